@@ -28,6 +28,9 @@ func newAdminCmd(cfg *config.Admin) *cobra.Command {
 
 	// admin
 	cmd.AddCommand(newAdminAppCmd(cfg))
+	cmd.AddCommand(newAdminBackupCmd(&cfg.AdminBackup))
+	cmd.AddCommand(newAdminRestoreCmd(&cfg.AdminRestore))
+	cmd.AddCommand(newAdminStorageCmd(cfg))
 
 	return cmd
 }
@@ -111,3 +114,108 @@ func newAdminAppDeleteCmd(cfg *config.AdminAppDelete) *cobra.Command {
 	cli.PopulateFlagSet(cfg, cmd.Flags(), vpr)
 	return cmd
 }
+
+// admin backup
+func newAdminBackupCmd(cfg *config.AdminBackup) *cobra.Command {
+	vpr := newViper()
+	cmd := &cobra.Command{
+		Use:   "backup [flags] [file]",
+		Short: "back up the storage of a running server",
+		Long:  "back up the storage of a running server to the file, or to stdout if the file is '-'",
+		Args:  cobra.ExactArgs(1),
+		RunE: cli.CreateCmdRunFn(cfg, vpr, func(_ *cobra.Command, arg []string) error {
+			cli, err := admin.NewCLI(cfg.SocketPath, cfg.Timeout)
+			if err != nil {
+				return err
+			}
+
+			return cli.Backup(arg[0])
+		}),
+	}
+
+	cli.PopulateFlagSet(cfg, cmd.Flags(), vpr)
+	return cmd
+}
+
+// admin restore
+func newAdminRestoreCmd(cfg *config.AdminRestore) *cobra.Command {
+	vpr := newViper()
+	cmd := &cobra.Command{
+		Use:   "restore [flags] [file]",
+		Short: "restore the storage from a backup",
+		Long:  "restore the storage from a backup. The server must be stopped, and the storage must be empty",
+		Args:  cobra.ExactArgs(1),
+		RunE: cli.CreateCmdRunFn(cfg, vpr, func(_ *cobra.Command, arg []string) error {
+			return admin.Restore(cfg.StoragePath, cfg.StorageEncryptionKeyFile, arg[0])
+		}),
+	}
+
+	cli.PopulateFlagSet(cfg, cmd.Flags(), vpr)
+	return cmd
+}
+
+// admin storage
+func newAdminStorageCmd(cfg *config.Admin) *cobra.Command {
+	vpr := newViper()
+
+	var cmd *cobra.Command
+	cmd = &cobra.Command{
+		Use:   "storage",
+		Short: "storage maintenance commands",
+		RunE: cli.CreateCmdRunFn(cfg, vpr, func(_ *cobra.Command, _ []string) error {
+			printUsageMessage(cmd)
+			return nil
+		}),
+	}
+
+	cmd.AddCommand(newAdminStorageVerifyCmd(&cfg.AdminStorageVerify))
+	cmd.AddCommand(newAdminStorageRotateKeyCmd(&cfg.AdminStorageRotateKey))
+
+	return cmd
+}
+
+// admin storage verify
+func newAdminStorageVerifyCmd(cfg *config.AdminStorageVerify) *cobra.Command {
+	vpr := newViper()
+	cmd := &cobra.Command{
+		Use:   "verify [flags]",
+		Short: "check the storage integrity of a running server",
+		Long: "check that all the segments, trees, and dictionaries referenced in the storage exist and can be decoded, " +
+			"and report corrupt and unreachable data. With --repair, corrupt and unreachable data, and dangling references are removed. " +
+			"Ingestion is suspended while the command runs",
+		Args: cobra.NoArgs,
+		RunE: cli.CreateCmdRunFn(cfg, vpr, func(_ *cobra.Command, _ []string) error {
+			cli, err := admin.NewCLI(cfg.SocketPath, cfg.Timeout)
+			if err != nil {
+				return err
+			}
+
+			return cli.VerifyStorage(cfg.Repair)
+		}),
+	}
+
+	cli.PopulateFlagSet(cfg, cmd.Flags(), vpr)
+	return cmd
+}
+
+// admin storage rotate-key
+func newAdminStorageRotateKeyCmd(cfg *config.AdminStorageRotateKey) *cobra.Command {
+	vpr := newViper()
+	cmd := &cobra.Command{
+		Use:   "rotate-key [flags]",
+		Short: "change the key the storage is encrypted with",
+		Long: "re-encrypt the storage with the key from --new-key-file. Without --old-key-file, an unencrypted storage is encrypted; " +
+			"without --new-key-file, the storage is decrypted. The server must be stopped. " +
+			"Data written before the storage was encrypted is only encrypted once compacted",
+		Args: cobra.NoArgs,
+		RunE: cli.CreateCmdRunFn(cfg, vpr, func(_ *cobra.Command, _ []string) error {
+			if cfg.OldKeyFile == "" && cfg.NewKeyFile == "" {
+				return fmt.Errorf("either --old-key-file or --new-key-file must be specified")
+			}
+			return admin.RotateEncryptionKey(cfg.StoragePath, cfg.OldKeyFile, cfg.NewKeyFile)
+		}),
+	}
+
+	cli.PopulateFlagSet(cfg, cmd.Flags(), vpr)
+	return cmd
+}
@@ -14,8 +14,10 @@ func newServerCmd(cfg *config.Server) *cobra.Command {
 		Short: "Start pyroscope server. This is the database + web-based user interface",
 
 		DisableFlagParsing: true,
-		RunE: cli.CreateCmdRunFn(cfg, vpr, func(_ *cobra.Command, _ []string) error {
-			srv, err := cli.NewServer(cfg)
+		RunE: cli.CreateCmdRunFn(cfg, vpr, func(cmd *cobra.Command, _ []string) error {
+			srv, err := cli.NewServer(cfg, func(c *config.Server) error {
+				return cli.LoadConfig(cmd, vpr, c)
+			})
 			if err != nil {
 				return err
 			}
@@ -32,7 +32,7 @@ func newPush(_ *config.Adhoc, args []string, st *storage.Storage, logger *logrus
 	}
 	return push{
 		args:    args,
-		handler: server.NewIngestHandler(logger, st, e, func(_ *storage.PutInput) {}),
+		handler: server.NewIngestHandler(logger, st, e, nil, nil, func(_ *storage.PutInput) {}),
 		logger:  logger,
 	}, nil
 }
@@ -89,6 +89,69 @@ func (c *CLI) DeleteApp(appname string, skipVerification bool) error {
 	return nil
 }
 
+// Backup writes the storage backup to the file, or to stdout if the
+// file name is "-". The file is removed if the backup fails.
+func (c *CLI) Backup(file string) error {
+	if file == "-" {
+		if err := c.client.Backup(os.Stdout); err != nil {
+			return CLIError{err}
+		}
+		return nil
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err = c.client.Backup(f); err == nil {
+		err = f.Close()
+	} else {
+		_ = f.Close()
+	}
+	if err != nil {
+		_ = os.Remove(file)
+		return CLIError{err}
+	}
+
+	fmt.Fprintf(os.Stderr, "Backup saved to '%s'.\n", file)
+	return nil
+}
+
+// VerifyStorage checks the storage integrity and prints the issues found.
+// It fails if there are issues left unrepaired.
+func (c *CLI) VerifyStorage(repair bool) error {
+	report, err := c.client.Verify(repair)
+	if err != nil {
+		return CLIError{err}
+	}
+
+	fmt.Printf("Checked %d dimensions, %d segments, %d trees.\n", report.Dimensions, report.Segments, report.Trees)
+	for _, x := range report.Issues {
+		if x.Error != "" {
+			fmt.Printf("%s\t%s\t%s\n", x.Kind, x.Key, x.Error)
+		} else {
+			fmt.Printf("%s\t%s\n", x.Kind, x.Key)
+		}
+	}
+	if n := report.IssuesTotal - len(report.Issues); n > 0 {
+		fmt.Printf("... and %d more\n", n)
+	}
+
+	switch {
+	case report.IssuesTotal == 0:
+		fmt.Println("No issues found.")
+	case repair:
+		fmt.Printf("Found %d issues, %d repaired.\n", report.IssuesTotal, report.Repaired)
+	default:
+		fmt.Printf("Found %d issues. Run with --repair to remove corrupt and unreachable data.\n", report.IssuesTotal)
+	}
+
+	if report.IssuesTotal > report.Repaired {
+		return fmt.Errorf("%d storage issues left unrepaired", report.IssuesTotal-report.Repaired)
+	}
+	return nil
+}
+
 // CompleteApp returns the list of apps
 // it's meant for cobra's autocompletion
 // TODO use the parameter for fuzzy search?
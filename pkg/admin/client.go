@@ -5,10 +5,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
 	"github.com/hashicorp/go-multierror"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
 )
 
 type Client struct {
@@ -17,7 +20,11 @@ type Client struct {
 
 // TODO since this is shared between client/server
 // maybe we could share it?
-const AppsEndpoint = "http://pyroscope/v1/apps"
+const (
+	AppsEndpoint   = "http://pyroscope/v1/apps"
+	BackupEndpoint = "http://pyroscope/v1/backup"
+	VerifyEndpoint = "http://pyroscope/v1/storage/verify"
+)
 
 var (
 	ErrHTTPClientCreation = errors.New("failed to create http over uds client")
@@ -88,6 +95,47 @@ func (c *Client) DeleteApp(name string) (err error) {
 	return nil
 }
 
+// Backup writes the storage backup to w.
+func (c *Client) Backup(w io.Writer) error {
+	resp, err := c.httpClient.Get(BackupEndpoint)
+	if err != nil {
+		return multierror.Append(ErrMakingRequest, err)
+	}
+	defer resp.Body.Close()
+
+	if err = checkStatusCodeOK(resp.StatusCode); err != nil {
+		return multierror.Append(ErrStatusCodeNotOK, err)
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Verify checks the storage integrity, repairing it if requested.
+func (c *Client) Verify(repair bool) (*storage.VerifyReport, error) {
+	marshalledPayload, err := json.Marshal(VerifyStorageInput{Repair: repair})
+	if err != nil {
+		return nil, multierror.Append(ErrMarshalingPayload, err)
+	}
+
+	resp, err := c.httpClient.Post(VerifyEndpoint, "application/json", bytes.NewBuffer(marshalledPayload))
+	if err != nil {
+		return nil, multierror.Append(ErrMakingRequest, err)
+	}
+	defer resp.Body.Close()
+
+	if err = checkStatusCodeOK(resp.StatusCode); err != nil {
+		return nil, multierror.Append(ErrStatusCodeNotOK, err)
+	}
+
+	var report storage.VerifyReport
+	if err = json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, multierror.Append(ErrDecodingResponse, err)
+	}
+
+	return &report, nil
+}
+
 func checkStatusCodeOK(statusCode int) error {
 	statusOK := statusCode >= 200 && statusCode < 300
 	if !statusOK {
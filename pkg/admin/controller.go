@@ -54,3 +54,36 @@ func (ctrl *Controller) HandleDeleteApp(w http.ResponseWriter, r *http.Request)
 
 	w.WriteHeader(200)
 }
+
+// HandleBackup streams the storage backup. Once the response is started,
+// errors can't be reported to the client: the stream is truncated then,
+// which makes the tar archive invalid.
+func (ctrl *Controller) HandleBackup(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/x-tar")
+	if err := ctrl.svc.Backup(w); err != nil {
+		ctrl.log.WithError(err).Error("backup failed")
+	}
+}
+
+type VerifyStorageInput struct {
+	Repair bool `json:"repair"`
+}
+
+// HandleVerifyStorage checks the storage integrity and responds with
+// the report. The request may take a while: ingestion is suspended
+// until the check is done.
+func (ctrl *Controller) HandleVerifyStorage(w http.ResponseWriter, r *http.Request) {
+	var payload VerifyStorageInput
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		ctrl.writeError(w, http.StatusBadRequest, err, "")
+		return
+	}
+
+	report, err := ctrl.svc.Verify(payload.Repair)
+	if err != nil {
+		ctrl.writeError(w, http.StatusInternalServerError, err, "storage verification failed")
+		return
+	}
+
+	ctrl.writeResponseJSON(w, report)
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/sirupsen/logrus/hooks/test"
 
 	"github.com/pyroscope-io/pyroscope/pkg/admin"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
 )
 
 type mockStorage struct {
@@ -29,6 +30,23 @@ func (m mockStorage) DeleteApp(appname string) error {
 	return m.deleteResult
 }
 
+func (m mockStorage) Backup(w io.Writer) error {
+	_, err := w.Write([]byte("backup"))
+	return err
+}
+
+func (m mockStorage) Verify(repair bool) (*storage.VerifyReport, error) {
+	r := storage.VerifyReport{
+		Segments:    1,
+		Issues:      []storage.VerifyIssue{{Kind: storage.IssueOrphanSegment, Key: "app.cpu{}"}},
+		IssuesTotal: 1,
+	}
+	if repair {
+		r.Repaired = 1
+	}
+	return &r, nil
+}
+
 var _ = Describe("controller", func() {
 	Describe("/v1/apps", func() {
 		var svr *admin.Server
@@ -135,4 +153,45 @@ var _ = Describe("controller", func() {
 			Entry("NON_VALID_METHOD", http.MethodPost),
 		)
 	})
+
+	Describe("/v1/backup", func() {
+		It("streams the backup", func() {
+			logger, _ := test.NewNullLogger()
+			svc := admin.NewService(mockStorage{})
+			ctrl := admin.NewController(logger, svc)
+			svr, err := admin.NewServer(logger, ctrl, &admin.UdsHTTPServer{})
+			Expect(err).ToNot(HaveOccurred())
+
+			response := httptest.NewRecorder()
+			request, err := http.NewRequest(http.MethodGet, "/v1/backup", nil)
+			Expect(err).ToNot(HaveOccurred())
+			svr.Handler.ServeHTTP(response, request)
+
+			Expect(response.Code).To(Equal(http.StatusOK))
+			Expect(response.Header().Get("Content-Type")).To(Equal("application/x-tar"))
+			Expect(response.Body.String()).To(Equal("backup"))
+		})
+	})
+
+	Describe("/v1/storage/verify", func() {
+		It("responds with the report", func() {
+			logger, _ := test.NewNullLogger()
+			svc := admin.NewService(mockStorage{})
+			ctrl := admin.NewController(logger, svc)
+			svr, err := admin.NewServer(logger, ctrl, &admin.UdsHTTPServer{})
+			Expect(err).ToNot(HaveOccurred())
+
+			response := httptest.NewRecorder()
+			request, err := http.NewRequest(http.MethodPost, "/v1/storage/verify", bytes.NewBufferString(`{"repair":true}`))
+			Expect(err).ToNot(HaveOccurred())
+			svr.Handler.ServeHTTP(response, request)
+
+			Expect(response.Code).To(Equal(http.StatusOK))
+			var report storage.VerifyReport
+			Expect(json.NewDecoder(response.Body).Decode(&report)).To(Succeed())
+			Expect(report.IssuesTotal).To(Equal(1))
+			Expect(report.Repaired).To(Equal(1))
+			Expect(report.Issues[0].Kind).To(Equal(storage.IssueOrphanSegment))
+		})
+	})
 })
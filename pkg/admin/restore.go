@@ -0,0 +1,64 @@
+package admin
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+)
+
+// Restore loads the backup file into the storage at the given path.
+// Unlike other admin commands, it does not talk to the server: the
+// databases can't be opened while the server is running.
+func Restore(storagePath, encryptionKeyFile, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	c := storage.NewConfig(&config.Server{StorageEncryptionKeyFile: encryptionKeyFile}).WithPath(storagePath)
+	s, err := storage.New(c, logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	if err = s.Restore(bufio.NewReader(f)); err != nil {
+		_ = s.Close()
+		return err
+	}
+	if err = s.Close(); err != nil {
+		return err
+	}
+
+	fmt.Println(fmt.Sprintf("Restored '%s' to '%s'.", file, storagePath))
+	return nil
+}
+
+// RotateEncryptionKey re-encrypts the storage at the given path with the
+// key from newKeyFile. Like Restore, it requires the server to be stopped.
+func RotateEncryptionKey(storagePath, oldKeyFile, newKeyFile string) error {
+	oldKey, err := storage.ReadEncryptionKey(oldKeyFile)
+	if err != nil {
+		return err
+	}
+	newKey, err := storage.ReadEncryptionKey(newKeyFile)
+	if err != nil {
+		return err
+	}
+	if err = storage.RotateEncryptionKey(storagePath, oldKey, newKey); err != nil {
+		return err
+	}
+
+	if newKeyFile == "" {
+		fmt.Println(fmt.Sprintf("Decrypted storage at '%s'.", storagePath))
+	} else {
+		fmt.Println(fmt.Sprintf("Encrypted storage at '%s' with the key from '%s'.", storagePath, newKeyFile))
+	}
+	return nil
+}
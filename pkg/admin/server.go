@@ -40,6 +40,8 @@ func NewServer(logger *logrus.Logger, ctrl *Controller, httpServer HTTPServer) (
 	// Routes
 	r.HandleFunc("/v1/apps", as.ctrl.HandleGetApps).Methods("GET")
 	r.HandleFunc("/v1/apps", as.ctrl.HandleDeleteApp).Methods("DELETE")
+	r.HandleFunc("/v1/backup", as.ctrl.HandleBackup).Methods("GET")
+	r.HandleFunc("/v1/storage/verify", as.ctrl.HandleVerifyStorage).Methods("POST")
 
 	// Global middlewares
 	r.Use(logginMiddleware)
@@ -1,5 +1,11 @@
 package admin
 
+import (
+	"io"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+)
+
 type AdminService struct {
 	storage Storage
 }
@@ -7,6 +13,8 @@ type AdminService struct {
 type Storage interface {
 	GetAppNames() []string
 	DeleteApp(appname string) error
+	Backup(w io.Writer) error
+	Verify(repair bool) (*storage.VerifyReport, error)
 }
 
 func NewService(v Storage) *AdminService {
@@ -24,3 +32,11 @@ func (m *AdminService) GetApps() (appNames []string) {
 func (m *AdminService) DeleteApp(appname string) error {
 	return m.storage.DeleteApp(appname)
 }
+
+func (m *AdminService) Backup(w io.Writer) error {
+	return m.storage.Backup(w)
+}
+
+func (m *AdminService) Verify(repair bool) (*storage.VerifyReport, error) {
+	return m.storage.Verify(repair)
+}
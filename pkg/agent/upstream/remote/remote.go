@@ -2,11 +2,15 @@ package remote
 
 import (
 	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
 	"runtime/debug"
 	"strconv"
@@ -16,12 +20,16 @@ import (
 
 	"github.com/pyroscope-io/pyroscope/pkg/agent"
 	"github.com/pyroscope-io/pyroscope/pkg/agent/upstream"
+	"github.com/pyroscope-io/pyroscope/pkg/util/signature"
 )
 
 var (
 	ErrCloudTokenRequired = errors.New("Please provide an authentication token. You can find it here: https://pyroscope.io/cloud")
 	ErrUpload             = errors.New("Failed to upload a profile")
 	cloudHostnameSuffix   = "pyroscope.cloud"
+
+	ErrClientCertRequiresKey = errors.New("both client certificate and key files must be specified")
+	ErrSigningKeyIDRequired  = errors.New("signing key ID must be specified along with the signing secret")
 )
 
 type Remote struct {
@@ -39,15 +47,38 @@ type RemoteConfig struct {
 	UpstreamThreads        int
 	UpstreamAddress        string
 	UpstreamRequestTimeout time.Duration
+	// Profiles larger than CompressionThreshold bytes are uploaded
+	// gzip-compressed. 0 disables compression.
+	CompressionThreshold int
+
+	// ServerCAFile is a PEM-encoded bundle of CA certificates used to
+	// verify the server certificate instead of the system pool.
+	ServerCAFile string
+	// ClientCertFile and ClientKeyFile are the PEM-encoded certificate and
+	// key presented to the server, if it requires client certificates.
+	ClientCertFile string
+	ClientKeyFile  string
+
+	// If SigningSecret is specified, uploads are signed with it.
+	SigningKeyID  string
+	SigningSecret string
 }
 
 func New(cfg RemoteConfig, logger agent.Logger) (*Remote, error) {
+	if cfg.SigningSecret != "" && cfg.SigningKeyID == "" {
+		return nil, ErrSigningKeyIDRequired
+	}
+	tlsConfig, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
 	remote := &Remote{
 		cfg:  cfg,
 		jobs: make(chan *upstream.UploadJob, 100),
 		client: &http.Client{
 			Transport: &http.Transport{
 				MaxConnsPerHost: cfg.UpstreamThreads,
+				TLSClientConfig: tlsConfig,
 			},
 			Timeout: cfg.UpstreamRequestTimeout,
 		},
@@ -69,6 +100,37 @@ func New(cfg RemoteConfig, logger agent.Logger) (*Remote, error) {
 	return remote, nil
 }
 
+// newTLSConfig returns the TLS configuration of the upstream client, if
+// a custom CA or a client certificate is configured. Otherwise, nil is
+// returned and the default configuration is used.
+func newTLSConfig(cfg RemoteConfig) (*tls.Config, error) {
+	if cfg.ServerCAFile == "" && cfg.ClientCertFile == "" && cfg.ClientKeyFile == "" {
+		return nil, nil
+	}
+	var tlsConfig tls.Config
+	if cfg.ServerCAFile != "" {
+		b, err := os.ReadFile(cfg.ServerCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read server CA file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in server CA file %s", cfg.ServerCAFile)
+		}
+	}
+	if cfg.ClientCertFile != "" || cfg.ClientKeyFile != "" {
+		if cfg.ClientCertFile == "" || cfg.ClientKeyFile == "" {
+			return nil, ErrClientCertRequiresKey
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.ClientCertFile, cfg.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return &tlsConfig, nil
+}
+
 func (r *Remote) Start() {
 	for i := 0; i < r.cfg.UpstreamThreads; i++ {
 		go r.handleJobs()
@@ -118,15 +180,28 @@ func (r *Remote) uploadProfile(j *upstream.UploadJob) error {
 
 	r.Logger.Debugf("uploading at %s", u.String())
 	// new a request for the job
-	request, err := http.NewRequest("POST", u.String(), bytes.NewReader(j.Trie.Bytes()))
+	body := j.Trie.Bytes()
+	compressed := r.cfg.CompressionThreshold > 0 && len(body) > r.cfg.CompressionThreshold
+	if compressed {
+		if body, err = compress(body); err != nil {
+			return fmt.Errorf("compress profile: %v", err)
+		}
+	}
+	request, err := http.NewRequest("POST", u.String(), bytes.NewReader(body))
 	if err != nil {
 		return fmt.Errorf("new http request: %v", err)
 	}
 	request.Header.Set("Content-Type", "binary/octet-stream+trie")
+	if compressed {
+		request.Header.Set("Content-Encoding", "gzip")
+	}
 
 	if r.cfg.AuthToken != "" {
 		request.Header.Set("Authorization", "Bearer "+r.cfg.AuthToken)
 	}
+	if r.cfg.SigningSecret != "" {
+		request.Header.Set(signature.Header, signature.Sign(r.cfg.SigningKeyID, []byte(r.cfg.SigningSecret), time.Now(), u.RawQuery, body))
+	}
 
 	// do the request and get the response
 	response, err := r.client.Do(request)
@@ -148,6 +223,21 @@ func (r *Remote) uploadProfile(j *upstream.UploadJob) error {
 	return nil
 }
 
+func compress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(b); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 // handle the jobs
 func (r *Remote) handleJobs() {
 	for {
@@ -1,11 +1,18 @@
 package remote
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
 	"html"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -14,6 +21,7 @@ import (
 	"github.com/pyroscope-io/pyroscope/pkg/agent/upstream"
 	"github.com/pyroscope-io/pyroscope/pkg/structs/transporttrie"
 	"github.com/pyroscope-io/pyroscope/pkg/testing"
+	"github.com/pyroscope-io/pyroscope/pkg/util/signature"
 	"github.com/sirupsen/logrus"
 )
 
@@ -74,4 +82,168 @@ var _ = Describe("remote.Remote", func() {
 			Eventually(done, 5).Should(BeClosed())
 		})
 	})
+
+	Describe("compression", func() {
+		upload := func(threshold int) (string, []byte) {
+			var received []byte
+			var receivedEncoding string
+			httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				receivedEncoding = r.Header.Get("Content-Encoding")
+				received, _ = io.ReadAll(r.Body)
+			}))
+			defer httpServer.Close()
+
+			r, err := New(RemoteConfig{
+				UpstreamThreads:        1,
+				UpstreamAddress:        httpServer.URL,
+				UpstreamRequestTimeout: 3 * time.Second,
+				CompressionThreshold:   threshold,
+			}, logrus.New())
+			Expect(err).ToNot(HaveOccurred())
+
+			t := transporttrie.New()
+			t.Insert([]byte("foo;bar"), 1)
+			Expect(r.UploadSync(&upstream.UploadJob{
+				Name:      "test{}",
+				StartTime: testing.SimpleTime(0),
+				EndTime:   testing.SimpleTime(10),
+				Trie:      t,
+			})).To(Succeed())
+			return receivedEncoding, received
+		}
+
+		It("compresses profiles above the threshold", func() {
+			encoding, body := upload(1)
+			Expect(encoding).To(Equal("gzip"))
+			g, err := gzip.NewReader(bytes.NewReader(body))
+			Expect(err).ToNot(HaveOccurred())
+			b, err := io.ReadAll(g)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b).ToNot(BeEmpty())
+		})
+
+		It("does not compress small profiles", func() {
+			encoding, _ := upload(1 << 20)
+			Expect(encoding).To(BeEmpty())
+		})
+
+		It("does not compress if disabled", func() {
+			encoding, _ := upload(0)
+			Expect(encoding).To(BeEmpty())
+		})
+	})
+
+	Describe("TLS", func() {
+		const (
+			clientCertFile = "../../../server/testdata/cert.pem"
+			clientKeyFile  = "../../../server/testdata/key.pem"
+		)
+
+		var (
+			httpServer *httptest.Server
+			tmpDir     *testing.TmpDirectory
+			caFile     string
+		)
+
+		BeforeEach(func() {
+			httpServer = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			b, err := os.ReadFile(clientCertFile)
+			Expect(err).ToNot(HaveOccurred())
+			clientCAs := x509.NewCertPool()
+			Expect(clientCAs.AppendCertsFromPEM(b)).To(BeTrue())
+			httpServer.TLS = &tls.Config{
+				ClientAuth: tls.RequireAndVerifyClientCert,
+				ClientCAs:  clientCAs,
+			}
+			httpServer.StartTLS()
+
+			tmpDir = testing.TmpDirSync()
+			caFile = filepath.Join(tmpDir.Path, "ca.pem")
+			ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: httpServer.Certificate().Raw})
+			Expect(os.WriteFile(caFile, ca, 0600)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			httpServer.Close()
+			tmpDir.Close()
+		})
+
+		upload := func(cfg RemoteConfig) error {
+			cfg.UpstreamThreads = 1
+			cfg.UpstreamAddress = httpServer.URL
+			cfg.UpstreamRequestTimeout = 3 * time.Second
+			r, err := New(cfg, logrus.New())
+			Expect(err).ToNot(HaveOccurred())
+			return r.UploadSync(&upstream.UploadJob{
+				Name:      "test{}",
+				StartTime: testing.SimpleTime(0),
+				EndTime:   testing.SimpleTime(10),
+				Trie:      transporttrie.New(),
+			})
+		}
+
+		It("presents the client certificate to the server", func() {
+			Expect(upload(RemoteConfig{
+				ServerCAFile:   caFile,
+				ClientCertFile: clientCertFile,
+				ClientKeyFile:  clientKeyFile,
+			})).To(Succeed())
+		})
+
+		It("fails if the client certificate is not configured", func() {
+			Expect(upload(RemoteConfig{ServerCAFile: caFile})).ToNot(Succeed())
+		})
+
+		It("fails if the server certificate is not trusted", func() {
+			Expect(upload(RemoteConfig{
+				ClientCertFile: clientCertFile,
+				ClientKeyFile:  clientKeyFile,
+			})).ToNot(Succeed())
+		})
+
+		It("requires both client certificate and key", func() {
+			_, err := New(RemoteConfig{ClientCertFile: clientCertFile}, logrus.New())
+			Expect(err).To(MatchError(ErrClientCertRequiresKey))
+		})
+	})
+	Describe("signing", func() {
+		It("signs requests with the shared secret", func() {
+			var verifyErr error
+			httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				s, err := signature.Parse(r.Header.Get(signature.Header))
+				if err != nil {
+					verifyErr = err
+					return
+				}
+				Expect(s.KeyID).To(Equal("agent"))
+				verifyErr = s.Verify([]byte("secret"), r.URL.RawQuery, body, time.Now(), time.Minute)
+			}))
+			defer httpServer.Close()
+
+			r, err := New(RemoteConfig{
+				UpstreamThreads:        1,
+				UpstreamAddress:        httpServer.URL,
+				UpstreamRequestTimeout: 3 * time.Second,
+				SigningKeyID:           "agent",
+				SigningSecret:          "secret",
+			}, logrus.New())
+			Expect(err).ToNot(HaveOccurred())
+
+			t := transporttrie.New()
+			t.Insert([]byte("foo;bar"), 1)
+			Expect(r.UploadSync(&upstream.UploadJob{
+				Name:      "test{}",
+				StartTime: testing.SimpleTime(0),
+				EndTime:   testing.SimpleTime(10),
+				Trie:      t,
+			})).To(Succeed())
+			Expect(verifyErr).ToNot(HaveOccurred())
+		})
+
+		It("requires the signing key ID", func() {
+			_, err := New(RemoteConfig{SigningSecret: "secret"}, logrus.New())
+			Expect(err).To(MatchError(ErrSigningKeyIDRequired))
+		})
+	})
 })
@@ -11,20 +11,33 @@ You can disable this with a flag or an environment variable
 	pyroscope server -analytics-opt-out
 	...
 	PYROSCOPE_ANALYTICS_OPT_OUT=true pyroscope server
+	...
+	DO_NOT_TRACK=1 pyroscope server
 
 */
 package analytics
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	neturl "net/url"
+	"os"
 	"reflect"
 	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 
 	"github.com/pyroscope-io/pyroscope/pkg/build"
@@ -37,6 +50,24 @@ var (
 	gracePeriod       = 5 * time.Minute
 	uploadFrequency   = 24 * time.Hour
 	snapshotFrequency = 10 * time.Minute
+
+	storageReadyTimeout       = time.Minute
+	storageReadyCheckInterval = time.Second
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	contentDigestHeader  = "Content-Digest"
+)
+
+// Shutdown reasons reported with the final report.
+const (
+	ShutdownClean   = "clean"
+	ShutdownSignal  = "signal"
+	ShutdownError   = "error"
+	ShutdownUnknown = "unknown"
+
+	reportTypeShutdown = "shutdown"
 )
 
 type Analytics struct {
@@ -52,11 +83,14 @@ type Analytics struct {
 	GOARCH               string    `json:"goarch"`
 	GoVersion            string    `json:"go_version"`
 	AnalyticsPersistence bool      `json:"analytics_persistence"`
+	EnabledFeatures      []string  `json:"enabled_features"`
+	ReportType           string    `json:"report_type,omitempty"`
+	ShutdownReason       string    `json:"shutdown_reason,omitempty"`
 
 	// gauges
-	MemAlloc         int `json:"mem_alloc"`
+	MemAlloc         int `json:"mem_alloc" kind:"gauge_max"`
 	MemTotalAlloc    int `json:"mem_total_alloc"`
-	MemSys           int `json:"mem_sys"`
+	MemSys           int `json:"mem_sys" kind:"gauge_max"`
 	MemNumGC         int `json:"mem_num_gc"`
 	BadgerMain       int `json:"badger_main"`
 	BadgerTrees      int `json:"badger_trees"`
@@ -65,19 +99,40 @@ type Analytics struct {
 	BadgerSegments   int `json:"badger_segments"`
 	AppsCount        int `json:"apps_count"`
 
+	// per-database disk usage details
+	BadgerDiskUsage map[string]storage.DiskUsage `json:"badger_disk_usage"`
+
 	// counters
 	ControllerIndex      int `json:"controller_index" kind:"cumulative"`
 	ControllerComparison int `json:"controller_comparison" kind:"cumulative"`
 	ControllerDiff       int `json:"controller_diff" kind:"cumulative"`
-	ControllerIngest     int `json:"controller_ingest" kind:"cumulative"`
+	ControllerIngest     int `json:"controller_ingest" kind:"cumulative" bucketize:"true"`
 	ControllerRender     int `json:"controller_render" kind:"cumulative"`
-	SpyRbspy             int `json:"spy_rbspy" kind:"cumulative"`
-	SpyPyspy             int `json:"spy_pyspy" kind:"cumulative"`
-	SpyGospy             int `json:"spy_gospy" kind:"cumulative"`
-	SpyEbpfspy           int `json:"spy_ebpfspy" kind:"cumulative"`
-	SpyPhpspy            int `json:"spy_phpspy" kind:"cumulative"`
-	SpyDotnetspy         int `json:"spy_dotnetspy" kind:"cumulative"`
-	SpyJavaspy           int `json:"spy_javaspy" kind:"cumulative"`
+	SpyRbspy             int `json:"spy_rbspy" kind:"cumulative" bucketize:"true"`
+	SpyPyspy             int `json:"spy_pyspy" kind:"cumulative" bucketize:"true"`
+	SpyGospy             int `json:"spy_gospy" kind:"cumulative" bucketize:"true"`
+	SpyEbpfspy           int `json:"spy_ebpfspy" kind:"cumulative" bucketize:"true"`
+	SpyPhpspy            int `json:"spy_phpspy" kind:"cumulative" bucketize:"true"`
+	SpyDotnetspy         int `json:"spy_dotnetspy" kind:"cumulative" bucketize:"true"`
+	SpyJavaspy           int `json:"spy_javaspy" kind:"cumulative" bucketize:"true"`
+}
+
+// experimentalFeatures maps names of experimental features to functions
+// reporting whether the feature is enabled in the server configuration.
+var experimentalFeatures = map[string]func(*config.Server) bool{
+	"admin": func(c *config.Server) bool { return c.EnableExperimentalAdmin },
+}
+
+// enabledFeatures returns sorted names of enabled experimental features.
+func enabledFeatures(c *config.Server) []string {
+	features := make([]string, 0, len(experimentalFeatures))
+	for name, enabled := range experimentalFeatures {
+		if enabled(c) {
+			features = append(features, name)
+		}
+	}
+	sort.Strings(features)
+	return features
 }
 
 type StatsProvider interface {
@@ -85,42 +140,189 @@ type StatsProvider interface {
 	AppsCount() int
 }
 
-func NewService(cfg *config.Server, s *storage.Storage, p StatsProvider) *Service {
-	return &Service{
-		cfg:  cfg,
-		s:    s,
-		p:    p,
-		base: &Analytics{},
+// noopStatsProvider is used if no StatsProvider is given to NewService:
+// controller, spy, and apps counters are reported as zeros.
+type noopStatsProvider struct{}
+
+func (noopStatsProvider) Stats() map[string]int { return nil }
+
+func (noopStatsProvider) AppsCount() int { return 0 }
+
+// Service collects and periodically uploads usage data.
+type Service interface {
+	Start()
+	Stop()
+
+	// StopWithReason sends the final report indicating why the server
+	// is shutting down, and stops the service. If reporting is paused,
+	// the final report is not sent.
+	StopWithReason(reason string)
+
+	// Pause suspends uploading reports until Resume is called.
+	// Usage data is still collected while reporting is paused.
+	Pause()
+	Resume()
+
+	// CurrentSnapshot returns a copy of the latest usage data snapshot,
+	// including values accumulated during previous runs.
+	CurrentSnapshot() *Analytics
+
+	// SetExtraFieldsProvider sets the function supplying additional
+	// values included into every report under the "extra" object.
+	SetExtraFieldsProvider(p ExtraFieldsProvider)
+
+	// EffectiveConfig returns the analytics settings resolved from
+	// the configuration options and environment variables.
+	EffectiveConfig() AnalyticsSettings
+
+	// RecentReports returns outcomes of the most recent report uploads,
+	// oldest first.
+	RecentReports() []ReportOutcome
+
+	// Replay uploads stored reports that failed to upload since the
+	// given time, and returns the number of reports sent.
+	Replay(ctx context.Context, since time.Time) (int, error)
+}
+
+// NewService creates a new analytics service. If analytics is disabled with
+// the opt-out option or DO_NOT_TRACK environment variable, a no-op service
+// is returned. p may be nil, in which case controller statistics are not
+// reported.
+func NewService(cfg *config.Server, s *storage.Storage, p StatsProvider, reg prometheus.Registerer, logger logrus.FieldLogger) (Service, error) {
+	if disabledReason(cfg) != "" {
+		return nullService{settings: effectiveSettings(cfg)}, nil
+	}
+	transport := &http.Transport{
+		MaxConnsPerHost: 1,
+	}
+	reportURL, err := resolveURL(cfg.AnalyticsURL, transport)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		p = noopStatsProvider{}
+	}
+	return &service{
+		cfg:      cfg,
+		s:        s,
+		p:        p,
+		logger:   logger,
+		base:     &Analytics{},
+		snapshot: &Analytics{},
+		url:      reportURL,
+		metrics:  newMetrics(reg),
+		recent:   newReportRing(recentReportsSize),
 		httpClient: &http.Client{
-			Transport: &http.Transport{
-				MaxConnsPerHost: 1,
-			},
-			Timeout: 60 * time.Second,
+			Transport: transport,
+			Timeout:   60 * time.Second,
 		},
-		stop: make(chan struct{}),
-		done: make(chan struct{}),
+		now:          time.Now,
+		installID:    s.InstallID,
+		storageReady: s.Ready,
+		readMemStats: runtime.ReadMemStats,
+		stop:         make(chan struct{}),
+		done:         make(chan struct{}),
+	}, nil
+}
+
+// disabledReason returns the reason analytics is turned off by the user,
+// or an empty string if it is enabled. DO_NOT_TRACK follows
+// https://consoledonottrack.com convention.
+func disabledReason(cfg *config.Server) string {
+	if cfg.AnalyticsOptOut {
+		return ReasonOptOut
 	}
+	v, ok := os.LookupEnv("DO_NOT_TRACK")
+	if !ok {
+		return ""
+	}
+	switch strings.ToLower(v) {
+	case "", "0", "false":
+		return ""
+	}
+	return ReasonDoNotTrack
 }
 
-type Service struct {
+// resolveURL returns the URL reports are posted to. If rawURL has unix
+// scheme, the transport is configured to dial the socket at the URL path,
+// and the HTTP request path is the one of the default analytics URL.
+func resolveURL(rawURL string, t *http.Transport) (string, error) {
+	if rawURL == "" {
+		rawURL = url
+	}
+	u, err := neturl.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid analytics URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return rawURL, nil
+	case "unix":
+		socketPath := u.Host + u.Path
+		if socketPath == "" {
+			return "", fmt.Errorf("invalid analytics URL %q: socket path is empty", rawURL)
+		}
+		d, err := neturl.Parse(url)
+		if err != nil {
+			return "", fmt.Errorf("invalid default analytics URL: %w", err)
+		}
+		t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socketPath)
+		}
+		return (&neturl.URL{Scheme: "http", Host: "analytics", Path: d.Path}).String(), nil
+	default:
+		return "", fmt.Errorf("invalid analytics URL %q: unsupported scheme %q", rawURL, u.Scheme)
+	}
+}
+
+type service struct {
 	cfg        *config.Server
 	s          *storage.Storage
 	p          StatsProvider
-	base       *Analytics
+	logger     logrus.FieldLogger
+	url        string
 	httpClient *http.Client
-	uploads    int
+	metrics    *metrics
+	paused     int32
+	now        func() time.Time
+
+	installID    func() string
+	storageReady func() bool
+
+	// readMemStats is called at most once per AnalyticsMemStatsInterval,
+	// the statistics are reused by snapshots taken in between.
+	readMemStats  func(*runtime.MemStats)
+	memStatsMutex sync.Mutex
+	memStats      runtime.MemStats
+	memStatsTime  time.Time
+
+	// mutex guards base, uploads, snapshot, and extra which are accessed
+	// by the service goroutine and by callers of the exported methods.
+	mutex    sync.Mutex
+	base     *Analytics
+	uploads  int
+	snapshot *Analytics
+	extra    ExtraFieldsProvider
+
+	recent *reportRing
 
 	stop chan struct{}
 	done chan struct{}
 }
 
-func (s *Service) Start() {
+func (s *service) Start() {
 	defer close(s.done)
-	err := s.s.LoadAnalytics(s.base)
+	base := new(Analytics)
+	err := s.s.LoadAnalytics(base)
 	if err != nil {
 		// this is not really an error, this will always be !nil on the first run, hence Debug level
-		logrus.WithError(err).Debug("failed to load analytics data")
+		s.logger.WithError(err).Debug("failed to load analytics data")
 	}
+	s.mutex.Lock()
+	s.base = base
+	s.mutex.Unlock()
+	s.setSnapshot(base)
 
 	timer := time.NewTimer(gracePeriod)
 	select {
@@ -128,75 +330,208 @@ func (s *Service) Start() {
 		return
 	case <-timer.C:
 	}
-	s.sendReport()
-	upload := time.NewTicker(uploadFrequency)
+	if !s.waitForStorage() {
+		return
+	}
+	// The first report is sent right away, unless the previous
+	// run has scheduled the next one: the schedule is resumed then.
+	if _, ok := s.scheduledUploadTime(); !ok {
+		s.upload()
+	}
+	upload := time.NewTimer(s.nextUploadTime().Sub(s.now()))
 	snapshot := time.NewTicker(snapshotFrequency)
 	defer upload.Stop()
 	defer snapshot.Stop()
 	for {
 		select {
 		case <-upload.C:
-			s.sendReport()
+			s.upload()
+			upload.Reset(s.nextUploadTime().Sub(s.now()))
 		case <-snapshot.C:
-			s.s.SaveAnalytics(s.getAnalytics())
+			s.s.SaveAnalytics(s.takeSnapshot())
 		case <-s.stop:
 			return
 		}
 	}
 }
 
+// waitForStorage blocks until the storage is able to provide a valid install
+// ID, so that the first report is not sent with a placeholder value. If the
+// storage is not ready within the timeout, the report is sent anyway.
+// It returns false if the service is stopped while waiting.
+func (s *service) waitForStorage() bool {
+	if s.storageReady() {
+		return true
+	}
+	timeout := time.NewTimer(storageReadyTimeout)
+	defer timeout.Stop()
+	ticker := time.NewTicker(storageReadyCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return false
+		case <-timeout.C:
+			s.logger.Warn("storage is not ready, sending analytics report anyway")
+			return true
+		case <-ticker.C:
+			if s.storageReady() {
+				return true
+			}
+		}
+	}
+}
+
 // TODO: reflection is always tricky to work with. Maybe long term we should just put all counters
 //   in one map (map[string]int), and put all gauges in another map(map[string]int) and then
 //   for gauges we would override old values and for counters we would sum the values up.
-func (*Service) rebaseAnalytics(base *Analytics, current *Analytics) *Analytics {
-	rebased := &(*current)
-	vRebased := reflect.ValueOf(rebased).Elem()
-	vCur := reflect.ValueOf(*current)
-	vBase := reflect.ValueOf(*base)
-	tAnalytics := reflect.TypeOf(*base)
-	for i := 0; i < vBase.NumField(); i++ {
-		name := tAnalytics.Field(i).Name
-		tField := tAnalytics.Field(i).Type
-		vBaseField := vBase.FieldByName(name)
-		vCurrentField := vCur.FieldByName(name)
-		vRebasedField := vRebased.FieldByName(name)
-		tag, ok := tAnalytics.Field(i).Tag.Lookup("kind")
-		if ok && tag == "cumulative" {
-			switch tField.Kind() {
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				vRebasedField.SetInt(vBaseField.Int() + vCurrentField.Int())
+func (*service) rebaseAnalytics(base *Analytics, current *Analytics) *Analytics {
+	rebased := *current
+	rebaseFields(reflect.ValueOf(&rebased).Elem(), reflect.ValueOf(*base), analyticsFields())
+	return &rebased
+}
+
+// rebaseFields merges struct fields of base into rebased according to
+// their aggregation kind. Zero gauge_max and gauge_min values of base
+// are considered unset.
+func rebaseFields(rebased, base reflect.Value, fields []field) {
+	for _, f := range fields {
+		if !f.numeric {
+			continue
+		}
+		vBase := base.Field(f.index).Int()
+		vRebased := rebased.Field(f.index)
+		switch f.kind {
+		case "cumulative":
+			vRebased.SetInt(vBase + vRebased.Int())
+		case "gauge_max":
+			if vBase > vRebased.Int() {
+				vRebased.SetInt(vBase)
+			}
+		case "gauge_min":
+			if vBase != 0 && vBase < vRebased.Int() {
+				vRebased.SetInt(vBase)
 			}
 		}
 	}
-	return rebased
 }
 
-func (s *Service) Stop() {
-	s.s.SaveAnalytics(s.getAnalytics())
+// copyGauges copies values of gauge_max and gauge_min fields of src to dst.
+func copyGauges(dst, src reflect.Value, fields []field) {
+	for _, f := range fields {
+		if f.numeric && isAggregatedGauge(f.kind) {
+			dst.Field(f.index).SetInt(src.Field(f.index).Int())
+		}
+	}
+}
+
+// resetGauges sets gauge_max and gauge_min fields to zero (unset).
+func resetGauges(v reflect.Value, fields []field) {
+	for _, f := range fields {
+		if f.numeric && isAggregatedGauge(f.kind) {
+			v.Field(f.index).SetInt(0)
+		}
+	}
+}
+
+func isAggregatedGauge(kind string) bool {
+	return kind == "gauge_max" || kind == "gauge_min"
+}
+
+func (s *service) Pause() {
+	if atomic.CompareAndSwapInt32(&s.paused, 0, 1) {
+		s.logger.Info("analytics reporting paused")
+	}
+}
+
+func (s *service) Resume() {
+	if atomic.CompareAndSwapInt32(&s.paused, 1, 0) {
+		s.logger.Info("analytics reporting resumed")
+	}
+}
+
+func (s *service) upload() {
+	if atomic.LoadInt32(&s.paused) == 1 {
+		s.logger.Debug("analytics reporting is paused, skipping upload")
+		return
+	}
+	s.sendReport()
+}
+
+func (s *service) Stop() {
+	s.s.SaveAnalytics(s.takeSnapshot())
 	close(s.stop)
 	<-s.done
 }
 
-func (s *Service) getAnalytics() *Analytics {
-	var ms runtime.MemStats
-	runtime.ReadMemStats(&ms)
+func (s *service) StopWithReason(reason string) {
+	if reason == "" {
+		reason = ShutdownUnknown
+	}
+	if atomic.LoadInt32(&s.paused) == 0 {
+		a := s.takeSnapshot()
+		a.ReportType = reportTypeShutdown
+		a.ShutdownReason = reason
+		s.send(a)
+	}
+	s.Stop()
+}
+
+func (s *service) SetExtraFieldsProvider(p ExtraFieldsProvider) {
+	s.mutex.Lock()
+	s.extra = p
+	s.mutex.Unlock()
+}
+
+func (s *service) EffectiveConfig() AnalyticsSettings { return effectiveSettings(s.cfg) }
+
+func (s *service) RecentReports() []ReportOutcome { return s.recent.items() }
+
+func (s *service) CurrentSnapshot() *Analytics {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	a := *s.snapshot
+	return &a
+}
+
+// takeSnapshot collects usage data and stores it as the current snapshot.
+func (s *service) takeSnapshot() *Analytics {
+	a := s.getAnalytics()
+	s.setSnapshot(a)
+	return a
+}
+
+func (s *service) setSnapshot(a *Analytics) {
+	c := *a
+	s.mutex.Lock()
+	s.snapshot = &c
+	s.mutex.Unlock()
+}
+
+func (s *service) getAnalytics() *Analytics {
+	ms := s.readMemStatsCached()
 	du := s.s.DiskUsage()
 
 	controllerStats := s.p.Stats()
 
+	s.mutex.Lock()
+	uploads := s.uploads
+	s.mutex.Unlock()
+
 	a := &Analytics{
 		// metadata
-		InstallID:            s.s.InstallID(),
+		InstallID:            s.installID(),
 		RunID:                uuid.New().String(),
 		Version:              build.Version,
 		GitSHA:               build.GitSHA,
 		BuildTime:            build.Time,
 		Timestamp:            time.Now(),
-		UploadIndex:          s.uploads,
+		UploadIndex:          uploads,
 		GOOS:                 runtime.GOOS,
 		GOARCH:               runtime.GOARCH,
 		GoVersion:            runtime.Version(),
 		AnalyticsPersistence: true,
+		EnabledFeatures:      enabledFeatures(s.cfg),
 
 		// gauges
 		MemAlloc:         int(ms.Alloc),
@@ -208,6 +543,7 @@ func (s *Service) getAnalytics() *Analytics {
 		BadgerDicts:      int(du["dicts"]),
 		BadgerDimensions: int(du["dimensions"]),
 		BadgerSegments:   int(du["segments"]),
+		BadgerDiskUsage:  s.s.DiskUsageDetailed(),
 		AppsCount:        s.p.AppsCount(),
 
 		// counters
@@ -224,31 +560,163 @@ func (s *Service) getAnalytics() *Analytics {
 		SpyDotnetspy:         controllerStats["ingest:dotnetspy"],
 		SpyJavaspy:           controllerStats["ingest:javaspy"],
 	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	a = s.rebaseAnalytics(s.base, a)
+	// Gauge fields of the base hold the values of the current report
+	// interval, they are reset once the report is sent.
+	copyGauges(reflect.ValueOf(s.base).Elem(), reflect.ValueOf(*a), analyticsFields())
 	return a
 }
 
-func (s *Service) sendReport() {
-	logrus.Debug("sending analytics report")
+// readMemStatsCached returns memory statistics. runtime.ReadMemStats stops
+// the world, therefore the statistics are read at most once per configured
+// interval.
+func (s *service) readMemStatsCached() runtime.MemStats {
+	s.memStatsMutex.Lock()
+	defer s.memStatsMutex.Unlock()
+	now := s.now()
+	if !s.memStatsTime.IsZero() && now.Sub(s.memStatsTime) < s.cfg.AnalyticsMemStatsInterval {
+		s.logger.Debug("reusing cached memory statistics")
+		return s.memStats
+	}
+	s.readMemStats(&s.memStats)
+	s.memStatsTime = now
+	return s.memStats
+}
 
-	a := s.getAnalytics()
+func (s *service) sendReport() {
+	s.logger.Debug("sending analytics report")
+	s.send(s.takeSnapshot())
+}
 
-	buf, err := json.Marshal(a)
+func (s *service) send(a *Analytics) {
+	buf, err := s.marshalReport(a)
 	if err != nil {
-		logrus.WithField("err", err).Error("Error happened when preparing JSON")
+		s.logger.WithField("err", err).Error("Error happened when preparing JSON")
 		return
 	}
-	resp, err := s.httpClient.Post(url, "application/json", bytes.NewReader(buf))
+	if err = s.post(context.Background(), buf, a.Timestamp); err != nil {
+		// the report is kept so that it can be sent later with Replay.
+		if err = s.s.SaveAnalyticsHistory(a.Timestamp, buf); err != nil {
+			s.logger.WithError(err).Warn("failed to save analytics report history")
+		}
+	}
+
+	s.mutex.Lock()
+	s.uploads++
+	resetGauges(reflect.ValueOf(s.base).Elem(), analyticsFields())
+	s.mutex.Unlock()
+}
+
+// Replay uploads reports created since the given time that failed to upload.
+// Replayed reports are removed from the history. It returns the number of
+// reports sent.
+func (s *service) Replay(ctx context.Context, since time.Time) (int, error) {
+	entries, err := s.s.ReadAnalyticsHistory(since)
 	if err != nil {
-		logrus.WithField("err", err).Error("Error happened when uploading anonymized usage data")
+		return 0, err
 	}
-	if resp != nil {
-		_, err := io.ReadAll(resp.Body)
-		if err != nil {
-			logrus.WithField("err", err).Error("Error happened when uploading reading server response")
-			return
+	var n int
+	for _, e := range entries {
+		if err = s.post(ctx, e.Report, e.Timestamp); err != nil {
+			return n, err
 		}
+		if err = s.s.DeleteAnalyticsHistory(e.Timestamp); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// post uploads the report payload created at t. Each request carries an
+// idempotency key derived from the install ID and the report creation time,
+// which allows the receiving side to discard duplicates.
+func (s *service) post(ctx context.Context, buf []byte, t time.Time) error {
+	start := time.Now()
+	status, category, err := s.doPost(ctx, buf, t)
+	o := ReportOutcome{
+		Timestamp: start,
+		Status:    status,
+		Category:  category,
+		Latency:   time.Since(start),
+	}
+	if err != nil {
+		o.Error = err.Error()
+	}
+	s.recent.add(o)
+	if category != "" {
+		s.uploadFailed(category, err)
 	}
+	return err
+}
 
-	s.uploads++
+func (s *service) doPost(ctx context.Context, buf []byte, t time.Time) (status int, category string, err error) {
+	if s.cfg.AnalyticsTrace {
+		ctx = withClientTrace(ctx, s.logger)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(buf))
+	if err != nil {
+		return 0, errCategoryOther, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(idempotencyKeyHeader, s.idempotencyKey(t))
+	req.Header.Set(contentDigestHeader, contentDigest(buf))
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return 0, classifyError(err), err
+	}
+	defer resp.Body.Close()
+	if _, err = io.ReadAll(resp.Body); err != nil {
+		s.logger.WithField("err", err).Error("Error happened when uploading reading server response")
+		return resp.StatusCode, "", err
+	}
+	if c := classifyStatusCode(resp.StatusCode); c != "" {
+		return resp.StatusCode, c, fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return resp.StatusCode, "", nil
+}
+
+// contentDigest returns SHA-256 digest of the request body in RFC 9530 format.
+func contentDigest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+func (s *service) idempotencyKey(t time.Time) string {
+	return fmt.Sprintf("%s-%d", s.installID(), t.UnixNano())
+}
+
+// marshalReport prepares the report payload. Values of the stored usage
+// data remain exact regardless of the reporting mode.
+func (s *service) marshalReport(a *Analytics) ([]byte, error) {
+	var (
+		b   []byte
+		err error
+	)
+	if s.cfg.AnalyticsBucketize {
+		b, err = marshalBucketized(a)
+	} else {
+		b, err = a.Marshal()
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	extra := s.extra
+	s.mutex.Unlock()
+	if extra != nil {
+		if b, err = withExtraFields(b, extra(), s.logger); err != nil {
+			return nil, err
+		}
+	}
+	return truncatePayload(b, s.cfg.AnalyticsMaxPayloadSize.Bytes(), s.logger)
+}
+
+func (s *service) uploadFailed(category string, err error) {
+	s.metrics.uploadFailures.WithLabelValues(category).Inc()
+	s.logger.WithError(err).
+		WithField("category", category).
+		Error("Error happened when uploading anonymized usage data")
 }
@@ -4,13 +4,23 @@
 package analytics
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"html"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	. "github.com/onsi/ginkgo"
@@ -18,6 +28,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/pyroscope-io/pyroscope/pkg/health"
 	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 
 	"github.com/pyroscope-io/pyroscope/pkg/config"
 	"github.com/pyroscope-io/pyroscope/pkg/storage"
@@ -39,6 +50,51 @@ func (m *mockStatsProvider) Stats() map[string]int {
 
 func (*mockStatsProvider) AppsCount() int { return 0 }
 
+// testFixture holds the storage of the server configuration and the server
+// receiving reports of the services created with newService.
+type testFixture struct {
+	config  *config.Server
+	storage *storage.Storage
+	server  *httptest.Server
+}
+
+// newTestFixture opens the storage of the server configuration. Unless the
+// handler is nil, reports are uploaded to a server with the handler.
+func newTestFixture(c *config.Server, h http.HandlerFunc) *testFixture {
+	s, err := storage.New(storage.NewConfig(c), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+	Expect(err).ToNot(HaveOccurred())
+	f := &testFixture{config: c, storage: s}
+	if h != nil {
+		f.server = httptest.NewServer(h)
+		url = f.server.URL + "/api/events"
+	}
+	return f
+}
+
+func (f *testFixture) newService(p StatsProvider) *service {
+	svc, err := NewService(f.config, f.storage, p, prometheus.NewRegistry(), logrus.StandardLogger())
+	Expect(err).ToNot(HaveOccurred())
+	return svc.(*service)
+}
+
+func (f *testFixture) Close() {
+	if f.server != nil {
+		f.server.Close()
+	}
+	f.storage.Close()
+}
+
+// runWithTimeout fails the spec if the body does not complete in 2 seconds.
+func runWithTimeout(body func()) {
+	done := make(chan interface{})
+	go func() {
+		defer GinkgoRecover()
+		body()
+		close(done)
+	}()
+	Eventually(done, 2).Should(BeClosed())
+}
+
 var _ = Describe("analytics", func() {
 	gracePeriod = 100 * time.Millisecond
 	uploadFrequency = 200 * time.Millisecond
@@ -47,14 +103,11 @@ var _ = Describe("analytics", func() {
 	testing.WithConfig(func(cfg **config.Config) {
 		Describe("NewService", func() {
 			It("works as expected", func() {
-				done := make(chan interface{})
-				go func() {
-					defer GinkgoRecover()
-
+				runWithTimeout(func() {
 					wg := sync.WaitGroup{}
 					wg.Add(3)
 					timestamps := []time.Time{}
-					myHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					f := newTestFixture(&(*cfg).Server, func(w http.ResponseWriter, r *http.Request) {
 						timestamps = append(timestamps, time.Now())
 						bytes, err := io.ReadAll(r.Body)
 						Expect(err).ToNot(HaveOccurred())
@@ -66,17 +119,18 @@ var _ = Describe("analytics", func() {
 						fmt.Fprintf(w, "Hello, %q", html.EscapeString(r.URL.Path))
 						wg.Done()
 					})
+					defer f.Close()
 
-					httpServer := httptest.NewServer(myHandler)
-					defer httpServer.Close()
-					url = httpServer.URL + "/api/events"
-
-					s, err := storage.New(storage.NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
-					Expect(err).ToNot(HaveOccurred())
-
-					analytics := NewService(&(*cfg).Server, s, &mockStatsProvider{})
+					analytics := f.newService(&mockStatsProvider{})
 
 					startTime := time.Now()
+					// Reports following the first one are aligned to the install
+					// offset: the clock is shifted so that the offset matches the
+					// end of the grace period.
+					first := startTime.Add(gracePeriod)
+					shift := nextUpload(f.storage.InstallID(), first, uploadFrequency).Sub(first)
+					analytics.now = func() time.Time { return time.Now().Add(shift) }
+
 					go analytics.Start()
 					wg.Wait()
 					analytics.Stop()
@@ -85,18 +139,13 @@ var _ = Describe("analytics", func() {
 						BeTemporally("~", startTime.Add(300*time.Millisecond), durThreshold),
 						BeTemporally("~", startTime.Add(500*time.Millisecond), durThreshold),
 					))
-					close(done)
-				}()
-				Eventually(done, 2).Should(BeClosed())
+				})
 			})
 			It("cumilative metrics should persist on service stop", func() {
-				done := make(chan interface{})
-				go func() {
-					defer GinkgoRecover()
-
+				runWithTimeout(func() {
 					wg := sync.WaitGroup{}
 					v := make(map[string]interface{})
-					myHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					f := newTestFixture(&(*cfg).Server, func(w http.ResponseWriter, r *http.Request) {
 						bytes, err := io.ReadAll(r.Body)
 						Expect(err).ToNot(HaveOccurred())
 						err = json.Unmarshal(bytes, &v)
@@ -104,13 +153,7 @@ var _ = Describe("analytics", func() {
 						w.WriteHeader(http.StatusOK)
 						wg.Done()
 					})
-
-					httpServer := httptest.NewServer(myHandler)
-					defer httpServer.Close()
-					url = httpServer.URL + "/api/events"
-
-					s, err := storage.New(storage.NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
-					Expect(err).ToNot(HaveOccurred())
+					defer f.Close()
 
 					stats := map[string]int{
 						"diff":       1,
@@ -122,7 +165,7 @@ var _ = Describe("analytics", func() {
 
 					for i := 0; i < 2; i = i + 1 {
 						wg.Add(1)
-						analytics := NewService(&(*cfg).Server, s, &mockProvider)
+						analytics := f.newService(&mockProvider)
 						go analytics.Start()
 						wg.Wait()
 						analytics.Stop()
@@ -132,11 +175,543 @@ var _ = Describe("analytics", func() {
 					Expect(v["controller_ingest"]).To(BeEquivalentTo(2))
 					Expect(v["controller_comparison"]).To(BeEquivalentTo(2))
 					Expect(v["analytics_persistence"]).To(BeTrue())
+				})
+			})
+			It("sends reports over unix domain socket", func() {
+				runWithTimeout(func() {
+					wg := sync.WaitGroup{}
+					wg.Add(1)
+					var path string
+					myHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+						path = r.URL.Path
+						bytes, err := io.ReadAll(r.Body)
+						Expect(err).ToNot(HaveOccurred())
+						v := make(map[string]interface{})
+						Expect(json.Unmarshal(bytes, &v)).To(Succeed())
+						w.WriteHeader(http.StatusOK)
+						wg.Done()
+					})
+
+					socketPath := filepath.Join((*cfg).Server.StoragePath, "analytics.sock")
+					listener, err := net.Listen("unix", socketPath)
+					Expect(err).ToNot(HaveOccurred())
+					httpServer := &http.Server{Handler: myHandler}
+					go httpServer.Serve(listener)
+					defer httpServer.Close()
+					url = "http://localhost/api/events"
+
+					f := newTestFixture(&(*cfg).Server, nil)
+					defer f.Close()
+
+					(*cfg).Server.AnalyticsURL = "unix://" + socketPath
+					analytics := f.newService(&mockStatsProvider{})
+
+					go analytics.Start()
+					wg.Wait()
+					analytics.Stop()
+					Expect(path).To(Equal("/api/events"))
+				})
+			})
+			It("does not upload reports while paused", func() {
+				runWithTimeout(func() {
+					var uploads int32
+					f := newTestFixture(&(*cfg).Server, func(w http.ResponseWriter, r *http.Request) {
+						atomic.AddInt32(&uploads, 1)
+						w.WriteHeader(http.StatusOK)
+					})
+					defer f.Close()
+
+					analytics := f.newService(&mockStatsProvider{})
+
+					analytics.Pause()
+					go analytics.Start()
+					// Grace period and two upload intervals.
+					time.Sleep(500 * time.Millisecond)
+					Expect(atomic.LoadInt32(&uploads)).To(BeZero())
+
+					analytics.Resume()
+					Eventually(func() int32 {
+						return atomic.LoadInt32(&uploads)
+					}, 500*time.Millisecond).Should(BeNumerically(">=", 1))
+					analytics.Stop()
+				})
+			})
+			It("exposes accumulated usage data snapshot", func() {
+				runWithTimeout(func() {
+					f := newTestFixture(&(*cfg).Server, func(w http.ResponseWriter, r *http.Request) {
+						w.WriteHeader(http.StatusOK)
+					})
+					defer f.Close()
+					Expect(f.storage.SaveAnalytics(&Analytics{ControllerIngest: 3, ControllerRender: 1})).To(Succeed())
+
+					analytics := f.newService(&mockStatsProvider{stats: map[string]int{"ingest": 5}})
+
+					go analytics.Start()
+					// The grace period and at least one snapshot interval.
+					Eventually(func() int {
+						return analytics.CurrentSnapshot().ControllerIngest
+					}, time.Second, 10*time.Millisecond).Should(Equal(8))
+					Expect(analytics.CurrentSnapshot().ControllerRender).To(Equal(1))
+					analytics.Stop()
+				})
+			})
+			It("is safe for concurrent use", func() {
+				runWithTimeout(func() {
+					f := newTestFixture(&(*cfg).Server, func(w http.ResponseWriter, r *http.Request) {
+						w.WriteHeader(http.StatusOK)
+					})
+					defer f.Close()
+
+					analytics := f.newService(&mockStatsProvider{})
+
+					go analytics.Start()
+					stop := make(chan struct{})
+					wg := sync.WaitGroup{}
+					for i := 0; i < 4; i++ {
+						wg.Add(1)
+						go func() {
+							defer wg.Done()
+							for {
+								select {
+								case <-stop:
+									return
+								default:
+									analytics.CurrentSnapshot()
+								}
+							}
+						}()
+					}
+					time.Sleep(200 * time.Millisecond)
+					analytics.Pause()
+					time.Sleep(200 * time.Millisecond)
+					analytics.Resume()
+					time.Sleep(100 * time.Millisecond)
+					analytics.Stop()
+					close(stop)
+					wg.Wait()
+				})
+			})
+			It("sends bucketized counters and stores exact values", func() {
+				runWithTimeout(func() {
+					wg := sync.WaitGroup{}
+					wg.Add(1)
+					v := make(map[string]interface{})
+					f := newTestFixture(&(*cfg).Server, func(w http.ResponseWriter, r *http.Request) {
+						bytes, err := io.ReadAll(r.Body)
+						Expect(err).ToNot(HaveOccurred())
+						Expect(json.Unmarshal(bytes, &v)).To(Succeed())
+						w.WriteHeader(http.StatusOK)
+						wg.Done()
+					})
+					defer f.Close()
+
+					(*cfg).Server.AnalyticsBucketize = true
+					analytics := f.newService(&mockStatsProvider{stats: map[string]int{"ingest": 4200}})
 
+					go analytics.Start()
+					wg.Wait()
+					analytics.Stop()
+					Expect(v["controller_ingest"]).To(Equal("1k-10k"))
+
+					var stored Analytics
+					Expect(f.storage.LoadAnalytics(&stored)).To(Succeed())
+					Expect(stored.ControllerIngest).To(Equal(4200))
+				})
+			})
+			It("sends the final report with the shutdown reason", func() {
+				var mutex sync.Mutex
+				var reports []map[string]interface{}
+				f := newTestFixture(&(*cfg).Server, func(w http.ResponseWriter, r *http.Request) {
+					var m map[string]interface{}
+					Expect(json.NewDecoder(r.Body).Decode(&m)).To(Succeed())
+					mutex.Lock()
+					reports = append(reports, m)
+					mutex.Unlock()
+					w.WriteHeader(http.StatusOK)
+				})
+				defer f.Close()
+
+				for _, reason := range []string{ShutdownSignal, ""} {
+					svc := f.newService(&mockStatsProvider{})
+					go svc.Start()
+					svc.StopWithReason(reason)
+				}
+
+				Expect(reports).To(HaveLen(2))
+				Expect(reports[0]["report_type"]).To(Equal("shutdown"))
+				Expect(reports[0]["shutdown_reason"]).To(Equal(ShutdownSignal))
+				Expect(reports[1]["shutdown_reason"]).To(Equal(ShutdownUnknown))
+			})
+			It("reads memory statistics at most once per configured interval", func() {
+				(*cfg).Server.AnalyticsMemStatsInterval = time.Minute
+				f := newTestFixture(&(*cfg).Server, nil)
+				defer f.Close()
+
+				a := f.newService(&mockStatsProvider{})
+				var reads int
+				a.readMemStats = func(ms *runtime.MemStats) {
+					reads++
+					ms.Alloc = uint64(reads)
+				}
+				now := time.Now()
+				a.now = func() time.Time { return now }
+
+				// A snapshot every 10 seconds over 3 minutes.
+				for i := 0; i < 18; i++ {
+					Expect(a.takeSnapshot().MemAlloc).To(Equal(reads))
+					now = now.Add(10 * time.Second)
+				}
+				Expect(reads).To(Equal(3))
+			})
+			It("reports the peak of gauge_max fields of the report interval", func() {
+				f := newTestFixture(&(*cfg).Server, func(w http.ResponseWriter, r *http.Request) {})
+				defer f.Close()
+
+				a := f.newService(&mockStatsProvider{})
+				var alloc uint64
+				a.readMemStats = func(ms *runtime.MemStats) { ms.Alloc = alloc }
+
+				for _, v := range [][2]int{{200, 200}, {300, 300}, {100, 300}} {
+					alloc = uint64(v[0])
+					Expect(a.takeSnapshot().MemAlloc).To(Equal(v[1]))
+				}
+				a.sendReport()
+
+				By("starting a new interval once the report is sent")
+				Expect(f.storage.SaveAnalytics(a.takeSnapshot())).To(Succeed())
+				Expect(a.CurrentSnapshot().MemAlloc).To(Equal(100))
+
+				By("resuming the interval after restart")
+				a = f.newService(&mockStatsProvider{})
+				a.readMemStats = func(ms *runtime.MemStats) { ms.Alloc = 50 }
+				Expect(f.storage.LoadAnalytics(a.base)).To(Succeed())
+				Expect(a.takeSnapshot().MemAlloc).To(Equal(100))
+			})
+			It("sends extra fields nested under the extra object", func() {
+				bodies := make(chan []byte, 1)
+				f := newTestFixture(&(*cfg).Server, func(w http.ResponseWriter, r *http.Request) {
+					b, err := io.ReadAll(r.Body)
+					Expect(err).ToNot(HaveOccurred())
+					bodies <- b
+					w.WriteHeader(http.StatusOK)
+				})
+				defer f.Close()
+
+				svc := f.newService(&mockStatsProvider{})
+				svc.SetExtraFieldsProvider(func() map[string]int {
+					return map[string]int{"enterprise_edition": 1, "Invalid Name": 2}
+				})
+				svc.sendReport()
+
+				var m map[string]json.RawMessage
+				Expect(json.Unmarshal(<-bodies, &m)).To(Succeed())
+				Expect(m).ToNot(HaveKey("enterprise_edition"))
+				Expect(string(m["extra"])).To(Equal(`{"enterprise_edition":1}`))
+			})
+			It("defers the first report until storage is ready", func() {
+				defer func(d time.Duration) { storageReadyCheckInterval = d }(storageReadyCheckInterval)
+				storageReadyCheckInterval = 10 * time.Millisecond
+
+				installIDs := make(chan string, 1)
+				f := newTestFixture(&(*cfg).Server, func(w http.ResponseWriter, r *http.Request) {
+					var a Analytics
+					Expect(json.NewDecoder(r.Body).Decode(&a)).To(Succeed())
+					select {
+					case installIDs <- a.InstallID:
+					default:
+					}
+					w.WriteHeader(http.StatusOK)
+				})
+				defer f.Close()
+
+				a := f.newService(&mockStatsProvider{})
+				readyAt := time.Now().Add(gracePeriod + 100*time.Millisecond)
+				a.storageReady = func() bool { return time.Now().After(readyAt) }
+				a.installID = func() string {
+					if a.storageReady() {
+						return "ready-id"
+					}
+					return ""
+				}
+
+				go a.Start()
+				defer a.Stop()
+				Eventually(installIDs, time.Second).Should(Receive(Equal("ready-id")))
+			})
+			It("reports zero controller statistics without a stats provider", func() {
+				reports := make(chan Analytics, 1)
+				f := newTestFixture(&(*cfg).Server, func(w http.ResponseWriter, r *http.Request) {
+					var a Analytics
+					Expect(json.NewDecoder(r.Body).Decode(&a)).To(Succeed())
+					reports <- a
+					w.WriteHeader(http.StatusOK)
+				})
+				defer f.Close()
+
+				f.newService(nil).sendReport()
+
+				var a Analytics
+				Eventually(reports).Should(Receive(&a))
+				Expect(a.InstallID).ToNot(BeEmpty())
+				Expect(a.ControllerIngest).To(BeZero())
+				Expect(a.SpyGospy).To(BeZero())
+				Expect(a.AppsCount).To(BeZero())
+			})
+			It("logs upload phases if tracing is enabled", func() {
+				hook := logtest.NewGlobal()
+				defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+				defer logrus.SetLevel(logrus.GetLevel())
+				logrus.SetLevel(logrus.DebugLevel)
+
+				httpServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				}))
+				defer httpServer.Close()
+				url = httpServer.URL + "/api/events"
+
+				f := newTestFixture(&(*cfg).Server, nil)
+				defer f.Close()
+
+				(*cfg).Server.AnalyticsTrace = true
+				svc := f.newService(&mockStatsProvider{})
+				svc.httpClient = httpServer.Client()
+				svc.sendReport()
+
+				var phases []string
+				for _, e := range hook.AllEntries() {
+					if e.Message == "analytics upload trace" {
+						phases = append(phases, e.Data["phase"].(string))
+						Expect(e.Data).To(HaveKey("duration"))
+					}
+				}
+				Expect(phases).To(Equal([]string{"connect", "tls", "first_byte"}))
+			})
+			It("keeps outcomes of the most recent reports", func() {
+				statuses := []int{200, 500, 200, 404, 200}
+				var i int32
+				f := newTestFixture(&(*cfg).Server, func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(statuses[atomic.AddInt32(&i, 1)-1])
+				})
+				defer f.Close()
+
+				svc := f.newService(&mockStatsProvider{})
+				svc.recent = newReportRing(3)
+				for range statuses {
+					svc.sendReport()
+				}
+
+				reports := svc.RecentReports()
+				Expect(reports).To(HaveLen(3))
+				Expect(reports[0].Status).To(Equal(200))
+				Expect(reports[0].Category).To(BeEmpty())
+				Expect(reports[0].Error).To(BeEmpty())
+				Expect(reports[1].Status).To(Equal(404))
+				Expect(reports[1].Category).To(Equal(errCategoryHTTP4xx))
+				Expect(reports[1].Error).ToNot(BeEmpty())
+				Expect(reports[2].Status).To(Equal(200))
+				Expect(reports[1].Timestamp.Before(reports[2].Timestamp)).To(BeTrue())
+			})
+			It("sends digest of the request body", func() {
+				matched := make(chan bool, 1)
+				f := newTestFixture(&(*cfg).Server, func(w http.ResponseWriter, r *http.Request) {
+					b, err := io.ReadAll(r.Body)
+					Expect(err).ToNot(HaveOccurred())
+					sum := sha256.Sum256(b)
+					matched <- r.Header.Get("Content-Digest") == "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":"
+					w.WriteHeader(http.StatusOK)
+				})
+				defer f.Close()
+
+				f.newService(&mockStatsProvider{}).sendReport()
+				Expect(<-matched).To(BeTrue())
+			})
+			It("replays stored reports exactly once", func() {
+				var mutex sync.Mutex
+				received := make(map[string]int)
+				f := newTestFixture(&(*cfg).Server, func(w http.ResponseWriter, r *http.Request) {
+					mutex.Lock()
+					received[r.Header.Get(idempotencyKeyHeader)]++
+					mutex.Unlock()
+					w.WriteHeader(http.StatusOK)
+				})
+				defer f.Close()
+				since := time.Now().Add(-72 * time.Hour)
+				for i := 1; i <= 3; i++ {
+					Expect(f.storage.SaveAnalyticsHistory(since.Add(time.Duration(i)*time.Hour), []byte(`{}`))).To(Succeed())
+				}
+
+				svc := f.newService(&mockStatsProvider{})
+				n, err := svc.Replay(context.Background(), since)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(Equal(3))
+				n, err = svc.Replay(context.Background(), since)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(n).To(BeZero())
+
+				Expect(received).To(HaveLen(3))
+				for _, c := range received {
+					Expect(c).To(Equal(1))
+				}
+			})
+			It("returns a no-op service if analytics is disabled", func() {
+				(*cfg).Server.AnalyticsOptOut = true
+				svc, err := NewService(&(*cfg).Server, nil, nil, nil, logrus.StandardLogger())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(svc).To(BeAssignableToTypeOf(nullService{}))
+
+				done := make(chan struct{})
+				go func() {
+					svc.Start()
+					svc.Stop()
 					close(done)
 				}()
-				Eventually(done, 2).Should(BeClosed())
+				Eventually(done, durThreshold).Should(BeClosed())
+			})
+			It("respects DO_NOT_TRACK environment variable", func() {
+				Expect(os.Setenv("DO_NOT_TRACK", "1")).To(Succeed())
+				defer os.Unsetenv("DO_NOT_TRACK")
+				svc, err := NewService(&(*cfg).Server, nil, nil, nil, logrus.StandardLogger())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(svc).To(BeAssignableToTypeOf(nullService{}))
 			})
+			It("rejects unsupported URL schemes", func() {
+				(*cfg).Server.AnalyticsURL = "ftp://localhost/api/events"
+				_, err := NewService(&(*cfg).Server, nil, &mockStatsProvider{}, prometheus.NewRegistry(), logrus.StandardLogger())
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("upload schedule", func() {
+		testing.WithConfig(func(cfg **config.Config) {
+			It("is stable across restarts", func() {
+				f := newTestFixture(&(*cfg).Server, nil)
+				defer f.Close()
+
+				t0 := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+				now := t0
+				newService := func() *service {
+					return &service{s: f.storage, logger: logrus.StandardLogger(), installID: f.storage.InstallID, now: func() time.Time { return now }}
+				}
+
+				scheduled := newService().nextUploadTime()
+				Expect(scheduled).To(BeTemporally(">", t0))
+				Expect(scheduled).To(BeTemporally("<=", t0.Add(uploadFrequency)))
+
+				now = t0.Add((scheduled.Sub(t0)) / 2)
+				Expect(newService().nextUploadTime()).To(Equal(scheduled))
+
+				now = scheduled.Add(time.Millisecond)
+				Expect(newService().nextUploadTime()).To(Equal(scheduled.Add(uploadFrequency)))
+			})
+
+			It("is resumed on start", func() {
+				var (
+					m          sync.Mutex
+					timestamps []time.Time
+				)
+				f := newTestFixture(&(*cfg).Server, func(w http.ResponseWriter, r *http.Request) {
+					m.Lock()
+					timestamps = append(timestamps, time.Now())
+					m.Unlock()
+				})
+				defer f.Close()
+
+				startTime := time.Now()
+				scheduled := startTime.Add(uploadFrequency)
+				Expect(f.storage.SaveAnalyticsSchedule(scheduled)).To(Succeed())
+
+				svc := f.newService(&mockStatsProvider{})
+				go svc.Start()
+				Eventually(func() int {
+					m.Lock()
+					defer m.Unlock()
+					return len(timestamps)
+				}, time.Second).ShouldNot(BeZero())
+				svc.Stop()
+				Expect(timestamps[0]).To(BeTemporally("~", scheduled, durThreshold))
+			})
+		})
+
+		It("varies between installations", func() {
+			now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+			period := 24 * time.Hour
+			a := nextUpload("install-a", now, period)
+			b := nextUpload("install-b", now, period)
+			Expect(a).ToNot(Equal(b))
+			Expect(nextUpload("install-a", now.Add(time.Hour), period)).To(Equal(a))
+		})
+	})
+
+	Describe("enabledFeatures", func() {
+		var features map[string]func(*config.Server) bool
+
+		BeforeEach(func() {
+			features = experimentalFeatures
+			experimentalFeatures = map[string]func(*config.Server) bool{
+				"zeta":  func(c *config.Server) bool { return c.EnableExperimentalAdmin },
+				"alpha": func(c *config.Server) bool { return c.NoAdhocUI },
+				"beta":  func(c *config.Server) bool { return c.NoSelfProfiling },
+			}
+		})
+
+		AfterEach(func() {
+			experimentalFeatures = features
+		})
+
+		It("reports sorted names of enabled features", func() {
+			c := &config.Server{EnableExperimentalAdmin: true, NoAdhocUI: true}
+			Expect(enabledFeatures(c)).To(Equal([]string{"alpha", "zeta"}))
+		})
+
+		It("reports empty list if no features are enabled", func() {
+			Expect(enabledFeatures(new(config.Server))).To(BeEmpty())
+		})
+	})
+
+	Describe("rebaseAnalytics", func() {
+		It("keeps the largest value of gauge_max fields", func() {
+			base := &Analytics{MemAlloc: 300, MemSys: 100, MemTotalAlloc: 100, ControllerIngest: 1}
+			current := &Analytics{MemAlloc: 200, MemSys: 400, MemTotalAlloc: 200, ControllerIngest: 2}
+			rebased := new(service).rebaseAnalytics(base, current)
+			Expect(rebased.MemAlloc).To(Equal(300))
+			Expect(rebased.MemSys).To(Equal(400))
+			Expect(rebased.MemTotalAlloc).To(Equal(200))
+			Expect(rebased.ControllerIngest).To(Equal(3))
+		})
+
+		It("keeps the smallest value of gauge_min fields", func() {
+			type gauges struct {
+				A int `kind:"gauge_min"`
+				B int `kind:"gauge_min"`
+			}
+			base := gauges{A: 1, B: 20}
+			rebased := gauges{A: 10, B: 2}
+			rebaseFields(reflect.ValueOf(&rebased).Elem(), reflect.ValueOf(base), structFields(reflect.TypeOf(base)))
+			Expect(rebased).To(Equal(gauges{A: 1, B: 2}))
+		})
+
+		It("considers zero base values of gauge fields unset", func() {
+			type gauges struct {
+				A int `kind:"gauge_min"`
+				B int `kind:"gauge_max"`
+			}
+			rebased := gauges{A: 10, B: 20}
+			rebaseFields(reflect.ValueOf(&rebased).Elem(), reflect.ValueOf(gauges{}), structFields(reflect.TypeOf(rebased)))
+			Expect(rebased).To(Equal(gauges{A: 10, B: 20}))
+		})
+	})
+
+	Describe("analyticsFields", func() {
+		It("covers every field of Analytics", func() {
+			t := reflect.TypeOf(Analytics{})
+			fields := analyticsFields()
+			Expect(fields).To(HaveLen(t.NumField()))
+			for i, f := range fields {
+				Expect(f.index).To(Equal(i))
+				Expect(f.name).To(Equal(strings.Split(t.Field(i).Tag.Get("json"), ",")[0]))
+				Expect(f.kind).To(Equal(t.Field(i).Tag.Get("kind")))
+			}
 		})
 	})
 })
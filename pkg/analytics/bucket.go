@@ -0,0 +1,76 @@
+package analytics
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// bucketizedFields lists JSON names of Analytics fields tagged with
+// bucketize:"true". If coarse reporting is enabled, values of these fields
+// are replaced with power of ten ranges they fall into.
+var bucketizedFields = func() []string {
+	var names []string
+	for _, f := range analyticsFields() {
+		if f.bucketize {
+			names = append(names, f.name)
+		}
+	}
+	return names
+}()
+
+// marshalBucketized marshals a with values of bucketized fields replaced
+// with the corresponding bucket labels. a itself is not modified.
+func marshalBucketized(a *Analytics) ([]byte, error) {
+	b, err := a.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]json.RawMessage
+	if err = json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	for _, name := range bucketizedFields {
+		raw, ok := m[name]
+		if !ok {
+			continue
+		}
+		var v int64
+		if err = json.Unmarshal(raw, &v); err != nil {
+			return nil, err
+		}
+		if m[name], err = json.Marshal(bucketLabel(v)); err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(m)
+}
+
+// bucketLabel returns the power of ten range v belongs to,
+// e.g. 4200 is reported as "1k-10k".
+func bucketLabel(v int64) string {
+	if v <= 0 {
+		return "0"
+	}
+	lo := int64(1)
+	for v/lo >= 10 {
+		lo *= 10
+	}
+	return humanizeCount(lo) + "-" + humanizeCount(lo*10)
+}
+
+func humanizeCount(v int64) string {
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"T", 1e12},
+		{"G", 1e9},
+		{"M", 1e6},
+		{"k", 1e3},
+	} {
+		if v >= u.size {
+			return strconv.FormatInt(v/u.size, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(v, 10)
+}
@@ -0,0 +1,35 @@
+package analytics
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("bucketize", func() {
+	DescribeTable("bucketLabel",
+		func(v int64, expected string) {
+			Expect(bucketLabel(v)).To(Equal(expected))
+		},
+		Entry("zero", int64(0), "0"),
+		Entry("single digit", int64(7), "1-10"),
+		Entry("lower bound", int64(100), "100-1k"),
+		Entry("thousands", int64(4200), "1k-10k"),
+		Entry("upper bound", int64(999999), "100k-1M"),
+		Entry("billions", int64(3e9), "1G-10G"),
+	)
+
+	It("replaces only bucketized fields", func() {
+		a := &Analytics{ControllerIngest: 4200, SpyGospy: 15, ControllerRender: 4200}
+		b, err := marshalBucketized(a)
+		Expect(err).ToNot(HaveOccurred())
+		var m map[string]interface{}
+		Expect(json.Unmarshal(b, &m)).To(Succeed())
+		Expect(m["controller_ingest"]).To(Equal("1k-10k"))
+		Expect(m["spy_gospy"]).To(Equal("10-100"))
+		Expect(m["controller_render"]).To(BeEquivalentTo(4200))
+		Expect(a.ControllerIngest).To(Equal(4200))
+	})
+})
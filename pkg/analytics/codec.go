@@ -0,0 +1,37 @@
+package analytics
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// Marshal returns the JSON encoding of the usage data. The output is
+// canonical: map keys are sorted by encoding/json, and slice fields are
+// sorted before marshaling, therefore equal data is encoded identically.
+func (a *Analytics) Marshal() ([]byte, error) {
+	c := *a
+	c.EnabledFeatures = sortedStrings(a.EnabledFeatures)
+	return json.Marshal(&c)
+}
+
+// Unmarshal decodes JSON encoded usage data into a. Fields that are not
+// present in b are reset to their zero values, therefore the result does
+// not depend on the previous state of a.
+func (a *Analytics) Unmarshal(b []byte) error {
+	var x Analytics
+	if err := json.Unmarshal(b, &x); err != nil {
+		return err
+	}
+	*a = x
+	return nil
+}
+
+func sortedStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	c := make([]string, len(s))
+	copy(c, s)
+	sort.Strings(c)
+	return c
+}
@@ -0,0 +1,67 @@
+//go:build go1.18
+// +build go1.18
+
+package analytics
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+)
+
+func FuzzAnalyticsRoundTrip(f *testing.F) {
+	snapshot := &Analytics{
+		InstallID:            "7a5d5e4c-3f1b-4bb1-a9b6-5d8e1c0f2a11",
+		RunID:                "0b7c8a52-1d55-4bd2-9c0e-6a0f3b2d4e19",
+		Version:              "0.0.35",
+		GitSHA:               "4b0c6bde",
+		BuildTime:            "2021-06-11T20:15:54Z",
+		Timestamp:            time.Date(2021, 6, 14, 11, 3, 12, 0, time.UTC),
+		UploadIndex:          12,
+		GOOS:                 "linux",
+		GOARCH:               "amd64",
+		GoVersion:            "go1.16.4",
+		AnalyticsPersistence: true,
+		EnabledFeatures:      []string{"admin"},
+		MemAlloc:             38129664,
+		MemSys:               80561160,
+		BadgerMain:           1 << 20,
+		BadgerDiskUsage: map[string]storage.DiskUsage{
+			"main": {LSM: 1 << 19, VLog: 1 << 19},
+		},
+		AppsCount:        3,
+		ControllerIngest: 48211,
+		SpyGospy:         48211,
+	}
+	b, err := snapshot.Marshal()
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(b)
+	f.Add([]byte(`{}`))
+	f.Add([]byte(`null`))
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var a Analytics
+		if err := a.Unmarshal(b); err != nil {
+			return
+		}
+		b1, err := a.Marshal()
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		var a2 Analytics
+		if err = a2.Unmarshal(b1); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		b2, err := a2.Marshal()
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		if !bytes.Equal(b1, b2) {
+			t.Fatalf("round trip is not stable:\n%s\n%s", b1, b2)
+		}
+	})
+}
@@ -0,0 +1,32 @@
+package analytics
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+)
+
+var _ = Describe("Marshal", func() {
+	It("produces identical output for the same data", func() {
+		ts := time.Date(2021, 6, 14, 11, 3, 12, 0, time.UTC)
+		a := &Analytics{
+			Timestamp:       ts,
+			EnabledFeatures: []string{"b", "a", "c"},
+			BadgerDiskUsage: map[string]storage.DiskUsage{"main": {LSM: 1}, "trees": {VLog: 2}},
+		}
+		b := &Analytics{
+			Timestamp:       ts,
+			EnabledFeatures: []string{"c", "b", "a"},
+			BadgerDiskUsage: map[string]storage.DiskUsage{"trees": {VLog: 2}, "main": {LSM: 1}},
+		}
+		x, err := a.Marshal()
+		Expect(err).ToNot(HaveOccurred())
+		y, err := b.Marshal()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(x).To(Equal(y))
+		Expect(a.EnabledFeatures).To(Equal([]string{"b", "a", "c"}))
+	})
+})
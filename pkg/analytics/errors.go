@@ -0,0 +1,61 @@
+package analytics
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// Upload error categories.
+const (
+	errCategoryDNS               = "dns"
+	errCategoryTLS               = "tls"
+	errCategoryTimeout           = "timeout"
+	errCategoryConnectionRefused = "connection_refused"
+	errCategoryHTTP4xx           = "http_4xx"
+	errCategoryHTTP5xx           = "http_5xx"
+	errCategoryOther             = "other"
+)
+
+// classifyError returns the category of an error returned by the HTTP client.
+func classifyError(err error) string {
+	var (
+		dnsErr      *net.DNSError
+		netErr      net.Error
+		recordErr   tls.RecordHeaderError
+		authorityEr x509.UnknownAuthorityError
+		hostnameErr x509.HostnameError
+		invalidErr  x509.CertificateInvalidError
+	)
+	switch {
+	case errors.As(err, &dnsErr):
+		return errCategoryDNS
+	case errors.As(err, &recordErr),
+		errors.As(err, &authorityEr),
+		errors.As(err, &hostnameErr),
+		errors.As(err, &invalidErr):
+		return errCategoryTLS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return errCategoryTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return errCategoryConnectionRefused
+	default:
+		return errCategoryOther
+	}
+}
+
+// classifyStatusCode returns the category of an unsuccessful response
+// status code. An empty string is returned if the code indicates success.
+func classifyStatusCode(code int) string {
+	switch {
+	case code >= http.StatusInternalServerError:
+		return errCategoryHTTP5xx
+	case code >= http.StatusBadRequest:
+		return errCategoryHTTP4xx
+	default:
+		return ""
+	}
+}
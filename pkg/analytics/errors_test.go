@@ -0,0 +1,45 @@
+package analytics
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"syscall"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("upload errors", func() {
+	urlError := func(err error) error {
+		return &neturl.Error{Op: "Post", URL: "https://analytics.pyroscope.io/api/events", Err: err}
+	}
+
+	DescribeTable("classifyError",
+		func(err error, expected string) {
+			Expect(classifyError(err)).To(Equal(expected))
+		},
+		Entry("dns", urlError(&net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "analytics.pyroscope.io"}}), errCategoryDNS),
+		Entry("tls", urlError(x509.UnknownAuthorityError{}), errCategoryTLS),
+		Entry("timeout", urlError(context.DeadlineExceeded), errCategoryTimeout),
+		Entry("connection refused", urlError(&net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), errCategoryConnectionRefused),
+		Entry("other", urlError(errors.New("unexpected EOF")), errCategoryOther),
+	)
+
+	DescribeTable("classifyStatusCode",
+		func(code int, expected string) {
+			Expect(classifyStatusCode(code)).To(Equal(expected))
+		},
+		Entry("ok", http.StatusOK, ""),
+		Entry("redirect", http.StatusNotModified, ""),
+		Entry("bad request", http.StatusBadRequest, errCategoryHTTP4xx),
+		Entry("too many requests", http.StatusTooManyRequests, errCategoryHTTP4xx),
+		Entry("internal server error", http.StatusInternalServerError, errCategoryHTTP5xx),
+		Entry("bad gateway", http.StatusBadGateway, errCategoryHTTP5xx),
+	)
+})
@@ -0,0 +1,54 @@
+package analytics
+
+import (
+	"encoding/json"
+	"regexp"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ExtraFieldsProvider supplies additional numeric values reported under
+// the "extra" object. It allows applications embedding pyroscope to add
+// their own anonymous usage data without changing the Analytics struct.
+type ExtraFieldsProvider func() map[string]int
+
+const maxExtraFields = 32
+
+var extraFieldName = regexp.MustCompile(`^[a-z0-9_]{1,64}$`)
+
+// validExtraFields returns extra fields with valid names. Names must be
+// snake_case and not longer than 64 characters; at most 32 fields are kept.
+func validExtraFields(extra map[string]int, logger logrus.FieldLogger) map[string]int {
+	valid := make(map[string]int, len(extra))
+	for k, v := range extra {
+		if !extraFieldName.MatchString(k) {
+			logger.WithField("name", k).Warn("ignoring invalid analytics extra field")
+			continue
+		}
+		valid[k] = v
+	}
+	if len(valid) > maxExtraFields {
+		logger.WithField("count", len(valid)).Warn("too many analytics extra fields, ignoring all of them")
+		return nil
+	}
+	return valid
+}
+
+// withExtraFields adds extra fields to the JSON object b as a nested
+// "extra" object. b is returned as is if there are no extra fields.
+func withExtraFields(b []byte, extra map[string]int, logger logrus.FieldLogger) ([]byte, error) {
+	extra = validExtraFields(extra, logger)
+	if len(extra) == 0 {
+		return b, nil
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	v, err := json.Marshal(extra)
+	if err != nil {
+		return nil, err
+	}
+	m["extra"] = v
+	return json.Marshal(m)
+}
@@ -0,0 +1,60 @@
+package analytics
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// field describes an Analytics struct field.
+type field struct {
+	index int
+	// name is the JSON name of the field.
+	name string
+	// kind specifies how values of a numeric field are aggregated:
+	//   - cumulative: values are summed up;
+	//   - gauge_max: the largest of the values is kept;
+	//   - gauge_min: the smallest of the values is kept.
+	//
+	// Fields without the tag are gauges, and their values are not changed.
+	kind      string
+	numeric   bool
+	bucketize bool
+}
+
+var (
+	fieldsOnce sync.Once
+	fields     []field
+)
+
+// analyticsFields returns metadata of Analytics fields, in the order
+// of declaration. The metadata is computed once.
+func analyticsFields() []field {
+	fieldsOnce.Do(func() {
+		fields = structFields(reflect.TypeOf(Analytics{}))
+	})
+	return fields
+}
+
+func structFields(t reflect.Type) []field {
+	s := make([]field, t.NumField())
+	for i := range s {
+		f := t.Field(i)
+		s[i] = field{
+			index:     i,
+			name:      strings.Split(f.Tag.Get("json"), ",")[0],
+			kind:      f.Tag.Get("kind"),
+			numeric:   isInt(f.Type.Kind()),
+			bucketize: f.Tag.Get("bucketize") == "true",
+		}
+	}
+	return s
+}
+
+func isInt(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,23 @@
+package analytics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func BenchmarkRebaseFields(b *testing.B) {
+	base := Analytics{MemAlloc: 300, ControllerIngest: 1, SpyGospy: 10}
+	current := Analytics{MemAlloc: 200, ControllerIngest: 2, SpyGospy: 20}
+	b.Run("cached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			rebased := current
+			rebaseFields(reflect.ValueOf(&rebased).Elem(), reflect.ValueOf(base), analyticsFields())
+		}
+	})
+	b.Run("uncached", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			rebased := current
+			rebaseFields(reflect.ValueOf(&rebased).Elem(), reflect.ValueOf(base), structFields(reflect.TypeOf(base)))
+		}
+	})
+}
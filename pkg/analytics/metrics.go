@@ -0,0 +1,19 @@
+package analytics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+type metrics struct {
+	uploadFailures *prometheus.CounterVec
+}
+
+func newMetrics(r prometheus.Registerer) *metrics {
+	return &metrics{
+		uploadFailures: promauto.With(r).NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_analytics_upload_failures_total",
+			Help: "number of failed analytics report uploads",
+		}, []string{"category"}),
+	}
+}
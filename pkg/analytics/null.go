@@ -0,0 +1,32 @@
+package analytics
+
+import (
+	"context"
+	"time"
+)
+
+// nullService is used when analytics is disabled: it neither collects
+// nor uploads any data.
+type nullService struct {
+	settings AnalyticsSettings
+}
+
+func (nullService) Start() {}
+
+func (nullService) Stop() {}
+
+func (nullService) StopWithReason(string) {}
+
+func (nullService) Pause() {}
+
+func (nullService) Resume() {}
+
+func (nullService) CurrentSnapshot() *Analytics { return new(Analytics) }
+
+func (nullService) SetExtraFieldsProvider(ExtraFieldsProvider) {}
+
+func (n nullService) EffectiveConfig() AnalyticsSettings { return n.settings }
+
+func (nullService) RecentReports() []ReportOutcome { return nil }
+
+func (nullService) Replay(context.Context, time.Time) (int, error) { return 0, nil }
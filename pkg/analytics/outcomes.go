@@ -0,0 +1,51 @@
+package analytics
+
+import (
+	"sync"
+	"time"
+)
+
+const recentReportsSize = 16
+
+// ReportOutcome describes the result of a report upload attempt.
+type ReportOutcome struct {
+	Timestamp time.Time     `json:"timestamp"`
+	Status    int           `json:"status,omitempty"`
+	Category  string        `json:"category,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	Latency   time.Duration `json:"latency"`
+}
+
+// reportRing keeps a fixed number of the most recent report outcomes.
+type reportRing struct {
+	mutex sync.Mutex
+	buf   []ReportOutcome
+	next  int
+	full  bool
+}
+
+func newReportRing(size int) *reportRing {
+	return &reportRing{buf: make([]ReportOutcome, size)}
+}
+
+func (r *reportRing) add(o ReportOutcome) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.buf[r.next] = o
+	r.next = (r.next + 1) % len(r.buf)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// items returns a copy of the stored outcomes, oldest first.
+func (r *reportRing) items() []ReportOutcome {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.full {
+		return append([]ReportOutcome(nil), r.buf[:r.next]...)
+	}
+	items := make([]ReportOutcome, 0, len(r.buf))
+	items = append(items, r.buf[r.next:]...)
+	return append(items, r.buf[:r.next]...)
+}
@@ -0,0 +1,32 @@
+package analytics
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("reportRing", func() {
+	status := func(items []ReportOutcome) []int {
+		var s []int
+		for _, o := range items {
+			s = append(s, o.Status)
+		}
+		return s
+	}
+
+	It("returns stored outcomes in order", func() {
+		r := newReportRing(3)
+		Expect(r.items()).To(BeEmpty())
+		r.add(ReportOutcome{Status: 1})
+		r.add(ReportOutcome{Status: 2})
+		Expect(status(r.items())).To(Equal([]int{1, 2}))
+	})
+
+	It("keeps only the last outcomes", func() {
+		r := newReportRing(3)
+		for i := 1; i <= 7; i++ {
+			r.add(ReportOutcome{Status: i})
+		}
+		Expect(status(r.items())).To(Equal([]int{5, 6, 7}))
+	})
+})
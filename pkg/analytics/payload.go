@@ -0,0 +1,63 @@
+package analytics
+
+import (
+	"encoding/json"
+
+	"github.com/sirupsen/logrus"
+)
+
+// droppableFields lists groups of optional report fields in the order they
+// are dropped if the report payload exceeds the maximum size.
+var droppableFields = [][]string{
+	{"extra", "enabled_features", "badger_disk_usage"},
+	{
+		"spy_rbspy",
+		"spy_pyspy",
+		"spy_gospy",
+		"spy_ebpfspy",
+		"spy_phpspy",
+		"spy_dotnetspy",
+		"spy_javaspy",
+	},
+	{
+		"badger_main",
+		"badger_trees",
+		"badger_dicts",
+		"badger_dimensions",
+		"badger_segments",
+	},
+}
+
+// truncatePayload drops optional fields from the JSON object b until its
+// size does not exceed max bytes. If all the optional fields are dropped
+// but the payload is still too large, it is returned as is.
+func truncatePayload(b []byte, max int, logger logrus.FieldLogger) ([]byte, error) {
+	if max <= 0 || len(b) <= max {
+		return b, nil
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	var dropped []string
+	for _, fields := range droppableFields {
+		for _, f := range fields {
+			if _, ok := m[f]; ok {
+				delete(m, f)
+				dropped = append(dropped, f)
+			}
+		}
+		r, err := json.Marshal(m)
+		if err != nil {
+			return nil, err
+		}
+		b = r
+		if len(b) <= max {
+			break
+		}
+	}
+	logger.WithField("dropped", dropped).
+		WithField("size", len(b)).
+		Warn("analytics report exceeds maximum payload size, optional fields dropped")
+	return b, nil
+}
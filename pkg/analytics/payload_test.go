@@ -0,0 +1,65 @@
+package analytics
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+var _ = Describe("truncatePayload", func() {
+	a := &Analytics{
+		InstallID:        "install-id",
+		EnabledFeatures:  []string{"admin"},
+		ControllerIngest: 10,
+		SpyGospy:         5,
+		BadgerMain:       1024,
+	}
+
+	var b []byte
+	BeforeEach(func() {
+		var err error
+		b, err = json.Marshal(a)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	decode := func(b []byte) map[string]interface{} {
+		var m map[string]interface{}
+		Expect(json.Unmarshal(b, &m)).To(Succeed())
+		return m
+	}
+
+	It("does not modify payload within the limit", func() {
+		r, err := truncatePayload(b, len(b), logrus.StandardLogger())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r).To(Equal(b))
+	})
+
+	It("does not modify payload if there is no limit", func() {
+		r, err := truncatePayload(b, 0, logrus.StandardLogger())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r).To(Equal(b))
+	})
+
+	It("drops optional fields in order", func() {
+		r, err := truncatePayload(b, len(b)-1, logrus.StandardLogger())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(len(r)).To(BeNumerically("<", len(b)))
+		m := decode(r)
+		Expect(m).ToNot(HaveKey("enabled_features"))
+		Expect(m).To(HaveKey("spy_gospy"))
+		Expect(m).To(HaveKey("badger_main"))
+	})
+
+	It("keeps core fields if the limit is too small", func() {
+		r, err := truncatePayload(b, 1, logrus.StandardLogger())
+		Expect(err).ToNot(HaveOccurred())
+		m := decode(r)
+		Expect(m).ToNot(HaveKey("enabled_features"))
+		Expect(m).ToNot(HaveKey("spy_gospy"))
+		Expect(m).ToNot(HaveKey("badger_main"))
+		Expect(m["install_id"]).To(Equal("install-id"))
+		Expect(m["controller_ingest"]).To(BeEquivalentTo(10))
+	})
+})
@@ -0,0 +1,45 @@
+package analytics
+
+import (
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// nextUploadTime returns the time the next report is to be uploaded at.
+// The schedule is persisted, therefore restarts do not reset it.
+func (s *service) nextUploadTime() time.Time {
+	if t, ok := s.scheduledUploadTime(); ok {
+		return t
+	}
+	t := nextUpload(s.installID(), s.now(), uploadFrequency)
+	if err := s.s.SaveAnalyticsSchedule(t); err != nil {
+		s.logger.WithError(err).Error("failed to save analytics schedule")
+	}
+	return t
+}
+
+// scheduledUploadTime returns the persisted time of the next upload,
+// if it is still ahead within the upload period.
+func (s *service) scheduledUploadTime() (time.Time, bool) {
+	now := s.now()
+	t, err := s.s.LoadAnalyticsSchedule()
+	if err == nil && t.After(now) && t.Sub(now) <= uploadFrequency {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// nextUpload returns the first time after now that is aligned to the
+// install offset within the upload period. The offset is derived from
+// the install ID, so that reports of different installations are spread
+// over the period, while reports of a particular one are sent at the
+// same time of the period.
+func nextUpload(installID string, now time.Time, period time.Duration) time.Time {
+	offset := time.Duration(xxhash.Sum64String(installID) % uint64(period))
+	t := now.Truncate(period).Add(offset)
+	if !t.After(now) {
+		t = t.Add(period)
+	}
+	return t
+}
@@ -0,0 +1,52 @@
+package analytics
+
+import (
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/util/bytesize"
+)
+
+// Reasons analytics is enabled or disabled.
+const (
+	ReasonEnabled    = "enabled"
+	ReasonOptOut     = "analytics-opt-out flag is set"
+	ReasonDoNotTrack = "DO_NOT_TRACK environment variable is set"
+)
+
+// AnalyticsSettings describes what the analytics service actually does
+// given the configuration options and environment variables.
+type AnalyticsSettings struct {
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason"`
+
+	URL               string            `json:"url"`
+	UploadFrequency   time.Duration     `json:"upload_frequency"`
+	SnapshotFrequency time.Duration     `json:"snapshot_frequency"`
+	MemStatsInterval  time.Duration     `json:"mem_stats_interval"`
+	Bucketize         bool              `json:"bucketize"`
+	MaxPayloadSize    bytesize.ByteSize `json:"max_payload_size"`
+	EnabledFeatures   []string          `json:"enabled_features"`
+}
+
+func effectiveSettings(cfg *config.Server) AnalyticsSettings {
+	reason := disabledReason(cfg)
+	if reason != "" {
+		return AnalyticsSettings{Reason: reason}
+	}
+	u := cfg.AnalyticsURL
+	if u == "" {
+		u = url
+	}
+	return AnalyticsSettings{
+		Enabled:           true,
+		Reason:            ReasonEnabled,
+		URL:               u,
+		UploadFrequency:   uploadFrequency,
+		SnapshotFrequency: snapshotFrequency,
+		MemStatsInterval:  cfg.AnalyticsMemStatsInterval,
+		Bucketize:         cfg.AnalyticsBucketize,
+		MaxPayloadSize:    cfg.AnalyticsMaxPayloadSize,
+		EnabledFeatures:   enabledFeatures(cfg),
+	}
+}
@@ -0,0 +1,50 @@
+package analytics
+
+import (
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+)
+
+var _ = Describe("EffectiveConfig", func() {
+	AfterEach(func() {
+		Expect(os.Unsetenv("DO_NOT_TRACK")).To(Succeed())
+	})
+
+	DescribeTable("resolves whether analytics is enabled",
+		func(optOut bool, doNotTrack *string, enabled bool, reason string) {
+			if doNotTrack != nil {
+				Expect(os.Setenv("DO_NOT_TRACK", *doNotTrack)).To(Succeed())
+			}
+			cfg := &config.Server{AnalyticsOptOut: optOut}
+			svc, err := NewService(cfg, nil, &mockStatsProvider{}, nil, logrus.StandardLogger())
+			Expect(err).ToNot(HaveOccurred())
+			s := svc.EffectiveConfig()
+			Expect(s.Enabled).To(Equal(enabled))
+			Expect(s.Reason).To(Equal(reason))
+		},
+		Entry("by default", false, nil, true, ReasonEnabled),
+		Entry("DO_NOT_TRACK=0", false, strPtr("0"), true, ReasonEnabled),
+		Entry("DO_NOT_TRACK=false", false, strPtr("FALSE"), true, ReasonEnabled),
+		Entry("DO_NOT_TRACK=1", false, strPtr("1"), false, ReasonDoNotTrack),
+		Entry("opt-out flag", true, nil, false, ReasonOptOut),
+		Entry("opt-out flag takes precedence over DO_NOT_TRACK", true, strPtr("1"), false, ReasonOptOut),
+	)
+
+	It("reports the effective endpoint", func() {
+		svc, err := NewService(&config.Server{AnalyticsURL: "unix:///tmp/analytics.sock"}, nil, &mockStatsProvider{}, nil, logrus.StandardLogger())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(svc.EffectiveConfig().URL).To(Equal("unix:///tmp/analytics.sock"))
+
+		svc, err = NewService(new(config.Server), nil, &mockStatsProvider{}, nil, logrus.StandardLogger())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(svc.EffectiveConfig().URL).To(Equal(url))
+	})
+})
+
+func strPtr(s string) *string { return &s }
@@ -0,0 +1,45 @@
+package analytics
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// withClientTrace returns a context that makes the HTTP client log
+// durations of DNS lookup, connection, and TLS handshake phases, as well
+// as the time to the first response byte.
+func withClientTrace(ctx context.Context, logger logrus.FieldLogger) context.Context {
+	var dnsStart, connectStart, tlsStart time.Time
+	start := time.Now()
+	phaseDone := func(phase string, since time.Time, err error) {
+		l := logger.WithFields(logrus.Fields{
+			"phase":    phase,
+			"duration": time.Since(since),
+		})
+		if err != nil {
+			l = l.WithError(err)
+		}
+		l.Debug("analytics upload trace")
+	}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone: func(i httptrace.DNSDoneInfo) {
+			phaseDone("dns", dnsStart, i.Err)
+		},
+		ConnectStart: func(_, _ string) { connectStart = time.Now() },
+		ConnectDone: func(_, _ string, err error) {
+			phaseDone("connect", connectStart, err)
+		},
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			phaseDone("tls", tlsStart, err)
+		},
+		GotFirstResponseByte: func() {
+			phaseDone("first_byte", start, nil)
+		},
+	})
+}
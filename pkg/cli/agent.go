@@ -26,6 +26,12 @@ func newAgentService(logger *logrus.Logger, cfg *config.Agent) (*agentService, e
 		UpstreamThreads:        cfg.UpstreamThreads,
 		UpstreamAddress:        cfg.ServerAddress,
 		UpstreamRequestTimeout: cfg.UpstreamRequestTimeout,
+		CompressionThreshold:   int(cfg.UpstreamCompressionThreshold),
+		ServerCAFile:           cfg.ServerCAFile,
+		ClientCertFile:         cfg.ClientCertFile,
+		ClientKeyFile:          cfg.ClientKeyFile,
+		SigningKeyID:           cfg.SigningKeyID,
+		SigningSecret:          cfg.SigningSecret,
 	}
 	upstream, err := remote.New(rc, logger)
 	if err != nil {
@@ -54,7 +60,7 @@ func (svc *agentService) Stop(_ service.Service) error {
 // https://github.com/spf13/viper#accessing-nested-keys.
 // TODO(kolesnikovae): find a way to get rid of the function.
 func loadAgentConfig(c *config.Agent) error {
-	b, err := os.ReadFile(c.Config)
+	b, err := readConfigFile(c.Config)
 	switch {
 	case err == nil:
 	case os.IsNotExist(err):
@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"os"
@@ -61,6 +62,11 @@ func CreateCmdRunFn(cfg interface{}, vpr *viper.Viper, fn CmdRunFn) CmdRunFn {
 			return err
 		}
 
+		// Secrets may be specified with files, e.g. auth-token-file.
+		if err = loadSecretFiles(cfg); err != nil {
+			return err
+		}
+
 		if err = fn(cmd, args); err != nil {
 			cmd.SilenceUsage = true
 		}
@@ -68,6 +74,19 @@ func CreateCmdRunFn(cfg interface{}, vpr *viper.Viper, fn CmdRunFn) CmdRunFn {
 	}
 }
 
+// LoadConfig loads the configuration of the command into cfg again, e.g.
+// on reload, with the same precedence as CreateCmdRunFn: command line
+// arguments parsed at start override the config file and environment.
+func LoadConfig(cmd *cobra.Command, vpr *viper.Viper, cfg interface{}) error {
+	if err := loadConfigFile(cmd, vpr); err != nil {
+		return err
+	}
+	if err := Unmarshal(vpr, cfg); err != nil {
+		return err
+	}
+	return loadSecretFiles(cfg)
+}
+
 func NewViper(prefix string) *viper.Viper {
 	v := viper.New()
 	v.SetEnvPrefix(prefix)
@@ -159,7 +178,11 @@ func loadConfigFile(cmd *cobra.Command, vpr *viper.Viper) error {
 		return nil
 	}
 	vpr.SetConfigFile(configPath)
-	err := vpr.ReadInConfig()
+	// ${VAR} references are expanded before the file is parsed.
+	b, err := readConfigFile(configPath)
+	if err == nil {
+		err = vpr.ReadConfig(bytes.NewReader(b))
+	}
 	if err == nil || (errors.Is(err, os.ErrNotExist) && !userDefined) {
 		// The default config file can be missing.
 		return nil
@@ -195,33 +195,62 @@ var _ = Describe("flags", func() {
 				err := exampleCommand.Execute()
 				Expect(err).ToNot(HaveOccurred())
 				Expect(cfg).To(Equal(config.Server{
-					AnalyticsOptOut:         false,
-					Config:                  "testdata/server.yml",
-					LogLevel:                "debug",
-					BadgerLogLevel:          "error",
-					StoragePath:             "/var/lib/pyroscope",
-					APIBindAddr:             ":4040",
-					BaseURL:                 "",
-					CacheEvictThreshold:     0.25,
-					CacheEvictVolume:        0.33,
-					BadgerNoTruncate:        false,
-					DisablePprofEndpoint:    false,
-					EnableExperimentalAdmin: true,
-					NoAdhocUI:               false,
-					MaxNodesSerialization:   2048,
-					MaxNodesRender:          8192,
-					HideApplications:        []string{},
-					Retention:               0,
+					AnalyticsOptOut:           false,
+					AnalyticsMaxPayloadSize:   64 * bytesize.KB,
+					AnalyticsMemStatsInterval: time.Minute,
+					Config:                    "testdata/server.yml",
+					LogLevel:                  "debug",
+					BadgerLogLevel:            "error",
+					LogFormat:                 "text",
+					LogLevels:                 map[string]string{},
+					StoragePath:               "/var/lib/pyroscope",
+					StorageBackend:            "badger",
+					APIBindAddr:               ":4040",
+					BaseURL:                   "",
+					CacheEvictThreshold:       0.25,
+					CacheEvictVolume:          0.33,
+					BadgerNoTruncate:          false,
+					DisablePprofEndpoint:      false,
+					EnableExperimentalAdmin:   true,
+					NoAdhocUI:                 false,
+					MaxNodesSerialization:     2048,
+					MaxNodesRender:            8192,
+					StorageTreeFormat:         1,
+					HideApplications:          []string{},
+					ClusterPeers:              []string{},
+					ClusterProbeInterval:      5 * time.Second,
+					Retention:                 0,
 					RetentionLevels: config.RetentionLevels{
 						Zero: 100 * time.Second,
 						One:  1000 * time.Second,
 					},
-					SampleRate:          0,
-					OutOfSpaceThreshold: 0,
-					CacheDimensionSize:  0,
-					CacheDictionarySize: 0,
-					CacheSegmentSize:    0,
-					CacheTreeSize:       0,
+					AppRetention: map[string]string{
+						"*.staging.*": "3d",
+						"payments.*":  "90d",
+					},
+					SymbolMappings:               map[string]string{},
+					DownsamplingResolution:       10 * time.Minute,
+					StorageDiskUsageLowWatermark: 0.9,
+					StorageQuota:                 map[string]string{},
+					StorageWALFsync:              "always",
+					BadgerValueLogFileSize:       bytesize.GB,
+					BadgerCompression:            "zstd",
+					BadgerNumCompactors:          2,
+					CompactionInterval:           5 * time.Minute,
+					StorageWALFsyncInterval:      time.Second,
+					ObjectStorageMinAge:          24 * time.Hour,
+					SnapshotShippingInterval:     time.Minute,
+					StandbyPollInterval:          10 * time.Second,
+					ShutdownTimeout:              30 * time.Second,
+					HealthMinFreeSpace:           512 * bytesize.MB,
+					SampleRate:                   0,
+					OutOfSpaceThreshold:          0,
+					CacheDimensionSize:           0,
+					CacheDictionarySize:          0,
+					CacheSegmentSize:             0,
+					CacheTreeSize:                0,
+
+					StorageCardinalityLimitAction: "reject",
 					Auth: config.Auth{
 						Google: config.GoogleOauth{
 							Enabled:        false,
@@ -251,6 +280,21 @@ var _ = Describe("flags", func() {
 							TokenURL:             "https://github.com/login/oauth/access_token",
 							AllowedOrganizations: []string{},
 						},
+						OIDC: config.OIDCOauth{
+							Enabled:       false,
+							Name:          "SSO",
+							IssuerURL:     "",
+							ClientID:      "",
+							ClientSecret:  "",
+							RedirectURL:   "",
+							Scopes:        []string{},
+							GroupsClaim:   "groups",
+							AllowedGroups: []string{},
+						},
+						APIKeys: config.APIKeysAuth{
+							Enabled:  false,
+							AdminKey: "",
+						},
 						JWTSecret:                "",
 						LoginMaximumLifetimeDays: 0,
 					},
@@ -264,6 +308,11 @@ var _ = Describe("flags", func() {
 					},
 					AdminSocketPath: "/tmp/pyroscope.sock",
 
+					StorageEncryptionKeyRotationInterval: 240 * time.Hour,
+					StorageQueryCacheMaxEntries:          1000,
+					IngestSigningSecrets:                 map[string]string{},
+					IngestSignatureMaxAge:                5 * time.Minute,
+
 					ScrapeConfigs: []*scrape.Config{
 						{
 							JobName:          "testing",
@@ -1,12 +1,18 @@
 package cli
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"runtime"
+	"strings"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/util/slices"
 )
 
 func InitLogging() {
@@ -20,3 +26,84 @@ func InitLogging() {
 		color.NoColor = true
 	}
 }
+
+// Subsystems of the server with log levels configurable independently
+// (log-levels), the rest use log-level.
+const (
+	logStorage    = "storage"
+	logController = "controller"
+	logAnalytics  = "analytics"
+	logAgent      = "agent"
+)
+
+var logSubsystems = []string{logStorage, logController, logAnalytics, logAgent}
+
+// serverLoggers are the loggers of the server subsystems. They share the
+// output, formatter, and hooks of the root logger.
+type serverLoggers struct {
+	root       *logrus.Logger
+	subsystems map[string]*logrus.Logger
+}
+
+type logLevels struct {
+	root       logrus.Level
+	subsystems map[string]logrus.Level
+}
+
+func newServerLoggers(root *logrus.Logger, c *config.Server) (*serverLoggers, error) {
+	switch c.LogFormat {
+	case "", "text":
+	case "json":
+		root.SetFormatter(&logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano})
+	default:
+		return nil, fmt.Errorf("invalid log format %q: must be text or json", c.LogFormat)
+	}
+	l := serverLoggers{root: root, subsystems: make(map[string]*logrus.Logger, len(logSubsystems))}
+	levels, err := l.parseLevels(c)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range logSubsystems {
+		x := logrus.New()
+		x.Out = root.Out
+		x.Formatter = root.Formatter
+		x.Hooks = root.Hooks
+		x.ReportCaller = root.ReportCaller
+		x.ExitFunc = root.ExitFunc
+		l.subsystems[name] = x
+	}
+	l.setLevels(levels)
+	return &l, nil
+}
+
+// parseLevels validates the log levels of the configuration.
+func (*serverLoggers) parseLevels(c *config.Server) (logLevels, error) {
+	var levels logLevels
+	var err error
+	if levels.root, err = logrus.ParseLevel(c.LogLevel); err != nil {
+		return levels, err
+	}
+	levels.subsystems = make(map[string]logrus.Level, len(c.LogLevels))
+	for name, v := range c.LogLevels {
+		if !slices.StringContains(logSubsystems, name) {
+			return levels, fmt.Errorf("unknown log subsystem %q: must be one of %s", name, strings.Join(logSubsystems, "|"))
+		}
+		if levels.subsystems[name], err = logrus.ParseLevel(v); err != nil {
+			return levels, fmt.Errorf("log level of %s: %w", name, err)
+		}
+	}
+	return levels, nil
+}
+
+func (l *serverLoggers) setLevels(levels logLevels) {
+	l.root.SetLevel(levels.root)
+	for name, x := range l.subsystems {
+		if v, ok := levels.subsystems[name]; ok {
+			x.SetLevel(v)
+		} else {
+			x.SetLevel(levels.root)
+		}
+	}
+}
+
+func (l *serverLoggers) get(name string) *logrus.Logger { return l.subsystems[name] }
@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+)
+
+var _ = Describe("server loggers", func() {
+	It("applies levels of subsystems", func() {
+		root := logrus.New()
+		l, err := newServerLoggers(root, &config.Server{
+			LogLevel:  "info",
+			LogLevels: map[string]string{logStorage: "debug"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(root.GetLevel()).To(Equal(logrus.InfoLevel))
+		Expect(l.get(logStorage).GetLevel()).To(Equal(logrus.DebugLevel))
+		Expect(l.get(logController).GetLevel()).To(Equal(logrus.InfoLevel))
+
+		By("keeping the levels if any is invalid")
+		_, err = l.parseLevels(&config.Server{LogLevel: "warn", LogLevels: map[string]string{"foo": "debug"}})
+		Expect(err).To(HaveOccurred())
+		_, err = l.parseLevels(&config.Server{LogLevel: "warn", LogLevels: map[string]string{logAgent: "foo"}})
+		Expect(err).To(HaveOccurred())
+
+		levels, err := l.parseLevels(&config.Server{LogLevel: "warn"})
+		Expect(err).ToNot(HaveOccurred())
+		l.setLevels(levels)
+		Expect(l.get(logStorage).GetLevel()).To(Equal(logrus.WarnLevel))
+	})
+
+	It("writes JSON logs", func() {
+		var buf bytes.Buffer
+		root := logrus.New()
+		root.SetOutput(&buf)
+		l, err := newServerLoggers(root, &config.Server{LogLevel: "info", LogFormat: "json"})
+		Expect(err).ToNot(HaveOccurred())
+		l.get(logAnalytics).WithField("app", "app.cpu").Info("foo")
+
+		var e map[string]interface{}
+		Expect(json.Unmarshal(buf.Bytes(), &e)).To(Succeed())
+		Expect(e).To(HaveKeyWithValue("msg", "foo"))
+		Expect(e).To(HaveKeyWithValue("app", "app.cpu"))
+
+		_, err = newServerLoggers(logrus.New(), &config.Server{LogLevel: "info", LogFormat: "xml"})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,151 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	sc "github.com/pyroscope-io/pyroscope/pkg/scrape/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+)
+
+var errReloadNotSupported = errors.New("configuration reload is not supported")
+
+// ConfigLoader loads the server configuration into c, on reload.
+type ConfigLoader func(c *config.Server) error
+
+// Reload loads the configuration again and applies the settings that can
+// be changed without a restart: log levels, retention policies, scrape
+// configs, ingestion rate limits, and ingestion relabeling rules. Changes
+// of other settings take effect after the server is restarted. If any of
+// the settings can not be applied, the previous ones are restored. Applied
+// changes are written to the log.
+func (svc *serverService) Reload() ([]string, error) {
+	if svc.loadConfig == nil {
+		return nil, errReloadNotSupported
+	}
+	svc.reloadMutex.Lock()
+	defer svc.reloadMutex.Unlock()
+
+	var c config.Server
+	if err := svc.loadConfig(&c); err != nil {
+		return nil, fmt.Errorf("could not load config: %w", err)
+	}
+	if err := loadScrapeConfigsFromFile(&c); err != nil {
+		return nil, fmt.Errorf("could not load scrape configs from %s: %w", c.Config, err)
+	}
+
+	// The applied configuration may be in use concurrently:
+	// the reloaded settings are applied to a copy.
+	next := *svc.applied
+	next.LogLevel = c.LogLevel
+	next.LogLevels = c.LogLevels
+	next.Retention = c.Retention
+	next.RetentionLevels = c.RetentionLevels
+	next.AppRetention = c.AppRetention
+	next.DownsamplingAge = c.DownsamplingAge
+	next.DownsamplingResolution = c.DownsamplingResolution
+	next.ScrapeConfigs = c.ScrapeConfigs
+	next.IngestRateLimit = c.IngestRateLimit
+	next.IngestRateBurst = c.IngestRateBurst
+	next.IngestKeyRateLimit = c.IngestKeyRateLimit
+	next.IngestKeyRateBurst = c.IngestKeyRateBurst
+	next.IngestRelabelConfigs = c.IngestRelabelConfigs
+
+	if err := svc.applyConfig(&next); err != nil {
+		if rerr := svc.applyConfig(svc.applied); rerr != nil {
+			svc.logger.WithError(rerr).Error("failed to restore configuration")
+		}
+		return nil, err
+	}
+	changes := configChanges(svc.applied, &next)
+	svc.applied = &next
+
+	for _, x := range changes {
+		svc.logger.WithField("change", x).Info("applied configuration change")
+	}
+	svc.logger.WithField("changes", len(changes)).Info("configuration reloaded")
+	return changes, nil
+}
+
+// applyConfig applies the settings of the configuration that can be
+// reloaded. Log levels are validated before any other setting is applied.
+func (svc *serverService) applyConfig(c *config.Server) error {
+	logLevels, err := svc.loggers.parseLevels(c)
+	if err != nil {
+		return err
+	}
+	if err = svc.storage.ApplyRetention(storage.NewConfig(c)); err != nil {
+		return fmt.Errorf("could not apply retention: %w", err)
+	}
+	if err = svc.applyScrapeTargets(c); err != nil {
+		return fmt.Errorf("could not apply scrape configs: %w", err)
+	}
+	svc.loggers.setLevels(logLevels)
+	svc.controller.ApplyConfig(c)
+	return nil
+}
+
+// configChanges describes the differences in settings that can
+// be reloaded, e.g. "retention: 0s -> 720h0m0s".
+func configChanges(prev, cur *config.Server) []string {
+	changes := make([]string, 0)
+	options := []struct {
+		name  string
+		value func(*config.Server) interface{}
+	}{
+		{"log-level", func(c *config.Server) interface{} { return c.LogLevel }},
+		{"log-levels", func(c *config.Server) interface{} { return c.LogLevels }},
+		{"retention", func(c *config.Server) interface{} { return c.Retention }},
+		{"retention-levels", func(c *config.Server) interface{} { return c.RetentionLevels }},
+		{"app-retention", func(c *config.Server) interface{} { return c.AppRetention }},
+		{"downsampling-age", func(c *config.Server) interface{} { return c.DownsamplingAge }},
+		{"downsampling-resolution", func(c *config.Server) interface{} { return c.DownsamplingResolution }},
+		{"ingest-rate-limit", func(c *config.Server) interface{} { return c.IngestRateLimit }},
+		{"ingest-rate-burst", func(c *config.Server) interface{} { return c.IngestRateBurst }},
+		{"ingest-key-rate-limit", func(c *config.Server) interface{} { return c.IngestKeyRateLimit }},
+		{"ingest-key-rate-burst", func(c *config.Server) interface{} { return c.IngestKeyRateBurst }},
+	}
+	for _, o := range options {
+		p, c := o.value(prev), o.value(cur)
+		if !reflect.DeepEqual(p, c) {
+			changes = append(changes, fmt.Sprintf("%s: %v -> %v", o.name, p, c))
+		}
+	}
+	changes = append(changes, scrapeConfigChanges(prev.ScrapeConfigs, cur.ScrapeConfigs)...)
+	if !reflect.DeepEqual(prev.IngestRelabelConfigs, cur.IngestRelabelConfigs) {
+		changes = append(changes, fmt.Sprintf("ingest-relabel-configs: %d -> %d rules",
+			len(prev.IngestRelabelConfigs), len(cur.IngestRelabelConfigs)))
+	}
+	return changes
+}
+
+func scrapeConfigChanges(prev, cur []*sc.Config) []string {
+	jobs := func(cfgs []*sc.Config) map[string]*sc.Config {
+		m := make(map[string]*sc.Config, len(cfgs))
+		for _, c := range cfgs {
+			m[c.JobName] = c
+		}
+		return m
+	}
+	p, c := jobs(prev), jobs(cur)
+	changes := make([]string, 0)
+	for name, x := range c {
+		y, ok := p[name]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("scrape-configs: job %q added", name))
+		case !reflect.DeepEqual(x, y):
+			changes = append(changes, fmt.Sprintf("scrape-configs: job %q changed", name))
+		}
+	}
+	for name := range p {
+		if _, ok := c[name]; !ok {
+			changes = append(changes, fmt.Sprintf("scrape-configs: job %q removed", name))
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
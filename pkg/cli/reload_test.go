@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/cobra"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	scrape "github.com/pyroscope-io/pyroscope/pkg/scrape/config"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/relabel"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("config reload", func() {
+	It("loads the config file again", func() {
+		tmpDir := testing.TmpDirSync()
+		defer tmpDir.Close()
+		path := filepath.Join(tmpDir.Path, "server.yml")
+		Expect(os.WriteFile(path, []byte("log-level: warn\nretention: 1h\n"), 0o600)).To(Succeed())
+
+		var cfg, reloaded config.Server
+		vpr := NewViper("pyroscope")
+		cmd := &cobra.Command{
+			DisableFlagParsing: true,
+			RunE: CreateCmdRunFn(&cfg, vpr, func(cmd *cobra.Command, _ []string) error {
+				Expect(cfg.Retention).To(Equal(time.Hour))
+				Expect(os.WriteFile(path, []byte("log-level: warn\nretention: 2h\n"), 0o600)).To(Succeed())
+				return LoadConfig(cmd, vpr, &reloaded)
+			}),
+		}
+		PopulateFlagSet(&cfg, cmd.Flags(), vpr, WithSkip("scrape-configs"))
+		cmd.SetArgs([]string{"--config=" + path, "--log-level=debug"})
+		Expect(cmd.Execute()).To(Succeed())
+
+		Expect(reloaded.Retention).To(Equal(2 * time.Hour))
+		By("keeping command line arguments precedence")
+		Expect(reloaded.LogLevel).To(Equal("debug"))
+	})
+
+	It("describes the changes", func() {
+		prev := config.Server{
+			LogLevel:      "info",
+			AppRetention:  map[string]string{"*.staging.*": "3d"},
+			ScrapeConfigs: []*scrape.Config{{JobName: "a"}, {JobName: "b"}},
+		}
+		cur := config.Server{
+			LogLevel:             "debug",
+			Retention:            time.Hour,
+			AppRetention:         map[string]string{"*.staging.*": "3d"},
+			ScrapeConfigs:        []*scrape.Config{{JobName: "b", ScrapeInterval: time.Second}, {JobName: "c"}},
+			IngestRelabelConfigs: []*relabel.Config{{Action: relabel.Drop}},
+		}
+		Expect(configChanges(&prev, &cur)).To(Equal([]string{
+			"log-level: info -> debug",
+			"retention: 0s -> 1h0m0s",
+			`scrape-configs: job "a" removed`,
+			`scrape-configs: job "b" changed`,
+			`scrape-configs: job "c" added`,
+			"ingest-relabel-configs: 0 -> 1 rules",
+		}))
+		Expect(configChanges(&cur, &cur)).To(BeEmpty())
+	})
+})
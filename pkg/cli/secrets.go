@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+)
+
+// envVarRe matches ${VAR} references in configuration files. Unlike
+// os.ExpandEnv, $VAR and ${1} are not expanded, as they are used in
+// e.g. relabeling rules.
+var envVarRe = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// readConfigFile reads the configuration file and replaces ${VAR} references
+// with values of the environment variables. References to variables that
+// are not set are left intact.
+func readConfigFile(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return expandEnv(b), nil
+}
+
+func expandEnv(b []byte) []byte {
+	return envVarRe.ReplaceAllFunc(b, func(ref []byte) []byte {
+		if v, ok := os.LookupEnv(string(envVarRe.FindSubmatch(ref)[1])); ok {
+			return []byte(v)
+		}
+		return ref
+	})
+}
+
+var configPkgPath = reflect.TypeOf(config.Server{}).PkgPath()
+
+// loadSecretFiles sets string fields of the configuration to the content
+// of the files specified with the corresponding <Name>File fields, e.g.
+// AuthToken is read from AuthTokenFile (auth-token-file), so that secrets
+// can be kept out of configuration files and command lines. It is an
+// error to specify both the value and the file.
+//
+// Only structs defined in the config package are visited.
+func loadSecretFiles(cfg interface{}) error {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Ptr {
+		return nil
+	}
+	return loadSecretFilesValue(v.Elem())
+}
+
+func loadSecretFilesValue(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return loadSecretFilesValue(v.Elem())
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := loadSecretFilesValue(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		if v.Type().PkgPath() != configPkgPath {
+			return nil
+		}
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			if err := loadSecretFilesValue(v.Field(i)); err != nil {
+				return err
+			}
+			if f.Type.Kind() != reflect.String || !strings.HasSuffix(f.Name, "File") {
+				continue
+			}
+			target, ok := t.FieldByName(strings.TrimSuffix(f.Name, "File"))
+			if !ok || target.Type.Kind() != reflect.String {
+				continue
+			}
+			if err := loadSecretFile(v.FieldByIndex(target.Index), v.Field(i), fieldName(target), fieldName(f)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func loadSecretFile(value, file reflect.Value, valueName, fileName string) error {
+	path := file.String()
+	if path == "" {
+		return nil
+	}
+	if value.String() != "" {
+		return fmt.Errorf("%s and %s are mutually exclusive", valueName, fileName)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fileName, err)
+	}
+	value.SetString(strings.TrimRight(string(b), "\r\n"))
+	return nil
+}
+
+func fieldName(f reflect.StructField) string {
+	for _, tag := range []string{"mapstructure", "yaml"} {
+		if n := f.Tag.Get(tag); n != "" && n != "-" {
+			return n
+		}
+	}
+	return f.Name
+}
@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/spf13/cobra"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("secrets", func() {
+	var (
+		tmpDir *testing.TmpDirectory
+		dir    string
+	)
+
+	BeforeEach(func() {
+		tmpDir = testing.TmpDirSync()
+		dir = tmpDir.Path
+	})
+
+	AfterEach(func() {
+		tmpDir.Close()
+	})
+
+	writeFile := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		Expect(os.WriteFile(p, []byte(content), 0600)).To(Succeed())
+		return p
+	}
+
+	run := func(cfg *config.Server, args ...string) error {
+		vpr := NewViper("PYROSCOPE")
+		cmd := &cobra.Command{SilenceUsage: true, SilenceErrors: true}
+		cmd.RunE = CreateCmdRunFn(cfg, vpr, func(*cobra.Command, []string) error { return nil })
+		PopulateFlagSet(cfg, cmd.Flags(), vpr)
+		cmd.SetArgs(args)
+		return cmd.Execute()
+	}
+
+	It("expands environment variables in the config file", func() {
+		Expect(os.Setenv("PYROSCOPE_TEST_ADMIN_KEY", "key-from-env")).To(Succeed())
+		defer os.Unsetenv("PYROSCOPE_TEST_ADMIN_KEY")
+		p := writeFile("server.yml", `
+base-url: "${PYROSCOPE_TEST_UNDEFINED}"
+auth:
+  api-keys:
+    admin-key: "${PYROSCOPE_TEST_ADMIN_KEY}"
+`)
+		var cfg config.Server
+		Expect(run(&cfg, "--config", p)).To(Succeed())
+		Expect(cfg.Auth.APIKeys.AdminKey).To(Equal("key-from-env"))
+		Expect(cfg.BaseURL).To(Equal("${PYROSCOPE_TEST_UNDEFINED}"))
+	})
+
+	It("reads secrets from files", func() {
+		keyFile := writeFile("admin-key", "key-from-file\n")
+		secretFile := writeFile("client-secret", "secret")
+		p := writeFile("server.yml", `
+auth:
+  oidc:
+    client-secret-file: `+secretFile+`
+`)
+		var cfg config.Server
+		Expect(run(&cfg, "--config", p, "--auth.api-keys.admin-key-file", keyFile)).To(Succeed())
+		Expect(cfg.Auth.APIKeys.AdminKey).To(Equal("key-from-file"))
+		Expect(cfg.Auth.OIDC.ClientSecret).To(Equal("secret"))
+	})
+
+	It("does not allow both the value and the file", func() {
+		keyFile := writeFile("admin-key", "key-from-file")
+		var cfg config.Server
+		err := run(&cfg, "--auth.api-keys.admin-key", "key", "--auth.api-keys.admin-key-file", keyFile)
+		Expect(err).To(MatchError("admin-key and admin-key-file are mutually exclusive"))
+	})
+
+	It("reads auth tokens of remote write targets from files", func() {
+		tokenFile := writeFile("token", "token-from-file")
+		cfg := config.Server{Config: writeFile("server.yml", `
+remote-write:
+  - address: http://localhost:4040
+    auth-token-file: `+tokenFile+`
+`)}
+		Expect(loadScrapeConfigsFromFile(&cfg)).To(Succeed())
+		Expect(cfg.RemoteWrite).To(HaveLen(1))
+		Expect(cfg.RemoteWrite[0].AuthToken).To(Equal("token-from-file"))
+	})
+})
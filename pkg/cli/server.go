@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -25,12 +26,12 @@ import (
 	"github.com/pyroscope-io/pyroscope/pkg/config"
 	"github.com/pyroscope-io/pyroscope/pkg/exporter"
 	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/remotewrite"
 	"github.com/pyroscope-io/pyroscope/pkg/scrape"
 	sc "github.com/pyroscope-io/pyroscope/pkg/scrape/config"
 	"github.com/pyroscope-io/pyroscope/pkg/scrape/discovery"
 	"github.com/pyroscope-io/pyroscope/pkg/server"
 	"github.com/pyroscope-io/pyroscope/pkg/storage"
-	"github.com/pyroscope-io/pyroscope/pkg/util/bytesize"
 	"github.com/pyroscope-io/pyroscope/pkg/util/debug"
 )
 
@@ -41,50 +42,64 @@ type Server struct {
 type serverService struct {
 	config               *config.Server
 	logger               *logrus.Logger
+	loggers              *serverLoggers
 	controller           *server.Controller
 	storage              *storage.Storage
 	directUpstream       *direct.Direct
 	directScrapeUpstream *direct.Direct
-	analyticsService     *analytics.Service
+	analyticsService     analytics.Service
 	selfProfiling        *agent.ProfileSession
 	debugReporter        *debug.Reporter
 	healthController     *health.Controller
 	adminServer          *admin.Server
 	discoveryManager     *discovery.Manager
 	scrapeManager        *scrape.Manager
+	remoteWriter         *remotewrite.RemoteWriter
+
+	// loadConfig is optional: the configuration can't be reloaded if nil.
+	loadConfig  ConfigLoader
+	reloadMutex sync.Mutex
+	// applied is the configuration applied on the last reload, or the
+	// initial one. It is shared with the controller: on reload it is
+	// replaced with a copy, and never modified.
+	applied *config.Server
 
 	stopped chan struct{}
 	done    chan struct{}
 	group   *errgroup.Group
+
+	// shutdownReason is set before stopped is closed.
+	shutdownReason string
 }
 
-func newServerService(c *config.Server) (*serverService, error) {
-	logLevel, err := logrus.ParseLevel(c.LogLevel)
+func newServerService(c *config.Server, load ConfigLoader) (*serverService, error) {
+	logger := logrus.StandardLogger()
+	loggers, err := newServerLoggers(logger, c)
 	if err != nil {
 		return nil, err
 	}
 
-	logger := logrus.StandardLogger()
-	logger.SetLevel(logLevel)
-
 	if err = loadScrapeConfigsFromFile(c); err != nil {
 		return nil, fmt.Errorf("could not load scrape config: %w", err)
 	}
 
 	svc := serverService{
-		config:  c,
-		logger:  logger,
-		stopped: make(chan struct{}),
-		done:    make(chan struct{}),
+		config:     c,
+		applied:    c,
+		logger:     logger,
+		loggers:    loggers,
+		loadConfig: load,
+		stopped:    make(chan struct{}),
+		done:       make(chan struct{}),
 	}
 
 	diskPressure := health.DiskPressure{
-		Threshold: 512 * bytesize.MB,
+		Threshold: c.HealthMinFreeSpace,
 		Path:      c.StoragePath,
 	}
 
 	svc.healthController = health.NewController(svc.logger, time.Minute, diskPressure)
-	svc.storage, err = storage.New(storage.NewConfig(svc.config), svc.logger, prometheus.DefaultRegisterer, svc.healthController)
+	svc.storage, err = storage.New(storage.NewConfig(svc.config), loggers.get(logStorage), prometheus.DefaultRegisterer, svc.healthController)
 	if err != nil {
 		return nil, fmt.Errorf("new storage: %w", err)
 	}
@@ -130,7 +145,8 @@ func newServerService(c *config.Server) (*serverService, error) {
 	svc.directUpstream = direct.New(svc.storage, metricsExporter)
 	svc.directScrapeUpstream = direct.New(svc.storage, metricsExporter)
 
-	if !svc.config.NoSelfProfiling {
+	// Profiles of the server itself can't be stored in read-only mode.
+	if !svc.config.NoSelfProfiling && !svc.config.ReadOnly {
 		svc.selfProfiling, _ = agent.NewSession(agent.SessionConfig{
 			Upstream:       svc.directUpstream,
 			AppName:        "pyroscope.server",
@@ -138,15 +154,26 @@ func newServerService(c *config.Server) (*serverService, error) {
 			SpyName:        types.GoSpy,
 			SampleRate:     100,
 			UploadRate:     10 * time.Second,
-			Logger:         logger,
+			Logger:         loggers.get(logAgent),
 		})
 	}
 
 	defaultMetricsRegistry := prometheus.DefaultRegisterer
+	var remoteWriter server.RemoteWriter
+	if len(svc.config.RemoteWrite) > 0 {
+		svc.remoteWriter, err = remotewrite.New(svc.logger.WithField("component", "remote-write"), svc.config.RemoteWrite, defaultMetricsRegistry)
+		if err != nil {
+			return nil, fmt.Errorf("new remote writer: %w", err)
+		}
+		remoteWriter = svc.remoteWriter
+	}
+
 	svc.controller, err = server.New(server.Config{
 		Configuration:   svc.config,
 		Storage:         svc.storage,
 		MetricsExporter: metricsExporter,
+		RemoteWriter:    remoteWriter,
+		Reloader:        &svc,
 		Notifier:        svc.healthController,
 		Adhoc: adhocserver.New(
 			svc.logger,
@@ -154,7 +181,7 @@ func newServerService(c *config.Server) (*serverService, error) {
 			svc.config.MaxNodesRender,
 			!svc.config.NoAdhocUI,
 		),
-		Logger:                  svc.logger,
+		Logger:                  loggers.get(logController),
 		MetricsRegisterer:       defaultMetricsRegistry,
 		ExportedMetricsRegistry: exportedMetricsRegistry,
 	})
@@ -170,8 +197,9 @@ func newServerService(c *config.Server) (*serverService, error) {
 		svc.storage,
 		defaultMetricsRegistry)
 
-	if !c.AnalyticsOptOut {
-		svc.analyticsService = analytics.NewService(c, svc.storage, svc.controller)
+	svc.analyticsService, err = analytics.NewService(c, svc.storage, svc.controller, defaultMetricsRegistry, loggers.get(logAnalytics))
+	if err != nil {
+		return nil, fmt.Errorf("new analytics service: %w", err)
 	}
 
 	return &svc, nil
@@ -194,15 +222,16 @@ func (svc *serverService) Start() error {
 	}
 
 	go svc.debugReporter.Start()
-	if svc.analyticsService != nil {
-		go svc.analyticsService.Start()
+	go svc.analyticsService.Start()
+	if svc.remoteWriter != nil {
+		svc.remoteWriter.Start()
 	}
 
 	svc.healthController.Start()
 	svc.directUpstream.Start()
 	svc.directScrapeUpstream.Start()
 
-	if !svc.config.NoSelfProfiling {
+	if svc.selfProfiling != nil {
 		if err := svc.selfProfiling.Start(); err != nil {
 			svc.logger.WithError(err).Error("failed to start self-profiling")
 		}
@@ -223,32 +252,43 @@ func (svc *serverService) Start() error {
 	})
 
 	defer close(svc.done)
+	var reason string
 	select {
 	case <-svc.stopped:
+		reason = svc.shutdownReason
 	case <-ctx.Done():
 		// The context is canceled the first time a function passed to Go
 		// returns a non-nil error.
+		reason = analytics.ShutdownError
 	}
 	// N.B. internal components are de-initialized/disposed (if applicable)
 	// regardless of the exit reason. Once server is stopped, wait for all
 	// Go goroutines to finish.
-	svc.stop()
+	svc.stop(reason)
 	return svc.group.Wait()
 }
 
 func (svc *serverService) Stop() {
+	svc.stopWithReason(analytics.ShutdownClean)
+}
+
+func (svc *serverService) stopWithReason(reason string) {
+	svc.shutdownReason = reason
 	close(svc.stopped)
 	<-svc.done
 }
 
 //revive:disable-next-line:confusing-naming methods are different
-func (svc *serverService) stop() {
+func (svc *serverService) stop(reason string) {
 	if svc.config.EnableExperimentalAdmin {
 		svc.logger.Debug("stopping admin server")
 		if err := svc.adminServer.Stop(); err != nil {
 			svc.logger.WithError(err).Error("admin server stop")
 		}
 	}
+	// Ingestion and queries are completed before the storage is closed:
+	// in-memory segments and caches are flushed to disk on close.
+	svc.logger.Debug("draining http server")
 	svc.controller.Drain()
 	svc.logger.Debug("stopping discovery manager")
 	svc.discoveryManager.Stop()
@@ -257,12 +297,10 @@ func (svc *serverService) stop() {
 	svc.logger.Debug("stopping debug reporter")
 	svc.debugReporter.Stop()
 	svc.healthController.Stop()
-	if svc.analyticsService != nil {
-		svc.logger.Debug("stopping analytics service")
-		svc.analyticsService.Stop()
-	}
+	svc.logger.Debug("stopping analytics service")
+	svc.analyticsService.StopWithReason(reason)
 
-	if !svc.config.NoSelfProfiling {
+	if svc.selfProfiling != nil {
 		svc.logger.Debug("stopping self profiling")
 		svc.selfProfiling.Stop()
 	}
@@ -282,6 +320,10 @@ func (svc *serverService) stop() {
 	if err := svc.controller.Stop(); err != nil {
 		svc.logger.WithError(err).Error("controller stop")
 	}
+	if svc.remoteWriter != nil {
+		svc.logger.Debug("stopping remote writer")
+		svc.remoteWriter.Stop()
+	}
 }
 
 func (svc *serverService) ApplyConfig(c *config.Server) error {
@@ -292,6 +334,10 @@ func (svc *serverService) applyScrapeConfigs(c *config.Server) error {
 	if err := loadScrapeConfigsFromFile(c); err != nil {
 		return fmt.Errorf("could not load scrape configs from %s: %w", c.Config, err)
 	}
+	return svc.applyScrapeTargets(c)
+}
+
+func (svc *serverService) applyScrapeTargets(c *config.Server) error {
 	if err := svc.discoveryManager.ApplyConfig(discoveryConfigs(c.ScrapeConfigs)); err != nil {
 		// discoveryManager.ApplyConfig never return errors.
 		return err
@@ -308,7 +354,7 @@ func discoveryConfigs(cfg []*sc.Config) map[string]discovery.Configs {
 }
 
 func loadScrapeConfigsFromFile(c *config.Server) error {
-	b, err := os.ReadFile(c.Config)
+	b, err := readConfigFile(c.Config)
 	switch {
 	case err == nil:
 	case os.IsNotExist(err):
@@ -320,7 +366,15 @@ func loadScrapeConfigsFromFile(c *config.Server) error {
 	if err = yaml.Unmarshal(b, &s); err != nil {
 		return err
 	}
-	// Populate scrape configs.
+	// Populate scrape configs, remote write and federation targets,
+	// ingestion quotas, and ingestion relabeling rules.
 	c.ScrapeConfigs = s.ScrapeConfigs
-	return nil
+	c.RemoteWrite = s.RemoteWrite
+	c.Federation = s.Federation
+	c.IngestQuotas = s.IngestQuotas
+	c.IngestRelabelConfigs = s.IngestRelabelConfigs
+	if err = loadSecretFiles(&c.RemoteWrite); err != nil {
+		return err
+	}
+	return loadSecretFiles(&c.Federation)
 }
@@ -9,11 +9,14 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/pyroscope-io/pyroscope/pkg/analytics"
 	"github.com/pyroscope-io/pyroscope/pkg/config"
 )
 
-func NewServer(c *config.Server) (*Server, error) {
-	svc, err := newServerService(c)
+// NewServer creates a new server. load is optional: if specified, the
+// configuration is reloaded on SIGHUP and with /-/reload.
+func NewServer(c *config.Server, load ConfigLoader) (*Server, error) {
+	svc, err := newServerService(c, load)
 	if err != nil {
 		return nil, fmt.Errorf("could not initialize server: %w", err)
 	}
@@ -30,16 +33,23 @@ func (s *Server) Start() error {
 	}()
 
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 	for {
 		select {
 		case err := <-exited:
 			return err
 
-		case <-sigs:
+		case sig := <-sigs:
+			if sig == syscall.SIGHUP {
+				s.svc.logger.Info("reloading configuration")
+				if _, err := s.svc.Reload(); err != nil {
+					s.svc.logger.WithError(err).Error("failed to reload configuration")
+				}
+				continue
+			}
 			s.svc.logger.Info("stopping server")
 			stopTime := time.Now()
-			s.svc.Stop()
+			s.svc.stopWithReason(analytics.ShutdownSignal)
 			if err := <-exited; err != nil {
 				s.svc.logger.WithError(err).Error("failed to stop server gracefully")
 				return err
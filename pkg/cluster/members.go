@@ -0,0 +1,190 @@
+package cluster
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ProbeFunc checks whether the node is available.
+type ProbeFunc func(ctx context.Context, node string) error
+
+// Members tracks availability of the nodes of the cluster: the nodes are
+// probed periodically, and keys are distributed across the nodes that
+// are available. A node is also considered unavailable once a request
+// to it fails (see MarkDown), until it responds to a probe.
+//
+// Keys of an unavailable node move to other nodes, as if the node was
+// removed from the ring, and move back once the node is available again.
+// Note that the nodes probe each other independently, therefore they may
+// disagree on key owners for up to the probe interval.
+type Members struct {
+	self    string
+	nodes   []string
+	tokens  int
+	probe   ProbeFunc
+	timeout time.Duration
+
+	m    sync.RWMutex
+	down map[string]struct{}
+	ring *Ring
+
+	started uint32
+	stop    chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewMembers creates Members of the given nodes. The node self is always
+// considered available. All the nodes are available until probed.
+func NewMembers(self string, nodes []string, tokens int, probe ProbeFunc, timeout time.Duration) (*Members, error) {
+	ring, err := NewRing(nodes, tokens)
+	if err != nil {
+		return nil, err
+	}
+	return &Members{
+		self:    self,
+		nodes:   nodes,
+		tokens:  tokens,
+		probe:   probe,
+		timeout: timeout,
+		down:    make(map[string]struct{}),
+		ring:    ring,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Ring returns the ring of the nodes available.
+func (m *Members) Ring() *Ring {
+	m.m.RLock()
+	defer m.m.RUnlock()
+	return m.ring
+}
+
+// Available reports whether the node is available.
+func (m *Members) Available(node string) bool {
+	m.m.RLock()
+	defer m.m.RUnlock()
+	_, ok := m.down[node]
+	return !ok
+}
+
+// Down returns the nodes that are not available, sorted.
+func (m *Members) Down() []string {
+	m.m.RLock()
+	defer m.m.RUnlock()
+	down := make([]string, 0, len(m.down))
+	for n := range m.down {
+		down = append(down, n)
+	}
+	sort.Strings(down)
+	return down
+}
+
+// MarkDown marks the node unavailable until it responds to a probe.
+func (m *Members) MarkDown(node string) {
+	if node == m.self {
+		return
+	}
+	m.m.Lock()
+	defer m.m.Unlock()
+	if _, ok := m.down[node]; !ok {
+		m.down[node] = struct{}{}
+		m.rebuild()
+	}
+}
+
+// Probe probes all the nodes concurrently and updates the ring.
+func (m *Members) Probe(ctx context.Context) {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		down = make(map[string]struct{})
+	)
+	for _, n := range m.nodes {
+		if n == m.self {
+			continue
+		}
+		wg.Add(1)
+		go func(n string) {
+			defer wg.Done()
+			pctx, cancel := context.WithTimeout(ctx, m.timeout)
+			defer cancel()
+			if err := m.probe(pctx, n); err != nil {
+				mu.Lock()
+				down[n] = struct{}{}
+				mu.Unlock()
+			}
+		}(n)
+	}
+	wg.Wait()
+	m.m.Lock()
+	defer m.m.Unlock()
+	if !sameNodes(m.down, down) {
+		m.down = down
+		m.rebuild()
+	}
+}
+
+// Start probes the nodes with the given interval until Stop is called.
+func (m *Members) Start(interval time.Duration) {
+	atomic.StoreUint32(&m.started, 1)
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			<-m.stop
+			cancel()
+		}()
+		for {
+			m.Probe(ctx)
+			select {
+			case <-m.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops probing, if started, and waits for the probes in progress.
+func (m *Members) Stop() {
+	m.once.Do(func() {
+		close(m.stop)
+	})
+	if atomic.LoadUint32(&m.started) > 0 {
+		<-m.done
+	}
+}
+
+func (m *Members) rebuild() {
+	available := make([]string, 0, len(m.nodes))
+	for _, n := range m.nodes {
+		if _, ok := m.down[n]; !ok {
+			available = append(available, n)
+		}
+	}
+	// The node self is always available, therefore
+	// the list of nodes is never empty.
+	if r, err := NewRing(available, m.tokens); err == nil {
+		m.ring = r
+	}
+}
+
+func sameNodes(a, b map[string]struct{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for n := range a {
+		if _, ok := b[n]; !ok {
+			return false
+		}
+	}
+	return true
+}
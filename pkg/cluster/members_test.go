@@ -0,0 +1,94 @@
+package cluster_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/cluster"
+)
+
+var _ = Describe("Members", func() {
+	nodes := []string{"http://node-0:4040", "http://node-1:4040", "http://node-2:4040"}
+
+	var (
+		m    sync.Mutex
+		down map[string]bool
+	)
+
+	probe := func(_ context.Context, node string) error {
+		m.Lock()
+		defer m.Unlock()
+		if down[node] {
+			return errors.New("unavailable")
+		}
+		return nil
+	}
+
+	setDown := func(nodes ...string) {
+		m.Lock()
+		defer m.Unlock()
+		down = make(map[string]bool)
+		for _, n := range nodes {
+			down[n] = true
+		}
+	}
+
+	BeforeEach(func() {
+		setDown()
+	})
+
+	owners := func(r *cluster.Ring) map[string]int {
+		counts := make(map[string]int)
+		for _, k := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j"} {
+			counts[r.Get(k)]++
+		}
+		return counts
+	}
+
+	It("excludes unavailable nodes from the ring", func() {
+		ms, err := cluster.NewMembers(nodes[0], nodes, 0, probe, time.Second)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ms.Down()).To(BeEmpty())
+
+		ms.MarkDown(nodes[1])
+		Expect(ms.Available(nodes[1])).To(BeFalse())
+		Expect(owners(ms.Ring())).ToNot(HaveKey(nodes[1]))
+
+		By("probing the nodes")
+		setDown(nodes[2])
+		ms.Probe(context.Background())
+		Expect(ms.Down()).To(Equal([]string{nodes[2]}))
+		Expect(owners(ms.Ring())).ToNot(HaveKey(nodes[2]))
+
+		setDown()
+		ms.Probe(context.Background())
+		Expect(ms.Down()).To(BeEmpty())
+		Expect(owners(ms.Ring())).To(HaveLen(len(nodes)))
+	})
+
+	It("never excludes the node itself", func() {
+		setDown(nodes...)
+		ms, err := cluster.NewMembers(nodes[0], nodes, 0, probe, time.Second)
+		Expect(err).ToNot(HaveOccurred())
+		ms.MarkDown(nodes[0])
+		ms.Probe(context.Background())
+		Expect(ms.Down()).To(Equal(nodes[1:]))
+		Expect(owners(ms.Ring())).To(Equal(map[string]int{nodes[0]: 10}))
+	})
+
+	It("probes the nodes periodically", func() {
+		ms, err := cluster.NewMembers(nodes[0], nodes, 0, probe, time.Second)
+		Expect(err).ToNot(HaveOccurred())
+		ms.MarkDown(nodes[1])
+		ms.Start(10 * time.Millisecond)
+		Eventually(ms.Down).Should(BeEmpty())
+		setDown(nodes[2])
+		Eventually(ms.Down).Should(Equal([]string{nodes[2]}))
+		ms.Stop()
+	})
+})
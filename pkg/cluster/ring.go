@@ -0,0 +1,75 @@
+// Package cluster implements consistent hashing of application names
+// across servers of a cluster.
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// DefaultTokens is the number of tokens (virtual nodes) per server,
+// which makes the distribution of keys even enough.
+const DefaultTokens = 128
+
+var (
+	ErrNoNodes       = errors.New("cluster has no nodes")
+	ErrDuplicateNode = errors.New("duplicate cluster node")
+)
+
+// Ring maps keys to nodes: every node owns a number of tokens on the
+// ring, and a key belongs to the node owning the first token following
+// the key hash. When a node is added or removed, only keys of the
+// adjacent tokens move to another node.
+//
+// Ring does not depend on the order of nodes, therefore servers of the
+// cluster agree on key owners as long as they are given the same nodes.
+type Ring struct {
+	tokens []uint64
+	owners map[uint64]string
+}
+
+func NewRing(nodes []string, tokens int) (*Ring, error) {
+	if len(nodes) == 0 {
+		return nil, ErrNoNodes
+	}
+	if tokens <= 0 {
+		tokens = DefaultTokens
+	}
+	r := Ring{
+		tokens: make([]uint64, 0, len(nodes)*tokens),
+		owners: make(map[uint64]string, len(nodes)*tokens),
+	}
+	seen := make(map[string]struct{}, len(nodes))
+	for _, n := range nodes {
+		if _, ok := seen[n]; ok {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateNode, n)
+		}
+		seen[n] = struct{}{}
+		for i := 0; i < tokens; i++ {
+			t := xxhash.Sum64String(n + "#" + strconv.Itoa(i))
+			if o, ok := r.owners[t]; !ok {
+				r.tokens = append(r.tokens, t)
+			} else if o < n {
+				// Collisions are resolved deterministically.
+				continue
+			}
+			r.owners[t] = n
+		}
+	}
+	sort.Slice(r.tokens, func(i, j int) bool { return r.tokens[i] < r.tokens[j] })
+	return &r, nil
+}
+
+// Get returns the node owning the key.
+func (r *Ring) Get(key string) string {
+	h := xxhash.Sum64String(key)
+	i := sort.Search(len(r.tokens), func(i int) bool { return r.tokens[i] >= h })
+	if i == len(r.tokens) {
+		i = 0
+	}
+	return r.owners[r.tokens[i]]
+}
@@ -0,0 +1,60 @@
+package cluster_test
+
+import (
+	"strconv"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/cluster"
+)
+
+var _ = Describe("Ring", func() {
+	nodes := []string{"http://node-0:4040", "http://node-1:4040", "http://node-2:4040"}
+
+	keys := func(r *cluster.Ring) map[string]string {
+		m := make(map[string]string)
+		for i := 0; i < 3000; i++ {
+			k := "app-" + strconv.Itoa(i) + ".cpu"
+			m[k] = r.Get(k)
+		}
+		return m
+	}
+
+	It("distributes keys evenly", func() {
+		r, err := cluster.NewRing(nodes, 0)
+		Expect(err).ToNot(HaveOccurred())
+		counts := make(map[string]int)
+		for _, n := range keys(r) {
+			counts[n]++
+		}
+		Expect(counts).To(HaveLen(len(nodes)))
+		for _, c := range counts {
+			Expect(c).To(BeNumerically("~", 1000, 250))
+		}
+	})
+
+	It("does not depend on the order of nodes", func() {
+		a, _ := cluster.NewRing(nodes, 0)
+		b, _ := cluster.NewRing([]string{nodes[2], nodes[0], nodes[1]}, 0)
+		Expect(keys(a)).To(Equal(keys(b)))
+	})
+
+	It("only moves keys of the added node", func() {
+		a, _ := cluster.NewRing(nodes, 0)
+		b, _ := cluster.NewRing(append(nodes, "http://node-3:4040"), 0)
+		before, after := keys(a), keys(b)
+		for k, n := range after {
+			if n != "http://node-3:4040" {
+				Expect(n).To(Equal(before[k]))
+			}
+		}
+	})
+
+	It("rejects invalid nodes", func() {
+		_, err := cluster.NewRing(nil, 0)
+		Expect(err).To(MatchError(cluster.ErrNoNodes))
+		_, err = cluster.NewRing([]string{nodes[0], nodes[0]}, 0)
+		Expect(err).To(MatchError(cluster.ErrDuplicateNode))
+	})
+})
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	scrape "github.com/pyroscope-io/pyroscope/pkg/scrape/config"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/relabel"
 	"github.com/pyroscope-io/pyroscope/pkg/util/bytesize"
 )
 
@@ -66,10 +67,18 @@ type Agent struct {
 	LogLevel    string `def:"info" desc:"log level: debug|info|warn|error" mapstructure:"log-level"`
 	NoLogging   bool   `def:"false" desc:"disables logging from pyroscope" mapstructure:"no-logging"`
 
-	ServerAddress          string        `def:"http://localhost:4040" desc:"address of the pyroscope server" mapstructure:"server-address"`
-	AuthToken              string        `def:"" desc:"authorization token used to upload profiling data" mapstructure:"auth-token"`
-	UpstreamThreads        int           `def:"4" desc:"number of upload threads" mapstructure:"upstream-threads"`
-	UpstreamRequestTimeout time.Duration `def:"10s" desc:"profile upload timeout" mapstructure:"upstream-request-timeout"`
+	ServerAddress                string            `def:"http://localhost:4040" desc:"address of the pyroscope server" mapstructure:"server-address"`
+	AuthToken                    string            `def:"" desc:"authorization token used to upload profiling data" mapstructure:"auth-token"`
+	AuthTokenFile                string            `def:"" desc:"file with the authorization token used to upload profiling data, instead of auth-token" mapstructure:"auth-token-file"`
+	SigningKeyID                 string            `def:"" desc:"ID of the shared secret profile uploads are signed with. Must match the one configured on the server" mapstructure:"signing-key-id"`
+	SigningSecret                string            `def:"" desc:"shared secret profile uploads are signed with (HMAC-SHA256)" mapstructure:"signing-secret"`
+	SigningSecretFile            string            `def:"" desc:"file with the shared secret profile uploads are signed with, instead of signing-secret" mapstructure:"signing-secret-file"`
+	UpstreamThreads              int               `def:"4" desc:"number of upload threads" mapstructure:"upstream-threads"`
+	UpstreamRequestTimeout       time.Duration     `def:"10s" desc:"profile upload timeout" mapstructure:"upstream-request-timeout"`
+	UpstreamCompressionThreshold bytesize.ByteSize `def:"0" desc:"profiles larger than this size are uploaded gzip-compressed. The server must support Content-Encoding on /ingest. 0 disables compression" mapstructure:"upstream-compression-threshold"`
+	ServerCAFile                 string            `def:"" desc:"path to a PEM-encoded CA bundle used to verify the server certificate" mapstructure:"server-ca-file"`
+	ClientCertFile               string            `def:"" desc:"path to a PEM-encoded client certificate presented to the server" mapstructure:"client-cert-file"`
+	ClientKeyFile                string            `def:"" desc:"path to a PEM-encoded private key of the client certificate" mapstructure:"client-key-file"`
 
 	Targets []Target `yaml:"targets" desc:"list of targets to be profiled" mapstructure:"-"`
 
@@ -95,32 +104,125 @@ type Target struct {
 }
 
 type Server struct {
-	AnalyticsOptOut bool `def:"false" desc:"disables analytics" mapstructure:"analytics-opt-out"`
+	AnalyticsOptOut           bool              `def:"false" desc:"disables analytics" mapstructure:"analytics-opt-out"`
+	AnalyticsURL              string            `def:"" desc:"URL analytics reports are sent to, unix:///path/to/socket is supported. Pyroscope analytics endpoint is used by default" mapstructure:"analytics-url"`
+	AnalyticsBucketize        bool              `def:"false" desc:"report ingestion counters as coarse ranges (e.g. 1k-10k) instead of exact values" mapstructure:"analytics-bucketize"`
+	AnalyticsMaxPayloadSize   bytesize.ByteSize `def:"64KB" desc:"maximum size of analytics report. Optional fields are dropped from reports exceeding the limit. 0 means no limit" mapstructure:"analytics-max-payload-size"`
+	AnalyticsTrace            bool              `def:"false" desc:"log DNS, connection, and TLS handshake timings of analytics uploads at debug level" mapstructure:"analytics-trace"`
+	AnalyticsMemStatsInterval time.Duration     `def:"1m" desc:"minimum interval between memory statistics reads for analytics reports. 0 means the statistics are read on every snapshot" mapstructure:"analytics-mem-stats-interval"`
 
 	Config         string `def:"<installPrefix>/etc/pyroscope/server.yml" desc:"location of config file" mapstructure:"config"`
 	LogLevel       string `def:"info" desc:"log level: debug|info|warn|error" mapstructure:"log-level"`
 	BadgerLogLevel string `def:"error" desc:"log level: debug|info|warn|error" mapstructure:"badger-log-level"`
 
-	StoragePath string `def:"<installPrefix>/var/lib/pyroscope" desc:"directory where pyroscope stores profiling data" mapstructure:"storage-path"`
-	APIBindAddr string `def:":4040" desc:"port for the HTTP(S) server used for data ingestion and web UI" mapstructure:"api-bind-addr"`
-	BaseURL     string `def:"" desc:"base URL for when the server is behind a reverse proxy with a different path" mapstructure:"base-url"`
+	LogFormat string            `def:"text" desc:"log format: text|json" mapstructure:"log-format"`
+	LogLevels map[string]string `def:"" desc:"log levels of subsystems in subsystem=level form, e.g. storage=debug. Subsystems are storage|controller|analytics|agent; log-level applies to the rest. The flag may be specified multiple times" mapstructure:"log-levels"`
+
+	StoragePath    string `def:"<installPrefix>/var/lib/pyroscope" desc:"directory where pyroscope stores profiling data" mapstructure:"storage-path"`
+	StorageBackend string `def:"badger" desc:"key-value store used for profiling data: badger|memory. Data stored in memory is lost on restart" mapstructure:"storage-backend"`
+	InstallIDFile  string `def:"" desc:"path to a file containing the install ID. PYROSCOPE_INSTALL_ID environment variable takes precedence" mapstructure:"install-id-file"`
+	APIBindAddr    string `def:":4040" desc:"port for the HTTP(S) server used for data ingestion and web UI" mapstructure:"api-bind-addr"`
+	BaseURL        string `def:"" desc:"base URL for when the server is behind a reverse proxy with a different path" mapstructure:"base-url"`
 
 	CacheEvictThreshold float64 `def:"0.25" desc:"percentage of memory at which cache evictions start" mapstructure:"cache-evict-threshold"`
 	CacheEvictVolume    float64 `def:"0.33" desc:"percentage of cache that is evicted per eviction run" mapstructure:"cache-evict-volume"`
 
+	CacheTreesMaxSize      bytesize.ByteSize `def:"0" desc:"maximum estimated size of trees kept in cache. Least recently used trees are evicted to disk once exceeded. 0 means no limit" mapstructure:"cache-trees-max-size"`
+	CacheDimensionsMaxSize bytesize.ByteSize `def:"0" desc:"maximum estimated size of dimensions kept in cache. Least recently used dimensions are evicted to disk once exceeded. 0 means no limit" mapstructure:"cache-dimensions-max-size"`
+
 	// TODO: I don't think a lot of people will change these values.
 	//   I think these should just be constants.
 	BadgerNoTruncate     bool `def:"false" desc:"indicates whether value log files should be truncated to delete corrupt data, if any" mapstructure:"badger-no-truncate"`
 	DisablePprofEndpoint bool `def:"false" desc:"disables /debug/pprof route" mapstructure:"disable-pprof-endpoint"`
 
+	CompactionInterval  time.Duration     `def:"5m" desc:"interval at which databases are checked for compaction: value log garbage collection and, optionally, LSM tree merging" mapstructure:"compaction-interval"`
+	CompactionWindow    string            `def:"" desc:"local time of day range compaction is allowed to run within, e.g. 22:00-06:00. Empty means any time" mapstructure:"compaction-window"`
+	CompactionRateLimit bytesize.ByteSize `def:"0" desc:"maximum amount of value log data rewritten per second by compaction. 0 means no limit" mapstructure:"compaction-rate-limit"`
+	CompactionFlatten   bool              `def:"false" desc:"merge LSM tree levels of databases after value log garbage collection. The merge is not rate limited" mapstructure:"compaction-flatten"`
+
+	StoragePreloadApps int `def:"0" desc:"number of the most recently written applications whose indexes (dimensions and segments) are loaded on startup, so that the first queries are not slowed down. The server is not ready (/readyz) until the indexes are loaded. 0 disables preloading" mapstructure:"storage-preload-apps"`
+
+	DictionaryGCInterval time.Duration `def:"0" desc:"minimum interval between garbage collections of dictionaries (function names), performed along with retention. A collection rewrites trees of the applications. 0 disables the collection" mapstructure:"dictionary-gc-interval"`
+
+	// Badger options apply to every database (there are five of them).
+	BadgerBlockCacheSize   bytesize.ByteSize `def:"0" desc:"size of the cache of decompressed data blocks of each database. 0 disables the cache" mapstructure:"badger-block-cache-size"`
+	BadgerIndexCacheSize   bytesize.ByteSize `def:"0" desc:"size of the cache of table indices of each database. 0 means all the indices are kept in memory" mapstructure:"badger-index-cache-size"`
+	BadgerValueLogFileSize bytesize.ByteSize `def:"1GB" desc:"maximum size of a value log file, up to 2GB" mapstructure:"badger-value-log-file-size"`
+	BadgerCompression      string            `def:"zstd" desc:"compression of data blocks: none|snappy|zstd" mapstructure:"badger-compression"`
+	BadgerNumCompactors    int               `def:"2" desc:"number of concurrent compaction workers of each database. Must be at least 2" mapstructure:"badger-num-compactors"`
+
 	MaxNodesSerialization int `def:"2048" desc:"max number of nodes used when saving profiles to disk" mapstructure:"max-nodes-serialization"`
 	MaxNodesRender        int `def:"8192" desc:"max number of nodes used to display data on the frontend" mapstructure:"max-nodes-render"`
+	StorageTreeFormat     int `def:"1" desc:"format profiles are saved to disk in: 1 (row-oriented) or 2 (column-oriented, faster to load and merge). Profiles in either format are readable, and are rewritten in the configured one once updated" mapstructure:"storage-tree-format"`
+
+	StorageEncryptionKeyFile             string        `def:"" desc:"file with the AES key (16, 24, or 32 bytes, raw or hex-encoded) profiling data is encrypted with. Existing storage must be encrypted with 'pyroscope admin storage rotate-key' first. Disabled by default" mapstructure:"storage-encryption-key-file"`
+	StorageEncryptionKeyRotationInterval time.Duration `def:"240h" desc:"interval at which the keys data is encrypted with are rotated. The keys are stored encrypted with the storage encryption key" mapstructure:"storage-encryption-key-rotation-interval"`
+
+	StorageQueryCacheTTL        time.Duration `def:"0s" desc:"time results of queries are cached for. Cached results are invalidated on writes to the application within the time range of the query. 0 disables the cache" mapstructure:"storage-query-cache-ttl"`
+	StorageQueryCacheMaxEntries int           `def:"1000" desc:"maximum number of query results cached" mapstructure:"storage-query-cache-max-entries"`
+
+	StorageTreeShardDuration time.Duration `def:"0" desc:"time range of storage shards, e.g. 24h or 168h: profiles of every range are stored in a separate database in the trees.shards directory, and data out of retention is removed with the shard directory. Shards may be moved to other disks (and symlinked) while the server is stopped. Can only be set for a new storage, and can't be changed. 0 disables sharding" mapstructure:"storage-tree-shard-duration"`
+
+	IngestMaxBodySize  bytesize.ByteSize `def:"0" desc:"maximum size of ingestion request body. Larger requests are rejected with 413. 0 means no limit" mapstructure:"ingest-max-body-size"`
+	IngestRateLimit    float64           `def:"0" desc:"maximum number of ingestion requests per second per application. Requests exceeding the limit are rejected with 429. 0 means no limit" mapstructure:"ingest-rate-limit"`
+	IngestRateBurst    int               `def:"0" desc:"maximum burst of ingestion requests per application. Defaults to the rate limit" mapstructure:"ingest-rate-burst"`
+	IngestKeyRateLimit float64           `def:"0" desc:"maximum number of ingestion requests per second per API key, across all the applications. Requests exceeding the limit are rejected with 429. 0 means no limit" mapstructure:"ingest-key-rate-limit"`
+	IngestKeyRateBurst int               `def:"0" desc:"maximum burst of ingestion requests per API key. Defaults to the key rate limit" mapstructure:"ingest-key-rate-burst"`
+
+	IngestAllowedCIDRs []string `def:"" desc:"list of networks (CIDR blocks or IP addresses) ingestion requests are accepted from. Requests from other addresses are rejected with 403. Empty means any address" mapstructure:"ingest-allowed-cidrs"`
+	IngestDeniedCIDRs  []string `def:"" desc:"list of networks (CIDR blocks or IP addresses) ingestion requests are rejected from with 403, even if allowed" mapstructure:"ingest-denied-cidrs"`
+	UIAllowedCIDRs     []string `def:"" desc:"list of networks (CIDR blocks or IP addresses) the UI and API, except for ingestion, health checks and metrics, are accessible from. Empty means any address" mapstructure:"ui-allowed-cidrs"`
+	UIDeniedCIDRs      []string `def:"" desc:"list of networks (CIDR blocks or IP addresses) the UI and API, except for ingestion, health checks and metrics, are not accessible from, even if allowed" mapstructure:"ui-denied-cidrs"`
+
+	IngestSigningSecrets  map[string]string `json:"-" def:"" desc:"shared secrets ingestion requests must be signed with, in key-id=secret form. If specified, requests without a valid signature are rejected with 401. The flag may be specified multiple times" mapstructure:"ingest-signing-secrets"`
+	IngestSignatureMaxAge time.Duration     `def:"5m" desc:"maximum difference between the time an ingestion request is signed at and the server time. Protects against replay of signed requests. 0 disables the check" mapstructure:"ingest-signature-max-age"`
 
 	// currently only used in our demo app
 	HideApplications []string `def:"" desc:"please don't use, this will soon be deprecated" mapstructure:"hide-applications"`
 
-	Retention       time.Duration   `def:"" desc:"sets the maximum amount of time the profiling data is stored for. Data before this threshold is deleted. Disabled by default" mapstructure:"retention"`
-	RetentionLevels RetentionLevels `def:"" desc:"specifies how long the profiling data stored per aggregation level. Disabled by default" mapstructure:"retention-levels"`
+	Retention       time.Duration     `def:"" desc:"sets the maximum amount of time the profiling data is stored for. Data before this threshold is deleted. Disabled by default" mapstructure:"retention"`
+	RetentionLevels RetentionLevels   `def:"" desc:"specifies how long the profiling data stored per aggregation level. Disabled by default" mapstructure:"retention-levels"`
+	AppRetention    map[string]string `def:"" desc:"retention period per application name glob in pattern=period form, e.g. *.staging.*=3d. Overrides retention for matching applications; if several patterns match, the longest one is used. The flag may be specified multiple times" mapstructure:"app-retention"`
+
+	SymbolMappings map[string]string `def:"" desc:"symbol mapping file per application name glob in pattern=type:path form, e.g. android.*=proguard:/etc/pyroscope/mapping.txt. Frame names of profiles rendered with deobfuscate=true are mapped to the original ones. Supported types are proguard (ProGuard and R8 mapping files) and names (tab-separated obfuscated and original names); if several patterns match, the longest one is used. The flag may be specified multiple times" mapstructure:"symbol-mappings"`
+
+	DownsamplingAge        time.Duration `def:"" desc:"age after which profiling data is only kept at downsampling-resolution: trees of finer resolution are removed. Disabled by default" mapstructure:"downsampling-age"`
+	DownsamplingResolution time.Duration `def:"10m" desc:"resolution profiling data older than downsampling-age is kept at. Rounded up to one of 10s, 100s, 1000s, 10000s, and so on" mapstructure:"downsampling-resolution"`
+
+	StorageMaxDiskUsage          string            `def:"" desc:"maximum disk space occupied by profiling data, in bytes (e.g. 100GB) or percentage of the disk size (e.g. 80%). When exceeded, the oldest data is removed. Disabled by default" mapstructure:"storage-max-disk-usage"`
+	StorageDiskUsageLowWatermark float64           `def:"0.9" desc:"fraction of storage-max-disk-usage (or storage-quota) the disk usage is reduced to when the limit is exceeded" mapstructure:"storage-disk-usage-low-watermark"`
+	StorageQuota                 map[string]string `def:"" desc:"maximum size of profiling data per application name glob in pattern=size form, e.g. *.alloc_space=10GB. Application names end with the profile type, so quotas may be set per profile type. When exceeded, the oldest data of the application is removed; if several patterns match, the longest one is used. The flag may be specified multiple times" mapstructure:"storage-quota"`
+
+	StorageMaxTagValues           int    `def:"0" desc:"maximum number of distinct values of a tag per application. 0 means no limit" mapstructure:"storage-max-tag-values"`
+	StorageMaxSeries              int    `def:"0" desc:"maximum number of series (distinct tag sets) per application. 0 means no limit" mapstructure:"storage-max-series"`
+	StorageCardinalityLimitAction string `def:"reject" desc:"what is done with profiles exceeding storage-max-tag-values or storage-max-series: reject|overflow. With overflow, values of the offending tags are replaced with __overflow__" mapstructure:"storage-cardinality-limit-action"`
+
+	StorageWAL              bool          `def:"false" desc:"enables the write-ahead log: ingested profiles are journaled before being acknowledged and replayed on startup after a crash" mapstructure:"storage-wal"`
+	StorageWALFsync         string        `def:"always" desc:"when the write-ahead log is synced to disk: always|interval|never. With interval, profiles ingested within storage-wal-fsync-interval may be lost if the host crashes" mapstructure:"storage-wal-fsync"`
+	StorageWALFsyncInterval time.Duration `def:"1s" desc:"interval at which the write-ahead log is synced to disk if storage-wal-fsync is interval" mapstructure:"storage-wal-fsync-interval"`
+
+	ObjectStorageURL      string        `def:"" desc:"object storage old profiling data is offloaded to: s3://bucket/prefix, gs://bucket/prefix or file:///path. Credentials are read from AWS environment variables or shared credentials file; use HMAC keys for GCS. Disabled by default" mapstructure:"object-storage-url"`
+	ObjectStorageEndpoint string        `def:"" desc:"custom endpoint of S3-compatible object storage" mapstructure:"object-storage-endpoint"`
+	ObjectStorageRegion   string        `def:"" desc:"object storage region" mapstructure:"object-storage-region"`
+	ObjectStorageMinAge   time.Duration `def:"24h" desc:"profiling data older than this is offloaded to object storage" mapstructure:"object-storage-min-age"`
+
+	SnapshotShippingURL      string        `def:"" desc:"object storage incremental storage snapshots are shipped to for a warm standby: s3://bucket/prefix, gs://bucket/prefix or file:///path. Object storage endpoint and region apply. Disabled by default" mapstructure:"snapshot-shipping-url"`
+	SnapshotShippingInterval time.Duration `def:"1m" desc:"interval at which storage snapshots are shipped" mapstructure:"snapshot-shipping-interval"`
+	StandbyURL               string        `def:"" desc:"object storage the server applies shipped storage snapshots from, running as a read-only warm standby. To fail over, restart the server without this option" mapstructure:"standby-url"`
+	StandbyPollInterval      time.Duration `def:"10s" desc:"interval at which a warm standby checks for new storage snapshots" mapstructure:"standby-poll-interval"`
+
+	ReadOnly bool `def:"false" desc:"disables ingestion and endpoints modifying the data, and suspends retention enforcement, e.g. for analysis of a restored backup" mapstructure:"read-only"`
+
+	ShutdownDrainDelay time.Duration `def:"0s" desc:"time the server keeps serving requests at shutdown after /readyz starts reporting it is not ready, for load balancers to stop routing to it" mapstructure:"shutdown-drain-delay"`
+	ShutdownTimeout    time.Duration `def:"30s" desc:"maximum time to wait at shutdown for requests in flight to complete before the storage is closed. 0 means no limit" mapstructure:"shutdown-timeout"`
+
+	HealthMinFreeSpace bytesize.ByteSize `def:"512MB" desc:"minimum free disk space in the storage directory. Below it, /readyz reports the server is not ready, and a warning is displayed in the UI" mapstructure:"health-min-free-space"`
+
+	ClusterPeers         []string      `def:"" desc:"URLs of all the servers of the cluster, including this one, e.g. http://pyroscope-0:4040. Applications are sharded across the servers by name: profiles are forwarded to the server owning the application, queries are proxied to it, and listings of applications and labels are merged. The list must be the same on all the servers" mapstructure:"cluster-peers"`
+	ClusterAdvertiseURL  string        `def:"" desc:"URL of this server as specified in cluster peers" mapstructure:"cluster-advertise-url"`
+	ClusterSecret        string        `json:"-" def:"" desc:"secret shared by the servers of the cluster to authenticate requests between them" mapstructure:"cluster-secret"`
+	ClusterSecretFile    string        `json:"-" def:"" desc:"file with the cluster secret, instead of cluster-secret" mapstructure:"cluster-secret-file"`
+	ClusterProbeInterval time.Duration `def:"5s" desc:"how often the servers of the cluster check each other are available. Applications of a server that is not available are owned by other servers until it is" mapstructure:"cluster-probe-interval"`
 
 	// Deprecated fields. They can be set (for backwards compatibility) but have no effect
 	// TODO: we should print some warning messages when people try to use these
@@ -133,10 +235,17 @@ type Server struct {
 
 	Auth Auth `mapstructure:"auth"`
 
+	AuditLog bool `def:"false" desc:"records queries and administrative actions (API keys, deletion of data, retention changes) in the audit log, which is exported with /api/audit" mapstructure:"audit-log"`
+
+	MultiTenancy  bool   `def:"false" desc:"isolates data of tenants specified with X-Pyroscope-Tenant header or bound to API keys. Application names are stored prefixed with the tenant ID and a dot, therefore app retention and quota patterns apply per tenant, e.g. team-a.*=30d" mapstructure:"multi-tenancy"`
+	DefaultTenant string `def:"" desc:"tenant of requests not specifying one in multi-tenancy mode. If empty, such requests are rejected" mapstructure:"default-tenant"`
+
 	MetricsExportRules MetricsExportRules `yaml:"metrics-export-rules" def:"" desc:"metrics export rules" mapstructure:"metrics-export-rules"`
 
 	TLSCertificateFile string `def:"" desc:"location of TLS Certificate file (.crt)" mapstructure:"tls-certificate-file"`
 	TLSKeyFile         string `def:"" desc:"location of TLS Private key file (.key)" mapstructure:"tls-key-file"`
+	TLSClientCAFile    string `def:"" desc:"location of the file with CA certificates (.crt) used to verify client certificates" mapstructure:"tls-client-ca-file"`
+	TLSClientAuth      string `def:"" desc:"client certificate policy: request, require, verify-if-given, or require-and-verify (default, if tls-client-ca-file is set)" mapstructure:"tls-client-auth"`
 
 	AdminSocketPath         string `def:"/tmp/pyroscope.sock" desc:"path where the admin server socket will be created." mapstructure:"admin-socket-path"`
 	EnableExperimentalAdmin bool   `def:"true" deprecated:"true" desc:"whether to enable the experimental admin interface" mapstructure:"enable-experimental-admin"`
@@ -146,9 +255,73 @@ type Server struct {
 
 	ScrapeConfigs []*scrape.Config `yaml:"scrape-configs" mapstructure:"-"`
 
+	// RemoteWrite targets receive a copy of every ingested profile.
+	RemoteWrite []RemoteWriteTarget `yaml:"remote-write" mapstructure:"-"`
+
+	// Federation targets are queried along with the local storage:
+	// flamegraphs, timelines and label listings are merged. Federation
+	// is not supported in multi-tenancy mode.
+	Federation []FederationTarget `yaml:"federation" mapstructure:"-"`
+
+	// IngestQuotas limit ingestion per tenant or application.
+	IngestQuotas []IngestQuota `yaml:"ingest-quotas" mapstructure:"-"`
+
+	// IngestRelabelConfigs are applied to profile keys at ingestion.
+	IngestRelabelConfigs []*relabel.Config `yaml:"ingest-relabel-configs" mapstructure:"-"`
+
 	NoSelfProfiling bool `def:"false" desc:"disable profiling of pyroscope itself" mapstructure:"no-self-profiling"`
 }
 
+type RemoteWriteTarget struct {
+	// Address of the pyroscope server profiles are forwarded to.
+	Address   string `yaml:"address"`
+	AuthToken string `yaml:"auth-token"`
+	// AuthTokenFile is a file the auth token is read from.
+	AuthTokenFile string `yaml:"auth-token-file"`
+
+	// Timeout of a single upload request. Defaults to 10s.
+	Timeout time.Duration `yaml:"timeout"`
+	// QueueSize specifies the number of profiles buffered for upload.
+	// Once the queue is full, new profiles are dropped. Defaults to 1000.
+	QueueSize int `yaml:"queue-size"`
+	// MaxRetries specifies how many times a failed upload is retried.
+	// Defaults to 3.
+	MaxRetries int `yaml:"max-retries"`
+}
+
+type FederationTarget struct {
+	// Address of the remote pyroscope server.
+	Address   string `yaml:"address"`
+	AuthToken string `yaml:"auth-token"`
+	// AuthTokenFile is a file the auth token is read from.
+	AuthTokenFile string `yaml:"auth-token-file"`
+
+	// Timeout of a single request. Defaults to 30s.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+type IngestQuota struct {
+	// Tenant the quota applies to; empty matches any tenant.
+	Tenant string `yaml:"tenant"`
+	// App is a glob pattern of application names (without the tenant
+	// prefix), e.g. *.cpu: the quota applies to every matching application
+	// individually. If empty, the quota applies to all the applications of
+	// the tenant combined.
+	App string `yaml:"app"`
+
+	// SamplesPerSecond limits the rate of ingested samples. Bursts of
+	// up to 10 seconds worth of samples, the default upload interval
+	// of agents, are allowed.
+	SamplesPerSecond float64 `yaml:"samples-per-second"`
+	// BytesPerDay limits the size of ingested request bodies per UTC
+	// day, e.g. 10GB.
+	BytesPerDay string `yaml:"bytes-per-day"`
+	// MaxSeries limits the number of distinct series (sets of labels).
+	// Series stored are counted: series removed by retention or deletion
+	// are not.
+	MaxSeries int `yaml:"max-series"`
+}
+
 type MetricsExportRules map[string]MetricsExportRule
 
 type MetricsExportRule struct {
@@ -167,46 +340,73 @@ type Auth struct {
 	Google GoogleOauth `mapstructure:"google"`
 	Gitlab GitlabOauth `mapstructure:"gitlab"`
 	Github GithubOauth `mapstructure:"github"`
+	OIDC   OIDCOauth   `mapstructure:"oidc"`
+
+	APIKeys APIKeysAuth `mapstructure:"api-keys"`
 
 	// TODO: can we generate these automatically if it's empty?
 	JWTSecret                string `json:"-" deprecated:"true" def:"" desc:"secret used to secure your JWT tokens" mapstructure:"jwt-secret"`
+	JWTSecretFile            string `json:"-" deprecated:"true" def:"" desc:"file with the secret used to secure your JWT tokens, instead of jwt-secret" mapstructure:"jwt-secret-file"`
 	LoginMaximumLifetimeDays int    `json:"-" deprecated:"true" def:"0" desc:"amount of days after which user will be logged out. 0 means non-expiring." mapstructure:"login-maximum-lifetime-days"`
 }
 
+type APIKeysAuth struct {
+	Enabled bool `def:"false" desc:"enables authentication of API and ingestion requests with API keys provided in the Authorization header" mapstructure:"enabled"`
+	// AdminKey is used for bootstrapping: it allows creating other keys.
+	AdminKey     string `json:"-" def:"" desc:"static API key with the admin role" mapstructure:"admin-key"`
+	AdminKeyFile string `json:"-" def:"" desc:"file with the static API key with the admin role, instead of admin-key" mapstructure:"admin-key-file"`
+}
+
 // TODO: Maybe merge Oauth structs into one (would have to move def and desc tags somewhere else in code)
 type GoogleOauth struct {
 	// TODO: remove deprecated: true when we enable these back
-	Enabled        bool     `json:"-" deprecated:"true" def:"false" desc:"enables Google Oauth" mapstructure:"enabled"`
-	ClientID       string   `json:"-" deprecated:"true" def:"" desc:"client ID generated for Google API" mapstructure:"client-id"`
-	ClientSecret   string   `json:"-" deprecated:"true" def:"" desc:"client secret generated for Google API" mapstructure:"client-secret"`
-	RedirectURL    string   `json:"-" deprecated:"true" def:"" desc:"url that google will redirect to after logging in. Has to be in form <pathToPyroscopeServer/auth/google/callback>" mapstructure:"redirect-url"`
-	AuthURL        string   `json:"-" deprecated:"true" def:"https://accounts.google.com/o/oauth2/auth" desc:"auth url for Google API (usually present in credentials.json file)" mapstructure:"auth-url"`
-	TokenURL       string   `json:"-" deprecated:"true" def:"https://accounts.google.com/o/oauth2/token" desc:"token url for Google API (usually present in credentials.json file)" mapstructure:"token-url"`
-	AllowedDomains []string `json:"-" deprecated:"true" def:"" desc:"list of domains that are allowed to login through google" mapstructure:"allowed-domains"`
+	Enabled          bool     `json:"-" deprecated:"true" def:"false" desc:"enables Google Oauth" mapstructure:"enabled"`
+	ClientID         string   `json:"-" deprecated:"true" def:"" desc:"client ID generated for Google API" mapstructure:"client-id"`
+	ClientSecret     string   `json:"-" deprecated:"true" def:"" desc:"client secret generated for Google API" mapstructure:"client-secret"`
+	ClientSecretFile string   `json:"-" deprecated:"true" def:"" desc:"file with the client secret generated for Google API, instead of client-secret" mapstructure:"client-secret-file"`
+	RedirectURL      string   `json:"-" deprecated:"true" def:"" desc:"url that google will redirect to after logging in. Has to be in form <pathToPyroscopeServer/auth/google/callback>" mapstructure:"redirect-url"`
+	AuthURL          string   `json:"-" deprecated:"true" def:"https://accounts.google.com/o/oauth2/auth" desc:"auth url for Google API (usually present in credentials.json file)" mapstructure:"auth-url"`
+	TokenURL         string   `json:"-" deprecated:"true" def:"https://accounts.google.com/o/oauth2/token" desc:"token url for Google API (usually present in credentials.json file)" mapstructure:"token-url"`
+	AllowedDomains   []string `json:"-" deprecated:"true" def:"" desc:"list of domains that are allowed to login through google" mapstructure:"allowed-domains"`
 }
 
 type GitlabOauth struct {
 	Enabled bool `json:"-" deprecated:"true" def:"false" desc:"enables Gitlab Oauth" mapstructure:"enabled"`
 	// TODO: I changed this to ClientID to fit others, but in Gitlab docs it's Application ID so it might get someone confused?
-	ClientID      string   `json:"-" deprecated:"true" def:"" desc:"client ID generated for GitLab API" mapstructure:"client-id"`
-	ClientSecret  string   `json:"-" deprecated:"true" def:"" desc:"client secret generated for GitLab API" mapstructure:"client-secret"`
-	RedirectURL   string   `json:"-" deprecated:"true" def:"" desc:"url that gitlab will redirect to after logging in. Has to be in form <pathToPyroscopeServer/auth/gitlab/callback>" mapstructure:"redirect-url"`
-	AuthURL       string   `json:"-" deprecated:"true" def:"https://gitlab.com/oauth/authorize" desc:"auth url for GitLab API (keep default for cloud, usually https://gitlab.mycompany.com/oauth/authorize for on-premise)" mapstructure:"auth-url"`
-	TokenURL      string   `json:"-" deprecated:"true" def:"https://gitlab.com/oauth/token" desc:"token url for GitLab API (keep default for cloud, usually https://gitlab.mycompany.com/oauth/token for on-premise)" mapstructure:"token-url"`
-	APIURL        string   `json:"-" deprecated:"true" def:"https://gitlab.com/api/v4" desc:"URL to gitlab API (keep default for cloud, usually https://gitlab.mycompany.com/api/v4/user for on-premise)" mapstructure:"api-url"`
-	AllowedGroups []string `json:"-" deprecated:"true" def:"" desc:"list of groups (unique names of the group as listed in URL) that are allowed to login through gitlab" mapstructure:"allowed-groups"`
+	ClientID         string   `json:"-" deprecated:"true" def:"" desc:"client ID generated for GitLab API" mapstructure:"client-id"`
+	ClientSecret     string   `json:"-" deprecated:"true" def:"" desc:"client secret generated for GitLab API" mapstructure:"client-secret"`
+	ClientSecretFile string   `json:"-" deprecated:"true" def:"" desc:"file with the client secret generated for GitLab API, instead of client-secret" mapstructure:"client-secret-file"`
+	RedirectURL      string   `json:"-" deprecated:"true" def:"" desc:"url that gitlab will redirect to after logging in. Has to be in form <pathToPyroscopeServer/auth/gitlab/callback>" mapstructure:"redirect-url"`
+	AuthURL          string   `json:"-" deprecated:"true" def:"https://gitlab.com/oauth/authorize" desc:"auth url for GitLab API (keep default for cloud, usually https://gitlab.mycompany.com/oauth/authorize for on-premise)" mapstructure:"auth-url"`
+	TokenURL         string   `json:"-" deprecated:"true" def:"https://gitlab.com/oauth/token" desc:"token url for GitLab API (keep default for cloud, usually https://gitlab.mycompany.com/oauth/token for on-premise)" mapstructure:"token-url"`
+	APIURL           string   `json:"-" deprecated:"true" def:"https://gitlab.com/api/v4" desc:"URL to gitlab API (keep default for cloud, usually https://gitlab.mycompany.com/api/v4/user for on-premise)" mapstructure:"api-url"`
+	AllowedGroups    []string `json:"-" deprecated:"true" def:"" desc:"list of groups (unique names of the group as listed in URL) that are allowed to login through gitlab" mapstructure:"allowed-groups"`
 }
 
 type GithubOauth struct {
 	Enabled              bool     `json:"-" deprecated:"true" def:"false" desc:"enables Github Oauth" mapstructure:"enabled"`
 	ClientID             string   `json:"-" deprecated:"true" def:"" desc:"client ID generated for Github API" mapstructure:"client-id"`
 	ClientSecret         string   `json:"-" deprecated:"true" def:"" desc:"client secret generated for Github API" mapstructure:"client-secret"`
+	ClientSecretFile     string   `json:"-" deprecated:"true" def:"" desc:"file with the client secret generated for Github API, instead of client-secret" mapstructure:"client-secret-file"`
 	RedirectURL          string   `json:"-" deprecated:"true" def:"" desc:"url that Github will redirect to after logging in. Has to be in form <pathToPyroscopeServer/auth/github/callback>" mapstructure:"redirect-url"`
 	AuthURL              string   `json:"-" deprecated:"true" def:"https://github.com/login/oauth/authorize" desc:"auth url for Github API" mapstructure:"auth-url"`
 	TokenURL             string   `json:"-" deprecated:"true" def:"https://github.com/login/oauth/access_token" desc:"token url for Github API" mapstructure:"token-url"`
 	AllowedOrganizations []string `json:"-" deprecated:"true" def:"" desc:"list of organizations that are allowed to login through github" mapstructure:"allowed-organizations"`
 }
 
+type OIDCOauth struct {
+	Enabled          bool     `def:"false" desc:"enables OpenID Connect login" mapstructure:"enabled"`
+	Name             string   `def:"SSO" desc:"name of the identity provider displayed on the login page" mapstructure:"name"`
+	IssuerURL        string   `def:"" desc:"OpenID Connect issuer URL. Endpoints are discovered from <issuer-url>/.well-known/openid-configuration" mapstructure:"issuer-url"`
+	ClientID         string   `def:"" desc:"client ID registered with the identity provider" mapstructure:"client-id"`
+	ClientSecret     string   `json:"-" def:"" desc:"client secret registered with the identity provider" mapstructure:"client-secret"`
+	ClientSecretFile string   `json:"-" def:"" desc:"file with the client secret registered with the identity provider, instead of client-secret" mapstructure:"client-secret-file"`
+	RedirectURL      string   `def:"" desc:"url that the identity provider will redirect to after logging in. Has to be in form <pathToPyroscopeServer/auth/oidc/callback>" mapstructure:"redirect-url"`
+	Scopes           []string `def:"" desc:"list of scopes to request, openid, profile and email by default" mapstructure:"scopes"`
+	GroupsClaim      string   `def:"groups" desc:"name of the user info claim listing the groups of the user" mapstructure:"groups-claim"`
+	AllowedGroups    []string `def:"" desc:"list of groups that are allowed to login. If empty, all users of the identity provider are allowed" mapstructure:"allowed-groups"`
+}
+
 type Convert struct {
 	Format string `def:"tree" mapstructure:"format"`
 }
@@ -240,10 +440,18 @@ type Exec struct {
 	RbspyBlocking      bool   `def:"false" desc:"enables blocking mode for rbspy" mapstructure:"rbspy-blocking"`
 
 	// Remote upstream configuration
-	ServerAddress          string        `def:"http://localhost:4040" desc:"address of the pyroscope server" mapstructure:"server-address"`
-	AuthToken              string        `def:"" desc:"authorization token used to upload profiling data" mapstructure:"auth-token"`
-	UpstreamThreads        int           `def:"4" desc:"number of upload threads" mapstructure:"upstream-threads"`
-	UpstreamRequestTimeout time.Duration `def:"10s" desc:"profile upload timeout" mapstructure:"upstream-request-timeout"`
+	ServerAddress                string            `def:"http://localhost:4040" desc:"address of the pyroscope server" mapstructure:"server-address"`
+	AuthToken                    string            `def:"" desc:"authorization token used to upload profiling data" mapstructure:"auth-token"`
+	AuthTokenFile                string            `def:"" desc:"file with the authorization token used to upload profiling data, instead of auth-token" mapstructure:"auth-token-file"`
+	SigningKeyID                 string            `def:"" desc:"ID of the shared secret profile uploads are signed with. Must match the one configured on the server" mapstructure:"signing-key-id"`
+	SigningSecret                string            `def:"" desc:"shared secret profile uploads are signed with (HMAC-SHA256)" mapstructure:"signing-secret"`
+	SigningSecretFile            string            `def:"" desc:"file with the shared secret profile uploads are signed with, instead of signing-secret" mapstructure:"signing-secret-file"`
+	UpstreamThreads              int               `def:"4" desc:"number of upload threads" mapstructure:"upstream-threads"`
+	UpstreamRequestTimeout       time.Duration     `def:"10s" desc:"profile upload timeout" mapstructure:"upstream-request-timeout"`
+	UpstreamCompressionThreshold bytesize.ByteSize `def:"0" desc:"profiles larger than this size are uploaded gzip-compressed. The server must support Content-Encoding on /ingest. 0 disables compression" mapstructure:"upstream-compression-threshold"`
+	ServerCAFile                 string            `def:"" desc:"path to a PEM-encoded CA bundle used to verify the server certificate" mapstructure:"server-ca-file"`
+	ClientCertFile               string            `def:"" desc:"path to a PEM-encoded client certificate presented to the server" mapstructure:"client-cert-file"`
+	ClientKeyFile                string            `def:"" desc:"path to a PEM-encoded private key of the client certificate" mapstructure:"client-key-file"`
 
 	Tags map[string]string `name:"tag" def:"" desc:"tag in key=value form. The flag may be specified multiple times" mapstructure:"tags"`
 
@@ -265,10 +473,18 @@ type Connect struct {
 	RbspyBlocking      bool   `def:"false" desc:"enables blocking mode for rbspy" mapstructure:"rbspy-blocking"`
 
 	// Remote upstream configuration
-	ServerAddress          string        `def:"http://localhost:4040" desc:"address of the pyroscope server" mapstructure:"server-address"`
-	AuthToken              string        `def:"" desc:"authorization token used to upload profiling data" mapstructure:"auth-token"`
-	UpstreamThreads        int           `def:"4" desc:"number of upload threads" mapstructure:"upstream-threads"`
-	UpstreamRequestTimeout time.Duration `def:"10s" desc:"profile upload timeout" mapstructure:"upstream-request-timeout"`
+	ServerAddress                string            `def:"http://localhost:4040" desc:"address of the pyroscope server" mapstructure:"server-address"`
+	AuthToken                    string            `def:"" desc:"authorization token used to upload profiling data" mapstructure:"auth-token"`
+	AuthTokenFile                string            `def:"" desc:"file with the authorization token used to upload profiling data, instead of auth-token" mapstructure:"auth-token-file"`
+	SigningKeyID                 string            `def:"" desc:"ID of the shared secret profile uploads are signed with. Must match the one configured on the server" mapstructure:"signing-key-id"`
+	SigningSecret                string            `def:"" desc:"shared secret profile uploads are signed with (HMAC-SHA256)" mapstructure:"signing-secret"`
+	SigningSecretFile            string            `def:"" desc:"file with the shared secret profile uploads are signed with, instead of signing-secret" mapstructure:"signing-secret-file"`
+	UpstreamThreads              int               `def:"4" desc:"number of upload threads" mapstructure:"upstream-threads"`
+	UpstreamRequestTimeout       time.Duration     `def:"10s" desc:"profile upload timeout" mapstructure:"upstream-request-timeout"`
+	UpstreamCompressionThreshold bytesize.ByteSize `def:"0" desc:"profiles larger than this size are uploaded gzip-compressed. The server must support Content-Encoding on /ingest. 0 disables compression" mapstructure:"upstream-compression-threshold"`
+	ServerCAFile                 string            `def:"" desc:"path to a PEM-encoded CA bundle used to verify the server certificate" mapstructure:"server-ca-file"`
+	ClientCertFile               string            `def:"" desc:"path to a PEM-encoded client certificate presented to the server" mapstructure:"client-cert-file"`
+	ClientKeyFile                string            `def:"" desc:"path to a PEM-encoded private key of the client certificate" mapstructure:"client-key-file"`
 
 	Tags map[string]string `name:"tag" def:"" desc:"tag in key=value form. The flag may be specified multiple times" mapstructure:"tags"`
 
@@ -277,14 +493,40 @@ type Connect struct {
 
 // TODO how to abstract this better?
 type Admin struct {
-	AdminAppDelete AdminAppDelete `skip:"true" mapstructure:",squash"`
-	AdminAppGet    AdminAppGet    `skip:"true" mapstructure:",squash"`
+	AdminAppDelete        AdminAppDelete        `skip:"true" mapstructure:",squash"`
+	AdminAppGet           AdminAppGet           `skip:"true" mapstructure:",squash"`
+	AdminBackup           AdminBackup           `skip:"true" mapstructure:",squash"`
+	AdminRestore          AdminRestore          `skip:"true" mapstructure:",squash"`
+	AdminStorageVerify    AdminStorageVerify    `skip:"true" mapstructure:",squash"`
+	AdminStorageRotateKey AdminStorageRotateKey `skip:"true" mapstructure:",squash"`
 }
 type AdminAppGet struct {
 	SocketPath string        `def:"/tmp/pyroscope.sock" desc:"path where the admin server socket was created." mapstructure:"socket-path"`
 	Timeout    time.Duration `def:"30m" desc:"timeout for the server to respond" mapstructure:"timeout"`
 }
 
+type AdminBackup struct {
+	SocketPath string        `def:"/tmp/pyroscope.sock" desc:"path where the admin server socket was created." mapstructure:"socket-path"`
+	Timeout    time.Duration `def:"30m" desc:"timeout for the server to respond" mapstructure:"timeout"`
+}
+
+type AdminStorageVerify struct {
+	SocketPath string        `def:"/tmp/pyroscope.sock" desc:"path where the admin server socket was created." mapstructure:"socket-path"`
+	Repair     bool          `def:"false" desc:"remove corrupt and unreachable data, and dangling references" mapstructure:"repair"`
+	Timeout    time.Duration `def:"30m" desc:"timeout for the server to respond" mapstructure:"timeout"`
+}
+
+type AdminRestore struct {
+	StoragePath              string `def:"<installPrefix>/var/lib/pyroscope" desc:"directory where pyroscope stores profiling data" mapstructure:"storage-path"`
+	StorageEncryptionKeyFile string `def:"" desc:"file with the key the storage is encrypted with, if any" mapstructure:"storage-encryption-key-file"`
+}
+
+type AdminStorageRotateKey struct {
+	StoragePath string `def:"<installPrefix>/var/lib/pyroscope" desc:"directory where pyroscope stores profiling data" mapstructure:"storage-path"`
+	OldKeyFile  string `def:"" desc:"file with the key the storage is encrypted with. Empty if the storage is not encrypted" mapstructure:"old-key-file"`
+	NewKeyFile  string `def:"" desc:"file with the new key. Empty to decrypt the storage" mapstructure:"new-key-file"`
+}
+
 type AdminAppDelete struct {
 	SocketPath string        `def:"/tmp/pyroscope.sock" desc:"path where the admin server socket was created." mapstructure:"socket-path"`
 	Force      bool          `def:"false" desc:"don't prompt for confirmation of dangerous actions" mapstructure:"force"`
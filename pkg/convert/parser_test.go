@@ -5,6 +5,7 @@ import (
 	"compress/gzip"
 	"fmt"
 	"os"
+	"strings"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -52,4 +53,47 @@ var _ = Describe("convert", func() {
 			Expect(result).To(ConsistOf("foo;bar 1", "foo;baz 1"))
 		})
 	})
+
+	Describe("ParseSpeedscope", func() {
+		parse := func(s string) ([]string, error) {
+			result := []string{}
+			err := ParseSpeedscope(strings.NewReader(s), func(name []byte, val int) {
+				result = append(result, fmt.Sprintf("%s %d", name, val))
+			})
+			return result, err
+		}
+
+		It("parses sampled profiles", func() {
+			result, err := parse(`{
+				"shared": {"frames": [{"name": "foo"}, {"name": "bar"}, {"name": "baz"}]},
+				"profiles": [{"type": "sampled", "samples": [[0, 1], [0, 2], [0, 1]], "weights": [10, 20, 5]}]
+			}`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(ConsistOf("foo;bar 15", "foo;baz 20"))
+		})
+
+		It("parses evented profiles", func() {
+			result, err := parse(`{
+				"shared": {"frames": [{"name": "foo"}, {"name": "bar"}]},
+				"profiles": [{"type": "evented", "events": [
+					{"type": "O", "at": 0, "frame": 0},
+					{"type": "O", "at": 10, "frame": 1},
+					{"type": "C", "at": 40, "frame": 1},
+					{"type": "C", "at": 50, "frame": 0}
+				]}]
+			}`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(ConsistOf("foo 20", "foo;bar 30"))
+		})
+
+		It("rejects invalid frame references", func() {
+			_, err := parse(`{"shared": {"frames": []}, "profiles": [{"type": "sampled", "samples": [[0]]}]}`)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects unbalanced events", func() {
+			_, err := parse(`{"shared": {"frames": [{"name": "foo"}]}, "profiles": [{"type": "evented", "events": [{"type": "C", "at": 0, "frame": 0}]}]}`)
+			Expect(err).To(HaveOccurred())
+		})
+	})
 })
@@ -0,0 +1,113 @@
+package convert
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// speedscopeFile is a subset of the speedscope file format.
+// See https://github.com/jlfwong/speedscope/blob/main/src/lib/file-format-spec.ts
+type speedscopeFile struct {
+	Shared struct {
+		Frames []struct {
+			Name string `json:"name"`
+		} `json:"frames"`
+	} `json:"shared"`
+	Profiles []speedscopeProfile `json:"profiles"`
+}
+
+type speedscopeProfile struct {
+	Type string `json:"type"`
+
+	// Evented profiles.
+	Events []struct {
+		Type  string  `json:"type"`
+		At    float64 `json:"at"`
+		Frame int     `json:"frame"`
+	} `json:"events"`
+
+	// Sampled profiles.
+	Samples [][]int   `json:"samples"`
+	Weights []float64 `json:"weights"`
+}
+
+// ParseSpeedscope parses speedscope JSON files. Stacks of all profiles in
+// the file are merged; values are reported in the units of the profile
+// (e.g. samples or nanoseconds), rounded to integers.
+func ParseSpeedscope(r io.Reader, cb func(name []byte, val int)) error {
+	var f speedscopeFile
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return err
+	}
+	frames := make([]string, len(f.Shared.Frames))
+	for i, x := range f.Shared.Frames {
+		frames[i] = x.Name
+	}
+	stackName := func(stack []int) (string, error) {
+		names := make([]string, len(stack))
+		for i, x := range stack {
+			if x < 0 || x >= len(frames) {
+				return "", fmt.Errorf("invalid frame index %d", x)
+			}
+			names[i] = frames[x]
+		}
+		return strings.Join(names, ";"), nil
+	}
+
+	values := make(map[string]float64)
+	for _, p := range f.Profiles {
+		switch p.Type {
+		case "sampled":
+			if p.Weights != nil && len(p.Weights) != len(p.Samples) {
+				return fmt.Errorf("number of weights (%d) does not match number of samples (%d)", len(p.Weights), len(p.Samples))
+			}
+			for i, s := range p.Samples {
+				name, err := stackName(s)
+				if err != nil {
+					return err
+				}
+				w := 1.0
+				if p.Weights != nil {
+					w = p.Weights[i]
+				}
+				values[name] += w
+			}
+		case "evented":
+			var stack []int
+			var last float64
+			for _, e := range p.Events {
+				if len(stack) > 0 && e.At > last {
+					name, err := stackName(stack)
+					if err != nil {
+						return err
+					}
+					values[name] += e.At - last
+				}
+				last = e.At
+				switch e.Type {
+				case "O":
+					stack = append(stack, e.Frame)
+				case "C":
+					if len(stack) == 0 || stack[len(stack)-1] != e.Frame {
+						return fmt.Errorf("unbalanced close event for frame %d at %v", e.Frame, e.At)
+					}
+					stack = stack[:len(stack)-1]
+				default:
+					return fmt.Errorf("unknown event type %q", e.Type)
+				}
+			}
+		default:
+			return fmt.Errorf("unsupported profile type %q", p.Type)
+		}
+	}
+
+	for name, v := range values {
+		if n := int(math.Round(v)); name != "" && n > 0 {
+			cb([]byte(name), n)
+		}
+	}
+	return nil
+}
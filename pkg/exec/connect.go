@@ -50,6 +50,12 @@ func NewConnect(cfg *config.Connect) (*Connect, error) {
 		UpstreamThreads:        cfg.UpstreamThreads,
 		UpstreamAddress:        cfg.ServerAddress,
 		UpstreamRequestTimeout: cfg.UpstreamRequestTimeout,
+		CompressionThreshold:   int(cfg.UpstreamCompressionThreshold),
+		ServerCAFile:           cfg.ServerCAFile,
+		ClientCertFile:         cfg.ClientCertFile,
+		ClientKeyFile:          cfg.ClientKeyFile,
+		SigningKeyID:           cfg.SigningKeyID,
+		SigningSecret:          cfg.SigningSecret,
 	}
 	up, err := remote.New(rc, logger)
 	if err != nil {
@@ -59,6 +59,12 @@ func NewExec(cfg *config.Exec, args []string) (*Exec, error) {
 		UpstreamThreads:        cfg.UpstreamThreads,
 		UpstreamAddress:        cfg.ServerAddress,
 		UpstreamRequestTimeout: cfg.UpstreamRequestTimeout,
+		CompressionThreshold:   int(cfg.UpstreamCompressionThreshold),
+		ServerCAFile:           cfg.ServerCAFile,
+		ClientCertFile:         cfg.ClientCertFile,
+		ClientKeyFile:          cfg.ClientKeyFile,
+		SigningKeyID:           cfg.SigningKeyID,
+		SigningSecret:          cfg.SigningSecret,
 	}
 	up, err := remote.New(rc, logger)
 	if err != nil {
@@ -63,8 +63,10 @@ func ParseMatchers(s string) ([]*TagMatcher, error) {
 }
 
 // ParseMatcher parses a string of $tag_key$op"$tag_value" form,
-// where $op is one of the supported match operators.
+// where $op is one of the supported match operators. The key, the
+// operator, and the value may be separated with whitespace.
 func ParseMatcher(s string) (*TagMatcher, error) {
+	s = trimUnquotedSpaces(s)
 	var tm TagMatcher
 	var offset int
 	var c rune
@@ -153,6 +155,25 @@ func unquote(s string) (string, bool) {
 	return s[1 : len(s)-1], true
 }
 
+// trimUnquotedSpaces removes whitespace outside of double quotes.
+func trimUnquotedSpaces(s string) string {
+	if !strings.ContainsAny(s, " \t") {
+		return s
+	}
+	var b strings.Builder
+	var y bool
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"' && (!y || s[i-1] != '\\'):
+			y = !y
+		case (s[i] == ' ' || s[i] == '\t') && !y:
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
 func split(s string) []string {
 	var r []string
 	var x int
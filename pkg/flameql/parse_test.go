@@ -26,6 +26,8 @@ var _ = Describe("ParseQuery", func() {
 				&Query{"app.name", []*TagMatcher{{"foo", "bar,baz", OpEqual, nil}}, `app.name{foo="bar,baz"}`}},
 			{`app.name{foo="bar",baz!="quo"}`, nil,
 				&Query{"app.name", []*TagMatcher{{"baz", "quo", OpNotEqual, nil}, {"foo", "bar", OpEqual, nil}}, `app.name{foo="bar",baz!="quo"}`}},
+			{`app.name{foo = "bar", baz !~ "quo .*"}`, nil,
+				&Query{"app.name", []*TagMatcher{{"baz", "quo .*", OpNotEqualRegex, nil}, {"foo", "bar", OpEqual, nil}}, `app.name{foo = "bar", baz !~ "quo .*"}`}},
 
 			{"", ErrAppNameIsRequired, nil},
 			{"{}", ErrAppNameIsRequired, nil},
@@ -43,6 +45,11 @@ var _ = Describe("ParseQuery", func() {
 			} else {
 				Expect(err).To(BeNil())
 			}
+			if q != nil {
+				for _, m := range q.Matchers {
+					m.R = nil
+				}
+			}
 			Expect(q).To(Equal(tc.q))
 		}
 	})
@@ -87,6 +94,8 @@ var _ = Describe("ParseMatcher", func() {
 
 			{expr: `foo="bar,baz"`, m: &TagMatcher{"foo", "bar,baz", OpEqual, nil}},
 			{expr: `foo="bar\",\"baz"`, m: &TagMatcher{"foo", "bar\\\",\\\"baz", OpEqual, nil}},
+			{expr: `foo = "bar baz"`, m: &TagMatcher{"foo", "bar baz", OpEqual, nil}},
+			{expr: `foo=~ "a\" b"`, m: &TagMatcher{"foo", `a\" b`, OpEqualRegex, nil}},
 
 			{expr: `foo;bar="baz"`, err: ErrInvalidTagKey},
 			{expr: `foo""`, err: ErrInvalidTagKey},
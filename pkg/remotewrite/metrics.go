@@ -0,0 +1,35 @@
+package remotewrite
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+type metrics struct {
+	sent    *prometheus.CounterVec
+	failed  *prometheus.CounterVec
+	retries *prometheus.CounterVec
+	dropped *prometheus.CounterVec
+}
+
+func newMetrics(r prometheus.Registerer) *metrics {
+	target := []string{"target"}
+	return &metrics{
+		sent: promauto.With(r).NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_remote_write_sent_total",
+			Help: "number of profiles sent to remote write targets",
+		}, target),
+		failed: promauto.With(r).NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_remote_write_failed_total",
+			Help: "number of profiles that failed to be sent to remote write targets",
+		}, target),
+		retries: promauto.With(r).NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_remote_write_retries_total",
+			Help: "number of retried remote write requests",
+		}, target),
+		dropped: promauto.With(r).NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_remote_write_dropped_total",
+			Help: "number of profiles dropped because remote write queue is full",
+		}, target),
+	}
+}
@@ -0,0 +1,209 @@
+// Package remotewrite forwards ingested profiles to remote pyroscope servers.
+package remotewrite
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+)
+
+const (
+	defaultTimeout    = 10 * time.Second
+	defaultQueueSize  = 1000
+	defaultMaxRetries = 3
+
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 10 * time.Second
+)
+
+// RemoteWriter sends a copy of every written profile to the configured
+// targets. Each target has its own queue: a slow or unavailable target
+// does not affect others, and it never blocks ingestion – profiles are
+// dropped when the target queue is full.
+type RemoteWriter struct {
+	logger  logrus.FieldLogger
+	clients []*client
+	metrics *metrics
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+type client struct {
+	target     config.RemoteWriteTarget
+	url        *url.URL
+	httpClient *http.Client
+	queue      chan *request
+	backoff    time.Duration
+}
+
+type request struct {
+	query url.Values
+	body  []byte
+}
+
+func New(logger logrus.FieldLogger, targets []config.RemoteWriteTarget, reg prometheus.Registerer) (*RemoteWriter, error) {
+	w := RemoteWriter{
+		logger:  logger,
+		metrics: newMetrics(reg),
+		done:    make(chan struct{}),
+	}
+	for _, t := range targets {
+		u, err := url.Parse(t.Address)
+		if err != nil {
+			return nil, fmt.Errorf("remote write address %q: %w", t.Address, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("remote write address %q: unsupported scheme %q", t.Address, u.Scheme)
+		}
+		if t.Timeout <= 0 {
+			t.Timeout = defaultTimeout
+		}
+		if t.QueueSize <= 0 {
+			t.QueueSize = defaultQueueSize
+		}
+		if t.MaxRetries < 0 {
+			t.MaxRetries = 0
+		} else if t.MaxRetries == 0 {
+			t.MaxRetries = defaultMaxRetries
+		}
+		w.clients = append(w.clients, &client{
+			target:     t,
+			url:        u,
+			httpClient: &http.Client{Timeout: t.Timeout},
+			queue:      make(chan *request, t.QueueSize),
+			backoff:    minBackoff,
+		})
+	}
+	return &w, nil
+}
+
+func (w *RemoteWriter) Start() {
+	for _, c := range w.clients {
+		w.wg.Add(1)
+		go func(c *client) {
+			defer w.wg.Done()
+			w.run(c)
+		}(c)
+	}
+}
+
+// Stop stops uploading. Profiles remaining in queues are discarded.
+func (w *RemoteWriter) Stop() {
+	close(w.done)
+	w.wg.Wait()
+	for _, c := range w.clients {
+		if n := len(c.queue); n > 0 {
+			w.logger.WithField("target", c.target.Address).
+				WithField("profiles", n).
+				Warn("remote write queue is not empty, discarding profiles")
+		}
+	}
+}
+
+// Write enqueues the profile for upload to every target. The tree is
+// serialized synchronously, therefore pi can be modified once the call
+// returns.
+func (w *RemoteWriter) Write(pi *storage.PutInput) {
+	if len(w.clients) == 0 {
+		return
+	}
+	r := &request{
+		query: url.Values{
+			"name":            []string{pi.Key.Normalized()},
+			"from":            []string{strconv.FormatInt(pi.StartTime.Unix(), 10)},
+			"until":           []string{strconv.FormatInt(pi.EndTime.Unix(), 10)},
+			"spyName":         []string{pi.SpyName},
+			"sampleRate":      []string{strconv.Itoa(int(pi.SampleRate))},
+			"units":           []string{pi.Units},
+			"aggregationType": []string{pi.AggregationType},
+		},
+		body: []byte(pi.Val.Collapsed()),
+	}
+	for _, c := range w.clients {
+		select {
+		case c.queue <- r:
+		default:
+			w.metrics.dropped.WithLabelValues(c.target.Address).Inc()
+			w.logger.WithField("target", c.target.Address).Debug("remote write queue is full, dropping profile")
+		}
+	}
+}
+
+func (w *RemoteWriter) run(c *client) {
+	for {
+		select {
+		case <-w.done:
+			return
+		case r := <-c.queue:
+			w.send(c, r)
+		}
+	}
+}
+
+func (w *RemoteWriter) send(c *client, r *request) {
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := c.upload(r)
+		if err == nil {
+			w.metrics.sent.WithLabelValues(c.target.Address).Inc()
+			return
+		}
+		if !retry || attempt >= c.target.MaxRetries {
+			w.metrics.failed.WithLabelValues(c.target.Address).Inc()
+			w.logger.WithError(err).WithField("target", c.target.Address).Error("remote write failed")
+			return
+		}
+		w.metrics.retries.WithLabelValues(c.target.Address).Inc()
+		select {
+		case <-w.done:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// upload sends the request and reports whether it is worth retrying
+// if the upload failed.
+func (c *client) upload(r *request) (retry bool, err error) {
+	u := *c.url
+	u.Path = path.Join(u.Path, "/ingest")
+	u.RawQuery = r.query.Encode()
+	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(r.body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	if c.target.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.target.AuthToken)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		return true, fmt.Errorf("unexpected response status: %s", resp.Status)
+	default:
+		return false, fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+}
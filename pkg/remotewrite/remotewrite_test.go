@@ -0,0 +1,151 @@
+package remotewrite
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+)
+
+type received struct {
+	query url.Values
+	body  string
+	auth  string
+}
+
+var _ = Describe("RemoteWriter", func() {
+	var (
+		mutex    sync.Mutex
+		requests []received
+		statuses []int
+		server   *httptest.Server
+	)
+
+	BeforeEach(func() {
+		requests = nil
+		statuses = nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Path).To(Equal("/ingest"))
+			b, err := io.ReadAll(r.Body)
+			Expect(err).ToNot(HaveOccurred())
+			mutex.Lock()
+			defer mutex.Unlock()
+			requests = append(requests, received{
+				query: r.URL.Query(),
+				body:  string(b),
+				auth:  r.Header.Get("Authorization"),
+			})
+			status := http.StatusOK
+			if len(statuses) > 0 {
+				status, statuses = statuses[0], statuses[1:]
+			}
+			w.WriteHeader(status)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	newPutInput := func() *storage.PutInput {
+		k, err := segment.ParseKey("app.cpu{foo=bar}")
+		Expect(err).ToNot(HaveOccurred())
+		t := tree.New()
+		t.Insert([]byte("a;b"), 2)
+		t.Insert([]byte("a;c"), 3)
+		return &storage.PutInput{
+			StartTime:       time.Unix(10, 0),
+			EndTime:         time.Unix(20, 0),
+			Key:             k,
+			Val:             t,
+			SpyName:         "gospy",
+			SampleRate:      100,
+			Units:           "samples",
+			AggregationType: "sum",
+		}
+	}
+
+	receivedRequests := func() []received {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]received(nil), requests...)
+	}
+
+	It("forwards profiles to the target", func() {
+		w, err := New(logrus.New(), []config.RemoteWriteTarget{{Address: server.URL, AuthToken: "token"}}, prometheus.NewRegistry())
+		Expect(err).ToNot(HaveOccurred())
+		w.Start()
+		defer w.Stop()
+		w.Write(newPutInput())
+
+		Eventually(receivedRequests).Should(HaveLen(1))
+		r := receivedRequests()[0]
+		Expect(r.body).To(Equal("a;b 2\na;c 3\n"))
+		Expect(r.auth).To(Equal("Bearer token"))
+		Expect(r.query.Get("name")).To(Equal("app.cpu{foo=bar}"))
+		Expect(r.query.Get("from")).To(Equal("10"))
+		Expect(r.query.Get("until")).To(Equal("20"))
+		Expect(r.query.Get("spyName")).To(Equal("gospy"))
+		Expect(r.query.Get("sampleRate")).To(Equal("100"))
+		Expect(r.query.Get("units")).To(Equal("samples"))
+		Expect(r.query.Get("aggregationType")).To(Equal("sum"))
+	})
+
+	It("retries failed uploads", func() {
+		statuses = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+		w, err := New(logrus.New(), []config.RemoteWriteTarget{{Address: server.URL}}, prometheus.NewRegistry())
+		Expect(err).ToNot(HaveOccurred())
+		w.clients[0].backoff = time.Millisecond
+		w.Start()
+		defer w.Stop()
+		w.Write(newPutInput())
+
+		Eventually(func() float64 {
+			return testutil.ToFloat64(w.metrics.sent.WithLabelValues(server.URL))
+		}).Should(Equal(float64(1)))
+		Expect(receivedRequests()).To(HaveLen(3))
+		Expect(testutil.ToFloat64(w.metrics.retries.WithLabelValues(server.URL))).To(Equal(float64(2)))
+	})
+
+	It("does not retry rejected uploads", func() {
+		statuses = []int{http.StatusBadRequest}
+		w, err := New(logrus.New(), []config.RemoteWriteTarget{{Address: server.URL}}, prometheus.NewRegistry())
+		Expect(err).ToNot(HaveOccurred())
+		w.clients[0].backoff = time.Millisecond
+		w.Start()
+		defer w.Stop()
+		w.Write(newPutInput())
+
+		Eventually(func() float64 {
+			return testutil.ToFloat64(w.metrics.failed.WithLabelValues(server.URL))
+		}).Should(Equal(float64(1)))
+		Expect(receivedRequests()).To(HaveLen(1))
+	})
+
+	It("drops profiles if the queue is full", func() {
+		w, err := New(logrus.New(), []config.RemoteWriteTarget{{Address: server.URL, QueueSize: 1}}, prometheus.NewRegistry())
+		Expect(err).ToNot(HaveOccurred())
+		for i := 0; i < 3; i++ {
+			w.Write(newPutInput())
+		}
+		Expect(testutil.ToFloat64(w.metrics.dropped.WithLabelValues(server.URL))).To(Equal(float64(2)))
+	})
+
+	It("rejects invalid addresses", func() {
+		_, err := New(logrus.New(), []config.RemoteWriteTarget{{Address: "ftp://localhost"}}, prometheus.NewRegistry())
+		Expect(err).To(HaveOccurred())
+	})
+})
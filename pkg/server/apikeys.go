@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/flameql"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+)
+
+// adminAPIKeyName is the name of the static admin key from the config.
+const adminAPIKeyName = "admin"
+
+var errAppAccessDenied = errors.New("api key is not allowed to access the application")
+
+type apiKeyContextKey struct{}
+
+type apiKey struct {
+	Name      string             `json:"name"`
+	Role      storage.APIKeyRole `json:"role"`
+	CreatedAt time.Time          `json:"createdAt"`
+	Apps      []string           `json:"apps,omitempty"`
+	Tenant    string             `json:"tenant,omitempty"`
+}
+
+type createAPIKeyRequest struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+	// Apps limit the applications the key is allowed to access.
+	Apps []string `json:"apps,omitempty"`
+}
+
+type createAPIKeyResponse struct {
+	apiKey
+	// Key is the token to be provided in the Authorization header:
+	// it's only returned once, when the key is created.
+	Key string `json:"key"`
+}
+
+// apiKeysHandler manages API keys:
+//   - GET /api/keys lists the keys;
+//   - POST /api/keys creates a key: {"name": "ci", "role": "ingest"},
+//     optionally scoped to applications: {..., "apps": ["payments.*"]};
+//   - DELETE /api/keys?name=ci deletes the key.
+//
+// In multi-tenancy mode, keys are bound to the tenant they are created
+// within, and only keys of the request tenant are managed.
+func (ctrl *Controller) apiKeysHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		tenant, _ := tenantFromContext(r.Context())
+		keys := ctrl.storage.APIKeys()
+		res := make([]apiKey, 0, len(keys))
+		for _, k := range keys {
+			if k.Tenant == tenant {
+				res = append(res, newAPIKeyResponse(k))
+			}
+		}
+		ctrl.writeResponseJSON(w, res)
+
+	case http.MethodPost:
+		var req createAPIKeyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			ctrl.writeInvalidParameterError(w, err)
+			return
+		}
+		role, err := storage.ParseAPIKeyRole(req.Role)
+		if err != nil {
+			ctrl.writeInvalidParameterError(w, err)
+			return
+		}
+		if req.Name == adminAPIKeyName {
+			ctrl.writeError(w, http.StatusConflict, storage.ErrAPIKeyExists, "failed to create api key")
+			return
+		}
+		tenant, _ := tenantFromContext(r.Context())
+		k, token, err := ctrl.storage.CreateAPIKey(storage.CreateAPIKeyInput{Name: req.Name, Role: role, Apps: req.Apps, Tenant: tenant})
+		switch {
+		case err == nil:
+		case errors.Is(err, storage.ErrAPIKeyInvalidName), errors.Is(err, storage.ErrAPIKeyInvalidApps):
+			ctrl.writeInvalidParameterError(w, err)
+			return
+		case errors.Is(err, storage.ErrAPIKeyExists):
+			ctrl.writeError(w, http.StatusConflict, err, "failed to create api key")
+			return
+		default:
+			ctrl.writeInternalServerError(w, err, "failed to create api key")
+			return
+		}
+		ctrl.log.WithField("name", k.Name).WithField("role", k.Role).Info("api key created")
+		ctrl.audit(r, storage.AuditEvent{
+			Action:  storage.AuditActionCreateAPIKey,
+			Details: map[string]string{"name": k.Name, "role": string(k.Role), "apps": strings.Join(k.Apps, ",")},
+		})
+		ctrl.writeResponseJSON(w, createAPIKeyResponse{
+			apiKey: newAPIKeyResponse(*k),
+			Key:    token,
+		})
+
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			ctrl.writeInvalidParameterError(w, errNameIsRequired)
+			return
+		}
+		err := ctrl.authorizeAPIKeyTenant(r.Context(), name)
+		if err == nil {
+			err = ctrl.storage.DeleteAPIKey(name)
+		}
+		switch {
+		case err == nil:
+		case errors.Is(err, storage.ErrAPIKeyNotFound):
+			ctrl.writeError(w, http.StatusNotFound, err, "failed to delete api key")
+			return
+		default:
+			ctrl.writeInternalServerError(w, err, "failed to delete api key")
+			return
+		}
+		ctrl.log.WithField("name", name).Info("api key deleted")
+		ctrl.audit(r, storage.AuditEvent{
+			Action:  storage.AuditActionDeleteAPIKey,
+			Details: map[string]string{"name": name},
+		})
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		ctrl.writeInvalidMethodError(w)
+	}
+}
+
+// apiKeyMiddleware authenticates requests with the API key provided in
+// the Authorization header, if API keys are enabled: the key role must
+// grant the permission.
+func (ctrl *Controller) apiKeyMiddleware(p storage.Permission) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !ctrl.config.Auth.APIKeys.Enabled {
+				next.ServeHTTP(w, r)
+				return
+			}
+			token, ok := bearerToken(r)
+			if !ok {
+				ctrl.writeErrorMessage(w, http.StatusUnauthorized, "api key is required")
+				return
+			}
+			k, err := ctrl.authenticateAPIKey(token)
+			if err != nil {
+				ctrl.writeError(w, http.StatusUnauthorized, err, "authentication failed")
+				return
+			}
+			if !k.Role.Allows(p) {
+				ctrl.writeErrorMessage(w, http.StatusForbidden, "api key role does not allow the operation")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, k)))
+		}
+	}
+}
+
+func newAPIKeyResponse(k storage.APIKey) apiKey {
+	return apiKey{Name: k.Name, Role: k.Role, CreatedAt: k.CreatedAt, Apps: k.Apps, Tenant: k.Tenant}
+}
+
+// authorizeApp checks whether the API key the request is authenticated
+// with, if any, is allowed to access the application. Application
+// patterns of the key are relative to the request tenant.
+func authorizeApp(ctx context.Context, appName string) error {
+	appName = stripTenant(ctx, appName)
+	if k, ok := ctx.Value(apiKeyContextKey{}).(*storage.APIKey); ok && !k.AllowsApp(appName) {
+		return fmt.Errorf("%w: %s", errAppAccessDenied, appName)
+	}
+	return nil
+}
+
+// allowedApps returns the names of the applications the API key the
+// request is authenticated with is allowed to access, among the
+// applications of the request tenant.
+func allowedApps(ctx context.Context, names []string) []string {
+	names = tenantApps(ctx, names)
+	k, ok := ctx.Value(apiKeyContextKey{}).(*storage.APIKey)
+	if !ok || len(k.Apps) == 0 {
+		return names
+	}
+	allowed := make([]string, 0, len(names))
+	for _, n := range names {
+		if k.AllowsApp(n) {
+			allowed = append(allowed, n)
+		}
+	}
+	return allowed
+}
+
+// authorizeLabelsQuery checks whether the request is allowed to list
+// labels of the series matching the query. Keys limited to particular
+// applications and tenants are only allowed to list names of the
+// applications, unless an accessible application is queried.
+func authorizeLabelsQuery(ctx context.Context, q *flameql.Query, label string) error {
+	if q != nil {
+		return authorizeApp(ctx, q.AppName)
+	}
+	if label == "__name__" {
+		return nil
+	}
+	if _, ok := tenantFromContext(ctx); ok {
+		return fmt.Errorf("%w: query is required", errAppAccessDenied)
+	}
+	if k, ok := ctx.Value(apiKeyContextKey{}).(*storage.APIKey); ok && len(k.Apps) > 0 {
+		return fmt.Errorf("%w: query is required", errAppAccessDenied)
+	}
+	return nil
+}
+
+// authorizeAPIKeyTenant returns storage.ErrAPIKeyNotFound if the key
+// does not belong to the request tenant.
+func (ctrl *Controller) authorizeAPIKeyTenant(ctx context.Context, name string) error {
+	tenant, ok := tenantFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	for _, k := range ctrl.storage.APIKeys() {
+		if k.Name == name && k.Tenant == tenant {
+			return nil
+		}
+	}
+	return storage.ErrAPIKeyNotFound
+}
+
+func (ctrl *Controller) authenticateAPIKey(token string) (*storage.APIKey, error) {
+	if a := ctrl.config.Auth.APIKeys.AdminKey; a != "" && subtle.ConstantTimeCompare([]byte(a), []byte(token)) == 1 {
+		return &storage.APIKey{Name: adminAPIKeyName, Role: storage.APIKeyRoleAdmin}, nil
+	}
+	return ctrl.storage.AuthenticateAPIKey(token)
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	h := r.Header.Get("Authorization")
+	if len(h) <= len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(h[len(prefix):]), true
+}
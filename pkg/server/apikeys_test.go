@@ -0,0 +1,177 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("API keys", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		const adminKey = "admin-secret"
+
+		BeforeEach(func() {
+			(*cfg).Server.Auth.APIKeys.Enabled = true
+			(*cfg).Server.Auth.APIKeys.AdminKey = adminKey
+		})
+
+		ts := withTestServer(cfg)
+
+		do := func(method, path, token string, body []byte) *http.Response {
+			req, err := http.NewRequest(method, ts.URL+path, bytes.NewReader(body))
+			Expect(err).ToNot(HaveOccurred())
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			res, err := http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			return res
+		}
+
+		createKey := func(name, role string, apps ...string) string {
+			b, _ := json.Marshal(createAPIKeyRequest{Name: name, Role: role, Apps: apps})
+			res := do(http.MethodPost, "/api/keys", adminKey, b)
+			defer res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			var k createAPIKeyResponse
+			Expect(json.NewDecoder(res.Body).Decode(&k)).To(Succeed())
+			Expect(k.Name).To(Equal(name))
+			Expect(k.Key).ToNot(BeEmpty())
+			return k.Key
+		}
+
+		ingestApp := func(token, app string) int {
+			q := url.Values{"name": []string{app}, "from": []string{"1609459200"}, "until": []string{"1609459210"}}
+			res := do(http.MethodPost, "/ingest?"+q.Encode(), token, []byte("main;foo 1\n"))
+			res.Body.Close()
+			return res.StatusCode
+		}
+
+		ingest := func(token string) int {
+			return ingestApp(token, "app.cpu")
+		}
+
+		renderApp := func(token, app string) int {
+			q := url.Values{"query": []string{app}, "from": []string{"1609459200"}, "until": []string{"1609459210"}, "format": []string{"json"}}
+			res := do(http.MethodGet, "/render?"+q.Encode(), token, nil)
+			res.Body.Close()
+			return res.StatusCode
+		}
+
+		render := func(token string) int {
+			return renderApp(token, "app.cpu")
+		}
+
+		It("enforces key roles", func() {
+			ingestKey := createKey("agent", "ingest")
+			readKey := createKey("dashboard", "read-only")
+
+			Expect(ingest("")).To(Equal(http.StatusUnauthorized))
+			Expect(ingest("invalid")).To(Equal(http.StatusUnauthorized))
+			Expect(ingest(readKey)).To(Equal(http.StatusForbidden))
+			Expect(ingest(ingestKey)).To(Equal(http.StatusOK))
+
+			Expect(render("")).To(Equal(http.StatusUnauthorized))
+			Expect(render(ingestKey)).To(Equal(http.StatusForbidden))
+			Expect(render(readKey)).To(Equal(http.StatusOK))
+			Expect(render(adminKey)).To(Equal(http.StatusOK))
+
+			res := do(http.MethodGet, "/api/keys", readKey, nil)
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+			res = do(http.MethodDelete, "/api/apps?name=app.cpu", readKey, nil)
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+		})
+
+		It("limits keys to applications", func() {
+			ingestKey := createKey("payments-agent", "ingest", "payments.*")
+			readKey := createKey("payments-dashboard", "read-only", "Payments.*")
+			Expect(ingestApp(ingestKey, "payments.cpu")).To(Equal(http.StatusOK))
+			Expect(ingestApp(ingestKey, "orders.cpu")).To(Equal(http.StatusForbidden))
+			Expect(ingestApp(adminKey, "orders.cpu")).To(Equal(http.StatusOK))
+
+			Expect(renderApp(readKey, "payments.cpu")).To(Equal(http.StatusOK))
+			Expect(renderApp(readKey, "orders.cpu")).To(Equal(http.StatusForbidden))
+			Expect(renderApp(ingestKey, "payments.cpu")).To(Equal(http.StatusForbidden))
+
+			res := do(http.MethodGet, "/api/label-values?label=__name__", readKey, nil)
+			var names []string
+			Expect(json.NewDecoder(res.Body).Decode(&names)).To(Succeed())
+			res.Body.Close()
+			Expect(names).To(Equal([]string{"payments.cpu"}))
+			res = do(http.MethodGet, "/label-values?label=__name__", readKey, nil)
+			Expect(json.NewDecoder(res.Body).Decode(&names)).To(Succeed())
+			res.Body.Close()
+			Expect(names).To(Equal([]string{"payments.cpu"}))
+			res = do(http.MethodGet, "/api/labels", readKey, nil)
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+			res = do(http.MethodGet, "/api/labels?query=orders.cpu", readKey, nil)
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+			res = do(http.MethodGet, "/api/labels?query=payments.cpu", readKey, nil)
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			b, _ := json.Marshal(createAPIKeyRequest{Name: "invalid", Role: "ingest", Apps: []string{"[payments"}})
+			res = do(http.MethodPost, "/api/keys", adminKey, b)
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+		})
+
+		Context("rate limit", func() {
+			BeforeEach(func() {
+				(*cfg).Server.IngestKeyRateLimit = 0.5
+				(*cfg).Server.IngestKeyRateBurst = 2
+			})
+
+			It("limits keys across applications", func() {
+				ingestKey := createKey("agent", "ingest")
+				Expect(ingestApp(ingestKey, "app-1.cpu")).To(Equal(http.StatusOK))
+				Expect(ingestApp(ingestKey, "app-2.cpu")).To(Equal(http.StatusOK))
+				Expect(ingestApp(ingestKey, "app-3.cpu")).To(Equal(http.StatusTooManyRequests))
+				Expect(ingestApp(createKey("other-agent", "ingest"), "app-3.cpu")).To(Equal(http.StatusOK))
+			})
+		})
+
+		It("manages keys", func() {
+			createKey("agent", "ingest")
+			token := createKey("dashboard", "read-only")
+
+			b, _ := json.Marshal(createAPIKeyRequest{Name: "agent", Role: "admin"})
+			res := do(http.MethodPost, "/api/keys", adminKey, b)
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusConflict))
+			b, _ = json.Marshal(createAPIKeyRequest{Name: "ops", Role: "root"})
+			res = do(http.MethodPost, "/api/keys", adminKey, b)
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusBadRequest))
+
+			res = do(http.MethodGet, "/api/keys", adminKey, nil)
+			var keys []apiKey
+			Expect(json.NewDecoder(res.Body).Decode(&keys)).To(Succeed())
+			res.Body.Close()
+			Expect(keys).To(HaveLen(2))
+			Expect(keys[0].Name).To(Equal("agent"))
+			Expect(keys[0].Role).To(Equal(storage.APIKeyRoleIngest))
+			Expect(keys[1].Name).To(Equal("dashboard"))
+
+			res = do(http.MethodDelete, "/api/keys?name=dashboard", adminKey, nil)
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			res = do(http.MethodDelete, "/api/keys?name=dashboard", adminKey, nil)
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+			Expect(render(token)).To(Equal(http.StatusUnauthorized))
+		})
+	})
+})
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+)
+
+var errAppNameOnly = errors.New("name must not contain tags")
+
+// appsHandler deletes all the data of the application specified with
+// the name parameter: DELETE /api/apps?name=app.cpu
+func (ctrl *Controller) appsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		ctrl.writeInvalidMethodError(w)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		ctrl.writeInvalidParameterError(w, errNameIsRequired)
+		return
+	}
+	k, err := segment.ParseKey(tenantName(r.Context(), name))
+	if err != nil {
+		ctrl.writeInvalidParameterError(w, fmt.Errorf("name: %w", err))
+		return
+	}
+	if len(k.Labels()) != 1 {
+		ctrl.writeInvalidParameterError(w, errAppNameOnly)
+		return
+	}
+	if err = authorizeApp(r.Context(), k.AppName()); err != nil {
+		ctrl.writeInvalidParameterError(w, err)
+		return
+	}
+	if err = ctrl.storage.DeleteApp(k.AppName()); err != nil {
+		ctrl.writeInternalServerError(w, err, "failed to delete application")
+		return
+	}
+	ctrl.log.WithField("app", k.AppName()).Info("application deleted")
+	ctrl.audit(r, storage.AuditEvent{Action: storage.AuditActionDeleteApp, App: k.AppName()})
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("/api/apps", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		ts := withTestServer(cfg)
+
+		ingest := func(name string) {
+			q := url.Values{"name": []string{name}, "from": []string{"1609459200"}, "until": []string{"1609459210"}}
+			res, err := http.Post(ts.URL+"/ingest?"+q.Encode(), "text/plain", bytes.NewBufferString("foo;bar 1\n"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+		}
+
+		deleteApp := func(name string) int {
+			req, err := http.NewRequest(http.MethodDelete, ts.URL+"/api/apps?"+url.Values{"name": []string{name}}.Encode(), nil)
+			Expect(err).ToNot(HaveOccurred())
+			res, err := http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			res.Body.Close()
+			return res.StatusCode
+		}
+
+		It("deletes application data", func() {
+			ingest("app.cpu{foo=bar}")
+			ingest("other.cpu{foo=bar}")
+
+			Expect(deleteApp("app.cpu")).To(Equal(http.StatusOK))
+			Expect(ts.storage.GetAppNames()).To(Equal([]string{"other.cpu"}))
+			values := make([]string, 0)
+			ts.storage.GetValues("foo", func(v string) bool {
+				values = append(values, v)
+				return true
+			})
+			Expect(values).To(Equal([]string{"bar"}))
+		})
+
+		It("validates the name", func() {
+			Expect(deleteApp("")).To(Equal(http.StatusBadRequest))
+			Expect(deleteApp("app.cpu{foo=bar}")).To(Equal(http.StatusBadRequest))
+
+			res, err := http.Get(ts.URL + "/api/apps?name=app.cpu")
+			Expect(err).ToNot(HaveOccurred())
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusMethodNotAllowed))
+		})
+	})
+})
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/util/attime"
+)
+
+type userContextKey struct{}
+
+// auditHandler exports the audit log events recorded within the time
+// range as JSON lines: GET /api/audit?from=now-7d&until=now
+// The whole log is exported if the range is not specified. In
+// multi-tenancy mode, only events of the request tenant are exported.
+func (ctrl *Controller) auditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ctrl.writeInvalidMethodError(w)
+		return
+	}
+	var from, until time.Time
+	v := r.URL.Query()
+	if s := v.Get("from"); s != "" {
+		from = attime.Parse(s)
+	}
+	if s := v.Get("until"); s != "" {
+		until = attime.Parse(s)
+	}
+	tenant, _ := tenantFromContext(r.Context())
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	if err := ctrl.storage.AuditEvents(from, until, func(e *storage.AuditEvent) error {
+		if e.Tenant != tenant {
+			return nil
+		}
+		return enc.Encode(e)
+	}); err != nil {
+		// The response may be partially written.
+		ctrl.log.WithError(err).Error("failed to export audit log")
+	}
+}
+
+// audit records the action performed with the request in the audit
+// log, if enabled. Failures are logged and do not affect the request.
+func (ctrl *Controller) audit(r *http.Request, e storage.AuditEvent) {
+	if !ctrl.config.AuditLog {
+		return
+	}
+	e.Actor = auditActor(r.Context())
+	e.RemoteAddr = r.RemoteAddr
+	e.Tenant, _ = tenantFromContext(r.Context())
+	if err := ctrl.storage.AppendAuditEvent(&e); err != nil {
+		ctrl.log.WithError(err).
+			WithField("actor", e.Actor).
+			WithField("action", e.Action).
+			WithField("app", e.App).
+			Error("failed to record audit event")
+	}
+}
+
+func (ctrl *Controller) auditQuery(r *http.Request, gi *storage.GetInput) {
+	e := storage.AuditEvent{
+		Action: storage.AuditActionQuery,
+		From:   gi.StartTime.Unix(),
+		Until:  gi.EndTime.Unix(),
+	}
+	if gi.Key != nil {
+		e.App = gi.Key.Normalized()
+	} else if gi.Query != nil {
+		e.App = gi.Query.String()
+	}
+	ctrl.audit(r, e)
+}
+
+// auditActor returns the name of the user or API key the request is
+// authenticated with.
+func auditActor(ctx context.Context) string {
+	if k, ok := ctx.Value(apiKeyContextKey{}).(*storage.APIKey); ok {
+		return "api-key:" + k.Name
+	}
+	if name, ok := ctx.Value(userContextKey{}).(string); ok && name != "" {
+		return name
+	}
+	return "anonymous"
+}
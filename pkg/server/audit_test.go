@@ -0,0 +1,89 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("audit log", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		const adminKey = "admin-secret"
+
+		BeforeEach(func() {
+			(*cfg).Server.AuditLog = true
+			(*cfg).Server.Auth.APIKeys.Enabled = true
+			(*cfg).Server.Auth.APIKeys.AdminKey = adminKey
+		})
+
+		ts := withTestServer(cfg)
+
+		do := func(method, path string, body []byte) *http.Response {
+			req, err := http.NewRequest(method, ts.URL+path, bytes.NewReader(body))
+			Expect(err).ToNot(HaveOccurred())
+			req.Header.Set("Authorization", "Bearer "+adminKey)
+			res, err := http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			return res
+		}
+
+		export := func(path string) []storage.AuditEvent {
+			res := do(http.MethodGet, path, nil)
+			defer res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(res.Header.Get("Content-Type")).To(Equal("application/x-ndjson"))
+			var events []storage.AuditEvent
+			scanner := bufio.NewScanner(res.Body)
+			for scanner.Scan() {
+				var e storage.AuditEvent
+				Expect(json.Unmarshal(scanner.Bytes(), &e)).To(Succeed())
+				events = append(events, e)
+			}
+			return events
+		}
+
+		It("records queries and administrative actions", func() {
+			res := do(http.MethodGet, "/render?query=app.cpu%7B%7D&from=1609459200&until=1609459260&format=json", nil)
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			res = do(http.MethodPost, "/api/keys", []byte(`{"name":"ci","role":"ingest"}`))
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			res = do(http.MethodDelete, "/api/keys?name=ci", nil)
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			res = do(http.MethodDelete, "/api/data?query=app.cpu&from=1609459200&until=1609459260", nil)
+			Expect(res.StatusCode).To(Equal(http.StatusAccepted))
+
+			events := export("/api/audit")
+			// The first event records retention settings.
+			Expect(events).To(HaveLen(5))
+			Expect(events[0].Action).To(Equal(storage.AuditActionChangeRetention))
+			Expect(events[0].Actor).To(Equal("config"))
+
+			Expect(events[1].Action).To(Equal(storage.AuditActionQuery))
+			Expect(events[1].Actor).To(Equal("api-key:admin"))
+			Expect(events[1].App).To(Equal("app.cpu{}"))
+			Expect(events[1].From).To(Equal(int64(1609459200)))
+			Expect(events[1].Until).To(Equal(int64(1609459260)))
+			Expect(events[1].RemoteAddr).ToNot(BeEmpty())
+
+			Expect(events[2].Action).To(Equal(storage.AuditActionCreateAPIKey))
+			Expect(events[2].Details).To(HaveKeyWithValue("name", "ci"))
+			Expect(events[2].Details).To(HaveKeyWithValue("role", "ingest"))
+			Expect(events[3].Action).To(Equal(storage.AuditActionDeleteAPIKey))
+			Expect(events[4].Action).To(Equal(storage.AuditActionDeleteData))
+			Expect(events[4].App).To(Equal("app.cpu"))
+		})
+
+		It("exports events within the time range", func() {
+			Expect(export("/api/audit?from=now-1h&until=now")).To(HaveLen(1))
+			Expect(export("/api/audit?until=now-1h")).To(BeEmpty())
+		})
+	})
+})
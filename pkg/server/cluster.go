@@ -0,0 +1,529 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/cluster"
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/convert"
+	"github.com/pyroscope-io/pyroscope/pkg/flameql"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+)
+
+// In clustering mode, applications are sharded across the servers with
+// consistent hashing of the application names (as stored, i.e. including
+// the tenant prefix): a server owns all the data of an application.
+//
+// A server forwards profiles of applications it does not own to the owner,
+// and proxies queries of a single application to it. Requests that are not
+// bound to an application (listings of applications and labels) are sent
+// to all the servers, and the results are merged.
+//
+// Requests between the servers are authenticated with the cluster secret
+// and are never forwarded further, therefore a request takes at most one
+// hop even if the servers disagree on the peers. Note that API keys are
+// stored by every server independently: a request is authenticated and
+// authorized by the server it is received by.
+//
+// Servers probe the /healthz endpoint of each other periodically. A server
+// that fails to respond to a probe or a request is excluded from the ring
+// until it responds to a probe: its applications are owned by other servers
+// in the meantime. Profiles ingested in the meantime remain on the servers
+// they were written to, and are not shown once the owner is available again.
+
+const (
+	clusterSecretHeader = "X-Pyroscope-Cluster-Secret"
+	clusterActorHeader  = "X-Pyroscope-Cluster-Actor"
+
+	clusterIngestPath     = "/cluster/ingest"
+	clusterRequestTimeout = 30 * time.Second
+	// Forwarded profiles are small, and ingestion should not be held
+	// by an unavailable server for long.
+	clusterIngestTimeout = 5 * time.Second
+	clusterProbeTimeout  = 2 * time.Second
+
+	defaultClusterProbeInterval = 5 * time.Second
+)
+
+var (
+	errClusterAdvertiseURL = errors.New("cluster advertise URL must be one of the cluster peers")
+	errClusterSecret       = errors.New("cluster secret is required")
+	errClusterAuth         = errors.New("invalid cluster secret")
+	errClusterPeer         = errors.New("cluster peer request failed")
+)
+
+type clusterState struct {
+	self    string
+	secret  []byte
+	members *cluster.Members
+	peers   []string
+	proxies map[string]*httputil.ReverseProxy
+	client  *http.Client
+	// ingestClient is used to forward profiles.
+	ingestClient *http.Client
+
+	probeInterval time.Duration
+}
+
+// clusterContextKey marks requests received from other cluster servers.
+type clusterContextKey struct{}
+
+func newClusterState(c *config.Server, writeError func(http.ResponseWriter, int, error, string)) (*clusterState, error) {
+	if len(c.ClusterPeers) == 0 {
+		return nil, nil
+	}
+	if c.ClusterSecret == "" {
+		return nil, errClusterSecret
+	}
+	s := clusterState{
+		self:          c.ClusterAdvertiseURL,
+		secret:        []byte(c.ClusterSecret),
+		proxies:       make(map[string]*httputil.ReverseProxy, len(c.ClusterPeers)),
+		client:        &http.Client{Timeout: clusterRequestTimeout},
+		ingestClient:  &http.Client{Timeout: clusterIngestTimeout},
+		probeInterval: c.ClusterProbeInterval,
+	}
+	var err error
+	if s.members, err = cluster.NewMembers(s.self, c.ClusterPeers, cluster.DefaultTokens, s.probe, clusterProbeTimeout); err != nil {
+		return nil, err
+	}
+	for _, p := range c.ClusterPeers {
+		if p == s.self {
+			continue
+		}
+		u, err := url.Parse(p)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid cluster peer %q", p)
+		}
+		proxy := httputil.NewSingleHostReverseProxy(u)
+		peer := p
+		proxy.ErrorHandler = func(w http.ResponseWriter, _ *http.Request, err error) {
+			s.members.MarkDown(peer)
+			writeError(w, http.StatusBadGateway, err, errClusterPeer.Error())
+		}
+		s.peers = append(s.peers, p)
+		s.proxies[p] = proxy
+	}
+	if len(s.peers) == len(c.ClusterPeers) {
+		return nil, errClusterAdvertiseURL
+	}
+	return &s, nil
+}
+
+// start probes the peers periodically, until stop is called.
+func (s *clusterState) start() {
+	interval := s.probeInterval
+	if interval <= 0 {
+		interval = defaultClusterProbeInterval
+	}
+	s.members.Start(interval)
+}
+
+func (s *clusterState) stop() { s.members.Stop() }
+
+// probe checks the peer responds to /healthz requests.
+func (s *clusterState) probe(ctx context.Context, peer string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(peer, "/")+"/healthz", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *clusterState) authenticated(r *http.Request) bool {
+	h := r.Header.Get(clusterSecretHeader)
+	return h != "" && subtle.ConstantTimeCompare([]byte(h), s.secret) == 1
+}
+
+// setHeaders authenticates the request to another server and passes the
+// tenant and the actor of the original request.
+func (s *clusterState) setHeaders(ctx context.Context, h http.Header) {
+	h.Del("Authorization")
+	h.Set(clusterSecretHeader, string(s.secret))
+	h.Set(clusterActorHeader, auditActor(ctx))
+	if t, ok := tenantFromContext(ctx); ok {
+		h.Set(tenantHeader, t)
+	} else {
+		h.Del(tenantHeader)
+	}
+}
+
+// clusterAuthMiddleware rejects requests not authenticated with the
+// cluster secret with 401.
+func (ctrl *Controller) clusterAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ctrl.isClusterRequest(r) {
+			ctrl.writeError(w, http.StatusUnauthorized, errClusterAuth, "authentication failed")
+			return
+		}
+		next.ServeHTTP(w, withClusterContext(r))
+	}
+}
+
+func (ctrl *Controller) isClusterRequest(r *http.Request) bool {
+	return ctrl.cluster != nil && ctrl.cluster.authenticated(r)
+}
+
+// withClusterContext marks the request received from another server.
+// Such requests are trusted: the actor and the tenant are taken from
+// the request headers.
+func withClusterContext(r *http.Request) *http.Request {
+	ctx := context.WithValue(r.Context(), clusterContextKey{}, true)
+	if actor := r.Header.Get(clusterActorHeader); actor != "" {
+		ctx = context.WithValue(ctx, userContextKey{}, actor)
+	}
+	return r.WithContext(ctx)
+}
+
+func isForwarded(ctx context.Context) bool {
+	_, ok := ctx.Value(clusterContextKey{}).(bool)
+	return ok
+}
+
+// clusterPut writes the profile to the storage, if the application is
+// owned by this server, or sends it to the owner otherwise. If the owner
+// is not available, the profile is sent to the next owner.
+func (ctrl *Controller) clusterPut(pi *storage.PutInput) error {
+	var err error
+	// Every attempt excludes the owner from the ring, unless
+	// it responds to a probe concurrently.
+	for i := 0; i <= len(ctrl.cluster.peers); i++ {
+		owner := ctrl.cluster.members.Ring().Get(pi.Key.AppName())
+		if owner == ctrl.cluster.self {
+			return ctrl.storage.Put(pi)
+		}
+		err = ctrl.forwardPut(owner, pi)
+		var unavailable *clusterPeerUnavailableError
+		if !errors.As(err, &unavailable) {
+			return err
+		}
+		ctrl.log.WithError(err).Warn("cluster peer is unavailable")
+		ctrl.cluster.members.MarkDown(owner)
+	}
+	return err
+}
+
+// clusterPeerUnavailableError indicates the peer failed to respond.
+type clusterPeerUnavailableError struct {
+	peer string
+	err  error
+}
+
+func (e *clusterPeerUnavailableError) Error() string {
+	return fmt.Sprintf("%s: %s: %v", errClusterPeer, e.peer, e.err)
+}
+
+func (e *clusterPeerUnavailableError) Unwrap() error { return errClusterPeer }
+
+func (ctrl *Controller) forwardPut(owner string, pi *storage.PutInput) error {
+	q := url.Values{
+		"name":            []string{pi.Key.Normalized()},
+		"from":            []string{strconv.FormatInt(pi.StartTime.Unix(), 10)},
+		"until":           []string{strconv.FormatInt(pi.EndTime.Unix(), 10)},
+		"spyName":         []string{pi.SpyName},
+		"sampleRate":      []string{strconv.Itoa(int(pi.SampleRate))},
+		"units":           []string{pi.Units},
+		"aggregationType": []string{pi.AggregationType},
+	}
+	u := strings.TrimSuffix(owner, "/") + clusterIngestPath + "?" + q.Encode()
+	req, err := http.NewRequest(http.MethodPost, u, strings.NewReader(pi.Val.Collapsed()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header.Set(clusterSecretHeader, string(ctrl.cluster.secret))
+	resp, err := ctrl.cluster.ingestClient.Do(req)
+	if err != nil {
+		return &clusterPeerUnavailableError{peer: owner, err: err}
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s: unexpected response status: %s", errClusterPeer, owner, resp.Status)
+	}
+	return nil
+}
+
+// clusterIngestHandler writes profiles forwarded by other servers to the
+// storage. The profiles are already relabeled and evaluated against the
+// metrics export rules by the server they were received by.
+func (ctrl *Controller) clusterIngestHandler(h ingestHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			ctrl.writeInvalidMethodError(w)
+			return
+		}
+		pi, err := h.ingestParamsFromRequest(r)
+		if err != nil {
+			ctrl.writeInvalidParameterError(w, err)
+			return
+		}
+		pi.Val = tree.New()
+		if err = convert.ParseGroups(r.Body, pi.Val.InsertInt); err != nil {
+			ctrl.writeError(w, http.StatusUnprocessableEntity, err, "error happened while parsing request body")
+			return
+		}
+		if err = ctrl.storage.Put(pi); err != nil {
+			ctrl.writeInternalServerError(w, err, "error happened while ingesting data")
+		}
+	}
+}
+
+// clusterProxy passes the request of a single application to the server
+// owning the application. The application is specified with either name
+// or query parameter, or a field of the JSON request body.
+func (ctrl *Controller) clusterProxy(next http.HandlerFunc) http.HandlerFunc {
+	if ctrl.cluster == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isForwarded(r.Context()) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		appName, err := requestAppName(r)
+		if err != nil || appName == "" {
+			// The handler reports invalid parameters.
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctrl.proxyToOwner(w, r, appName, next)
+	}
+}
+
+func (ctrl *Controller) proxyToOwner(w http.ResponseWriter, r *http.Request, appName string, next http.HandlerFunc) {
+	if err := authorizeApp(r.Context(), appName); err != nil {
+		ctrl.writeInvalidParameterError(w, err)
+		return
+	}
+	owner := ctrl.cluster.members.Ring().Get(appName)
+	if owner == ctrl.cluster.self {
+		next.ServeHTTP(w, r)
+		return
+	}
+	pr := r.Clone(r.Context())
+	ctrl.cluster.setHeaders(r.Context(), pr.Header)
+	ctrl.cluster.proxies[owner].ServeHTTP(w, pr)
+}
+
+// requestAppName returns the name of the application the request refers
+// to, with the tenant prefix. The request body is read, if necessary, and
+// restored to be read by the handler.
+func requestAppName(r *http.Request) (string, error) {
+	v := r.URL.Query()
+	name, query := v.Get("name"), v.Get("query")
+	if r.Method == http.MethodPost && name == "" && query == "" {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			return "", err
+		}
+		r.Body = io.NopCloser(bytes.NewReader(b))
+		var p struct {
+			Name  string `json:"name"`
+			Query string `json:"query"`
+		}
+		if err = json.Unmarshal(b, &p); err != nil {
+			return "", err
+		}
+		name, query = p.Name, p.Query
+	}
+	switch {
+	case name != "":
+		k, err := segment.ParseKey(tenantName(r.Context(), name))
+		if err != nil {
+			return "", err
+		}
+		return k.AppName(), nil
+	case query != "":
+		q, err := flameql.ParseQuery(tenantName(r.Context(), query))
+		if err != nil {
+			return "", err
+		}
+		return q.AppName, nil
+	}
+	return "", nil
+}
+
+// clusterMerge passes requests of label names and values of a single
+// application to the owner, as clusterProxy does. Otherwise, the request
+// is sent to all the servers and the values they respond with are merged.
+func (ctrl *Controller) clusterMerge(next http.HandlerFunc) http.HandlerFunc {
+	if ctrl.cluster == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isForwarded(r.Context()) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if q := r.URL.Query().Get("query"); q != "" {
+			if qry, err := flameql.ParseQuery(tenantName(r.Context(), q)); err == nil {
+				ctrl.proxyToOwner(w, r, qry.AppName, next)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		var lw batchEntryWriter
+		next.ServeHTTP(&lw, r)
+		if lw.status() != http.StatusOK {
+			lw.writeTo(w)
+			return
+		}
+		var values []string
+		if err := json.Unmarshal(lw.body.Bytes(), &values); err != nil {
+			ctrl.writeInternalServerError(w, err, "failed to decode response")
+			return
+		}
+		peerValues, err := ctrl.peerValues(r, r.URL.Path, r.URL.Query())
+		if err != nil {
+			ctrl.writeError(w, http.StatusBadGateway, err, errClusterPeer.Error())
+			return
+		}
+		if r.URL.Query().Get("label") == "__name__" {
+			peerValues = allowedPeerApps(r.Context(), peerValues)
+		}
+		ctrl.writeResponseJSON(w, mergeValues(values, peerValues))
+	}
+}
+
+// peerValues sends the request to all the other servers of the cluster
+// available and returns the values they respond with. Peers apply
+// limitations of the tenant but not of the API key: the caller is
+// responsible for that.
+func (ctrl *Controller) peerValues(r *http.Request, p string, q url.Values) ([]string, error) {
+	var (
+		wg     sync.WaitGroup
+		m      sync.Mutex
+		values []string
+		errs   []error
+	)
+	for _, peer := range ctrl.cluster.peers {
+		if !ctrl.cluster.members.Available(peer) {
+			continue
+		}
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			v, err := ctrl.getPeerValues(r.Context(), peer, p, q)
+			m.Lock()
+			defer m.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			values = append(values, v...)
+		}(peer)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return values, nil
+}
+
+func (ctrl *Controller) getPeerValues(ctx context.Context, peer, p string, q url.Values) ([]string, error) {
+	u, err := url.Parse(peer)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join(u.Path, p)
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	ctrl.cluster.setHeaders(ctx, req.Header)
+	resp, err := ctrl.cluster.client.Do(req)
+	if err != nil {
+		ctrl.cluster.members.MarkDown(peer)
+		return nil, &clusterPeerUnavailableError{peer: peer, err: err}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("%w: %s: unexpected response status: %s", errClusterPeer, peer, resp.Status)
+	}
+	var values []string
+	if err = json.NewDecoder(resp.Body).Decode(&values); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", errClusterPeer, peer, err)
+	}
+	return values, nil
+}
+
+// clusterAppNames returns names of the applications of all the servers
+// of the cluster and the federation, which the request is allowed to
+// access.
+func (ctrl *Controller) clusterAppNames(r *http.Request) []string {
+	names := allowedApps(r.Context(), ctrl.storage.GetAppNames())
+	q := url.Values{"label": []string{"__name__"}}
+	if ctrl.cluster != nil && !isForwarded(r.Context()) {
+		peerNames, err := ctrl.peerValues(r, "/label-values", q)
+		if err != nil {
+			ctrl.log.WithError(err).Warn("failed to list applications of cluster peers")
+		}
+		names = mergeValues(names, allowedPeerApps(r.Context(), peerNames))
+	}
+	if ctrl.isFederated(r) {
+		names = mergeValues(names, allowedPeerApps(r.Context(), ctrl.federatedValues(r, "/label-values", q)))
+	}
+	return names
+}
+
+// allowedPeerApps filters names of the applications that peers respond
+// with, according to the API key the request is authenticated with.
+func allowedPeerApps(ctx context.Context, names []string) []string {
+	k, ok := ctx.Value(apiKeyContextKey{}).(*storage.APIKey)
+	if !ok || len(k.Apps) == 0 {
+		return names
+	}
+	apps := make([]string, 0, len(names))
+	for _, n := range names {
+		if k.AllowsApp(n) {
+			apps = append(apps, n)
+		}
+	}
+	return apps
+}
+
+func mergeValues(a, b []string) []string {
+	set := make(map[string]struct{}, len(a)+len(b))
+	for _, v := range a {
+		set[v] = struct{}{}
+	}
+	for _, v := range b {
+		set[v] = struct{}{}
+	}
+	values := make([]string, 0, len(set))
+	for v := range set {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return values
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("cluster", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		const secret = "cluster-secret"
+		var (
+			servers  [2]*httptest.Server
+			storages [2]*storage.Storage
+			ctrls    [2]*Controller
+			peers    []string
+		)
+
+		BeforeEach(func() {
+			peers = peers[:0]
+			for i := range servers {
+				servers[i] = httptest.NewUnstartedServer(nil)
+				peers = append(peers, "http://"+servers[i].Listener.Addr().String())
+			}
+		})
+
+		JustBeforeEach(func() {
+			for i := range servers {
+				c := (*cfg).Server
+				c.StoragePath = filepath.Join(c.StoragePath, strconv.Itoa(i))
+				c.ClusterPeers = peers
+				c.ClusterAdvertiseURL = peers[i]
+				c.ClusterSecret = secret
+				var err error
+				storages[i], err = storage.New(storage.NewConfig(&c), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+				Expect(err).ToNot(HaveOccurred())
+				ctrls[i] = newTestController(&c, storages[i])
+				servers[i].Config.Handler, _ = ctrls[i].mux()
+				servers[i].Start()
+			}
+		})
+
+		JustAfterEach(func() {
+			for i := range servers {
+				servers[i].Close()
+				storages[i].Close()
+			}
+		})
+
+		// appOwnedBy returns the name of an application owned by the server.
+		appOwnedBy := func(i int) string {
+			c := (*cfg).Server
+			c.ClusterPeers = peers
+			c.ClusterAdvertiseURL = peers[0]
+			c.ClusterSecret = secret
+			s, err := newClusterState(&c, nil)
+			Expect(err).ToNot(HaveOccurred())
+			for n := 0; ; n++ {
+				if app := fmt.Sprintf("app-%d.cpu", n); s.members.Ring().Get(app) == peers[i] {
+					return app
+				}
+			}
+		}
+
+		ingest := func(app string) int {
+			q := url.Values{"name": []string{app}, "from": []string{"1609459200"}, "until": []string{"1609459210"}}
+			res, err := http.Post(servers[0].URL+"/ingest?"+q.Encode(), "text/plain", bytes.NewBufferString("main;foo 1\n"))
+			Expect(err).ToNot(HaveOccurred())
+			res.Body.Close()
+			return res.StatusCode
+		}
+
+		It("shards applications across the servers", func() {
+			localApp, remoteApp := appOwnedBy(0), appOwnedBy(1)
+			Expect(ingest(localApp)).To(Equal(http.StatusOK))
+			Expect(ingest(remoteApp)).To(Equal(http.StatusOK))
+			Expect(storages[0].GetAppNames()).To(ConsistOf(localApp))
+			Expect(storages[1].GetAppNames()).To(ConsistOf(remoteApp))
+
+			By("proxying queries to the owner")
+			q := url.Values{"query": []string{remoteApp + "{}"}, "from": []string{"1609459200"}, "until": []string{"1609459210"}, "format": []string{"json"}}
+			res, err := http.Get(servers[0].URL + "/render?" + q.Encode())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			var r struct {
+				Flamebearer struct {
+					NumTicks int `json:"numTicks"`
+				} `json:"flamebearer"`
+			}
+			Expect(json.NewDecoder(res.Body).Decode(&r)).To(Succeed())
+			res.Body.Close()
+			Expect(r.Flamebearer.NumTicks).To(Equal(1))
+
+			By("merging application names")
+			res, err = http.Get(servers[0].URL + "/api/label-values?label=__name__")
+			Expect(err).ToNot(HaveOccurred())
+			var names []string
+			Expect(json.NewDecoder(res.Body).Decode(&names)).To(Succeed())
+			res.Body.Close()
+			Expect(names).To(ConsistOf(localApp, remoteApp))
+		})
+
+		It("excludes unavailable servers from the ring", func() {
+			remoteApp := appOwnedBy(1)
+			servers[1].Close()
+			Expect(ingest(remoteApp)).To(Equal(http.StatusOK))
+			Expect(storages[0].GetAppNames()).To(ConsistOf(remoteApp))
+			Expect(ctrls[0].cluster.members.Down()).To(Equal([]string{peers[1]}))
+
+			res, err := http.Get(servers[0].URL + "/api/label-values?label=__name__")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			var names []string
+			Expect(json.NewDecoder(res.Body).Decode(&names)).To(Succeed())
+			res.Body.Close()
+			Expect(names).To(ConsistOf(remoteApp))
+
+			By("probing the servers")
+			ctrls[0].cluster.members.Probe(context.Background())
+			Expect(ctrls[0].cluster.members.Down()).To(Equal([]string{peers[1]}))
+		})
+
+		It("rejects forwarded profiles without the cluster secret", func() {
+			res, err := http.Post(servers[1].URL+clusterIngestPath+"?name=app.cpu", "text/plain", bytes.NewBufferString("main;foo 1\n"))
+			Expect(err).ToNot(HaveOccurred())
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+			Expect(storages[1].GetAppNames()).To(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("cluster configuration", func() {
+	It("is validated", func() {
+		_, err := newClusterState(&config.Server{ClusterPeers: []string{"http://a:4040"}, ClusterAdvertiseURL: "http://a:4040"}, nil)
+		Expect(err).To(MatchError(errClusterSecret))
+		_, err = newClusterState(&config.Server{ClusterPeers: []string{"http://a:4040"}, ClusterAdvertiseURL: "http://b:4040", ClusterSecret: "x"}, nil)
+		Expect(err).To(MatchError(errClusterAdvertiseURL))
+		s, err := newClusterState(&config.Server{}, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(s).To(BeNil())
+	})
+})
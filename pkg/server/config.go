@@ -6,7 +6,7 @@ import (
 )
 
 func (ctrl *Controller) configHandler(w http.ResponseWriter, _ *http.Request) {
-	configBytes, err := json.MarshalIndent(ctrl.config, "", "  ")
+	configBytes, err := json.MarshalIndent(ctrl.currentConfig(), "", "  ")
 	if err != nil {
 		ctrl.writeJSONEncodeError(w, err)
 		return
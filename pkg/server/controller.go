@@ -3,6 +3,7 @@ package server
 import (
 	"compress/gzip"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -27,7 +28,9 @@ import (
 
 	adhocserver "github.com/pyroscope-io/pyroscope/pkg/adhoc/server"
 	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/relabel"
 	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/symbols"
 	"github.com/pyroscope-io/pyroscope/pkg/util/hyperloglog"
 	"github.com/pyroscope-io/pyroscope/pkg/util/updates"
 	"github.com/pyroscope-io/pyroscope/webapp"
@@ -43,14 +46,23 @@ const (
 )
 
 type Controller struct {
-	drained uint32
+	// shuttingDown is set once the server starts draining: it is not
+	// ready to serve new requests.
+	shuttingDown uint32
+	// drainMutex synchronizes requests in flight with draining.
+	drainMutex sync.RWMutex
+	drained    bool
+	inFlight   sync.WaitGroup
 
 	config     *config.Server
 	storage    *storage.Storage
 	log        *logrus.Logger
 	httpServer *http.Server
+	// tlsConfig is set if client certificates are verified.
+	tlsConfig  *tls.Config
 	notifier   Notifier
 	metricsMdw middleware.Middleware
+	metrics    *controllerMetrics
 	dir        http.FileSystem
 
 	statsMutex sync.Mutex
@@ -62,6 +74,30 @@ type Controller struct {
 	exportedMetrics *prometheus.Registry
 	exporter        storage.MetricsExporter
 
+	remoteWriter RemoteWriter
+	ingestQuotas *ingestQuotas
+	usage        *usageTracker
+	// reloadMutex guards the settings replaced on config reload.
+	reloadMutex      sync.RWMutex
+	ingestLimiter    *ingestLimiter
+	ingestKeyLimiter *ingestLimiter
+	relabelConfigs   []*relabel.Config
+	// reloadedConfig is the configuration applied on the last reload,
+	// or the initial one. It is replaced, but never modified.
+	reloadedConfig *config.Server
+	reloader       ConfigReloader
+	// ingestIPFilter and uiIPFilter are set if access to the ingestion
+	// and other routes respectively is limited to particular networks.
+	ingestIPFilter *ipFilter
+	uiIPFilter     *ipFilter
+	// symbolMappers de-obfuscate frame names of rendered profiles.
+	symbolMappers *symbols.AppMappers
+
+	// cluster is set if the server is a member of a cluster.
+	cluster *clusterState
+	// federation lists remote servers queries are sent to.
+	federation []*federationTarget
+
 	// Adhoc mode
 	adhoc adhocserver.Server
 }
@@ -79,6 +115,12 @@ type Config struct {
 	ExportedMetricsRegistry *prometheus.Registry
 	storage.MetricsExporter
 
+	// RemoteWriter is optional.
+	RemoteWriter RemoteWriter
+	// Reloader is optional: if set, the configuration
+	// can be reloaded with /-/reload.
+	Reloader ConfigReloader
+
 	Adhoc adhocserver.Server
 }
 
@@ -106,6 +148,13 @@ func New(c Config) (*Controller, error) {
 		stats:    make(map[string]int),
 		appStats: mustNewHLL(),
 
+		remoteWriter:     c.RemoteWriter,
+		ingestLimiter:    newIngestLimiter(c.Configuration.IngestRateLimit, c.Configuration.IngestRateBurst),
+		ingestKeyLimiter: newIngestLimiter(c.Configuration.IngestKeyRateLimit, c.Configuration.IngestKeyRateBurst),
+		relabelConfigs:   c.Configuration.IngestRelabelConfigs,
+		reloadedConfig:   c.Configuration,
+		reloader:         c.Reloader,
+
 		exportedMetrics: c.ExportedMetricsRegistry,
 		metricsMdw: middleware.New(middleware.Config{
 			Recorder: metrics.NewRecorder(metrics.Config{
@@ -114,7 +163,9 @@ func New(c Config) (*Controller, error) {
 			}),
 		}),
 
-		adhoc: c.Adhoc,
+		adhoc:   c.Adhoc,
+		usage:   newUsageTracker(),
+		metrics: newControllerMetrics(c.MetricsRegisterer),
 	}
 
 	var err error
@@ -122,6 +173,32 @@ func New(c Config) (*Controller, error) {
 	if err != nil {
 		return nil, err
 	}
+	if ctrl.symbolMappers, err = symbols.NewAppMappers(c.Configuration.SymbolMappings); err != nil {
+		return nil, err
+	}
+	if ctrl.tlsConfig, err = newTLSConfig(c.Configuration); err != nil {
+		return nil, err
+	}
+	if ctrl.ingestIPFilter, err = newIPFilter(c.Configuration.IngestAllowedCIDRs, c.Configuration.IngestDeniedCIDRs); err != nil {
+		return nil, fmt.Errorf("ingest ip filter: %w", err)
+	}
+	if ctrl.uiIPFilter, err = newIPFilter(c.Configuration.UIAllowedCIDRs, c.Configuration.UIDeniedCIDRs); err != nil {
+		return nil, fmt.Errorf("ui ip filter: %w", err)
+	}
+	if t := c.Configuration.DefaultTenant; t != "" {
+		if err = validateTenantID(t); err != nil {
+			return nil, fmt.Errorf("default tenant: %w", err)
+		}
+	}
+	if ctrl.cluster, err = newClusterState(c.Configuration, ctrl.writeError); err != nil {
+		return nil, fmt.Errorf("cluster: %w", err)
+	}
+	if ctrl.federation, err = newFederationTargets(c.Configuration); err != nil {
+		return nil, fmt.Errorf("federation: %w", err)
+	}
+	if ctrl.ingestQuotas, err = newIngestQuotas(c.Configuration.IngestQuotas, c.Storage); err != nil {
+		return nil, fmt.Errorf("ingest quotas: %w", err)
+	}
 
 	return &ctrl, nil
 }
@@ -143,18 +220,32 @@ func (ctrl *Controller) mux() (http.Handler, error) {
 		return nil, err
 	}
 
-	ingestHandler := NewIngestHandler(ctrl.log, ctrl.storage, ctrl.exporter, func(pi *storage.PutInput) {
+	ingestHandler := newIngestHandler(ctrl.log, ctrl.storage, ctrl.exporter, ctrl.remoteWriter, ctrl.currentRelabelConfigs, func(pi *storage.PutInput) {
 		ctrl.statsInc("ingest")
 		ctrl.statsInc("ingest:" + pi.SpyName)
 		ctrl.appStats.Add(hashString(pi.Key.AppName()))
 	})
+	ingestHandler.quotas = ctrl.ingestQuotas
+	ingestHandler.usage = ctrl.usage
+	if ctrl.cluster != nil {
+		ingestHandler.put = ctrl.clusterPut
+		ctrl.addRoutes(r, []route{{clusterIngestPath, ctrl.clusterIngestHandler(ingestHandler)}},
+			ctrl.drainMiddleware, ctrl.readOnlyMiddleware(false), ctrl.clusterAuthMiddleware)
+	}
 
 	insecureRoutes = append(insecureRoutes, []route{
-		{"/ingest", ingestHandler.ServeHTTP},
 		{"/forbidden", ctrl.forbiddenHandler()},
 		{"/assets/", r.PathPrefix("/assets/").Handler(http.FileServer(ctrl.dir)).GetHandler().ServeHTTP},
 	}...)
-	ctrl.addRoutes(r, insecureRoutes, ctrl.drainMiddleware)
+	uiIPFilter := ctrl.ipFilterMiddleware(ctrl.uiIPFilter)
+	ctrl.addRoutes(r, insecureRoutes, uiIPFilter, ctrl.drainMiddleware)
+
+	// Ingestion routes are only protected with API keys, if enabled.
+	ingestRoutes := []route{
+		{"/ingest", ctrl.logRequest(ctrl.ingestMetricsMiddleware(ctrl.ingestBodyLimitMiddleware(ctrl.ingestSignatureMiddleware(ctrl.ingestRateLimitMiddleware(ctrl.ingestDecodeMiddleware(ingestHandler.ServeHTTP))))))},
+		{"/ingest/batch", ctrl.logRequest(ctrl.ingestBodyLimitMiddleware(ctrl.ingestSignatureMiddleware(ctrl.ingestDecodeMiddleware(ctrl.ingestBatchHandler(ctrl.ingestMetricsMiddleware(ingestHandler.ServeHTTP))))))},
+	}
+	ctrl.addRoutes(r, ingestRoutes, ctrl.ipFilterMiddleware(ctrl.ingestIPFilter), ctrl.drainMiddleware, ctrl.readOnlyMiddleware(false), ctrl.apiKeyMiddleware(storage.PermissionIngest), ctrl.tenantMiddleware)
 
 	// Protected routes:
 	protectedRoutes := []route{
@@ -164,13 +255,37 @@ func (ctrl *Controller) mux() (http.Handler, error) {
 		{"/adhoc-single", ctrl.indexHandler()},
 		{"/adhoc-comparison", ctrl.indexHandler()},
 		{"/adhoc-comparison-diff", ctrl.indexHandler()},
-		{"/render", ctrl.renderHandler},
-		{"/render-diff", ctrl.renderDiffHandler},
-		{"/labels", ctrl.labelsHandler},
-		{"/label-values", ctrl.labelValuesHandler},
+		{"/render", ctrl.logRequest(ctrl.clusterProxy(ctrl.renderHandler))},
+		{"/render/expand", ctrl.logRequest(ctrl.clusterProxy(ctrl.renderExpandHandler))},
+		{"/render-diff", ctrl.logRequest(ctrl.clusterProxy(ctrl.renderDiffHandler))},
+		{"/render-diff-multi", ctrl.logRequest(ctrl.clusterProxy(ctrl.renderMultiDiffHandler))},
+		{"/labels", ctrl.federateValues(ctrl.clusterMerge(ctrl.labelsHandler))},
+		{"/label-values", ctrl.federateValues(ctrl.clusterMerge(ctrl.labelValuesHandler))},
+		{"/api/labels", ctrl.federateValues(ctrl.clusterMerge(ctrl.apiLabelsHandler))},
+		{"/api/label-values", ctrl.federateValues(ctrl.clusterMerge(ctrl.apiLabelValuesHandler))},
+		{"/api/exemplars", ctrl.clusterProxy(ctrl.exemplarsHandler)},
+		{"/api/top", ctrl.clusterProxy(ctrl.topHandler)},
+		{"/api/timeline", ctrl.clusterProxy(ctrl.timelineHandler)},
+		{"/api/limits", ctrl.limitsHandler},
 		{"/api/adhoc", ctrl.adhoc.AddRoutes(r.PathPrefix("/api/adhoc").Subrouter())},
 	}
-	ctrl.addRoutes(r, protectedRoutes, ctrl.drainMiddleware, ctrl.authMiddleware)
+	ctrl.addRoutes(r, protectedRoutes, uiIPFilter, ctrl.drainMiddleware, ctrl.authMiddleware(storage.PermissionRead), ctrl.tenantMiddleware)
+
+	// Routes modifying the data or server state.
+	adminRoutes := []route{
+		{"/api/apps", ctrl.clusterProxy(ctrl.appsHandler)},
+		{"/api/data", ctrl.clusterProxy(ctrl.dataHandler)},
+	}
+	if ctrl.config.Auth.APIKeys.Enabled {
+		adminRoutes = append(adminRoutes, route{"/api/keys", ctrl.apiKeysHandler})
+	}
+	if ctrl.config.AuditLog {
+		adminRoutes = append(adminRoutes, route{"/api/audit", ctrl.auditHandler})
+	}
+	ctrl.addRoutes(r, adminRoutes, uiIPFilter, ctrl.drainMiddleware, ctrl.readOnlyMiddleware(true), ctrl.authMiddleware(storage.PermissionAdmin), ctrl.tenantMiddleware)
+
+	// Usage of all the tenants is reported to admins.
+	ctrl.addRoutes(r, []route{{"/api/usage", ctrl.usageHandler}}, uiIPFilter, ctrl.drainMiddleware, ctrl.authMiddleware(storage.PermissionAdmin), ctrl.serverMiddleware)
 
 	// Diagnostic secure routes: must be protected but not drained.
 	diagnosticSecureRoutes := []route{
@@ -194,11 +309,17 @@ func (ctrl *Controller) mux() (http.Handler, error) {
 		}...)
 	}
 
-	ctrl.addRoutes(r, diagnosticSecureRoutes, ctrl.authMiddleware)
+	if ctrl.reloader != nil {
+		diagnosticSecureRoutes = append(diagnosticSecureRoutes, route{"/-/reload", ctrl.reloadHandler})
+	}
+
+	ctrl.addRoutes(r, diagnosticSecureRoutes, uiIPFilter, ctrl.authMiddleware(storage.PermissionAdmin), ctrl.serverMiddleware)
 	ctrl.addRoutes(r, []route{
 		{"/metrics", promhttp.Handler().ServeHTTP},
 		{"/exported-metrics", ctrl.exportedMetricsHandler},
 		{"/healthz", ctrl.healthz},
+		{"/readyz", ctrl.readyHandler},
+		{"/-/ready", ctrl.readyHandler},
 	})
 
 	return r, nil
@@ -255,6 +376,19 @@ func (ctrl *Controller) getAuthRoutes() ([]route, error) {
 		}...)
 	}
 
+	if ctrl.config.Auth.OIDC.Enabled {
+		oidcHandler, err := newOauthOIDCHandler(ctrl.config.Auth.OIDC, ctrl.config.BaseURL, ctrl.log)
+		if err != nil {
+			return nil, err
+		}
+
+		authRoutes = append(authRoutes, []route{
+			{"/auth/oidc/login", ctrl.oauthLoginHandler(oidcHandler)},
+			{"/auth/oidc/callback", ctrl.callbackHandler(oidcHandler.redirectRoute)},
+			{"/auth/oidc/redirect", ctrl.callbackRedirectHandler(oidcHandler)},
+		}...)
+	}
+
 	return authRoutes, nil
 }
 
@@ -289,9 +423,13 @@ func (ctrl *Controller) Start() error {
 		IdleTimeout:    30 * time.Second,
 		MaxHeaderBytes: 1 << 20,
 		ErrorLog:       golog.New(w, "", 0),
+		TLSConfig:      ctrl.tlsConfig,
 	}
 
 	updates.StartVersionUpdateLoop()
+	if ctrl.cluster != nil {
+		ctrl.cluster.start()
+	}
 
 	if ctrl.config.TLSCertificateFile != "" && ctrl.config.TLSKeyFile != "" {
 		err = ctrl.httpServer.ListenAndServeTLS(ctrl.config.TLSCertificateFile, ctrl.config.TLSKeyFile)
@@ -308,25 +446,79 @@ func (ctrl *Controller) Start() error {
 }
 
 func (ctrl *Controller) Stop() error {
+	if ctrl.cluster != nil {
+		ctrl.cluster.stop()
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
 	return ctrl.httpServer.Shutdown(ctx)
 }
 
+// Drain prepares the server for shutdown. /readyz starts reporting the
+// server is not ready, and requests are still served for the configured
+// delay, so that load balancers stop routing to the server. Then new
+// requests are rejected, and Drain waits for requests in flight (e.g.
+// ingestion and queries) to complete, but no longer than the shutdown
+// timeout. The storage can be safely closed afterwards.
 func (ctrl *Controller) Drain() {
-	atomic.StoreUint32(&ctrl.drained, 1)
+	atomic.StoreUint32(&ctrl.shuttingDown, 1)
+	if d := ctrl.config.ShutdownDrainDelay; d > 0 {
+		ctrl.log.WithField("delay", d).Info("server is not ready, waiting before draining")
+		time.Sleep(d)
+	}
+	ctrl.drainMutex.Lock()
+	ctrl.drained = true
+	ctrl.drainMutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		ctrl.inFlight.Wait()
+		close(done)
+	}()
+	timeout := ctrl.config.ShutdownTimeout
+	if timeout <= 0 {
+		<-done
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		ctrl.log.WithField("timeout", timeout).Warn("requests in flight did not complete in time")
+	}
 }
 
 func (ctrl *Controller) drainMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if atomic.LoadUint32(&ctrl.drained) > 0 {
+		ctrl.drainMutex.RLock()
+		if ctrl.drained {
+			ctrl.drainMutex.RUnlock()
 			w.WriteHeader(http.StatusServiceUnavailable)
 			return
 		}
+		ctrl.inFlight.Add(1)
+		ctrl.drainMutex.RUnlock()
+		defer ctrl.inFlight.Done()
 		next.ServeHTTP(w, r)
 	}
 }
 
+// readOnlyMiddleware rejects requests with 403, if the server is in
+// read-only mode. If allowReads is true, GET and HEAD requests are passed.
+func (ctrl *Controller) readOnlyMiddleware(allowReads bool) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if !ctrl.config.ReadOnly {
+			return next
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			if !allowReads || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+				ctrl.writeErrorMessage(w, http.StatusForbidden, "the server is in read-only mode")
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+	}
+}
+
 func (ctrl *Controller) trackMetrics(route string) func(next http.HandlerFunc) http.HandlerFunc {
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return std.Handler(route, ctrl.metricsMdw, next).ServeHTTP
@@ -334,7 +526,8 @@ func (ctrl *Controller) trackMetrics(route string) func(next http.HandlerFunc) h
 }
 
 func (ctrl *Controller) isAuthRequired() bool {
-	return ctrl.config.Auth.Google.Enabled || ctrl.config.Auth.Github.Enabled || ctrl.config.Auth.Gitlab.Enabled
+	return ctrl.config.Auth.Google.Enabled || ctrl.config.Auth.Github.Enabled || ctrl.config.Auth.Gitlab.Enabled ||
+		ctrl.config.Auth.OIDC.Enabled
 }
 
 func (ctrl *Controller) redirectPreservingBaseURL(w http.ResponseWriter, r *http.Request, urlStr string, status int) {
@@ -352,43 +545,64 @@ func (ctrl *Controller) redirectPreservingBaseURL(w http.ResponseWriter, r *http
 	http.Redirect(w, r, urlStr, status)
 }
 
-func (ctrl *Controller) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if !ctrl.isAuthRequired() {
-			next.ServeHTTP(w, r)
-			return
-		}
+// authMiddleware authenticates requests with the JWT cookie issued at
+// login or, if enabled, with an API key granting the permission. The key
+// is required if no login method is configured.
+func (ctrl *Controller) authMiddleware(p storage.Permission) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		apiKeyNext := ctrl.apiKeyMiddleware(p)(next)
+		return func(w http.ResponseWriter, r *http.Request) {
+			if ctrl.isClusterRequest(r) {
+				next.ServeHTTP(w, withClusterContext(r))
+				return
+			}
+			if ctrl.config.Auth.APIKeys.Enabled {
+				if _, ok := bearerToken(r); ok || !ctrl.isAuthRequired() {
+					apiKeyNext.ServeHTTP(w, r)
+					return
+				}
+			}
+			if !ctrl.isAuthRequired() {
+				next.ServeHTTP(w, r)
+				return
+			}
 
-		jwtCookie, err := r.Cookie(jwtCookieName)
-		if err != nil {
-			ctrl.log.WithFields(logrus.Fields{
-				"url":  r.URL.String(),
-				"host": r.Header.Get("Host"),
-			}).Debug("missing jwt cookie")
-			ctrl.redirectPreservingBaseURL(w, r, "/login", http.StatusTemporaryRedirect)
-			return
-		}
+			jwtCookie, err := r.Cookie(jwtCookieName)
+			if err != nil {
+				ctrl.log.WithFields(logrus.Fields{
+					"url":  r.URL.String(),
+					"host": r.Header.Get("Host"),
+				}).Debug("missing jwt cookie")
+				ctrl.redirectPreservingBaseURL(w, r, "/login", http.StatusTemporaryRedirect)
+				return
+			}
 
-		_, err = jwt.Parse(jwtCookie.Value, func(token *jwt.Token) (interface{}, error) {
-			if token.Method.Alg() != jwt.SigningMethodHS256.Alg() {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+			token, err := jwt.Parse(jwtCookie.Value, func(token *jwt.Token) (interface{}, error) {
+				if token.Method.Alg() != jwt.SigningMethodHS256.Alg() {
+					return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+				}
+				return []byte(ctrl.config.Auth.JWTSecret), nil
+			})
+
+			if err != nil {
+				ctrl.log.WithError(err).Error("invalid jwt token")
+				ctrl.redirectPreservingBaseURL(w, r, "/login", http.StatusTemporaryRedirect)
+				return
 			}
-			return []byte(ctrl.config.Auth.JWTSecret), nil
-		})
 
-		if err != nil {
-			ctrl.log.WithError(err).Error("invalid jwt token")
-			ctrl.redirectPreservingBaseURL(w, r, "/login", http.StatusTemporaryRedirect)
-			return
+			if claims, ok := token.Claims.(jwt.MapClaims); ok {
+				if name, ok := claims["name"].(string); ok {
+					r = r.WithContext(context.WithValue(r.Context(), userContextKey{}, name))
+				}
+			}
+			next.ServeHTTP(w, r)
 		}
-
-		next.ServeHTTP(w, r)
 	}
 }
 
 func (*Controller) expectFormats(format string) error {
 	switch format {
-	case "json", "pprof", "collapsed", "html", "":
+	case "json", "pprof", "collapsed", "html", "svg", "png", "dot", "callgraph", "":
 		return nil
 	default:
 		return errUnknownFormat
@@ -418,6 +632,12 @@ func (ctrl *Controller) writeInvalidMethodError(w http.ResponseWriter) {
 }
 
 func (ctrl *Controller) writeInvalidParameterError(w http.ResponseWriter, err error) {
+	// The application specified with a parameter may be inaccessible
+	// with the API key the request is authenticated with.
+	if errors.Is(err, errAppAccessDenied) {
+		ctrl.writeError(w, http.StatusForbidden, err, "access denied")
+		return
+	}
 	ctrl.writeError(w, http.StatusBadRequest, err, "invalid parameter")
 }
 
@@ -433,12 +653,12 @@ func (ctrl *Controller) writeErrorMessage(w http.ResponseWriter, code int, msg s
 	WriteErrorMessage(ctrl.log, w, code, msg)
 }
 
-func WriteError(log *logrus.Logger, w http.ResponseWriter, code int, err error, msg string) {
+func WriteError(log logrus.FieldLogger, w http.ResponseWriter, code int, err error, msg string) {
 	log.WithError(err).Error(msg)
 	writeMessage(w, code, "%s: %q", msg, err)
 }
 
-func WriteErrorMessage(log *logrus.Logger, w http.ResponseWriter, code int, msg string) {
+func WriteErrorMessage(log logrus.FieldLogger, w http.ResponseWriter, code int, msg string) {
 	log.Error(msg)
 	writeMessage(w, code, msg)
 }
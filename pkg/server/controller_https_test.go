@@ -105,6 +105,57 @@ var _ = Describe("server", func() {
 					Expect(errHTTPS).To(HaveOccurred())
 				},
 			)
+			It("Should verify client certificates when TLSClientCAFile is defined",
+				func() {
+					defer GinkgoRecover()
+					const addr = ":10047"
+					(*cfg).Server.APIBindAddr = addr
+					(*cfg).Server.TLSCertificateFile = filepath.Join(testDataDir, tlsCertificateFile)
+					(*cfg).Server.TLSKeyFile = filepath.Join(testDataDir, tlsKeyFile)
+					// The self-signed server certificate is used as the client one.
+					(*cfg).Server.TLSClientCAFile = filepath.Join(testDataDir, tlsCertificateFile)
+
+					s, err := storage.New(storage.NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+					Expect(err).ToNot(HaveOccurred())
+					c := newTestController(&(*cfg).Server, s)
+					c.dir = http.Dir(testDataDir)
+
+					go c.Start()
+					time.Sleep(50 * time.Millisecond)
+					defer s.Close()
+					defer c.Stop()
+
+					cert, err := tls.LoadX509KeyPair((*cfg).Server.TLSCertificateFile, (*cfg).Server.TLSKeyFile)
+					Expect(err).ToNot(HaveOccurred())
+					client := &http.Client{Transport: &http.Transport{
+						TLSClientConfig: &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{cert}},
+					}}
+					res, err := client.Get(fmt.Sprintf("https://localhost%s", addr))
+					Expect(err).ToNot(HaveOccurred())
+					defer res.Body.Close()
+					Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+					client = &http.Client{Transport: &http.Transport{
+						TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+					}}
+					_, err = client.Get(fmt.Sprintf("https://localhost%s", addr))
+					Expect(err).To(HaveOccurred())
+				},
+			)
+			It("Should reject invalid client certificate policies", func() {
+				(*cfg).Server.TLSCertificateFile = filepath.Join(testDataDir, tlsCertificateFile)
+				(*cfg).Server.TLSKeyFile = filepath.Join(testDataDir, tlsKeyFile)
+				(*cfg).Server.TLSClientAuth = "verify-if-given"
+				_, err := newTLSConfig(&(*cfg).Server)
+				Expect(err).To(HaveOccurred())
+				(*cfg).Server.TLSClientAuth = "require"
+				t, err := newTLSConfig(&(*cfg).Server)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(t.ClientAuth).To(Equal(tls.RequireAnyClientCert))
+				(*cfg).Server.TLSClientAuth = "always"
+				_, err = newTLSConfig(&(*cfg).Server)
+				Expect(err).To(HaveOccurred())
+			})
 		})
 	})
 })
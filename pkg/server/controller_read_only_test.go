@@ -0,0 +1,51 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("read-only mode", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		BeforeEach(func() {
+			// Read-only storage must be initialized beforehand.
+			s, err := storage.New(storage.NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.Close()).To(Succeed())
+			(*cfg).Server.ReadOnly = true
+		})
+
+		ts := withTestServer(cfg)
+
+		status := func(method, path string) int {
+			req, err := http.NewRequest(method, ts.URL+path, bytes.NewBufferString("foo;bar 1"))
+			Expect(err).ToNot(HaveOccurred())
+			res, err := http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			_ = res.Body.Close()
+			return res.StatusCode
+		}
+
+		It("rejects ingestion and modification of the data", func() {
+			Expect(status(http.MethodPost, "/ingest?name=app.cpu&from=1609459200&until=1609459210")).To(Equal(http.StatusForbidden))
+			Expect(status(http.MethodPost, "/ingest/batch")).To(Equal(http.StatusForbidden))
+			Expect(status(http.MethodDelete, "/api/apps")).To(Equal(http.StatusForbidden))
+			Expect(status(http.MethodDelete, "/api/data")).To(Equal(http.StatusForbidden))
+		})
+
+		It("serves queries", func() {
+			Expect(status(http.MethodGet, "/api/labels")).To(Equal(http.StatusOK))
+			Expect(status(http.MethodGet, "/render?query=app.cpu%7B%7D&from=now-1h&until=now&format=json")).To(Equal(http.StatusOK))
+		})
+	})
+})
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/pyroscope-io/pyroscope/pkg/flameql"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/util/attime"
+)
+
+var (
+	errQueryIsRequired     = errors.New("query parameter is required")
+	errTimeRangeIsRequired = errors.New("from and until parameters are required")
+)
+
+// dataHandler deletes data of the series matching the query within the
+// time range: DELETE /api/data?query=app.cpu{user_id="1"}&from=now-7d&until=now
+// The data is removed asynchronously, but is not returned from then on.
+func (ctrl *Controller) dataHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		ctrl.writeInvalidMethodError(w)
+		return
+	}
+	v := r.URL.Query()
+	q := v.Get("query")
+	if q == "" {
+		ctrl.writeInvalidParameterError(w, errQueryIsRequired)
+		return
+	}
+	qry, err := flameql.ParseQuery(tenantName(r.Context(), q))
+	if err != nil {
+		ctrl.writeInvalidParameterError(w, fmt.Errorf("query: %w", err))
+		return
+	}
+	if err = authorizeApp(r.Context(), qry.AppName); err != nil {
+		ctrl.writeInvalidParameterError(w, err)
+		return
+	}
+	if v.Get("from") == "" || v.Get("until") == "" {
+		ctrl.writeInvalidParameterError(w, errTimeRangeIsRequired)
+		return
+	}
+	di := storage.DeleteRangeInput{
+		Query:     qry,
+		StartTime: attime.Parse(v.Get("from")),
+		EndTime:   attime.Parse(v.Get("until")),
+	}
+	if !di.StartTime.Before(di.EndTime) {
+		ctrl.writeInvalidParameterError(w, errors.New("from must be before until"))
+		return
+	}
+	if err = ctrl.storage.DeleteRange(&di); err != nil {
+		ctrl.writeInternalServerError(w, err, "failed to delete data")
+		return
+	}
+	ctrl.log.WithField("query", q).
+		WithField("from", di.StartTime).
+		WithField("until", di.EndTime).
+		Info("data deletion requested")
+	ctrl.audit(r, storage.AuditEvent{
+		Action: storage.AuditActionDeleteData,
+		App:    q,
+		From:   di.StartTime.Unix(),
+		Until:  di.EndTime.Unix(),
+	})
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("/api/data", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		ts := withTestServer(cfg)
+
+		deleteData := func(q url.Values) int {
+			req, err := http.NewRequest(http.MethodDelete, ts.URL+"/api/data?"+q.Encode(), nil)
+			Expect(err).ToNot(HaveOccurred())
+			res, err := http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			res.Body.Close()
+			return res.StatusCode
+		}
+
+		It("accepts deletion requests", func() {
+			Expect(deleteData(url.Values{
+				"query": []string{`app.cpu{user_id="1"}`},
+				"from":  []string{"1609459200"},
+				"until": []string{"1609459300"},
+			})).To(Equal(http.StatusAccepted))
+		})
+
+		It("validates parameters", func() {
+			Expect(deleteData(url.Values{
+				"from":  []string{"1609459200"},
+				"until": []string{"1609459300"},
+			})).To(Equal(http.StatusBadRequest))
+			Expect(deleteData(url.Values{
+				"query": []string{`app.cpu{user_id="1"}`},
+			})).To(Equal(http.StatusBadRequest))
+			Expect(deleteData(url.Values{
+				"query": []string{`app.cpu{user_id="1"}`},
+				"from":  []string{"1609459300"},
+				"until": []string{"1609459200"},
+			})).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
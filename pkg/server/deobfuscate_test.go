@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("symbol de-obfuscation", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		BeforeEach(func() {
+			mapping := filepath.Join((*cfg).Server.StoragePath, "names.txt")
+			Expect(os.WriteFile(mapping, []byte("t\trender\ne\tfetchData\n"), 0644)).To(Succeed())
+			(*cfg).Server.SymbolMappings = map[string]string{"web.*": "names:" + mapping}
+		})
+
+		ts := withTestServer(cfg)
+
+		It("maps frame names if requested", func() {
+			res, err := http.Post(ts.URL+"/ingest?name=web.cpu&from=1609459200&until=1609459210", "text/plain",
+				bytes.NewBufferString("main;t 2\nmain;e;t 1\n"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			collapsed := func(deobfuscate string) string {
+				q := url.Values{
+					"query":       []string{"web.cpu"},
+					"from":        []string{"1609459200"},
+					"until":       []string{"1609459210"},
+					"format":      []string{"collapsed"},
+					"deobfuscate": []string{deobfuscate},
+				}
+				res, err := http.Get(ts.URL + "/render?" + q.Encode())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				b, err := io.ReadAll(res.Body)
+				Expect(err).ToNot(HaveOccurred())
+				return string(b)
+			}
+			Expect(collapsed("false")).To(Equal("main;t 2\nmain;e;t 1\n"))
+			Expect(collapsed("true")).To(Equal("main;render 2\nmain;fetchData;render 1\n"))
+		})
+	})
+})
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+)
+
+// diffNormalization defines how the trees of a diff are made comparable.
+type diffNormalization string
+
+const (
+	// diffNormalizationNone compares absolute values.
+	diffNormalizationNone diffNormalization = ""
+	// diffNormalizationRate compares values per second: the tree of
+	// the shorter time range is scaled up to the duration of the longer one.
+	diffNormalizationRate diffNormalization = "rate"
+	// diffNormalizationPercent compares values as shares of the total: the
+	// tree with fewer samples is scaled up to the total of the other one.
+	diffNormalizationPercent diffNormalization = "percent"
+)
+
+func parseDiffNormalization(s string) (diffNormalization, error) {
+	switch n := diffNormalization(s); n {
+	case diffNormalizationNone, diffNormalizationRate, diffNormalizationPercent:
+		return n, nil
+	case "none":
+		return diffNormalizationNone, nil
+	default:
+		return "", fmt.Errorf("unknown value %q: must be none, rate, or percent", s)
+	}
+}
+
+// normalizeDiffTrees returns the trees scaled according to the
+// normalization mode, the input trees are not modified. Trees are only
+// scaled up, so that small nodes are not lost to rounding.
+func normalizeDiffTrees(n diffNormalization, left, rght *tree.Tree, leftDuration, rghtDuration time.Duration) (*tree.Tree, *tree.Tree) {
+	var l, r uint64
+	switch n {
+	case diffNormalizationRate:
+		l, r = uint64(leftDuration/time.Second), uint64(rghtDuration/time.Second)
+	case diffNormalizationPercent:
+		l, r = left.Samples(), rght.Samples()
+	}
+	if l == 0 || r == 0 || l == r {
+		return left, rght
+	}
+	if l < r {
+		return left.Clone(new(big.Rat).SetFrac(new(big.Int).SetUint64(r), new(big.Int).SetUint64(l))), rght
+	}
+	return left, rght.Clone(new(big.Rat).SetFrac(new(big.Int).SetUint64(l), new(big.Int).SetUint64(r)))
+}
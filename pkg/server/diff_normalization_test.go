@@ -0,0 +1,57 @@
+package server
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+)
+
+var _ = Describe("diff normalization", func() {
+	var left, rght *tree.Tree
+
+	BeforeEach(func() {
+		left = tree.New()
+		left.Insert([]byte("a;b"), 10)
+		left.Insert([]byte("a;c"), 2)
+		rght = tree.New()
+		rght.Insert([]byte("a;b"), 3)
+	})
+
+	It("keeps absolute values by default", func() {
+		l, r := normalizeDiffTrees(diffNormalizationNone, left, rght, time.Hour, 5*time.Minute)
+		Expect(l.Samples()).To(Equal(uint64(12)))
+		Expect(r.Samples()).To(Equal(uint64(3)))
+	})
+
+	It("scales the shorter range up to the rate of the longer one", func() {
+		l, r := normalizeDiffTrees(diffNormalizationRate, left, rght, time.Hour, 5*time.Minute)
+		Expect(l.Samples()).To(Equal(uint64(12)))
+		Expect(r.Samples()).To(Equal(uint64(36)))
+		Expect(rght.Samples()).To(Equal(uint64(3)))
+	})
+
+	It("scales the smaller tree up to the total of the other one", func() {
+		l, r := normalizeDiffTrees(diffNormalizationPercent, left, rght, time.Hour, 5*time.Minute)
+		Expect(l.Samples()).To(Equal(uint64(12)))
+		Expect(r.Samples()).To(Equal(uint64(12)))
+		Expect(r.String()).To(Equal("a;b 12\n"))
+	})
+
+	It("parses normalization modes", func() {
+		for s, n := range map[string]diffNormalization{
+			"":        diffNormalizationNone,
+			"none":    diffNormalizationNone,
+			"rate":    diffNormalizationRate,
+			"percent": diffNormalizationPercent,
+		} {
+			v, err := parseDiffNormalization(s)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(v).To(Equal(n))
+		}
+		_, err := parseDiffNormalization("ratio")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("server drain", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		ts := withTestServer(cfg)
+
+		status := func(p string) int {
+			res, err := http.Get(ts.URL + p)
+			Expect(err).ToNot(HaveOccurred())
+			res.Body.Close()
+			return res.StatusCode
+		}
+
+		It("completes requests in flight and rejects new ones", func() {
+			Expect(status("/-/ready")).To(Equal(http.StatusOK))
+
+			started, release := make(chan struct{}), make(chan struct{})
+			h := ts.controller.drainMiddleware(func(w http.ResponseWriter, _ *http.Request) {
+				close(started)
+				<-release
+			})
+			completed := make(chan struct{})
+			go func() {
+				h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/render", nil))
+				close(completed)
+			}()
+			<-started
+
+			drained := make(chan struct{})
+			go func() {
+				ts.controller.Drain()
+				close(drained)
+			}()
+			Eventually(func() int { return status("/-/ready") }).Should(Equal(http.StatusServiceUnavailable))
+			Eventually(func() int { return status("/api/apps") }).Should(Equal(http.StatusServiceUnavailable))
+			Expect(status("/healthz")).To(Equal(http.StatusOK))
+			Consistently(drained).ShouldNot(BeClosed())
+
+			close(release)
+			Eventually(completed).Should(BeClosed())
+			Eventually(drained).Should(BeClosed())
+		})
+	})
+})
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/util/attime"
+)
+
+const defaultExemplarsLimit = 100
+
+var errNameIsRequired = errors.New("name parameter is required")
+
+// exemplarsHandler lists exemplars of the application overlapping the
+// time range specified with from and until parameters. Tags included in
+// the name (e.g. app.name{trace_id=abc}) must match exemplar labels.
+func (ctrl *Controller) exemplarsHandler(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query()
+	if v.Get("name") == "" {
+		ctrl.writeInvalidParameterError(w, errNameIsRequired)
+		return
+	}
+	k, err := segment.ParseKey(tenantName(r.Context(), v.Get("name")))
+	if err != nil {
+		ctrl.writeInvalidParameterError(w, fmt.Errorf("name: %w", err))
+		return
+	}
+	if err = authorizeApp(r.Context(), k.AppName()); err != nil {
+		ctrl.writeInvalidParameterError(w, err)
+		return
+	}
+	gi := storage.GetExemplarsInput{
+		AppName: k.AppName(),
+		Labels:  make(map[string]string),
+		Limit:   defaultExemplarsLimit,
+	}
+	for n, x := range k.Labels() {
+		if n != "__name__" {
+			gi.Labels[n] = x
+		}
+	}
+	if s := v.Get("from"); s != "" {
+		gi.StartTime = attime.Parse(s)
+	}
+	if s := v.Get("until"); s != "" {
+		gi.EndTime = attime.Parse(s)
+	}
+	if s := v.Get("limit"); s != "" {
+		if gi.Limit, err = strconv.Atoi(s); err != nil || gi.Limit < 0 {
+			ctrl.writeInvalidParameterError(w, fmt.Errorf("limit: invalid value %q", s))
+			return
+		}
+	}
+
+	e := storage.AuditEvent{Action: storage.AuditActionQuery, App: k.Normalized()}
+	if !gi.StartTime.IsZero() {
+		e.From = gi.StartTime.Unix()
+	}
+	if !gi.EndTime.IsZero() {
+		e.Until = gi.EndTime.Unix()
+	}
+	ctrl.audit(r, e)
+	exemplars, err := ctrl.storage.GetExemplars(&gi)
+	if err != nil {
+		ctrl.writeInternalServerError(w, err, "failed to retrieve exemplars")
+		return
+	}
+	ctrl.writeResponseJSON(w, exemplars)
+}
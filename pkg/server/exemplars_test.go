@@ -0,0 +1,67 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("/api/exemplars", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		ts := withTestServer(cfg)
+
+		ingest := func(name, from, until string) {
+			q := url.Values{"name": []string{name}, "from": []string{from}, "until": []string{until}}
+			res, err := http.Post(ts.URL+"/ingest?"+q.Encode(), "text/plain", bytes.NewBufferString("foo;bar 1\n"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+		}
+
+		exemplars := func(q url.Values) (int, []storage.Exemplar) {
+			res, err := http.Get(ts.URL + "/api/exemplars?" + q.Encode())
+			Expect(err).ToNot(HaveOccurred())
+			defer res.Body.Close()
+			var e []storage.Exemplar
+			if res.StatusCode == http.StatusOK {
+				Expect(json.NewDecoder(res.Body).Decode(&e)).To(Succeed())
+			}
+			return res.StatusCode, e
+		}
+
+		It("lists exemplars linked to traces", func() {
+			ingest("app.cpu{profile_id=1,trace_id=abc}", "1609459200", "1609459201")
+			ingest("app.cpu{profile_id=2,trace_id=def}", "1609459300", "1609459301")
+
+			code, e := exemplars(url.Values{"name": []string{"app.cpu"}})
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(e).To(HaveLen(2))
+
+			code, e = exemplars(url.Values{"name": []string{"app.cpu{trace_id=def}"}})
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(e).To(HaveLen(1))
+			Expect(e[0].ProfileID).To(Equal("2"))
+
+			code, e = exemplars(url.Values{
+				"name":  []string{"app.cpu"},
+				"from":  []string{"1609459100"},
+				"until": []string{"1609459250"},
+			})
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(e).To(HaveLen(1))
+			Expect(e[0].ProfileID).To(Equal("1"))
+		})
+
+		It("requires name parameter", func() {
+			code, _ := exemplars(url.Values{})
+			Expect(code).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/convert"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/structs/flamebearer"
+)
+
+// Federation provides a combined view of independent servers (e.g. one
+// per region): /render and label requests are sent to the federation
+// targets along with querying the local storage, and the results are
+// merged. Requests to the targets carry federatedHeader, and are not
+// federated further by the targets.
+//
+// A target that fails to respond is skipped: the response only includes
+// data of the servers available.
+//
+// Federation is not supported in multi-tenancy mode: the targets are not
+// aware of the tenant of the request, and their data would be shown to
+// every tenant.
+
+const (
+	federatedHeader          = "X-Pyroscope-Federated"
+	defaultFederationTimeout = 30 * time.Second
+)
+
+var errFederationMultiTenancy = errors.New("federation is not supported in multi-tenancy mode")
+
+type federationTarget struct {
+	url    *url.URL
+	token  string
+	client *http.Client
+}
+
+func newFederationTargets(c *config.Server) ([]*federationTarget, error) {
+	if len(c.Federation) > 0 && c.MultiTenancy {
+		return nil, errFederationMultiTenancy
+	}
+	ft := make([]*federationTarget, 0, len(c.Federation))
+	for _, t := range c.Federation {
+		u, err := url.Parse(t.Address)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("invalid federation target address %q", t.Address)
+		}
+		timeout := t.Timeout
+		if timeout <= 0 {
+			timeout = defaultFederationTimeout
+		}
+		ft = append(ft, &federationTarget{
+			url:    u,
+			token:  t.AuthToken,
+			client: &http.Client{Timeout: timeout},
+		})
+	}
+	return ft, nil
+}
+
+func (t *federationTarget) get(ctx context.Context, p string, q url.Values) (*http.Response, error) {
+	u := *t.url
+	u.Path = path.Join(u.Path, p)
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(federatedHeader, "true")
+	if t.token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+	return resp, nil
+}
+
+func (t *federationTarget) getJSON(ctx context.Context, p string, q url.Values, v interface{}) error {
+	resp, err := t.get(ctx, p, q)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (ctrl *Controller) isFederated(r *http.Request) bool {
+	return len(ctrl.federation) > 0 && r.Header.Get(federatedHeader) == ""
+}
+
+// federate runs fn for every federation target concurrently. Targets
+// that fail are logged and skipped.
+func (ctrl *Controller) federate(fn func(*federationTarget) error) {
+	var wg sync.WaitGroup
+	for _, t := range ctrl.federation {
+		wg.Add(1)
+		go func(t *federationTarget) {
+			defer wg.Done()
+			if err := fn(t); err != nil {
+				ctrl.log.WithError(err).WithField("target", t.url.String()).Warn("federation request failed")
+			}
+		}(t)
+	}
+	wg.Wait()
+}
+
+// federateRender merges profiles of the federation targets into out.
+// The targets are queried for the same time range, therefore timelines
+// have the same resolution and can be merged sample by sample.
+func (ctrl *Controller) federateRender(r *http.Request, p *renderParams, out *storage.GetOutput) {
+	v := r.URL.Query()
+	q := url.Values{
+		"from":  []string{strconv.FormatInt(p.gi.StartTime.Unix(), 10)},
+		"until": []string{strconv.FormatInt(p.gi.EndTime.Unix(), 10)},
+	}
+	if name := v.Get("name"); name != "" {
+		q.Set("name", name)
+	} else {
+		q.Set("query", v.Get("query"))
+	}
+	if out.Timeline == nil {
+		out.Timeline = segment.GenerateTimeline(p.gi.StartTime, p.gi.EndTime)
+	}
+	var m sync.Mutex
+	ctrl.federate(func(t *federationTarget) error {
+		collapsed := copyValues(q)
+		collapsed.Set("format", "collapsed")
+		resp, err := t.get(r.Context(), "/render", collapsed)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		remoteTree := tree.New()
+		if err = convert.ParseGroups(resp.Body, remoteTree.InsertInt); err != nil {
+			return err
+		}
+		// The flamegraph is not needed, only the timeline and metadata.
+		jq := copyValues(q)
+		jq.Set("format", "json")
+		jq.Set("max-nodes", "1")
+		var fb flamebearer.FlamebearerProfile
+		if err = t.getJSON(r.Context(), "/render", jq, &fb); err != nil {
+			return err
+		}
+		m.Lock()
+		defer m.Unlock()
+		out.Tree.Merge(remoteTree)
+		mergeTimeline(out.Timeline, fb.Timeline)
+		if out.SpyName == "" {
+			out.SpyName = fb.Metadata.SpyName
+			out.SampleRate = fb.Metadata.SampleRate
+			out.Units = fb.Metadata.Units
+		}
+		return nil
+	})
+}
+
+// mergeTimeline adds samples of the remote timeline to the local one.
+// Timeline values are offset by one, 0 indicates the absence of data.
+func mergeTimeline(local *segment.Timeline, remote *flamebearer.FlamebearerTimelineV1) {
+	if remote == nil || remote.StartTime != local.StartTime ||
+		remote.DurationDelta != local.DurationDeltaNormalized ||
+		len(remote.Samples) != len(local.Samples) {
+		return
+	}
+	for i, x := range remote.Samples {
+		switch {
+		case x == 0:
+		case local.Samples[i] == 0:
+			local.Samples[i] = x
+		default:
+			local.Samples[i] += x - 1
+		}
+	}
+}
+
+// federateValues merges label names or values of the federation targets
+// into the response of the handler, which is a JSON array of strings.
+func (ctrl *Controller) federateValues(next http.HandlerFunc) http.HandlerFunc {
+	if len(ctrl.federation) == 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Values of the cluster peers are merged by the server
+		// the request is received by.
+		if !ctrl.isFederated(r) || isForwarded(r.Context()) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		var lw batchEntryWriter
+		next.ServeHTTP(&lw, r)
+		var values []string
+		if lw.status() != http.StatusOK || json.Unmarshal(lw.body.Bytes(), &values) != nil {
+			lw.writeTo(w)
+			return
+		}
+		remote := ctrl.federatedValues(r, r.URL.Path, r.URL.Query())
+		if r.URL.Query().Get("label") == "__name__" {
+			remote = allowedPeerApps(r.Context(), remote)
+		}
+		ctrl.writeResponseJSON(w, mergeValues(values, remote))
+	}
+}
+
+func (ctrl *Controller) federatedValues(r *http.Request, p string, q url.Values) []string {
+	var (
+		m      sync.Mutex
+		values []string
+	)
+	ctrl.federate(func(t *federationTarget) error {
+		var v []string
+		if err := t.getJSON(r.Context(), p, q, &v); err != nil {
+			return err
+		}
+		m.Lock()
+		values = append(values, v...)
+		m.Unlock()
+		return nil
+	})
+	return values
+}
+
+func copyValues(v url.Values) url.Values {
+	c := make(url.Values, len(v))
+	for k, x := range v {
+		c[k] = append([]string(nil), x...)
+	}
+	return c
+}
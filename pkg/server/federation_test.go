@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("federation", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		var local, remote *testServer
+
+		JustBeforeEach(func() {
+			rc := (*cfg).Server
+			rc.StoragePath = filepath.Join(rc.StoragePath, "remote")
+			remote = newTestServer(&rc)
+			lc := (*cfg).Server
+			lc.Federation = []config.FederationTarget{{Address: remote.URL}}
+			local = newTestServer(&lc)
+		})
+
+		JustAfterEach(func() {
+			local.Close()
+			remote.Close()
+		})
+
+		ingest := func(s *testServer, app, body string) {
+			q := url.Values{"name": []string{app}, "from": []string{"1609459200"}, "until": []string{"1609459210"}}
+			res, err := http.Post(s.URL+"/ingest?"+q.Encode(), "text/plain", bytes.NewBufferString(body))
+			Expect(err).ToNot(HaveOccurred())
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+		}
+
+		render := func(s *testServer) RenderResponse {
+			q := url.Values{"query": []string{"app.cpu{}"}, "from": []string{"1609459200"}, "until": []string{"1609459210"}, "format": []string{"json"}}
+			res, err := http.Get(s.URL + "/render?" + q.Encode())
+			Expect(err).ToNot(HaveOccurred())
+			defer res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			var r RenderResponse
+			Expect(json.NewDecoder(res.Body).Decode(&r)).To(Succeed())
+			return r
+		}
+
+		sum := func(samples []uint64) (n uint64) {
+			for _, x := range samples {
+				if x > 0 {
+					n += x - 1
+				}
+			}
+			return n
+		}
+
+		It("merges profiles of the federation targets", func() {
+			ingest(local, "app.cpu", "main;foo 1\n")
+			ingest(remote, "app.cpu", "main;bar 2\n")
+
+			r := render(local)
+			Expect(r.Flamebearer.NumTicks).To(Equal(3))
+			Expect(r.Flamebearer.Names).To(ContainElements("foo", "bar"))
+			Expect(sum(r.Timeline.Samples)).To(Equal(uint64(3)))
+			Expect(r.Metadata.SpyName).ToNot(BeEmpty())
+
+			Expect(render(remote).Flamebearer.NumTicks).To(Equal(2))
+		})
+
+		It("merges application names", func() {
+			ingest(local, "app.cpu", "main;foo 1\n")
+			ingest(remote, "other.cpu", "main;bar 2\n")
+			res, err := http.Get(local.URL + "/label-values?label=__name__")
+			Expect(err).ToNot(HaveOccurred())
+			var names []string
+			Expect(json.NewDecoder(res.Body).Decode(&names)).To(Succeed())
+			res.Body.Close()
+			Expect(names).To(Equal([]string{"app.cpu", "other.cpu"}))
+		})
+
+		It("skips unavailable targets", func() {
+			ingest(local, "app.cpu", "main;foo 1\n")
+			remote.Server.Close()
+			Expect(render(local).Flamebearer.NumTicks).To(Equal(1))
+		})
+
+		It("is not supported in multi-tenancy mode", func() {
+			c := (*cfg).Server
+			c.MultiTenancy = true
+			c.Federation = []config.FederationTarget{{Address: remote.URL}}
+			_, err := newFederationTargets(&c)
+			Expect(err).To(MatchError(errFederationMultiTenancy))
+		})
+	})
+})
@@ -30,6 +30,8 @@ func (ctrl *Controller) loginHandler() http.HandlerFunc {
 			"GoogleEnabled": ctrl.config.Auth.Google.Enabled,
 			"GithubEnabled": ctrl.config.Auth.Github.Enabled,
 			"GitlabEnabled": ctrl.config.Auth.Gitlab.Enabled,
+			"OIDCEnabled":   ctrl.config.Auth.OIDC.Enabled,
+			"OIDCName":      ctrl.config.Auth.OIDC.Name,
 			"BaseURL":       ctrl.config.BaseURL,
 		})
 	}
@@ -165,6 +167,7 @@ func (ctrl *Controller) callbackRedirectHandler(oh oauthHandler) http.HandlerFun
 		name, err := oh.userAuth(client)
 		if err != nil {
 			ctrl.logErrorAndRedirect(w, r, "failed to get user auth info", err)
+			return
 		}
 
 		tk, err := ctrl.newJWTToken(name)
@@ -240,7 +243,7 @@ func (ctrl *Controller) getTemplate(path string) (*template.Template, error) {
 	return tmpl, nil
 }
 
-func (ctrl *Controller) renderIndexPage(w http.ResponseWriter, _ *http.Request) {
+func (ctrl *Controller) renderIndexPage(w http.ResponseWriter, r *http.Request) {
 	tmpl, err := ctrl.getTemplate("/index.html")
 	if err != nil {
 		ctrl.writeInternalServerError(w, err, "could not render index page")
@@ -248,7 +251,7 @@ func (ctrl *Controller) renderIndexPage(w http.ResponseWriter, _ *http.Request)
 	}
 
 	initialStateObj := indexPageJSON{}
-	initialStateObj.AppNames = ctrl.storage.GetAppNames()
+	initialStateObj.AppNames = ctrl.clusterAppNames(r)
 
 	var b []byte
 	b, err = json.Marshal(initialStateObj)
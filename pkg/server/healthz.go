@@ -1,9 +1,114 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"sync/atomic"
+
+	"github.com/pyroscope-io/pyroscope/pkg/util/disk"
 )
 
-func (*Controller) healthz(w http.ResponseWriter, _ *http.Request) {
-	_, _ = w.Write([]byte("server is ready"))
+const (
+	healthStatusOK      = "ok"
+	healthStatusFailing = "failing"
+
+	// queueSaturation is the ratio of the ingestion queue capacity
+	// above which the queue is considered saturated.
+	queueSaturation = 0.9
+)
+
+type healthResponse struct {
+	Status string        `json:"status"`
+	Checks []healthCheck `json:"checks"`
+}
+
+// healthCheck is the result of a check of a server dependency.
+type healthCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+func newHealthCheck(name string, err error) healthCheck {
+	c := healthCheck{Name: name, Status: healthStatusOK}
+	if err != nil {
+		c.Status = healthStatusFailing
+		c.Message = err.Error()
+	}
+	return c
+}
+
+// healthz reports whether the server is alive, for liveness probes:
+// the databases are open and writable. Conditions the server recovers
+// from on its own, like ingestion queue saturation or low disk space,
+// are only reported by /readyz: restarting the server does not help.
+func (ctrl *Controller) healthz(w http.ResponseWriter, _ *http.Request) {
+	var checks []healthCheck
+	if ctrl.storage != nil {
+		checks = append(checks, newHealthCheck("databases", ctrl.storage.CheckDatabases()))
+	}
+	ctrl.writeHealth(w, checks)
+}
+
+// readyHandler reports whether the server can serve requests, for
+// readiness probes and load balancers. In addition to the /healthz checks,
+// the ingestion queue is not saturated and there is enough free disk space.
+// The server is not ready until storage indexes are preloaded, if enabled,
+// and once it starts draining at shutdown.
+func (ctrl *Controller) readyHandler(w http.ResponseWriter, _ *http.Request) {
+	var shutdown, indexes error
+	if atomic.LoadUint32(&ctrl.shuttingDown) > 0 {
+		shutdown = errors.New("server is shutting down")
+	}
+	var checks []healthCheck
+	if ctrl.storage != nil {
+		if !ctrl.storage.Preloaded() {
+			indexes = errors.New("server is starting: loading storage indexes")
+		}
+		checks = append(checks,
+			newHealthCheck("databases", ctrl.storage.CheckDatabases()),
+			newHealthCheck("ingestion-queue", ctrl.checkQueue()),
+			newHealthCheck("disk-space", ctrl.checkDiskSpace()))
+	}
+	ctrl.writeHealth(w, append(checks,
+		newHealthCheck("shutdown", shutdown),
+		newHealthCheck("storage-indexes", indexes)))
+}
+
+func (ctrl *Controller) checkQueue() error {
+	n, capacity := ctrl.storage.QueueUsage()
+	if capacity > 0 && float64(n) >= queueSaturation*float64(capacity) {
+		return fmt.Errorf("ingestion queue is saturated: %d of %d profiles queued", n, capacity)
+	}
+	return nil
+}
+
+func (ctrl *Controller) checkDiskSpace() error {
+	available, err := disk.FreeSpace(ctrl.config.StoragePath)
+	if err != nil {
+		return fmt.Errorf("failed to get free disk space: %w", err)
+	}
+	if min := ctrl.config.HealthMinFreeSpace; available < min {
+		return fmt.Errorf("disk space is running low: %v available, at least %v required", available, min)
+	}
+	return nil
+}
+
+func (*Controller) writeHealth(w http.ResponseWriter, checks []healthCheck) {
+	res := healthResponse{Status: healthStatusOK, Checks: checks}
+	for _, c := range checks {
+		if c.Status != healthStatusOK {
+			res.Status = healthStatusFailing
+		}
+	}
+	if res.Checks == nil {
+		res.Checks = []healthCheck{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if res.Status != healthStatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(res)
 }
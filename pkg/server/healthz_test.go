@@ -0,0 +1,74 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+	"github.com/pyroscope-io/pyroscope/pkg/util/bytesize"
+)
+
+var _ = Describe("server health", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		ts := withTestServer(cfg)
+
+		get := func(p string) (int, healthResponse) {
+			res, err := http.Get(ts.URL + p)
+			Expect(err).ToNot(HaveOccurred())
+			defer res.Body.Close()
+			Expect(res.Header.Get("Content-Type")).To(Equal("application/json"))
+			var h healthResponse
+			Expect(json.NewDecoder(res.Body).Decode(&h)).To(Succeed())
+			return res.StatusCode, h
+		}
+
+		statuses := func(h healthResponse) map[string]string {
+			m := make(map[string]string)
+			for _, c := range h.Checks {
+				m[c.Name] = c.Status
+			}
+			return m
+		}
+
+		It("reports the server dependencies are healthy", func() {
+			code, h := get("/healthz")
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(h.Status).To(Equal(healthStatusOK))
+			Expect(statuses(h)).To(Equal(map[string]string{
+				"databases": healthStatusOK,
+			}))
+
+			code, h = get("/readyz")
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(statuses(h)).To(Equal(map[string]string{
+				"databases":       healthStatusOK,
+				"ingestion-queue": healthStatusOK,
+				"disk-space":      healthStatusOK,
+				"shutdown":        healthStatusOK,
+				"storage-indexes": healthStatusOK,
+			}))
+		})
+
+		Context("when disk space is running low", func() {
+			BeforeEach(func() {
+				(*cfg).Server.HealthMinFreeSpace = 1024 * bytesize.PB
+			})
+
+			It("reports the server is not ready, but alive", func() {
+				code, h := get("/readyz")
+				Expect(code).To(Equal(http.StatusServiceUnavailable))
+				Expect(h.Status).To(Equal(healthStatusFailing))
+				Expect(statuses(h)).To(HaveKeyWithValue("disk-space", healthStatusFailing))
+				Expect(statuses(h)).To(HaveKeyWithValue("databases", healthStatusOK))
+
+				code, h = get("/healthz")
+				Expect(code).To(Equal(http.StatusOK))
+				Expect(h.Status).To(Equal(healthStatusOK))
+			})
+		})
+	})
+})
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -14,6 +15,7 @@ import (
 	"github.com/pyroscope-io/pyroscope/pkg/agent/types"
 	"github.com/pyroscope-io/pyroscope/pkg/convert"
 	"github.com/pyroscope-io/pyroscope/pkg/flameql"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/relabel"
 	"github.com/pyroscope-io/pyroscope/pkg/storage"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
@@ -22,35 +24,117 @@ import (
 )
 
 type ingestHandler struct {
-	log        *logrus.Logger
-	storage    *storage.Storage
-	exporter   storage.MetricsExporter
-	bufferPool *bytebufferpool.Pool
-	onSuccess  func(pi *storage.PutInput)
+	log          logrus.FieldLogger
+	storage      *storage.Storage
+	exporter     storage.MetricsExporter
+	remoteWriter RemoteWriter
+	relabel      func() []*relabel.Config
+	deltas       *deltaCache
+	bufferPool   *bytebufferpool.Pool
+	onSuccess    func(pi *storage.PutInput)
+	// put writes the profile to the storage, or forwards it
+	// to the cluster server owning the application.
+	put func(pi *storage.PutInput) error
+	// quotas and usage are optional.
+	quotas *ingestQuotas
+	usage  *usageTracker
 }
 
-func NewIngestHandler(log *logrus.Logger, st *storage.Storage, exporter storage.MetricsExporter, onSuccess func(pi *storage.PutInput)) http.Handler {
+// RemoteWriter receives a copy of every successfully ingested profile.
+type RemoteWriter interface {
+	Write(*storage.PutInput)
+}
+
+// NewIngestHandler creates a new ingestion handler. remoteWriter and
+// relabelConfigs are optional.
+func NewIngestHandler(log *logrus.Logger, st *storage.Storage, exporter storage.MetricsExporter, remoteWriter RemoteWriter, relabelConfigs []*relabel.Config, onSuccess func(pi *storage.PutInput)) http.Handler {
+	return newIngestHandler(log, st, exporter, remoteWriter, func() []*relabel.Config { return relabelConfigs }, onSuccess)
+}
+
+func newIngestHandler(log *logrus.Logger, st *storage.Storage, exporter storage.MetricsExporter, remoteWriter RemoteWriter, relabelConfigs func() []*relabel.Config, onSuccess func(pi *storage.PutInput)) ingestHandler {
 	return ingestHandler{
-		log:        log,
-		storage:    st,
-		exporter:   exporter,
-		bufferPool: &bytebufferpool.Pool{},
-		onSuccess:  onSuccess,
+		log:          log,
+		storage:      st,
+		exporter:     exporter,
+		remoteWriter: remoteWriter,
+		relabel:      relabelConfigs,
+		deltas:       newDeltaCache(),
+		bufferPool:   &bytebufferpool.Pool{},
+		onSuccess:    onSuccess,
+		put:          st.Put,
 	}
 }
 
-// revive:disable:cognitive-complexity I don't want to split this into 2 functions just to please the linter
 func (h ingestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.log = h.log.WithFields(requestLogFields(r))
+	if r.URL.Query().Get("dryRun") == "true" {
+		h.dryRun(w, r)
+		return
+	}
+	body := &countingBody{ReadCloser: r.Body}
+	r.Body = body
+	pi, inputs, ok := h.parse(w, r, false)
+	if !ok || len(inputs) == 0 {
+		return
+	}
+	if err := h.quotas.admit(r.Context(), inputs, body.n, time.Now()); err != nil {
+		writeQuotaExceeded(h.log, w, err)
+		return
+	}
+	for _, input := range inputs {
+		if err := h.put(input); err != nil {
+			if errors.Is(err, storage.ErrCardinalityLimit) {
+				WriteError(h.log, w, http.StatusUnprocessableEntity, err, "profile exceeds cardinality limits")
+				return
+			}
+			WriteError(h.log, w, http.StatusInternalServerError, err, "error happened while ingesting data")
+			return
+		}
+		if h.remoteWriter != nil {
+			h.remoteWriter.Write(input)
+		}
+	}
+	h.usage.ingested(inputs, body.n, time.Now())
+	h.onSuccess(pi)
+}
+
+// revive:disable:cognitive-complexity I don't want to split this into 2 functions just to please the linter
+
+// parse parses the request into put inputs. If the request is invalid,
+// the error is written to w and ok is false. No inputs are returned
+// if the profile is dropped by relabeling rules.
+func (h ingestHandler) parse(w http.ResponseWriter, r *http.Request, dryRun bool) (pi *storage.PutInput, inputs []*storage.PutInput, ok bool) {
 	pi, err := h.ingestParamsFromRequest(r)
 	if err != nil {
 		WriteError(h.log, w, http.StatusBadRequest, err, "invalid parameter")
 		return
 	}
+	if pi.Key, err = relabelKey(pi.Key, r, h.relabel()); err != nil {
+		WriteError(h.log, w, http.StatusUnprocessableEntity, err, "error happened while relabeling profile")
+		return
+	}
+	if pi.Key == nil {
+		// Dropped by relabeling rules.
+		return pi, nil, true
+	}
+	tenantKey(r.Context(), pi.Key)
 
 	format := r.URL.Query().Get("format")
 	contentType := r.Header.Get("Content-Type")
-	inputs := []*storage.PutInput{}
-	cb := h.createParseCallback(pi)
+	if format == "jfr" {
+		// JFR recordings are binary and would otherwise be parsed as
+		// collapsed stacks by default, producing garbage data.
+		WriteErrorMessage(h.log, w, http.StatusUnsupportedMediaType, "jfr format is not supported yet, convert the recording to pprof or collapsed format")
+		return
+	}
+	// Deltas of cumulative profiles are not computed in dry-run mode,
+	// as this would affect subsequent requests.
+	var deltas *deltaCache
+	cumulative := r.URL.Query().Get("cumulative") == "true"
+	if cumulative && !dryRun {
+		deltas = h.deltas
+	}
+	cb := h.createParseCallback(pi, dryRun || cumulative)
 	switch {
 	case format == "trie", contentType == "binary/octet-stream+trie":
 		tmpBuf := h.bufferPool.Get()
@@ -62,6 +146,10 @@ func (h ingestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		err = convert.ParseIndividualLines(r.Body, cb)
 	case strings.Contains(contentType, "multipart/form-data"):
 		err := r.ParseMultipartForm(32 << 20) // maxMemory 32MB
+		if errors.Is(err, errRequestBodyTooLarge) {
+			WriteErrorMessage(h.log, w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
 		if err == nil {
 			var profile *tree.Profile
 			var prevProfile *tree.Profile
@@ -87,85 +175,125 @@ func (h ingestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				}
 			}
 
-			for _, sampleTypeStr := range profile.SampleTypes() {
-				var t *tree.SampleTypeConfig
-				var ok bool
-				if t, ok = tree.DefaultSampleTypeMapping[sampleTypeStr]; !ok {
-					continue
-				}
-				var tries map[string]*transporttrie.Trie
-				var prevTries map[string]*transporttrie.Trie
-
-				if profile != nil {
-					tries = pprofToTries(pi.Key.Normalized(), sampleTypeStr, profile)
-				}
-				if prevProfile != nil {
-					prevTries = pprofToTries(pi.Key.Normalized(), sampleTypeStr, prevProfile)
-				}
-				for trieKey, trie := range tries {
-					// copy of pi
-					input := *pi
-
-					suffix := sampleTypeStr
-					if t.DisplayName != "" {
-						suffix = t.DisplayName
-					}
-					// this also clones the key which is important
-					input.Key = ensureKeyHasSuffix(input.Key, "."+suffix)
-
-					sk, _ := segment.ParseKey(trieKey)
-					for k, v := range sk.Labels() {
-						if k != "__name__" {
-							input.Key.Add(k, v)
-						}
-					}
-
-					input.Val = tree.New()
-					resTrie := trie
-					if t.Cumulative {
-						if prevTrie := prevTries[trieKey]; prevTrie != nil {
-							resTrie = trie.Diff(prevTrie)
-						} else {
-							// TODO: error handling
-							continue
-						}
-					}
-					resTrie.Iterate(func(name []byte, val uint64) {
-						input.Val.Insert(name, val)
-					})
-					input.Units = t.Units
-					input.AggregationType = t.Aggregation
-					inputs = append(inputs, &input)
-				}
-			}
+			inputs = pprofInputs(pi, profile, prevProfile, deltas)
+		}
+	case format == "speedscope":
+		err = convert.ParseSpeedscope(r.Body, cb)
+	case format == "pprof":
+		var profile *tree.Profile
+		if profile, err = convert.ParsePprof(r.Body); err == nil {
+			inputs = pprofInputs(pi, profile, nil, deltas)
 		}
 	default:
 		err = convert.ParseGroups(r.Body, cb)
 	}
 
+	if errors.Is(err, errRequestBodyTooLarge) {
+		WriteErrorMessage(h.log, w, http.StatusRequestEntityTooLarge, err.Error())
+		return
+	}
 	if err != nil {
 		WriteError(h.log, w, http.StatusUnprocessableEntity, err, "error happened while parsing request body")
 		return
 	}
 
 	if len(inputs) == 0 {
+		if deltas != nil {
+			var ok bool
+			if pi.Val, ok = deltas.delta(pi.Key.Normalized(), pi.Val, time.Now()); !ok {
+				// The first profile of a series is only used as a base.
+				return pi, nil, true
+			}
+			h.observe(pi)
+		}
 		inputs = append(inputs, pi)
 	}
-
-	for _, input := range inputs {
-		if err = h.storage.Put(input); err != nil {
-			WriteError(h.log, w, http.StatusInternalServerError, err, "error happened while ingesting data")
-			return
+	for _, x := range inputs {
+		if err = authorizeApp(r.Context(), x.Key.AppName()); err != nil {
+			WriteError(h.log, w, http.StatusForbidden, err, "access denied")
+			return nil, nil, false
 		}
 	}
-
-	h.onSuccess(pi)
+	return pi, inputs, true
 }
 
 // revive:enable:cognitive-complexity
-func (h ingestHandler) createParseCallback(pi *storage.PutInput) func([]byte, int) {
+
+// pprofInputs converts pprof profile into put inputs, one per sample type
+// and set of labels. Values of cumulative sample types are calculated as
+// the difference with prevProfile; if it is not provided, the difference
+// with the previous profile of the series is calculated using deltas.
+// If neither is available, these sample types are skipped.
+func pprofInputs(pi *storage.PutInput, profile, prevProfile *tree.Profile, deltas *deltaCache) []*storage.PutInput {
+	now := time.Now()
+	var inputs []*storage.PutInput
+	for _, sampleTypeStr := range profile.SampleTypes() {
+		var t *tree.SampleTypeConfig
+		var ok bool
+		if t, ok = tree.DefaultSampleTypeMapping[sampleTypeStr]; !ok {
+			continue
+		}
+		var tries map[string]*transporttrie.Trie
+		var prevTries map[string]*transporttrie.Trie
+
+		if profile != nil {
+			tries = pprofToTries(pi.Key.Normalized(), sampleTypeStr, profile)
+		}
+		if prevProfile != nil {
+			prevTries = pprofToTries(pi.Key.Normalized(), sampleTypeStr, prevProfile)
+		}
+		for trieKey, trie := range tries {
+			// copy of pi
+			input := *pi
+
+			suffix := sampleTypeStr
+			if t.DisplayName != "" {
+				suffix = t.DisplayName
+			}
+			// this also clones the key which is important
+			input.Key = ensureKeyHasSuffix(input.Key, "."+suffix)
+
+			sk, _ := segment.ParseKey(trieKey)
+			for k, v := range sk.Labels() {
+				if k != "__name__" {
+					input.Key.Add(k, v)
+				}
+			}
+
+			input.Val = tree.New()
+			resTrie := trie
+			var useDeltas bool
+			if t.Cumulative {
+				if prevTrie := prevTries[trieKey]; prevTrie != nil {
+					resTrie = trie.Diff(prevTrie)
+				} else if deltas != nil {
+					useDeltas = true
+				} else {
+					// TODO: error handling
+					continue
+				}
+			}
+			resTrie.Iterate(func(name []byte, val uint64) {
+				input.Val.Insert(name, val)
+			})
+			if useDeltas {
+				if input.Val, ok = deltas.delta(input.Key.Normalized(), input.Val, now); !ok {
+					continue
+				}
+			}
+			input.Units = t.Units
+			input.AggregationType = t.Aggregation
+			inputs = append(inputs, &input)
+		}
+	}
+	return inputs
+}
+func (h ingestHandler) createParseCallback(pi *storage.PutInput, dryRun bool) func([]byte, int) {
 	pi.Val = tree.New()
 	cb := pi.Val.InsertInt
+	if dryRun {
+		return cb
+	}
 	o, ok := h.exporter.Evaluate(pi)
 	if !ok {
 		return cb
@@ -176,6 +304,19 @@ func (h ingestHandler) createParseCallback(pi *storage.PutInput) func([]byte, in
 	}
 }
 
+// observe evaluates metrics export rules against the profile.
+func (h ingestHandler) observe(pi *storage.PutInput) {
+	o, ok := h.exporter.Evaluate(pi)
+	if !ok {
+		return
+	}
+	pi.Val.Iterate(func(name []byte, val uint64) {
+		if len(name) > 2 && val != 0 {
+			o.Observe(name[2:], int(val))
+		}
+	})
+}
+
 func (h ingestHandler) ingestParamsFromRequest(r *http.Request) (*storage.PutInput, error) {
 	var (
 		q   = r.URL.Query()
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type ingestBatchResponse struct {
+	Results []ingestBatchResult `json:"results"`
+}
+
+type ingestBatchResult struct {
+	Name   string `json:"name"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ingestBatchHandler handles /ingest/batch requests carrying multiple
+// profiles in a single multipart/form-data body. Each part is an entry:
+// the form field name holds the URL-encoded /ingest query parameters
+// (name, from, until, format, etc.), the part body and Content-Type are
+// handled exactly as the body of a regular /ingest request.
+//
+// Entries are processed independently; the response lists the outcome of
+// every entry in the order they appear in the request.
+func (ctrl *Controller) ingestBatchHandler(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			ctrl.writeInvalidMethodError(w)
+			return
+		}
+		mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+			ctrl.writeErrorMessage(w, http.StatusUnsupportedMediaType, "multipart/form-data body is expected")
+			return
+		}
+
+		var res ingestBatchResponse
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if errors.Is(err, errRequestBodyTooLarge) {
+				ctrl.writeErrorMessage(w, http.StatusRequestEntityTooLarge, err.Error())
+				return
+			}
+			if err != nil {
+				ctrl.writeError(w, http.StatusBadRequest, err, "error happened while reading batch entry")
+				return
+			}
+			res.Results = append(res.Results, ctrl.ingestBatchEntry(h, r, p))
+			_ = p.Close()
+		}
+
+		ctrl.writeResponseJSON(w, res)
+	}
+}
+
+func (ctrl *Controller) ingestBatchEntry(h http.Handler, r *http.Request, p *multipart.Part) ingestBatchResult {
+	q, err := url.ParseQuery(p.FormName())
+	if err != nil {
+		return ingestBatchResult{Status: http.StatusBadRequest, Error: "invalid entry parameters: " + err.Error()}
+	}
+	result := ingestBatchResult{Name: q.Get("name")}
+	er := r.Clone(r.Context())
+	er.URL.RawQuery = q.Encode()
+	er.Header = http.Header{"Content-Type": []string{p.Header.Get("Content-Type")}}
+	if ok, _ := ctrl.allowIngest(r.Context(), tenantName(r.Context(), result.Name)); !ok {
+		ctrl.metrics.ingested(ingestFormat(er), http.StatusTooManyRequests)
+		result.Status = http.StatusTooManyRequests
+		result.Error = "ingestion rate limit exceeded"
+		return result
+	}
+	er.Body = io.NopCloser(p)
+	er.ContentLength = -1
+
+	var ew batchEntryWriter
+	h.ServeHTTP(&ew, er)
+	result.Status = ew.status()
+	if result.Status != http.StatusOK {
+		result.Error = strings.TrimSpace(ew.body.String())
+	}
+	return result
+}
+
+// batchEntryWriter captures the response of a single batch entry.
+type batchEntryWriter struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func (w *batchEntryWriter) Header() http.Header {
+	if w.header == nil {
+		w.header = make(http.Header)
+	}
+	return w.header
+}
+
+func (w *batchEntryWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+func (w *batchEntryWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+}
+
+func (w *batchEntryWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
+
+// writeTo writes the captured response to rw.
+func (w *batchEntryWriter) writeTo(rw http.ResponseWriter) {
+	for k, v := range w.header {
+		rw.Header()[k] = v
+	}
+	rw.WriteHeader(w.status())
+	_, _ = rw.Write(w.body.Bytes())
+}
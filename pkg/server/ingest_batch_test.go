@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("/ingest/batch", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		ts := withTestServer(cfg)
+
+		st := testing.ParseTime("2020-01-01-01:01:00")
+		et := testing.ParseTime("2020-01-01-01:01:10")
+
+		type entry struct {
+			name, format, body string
+		}
+
+		post := func(entries ...entry) (*http.Response, ingestBatchResponse) {
+			var buf bytes.Buffer
+			mw := multipart.NewWriter(&buf)
+			for _, e := range entries {
+				q := url.Values{
+					"name":   []string{e.name},
+					"from":   []string{strconv.Itoa(int(st.Unix()))},
+					"until":  []string{strconv.Itoa(int(et.Unix()))},
+					"format": []string{e.format},
+				}
+				h := make(textproto.MIMEHeader)
+				h.Set("Content-Disposition", `form-data; name="`+q.Encode()+`"`)
+				h.Set("Content-Type", "text/plain")
+				pw, err := mw.CreatePart(h)
+				Expect(err).ToNot(HaveOccurred())
+				_, _ = pw.Write([]byte(e.body))
+			}
+			Expect(mw.Close()).To(Succeed())
+			res, err := http.Post(ts.URL+"/ingest/batch", mw.FormDataContentType(), &buf)
+			Expect(err).ToNot(HaveOccurred())
+			defer res.Body.Close()
+			var r ingestBatchResponse
+			if res.StatusCode == http.StatusOK {
+				Expect(json.NewDecoder(res.Body).Decode(&r)).To(Succeed())
+			}
+			return res, r
+		}
+
+		get := func(name string) string {
+			sk, _ := segment.ParseKey(name)
+			gOut, err := ts.storage.Get(&storage.GetInput{StartTime: st, EndTime: et, Key: sk})
+			Expect(err).ToNot(HaveOccurred())
+			if gOut == nil || gOut.Tree == nil {
+				return ""
+			}
+			return gOut.Tree.String()
+		}
+
+		It("ingests every entry and reports per-entry results", func() {
+			res, r := post(
+				entry{name: "app.one{foo=bar}", body: "foo;bar 1\n"},
+				entry{name: "app.two", format: "lines", body: "foo;baz\nfoo;baz\n"},
+				entry{name: `app{foo"bar=baz}`, body: "foo 1\n"},
+				entry{name: "app.three", format: "jfr", body: "FLR\x00"},
+			)
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(r.Results).To(HaveLen(4))
+			Expect(r.Results[0]).To(Equal(ingestBatchResult{Name: "app.one{foo=bar}", Status: http.StatusOK}))
+			Expect(r.Results[1]).To(Equal(ingestBatchResult{Name: "app.two", Status: http.StatusOK}))
+			Expect(r.Results[2].Status).To(Equal(http.StatusBadRequest))
+			Expect(r.Results[2].Error).ToNot(BeEmpty())
+			Expect(r.Results[3].Status).To(Equal(http.StatusUnsupportedMediaType))
+
+			Expect(get("app.one{foo=bar}")).To(Equal("foo;bar 1\n"))
+			Expect(get("app.two")).To(Equal("foo;baz 2\n"))
+		})
+
+		It("rejects non-multipart requests", func() {
+			res, err := http.Post(ts.URL+"/ingest/batch", "text/plain", bytes.NewBufferString("foo 1"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.StatusCode).To(Equal(http.StatusUnsupportedMediaType))
+		})
+
+		Context("rate limit", func() {
+			BeforeEach(func() {
+				(*cfg).Server.IngestRateLimit = 1
+			})
+
+			It("is applied per entry", func() {
+				_, r := post(
+					entry{name: "app.one", body: "foo 1\n"},
+					entry{name: "app.one", body: "foo 1\n"},
+					entry{name: "app.two", body: "foo 1\n"},
+				)
+				Expect(r.Results).To(HaveLen(3))
+				Expect(r.Results[0].Status).To(Equal(http.StatusOK))
+				Expect(r.Results[1].Status).To(Equal(http.StatusTooManyRequests))
+				Expect(r.Results[2].Status).To(Equal(http.StatusOK))
+			})
+		})
+	})
+})
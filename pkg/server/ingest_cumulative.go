@@ -0,0 +1,68 @@
+package server
+
+import (
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+)
+
+// maxCumulativeSeries bounds the number of series previous profiles are
+// kept for: once exceeded, series that have not been updated recently are
+// removed.
+const (
+	maxCumulativeSeries    = 10000
+	cumulativeSeriesMaxAge = time.Hour
+)
+
+// deltaCache computes deltas of cumulative profiles (e.g. Go heap
+// allocations or block profiles) uploaded repeatedly by the same
+// instance. The last profile of every series is kept in memory.
+type deltaCache struct {
+	mutex  sync.Mutex
+	series map[string]*cumulativeSeries
+}
+
+type cumulativeSeries struct {
+	prev     *tree.Tree
+	lastSeen time.Time
+}
+
+func newDeltaCache() *deltaCache {
+	return &deltaCache{series: make(map[string]*cumulativeSeries)}
+}
+
+// delta returns the difference between cur and the previous profile of
+// the series identified by key, and remembers cur. If there is no previous
+// profile, false is returned. If cur is less than the previous profile,
+// the counters are considered reset (e.g. the process restarted), and cur
+// itself is returned.
+func (c *deltaCache) delta(key string, cur *tree.Tree, now time.Time) (*tree.Tree, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	s, ok := c.series[key]
+	if !ok {
+		if len(c.series) >= maxCumulativeSeries {
+			c.removeStale(now)
+		}
+		c.series[key] = &cumulativeSeries{prev: cur.Clone(big.NewRat(1, 1)), lastSeen: now}
+		return nil, false
+	}
+	prev := s.prev
+	s.prev = cur.Clone(big.NewRat(1, 1))
+	s.lastSeen = now
+	if cur.Samples() < prev.Samples() {
+		return cur, true
+	}
+	// Diff modifies the receiver, which is not referenced anymore.
+	return prev.Diff(cur), true
+}
+
+func (c *deltaCache) removeStale(now time.Time) {
+	for k, s := range c.series {
+		if now.Sub(s.lastSeen) > cumulativeSeriesMaxAge {
+			delete(c.series, k)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("deltaCache", func() {
+	newTree := func(stacks map[string]uint64) *tree.Tree {
+		t := tree.New()
+		for k, v := range stacks {
+			t.Insert([]byte(k), v)
+		}
+		return t
+	}
+
+	It("computes deltas of cumulative profiles", func() {
+		c := newDeltaCache()
+		now := time.Now()
+		_, ok := c.delta("app{instance=a}", newTree(map[string]uint64{"foo;bar": 10}), now)
+		Expect(ok).To(BeFalse())
+		_, ok = c.delta("app{instance=b}", newTree(map[string]uint64{"foo;bar": 1}), now)
+		Expect(ok).To(BeFalse())
+
+		d, ok := c.delta("app{instance=a}", newTree(map[string]uint64{"foo;bar": 15, "foo;baz": 3}), now)
+		Expect(ok).To(BeTrue())
+		Expect(d.String()).To(Equal("foo;bar 5\nfoo;baz 3\n"))
+
+		d, ok = c.delta("app{instance=a}", newTree(map[string]uint64{"foo;bar": 20, "foo;baz": 3}), now)
+		Expect(ok).To(BeTrue())
+		Expect(d.String()).To(Equal("foo;bar 5\n"))
+	})
+
+	It("handles counter resets", func() {
+		c := newDeltaCache()
+		now := time.Now()
+		c.delta("app", newTree(map[string]uint64{"foo": 10}), now)
+		d, ok := c.delta("app", newTree(map[string]uint64{"foo": 4}), now)
+		Expect(ok).To(BeTrue())
+		Expect(d.String()).To(Equal("foo 4\n"))
+	})
+})
+
+var _ = Describe("/ingest?cumulative=true", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		ts := withTestServer(cfg)
+
+		It("stores only growth between uploads", func() {
+			upload := func(from, until int, body string) {
+				q := url.Values{
+					"name":       []string{"test.app.alloc_space{instance=a}"},
+					"from":       []string{strconv.Itoa(from)},
+					"until":      []string{strconv.Itoa(until)},
+					"cumulative": []string{"true"},
+				}
+				res, err := http.Post(ts.URL+"/ingest?"+q.Encode(), "text/plain", bytes.NewBufferString(body))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+			}
+
+			upload(1609459200, 1609459210, "foo;bar 10\n")
+			upload(1609459210, 1609459220, "foo;bar 15\nfoo;baz 3\n")
+			upload(1609459220, 1609459230, "foo;bar 20\nfoo;baz 3\n")
+
+			sk, _ := segment.ParseKey("test.app.alloc_space{instance=a}")
+			gOut, err := ts.storage.Get(&storage.GetInput{
+				StartTime: time.Unix(1609459200, 0),
+				EndTime:   time.Unix(1609459230, 0),
+				Key:       sk,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(gOut).ToNot(BeNil())
+			Expect(gOut.Tree.String()).To(Equal("foo;bar 10\nfoo;baz 3\n"))
+		})
+	})
+})
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+)
+
+type dryRunReport struct {
+	Valid bool `json:"valid"`
+	// Error describes why the request was rejected.
+	Error string `json:"error,omitempty"`
+	// Dropped is true if the profile was dropped by relabeling rules.
+	Dropped  bool            `json:"dropped,omitempty"`
+	Profiles []dryRunProfile `json:"profiles"`
+	Warnings []string        `json:"warnings"`
+}
+
+type dryRunProfile struct {
+	Name            string    `json:"name"`
+	From            time.Time `json:"from"`
+	Until           time.Time `json:"until"`
+	SpyName         string    `json:"spyName"`
+	SampleRate      uint32    `json:"sampleRate"`
+	Units           string    `json:"units"`
+	AggregationType string    `json:"aggregationType"`
+	Samples         uint64    `json:"samples"`
+}
+
+// dryRun parses and validates the request the same way as a regular
+// ingestion request, but instead of writing profiles to storage, responds
+// with a report describing them. The response status is the one a regular
+// request would get.
+func (h ingestHandler) dryRun(w http.ResponseWriter, r *http.Request) {
+	var ew batchEntryWriter
+	_, inputs, ok := h.parse(&ew, r, true)
+	report := dryRunReport{
+		Valid:    ok,
+		Profiles: make([]dryRunProfile, 0, len(inputs)),
+		Warnings: make([]string, 0),
+	}
+	if !ok {
+		report.Error = strings.TrimSpace(ew.body.String())
+	} else if len(inputs) == 0 {
+		report.Dropped = true
+	}
+	for _, input := range inputs {
+		report.Profiles = append(report.Profiles, dryRunProfile{
+			Name:            input.Key.Normalized(),
+			From:            input.StartTime,
+			Until:           input.EndTime,
+			SpyName:         input.SpyName,
+			SampleRate:      input.SampleRate,
+			Units:           input.Units,
+			AggregationType: input.AggregationType,
+			Samples:         input.Val.Samples(),
+		})
+		report.Warnings = append(report.Warnings, dryRunWarnings(input)...)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(ew.status())
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		h.log.WithError(err).Error("failed to encode dry run report")
+	}
+}
+
+func dryRunWarnings(pi *storage.PutInput) []string {
+	var warnings []string
+	name := pi.Key.Normalized()
+	if pi.EndTime.Before(pi.StartTime) {
+		warnings = append(warnings, name+": until is before from")
+	}
+	if pi.StartTime.After(time.Now().Add(time.Hour)) {
+		warnings = append(warnings, name+": from is in the future")
+	}
+	if pi.Val.Samples() == 0 {
+		warnings = append(warnings, name+": profile is empty")
+	}
+	return warnings
+}
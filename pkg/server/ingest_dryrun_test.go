@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("/ingest?dryRun=true", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		ts := withTestServer(cfg)
+
+		dryRun := func(q url.Values, body string) (int, dryRunReport) {
+			q.Set("dryRun", "true")
+			res, err := http.Post(ts.URL+"/ingest?"+q.Encode(), "text/plain", bytes.NewBufferString(body))
+			Expect(err).ToNot(HaveOccurred())
+			defer res.Body.Close()
+			Expect(res.Header.Get("Content-Type")).To(Equal("application/json"))
+			var report dryRunReport
+			Expect(json.NewDecoder(res.Body).Decode(&report)).To(Succeed())
+			return res.StatusCode, report
+		}
+
+		It("reports parsed profiles without storing them", func() {
+			code, report := dryRun(url.Values{
+				"name":  []string{"test.app{foo=bar}"},
+				"from":  []string{"1609459200"},
+				"until": []string{"1609459210"},
+			}, "foo;bar 2\nfoo;baz 3\n")
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(report.Valid).To(BeTrue())
+			Expect(report.Warnings).To(BeEmpty())
+			Expect(report.Profiles).To(HaveLen(1))
+			p := report.Profiles[0]
+			Expect(p.Name).To(Equal("test.app{foo=bar}"))
+			Expect(p.From.Unix()).To(Equal(int64(1609459200)))
+			Expect(p.Until.Unix()).To(Equal(int64(1609459210)))
+			Expect(p.Samples).To(Equal(uint64(5)))
+			Expect(p.Units).To(Equal("samples"))
+			Expect(ts.storage.GetAppNames()).To(BeEmpty())
+		})
+
+		It("reports warnings", func() {
+			code, report := dryRun(url.Values{
+				"name":  []string{"test.app"},
+				"from":  []string{"1609459210"},
+				"until": []string{"1609459200"},
+			}, "")
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(report.Valid).To(BeTrue())
+			Expect(report.Warnings).To(ConsistOf(
+				"test.app{}: until is before from",
+				"test.app{}: profile is empty",
+			))
+		})
+
+		It("reports invalid requests", func() {
+			code, report := dryRun(url.Values{"name": []string{`test.app{foo"bar=baz}`}}, "foo 1")
+			Expect(code).To(Equal(http.StatusBadRequest))
+			Expect(report.Valid).To(BeFalse())
+			Expect(report.Error).To(ContainSubstring("invalid parameter"))
+			Expect(report.Profiles).To(BeEmpty())
+		})
+
+		It("reports malformed bodies", func() {
+			code, report := dryRun(url.Values{"name": []string{"test.app"}}, "foo;bar x\n")
+			Expect(code).To(Equal(http.StatusUnprocessableEntity))
+			Expect(report.Valid).To(BeFalse())
+			Expect(report.Error).ToNot(BeEmpty())
+		})
+	})
+})
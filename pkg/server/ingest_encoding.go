@@ -0,0 +1,50 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ingestDecodeMiddleware decodes ingestion request bodies compressed with
+// gzip or zstd, as specified by Content-Encoding header. Decoded bodies are
+// subject to the same size limit as raw ones.
+func (ctrl *Controller) ingestDecodeMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			body io.ReadCloser
+			err  error
+		)
+		switch e := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); e {
+		case "", "identity":
+			next.ServeHTTP(w, r)
+			return
+		case "gzip", "x-gzip":
+			body, err = gzip.NewReader(r.Body)
+		case "zstd":
+			var d *zstd.Decoder
+			if d, err = zstd.NewReader(r.Body, zstd.WithDecoderConcurrency(1)); err == nil {
+				body = d.IOReadCloser()
+			}
+		default:
+			ctrl.writeErrorMessage(w, http.StatusUnsupportedMediaType, "unsupported content encoding "+e)
+			return
+		}
+		if err != nil {
+			ctrl.writeError(w, http.StatusBadRequest, err, "error happened while decoding request body")
+			return
+		}
+		defer body.Close()
+		if max := int64(ctrl.config.IngestMaxBodySize); max > 0 {
+			body = &limitedBody{ReadCloser: body, n: max}
+		}
+		r.Body = body
+		r.ContentLength = -1
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+
+	"github.com/klauspost/compress/zstd"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("ingestion Content-Encoding", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		ts := withTestServer(cfg)
+
+		const profile = "foo;bar 2\nfoo;baz 3\n"
+
+		gzipped := func(b []byte) []byte {
+			var buf bytes.Buffer
+			w := gzip.NewWriter(&buf)
+			_, _ = w.Write(b)
+			Expect(w.Close()).To(Succeed())
+			return buf.Bytes()
+		}
+
+		zstdCompressed := func(b []byte) []byte {
+			e, err := zstd.NewWriter(nil)
+			Expect(err).ToNot(HaveOccurred())
+			return e.EncodeAll(b, nil)
+		}
+
+		ingest := func(encoding string, body []byte) int {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/ingest?name=test.app&from=1609459200&until=1609459210", bytes.NewReader(body))
+			Expect(err).ToNot(HaveOccurred())
+			req.Header.Set("Content-Type", "text/plain")
+			req.Header.Set("Content-Encoding", encoding)
+			res, err := http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			_ = res.Body.Close()
+			return res.StatusCode
+		}
+
+		DescribeTable("decodes compressed bodies",
+			func(encoding string, encode func([]byte) []byte) {
+				Expect(ingest(encoding, encode([]byte(profile)))).To(Equal(http.StatusOK))
+				sk, _ := segment.ParseKey("test.app")
+				gOut, err := ts.storage.Get(&storage.GetInput{
+					StartTime: testing.ParseTime("2021-01-01-00:00:00"),
+					EndTime:   testing.ParseTime("2021-01-01-00:00:10"),
+					Key:       sk,
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(gOut).ToNot(BeNil())
+				Expect(gOut.Tree.String()).To(Equal(profile))
+			},
+			Entry("gzip", "gzip", gzipped),
+			Entry("zstd", "zstd", zstdCompressed),
+		)
+
+		It("rejects unsupported encodings", func() {
+			Expect(ingest("br", []byte(profile))).To(Equal(http.StatusUnsupportedMediaType))
+		})
+
+		It("rejects malformed bodies", func() {
+			Expect(ingest("gzip", []byte(profile))).To(Equal(http.StatusBadRequest))
+		})
+
+		Context("body size limit", func() {
+			BeforeEach(func() {
+				(*cfg).Server.IngestMaxBodySize = 256
+			})
+
+			It("applies to decoded bodies", func() {
+				body := bytes.Repeat([]byte("foo;bar 1\n"), 100)
+				Expect(ingest("gzip", gzipped(body))).To(Equal(http.StatusRequestEntityTooLarge))
+			})
+		})
+	})
+})
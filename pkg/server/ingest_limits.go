@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+)
+
+var errRequestBodyTooLarge = errors.New("request body too large")
+
+// maxIngestLimiters bounds the number of rate limiters of an ingestLimiter:
+// once exceeded, limiters that have not been used recently are removed.
+const (
+	maxIngestLimiters   = 10000
+	ingestLimiterMaxAge = 10 * time.Minute
+)
+
+// ingestLimiter limits the rate of ingestion requests per key, e.g.
+// application name or API key name.
+type ingestLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mutex    sync.Mutex
+	limiters map[string]*keyLimiter
+}
+
+type keyLimiter struct {
+	*rate.Limiter
+	lastSeen time.Time
+}
+
+func newIngestLimiter(limit float64, burst int) *ingestLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(limit))
+	}
+	return &ingestLimiter{
+		limit:    rate.Limit(limit),
+		burst:    burst,
+		limiters: make(map[string]*keyLimiter),
+	}
+}
+
+// reserve reports whether a request for the given key is allowed now.
+// If it is not, the returned duration tells when to retry.
+func (l *ingestLimiter) reserve(key string, now time.Time) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	a, ok := l.limiters[key]
+	if !ok {
+		if len(l.limiters) >= maxIngestLimiters {
+			l.removeStale(now)
+		}
+		a = &keyLimiter{Limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.limiters[key] = a
+	}
+	a.lastSeen = now
+	if a.AllowN(now, 1) {
+		return true, 0
+	}
+	r := a.ReserveN(now, 1)
+	d := r.DelayFrom(now)
+	r.CancelAt(now)
+	return false, d
+}
+
+// sameLimits reports whether the limiters have the same limits.
+func (l *ingestLimiter) sameLimits(x *ingestLimiter) bool {
+	if l == nil || x == nil {
+		return l == x
+	}
+	return l.limit == x.limit && l.burst == x.burst
+}
+
+func (l *ingestLimiter) removeStale(now time.Time) {
+	for k, a := range l.limiters {
+		if now.Sub(a.lastSeen) > ingestLimiterMaxAge {
+			delete(l.limiters, k)
+		}
+	}
+}
+
+// ingestRateLimitMiddleware rejects ingestion requests exceeding the
+// per-application or per-API key rate limit with 429. The middleware
+// should follow the signature verification, so that requests that are
+// not authentic do not use up the limits.
+func (ctrl *Controller) ingestRateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, d := ctrl.allowIngest(r.Context(), tenantName(r.Context(), r.URL.Query().Get("name"))); !ok {
+			writeRateLimitExceeded(ctrl.log, w, d)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// ingestBodyLimitMiddleware rejects ingestion requests exceeding the body
+// size limit with 413.
+func (ctrl *Controller) ingestBodyLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if max := int64(ctrl.config.IngestMaxBodySize); max > 0 {
+			if r.ContentLength > max {
+				WriteErrorMessage(ctrl.log, w, http.StatusRequestEntityTooLarge, errRequestBodyTooLarge.Error())
+				return
+			}
+			r.Body = &limitedBody{ReadCloser: r.Body, n: max}
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// allowIngest reports whether a profile with the given name can be
+// ingested now with the API key of the request, if any, and if not,
+// when to retry.
+func (ctrl *Controller) allowIngest(ctx context.Context, name string) (bool, time.Duration) {
+	ctrl.reloadMutex.RLock()
+	l, kl := ctrl.ingestLimiter, ctrl.ingestKeyLimiter
+	ctrl.reloadMutex.RUnlock()
+	now := time.Now()
+	if k, ok := ctx.Value(apiKeyContextKey{}).(*storage.APIKey); ok && kl != nil {
+		if ok, d := kl.reserve(k.Name, now); !ok {
+			return false, d
+		}
+	}
+	if l == nil {
+		return true, 0
+	}
+	var appName string
+	if k, err := segment.ParseKey(name); err == nil {
+		appName = k.AppName()
+	}
+	return l.reserve(appName, now)
+}
+
+func writeRateLimitExceeded(log *logrus.Logger, w http.ResponseWriter, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	WriteErrorMessage(log, w, http.StatusTooManyRequests, "ingestion rate limit exceeded")
+}
+
+// limitedBody returns errRequestBodyTooLarge if more than n bytes are read.
+type limitedBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.n <= 0 {
+		// A body of exactly n bytes may only report EOF on the next read,
+		// e.g., if the body is chunked.
+		var probe [1]byte
+		if n, err := b.ReadCloser.Read(probe[:]); n == 0 {
+			return 0, err
+		}
+		return 0, errRequestBodyTooLarge
+	}
+	if int64(len(p)) > b.n+1 {
+		p = p[:b.n+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) > b.n {
+		n = int(b.n)
+		err = errRequestBodyTooLarge
+	}
+	b.n -= int64(n)
+	return n, err
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("ingestion limits", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		ts := withTestServer(cfg)
+
+		ingest := func(app string, body io.Reader) *http.Response {
+			res, err := http.Post(ts.URL+"/ingest?name="+app, "text/plain", body)
+			Expect(err).ToNot(HaveOccurred())
+			_ = res.Body.Close()
+			return res
+		}
+
+		Context("body size limit", func() {
+			BeforeEach(func() {
+				(*cfg).Server.IngestMaxBodySize = 16
+			})
+
+			It("accepts requests within the limit", func() {
+				Expect(ingest("test.app", bytes.NewBufferString("foo;bar 1")).StatusCode).To(Equal(http.StatusOK))
+			})
+
+			It("rejects requests with large Content-Length", func() {
+				res := ingest("test.app", bytes.NewBufferString("foo;bar;baz;qux 1\n"))
+				Expect(res.StatusCode).To(Equal(http.StatusRequestEntityTooLarge))
+				Expect(ts.storage.GetAppNames()).To(BeEmpty())
+			})
+
+			It("rejects chunked requests exceeding the limit", func() {
+				body := io.MultiReader(strings.NewReader("foo;bar 1\n"), strings.NewReader("foo;baz 1\n"))
+				res := ingest("test.app", body)
+				Expect(res.StatusCode).To(Equal(http.StatusRequestEntityTooLarge))
+				Expect(ts.storage.GetAppNames()).To(BeEmpty())
+			})
+		})
+
+		Context("rate limit", func() {
+			BeforeEach(func() {
+				(*cfg).Server.IngestRateLimit = 0.5
+				(*cfg).Server.IngestRateBurst = 2
+			})
+
+			It("rejects requests exceeding the limit with Retry-After", func() {
+				Expect(ingest("test.app", bytes.NewBufferString("foo;bar 1")).StatusCode).To(Equal(http.StatusOK))
+				Expect(ingest("test.app", bytes.NewBufferString("foo;bar 1")).StatusCode).To(Equal(http.StatusOK))
+				res := ingest("test.app{foo=bar}", bytes.NewBufferString("foo;bar 1"))
+				Expect(res.StatusCode).To(Equal(http.StatusTooManyRequests))
+				Expect(res.Header.Get("Retry-After")).To(Equal("2"))
+			})
+
+			It("limits applications independently", func() {
+				Expect(ingest("test.app", bytes.NewBufferString("foo;bar 1")).StatusCode).To(Equal(http.StatusOK))
+				Expect(ingest("test.app", bytes.NewBufferString("foo;bar 1")).StatusCode).To(Equal(http.StatusOK))
+				Expect(ingest("test.app", bytes.NewBufferString("foo;bar 1")).StatusCode).To(Equal(http.StatusTooManyRequests))
+				Expect(ingest("other.app", bytes.NewBufferString("foo;bar 1")).StatusCode).To(Equal(http.StatusOK))
+			})
+		})
+
+		Context("cardinality limit", func() {
+			BeforeEach(func() {
+				(*cfg).Server.StorageMaxTagValues = 1
+			})
+
+			It("rejects profiles exceeding the limit", func() {
+				Expect(ingest("test.app{id=1}", bytes.NewBufferString("foo;bar 1")).StatusCode).To(Equal(http.StatusOK))
+				Expect(ingest("test.app{id=2}", bytes.NewBufferString("foo;bar 1")).StatusCode).To(Equal(http.StatusUnprocessableEntity))
+			})
+		})
+	})
+})
+
+var _ = Describe("limitedBody", func() {
+	read := func(body string, n int64) ([]byte, error) {
+		return io.ReadAll(&limitedBody{ReadCloser: io.NopCloser(strings.NewReader(body)), n: n})
+	}
+
+	It("reads bodies of exactly the limit", func() {
+		// strings.Reader reports EOF only on the read following the data.
+		b, err := read("foo;bar;bazzz 1\n", 16)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(b).To(HaveLen(16))
+	})
+
+	It("rejects bodies exceeding the limit", func() {
+		_, err := read("foo;bar;bazzz 1\n", 15)
+		Expect(err).To(MatchError(errRequestBodyTooLarge))
+	})
+})
+
+var _ = Describe("ingestLimiter", func() {
+	It("is disabled if the limit is not set", func() {
+		Expect(newIngestLimiter(0, 10)).To(BeNil())
+	})
+
+	It("refills tokens over time", func() {
+		l := newIngestLimiter(1, 1)
+		now := time.Now()
+		ok, _ := l.reserve("app", now)
+		Expect(ok).To(BeTrue())
+		ok, d := l.reserve("app", now)
+		Expect(ok).To(BeFalse())
+		Expect(d).To(Equal(time.Second))
+		ok, _ = l.reserve("app", now.Add(time.Second))
+		Expect(ok).To(BeTrue())
+	})
+})
@@ -0,0 +1,425 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/util/bytesize"
+)
+
+const (
+	// quotaBurstSeconds is the number of seconds worth of samples allowed
+	// to be ingested at once: agents upload profiles every 10 seconds.
+	quotaBurstSeconds = 10
+	// quotaUsageIdleTimeout is the time after which the usage of a subject
+	// that does not ingest data is removed: by then, its limits are reset.
+	quotaUsageIdleTimeout   = 24 * time.Hour
+	quotaUsagePruneInterval = time.Hour
+)
+
+var errQuotaExceeded = errors.New("ingestion quota exceeded")
+
+// ingestQuotas enforce limits on the rate of ingested samples, the size
+// of ingested data per day, and the number of series, per tenant or per
+// application. Quotas are checked before a profile is written: if any of
+// the quotas matching the profile is exceeded, the request is rejected
+// and the usage of none of the quotas is updated. In clustering mode,
+// quotas are enforced by every server independently.
+//
+// Series are counted in the storage, therefore series removed by
+// retention or deletion are not counted, and in clustering mode only
+// series of the applications owned by the server are counted. Concurrent
+// requests adding new series may exceed the limit slightly: the series
+// are only counted once written.
+type ingestQuotas struct {
+	mutex  sync.Mutex
+	quotas []*ingestQuota
+	series seriesCounter
+	pruned time.Time
+}
+
+// seriesCounter counts series stored, see storage.Storage.
+type seriesCounter interface {
+	AppSeries(appName string) int
+	HasSeries(k *segment.Key) bool
+	GetAppNames() []string
+}
+
+type ingestQuota struct {
+	tenant           string
+	app              string
+	samplesPerSecond float64
+	bytesPerDay      int64
+	maxSeries        int
+
+	// Usage by subject: the stored application name (i.e. including the
+	// tenant prefix), or the tenant, if the app pattern is not specified.
+	usage map[string]*quotaUsage
+}
+
+type quotaUsage struct {
+	limiter *rate.Limiter
+
+	// Samples ingested within the current and the previous minute.
+	minute      int64
+	samples     uint64
+	prevSamples uint64
+
+	day   int64
+	bytes int64
+
+	lastUsed time.Time
+}
+
+func newIngestQuotas(c []config.IngestQuota, series seriesCounter) (*ingestQuotas, error) {
+	if len(c) == 0 {
+		return nil, nil
+	}
+	q := ingestQuotas{quotas: make([]*ingestQuota, 0, len(c)), series: series}
+	for _, x := range c {
+		if x.Tenant != "" {
+			if err := validateTenantID(x.Tenant); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := path.Match(x.App, ""); err != nil {
+			return nil, fmt.Errorf("invalid app pattern %q: %w", x.App, err)
+		}
+		iq := ingestQuota{
+			tenant:           x.Tenant,
+			app:              x.App,
+			samplesPerSecond: x.SamplesPerSecond,
+			maxSeries:        x.MaxSeries,
+			usage:            make(map[string]*quotaUsage),
+		}
+		if x.BytesPerDay != "" {
+			b, err := bytesize.Parse(x.BytesPerDay)
+			if err != nil || b <= 0 {
+				return nil, fmt.Errorf("invalid bytes per day %q", x.BytesPerDay)
+			}
+			iq.bytesPerDay = int64(b)
+		}
+		if iq.samplesPerSecond < 0 || iq.maxSeries < 0 || (iq.samplesPerSecond == 0 && iq.bytesPerDay == 0 && iq.maxSeries == 0) {
+			return nil, fmt.Errorf("quota for tenant %q and app %q has no valid limits", x.Tenant, x.App)
+		}
+		q.quotas = append(q.quotas, &iq)
+	}
+	return &q, nil
+}
+
+// subject returns the key the usage of the profile is accounted by,
+// if the quota applies to the profile.
+func (q *ingestQuota) subject(tenant, appName string) (string, bool) {
+	if q.tenant != "" && q.tenant != tenant {
+		return "", false
+	}
+	if q.app == "" {
+		return tenant, true
+	}
+	name := appName
+	if tenant != "" {
+		name = strings.TrimPrefix(name, tenant+".")
+	}
+	if ok, _ := path.Match(q.app, name); !ok {
+		return "", false
+	}
+	return appName, true
+}
+
+func (q *ingestQuota) usageOf(subject string) *quotaUsage {
+	u, ok := q.usage[subject]
+	if !ok {
+		u = new(quotaUsage)
+		if q.samplesPerSecond > 0 {
+			burst := int(math.Ceil(q.samplesPerSecond * quotaBurstSeconds))
+			u.limiter = rate.NewLimiter(rate.Limit(q.samplesPerSecond), burst)
+		}
+		q.usage[subject] = u
+	}
+	return u
+}
+
+func (u *quotaUsage) advance(now time.Time) {
+	if m := now.Unix() / 60; m != u.minute {
+		if m == u.minute+1 {
+			u.prevSamples = u.samples
+		} else {
+			u.prevSamples = 0
+		}
+		u.minute, u.samples = m, 0
+	}
+	if d := now.Unix() / 86400; d != u.day {
+		u.day, u.bytes = d, 0
+	}
+}
+
+// prune removes usage of the subjects idle for quotaUsageIdleTimeout.
+func (q *ingestQuotas) prune(now time.Time) {
+	if now.Sub(q.pruned) < quotaUsagePruneInterval {
+		return
+	}
+	q.pruned = now
+	for _, iq := range q.quotas {
+		for subject, u := range iq.usage {
+			if now.Sub(u.lastUsed) > quotaUsageIdleTimeout {
+				delete(iq.usage, subject)
+			}
+		}
+	}
+}
+
+// seriesOf returns the number of series accounted by the subject: the
+// application, or the applications of the tenant (all, if there is none).
+func (q *ingestQuotas) seriesOf(iq *ingestQuota, subject string) int {
+	if iq.app != "" {
+		return q.series.AppSeries(subject)
+	}
+	var n int
+	for _, appName := range q.series.GetAppNames() {
+		if subject == "" || strings.HasPrefix(appName, subject+".") {
+			n += q.series.AppSeries(appName)
+		}
+	}
+	return n
+}
+
+type quotaCharge struct {
+	quota   *ingestQuota
+	subject string
+	usage   *quotaUsage
+	samples uint64
+	bytes   int64
+	series  map[string]struct{}
+}
+
+// quotaExceededError tells when the request can be retried, if known.
+type quotaExceededError struct {
+	msg        string
+	retryAfter time.Duration
+}
+
+func (e *quotaExceededError) Error() string { return errQuotaExceeded.Error() + ": " + e.msg }
+func (e *quotaExceededError) Unwrap() error { return errQuotaExceeded }
+
+// admit checks the profiles of a request of the given size against the
+// quotas, and updates the usage if none is exceeded. The size is split
+// evenly between the profiles.
+func (q *ingestQuotas) admit(ctx context.Context, inputs []*storage.PutInput, size int64, now time.Time) error {
+	if q == nil || len(inputs) == 0 {
+		return nil
+	}
+	tenant, _ := tenantFromContext(ctx)
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.prune(now)
+
+	charges := make(map[*quotaUsage]*quotaCharge)
+	var order []*quotaCharge
+	for _, pi := range inputs {
+		for _, iq := range q.quotas {
+			subject, ok := iq.subject(tenant, pi.Key.AppName())
+			if !ok {
+				continue
+			}
+			u := iq.usageOf(subject)
+			c, ok := charges[u]
+			if !ok {
+				u.advance(now)
+				u.lastUsed = now
+				c = &quotaCharge{quota: iq, subject: subject, usage: u, series: make(map[string]struct{})}
+				charges[u] = c
+				order = append(order, c)
+			}
+			c.samples += pi.Val.Samples()
+			c.bytes += size / int64(len(inputs))
+			if iq.maxSeries > 0 && !q.series.HasSeries(pi.Key) {
+				c.series[pi.Key.Normalized()] = struct{}{}
+			}
+		}
+	}
+
+	reservations := make([]*rate.Reservation, 0, len(order))
+	cancel := func() {
+		for _, r := range reservations {
+			r.CancelAt(now)
+		}
+	}
+	for _, c := range order {
+		if err := q.check(c, tenant, now, &reservations); err != nil {
+			cancel()
+			return err
+		}
+	}
+	for _, c := range order {
+		c.usage.samples += c.samples
+		c.usage.bytes += c.bytes
+	}
+	return nil
+}
+
+func (q *ingestQuotas) check(c *quotaCharge, tenant string, now time.Time, reservations *[]*rate.Reservation) error {
+	iq := c.quota
+	var subject string
+	switch {
+	case iq.app != "":
+		subject = stripTenantPrefix(tenant, c.subject)
+	case tenant != "":
+		subject = "tenant " + tenant
+	default:
+		subject = "all applications"
+	}
+	if iq.maxSeries > 0 && len(c.series) > 0 && q.seriesOf(iq, c.subject)+len(c.series) > iq.maxSeries {
+		return &quotaExceededError{msg: fmt.Sprintf("%s: the maximum number of series is %d", subject, iq.maxSeries)}
+	}
+	if iq.bytesPerDay > 0 && c.usage.bytes+c.bytes > iq.bytesPerDay {
+		midnight := time.Unix((c.usage.day+1)*86400, 0)
+		return &quotaExceededError{
+			msg:        fmt.Sprintf("%s: the maximum size of data per day is %s", subject, bytesize.ByteSize(iq.bytesPerDay)),
+			retryAfter: midnight.Sub(now),
+		}
+	}
+	if c.usage.limiter != nil && c.samples > 0 {
+		n := c.samples
+		if n > math.MaxInt32 {
+			n = math.MaxInt32
+		}
+		r := c.usage.limiter.ReserveN(now, int(n))
+		if !r.OK() {
+			return &quotaExceededError{msg: fmt.Sprintf("%s: the profile exceeds the maximum burst of samples", subject)}
+		}
+		*reservations = append(*reservations, r)
+		if d := r.DelayFrom(now); d > 0 {
+			return &quotaExceededError{
+				msg:        fmt.Sprintf("%s: the maximum rate of samples is %g per second", subject, iq.samplesPerSecond),
+				retryAfter: d,
+			}
+		}
+	}
+	return nil
+}
+
+func stripTenantPrefix(tenant, subject string) string {
+	if tenant == "" {
+		return subject
+	}
+	return strings.TrimPrefix(subject, tenant+".")
+}
+
+func writeQuotaExceeded(log logrus.FieldLogger, w http.ResponseWriter, err error) {
+	var e *quotaExceededError
+	if errors.As(err, &e) && e.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(e.retryAfter.Seconds()))))
+	}
+	WriteErrorMessage(log, w, http.StatusTooManyRequests, err.Error())
+}
+
+// countingBody counts the bytes read from the request body.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+type limitUsage struct {
+	Tenant string `json:"tenant,omitempty"`
+	App    string `json:"app,omitempty"`
+	// Subject is the application or the tenant the usage is accounted by.
+	Subject string `json:"subject"`
+
+	SamplesPerSecondLimit float64 `json:"samplesPerSecondLimit,omitempty"`
+	// SamplesPerSecond is the average rate over the previous minute.
+	SamplesPerSecond float64 `json:"samplesPerSecond"`
+	BytesPerDayLimit int64   `json:"bytesPerDayLimit,omitempty"`
+	BytesToday       int64   `json:"bytesToday"`
+	MaxSeries        int     `json:"maxSeries,omitempty"`
+	Series           int     `json:"series"`
+}
+
+// usage returns the current usage of the quotas by subjects of the tenant
+// (of all the tenants, if empty) the filter function accepts.
+func (q *ingestQuotas) usage(tenant string, hasTenant bool, accept func(subject string) bool, now time.Time) []limitUsage {
+	res := make([]limitUsage, 0)
+	if q == nil {
+		return res
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for _, iq := range q.quotas {
+		if hasTenant && iq.tenant != "" && iq.tenant != tenant {
+			continue
+		}
+		for subject, u := range iq.usage {
+			stored := subject
+			if hasTenant {
+				if iq.app == "" && subject != tenant {
+					continue
+				}
+				if iq.app != "" && !strings.HasPrefix(subject, tenant+".") {
+					continue
+				}
+				subject = strings.TrimPrefix(subject, tenant+".")
+			}
+			if iq.app != "" && !accept(subject) {
+				continue
+			}
+			u.advance(now)
+			var series int
+			if iq.maxSeries > 0 {
+				series = q.seriesOf(iq, stored)
+			}
+			res = append(res, limitUsage{
+				Tenant:                iq.tenant,
+				App:                   iq.app,
+				Subject:               subject,
+				SamplesPerSecondLimit: iq.samplesPerSecond,
+				SamplesPerSecond:      float64(u.prevSamples) / 60,
+				BytesPerDayLimit:      iq.bytesPerDay,
+				BytesToday:            u.bytes,
+				MaxSeries:             iq.maxSeries,
+				Series:                series,
+			})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Subject != res[j].Subject {
+			return res[i].Subject < res[j].Subject
+		}
+		return res[i].App < res[j].App
+	})
+	return res
+}
+
+// limitsHandler returns the usage of the ingestion quotas of the request
+// tenant.
+func (ctrl *Controller) limitsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ctrl.writeInvalidMethodError(w)
+		return
+	}
+	tenant, ok := tenantFromContext(r.Context())
+	accept := func(appName string) bool {
+		return authorizeApp(r.Context(), appName) == nil
+	}
+	ctrl.writeResponseJSON(w, ctrl.ingestQuotas.usage(tenant, ok, accept, time.Now()))
+}
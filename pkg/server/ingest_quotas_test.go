@@ -0,0 +1,208 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+// seriesSet is an in-memory seriesCounter.
+type seriesSet map[string]map[string]struct{}
+
+func (s seriesSet) add(inputs ...*storage.PutInput) {
+	for _, pi := range inputs {
+		app := pi.Key.AppName()
+		if s[app] == nil {
+			s[app] = make(map[string]struct{})
+		}
+		s[app][pi.Key.SegmentKey()] = struct{}{}
+	}
+}
+
+func (s seriesSet) AppSeries(appName string) int { return len(s[appName]) }
+
+func (s seriesSet) HasSeries(k *segment.Key) bool {
+	_, ok := s[k.AppName()][k.SegmentKey()]
+	return ok
+}
+
+func (s seriesSet) GetAppNames() []string {
+	names := make([]string, 0, len(s))
+	for n := range s {
+		names = append(names, n)
+	}
+	return names
+}
+
+var _ = Describe("ingestion quotas", func() {
+	now := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+	ctx := context.Background()
+	var series seriesSet
+
+	BeforeEach(func() {
+		series = make(seriesSet)
+	})
+
+	// admit writes the profiles to the series set, if admitted.
+	admit := func(q *ingestQuotas, now time.Time, inputs ...*storage.PutInput) error {
+		err := q.admit(ctx, inputs, 0, now)
+		if err == nil {
+			series.add(inputs...)
+		}
+		return err
+	}
+
+	input := func(name string, samples int) *storage.PutInput {
+		k, err := segment.ParseKey(name)
+		Expect(err).ToNot(HaveOccurred())
+		t := tree.New()
+		t.InsertInt([]byte("foo;bar"), samples)
+		return &storage.PutInput{Key: k, Val: t}
+	}
+
+	It("limits the rate of samples", func() {
+		q, err := newIngestQuotas([]config.IngestQuota{{App: "*.cpu", SamplesPerSecond: 10}}, series)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(q.admit(ctx, []*storage.PutInput{input("app.cpu", 100)}, 0, now)).To(Succeed())
+		err = q.admit(ctx, []*storage.PutInput{input("app.cpu", 10)}, 0, now)
+		Expect(err).To(MatchError(errQuotaExceeded))
+		Expect(err.(*quotaExceededError).retryAfter).To(Equal(time.Second))
+		By("accounting applications individually")
+		Expect(q.admit(ctx, []*storage.PutInput{input("other.cpu", 100)}, 0, now)).To(Succeed())
+		Expect(q.admit(ctx, []*storage.PutInput{input("app.alloc_space", 1000)}, 0, now)).To(Succeed())
+		Expect(q.admit(ctx, []*storage.PutInput{input("app.cpu", 10)}, 0, now.Add(time.Second))).To(Succeed())
+	})
+
+	It("limits the size of data per day", func() {
+		q, err := newIngestQuotas([]config.IngestQuota{{BytesPerDay: "1KB"}}, series)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(q.admit(ctx, []*storage.PutInput{input("app.cpu", 1)}, 1000, now)).To(Succeed())
+		err = q.admit(ctx, []*storage.PutInput{input("other.cpu", 1)}, 100, now)
+		Expect(err).To(MatchError(errQuotaExceeded))
+		Expect(err.(*quotaExceededError).retryAfter).To(Equal(12 * time.Hour))
+		Expect(q.admit(ctx, []*storage.PutInput{input("other.cpu", 1)}, 100, now.Add(12*time.Hour))).To(Succeed())
+	})
+
+	It("limits the number of series", func() {
+		q, err := newIngestQuotas([]config.IngestQuota{{App: "app.*", MaxSeries: 2}, {SamplesPerSecond: 1}}, series)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(admit(q, now, input("app.cpu{pod=a}", 1))).To(Succeed())
+		Expect(admit(q, now, input("app.cpu{pod=b}", 1))).To(Succeed())
+		Expect(admit(q, now, input("app.cpu{pod=a}", 1))).To(Succeed())
+		Expect(admit(q, now, input("app.cpu{pod=c}", 1))).To(MatchError(errQuotaExceeded))
+		Expect(admit(q, now, input("app.cpu{pod=c}", 1), input("app.cpu{pod=d}", 1))).To(MatchError(errQuotaExceeded))
+
+		By("not updating the usage of a rejected request")
+		u := q.usage("", false, func(string) bool { return true }, now)
+		Expect(u).To(HaveLen(2))
+		Expect(u[0].Subject).To(BeEmpty())
+		Expect(u[1].Subject).To(Equal("app.cpu"))
+		Expect(u[1].Series).To(Equal(2))
+		Expect(admit(q, now, input("other.cpu", 7))).To(Succeed())
+
+		By("not counting series removed")
+		delete(series, "app.cpu")
+		Expect(admit(q, now.Add(time.Minute), input("app.cpu{pod=c}", 1))).To(Succeed())
+	})
+
+	It("removes usage of idle subjects", func() {
+		q, err := newIngestQuotas([]config.IngestQuota{{App: "*.cpu", SamplesPerSecond: 1}}, series)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(admit(q, now, input("app.cpu", 1))).To(Succeed())
+		Expect(admit(q, now.Add(time.Hour), input("other.cpu", 1))).To(Succeed())
+		Expect(q.quotas[0].usage).To(HaveLen(2))
+		Expect(admit(q, now.Add(25*time.Hour), input("other.cpu", 1))).To(Succeed())
+		Expect(q.quotas[0].usage).To(HaveLen(1))
+		Expect(q.quotas[0].usage).To(HaveKey("other.cpu"))
+	})
+
+	It("rejects invalid quotas", func() {
+		_, err := newIngestQuotas([]config.IngestQuota{{App: "*.cpu"}}, series)
+		Expect(err).To(HaveOccurred())
+		_, err = newIngestQuotas([]config.IngestQuota{{BytesPerDay: "a lot"}}, series)
+		Expect(err).To(HaveOccurred())
+		_, err = newIngestQuotas([]config.IngestQuota{{App: "[", MaxSeries: 1}}, series)
+		Expect(err).To(HaveOccurred())
+		q, err := newIngestQuotas(nil, series)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(q.admit(ctx, []*storage.PutInput{input("app.cpu", 1)}, 0, now)).To(Succeed())
+	})
+
+	testing.WithConfig(func(cfg **config.Config) {
+		BeforeEach(func() {
+			(*cfg).Server.MultiTenancy = true
+			(*cfg).Server.IngestQuotas = []config.IngestQuota{
+				{Tenant: "team-a", MaxSeries: 1},
+				{App: "*.cpu", SamplesPerSecond: 0.1},
+			}
+		})
+
+		ts := withTestServer(cfg)
+
+		ingest := func(tenant, name string) *http.Response {
+			q := url.Values{"name": []string{name}, "from": []string{"1609459200"}, "until": []string{"1609459210"}}
+			req, _ := http.NewRequest(http.MethodPost, ts.URL+"/ingest?"+q.Encode(), bytes.NewBufferString("main;foo 1\n"))
+			req.Header.Set(tenantHeader, tenant)
+			res, err := http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			res.Body.Close()
+			return res
+		}
+
+		It("enforces quotas and reports the usage", func() {
+			Expect(ingest("team-a", "app.cpu").StatusCode).To(Equal(http.StatusOK))
+			Expect(ingest("team-a", "app.cpu{pod=a}").StatusCode).To(Equal(http.StatusTooManyRequests))
+			Expect(ingest("team-b", "app.cpu").StatusCode).To(Equal(http.StatusOK))
+			res := ingest("team-b", "app.cpu")
+			Expect(res.StatusCode).To(Equal(http.StatusTooManyRequests))
+			Expect(res.Header.Get("Retry-After")).To(Equal("10"))
+
+			req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/limits", nil)
+			req.Header.Set(tenantHeader, "team-a")
+			res, err := http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			var u []limitUsage
+			Expect(json.NewDecoder(res.Body).Decode(&u)).To(Succeed())
+			res.Body.Close()
+			Expect(u).To(ConsistOf(
+				limitUsage{Tenant: "team-a", Subject: "team-a", MaxSeries: 1, Series: 1, BytesToday: 11},
+				limitUsage{App: "*.cpu", Subject: "app.cpu", SamplesPerSecondLimit: 0.1, BytesToday: 11},
+			))
+		})
+
+		It("counts series in the storage", func() {
+			Expect(ingest("team-a", "app.alloc_space").StatusCode).To(Equal(http.StatusOK))
+			Expect(ingest("team-a", "app.alloc_space{pod=a}").StatusCode).To(Equal(http.StatusTooManyRequests))
+
+			By("counting series stored before the restart")
+			Expect(ts.storage.Close()).To(Succeed())
+			var err error
+			ts.storage, err = storage.New(storage.NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+			q, err := newIngestQuotas((*cfg).Server.IngestQuotas, ts.storage)
+			Expect(err).ToNot(HaveOccurred())
+			tenantCtx := context.WithValue(ctx, tenantContextKey{}, "team-a")
+			err = q.admit(tenantCtx, []*storage.PutInput{input("team-a.app.alloc_space{pod=a}", 1)}, 0, now)
+			Expect(err).To(MatchError(errQuotaExceeded))
+
+			By("not counting series removed")
+			Expect(ts.storage.DeleteApp("team-a.app.alloc_space")).To(Succeed())
+			Expect(q.admit(tenantCtx, []*storage.PutInput{input("team-a.app.alloc_space{pod=a}", 1)}, 0, now)).To(Succeed())
+		})
+	})
+})
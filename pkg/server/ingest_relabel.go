@@ -0,0 +1,66 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pyroscope-io/pyroscope/pkg/flameql"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/labels"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/relabel"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+)
+
+// SourceIPLabel is a meta label holding the IP address of the client
+// that sent the profile. Like any other label with "__" prefix (except
+// the application name), it is only available during relabeling and is
+// not stored.
+const SourceIPLabel = "__meta_source_ip"
+
+// APIKeyLabel is a meta label holding the name of the API key the
+// ingestion request is authenticated with, if any.
+const APIKeyLabel = "__meta_api_key"
+
+// relabelKey applies relabeling rules to the profile key. It returns nil
+// if the profile is to be dropped.
+func relabelKey(k *segment.Key, r *http.Request, cfgs []*relabel.Config) (*segment.Key, error) {
+	if len(cfgs) == 0 {
+		return k, nil
+	}
+	m := make(map[string]string, len(k.Labels())+2)
+	for n, v := range k.Labels() {
+		m[n] = v
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		m[SourceIPLabel] = host
+	}
+	if key, ok := r.Context().Value(apiKeyContextKey{}).(*storage.APIKey); ok {
+		m[APIKeyLabel] = key.Name
+	}
+	lset := relabel.Process(labels.FromMap(m), cfgs...)
+	if lset == nil {
+		return nil, nil
+	}
+	res := make(map[string]string, len(lset))
+	for _, l := range lset {
+		switch {
+		case l.Name == "__name__":
+			if err := flameql.ValidateAppName(l.Value); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(l.Name, "__"):
+			continue
+		default:
+			if err := flameql.ValidateTagKey(l.Name); err != nil {
+				return nil, err
+			}
+		}
+		res[l.Name] = l.Value
+	}
+	if res["__name__"] == "" {
+		return nil, errors.New("application name is empty")
+	}
+	return segment.NewKey(res), nil
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v2"
+
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/relabel"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+)
+
+var _ = Describe("relabelKey", func() {
+	parseConfigs := func(s string) []*relabel.Config {
+		var c []*relabel.Config
+		Expect(yaml.Unmarshal([]byte(s), &c)).To(Succeed())
+		return c
+	}
+
+	DescribeTable("relabels profile keys",
+		func(name, rules, expected string) {
+			k, err := segment.ParseKey(name)
+			Expect(err).ToNot(HaveOccurred())
+			r := &http.Request{RemoteAddr: "10.0.0.1:1234"}
+			k, err = relabelKey(k, r, parseConfigs(rules))
+			Expect(err).ToNot(HaveOccurred())
+			if expected == "" {
+				Expect(k).To(BeNil())
+				return
+			}
+			Expect(k).ToNot(BeNil())
+			Expect(k.Normalized()).To(Equal(expected))
+		},
+
+		Entry("no rules", "app{foo=bar}", ``, "app{foo=bar}"),
+
+		Entry("drop by app name", "test.app{foo=bar}", `
+- source-labels: [__name__]
+  regex: test\..*
+  action: drop
+`, ""),
+
+		Entry("keep by app name", "other.app{foo=bar}", `
+- source-labels: [__name__]
+  regex: test\..*
+  action: drop
+`, "other.app{foo=bar}"),
+
+		Entry("rename tag key", "app{env=dev}", `
+- action: labelmap
+  regex: env
+  replacement: environment
+- action: labeldrop
+  regex: env
+`, "app{environment=dev}"),
+
+		Entry("add static tag by source IP", "app", `
+- source-labels: [__meta_source_ip]
+  regex: 10\.0\..*
+  target-label: dc
+  replacement: eu-west
+`, "app{dc=eu-west}"),
+
+		Entry("rewrite app name", "app{service=api}", `
+- source-labels: [__name__, service]
+  separator: "."
+  target-label: __name__
+`, "app.api{service=api}"),
+	)
+
+	It("adds static tags by API key", func() {
+		k, _ := segment.ParseKey("app")
+		r := (&http.Request{}).WithContext(context.WithValue(context.Background(),
+			apiKeyContextKey{}, &storage.APIKey{Name: "payments-agent"}))
+		rules := parseConfigs(`
+- source-labels: [__meta_api_key]
+  regex: payments-.*
+  target-label: team
+  replacement: payments
+`)
+		k, err := relabelKey(k, r, rules)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(k.Normalized()).To(Equal("app{team=payments}"))
+
+		By("not exposing the label if the request is not authenticated with an API key")
+		k, _ = segment.ParseKey("app")
+		k, err = relabelKey(k, &http.Request{}, rules)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(k.Normalized()).To(Equal("app{}"))
+	})
+
+	It("rejects invalid application names", func() {
+		k, _ := segment.ParseKey("app")
+		_, err := relabelKey(k, &http.Request{}, parseConfigs(`
+- target-label: __name__
+  replacement: foo/bar
+`))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("rejects empty application name", func() {
+		k, _ := segment.ParseKey("app")
+		_, err := relabelKey(k, &http.Request{}, parseConfigs(`
+- action: labeldrop
+  regex: __name__
+`))
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/util/signature"
+)
+
+var errUnknownSigningKey = errors.New("unknown signing key")
+
+// ingestSignatureMiddleware rejects ingestion requests without a valid
+// signature with 401, if signing secrets are configured. The body is
+// read in memory to be verified, therefore the middleware must be
+// preceded by the body size limit.
+func (ctrl *Controller) ingestSignatureMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	if len(ctrl.config.IngestSigningSecrets) == 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			if errors.Is(err, errRequestBodyTooLarge) {
+				ctrl.writeError(w, http.StatusRequestEntityTooLarge, err, "failed to read request body")
+				return
+			}
+			ctrl.writeInternalServerError(w, err, "failed to read request body")
+			return
+		}
+		if err = ctrl.verifyIngestSignature(r, body); err != nil {
+			ctrl.writeError(w, http.StatusUnauthorized, err, "signature verification failed")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	}
+}
+
+func (ctrl *Controller) verifyIngestSignature(r *http.Request, body []byte) error {
+	s, err := signature.Parse(r.Header.Get(signature.Header))
+	if err != nil {
+		return err
+	}
+	secret, ok := ctrl.config.IngestSigningSecrets[s.KeyID]
+	if !ok {
+		return fmt.Errorf("%w %q", errUnknownSigningKey, s.KeyID)
+	}
+	return s.Verify([]byte(secret), r.URL.RawQuery, body, time.Now(), ctrl.config.IngestSignatureMaxAge)
+}
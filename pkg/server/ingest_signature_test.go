@@ -0,0 +1,71 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+	"github.com/pyroscope-io/pyroscope/pkg/util/signature"
+)
+
+var _ = Describe("ingestion signature", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		BeforeEach(func() {
+			(*cfg).Server.IngestSigningSecrets = map[string]string{"agent": "secret"}
+			(*cfg).Server.IngestSignatureMaxAge = 5 * time.Minute
+		})
+
+		ts := withTestServer(cfg)
+
+		const query = "name=app.cpu&from=1609459200&until=1609459210"
+		body := []byte("foo;bar 1")
+
+		ingest := func(sig string) int {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+"/ingest?"+query, bytes.NewReader(body))
+			Expect(err).ToNot(HaveOccurred())
+			if sig != "" {
+				req.Header.Set(signature.Header, sig)
+			}
+			res, err := http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			_ = res.Body.Close()
+			return res.StatusCode
+		}
+
+		It("accepts signed requests", func() {
+			Expect(ingest(signature.Sign("agent", []byte("secret"), time.Now(), query, body))).To(Equal(http.StatusOK))
+		})
+
+		It("rejects unsigned requests", func() {
+			Expect(ingest("")).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("rejects requests signed with an unknown key or secret", func() {
+			Expect(ingest(signature.Sign("other", []byte("secret"), time.Now(), query, body))).To(Equal(http.StatusUnauthorized))
+			Expect(ingest(signature.Sign("agent", []byte("other"), time.Now(), query, body))).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("rejects expired signatures", func() {
+			Expect(ingest(signature.Sign("agent", []byte("secret"), time.Now().Add(-time.Hour), query, body))).To(Equal(http.StatusUnauthorized))
+		})
+
+		Context("rate limit", func() {
+			BeforeEach(func() {
+				(*cfg).Server.IngestRateLimit = 0.5
+				(*cfg).Server.IngestRateBurst = 1
+			})
+
+			It("is not used up by requests without a valid signature", func() {
+				Expect(ingest("")).To(Equal(http.StatusUnauthorized))
+				Expect(ingest(signature.Sign("other", []byte("secret"), time.Now(), query, body))).To(Equal(http.StatusUnauthorized))
+				Expect(ingest(signature.Sign("agent", []byte("secret"), time.Now(), query, body))).To(Equal(http.StatusOK))
+				Expect(ingest(signature.Sign("agent", []byte("secret"), time.Now(), query, body))).To(Equal(http.StatusTooManyRequests))
+			})
+		})
+	})
+})
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"strconv"
 
 	. "github.com/onsi/ginkgo"
@@ -173,5 +174,103 @@ var _ = Describe("server", func() {
 				ItCorrectlyParsesIncomingData()
 			})
 		})
+
+		Describe("remote write", func() {
+			It("forwards ingested profiles", func() {
+				rw := new(mockRemoteWriter)
+				ts := newTestServer(&(*cfg).Server, func(c *Config) { c.RemoteWriter = rw })
+				defer ts.Close()
+
+				res, err := http.Post(ts.URL+"/ingest?name=test.app{foo=bar}", "text/plain", bytes.NewReader([]byte("foo;bar 1\n")))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(res.StatusCode).To(Equal(200))
+				Expect(rw.names).To(Equal([]string{"test.app{foo=bar}"}))
+			})
+		})
+
+		Describe("/ingest?format=jfr", func() {
+			It("rejects JFR recordings instead of parsing them as collapsed stacks", func() {
+				ts := newTestServer(&(*cfg).Server)
+				defer ts.Close()
+
+				res, err := http.Post(ts.URL+"/ingest?name=test.app&format=jfr", "application/octet-stream", bytes.NewReader([]byte("FLR\x00 1")))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(res.StatusCode).To(Equal(http.StatusUnsupportedMediaType))
+				Expect(ts.storage.GetAppNames()).To(BeEmpty())
+			})
+		})
+
+		Describe("/ingest?format=pprof", func() {
+			It("ingests pprof profiles", func() {
+				ts := newTestServer(&(*cfg).Server)
+				defer ts.Close()
+
+				b, err := os.ReadFile("../convert/testdata/cpu.pprof")
+				Expect(err).ToNot(HaveOccurred())
+				st := testing.ParseTime("2020-01-01-01:01:00")
+				et := testing.ParseTime("2020-01-01-01:01:10")
+				q := url.Values{
+					"name":   []string{"test.app{foo=bar}"},
+					"from":   []string{strconv.Itoa(int(st.Unix()))},
+					"until":  []string{strconv.Itoa(int(et.Unix()))},
+					"format": []string{"pprof"},
+				}
+				res, err := http.Post(ts.URL+"/ingest?"+q.Encode(), "application/octet-stream", bytes.NewReader(b))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(res.StatusCode).To(Equal(200))
+
+				sk, _ := segment.ParseKey("test.app.cpu{foo=bar}")
+				gOut, err := ts.storage.Get(&storage.GetInput{
+					StartTime: st,
+					EndTime:   et,
+					Key:       sk,
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(gOut.Tree).ToNot(BeNil())
+				Expect(gOut.Tree.Samples()).ToNot(BeZero())
+			})
+
+			It("rejects malformed profiles", func() {
+				ts := newTestServer(&(*cfg).Server)
+				defer ts.Close()
+
+				res, err := http.Post(ts.URL+"/ingest?name=test.app&format=pprof", "application/octet-stream", bytes.NewReader([]byte("foo;bar 1")))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(res.StatusCode).To(Equal(http.StatusUnprocessableEntity))
+			})
+		})
+
+		Describe("/ingest?format=speedscope", func() {
+			It("ingests speedscope profiles", func() {
+				ts := newTestServer(&(*cfg).Server)
+				defer ts.Close()
+
+				body := `{
+					"shared": {"frames": [{"name": "foo"}, {"name": "bar"}]},
+					"profiles": [{"type": "sampled", "samples": [[0, 1], [0]], "weights": [3, 2]}]
+				}`
+				st := testing.ParseTime("2020-01-01-01:01:00")
+				et := testing.ParseTime("2020-01-01-01:01:10")
+				q := url.Values{
+					"name":   []string{"test.app"},
+					"from":   []string{strconv.Itoa(int(st.Unix()))},
+					"until":  []string{strconv.Itoa(int(et.Unix()))},
+					"format": []string{"speedscope"},
+				}
+				res, err := http.Post(ts.URL+"/ingest?"+q.Encode(), "application/json", bytes.NewBufferString(body))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(res.StatusCode).To(Equal(200))
+
+				sk, _ := segment.ParseKey("test.app")
+				gOut, err := ts.storage.Get(&storage.GetInput{
+					StartTime: st,
+					EndTime:   et,
+					Key:       sk,
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(gOut.Tree).ToNot(BeNil())
+				Expect(gOut.Tree.String()).To(Equal("foo 2\nfoo;bar 3\n"))
+			})
+		})
 	})
 })
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ipFilter allows requests from addresses within the allowed networks,
+// or from any address if none are specified, unless the address is
+// within one of the denied networks.
+//
+// The filter relies on the remote address of the connection: requests
+// passed through a reverse proxy are filtered by the proxy address.
+type ipFilter struct {
+	allowed []*net.IPNet
+	denied  []*net.IPNet
+}
+
+// newIPFilter returns nil if neither allowed nor denied networks are
+// specified. A network may be an IP address or a CIDR block.
+func newIPFilter(allowed, denied []string) (*ipFilter, error) {
+	if len(allowed) == 0 && len(denied) == 0 {
+		return nil, nil
+	}
+	var (
+		f   ipFilter
+		err error
+	)
+	if f.allowed, err = parseNetworks(allowed); err != nil {
+		return nil, err
+	}
+	if f.denied, err = parseNetworks(denied); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+func parseNetworks(networks []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(networks))
+	for _, s := range networks {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", s, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func (f *ipFilter) allows(ip net.IP) bool {
+	if containsIP(f.denied, ip) {
+		return false
+	}
+	return len(f.allowed) == 0 || containsIP(f.allowed, ip)
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipFilterMiddleware rejects requests from addresses not allowed by the
+// filter with 403. If the filter is nil, all requests are allowed.
+func (ctrl *Controller) ipFilterMiddleware(f *ipFilter) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		if f == nil {
+			return next
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
+			}
+			if ip := net.ParseIP(host); ip == nil || !f.allows(ip) {
+				ctrl.log.WithField("remote-addr", r.RemoteAddr).
+					WithField("url", r.URL.Path).
+					Debug("request from a not allowed address")
+				ctrl.writeErrorMessage(w, http.StatusForbidden, "access from the address is not allowed")
+				return
+			}
+			next.ServeHTTP(w, r)
+		}
+	}
+}
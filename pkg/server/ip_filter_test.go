@@ -0,0 +1,80 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("IP filter", func() {
+	It("allows addresses within allowed networks, unless denied", func() {
+		f, err := newIPFilter([]string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"}, []string{"10.1.0.0/16"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.allows(net.ParseIP("10.0.0.1"))).To(BeTrue())
+		Expect(f.allows(net.ParseIP("10.1.0.1"))).To(BeFalse())
+		Expect(f.allows(net.ParseIP("192.168.1.1"))).To(BeTrue())
+		Expect(f.allows(net.ParseIP("192.168.1.2"))).To(BeFalse())
+		Expect(f.allows(net.ParseIP("fd00::1"))).To(BeTrue())
+		Expect(f.allows(net.ParseIP("::1"))).To(BeFalse())
+
+		f, err = newIPFilter(nil, []string{"127.0.0.1"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.allows(net.ParseIP("127.0.0.1"))).To(BeFalse())
+		Expect(f.allows(net.ParseIP("10.0.0.1"))).To(BeTrue())
+	})
+
+	It("rejects invalid networks", func() {
+		_, err := newIPFilter([]string{"10.0.0.0/33"}, nil)
+		Expect(err).To(HaveOccurred())
+		_, err = newIPFilter(nil, []string{"localhost"})
+		Expect(err).To(HaveOccurred())
+		f, err := newIPFilter(nil, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f).To(BeNil())
+	})
+
+	testing.WithConfig(func(cfg **config.Config) {
+		ts := withTestServer(cfg)
+
+		status := func(method, path string) int {
+			req, err := http.NewRequest(method, ts.URL+path, bytes.NewBufferString("foo;bar 1"))
+			Expect(err).ToNot(HaveOccurred())
+			res, err := http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			_ = res.Body.Close()
+			return res.StatusCode
+		}
+
+		const ingestPath = "/ingest?name=app.cpu&from=1609459200&until=1609459210"
+
+		Context("ingestion networks are limited", func() {
+			BeforeEach(func() {
+				(*cfg).Server.IngestAllowedCIDRs = []string{"10.0.0.0/8"}
+			})
+
+			It("rejects ingestion from other addresses", func() {
+				Expect(status(http.MethodPost, ingestPath)).To(Equal(http.StatusForbidden))
+				Expect(status(http.MethodGet, "/api/labels")).To(Equal(http.StatusOK))
+			})
+		})
+
+		Context("UI networks are limited", func() {
+			BeforeEach(func() {
+				(*cfg).Server.UIDeniedCIDRs = []string{"127.0.0.0/8", "::1"}
+			})
+
+			It("rejects UI and API requests from denied addresses", func() {
+				Expect(status(http.MethodGet, "/api/labels")).To(Equal(http.StatusForbidden))
+				Expect(status(http.MethodGet, "/login")).To(Equal(http.StatusForbidden))
+				Expect(status(http.MethodPost, ingestPath)).To(Equal(http.StatusOK))
+				Expect(status(http.MethodGet, "/healthz")).To(Equal(http.StatusOK))
+			})
+		})
+	})
+})
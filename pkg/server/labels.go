@@ -2,11 +2,19 @@ package server
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+
+	"github.com/pyroscope-io/pyroscope/pkg/flameql"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
 )
 
 func (ctrl *Controller) labelsHandler(w http.ResponseWriter, r *http.Request) {
-	query := r.URL.Query().Get("query")
+	query := tenantName(r.Context(), r.URL.Query().Get("query"))
+	if err := authorizeLabelsQueryString(r, query, ""); err != nil {
+		ctrl.writeInvalidParameterError(w, err)
+		return
+	}
 
 	keys := make([]string, 0)
 	if query != "" {
@@ -31,12 +39,16 @@ func (ctrl *Controller) labelsHandler(w http.ResponseWriter, r *http.Request) {
 
 func (ctrl *Controller) labelValuesHandler(w http.ResponseWriter, r *http.Request) {
 	labelName := r.URL.Query().Get("label")
-	query := r.URL.Query().Get("query")
+	query := tenantName(r.Context(), r.URL.Query().Get("query"))
 
 	if labelName == "" {
 		ctrl.writeInvalidParameterError(w, errLabelIsRequired)
 		return
 	}
+	if err := authorizeLabelsQueryString(r, query, labelName); err != nil {
+		ctrl.writeInvalidParameterError(w, err)
+		return
+	}
 
 	values := make([]string, 0)
 	if query != "" {
@@ -50,6 +62,9 @@ func (ctrl *Controller) labelValuesHandler(w http.ResponseWriter, r *http.Reques
 			return true
 		})
 	}
+	if labelName == "__name__" {
+		values = allowedApps(r.Context(), values)
+	}
 
 	b, err := json.Marshal(values)
 	if err != nil {
@@ -58,3 +73,80 @@ func (ctrl *Controller) labelValuesHandler(w http.ResponseWriter, r *http.Reques
 	}
 	_, _ = w.Write(b)
 }
+
+// apiLabelsHandler lists names of labels of the series matching the query
+// (all the applications, if not specified). If from or until parameter is
+// given, only series having data within the time range are included.
+func (ctrl *Controller) apiLabelsHandler(w http.ResponseWriter, r *http.Request) {
+	li, err := labelsInputFromRequest(r)
+	if err != nil {
+		ctrl.writeInvalidParameterError(w, err)
+		return
+	}
+	if err = authorizeLabelsQuery(r.Context(), li.Query, ""); err != nil {
+		ctrl.writeInvalidParameterError(w, err)
+		return
+	}
+	keys, err := ctrl.storage.GetLabelKeys(r.Context(), li)
+	if err != nil {
+		ctrl.writeInternalServerError(w, err, "failed to retrieve labels")
+		return
+	}
+	ctrl.writeResponseJSON(w, keys)
+}
+
+// apiLabelValuesHandler lists values of the label of the series, which
+// are selected in the same way as in apiLabelsHandler.
+func (ctrl *Controller) apiLabelValuesHandler(w http.ResponseWriter, r *http.Request) {
+	label := r.URL.Query().Get("label")
+	if label == "" {
+		ctrl.writeInvalidParameterError(w, errLabelIsRequired)
+		return
+	}
+	li, err := labelsInputFromRequest(r)
+	if err != nil {
+		ctrl.writeInvalidParameterError(w, err)
+		return
+	}
+	if err = authorizeLabelsQuery(r.Context(), li.Query, label); err != nil {
+		ctrl.writeInvalidParameterError(w, err)
+		return
+	}
+	values, err := ctrl.storage.GetLabelValues(r.Context(), li, label)
+	if err != nil {
+		ctrl.writeInternalServerError(w, err, "failed to retrieve label values")
+		return
+	}
+	if label == "__name__" {
+		values = allowedApps(r.Context(), values)
+	}
+	ctrl.writeResponseJSON(w, values)
+}
+
+func labelsInputFromRequest(r *http.Request) (*storage.LabelsInput, error) {
+	var li storage.LabelsInput
+	if q := r.URL.Query().Get("query"); q != "" {
+		qry, err := flameql.ParseQuery(tenantName(r.Context(), q))
+		if err != nil {
+			return nil, fmt.Errorf("query: %w", err)
+		}
+		li.Query = qry
+	}
+	if st, et, ok := parseRenderRangeParams(r, "from", "until"); ok {
+		li.StartTime, li.EndTime = st, et
+	}
+	return &li, nil
+}
+
+// authorizeLabelsQueryString is authorizeLabelsQuery for the legacy
+// handlers, which ignore invalid queries.
+func authorizeLabelsQueryString(r *http.Request, query, label string) error {
+	var qry *flameql.Query
+	if query != "" {
+		var err error
+		if qry, err = flameql.ParseQuery(query); err != nil {
+			return nil
+		}
+	}
+	return authorizeLabelsQuery(r.Context(), qry, label)
+}
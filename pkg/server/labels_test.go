@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("/api/labels", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		ts := withTestServer(cfg)
+
+		ingest := func(name, from, until string) {
+			q := url.Values{"name": []string{name}, "from": []string{from}, "until": []string{until}}
+			res, err := http.Post(ts.URL+"/ingest?"+q.Encode(), "text/plain", bytes.NewBufferString("main;foo 1\n"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+		}
+
+		get := func(path string, q url.Values) (int, []string) {
+			res, err := http.Get(ts.URL + path + "?" + q.Encode())
+			Expect(err).ToNot(HaveOccurred())
+			defer res.Body.Close()
+			var v []string
+			if res.StatusCode == http.StatusOK {
+				Expect(json.NewDecoder(res.Body).Decode(&v)).To(Succeed())
+			}
+			return res.StatusCode, v
+		}
+
+		It("lists labels and values of series within the time range", func() {
+			ingest("app.cpu{env=prod,pod=a}", "1609459200", "1609459210")
+			ingest("app.cpu{env=staging,region=eu}", "1609462800", "1609462810")
+			ingest("other.cpu{host=x}", "1609459200", "1609459210")
+
+			code, keys := get("/api/labels", url.Values{"query": []string{"app.cpu"}})
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(keys).To(Equal([]string{"__name__", "env", "pod", "region"}))
+
+			code, keys = get("/api/labels", url.Values{
+				"query": []string{`app.cpu{env="prod"}`},
+			})
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(keys).To(Equal([]string{"__name__", "env", "pod"}))
+
+			code, values := get("/api/label-values", url.Values{
+				"label": []string{"env"},
+				"query": []string{"app.cpu"},
+				"from":  []string{"1609462800"},
+				"until": []string{"1609466400"},
+			})
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(values).To(Equal([]string{"staging"}))
+
+			code, values = get("/api/label-values", url.Values{
+				"label": []string{"__name__"},
+				"from":  []string{"1609459200"},
+				"until": []string{"1609459210"},
+			})
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(values).To(Equal([]string{"app.cpu", "other.cpu"}))
+		})
+
+		It("validates parameters", func() {
+			code, _ := get("/api/label-values", url.Values{"query": []string{"app.cpu"}})
+			Expect(code).To(Equal(http.StatusBadRequest))
+			code, _ = get("/api/labels", url.Values{"query": []string{"app.cpu{"}})
+			Expect(code).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/flameql"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+)
+
+// requestLogFields returns the fields identifying the request in logs:
+// the remote address, the tenant, and the application the name or query
+// parameter refers to. The request body is never read.
+func requestLogFields(r *http.Request) logrus.Fields {
+	f := logrus.Fields{"remote_addr": r.RemoteAddr}
+	if t, ok := tenantFromContext(r.Context()); ok {
+		f["tenant"] = t
+	}
+	v := r.URL.Query()
+	if name := v.Get("name"); name != "" {
+		if k, err := segment.ParseKey(name); err == nil {
+			f["app"] = k.AppName()
+		}
+	} else if query := v.Get("query"); query != "" {
+		if q, err := flameql.ParseQuery(query); err == nil {
+			f["app"] = q.AppName
+		}
+	}
+	return f
+}
+
+// logRequest logs ingestion and render requests at debug level once
+// they are served, with the request fields, status code, and duration.
+func (ctrl *Controller) logRequest(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := statusWriter{ResponseWriter: w}
+		next.ServeHTTP(&sw, r)
+		ctrl.log.WithFields(requestLogFields(r)).
+			WithField("path", r.URL.Path).
+			WithField("status", sw.status()).
+			WithField("duration", time.Since(start)).
+			Debug("request served")
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// controllerMetrics complement the HTTP middleware metrics, which record
+// the number and duration of requests per route and status code.
+type controllerMetrics struct {
+	ingestRequests *prometheus.CounterVec
+}
+
+func newControllerMetrics(r prometheus.Registerer) *controllerMetrics {
+	return &controllerMetrics{
+		ingestRequests: promauto.With(r).NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_ingest_requests_total",
+			Help: "number of ingested profiles (requests, or batch entries) by format and status code",
+		}, []string{"format", "status"}),
+	}
+}
+
+// ingestFormat returns the format of the ingested profile, the way
+// ingestHandler determines it. Unknown formats are parsed as collapsed.
+func ingestFormat(r *http.Request) string {
+	switch f := r.URL.Query().Get("format"); f {
+	case "trie", "tree", "lines", "speedscope", "pprof", "jfr":
+		return f
+	}
+	contentType := r.Header.Get("Content-Type")
+	switch {
+	case contentType == "binary/octet-stream+trie":
+		return "trie"
+	case contentType == "binary/octet-stream+tree":
+		return "tree"
+	case strings.Contains(contentType, "multipart/form-data"):
+		return "pprof"
+	}
+	return "collapsed"
+}
+
+func (m *controllerMetrics) ingested(format string, status int) {
+	m.ingestRequests.WithLabelValues(format, strconv.Itoa(status)).Inc()
+}
+
+// ingestMetricsMiddleware counts ingested profiles by format and
+// the response status code.
+func (ctrl *Controller) ingestMetricsMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sw := statusWriter{ResponseWriter: w}
+		next.ServeHTTP(&sw, r)
+		ctrl.metrics.ingested(ingestFormat(r), sw.status())
+	}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.code == 0 {
+		w.code = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.code == 0 {
+		w.code = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *statusWriter) status() int {
+	if w.code == 0 {
+		return http.StatusOK
+	}
+	return w.code
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("server metrics", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		ts := withTestServer(cfg)
+
+		ingested := func(format, status string) float64 {
+			return testutil.ToFloat64(ts.controller.metrics.ingestRequests.WithLabelValues(format, status))
+		}
+
+		It("counts ingested profiles by format and status", func() {
+			res, err := http.Post(ts.URL+"/ingest?name=app.cpu", "text/plain", bytes.NewBufferString("foo;bar 1"))
+			Expect(err).ToNot(HaveOccurred())
+			res.Body.Close()
+			res, err = http.Post(ts.URL+"/ingest?name=app.cpu&format=pprof", "", bytes.NewBufferString("foo"))
+			Expect(err).ToNot(HaveOccurred())
+			res.Body.Close()
+			Expect(ingested("collapsed", "200")).To(Equal(float64(1)))
+			Expect(ingested("pprof", "422")).To(Equal(float64(1)))
+
+			By("counting batch entries")
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			for _, q := range []string{"name=app.cpu&format=lines", "name=app.cpu&format=lines"} {
+				part, err := mw.CreateFormFile(q, "profile")
+				Expect(err).ToNot(HaveOccurred())
+				_, _ = part.Write([]byte("foo;bar\n"))
+			}
+			Expect(mw.Close()).To(Succeed())
+			res, err = http.Post(ts.URL+"/ingest/batch", mw.FormDataContentType(), &body)
+			Expect(err).ToNot(HaveOccurred())
+			res.Body.Close()
+			Expect(ingested("lines", "200")).To(Equal(float64(2)))
+		})
+	})
+})
@@ -2,8 +2,11 @@ package server
 
 import (
 	"net/http"
+	"sync"
 
 	"github.com/gorilla/mux"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
 )
 
 type mockNotifier struct{}
@@ -15,3 +18,18 @@ type mockAdhocServer struct{}
 func (mockAdhocServer) AddRoutes(r *mux.Router) http.HandlerFunc {
 	return r.ServeHTTP
 }
+
+type mockRemoteWriter struct {
+	sync.Mutex
+	names []string
+}
+
+func (m *mockRemoteWriter) Write(pi *storage.PutInput) {
+	m.Lock()
+	defer m.Unlock()
+	m.names = append(m.names, pi.Key.Normalized())
+}
+
+type mockReloader func() ([]string, error)
+
+func (m mockReloader) Reload() ([]string, error) { return m() }
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/oauth2"
+)
+
+var defaultOIDCScopes = []string{"openid", "profile", "email"}
+
+const oidcDiscoveryTimeout = 10 * time.Second
+
+type oauthHandlerOIDC struct {
+	oauthBase
+	groupsClaim   string
+	allowedGroups []string
+}
+
+// oidcProviderMetadata is a subset of the OpenID provider metadata.
+type oidcProviderMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+func newOauthOIDCHandler(cfg config.OIDCOauth, baseURL string, log *logrus.Logger) (*oauthHandlerOIDC, error) {
+	m, err := discoverOIDCProvider(cfg.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	authURL, err := url.Parse(m.AuthorizationEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = defaultOIDCScopes
+	}
+	h := &oauthHandlerOIDC{
+		oauthBase: oauthBase{
+			config: &oauth2.Config{
+				ClientID:     cfg.ClientID,
+				ClientSecret: cfg.ClientSecret,
+				Scopes:       scopes,
+				Endpoint:     oauth2.Endpoint{AuthURL: m.AuthorizationEndpoint, TokenURL: m.TokenEndpoint},
+			},
+			authURL:       authURL,
+			log:           log,
+			callbackRoute: "/auth/oidc/callback",
+			redirectRoute: "/auth/oidc/redirect",
+			apiURL:        m.UserinfoEndpoint,
+			baseURL:       baseURL,
+		},
+		groupsClaim:   cfg.GroupsClaim,
+		allowedGroups: cfg.AllowedGroups,
+	}
+
+	if cfg.RedirectURL != "" {
+		h.config.RedirectURL = cfg.RedirectURL
+	}
+
+	return h, nil
+}
+
+func discoverOIDCProvider(issuerURL string) (*oidcProviderMetadata, error) {
+	if issuerURL == "" {
+		return nil, errors.New("issuer url is required")
+	}
+	issuer := strings.TrimSuffix(issuerURL, "/")
+	client := http.Client{Timeout: oidcDiscoveryTimeout}
+	resp, err := client.Get(issuer + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+
+	var m oidcProviderMetadata
+	if err = json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("failed to decode provider metadata: %w", err)
+	}
+	if strings.TrimSuffix(m.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("issuer %q does not match the configured one", m.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.UserinfoEndpoint == "" {
+		return nil, errors.New("provider metadata lacks required endpoints")
+	}
+	return &m, nil
+}
+
+// userAuth retrieves claims of the user from the user info endpoint
+// with the access token obtained in exchange for the authorization code.
+func (o oauthHandlerOIDC) userAuth(client *http.Client) (string, error) {
+	resp, err := client.Get(o.apiURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to get oauth user info: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get oauth user info: %s", resp.Status)
+	}
+
+	var claims map[string]interface{}
+	if err = json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return "", fmt.Errorf("failed to decode user info response: %w", err)
+	}
+
+	var name string
+	for _, c := range []string{"preferred_username", "email", "sub"} {
+		if v, ok := claims[c].(string); ok && v != "" {
+			name = v
+			break
+		}
+	}
+	if name == "" {
+		return "", errors.New("user info lacks user identity claims")
+	}
+
+	if len(o.allowedGroups) == 0 {
+		return name, nil
+	}
+
+	for _, allowed := range o.allowedGroups {
+		for _, member := range oidcGroups(claims[o.groupsClaim]) {
+			if member == allowed {
+				return name, nil
+			}
+		}
+	}
+
+	return "", errForbidden
+}
+
+// oidcGroups returns the groups listed in the claim, which may be
+// either an array or a single string.
+func oidcGroups(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		groups := make([]string, 0, len(v))
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+		return groups
+	default:
+		return nil
+	}
+}
+
+func (o oauthHandlerOIDC) getOauthBase() oauthBase {
+	return o.oauthBase
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("OIDC login", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		var (
+			idp      *httptest.Server
+			userInfo map[string]interface{}
+		)
+
+		BeforeEach(func() {
+			userInfo = map[string]interface{}{"sub": "1", "preferred_username": "alice", "groups": []string{"dev"}}
+			m := http.NewServeMux()
+			m.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewEncoder(w).Encode(oidcProviderMetadata{
+					Issuer:                idp.URL,
+					AuthorizationEndpoint: idp.URL + "/authorize",
+					TokenEndpoint:         idp.URL + "/token",
+					UserinfoEndpoint:      idp.URL + "/userinfo",
+				})
+			})
+			m.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.FormValue("code")).To(Equal("code"))
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"access_token":"token","token_type":"Bearer"}`))
+			})
+			m.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+				Expect(r.Header.Get("Authorization")).To(Equal("Bearer token"))
+				_ = json.NewEncoder(w).Encode(userInfo)
+			})
+			idp = httptest.NewServer(m)
+
+			(*cfg).Server.Auth.JWTSecret = "secret"
+			(*cfg).Server.Auth.OIDC.Enabled = true
+			(*cfg).Server.Auth.OIDC.IssuerURL = idp.URL
+			(*cfg).Server.Auth.OIDC.ClientID = "pyroscope"
+			(*cfg).Server.Auth.OIDC.GroupsClaim = "groups"
+			(*cfg).Server.Auth.OIDC.AllowedGroups = []string{"dev", "ops"}
+		})
+
+		ts := withTestServer(cfg)
+
+		AfterEach(func() {
+			idp.Close()
+		})
+
+		client := &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+
+		cookie := func(res *http.Response, name string) *http.Cookie {
+			for _, c := range res.Cookies() {
+				if c.Name == name && c.Value != "" {
+					return c
+				}
+			}
+			return nil
+		}
+
+		// login follows the authorization code flow, returning
+		// the session cookie, if issued.
+		login := func() *http.Cookie {
+			res, err := client.Get(ts.URL + "/auth/oidc/login")
+			Expect(err).ToNot(HaveOccurred())
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusTemporaryRedirect))
+			u, err := url.Parse(res.Header.Get("Location"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(u.Path).To(Equal("/authorize"))
+			Expect(u.Query().Get("scope")).To(Equal("openid profile email"))
+			state := cookie(res, stateCookieName)
+			Expect(state).ToNot(BeNil())
+			Expect(u.Query().Get("state")).To(Equal(state.Value))
+
+			q := url.Values{"code": []string{"code"}, "state": []string{state.Value}}
+			req, _ := http.NewRequest(http.MethodGet, ts.URL+"/auth/oidc/redirect?"+q.Encode(), nil)
+			req.AddCookie(state)
+			res, err = client.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			res.Body.Close()
+			return cookie(res, jwtCookieName)
+		}
+
+		render := func(c *http.Cookie) int {
+			req, _ := http.NewRequest(http.MethodGet, ts.URL+"/render?query=app.cpu&format=json", nil)
+			if c != nil {
+				req.AddCookie(c)
+			}
+			res, err := client.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			res.Body.Close()
+			return res.StatusCode
+		}
+
+		It("issues a session cookie to users of allowed groups", func() {
+			Expect(render(nil)).To(Equal(http.StatusTemporaryRedirect))
+			c := login()
+			Expect(c).ToNot(BeNil())
+			Expect(render(c)).To(Equal(http.StatusOK))
+		})
+
+		It("rejects users not in allowed groups", func() {
+			userInfo["groups"] = "guests"
+			Expect(login()).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/relabel"
+)
+
+// ConfigReloader reloads the server configuration without a restart.
+type ConfigReloader interface {
+	// Reload applies the settings that can be changed at runtime,
+	// and returns descriptions of the changes applied.
+	Reload() ([]string, error)
+}
+
+// ApplyConfig replaces the ingestion rate limits and relabeling rules
+// with the ones of the configuration. Per-application and per-key rate
+// limiters are only reset, if the limits have changed. The configuration is reported
+// by /config afterwards, and must not be modified.
+func (ctrl *Controller) ApplyConfig(c *config.Server) {
+	ctrl.reloadMutex.Lock()
+	defer ctrl.reloadMutex.Unlock()
+	l := newIngestLimiter(c.IngestRateLimit, c.IngestRateBurst)
+	if !ctrl.ingestLimiter.sameLimits(l) {
+		ctrl.ingestLimiter = l
+	}
+	kl := newIngestLimiter(c.IngestKeyRateLimit, c.IngestKeyRateBurst)
+	if !ctrl.ingestKeyLimiter.sameLimits(kl) {
+		ctrl.ingestKeyLimiter = kl
+	}
+	ctrl.relabelConfigs = c.IngestRelabelConfigs
+	ctrl.reloadedConfig = c
+}
+
+func (ctrl *Controller) currentConfig() *config.Server {
+	ctrl.reloadMutex.RLock()
+	defer ctrl.reloadMutex.RUnlock()
+	return ctrl.reloadedConfig
+}
+
+func (ctrl *Controller) currentRelabelConfigs() []*relabel.Config {
+	ctrl.reloadMutex.RLock()
+	defer ctrl.reloadMutex.RUnlock()
+	return ctrl.relabelConfigs
+}
+
+// reloadHandler reloads the configuration and responds with
+// the list of the changes applied: POST /-/reload
+func (ctrl *Controller) reloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ctrl.writeInvalidMethodError(w)
+		return
+	}
+	changes, err := ctrl.reloader.Reload()
+	if err != nil {
+		ctrl.writeError(w, http.StatusBadRequest, err, "failed to reload configuration")
+		return
+	}
+	if changes == nil {
+		changes = []string{}
+	}
+	ctrl.writeResponseJSON(w, changes)
+}
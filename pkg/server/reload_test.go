@@ -0,0 +1,64 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/model"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/relabel"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("config reload", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		// The reloader applies the configuration to the controller served.
+		var ts *testServer
+		ts = withTestServer(cfg, func(c *Config) {
+			c.Reloader = mockReloader(func() ([]string, error) {
+				ts.controller.ApplyConfig(&config.Server{
+					IngestRateLimit:      1,
+					IngestRelabelConfigs: []*relabel.Config{{Action: relabel.Drop, SourceLabels: model.LabelNames{"env"}, Regex: relabel.MustNewRegexp("dev")}},
+				})
+				return []string{"ingest-rate-limit: 0 -> 1"}, nil
+			})
+		})
+
+		ingest := func(name string) int {
+			q := url.Values{"name": []string{name}, "from": []string{"1609459200"}, "until": []string{"1609459210"}}
+			res, err := http.Post(ts.URL+"/ingest?"+q.Encode(), "text/plain", bytes.NewBufferString("main;foo 1\n"))
+			Expect(err).ToNot(HaveOccurred())
+			res.Body.Close()
+			return res.StatusCode
+		}
+
+		It("applies rate limits and relabeling rules", func() {
+			Expect(ingest("app.cpu{env=dev}")).To(Equal(http.StatusOK))
+			Expect(ingest("app.cpu{env=dev}")).To(Equal(http.StatusOK))
+			Expect(ts.storage.GetAppNames()).To(ConsistOf("app.cpu"))
+
+			res, err := http.Get(ts.URL + "/-/reload")
+			Expect(err).ToNot(HaveOccurred())
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusMethodNotAllowed))
+
+			res, err = http.Post(ts.URL+"/-/reload", "", nil)
+			Expect(err).ToNot(HaveOccurred())
+			var changes []string
+			Expect(json.NewDecoder(res.Body).Decode(&changes)).To(Succeed())
+			res.Body.Close()
+			Expect(changes).To(Equal([]string{"ingest-rate-limit: 0 -> 1"}))
+			Expect(ts.controller.currentConfig().IngestRateLimit).To(BeEquivalentTo(1))
+			Expect((*cfg).Server.IngestRateLimit).To(BeZero())
+
+			Expect(ingest("other.cpu{env=dev}")).To(Equal(http.StatusOK))
+			Expect(ingest("other.cpu{env=dev}")).To(Equal(http.StatusTooManyRequests))
+			Expect(ts.storage.GetAppNames()).To(ConsistOf("app.cpu"))
+		})
+	})
+})
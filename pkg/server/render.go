@@ -1,12 +1,16 @@
 package server
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"regexp"
 	"runtime/debug"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -26,13 +30,30 @@ var (
 	errLabelIsRequired       = errors.New("label parameter is required")
 	errNoData                = errors.New("no data")
 	errTimeParamsAreRequired = errors.New("leftFrom,leftUntil,rightFrom,rightUntil are required")
+	errGroupByFormat         = errors.New("groupBy is only supported for json format")
+	errDiffCallGraphFormat   = errors.New("diff can't be rendered as a call graph")
 )
 
+// defaultCallGraphNodes is the default number of nodes of call graphs.
+const defaultCallGraphNodes = 80
+
 type renderParams struct {
 	format   string
 	maxNodes int
+	width    int
+	groupBy  string
 	gi       *storage.GetInput
 
+	normalization diffNormalization
+	// focus and hide filter frames of the tree, see tree.Filter.
+	focus *regexp.Regexp
+	hide  *regexp.Regexp
+	// maxDepth limits the depth of JSON flamegraphs, 0 if unlimited.
+	maxDepth int
+	// deobfuscate enables mapping of frame names with the symbol
+	// mapping configured for the application.
+	deobfuscate bool
+
 	leftStartTime time.Time
 	leftEndTime   time.Time
 	rghtStartTime time.Time
@@ -64,6 +85,11 @@ func (ctrl *Controller) renderHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if p.groupBy != "" {
+		ctrl.renderGrouped(w, r, &p)
+		return
+	}
+
 	out, err := ctrl.storage.Get(p.gi)
 	var appName string
 	if p.gi.Key != nil {
@@ -81,16 +107,26 @@ func (ctrl *Controller) renderHandler(w http.ResponseWriter, r *http.Request) {
 	if out == nil {
 		out = &storage.GetOutput{Tree: tree.New()}
 	}
+	if ctrl.isFederated(r) {
+		ctrl.federateRender(r, &p, out)
+	}
+	out.Tree = p.filterTree(ctrl.deobfuscateTree(&p, appName, out.Tree))
 
 	switch p.format {
 	case "json":
+		if p.maxDepth > 0 {
+			out.Tree = out.Tree.Prune(p.maxDepth)
+		}
 		flame := flamebearer.NewProfile(out, p.maxNodes)
 		res := ctrl.mountRenderResponse(flame, appName, p.gi, p.maxNodes)
 		ctrl.writeResponseJSON(w, res)
 	case "pprof":
 		pprof := out.Tree.Pprof(&tree.PprofMetadata{
-			Unit:      out.Units,
-			StartTime: p.gi.StartTime,
+			Type:       profileType(appName),
+			Unit:       out.Units,
+			StartTime:  p.gi.StartTime,
+			Duration:   p.gi.EndTime.Sub(p.gi.StartTime),
+			SampleRate: out.SampleRate,
 		})
 		out, err := proto.Marshal(pprof)
 		if err == nil {
@@ -108,9 +144,92 @@ func (ctrl *Controller) renderHandler(w http.ResponseWriter, r *http.Request) {
 			ctrl.writeJSONEncodeError(w, err)
 			return
 		}
+	case "dot", "callgraph":
+		// Unlike flamegraphs, call graphs with many nodes are hard
+		// to read, hence the smaller default.
+		maxNodes := p.maxNodes
+		if r.URL.Query().Get("max-nodes") == "" {
+			maxNodes = defaultCallGraphNodes
+		}
+		g := out.Tree.CallGraph(maxNodes)
+		if p.format == "callgraph" {
+			ctrl.writeResponseJSON(w, g)
+			return
+		}
+		var buf bytes.Buffer
+		if err := g.WriteDot(&buf, appName); err != nil {
+			ctrl.writeInternalServerError(w, err, "failed to render call graph")
+			return
+		}
+		ctrl.writeResponseFile(w, fmt.Sprintf("%v.dot", filename), buf.Bytes())
+	case "svg", "png":
+		res := flamebearer.NewProfile(out, p.maxNodes)
+		var buf bytes.Buffer
+		render, contentType := flamebearer.FlamebearerToSVG, "image/svg+xml"
+		if p.format == "png" {
+			render, contentType = flamebearer.FlamebearerToPNG, "image/png"
+		}
+		if err := render(&res, p.width, &buf); err != nil {
+			if errors.Is(err, flamebearer.ErrImageTooLarge) {
+				ctrl.writeInvalidParameterError(w, err)
+				return
+			}
+			ctrl.writeInternalServerError(w, err, "failed to render image")
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", filename+"."+p.format))
+		_, _ = w.Write(buf.Bytes())
 	}
 }
 
+type renderGroupedResponse struct {
+	GroupBy string                    `json:"groupBy"`
+	Groups  map[string]RenderResponse `json:"groups"`
+}
+
+// renderGrouped writes flamegraphs of every value of the groupBy label.
+func (ctrl *Controller) renderGrouped(w http.ResponseWriter, r *http.Request, p *renderParams) {
+	if p.format != "" && p.format != "json" {
+		ctrl.writeInvalidParameterError(w, errGroupByFormat)
+		return
+	}
+	groups, err := ctrl.storage.GetGroupedContext(r.Context(), p.gi, p.groupBy)
+	ctrl.statsInc("render")
+	if err != nil {
+		ctrl.writeInternalServerError(w, err, "failed to retrieve data")
+		return
+	}
+	var appName string
+	if p.gi.Key != nil {
+		appName = p.gi.Key.AppName()
+	} else if p.gi.Query != nil {
+		appName = p.gi.Query.AppName
+	}
+	res := renderGroupedResponse{
+		GroupBy: p.groupBy,
+		Groups:  make(map[string]RenderResponse, len(groups)),
+	}
+	for v, out := range groups {
+		out.Tree = p.filterTree(ctrl.deobfuscateTree(p, appName, out.Tree))
+		if p.maxDepth > 0 {
+			out.Tree = out.Tree.Prune(p.maxDepth)
+		}
+		flame := flamebearer.NewProfile(out, p.maxNodes)
+		res.Groups[v] = ctrl.mountRenderResponse(flame, appName, p.gi, p.maxNodes)
+	}
+	ctrl.writeResponseJSON(w, res)
+}
+
+// profileType returns the profile type an application name ends with,
+// e.g. "cpu" or "alloc_space".
+func profileType(appName string) string {
+	if i := strings.LastIndexByte(appName, '.'); i >= 0 {
+		return appName[i+1:]
+	}
+	return appName
+}
+
 // Enhance the flamebearer with a few additional fields the UI requires
 func (*Controller) mountRenderResponse(flame flamebearer.FlamebearerProfile, appName string, gi *storage.GetInput, maxNodes int) RenderResponse {
 	metadata := renderMetadataResponse{
@@ -163,6 +282,15 @@ func (ctrl *Controller) renderDiffHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	switch p.format {
+	case "svg", "png":
+		ctrl.writeInvalidParameterError(w, flamebearer.ErrDiffImageFormat)
+		return
+	case "dot", "callgraph":
+		ctrl.writeInvalidParameterError(w, errDiffCallGraphFormat)
+		return
+	}
+
 	leftStartTime, leftEndTime, leftOK := parseRenderRangeParams(r, leftStartParam, leftEndParam)
 	rghtStartTime, rghtEndTime, rghtOK := parseRenderRangeParams(r, rghtStartParam, rghtEndParam)
 	if !leftOK || !rghtOK {
@@ -181,6 +309,9 @@ func (ctrl *Controller) renderDiffHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	leftOut.Tree, rghtOut.Tree = normalizeDiffTrees(p.normalization,
+		leftOut.Tree, rghtOut.Tree,
+		leftEndTime.Sub(leftStartTime), rghtEndTime.Sub(rghtStartTime))
 	combined := flamebearer.NewCombinedProfile(out, leftOut, rghtOut, p.maxNodes)
 
 	switch p.format {
@@ -206,12 +337,115 @@ func (ctrl *Controller) renderDiffHandler(w http.ResponseWriter, r *http.Request
 	}
 }
 
+// maxMultiDiffRanges limits the number of time ranges compared at once.
+const maxMultiDiffRanges = 16
+
+type RenderMultiDiffParams struct {
+	Name  *string `json:"name,omitempty"`
+	Query *string `json:"query,omitempty"`
+
+	MaxNodes *int `json:"maxNodes,omitempty"`
+
+	Ranges []RenderTreeParams `json:"ranges"`
+}
+
+type renderMultiDiffResponse struct {
+	*tree.MultiDiff
+	Metadata renderMultiDiffMetadata `json:"metadata"`
+}
+
+type renderMultiDiffMetadata struct {
+	SpyName    string `json:"spyName"`
+	SampleRate uint32 `json:"sampleRate"`
+	Units      string `json:"units"`
+	AppName    string `json:"appName"`
+	MaxNodes   int    `json:"maxNodes"`
+}
+
+// renderMultiDiffHandler compares profiles of several time ranges, e.g.
+// the same hour after each of the last deployments, and returns values of
+// every node in each of the ranges.
+func (ctrl *Controller) renderMultiDiffHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		ctrl.writeInvalidMethodError(w)
+		return
+	}
+	var rP RenderMultiDiffParams
+	if err := json.NewDecoder(r.Body).Decode(&rP); err != nil {
+		ctrl.writeInvalidParameterError(w, err)
+		return
+	}
+	if len(rP.Ranges) < 2 || len(rP.Ranges) > maxMultiDiffRanges {
+		ctrl.writeInvalidParameterError(w, fmt.Errorf("ranges: from 2 to %d time ranges are required", maxMultiDiffRanges))
+		return
+	}
+	var p renderParams
+	if err := ctrl.renderParametersFromBodyFields(r.Context(), &p, rP.Name, rP.Query, rP.MaxNodes); err != nil {
+		ctrl.writeInvalidParameterError(w, err)
+		return
+	}
+	if err := authorizeRenderParams(r, &p); err != nil {
+		ctrl.writeInvalidParameterError(w, err)
+		return
+	}
+	// The query is audited with the time range spanning all the ranges.
+	audited := *p.gi
+	for _, tr := range rP.Ranges {
+		st, et := attime.Parse(tr.From), attime.Parse(tr.Until)
+		if audited.StartTime.IsZero() || st.Before(audited.StartTime) {
+			audited.StartTime = st
+		}
+		if et.After(audited.EndTime) {
+			audited.EndTime = et
+		}
+	}
+	ctrl.auditQuery(r, &audited)
+	ctrl.countQuery(p.gi)
+
+	outs := make([]*storage.GetOutput, len(rP.Ranges))
+	errs := make([]error, len(rP.Ranges))
+	var wg sync.WaitGroup
+	for i, tr := range rP.Ranges {
+		wg.Add(1)
+		go func(i int, st, et time.Time) {
+			defer wg.Done()
+			outs[i], errs[i] = ctrl.loadTree(p.gi, st, et)
+		}(i, attime.Parse(tr.From), attime.Parse(tr.Until))
+	}
+	wg.Wait()
+	ctrl.statsInc("render-diff-multi")
+	for _, err := range errs {
+		if err != nil {
+			ctrl.writeInternalServerError(w, err, "failed to retrieve data")
+			return
+		}
+	}
+
+	trees := make([]*tree.Tree, len(outs))
+	res := renderMultiDiffResponse{Metadata: renderMultiDiffMetadata{MaxNodes: p.maxNodes}}
+	for i, out := range outs {
+		trees[i] = out.Tree
+		if out.Units != "" {
+			res.Metadata.SpyName = out.SpyName
+			res.Metadata.SampleRate = out.SampleRate
+			res.Metadata.Units = out.Units
+		}
+	}
+	if p.gi.Key != nil {
+		res.Metadata.AppName = p.gi.Key.AppName()
+	} else if p.gi.Query != nil {
+		res.Metadata.AppName = p.gi.Query.AppName
+	}
+	res.MultiDiff = tree.CombineTrees(trees, p.maxNodes)
+	ctrl.writeResponseJSON(w, res)
+}
+
 func (ctrl *Controller) renderParametersFromRequest(r *http.Request, p *renderParams) error {
 	v := r.URL.Query()
 	p.gi = new(storage.GetInput)
 
-	k := v.Get("name")
-	q := v.Get("query")
+	k := tenantName(r.Context(), v.Get("name"))
+	q := tenantName(r.Context(), v.Get("query"))
 
 	switch {
 	case k == "" && q == "":
@@ -235,11 +469,57 @@ func (ctrl *Controller) renderParametersFromRequest(r *http.Request, p *renderPa
 		p.maxNodes = mn
 	}
 
+	p.width = flamebearer.DefaultImageWidth
+	if width := v.Get("width"); width != "" {
+		n, err := strconv.Atoi(width)
+		if err != nil || n <= 0 || n > flamebearer.MaxImageWidth {
+			return fmt.Errorf("width: must be a positive number not exceeding %d", flamebearer.MaxImageWidth)
+		}
+		p.width = n
+	}
+
+	if s := v.Get("max-depth"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return fmt.Errorf("max-depth: must be a non-negative number")
+		}
+		p.maxDepth = n
+	}
+
+	p.groupBy = v.Get("groupBy")
+	var err error
+	if p.gi.Aggregation, err = storage.ParseAggregation(v.Get("aggregation")); err != nil {
+		return fmt.Errorf("aggregation: %w", err)
+	}
+
+	if p.normalization, err = parseDiffNormalization(v.Get("normalization")); err != nil {
+		return fmt.Errorf("normalization: %w", err)
+	}
+	if s := v.Get("deobfuscate"); s != "" {
+		if p.deobfuscate, err = strconv.ParseBool(s); err != nil {
+			return fmt.Errorf("deobfuscate: %w", err)
+		}
+	}
+	if p.focus, err = parseFrameRegexp(v.Get("focus")); err != nil {
+		return fmt.Errorf("focus: %w", err)
+	}
+	if p.hide, err = parseFrameRegexp(v.Get("hide")); err != nil {
+		return fmt.Errorf("hide: %w", err)
+	}
+
 	p.gi.StartTime = attime.Parse(v.Get("from"))
 	p.gi.EndTime = attime.Parse(v.Get("until"))
 	p.format = v.Get("format")
 
-	return ctrl.expectFormats(p.format)
+	if err = authorizeRenderParams(r, p); err != nil {
+		return err
+	}
+	if err = ctrl.expectFormats(p.format); err != nil {
+		return err
+	}
+	ctrl.auditQuery(r, p.gi)
+	ctrl.countQuery(p.gi)
+	return nil
 }
 
 func (ctrl *Controller) renderParametersFromRequestBody(r *http.Request, p *renderParams, rP *RenderDiffParams) error {
@@ -248,18 +528,43 @@ func (ctrl *Controller) renderParametersFromRequestBody(r *http.Request, p *rend
 		return err
 	}
 
+	if err := ctrl.renderParametersFromBodyFields(r.Context(), p, rP.Name, rP.Query, rP.MaxNodes); err != nil {
+		return err
+	}
+
+	var err error
+	if p.normalization, err = parseDiffNormalization(rP.Normalization); err != nil {
+		return fmt.Errorf("normalization: %w", err)
+	}
+
+	p.gi.StartTime = attime.Parse(rP.From)
+	p.gi.EndTime = attime.Parse(rP.Until)
+	p.format = rP.Format
+
+	if err = authorizeRenderParams(r, p); err != nil {
+		return err
+	}
+	if err = ctrl.expectFormats(p.format); err != nil {
+		return err
+	}
+	ctrl.auditQuery(r, p.gi)
+	ctrl.countQuery(p.gi)
+	return nil
+}
+
+func (ctrl *Controller) renderParametersFromBodyFields(ctx context.Context, p *renderParams, name, query *string, maxNodes *int) error {
 	p.gi = new(storage.GetInput)
 	switch {
-	case rP.Name == nil && rP.Query == nil:
+	case name == nil && query == nil:
 		return fmt.Errorf("'query' or 'name' parameter is required")
-	case rP.Name != nil:
-		sk, err := segment.ParseKey(*rP.Name)
+	case name != nil:
+		sk, err := segment.ParseKey(tenantName(ctx, *name))
 		if err != nil {
 			return fmt.Errorf("name: parsing storage key: %w", err)
 		}
 		p.gi.Key = sk
-	case rP.Query != nil:
-		qry, err := flameql.ParseQuery(*rP.Query)
+	case query != nil:
+		qry, err := flameql.ParseQuery(tenantName(ctx, *query))
 		if err != nil {
 			return fmt.Errorf("query: %w", err)
 		}
@@ -267,15 +572,47 @@ func (ctrl *Controller) renderParametersFromRequestBody(r *http.Request, p *rend
 	}
 
 	p.maxNodes = ctrl.config.MaxNodesRender
-	if rP.MaxNodes != nil && *rP.MaxNodes > 0 {
-		p.maxNodes = *rP.MaxNodes
+	if maxNodes != nil && *maxNodes > 0 {
+		p.maxNodes = *maxNodes
 	}
+	return nil
+}
 
-	p.gi.StartTime = attime.Parse(rP.From)
-	p.gi.EndTime = attime.Parse(rP.Until)
-	p.format = rP.Format
+// authorizeRenderParams checks whether the request is allowed to access
+// the application specified with the name or query parameter.
+func authorizeRenderParams(r *http.Request, p *renderParams) error {
+	if p.gi.Key != nil {
+		return authorizeApp(r.Context(), p.gi.Key.AppName())
+	}
+	return authorizeApp(r.Context(), p.gi.Query.AppName)
+}
 
-	return ctrl.expectFormats(p.format)
+func parseFrameRegexp(s string) (*regexp.Regexp, error) {
+	if s == "" {
+		return nil, nil
+	}
+	return regexp.Compile(s)
+}
+
+// deobfuscateTree maps frame names of the tree, if requested and the
+// application has a symbol mapping. Frames are mapped before filtering,
+// so that focus and hide expressions match the original names.
+func (ctrl *Controller) deobfuscateTree(p *renderParams, appName string, t *tree.Tree) *tree.Tree {
+	if !p.deobfuscate {
+		return t
+	}
+	m, ok := ctrl.symbolMappers.Mapper(appName)
+	if !ok {
+		return t
+	}
+	return t.MapNames(m.MapName)
+}
+
+func (p *renderParams) filterTree(t *tree.Tree) *tree.Tree {
+	if p.focus == nil && p.hide == nil {
+		return t
+	}
+	return t.Filter(p.focus, p.hide)
 }
 
 func parseRenderRangeParams(r *http.Request, from, until string) (startTime, endTime time.Time, ok bool) {
@@ -349,6 +686,8 @@ type RenderDiffParams struct {
 
 	Format   string `json:"format"`
 	MaxNodes *int   `json:"maxNodes,omitempty"`
+	// Normalization is one of none (default), rate, or percent.
+	Normalization string `json:"normalization,omitempty"`
 
 	Left  RenderTreeParams `json:"leftParams"`
 	Right RenderTreeParams `json:"rightParams"`
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("/render-diff-multi", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		ts := withTestServer(cfg)
+
+		ingest := func(from, until, data string) {
+			q := url.Values{"name": []string{"app.cpu"}, "from": []string{from}, "until": []string{until}}
+			res, err := http.Post(ts.URL+"/ingest?"+q.Encode(), "text/plain", bytes.NewBufferString(data))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+		}
+
+		diff := func(p RenderMultiDiffParams) (int, renderMultiDiffResponse) {
+			b, err := json.Marshal(p)
+			Expect(err).ToNot(HaveOccurred())
+			res, err := http.Post(ts.URL+"/render-diff-multi", "application/json", bytes.NewReader(b))
+			Expect(err).ToNot(HaveOccurred())
+			defer res.Body.Close()
+			var d renderMultiDiffResponse
+			if res.StatusCode == http.StatusOK {
+				Expect(json.NewDecoder(res.Body).Decode(&d)).To(Succeed())
+			}
+			return res.StatusCode, d
+		}
+
+		It("compares several time ranges", func() {
+			ingest("1609459200", "1609459210", "main;foo 1\nmain;bar 3\n")
+			ingest("1609459300", "1609459310", "main;foo 5\n")
+			ingest("1609459400", "1609459410", "main;bar 2\n")
+
+			query := "app.cpu"
+			code, d := diff(RenderMultiDiffParams{
+				Query: &query,
+				Ranges: []RenderTreeParams{
+					{From: "1609459200", Until: "1609459210"},
+					{From: "1609459300", Until: "1609459310"},
+					{From: "1609459400", Until: "1609459410"},
+				},
+			})
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(d.Metadata.AppName).To(Equal("app.cpu"))
+			Expect(d.Ticks).To(Equal([]uint64{4, 5, 2}))
+			Expect(d.Nodes).To(HaveLen(4))
+			Expect(d.Names[d.Nodes[2].Name]).To(Equal("bar"))
+			Expect(d.Nodes[2].Parent).To(Equal(1))
+			Expect(d.Nodes[2].Self).To(Equal([]uint64{3, 0, 2}))
+			Expect(d.Names[d.Nodes[3].Name]).To(Equal("foo"))
+			Expect(d.Nodes[3].Self).To(Equal([]uint64{1, 5, 0}))
+		})
+
+		It("validates parameters", func() {
+			query := "app.cpu"
+			code, _ := diff(RenderMultiDiffParams{
+				Query:  &query,
+				Ranges: []RenderTreeParams{{From: "now-1h", Until: "now"}},
+			})
+			Expect(code).To(Equal(http.StatusBadRequest))
+			code, _ = diff(RenderMultiDiffParams{
+				Ranges: []RenderTreeParams{{From: "now-1h", Until: "now"}, {From: "now-2h", Until: "now-1h"}},
+			})
+			Expect(code).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/structs/flamebearer"
+)
+
+// defaultExpandDepth is the default depth of subtrees returned by
+// /render/expand.
+const defaultExpandDepth = 10
+
+var errNodeIsRequired = errors.New("node parameter is required")
+
+// renderExpandHandler returns the flamegraph of a subtree of the profile:
+// huge flamegraphs can be rendered with the max-depth parameter first,
+// and then explored incrementally. The node parameter is the call stack
+// of the root of the subtree, frames are separated with semicolons, e.g.
+// "main;handler". The subtree depth is limited with max-depth as well.
+func (ctrl *Controller) renderExpandHandler(w http.ResponseWriter, r *http.Request) {
+	var p renderParams
+	if err := ctrl.renderParametersFromRequest(r, &p); err != nil {
+		ctrl.writeInvalidParameterError(w, err)
+		return
+	}
+	node := r.URL.Query().Get("node")
+	if node == "" {
+		ctrl.writeInvalidParameterError(w, errNodeIsRequired)
+		return
+	}
+	if p.maxDepth == 0 {
+		p.maxDepth = defaultExpandDepth
+	}
+
+	out, err := ctrl.storage.GetContext(r.Context(), p.gi)
+	ctrl.statsInc("render-expand")
+	if err != nil {
+		ctrl.writeInternalServerError(w, err, "failed to retrieve data")
+		return
+	}
+	if out == nil {
+		out = &storage.GetOutput{Tree: tree.New()}
+	}
+
+	var appName string
+	if p.gi.Key != nil {
+		appName = p.gi.Key.AppName()
+	} else if p.gi.Query != nil {
+		appName = p.gi.Query.AppName
+	}
+	out.Tree = p.filterTree(ctrl.deobfuscateTree(&p, appName, out.Tree)).Subtree(strings.Split(node, ";"), p.maxDepth)
+	flame := flamebearer.NewProfile(out, p.maxNodes)
+	ctrl.writeResponseJSON(w, ctrl.mountRenderResponse(flame, appName, p.gi, p.maxNodes))
+}
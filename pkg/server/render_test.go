@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"image/png"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 
 	"github.com/golang/protobuf/proto"
 	. "github.com/onsi/ginkgo"
@@ -127,6 +129,7 @@ var _ = Describe("server", func() {
 				profile := &tree.Profile{}
 				err = proto.Unmarshal(body, profile)
 				Expect(err).ToNot(HaveOccurred())
+				Expect(profile.StringTable[profile.SampleType[0].Type]).To(Equal("app"))
 			})
 			It("supports collapsed format", func() {
 				defer httpServer.Close()
@@ -138,6 +141,154 @@ var _ = Describe("server", func() {
 					"^attachment; filename.+\\.collapsed.txt$",
 				))
 			})
+			It("supports groupBy and aggregation parameters", func() {
+				defer httpServer.Close()
+
+				resp, err := http.Get(fmt.Sprintf("%s/render?query=%s&groupBy=pod&aggregation=avg", httpServer.URL, url.QueryEscape(`app{foo="bar"}`)))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				var res renderGroupedResponse
+				Expect(json.NewDecoder(resp.Body).Decode(&res)).To(Succeed())
+				Expect(res.GroupBy).To(Equal("pod"))
+
+				resp, err = http.Get(fmt.Sprintf("%s/render?query=%s&groupBy=pod&format=svg", httpServer.URL, url.QueryEscape(`app{foo="bar"}`)))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+
+				resp, err = http.Get(fmt.Sprintf("%s/render?query=%s&aggregation=max", httpServer.URL, url.QueryEscape(`app{foo="bar"}`)))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			})
+			It("supports svg and png formats", func() {
+				defer httpServer.Close()
+
+				resp, err := http.Get(fmt.Sprintf("%s/render?query=%s&format=%s", httpServer.URL, url.QueryEscape(`app{foo="bar"}`), "svg"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.Header.Get("Content-Type")).To(Equal("image/svg+xml"))
+				body, _ := io.ReadAll(resp.Body)
+				Expect(string(body)).To(ContainSubstring("<svg"))
+
+				resp, err = http.Get(fmt.Sprintf("%s/render?query=%s&format=%s&width=300", httpServer.URL, url.QueryEscape(`app{foo="bar"}`), "png"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				Expect(resp.Header.Get("Content-Type")).To(Equal("image/png"))
+				img, err := png.Decode(resp.Body)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(img.Bounds().Dx()).To(Equal(300))
+
+				resp, err = http.Get(fmt.Sprintf("%s/render?query=%s&format=%s&width=-1", httpServer.URL, url.QueryEscape(`app{foo="bar"}`), "png"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			})
+			It("rejects images exceeding the size limit", func() {
+				defer httpServer.Close()
+
+				stack := strings.TrimSuffix(strings.Repeat("frame;", 1000), ";")
+				resp, err := http.Post(httpServer.URL+"/ingest?name=app.cpu&from=1609459200&until=1609459210", "text/plain",
+					bytes.NewBufferString(stack+" 1\n"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				q := url.Values{
+					"query":  []string{"app.cpu"},
+					"from":   []string{"1609459200"},
+					"until":  []string{"1609459210"},
+					"format": []string{"png"},
+					"width":  []string{"16384"},
+				}
+				resp, err = http.Get(httpServer.URL + "/render?" + q.Encode())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+
+				q.Set("width", "300")
+				resp, err = http.Get(httpServer.URL + "/render?" + q.Encode())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			})
+			It("supports focus and hide parameters", func() {
+				defer httpServer.Close()
+
+				resp, err := http.Post(httpServer.URL+"/ingest?name=app.cpu&from=1609459200&until=1609459210", "text/plain",
+					bytes.NewBufferString("main;handler;json.Marshal 2\nmain;runtime.gc 1\nmain;worker 8\n"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				q := url.Values{
+					"query":  []string{"app.cpu"},
+					"from":   []string{"1609459200"},
+					"until":  []string{"1609459210"},
+					"format": []string{"collapsed"},
+					"focus":  []string{"^(handler|runtime\\..*)$"},
+					"hide":   []string{"^handler$"},
+				}
+				resp, err = http.Get(httpServer.URL + "/render?" + q.Encode())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				body, _ := io.ReadAll(resp.Body)
+				Expect(string(body)).To(Equal("main;json.Marshal 2\nmain;runtime.gc 1\n"))
+
+				q.Set("focus", "(")
+				resp, err = http.Get(httpServer.URL + "/render?" + q.Encode())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			})
+			It("supports depth-limited rendering and expanding", func() {
+				defer httpServer.Close()
+
+				resp, err := http.Post(httpServer.URL+"/ingest?name=app.cpu&from=1609459200&until=1609459210", "text/plain",
+					bytes.NewBufferString("main;handler;json.Marshal;reflect 2\nmain;worker 8\n"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+				q := url.Values{
+					"query":     []string{"app.cpu"},
+					"from":      []string{"1609459200"},
+					"until":     []string{"1609459210"},
+					"max-depth": []string{"2"},
+					"format":    []string{"json"},
+				}
+				resp, err = http.Get(httpServer.URL + "/render?" + q.Encode())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				var res RenderResponse
+				Expect(json.NewDecoder(resp.Body).Decode(&res)).To(Succeed())
+				Expect(res.Flamebearer.NumTicks).To(Equal(10))
+				// The root, main, and its children.
+				Expect(res.Flamebearer.Levels).To(HaveLen(3))
+
+				q.Set("node", "main;handler")
+				q.Set("max-depth", "1")
+				resp, err = http.Get(httpServer.URL + "/render/expand?" + q.Encode())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				res = RenderResponse{}
+				Expect(json.NewDecoder(resp.Body).Decode(&res)).To(Succeed())
+				Expect(res.Flamebearer.NumTicks).To(Equal(2))
+				Expect(res.Flamebearer.Names).To(ContainElements("handler", "json.Marshal"))
+				Expect(res.Flamebearer.Names).ToNot(ContainElement("reflect"))
+
+				q.Del("node")
+				resp, err = http.Get(httpServer.URL + "/render/expand?" + q.Encode())
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+			})
+			It("supports dot and callgraph formats", func() {
+				defer httpServer.Close()
+
+				resp, err := http.Get(fmt.Sprintf("%s/render?query=%s&format=%s", httpServer.URL, url.QueryEscape(`app{foo="bar"}`), "dot"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				body, _ := io.ReadAll(resp.Body)
+				Expect(string(body)).To(HavePrefix(`digraph "app" {`))
+
+				resp, err = http.Get(fmt.Sprintf("%s/render?query=%s&format=%s", httpServer.URL, url.QueryEscape(`app{foo="bar"}`), "callgraph"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+				var g tree.CallGraph
+				Expect(json.NewDecoder(resp.Body).Decode(&g)).To(Succeed())
+				Expect(g.Nodes).ToNot(BeNil())
+			})
 		})
 	})
 })
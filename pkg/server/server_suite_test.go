@@ -1,16 +1,87 @@
 //go:build !windows
 // +build !windows
 
-package server_test
+package server
 
 import (
+	"net/http/httptest"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/exporter"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
 )
 
 func TestServer(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Server Suite")
 }
+
+// newTestController creates a controller of the storage with the server
+// configuration. Options modify the controller configuration.
+func newTestController(c *config.Server, s *storage.Storage, options ...func(*Config)) *Controller {
+	e, _ := exporter.NewExporter(nil, nil)
+	cfg := Config{
+		Configuration:           c,
+		Storage:                 s,
+		MetricsExporter:         e,
+		Logger:                  logrus.New(),
+		MetricsRegisterer:       prometheus.NewRegistry(),
+		ExportedMetricsRegistry: prometheus.NewRegistry(),
+		Notifier:                mockNotifier{},
+		Adhoc:                   mockAdhocServer{},
+	}
+	for _, o := range options {
+		o(&cfg)
+	}
+	ctrl, err := New(cfg)
+	Expect(err).ToNot(HaveOccurred())
+	return ctrl
+}
+
+// testServer serves the controller API over HTTP.
+type testServer struct {
+	*httptest.Server
+	storage    *storage.Storage
+	controller *Controller
+}
+
+// newTestServer opens the storage of the server configuration and serves
+// the controller of the storage. Close stops the server and closes the
+// storage.
+func newTestServer(c *config.Server, options ...func(*Config)) *testServer {
+	s, err := storage.New(storage.NewConfig(c), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+	Expect(err).ToNot(HaveOccurred())
+	ctrl := newTestController(c, s, options...)
+	h, err := ctrl.mux()
+	Expect(err).ToNot(HaveOccurred())
+	return &testServer{
+		Server:     httptest.NewServer(h),
+		storage:    s,
+		controller: ctrl,
+	}
+}
+
+func (ts *testServer) Close() {
+	ts.Server.Close()
+	ts.storage.Close()
+}
+
+// withTestServer starts a test server before each spec, once the
+// configuration is set up with BeforeEach, and stops it after the spec.
+func withTestServer(cfg **config.Config, options ...func(*Config)) *testServer {
+	ts := new(testServer)
+	JustBeforeEach(func() {
+		*ts = *newTestServer(&(*cfg).Server, options...)
+	})
+	JustAfterEach(func() {
+		ts.Close()
+	})
+	return ts
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+)
+
+// In multi-tenancy mode, data of a tenant is isolated by prefixing names
+// of the applications with the tenant ID and a dot: app.cpu ingested by
+// tenant team-a is stored as team-a.app.cpu. Names and queries specified
+// in requests are prefixed with the request tenant, and application names
+// are listed with the prefix removed, therefore a tenant never encounters
+// data of other tenants.
+
+const tenantHeader = "X-Pyroscope-Tenant"
+
+var (
+	errTenantRequired = errors.New("tenant is required")
+	errInvalidTenant  = errors.New("tenant ID must consist of up to 64 letters, digits, underscores or hyphens")
+	errTenantMismatch = errors.New("api key does not belong to the tenant")
+	errTenantKey      = errors.New("api key belongs to a tenant and can not access data of the server")
+
+	tenantIDRe = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+)
+
+type tenantContextKey struct{}
+
+func validateTenantID(id string) error {
+	if !tenantIDRe.MatchString(id) {
+		return fmt.Errorf("%w: %q", errInvalidTenant, id)
+	}
+	return nil
+}
+
+// tenantMiddleware resolves the tenant of the request, if multi-tenancy is
+// enabled: the tenant of the API key the request is authenticated with
+// takes precedence over X-Pyroscope-Tenant header and the default tenant.
+// Therefore, the middleware must be preceded by the auth middleware.
+func (ctrl *Controller) tenantMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	if !ctrl.config.MultiTenancy {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		tenant := r.Header.Get(tenantHeader)
+		if k, ok := r.Context().Value(apiKeyContextKey{}).(*storage.APIKey); ok && k.Tenant != "" {
+			if tenant != "" && tenant != k.Tenant {
+				ctrl.writeError(w, http.StatusForbidden, errTenantMismatch, "access denied")
+				return
+			}
+			tenant = k.Tenant
+		}
+		if tenant == "" {
+			tenant = ctrl.config.DefaultTenant
+		}
+		if tenant == "" {
+			ctrl.writeError(w, http.StatusUnauthorized, errTenantRequired, "failed to resolve tenant")
+			return
+		}
+		if err := validateTenantID(tenant); err != nil {
+			ctrl.writeInvalidParameterError(w, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantContextKey{}, tenant)))
+	}
+}
+
+// serverMiddleware protects routes that are not tenant-scoped, like usage
+// of all the tenants, the configuration, and database export: in
+// multi-tenancy mode, requests authenticated with API keys bound to
+// a tenant are rejected. The middleware must be preceded by the auth
+// middleware.
+func (ctrl *Controller) serverMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	if !ctrl.config.MultiTenancy {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if k, ok := r.Context().Value(apiKeyContextKey{}).(*storage.APIKey); ok && k.Tenant != "" {
+			ctrl.writeError(w, http.StatusForbidden, errTenantKey, "access denied")
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+func tenantFromContext(ctx context.Context) (string, bool) {
+	t, ok := ctx.Value(tenantContextKey{}).(string)
+	return t, ok
+}
+
+// tenantName prefixes the application name the series name or query
+// starts with, if the request has a tenant.
+func tenantName(ctx context.Context, s string) string {
+	t, ok := tenantFromContext(ctx)
+	if !ok || s == "" {
+		return s
+	}
+	return t + "." + strings.TrimSpace(s)
+}
+
+// tenantKey prefixes the application name of the series key in place,
+// if the request has a tenant.
+func tenantKey(ctx context.Context, k *segment.Key) {
+	if t, ok := tenantFromContext(ctx); ok {
+		k.Add("__name__", t+"."+k.AppName())
+	}
+}
+
+// stripTenant removes the tenant prefix of the application name.
+func stripTenant(ctx context.Context, appName string) string {
+	if t, ok := tenantFromContext(ctx); ok {
+		return strings.TrimPrefix(appName, t+".")
+	}
+	return appName
+}
+
+// tenantApps returns the names of the applications of the request tenant
+// with the prefix removed; all the names if the request has no tenant.
+func tenantApps(ctx context.Context, names []string) []string {
+	t, ok := tenantFromContext(ctx)
+	if !ok {
+		return names
+	}
+	prefix := t + "."
+	apps := make([]string, 0, len(names))
+	for _, n := range names {
+		if strings.HasPrefix(n, prefix) {
+			apps = append(apps, n[len(prefix):])
+		}
+	}
+	return apps
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("multi-tenancy", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		const adminKey = "admin-secret"
+
+		BeforeEach(func() {
+			(*cfg).Server.MultiTenancy = true
+		})
+
+		ts := withTestServer(cfg, func(c *Config) {
+			c.Reloader = mockReloader(func() ([]string, error) { return nil, nil })
+		})
+
+		do := func(method, path, tenant, token string, body []byte) *http.Response {
+			req, err := http.NewRequest(method, ts.URL+path, bytes.NewReader(body))
+			Expect(err).ToNot(HaveOccurred())
+			if tenant != "" {
+				req.Header.Set(tenantHeader, tenant)
+			}
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			res, err := http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			return res
+		}
+
+		ingest := func(tenant, token, app string) int {
+			q := url.Values{"name": []string{app}, "from": []string{"1609459200"}, "until": []string{"1609459210"}}
+			res := do(http.MethodPost, "/ingest?"+q.Encode(), tenant, token, []byte("main;foo 1\n"))
+			res.Body.Close()
+			return res.StatusCode
+		}
+
+		numTicks := func(tenant, app string) int {
+			q := url.Values{"query": []string{app + "{}"}, "from": []string{"1609459200"}, "until": []string{"1609459210"}, "format": []string{"json"}}
+			res := do(http.MethodGet, "/render?"+q.Encode(), tenant, "", nil)
+			defer res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			var r struct {
+				Flamebearer struct {
+					NumTicks int `json:"numTicks"`
+				} `json:"flamebearer"`
+			}
+			Expect(json.NewDecoder(res.Body).Decode(&r)).To(Succeed())
+			return r.Flamebearer.NumTicks
+		}
+
+		appNames := func(tenant string) []string {
+			res := do(http.MethodGet, "/api/label-values?label=__name__", tenant, "", nil)
+			defer res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			var names []string
+			Expect(json.NewDecoder(res.Body).Decode(&names)).To(Succeed())
+			return names
+		}
+
+		It("isolates data of tenants", func() {
+			Expect(ingest("team-a", "", "app.cpu")).To(Equal(http.StatusOK))
+			Expect(ingest("team-b", "", "other.cpu")).To(Equal(http.StatusOK))
+
+			Expect(numTicks("team-a", "app.cpu")).To(Equal(1))
+			Expect(numTicks("team-b", "app.cpu")).To(BeZero())
+			Expect(appNames("team-a")).To(ConsistOf("app.cpu"))
+			Expect(appNames("team-b")).To(ConsistOf("other.cpu"))
+			Expect(ts.storage.GetAppNames()).To(ConsistOf("team-a.app.cpu", "team-b.other.cpu"))
+
+			res := do(http.MethodGet, "/api/labels", "team-a", "", nil)
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusForbidden))
+		})
+
+		It("requires the tenant", func() {
+			Expect(ingest("", "", "app.cpu")).To(Equal(http.StatusUnauthorized))
+			Expect(ingest("team.a", "", "app.cpu")).To(Equal(http.StatusBadRequest))
+			res := do(http.MethodGet, "/api/label-values?label=__name__", "", "", nil)
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusUnauthorized))
+		})
+
+		Context("default tenant is specified", func() {
+			BeforeEach(func() {
+				(*cfg).Server.DefaultTenant = "default"
+			})
+
+			It("uses the default tenant", func() {
+				Expect(ingest("", "", "app.cpu")).To(Equal(http.StatusOK))
+				Expect(numTicks("default", "app.cpu")).To(Equal(1))
+				Expect(numTicks("team-a", "app.cpu")).To(BeZero())
+			})
+		})
+
+		Context("API keys are enabled", func() {
+			BeforeEach(func() {
+				(*cfg).Server.Auth.APIKeys.Enabled = true
+				(*cfg).Server.Auth.APIKeys.AdminKey = adminKey
+			})
+
+			It("binds keys to tenants", func() {
+				b, _ := json.Marshal(createAPIKeyRequest{Name: "agent", Role: "ingest"})
+				res := do(http.MethodPost, "/api/keys", "team-a", adminKey, b)
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				var k createAPIKeyResponse
+				Expect(json.NewDecoder(res.Body).Decode(&k)).To(Succeed())
+				res.Body.Close()
+				Expect(k.Tenant).To(Equal("team-a"))
+
+				Expect(ingest("", k.Key, "app.cpu")).To(Equal(http.StatusOK))
+				Expect(ingest("team-a", k.Key, "app.cpu")).To(Equal(http.StatusOK))
+				Expect(ingest("team-b", k.Key, "app.cpu")).To(Equal(http.StatusForbidden))
+				Expect(ts.storage.GetAppNames()).To(ConsistOf("team-a.app.cpu"))
+
+				var keys []apiKey
+				res = do(http.MethodGet, "/api/keys", "team-b", adminKey, nil)
+				Expect(json.NewDecoder(res.Body).Decode(&keys)).To(Succeed())
+				res.Body.Close()
+				Expect(keys).To(BeEmpty())
+				res = do(http.MethodDelete, "/api/keys?name=agent", "team-b", adminKey, nil)
+				res.Body.Close()
+				Expect(res.StatusCode).To(Equal(http.StatusNotFound))
+				res = do(http.MethodDelete, "/api/keys?name=agent", "team-a", adminKey, nil)
+				res.Body.Close()
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+			})
+
+			It("denies tenant keys access to data of the server", func() {
+				b, _ := json.Marshal(createAPIKeyRequest{Name: "tenant-admin", Role: "admin"})
+				res := do(http.MethodPost, "/api/keys", "team-a", adminKey, b)
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+				var k createAPIKeyResponse
+				Expect(json.NewDecoder(res.Body).Decode(&k)).To(Succeed())
+				res.Body.Close()
+				Expect(k.Tenant).To(Equal("team-a"))
+
+				for _, p := range []string{"/api/usage", "/config", "/build", "/debug/storage/export/main", "/debug/pprof/", "/-/reload"} {
+					method := http.MethodGet
+					if p == "/-/reload" {
+						method = http.MethodPost
+					}
+					res = do(method, p, "", k.Key, nil)
+					res.Body.Close()
+					Expect(res.StatusCode).To(Equal(http.StatusForbidden), p)
+					res = do(method, p, "", adminKey, nil)
+					res.Body.Close()
+					Expect(res.StatusCode).ToNot(Equal(http.StatusForbidden), p)
+				}
+
+				res = do(http.MethodGet, "/api/keys", "", k.Key, nil)
+				res.Body.Close()
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+			})
+		})
+	})
+})
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+)
+
+// maxTimelinePoints limits the number of points of a timeline series.
+const maxTimelinePoints = 11000
+
+type timelineResponse struct {
+	// StartTime is the Unix timestamp of the first point; points
+	// are Step seconds apart.
+	StartTime int64            `json:"startTime"`
+	Step      int64            `json:"step"`
+	Units     string           `json:"units"`
+	GroupBy   string           `json:"groupBy,omitempty"`
+	Series    []timelineSeries `json:"series"`
+}
+
+type timelineSeries struct {
+	// Value of the groupBy label.
+	Value   string   `json:"value"`
+	Samples []uint64 `json:"samples"`
+}
+
+// timelineHandler returns the time series of the total number of samples
+// of the profile matching the query, one per value of the groupBy label,
+// if specified. The step parameter (a duration, e.g. 1m, or a number of
+// seconds) is rounded up to a multiple of the timeline resolution of the
+// time range, which it defaults to.
+func (ctrl *Controller) timelineHandler(w http.ResponseWriter, r *http.Request) {
+	var p renderParams
+	if err := ctrl.renderParametersFromRequest(r, &p); err != nil {
+		ctrl.writeInvalidParameterError(w, err)
+		return
+	}
+	var step time.Duration
+	if s := r.URL.Query().Get("step"); s != "" {
+		var err error
+		if step, err = parseTimelineStep(s); err != nil {
+			ctrl.writeInvalidParameterError(w, fmt.Errorf("step: %w", err))
+			return
+		}
+	}
+
+	// Timelines of the profiles are generated for the same time
+	// range, and thus have the same resolution.
+	tl := segment.GenerateTimeline(p.gi.StartTime, p.gi.EndTime)
+	delta := time.Duration(tl.DurationDeltaNormalized) * time.Second
+	// The step must be a multiple of the timeline resolution.
+	step = (step + delta - 1) / delta * delta
+	if step < delta {
+		step = delta
+	}
+	end := tl.StartTime + int64(len(tl.Samples))*tl.DurationDeltaNormalized
+	res := timelineResponse{
+		StartTime: tl.StartTime - tl.StartTime%int64(step.Seconds()),
+		Step:      int64(step.Seconds()),
+		GroupBy:   p.groupBy,
+		Series:    []timelineSeries{},
+	}
+	n := int((end - res.StartTime + res.Step - 1) / res.Step)
+	if n > maxTimelinePoints {
+		ctrl.writeInvalidParameterError(w, fmt.Errorf("step: too many points (%d), the maximum is %d", n, maxTimelinePoints))
+		return
+	}
+
+	groups := make(map[string]*storage.GetOutput)
+	var err error
+	if p.groupBy != "" {
+		groups, err = ctrl.storage.GetGroupedContext(r.Context(), p.gi, p.groupBy)
+	} else {
+		var out *storage.GetOutput
+		if out, err = ctrl.storage.GetContext(r.Context(), p.gi); out != nil {
+			groups[""] = out
+		}
+	}
+	ctrl.statsInc("timeline")
+	if err != nil {
+		ctrl.writeInternalServerError(w, err, "failed to retrieve data")
+		return
+	}
+
+	for v, out := range groups {
+		res.Units = out.Units
+		samples := make([]uint64, n)
+		for i, x := range out.Timeline.Samples {
+			// Timeline values are offset by one, 0 indicates
+			// the absence of data.
+			if x == 0 {
+				continue
+			}
+			t := out.Timeline.StartTime + int64(i)*out.Timeline.DurationDeltaNormalized
+			samples[(t-res.StartTime)/res.Step] += x - 1
+		}
+		res.Series = append(res.Series, timelineSeries{Value: v, Samples: samples})
+	}
+	sort.Slice(res.Series, func(i, j int) bool {
+		return res.Series[i].Value < res.Series[j].Value
+	})
+
+	ctrl.writeResponseJSON(w, res)
+}
+
+func parseTimelineStep(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		n, nerr := strconv.Atoi(s)
+		if nerr != nil {
+			return 0, err
+		}
+		d = time.Duration(n) * time.Second
+	}
+	if d <= 0 || d%(10*time.Second) != 0 {
+		return 0, fmt.Errorf("invalid value %q: must be a positive multiple of 10s", s)
+	}
+	return d, nil
+}
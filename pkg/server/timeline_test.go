@@ -0,0 +1,68 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("/api/timeline", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		ts := withTestServer(cfg)
+
+		ingest := func(name, from, until string) {
+			q := url.Values{"name": []string{name}, "from": []string{from}, "until": []string{until}}
+			res, err := http.Post(ts.URL+"/ingest?"+q.Encode(), "text/plain", bytes.NewBufferString("foo;bar 2\n"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+		}
+
+		timeline := func(q url.Values) (int, timelineResponse) {
+			q.Set("query", "app.cpu")
+			q.Set("from", "1609459200")
+			q.Set("until", "1609459800")
+			res, err := http.Get(ts.URL + "/api/timeline?" + q.Encode())
+			Expect(err).ToNot(HaveOccurred())
+			defer res.Body.Close()
+			var t timelineResponse
+			if res.StatusCode == http.StatusOK {
+				Expect(json.NewDecoder(res.Body).Decode(&t)).To(Succeed())
+			}
+			return res.StatusCode, t
+		}
+
+		It("returns series of total samples per tag value", func() {
+			ingest("app.cpu{pod=a}", "1609459200", "1609459210")
+			ingest("app.cpu{pod=a}", "1609459260", "1609459270")
+			ingest("app.cpu{pod=b}", "1609459600", "1609459610")
+
+			code, t := timeline(url.Values{"step": []string{"5m"}})
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(t.StartTime).To(Equal(int64(1609459200)))
+			Expect(t.Step).To(Equal(int64(300)))
+			Expect(t.Units).To(Equal("samples"))
+			Expect(t.Series).To(Equal([]timelineSeries{{Value: "", Samples: []uint64{4, 2}}}))
+
+			code, t = timeline(url.Values{"step": []string{"300"}, "groupBy": []string{"pod"}})
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(t.Series).To(Equal([]timelineSeries{
+				{Value: "a", Samples: []uint64{4, 0}},
+				{Value: "b", Samples: []uint64{0, 2}},
+			}))
+		})
+
+		It("validates step", func() {
+			code, _ := timeline(url.Values{"step": []string{"15s"}})
+			Expect(code).To(Equal(http.StatusBadRequest))
+			code, _ = timeline(url.Values{"step": []string{"1y"}})
+			Expect(code).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+)
+
+// newTLSConfig returns the TLS configuration of the server listener, if
+// verification of client certificates is configured. Otherwise, nil is
+// returned and the default configuration is used.
+func newTLSConfig(c *config.Server) (*tls.Config, error) {
+	if c.TLSClientCAFile == "" && c.TLSClientAuth == "" {
+		return nil, nil
+	}
+	if c.TLSCertificateFile == "" || c.TLSKeyFile == "" {
+		return nil, errors.New("client certificate verification requires tls-certificate-file and tls-key-file")
+	}
+	clientAuth, err := parseTLSClientAuth(c.TLSClientAuth, c.TLSClientCAFile != "")
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := tls.Config{
+		ClientAuth: clientAuth,
+		MinVersion: tls.VersionTLS12,
+	}
+	if c.TLSClientCAFile != "" {
+		b, err := os.ReadFile(c.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", c.TLSClientCAFile)
+		}
+	}
+	return &tlsConfig, nil
+}
+
+func parseTLSClientAuth(s string, hasCA bool) (tls.ClientAuthType, error) {
+	switch s {
+	case "":
+		return tls.RequireAndVerifyClientCert, nil
+	case "request":
+		return tls.RequestClientCert, nil
+	case "require":
+		return tls.RequireAnyClientCert, nil
+	case "verify-if-given", "require-and-verify":
+		if !hasCA {
+			return 0, fmt.Errorf("tls-client-auth %s requires tls-client-ca-file", s)
+		}
+		if s == "verify-if-given" {
+			return tls.VerifyClientCertIfGiven, nil
+		}
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return 0, fmt.Errorf("unknown tls-client-auth value %q", s)
+	}
+}
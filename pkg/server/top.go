@@ -0,0 +1,118 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+)
+
+const (
+	defaultTopFunctions = 50
+	maxTopFunctions     = 1000
+)
+
+type topFunction struct {
+	tree.FunctionStats
+	// Stats of the function in the baseline time range, if requested.
+	BaselineSelf  *uint64 `json:"baselineSelf,omitempty"`
+	BaselineTotal *uint64 `json:"baselineTotal,omitempty"`
+}
+
+type topResponse struct {
+	Units string `json:"units"`
+	// Total is the number of samples in the time range; BaselineTotal
+	// is the one of the baseline range, useful for normalization.
+	Total         uint64        `json:"total"`
+	BaselineTotal *uint64       `json:"baselineTotal,omitempty"`
+	Functions     []topFunction `json:"functions"`
+}
+
+// topHandler lists the top n functions by self (or total, if sort=total)
+// time of the profile matching the query in the time range specified with
+// from and until parameters. If baselineFrom or baselineUntil is given,
+// stats of the functions in the baseline range are included.
+func (ctrl *Controller) topHandler(w http.ResponseWriter, r *http.Request) {
+	var p renderParams
+	if err := ctrl.renderParametersFromRequest(r, &p); err != nil {
+		ctrl.writeInvalidParameterError(w, err)
+		return
+	}
+	v := r.URL.Query()
+	n := defaultTopFunctions
+	if s := v.Get("n"); s != "" {
+		var err error
+		if n, err = strconv.Atoi(s); err != nil || n <= 0 || n > maxTopFunctions {
+			ctrl.writeInvalidParameterError(w, fmt.Errorf("n: must be a positive number not exceeding %d", maxTopFunctions))
+			return
+		}
+	}
+	var byTotal bool
+	switch s := v.Get("sort"); s {
+	case "", "self":
+	case "total":
+		byTotal = true
+	default:
+		ctrl.writeInvalidParameterError(w, fmt.Errorf("sort: unknown value %q: must be self or total", s))
+		return
+	}
+
+	out, err := ctrl.loadTree(p.gi, p.gi.StartTime, p.gi.EndTime)
+	ctrl.statsInc("top")
+	if err != nil {
+		ctrl.writeInternalServerError(w, err, "failed to retrieve data")
+		return
+	}
+	var baseline *storage.GetOutput
+	if st, et, ok := parseRenderRangeParams(r, "baselineFrom", "baselineUntil"); ok {
+		if baseline, err = ctrl.loadTree(p.gi, st, et); err != nil {
+			ctrl.writeInternalServerError(w, err, "failed to retrieve data")
+			return
+		}
+	}
+
+	functions := out.Tree.Functions()
+	res := topResponse{
+		Units:     out.Units,
+		Total:     out.Tree.Samples(),
+		Functions: make([]topFunction, 0, len(functions)),
+	}
+	for _, f := range functions {
+		res.Functions = append(res.Functions, topFunction{FunctionStats: *f})
+	}
+	sort.Slice(res.Functions, func(i, j int) bool {
+		a, b := res.Functions[i], res.Functions[j]
+		if byTotal && a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		if a.Self != b.Self {
+			return a.Self > b.Self
+		}
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		return a.Name < b.Name
+	})
+	if len(res.Functions) > n {
+		res.Functions = res.Functions[:n]
+	}
+
+	if baseline != nil {
+		baselineTotal := baseline.Tree.Samples()
+		res.BaselineTotal = &baselineTotal
+		baselineFunctions := baseline.Tree.Functions()
+		for i := range res.Functions {
+			var self, total uint64
+			if f, ok := baselineFunctions[res.Functions[i].Name]; ok {
+				self, total = f.Self, f.Total
+			}
+			res.Functions[i].BaselineSelf = &self
+			res.Functions[i].BaselineTotal = &total
+		}
+	}
+
+	ctrl.writeResponseJSON(w, res)
+}
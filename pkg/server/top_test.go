@@ -0,0 +1,77 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("/api/top", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		ts := withTestServer(cfg)
+
+		ingest := func(from, until, data string) {
+			q := url.Values{"name": []string{"app.cpu"}, "from": []string{from}, "until": []string{until}}
+			res, err := http.Post(ts.URL+"/ingest?"+q.Encode(), "text/plain", bytes.NewBufferString(data))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+		}
+
+		top := func(q url.Values) (int, topResponse) {
+			q.Set("query", "app.cpu")
+			res, err := http.Get(ts.URL + "/api/top?" + q.Encode())
+			Expect(err).ToNot(HaveOccurred())
+			defer res.Body.Close()
+			var t topResponse
+			if res.StatusCode == http.StatusOK {
+				Expect(json.NewDecoder(res.Body).Decode(&t)).To(Succeed())
+			}
+			return res.StatusCode, t
+		}
+
+		It("lists top functions", func() {
+			ingest("1609459200", "1609459210", "main;foo 1\nmain;bar 3\nmain 2\n")
+			ingest("1609459300", "1609459310", "main;foo 5\n")
+
+			code, t := top(url.Values{"from": []string{"1609459200"}, "until": []string{"1609459210"}})
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(t.Total).To(Equal(uint64(6)))
+			Expect(t.BaselineTotal).To(BeNil())
+			var names []string
+			for _, f := range t.Functions {
+				names = append(names, f.Name)
+			}
+			Expect(names).To(Equal([]string{"bar", "main", "foo"}))
+
+			code, t = top(url.Values{
+				"from":          []string{"1609459200"},
+				"until":         []string{"1609459210"},
+				"baselineFrom":  []string{"1609459300"},
+				"baselineUntil": []string{"1609459310"},
+				"sort":          []string{"total"},
+				"n":             []string{"2"},
+			})
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(*t.BaselineTotal).To(Equal(uint64(5)))
+			Expect(t.Functions).To(HaveLen(2))
+			Expect(t.Functions[0].Name).To(Equal("main"))
+			Expect(*t.Functions[0].BaselineTotal).To(Equal(uint64(5)))
+			Expect(t.Functions[1].Name).To(Equal("bar"))
+			Expect(*t.Functions[1].BaselineSelf).To(BeZero())
+		})
+
+		It("validates parameters", func() {
+			code, _ := top(url.Values{"n": []string{"0"}})
+			Expect(code).To(Equal(http.StatusBadRequest))
+			code, _ = top(url.Values{"sort": []string{"name"}})
+			Expect(code).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
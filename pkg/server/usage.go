@@ -0,0 +1,217 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/util/attime"
+)
+
+// usageRetention is the period the ingestion and query counters
+// are kept for. The counters are aggregated hourly.
+const usageRetention = 31 * 24 * time.Hour
+
+// usageTracker counts ingested bytes and queries of every application
+// (the stored name, i.e. including the tenant prefix). Counters are kept
+// in memory and reset on restart; in clustering mode, each server counts
+// the requests it handles.
+type usageTracker struct {
+	mutex sync.Mutex
+	apps  map[string]map[int64]*usageCounters
+}
+
+type usageCounters struct {
+	ingestedBytes int64
+	queries       int64
+}
+
+func newUsageTracker() *usageTracker {
+	return &usageTracker{apps: make(map[string]map[int64]*usageCounters)}
+}
+
+func (u *usageTracker) counters(appName string, now time.Time) *usageCounters {
+	hours, ok := u.apps[appName]
+	if !ok {
+		hours = make(map[int64]*usageCounters)
+		u.apps[appName] = hours
+	}
+	h := now.Unix() / 3600
+	c, ok := hours[h]
+	if !ok {
+		c = new(usageCounters)
+		hours[h] = c
+		// Counters are pruned once an hour per application.
+		oldest := now.Add(-usageRetention).Unix() / 3600
+		for x := range hours {
+			if x < oldest {
+				delete(hours, x)
+			}
+		}
+	}
+	return c
+}
+
+// ingested accounts the size of a request split evenly between
+// the profiles, like ingestion quotas do.
+func (u *usageTracker) ingested(inputs []*storage.PutInput, size int64, now time.Time) {
+	if u == nil || len(inputs) == 0 {
+		return
+	}
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	for _, pi := range inputs {
+		u.counters(pi.Key.AppName(), now).ingestedBytes += size / int64(len(inputs))
+	}
+}
+
+func (u *usageTracker) queried(appName string, now time.Time) {
+	if u == nil {
+		return
+	}
+	u.mutex.Lock()
+	u.counters(appName, now).queries++
+	u.mutex.Unlock()
+}
+
+// sum returns the counters of the applications within the time range,
+// with the boundaries rounded to the hour.
+func (u *usageTracker) sum(from, until time.Time) map[string]usageCounters {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	f, t := from.Unix()/3600, until.Unix()/3600
+	m := make(map[string]usageCounters, len(u.apps))
+	for app, hours := range u.apps {
+		var s usageCounters
+		for h, c := range hours {
+			if h >= f && h <= t {
+				s.ingestedBytes += c.ingestedBytes
+				s.queries += c.queries
+			}
+		}
+		m[app] = s
+	}
+	return m
+}
+
+func (ctrl *Controller) countQuery(gi *storage.GetInput) {
+	switch {
+	case gi.Key != nil:
+		ctrl.usage.queried(gi.Key.AppName(), time.Now())
+	case gi.Query != nil:
+		ctrl.usage.queried(gi.Query.AppName, time.Now())
+	}
+}
+
+type usageResponse struct {
+	From    int64         `json:"from"`
+	Until   int64         `json:"until"`
+	Tenants []tenantUsage `json:"tenants"`
+}
+
+type tenantUsage struct {
+	Tenant string `json:"tenant,omitempty"`
+	usageTotals
+	Apps []appUsage `json:"apps"`
+}
+
+type appUsage struct {
+	Name string `json:"name"`
+	usageTotals
+}
+
+type usageTotals struct {
+	// IngestedBytes and Queries are counted within the time range,
+	// StoredBytes and Series reflect the current state.
+	IngestedBytes int64 `json:"ingestedBytes"`
+	StoredBytes   int64 `json:"storedBytes"`
+	Series        int   `json:"series"`
+	Queries       int64 `json:"queries"`
+}
+
+func (t *usageTotals) add(x usageTotals) {
+	t.IngestedBytes += x.IngestedBytes
+	t.StoredBytes += x.StoredBytes
+	t.Series += x.Series
+	t.Queries += x.Queries
+}
+
+// usageHandler reports the usage per tenant and application for
+// chargeback: GET /api/usage?from=now-30d&until=now&tenant=team-a
+// Ingested bytes and queries are counted within the time range (the last
+// 24 hours by default), which is rounded to the hour and limited to the
+// last 31 days. Stored bytes and series are reported as of now. The
+// tenant parameter limits the report to a single tenant.
+func (ctrl *Controller) usageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		ctrl.writeInvalidMethodError(w)
+		return
+	}
+	v := r.URL.Query()
+	now := time.Now()
+	from, until := now.Add(-24*time.Hour), now
+	if s := v.Get("from"); s != "" {
+		from = attime.Parse(s)
+	}
+	if s := v.Get("until"); s != "" {
+		until = attime.Parse(s)
+	}
+	if oldest := now.Add(-usageRetention); from.Before(oldest) {
+		from = oldest
+	}
+	tenant := v.Get("tenant")
+	if tenant != "" {
+		if err := validateTenantID(tenant); err != nil {
+			ctrl.writeInvalidParameterError(w, err)
+			return
+		}
+	}
+
+	counters := ctrl.usage.sum(from, until)
+	apps := make(map[string]usageTotals)
+	for app, c := range counters {
+		if c.ingestedBytes > 0 || c.queries > 0 {
+			apps[app] = usageTotals{IngestedBytes: c.ingestedBytes, Queries: c.queries}
+		}
+	}
+	for _, app := range ctrl.storage.GetAppNames() {
+		if u, ok := ctrl.storage.AppUsage(app); ok {
+			t := apps[app]
+			t.StoredBytes, t.Series = u.Bytes, u.Series
+			apps[app] = t
+		}
+	}
+
+	tenants := make(map[string]*tenantUsage)
+	for app, t := range apps {
+		var appTenant string
+		if ctrl.config.MultiTenancy {
+			i := strings.IndexByte(app, '.')
+			if i < 0 {
+				continue
+			}
+			appTenant, app = app[:i], app[i+1:]
+		}
+		if tenant != "" && appTenant != tenant {
+			continue
+		}
+		tu, ok := tenants[appTenant]
+		if !ok {
+			tu = &tenantUsage{Tenant: appTenant}
+			tenants[appTenant] = tu
+		}
+		tu.add(t)
+		tu.Apps = append(tu.Apps, appUsage{Name: app, usageTotals: t})
+	}
+
+	res := usageResponse{From: from.Unix(), Until: until.Unix(), Tenants: make([]tenantUsage, 0, len(tenants))}
+	for _, tu := range tenants {
+		sort.Slice(tu.Apps, func(i, j int) bool { return tu.Apps[i].Name < tu.Apps[j].Name })
+		res.Tenants = append(res.Tenants, *tu)
+	}
+	sort.Slice(res.Tenants, func(i, j int) bool { return res.Tenants[i].Tenant < res.Tenants[j].Tenant })
+	ctrl.writeResponseJSON(w, res)
+}
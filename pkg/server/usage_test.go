@@ -0,0 +1,91 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("usage reporting", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		BeforeEach(func() {
+			(*cfg).Server.MultiTenancy = true
+		})
+
+		ts := withTestServer(cfg)
+
+		do := func(method, path, tenant string, body []byte) *http.Response {
+			req, err := http.NewRequest(method, ts.URL+path, bytes.NewReader(body))
+			Expect(err).ToNot(HaveOccurred())
+			req.Header.Set(tenantHeader, tenant)
+			res, err := http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			return res
+		}
+
+		usage := func(q url.Values) usageResponse {
+			res, err := http.Get(ts.URL + "/api/usage?" + q.Encode())
+			Expect(err).ToNot(HaveOccurred())
+			defer res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			var u usageResponse
+			Expect(json.NewDecoder(res.Body).Decode(&u)).To(Succeed())
+			return u
+		}
+
+		It("reports usage per tenant and application", func() {
+			st := time.Now().Add(-time.Minute).Unix()
+			body := []byte("main;foo 1\n")
+			for _, x := range []struct{ tenant, app string }{
+				{"team-a", "app.cpu{pod=a}"},
+				{"team-a", "app.cpu{pod=b}"},
+				{"team-a", "other.cpu"},
+				{"team-b", "app.cpu"},
+			} {
+				q := url.Values{"name": []string{x.app}, "from": []string{"now-1m"}}
+				res := do(http.MethodPost, "/ingest?"+q.Encode(), x.tenant, body)
+				res.Body.Close()
+				Expect(res.StatusCode).To(Equal(http.StatusOK))
+			}
+			q := url.Values{"query": []string{"app.cpu{}"}, "from": []string{"now-1h"}, "format": []string{"json"}}
+			res := do(http.MethodGet, "/render?"+q.Encode(), "team-a", nil)
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+
+			u := usage(url.Values{})
+			Expect(u.Until).To(BeNumerically(">", st))
+			Expect(u.Tenants).To(HaveLen(2))
+			a := u.Tenants[0]
+			Expect(a.Tenant).To(Equal("team-a"))
+			Expect(a.IngestedBytes).To(Equal(int64(3 * len(body))))
+			Expect(a.Series).To(Equal(3))
+			Expect(a.Queries).To(Equal(int64(1)))
+			Expect(a.Apps).To(HaveLen(2))
+			Expect(a.Apps[0].Name).To(Equal("app.cpu"))
+			Expect(a.Apps[0].Series).To(Equal(2))
+			Expect(a.Apps[0].Queries).To(Equal(int64(1)))
+			Expect(a.Apps[1].Name).To(Equal("other.cpu"))
+			Expect(a.Apps[1].Queries).To(BeZero())
+
+			By("limiting the report to a tenant")
+			u = usage(url.Values{"tenant": []string{"team-b"}})
+			Expect(u.Tenants).To(HaveLen(1))
+			Expect(u.Tenants[0].Tenant).To(Equal("team-b"))
+			Expect(u.Tenants[0].IngestedBytes).To(Equal(int64(len(body))))
+
+			By("counting ingestion and queries within the time range only")
+			u = usage(url.Values{"from": []string{"now-5d"}, "until": []string{"now-3d"}})
+			Expect(u.Tenants[0].IngestedBytes).To(BeZero())
+			Expect(u.Tenants[0].Queries).To(BeZero())
+			Expect(u.Tenants[0].Series).To(Equal(3))
+		})
+	})
+})
@@ -0,0 +1,112 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/flameql"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("aggregation across series", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		var s *Storage
+		st := time.Now().Add(-time.Minute).Truncate(10 * time.Second)
+
+		BeforeEach(func() {
+			var err error
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+			for k, v := range map[string]uint64{
+				"app.cpu{pod=a,region=us}": 10,
+				"app.cpu{pod=b,region=us}": 30,
+				"app.cpu{pod=c,region=eu}": 5,
+				"app.cpu{region=eu}":       1,
+			} {
+				key, err := segment.ParseKey(k)
+				Expect(err).ToNot(HaveOccurred())
+				t := tree.New()
+				t.Insert([]byte("a;b"), v)
+				Expect(s.Put(&PutInput{
+					StartTime:  st,
+					EndTime:    st.Add(10 * time.Second),
+					Key:        key,
+					Val:        t,
+					SpyName:    "gospy",
+					SampleRate: 100,
+				})).To(Succeed())
+			}
+		})
+
+		It("groups series by label values", func() {
+			defer func() { Expect(s.Close()).To(Succeed()) }()
+			q, err := flameql.ParseQuery(`app.cpu{}`)
+			Expect(err).ToNot(HaveOccurred())
+			groups, err := s.GetGroupedContext(context.Background(), &GetInput{
+				StartTime: st,
+				EndTime:   st.Add(10 * time.Second),
+				Query:     q,
+			}, "pod")
+			Expect(err).ToNot(HaveOccurred())
+			totals := map[string]uint64{}
+			for v, o := range groups {
+				totals[v] = o.Tree.Samples()
+			}
+			Expect(totals).To(Equal(map[string]uint64{"a": 10, "b": 30, "c": 5, "": 1}))
+		})
+
+		It("averages series", func() {
+			defer func() { Expect(s.Close()).To(Succeed()) }()
+			q, err := flameql.ParseQuery(`app.cpu{region="us"}`)
+			Expect(err).ToNot(HaveOccurred())
+			gi := &GetInput{
+				StartTime: st,
+				EndTime:   st.Add(10 * time.Second),
+				Query:     q,
+			}
+			o, err := s.Get(gi)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(o.Tree.Samples()).To(Equal(uint64(40)))
+
+			gi.Aggregation = AggregationAvg
+			o, err = s.Get(gi)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(o.Tree.Samples()).To(Equal(uint64(20)))
+		})
+
+		It("calculates percentiles across series", func() {
+			defer func() { Expect(s.Close()).To(Succeed()) }()
+			q, err := flameql.ParseQuery(`app.cpu{}`)
+			Expect(err).ToNot(HaveOccurred())
+			gi := &GetInput{
+				StartTime:   st,
+				EndTime:     st.Add(10 * time.Second),
+				Query:       q,
+				Aggregation: AggregationMedian,
+			}
+			o, err := s.Get(gi)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(o.Tree.String()).To(Equal("a;b 5\n"))
+
+			gi.Aggregation, err = ParseAggregation("p90")
+			Expect(err).ToNot(HaveOccurred())
+			o, err = s.Get(gi)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(o.Tree.Samples()).To(Equal(uint64(30)))
+
+			for _, a := range []string{"p0", "p100", "p090", "max"} {
+				_, err = ParseAggregation(a)
+				Expect(err).To(HaveOccurred())
+			}
+		})
+	})
+})
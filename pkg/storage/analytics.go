@@ -2,31 +2,148 @@ package storage
 
 import (
 	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
 
-	"github.com/dgraph-io/badger/v2"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
 )
 
-const analyticsKey = "analytics"
+const (
+	analyticsKey         = "analytics"
+	analyticsScheduleKey = "analytics-schedule"
+
+	analyticsHistoryPrefix = "analytics-history:"
+	// analyticsHistoryMaxEntries is the number of reports kept: older
+	// reports are removed. Reports are created daily.
+	analyticsHistoryMaxEntries = 90
+)
+
+// AnalyticsHistoryEntry is an analytics report that failed to upload.
+type AnalyticsHistoryEntry struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Report    json.RawMessage `json:"report"`
+}
 
 func (s *Storage) SaveAnalytics(a interface{}) error {
-	v, err := json.Marshal(a)
+	return s.saveJSON(analyticsKey, a)
+}
+
+// LoadAnalytics decodes stored analytics data into a. If the stored value
+// is malformed (e.g, due to a crash during write), a is reset to its zero
+// value and no error is returned: the value is overwritten on next save.
+func (s *Storage) LoadAnalytics(a interface{}) error {
+	v, err := s.loadValue(analyticsKey)
 	if err != nil {
 		return err
 	}
-	return s.main.Update(func(txn *badger.Txn) error {
-		return txn.SetEntry(badger.NewEntry([]byte(analyticsKey), v))
+	if err = json.Unmarshal(v, a); err != nil {
+		s.logger.WithError(err).Warn("stored analytics data is malformed and will be discarded")
+		x := reflect.ValueOf(a).Elem()
+		x.Set(reflect.Zero(x.Type()))
+	}
+	return nil
+}
+
+// SaveAnalyticsSchedule persists the time the next analytics report
+// is scheduled at.
+func (s *Storage) SaveAnalyticsSchedule(t time.Time) error {
+	return s.saveJSON(analyticsScheduleKey, t)
+}
+
+// LoadAnalyticsSchedule returns the time the next analytics report
+// is scheduled at. backend.ErrNotFound is returned if no schedule
+// has been saved.
+func (s *Storage) LoadAnalyticsSchedule() (time.Time, error) {
+	var t time.Time
+	err := s.loadJSON(analyticsScheduleKey, &t)
+	return t, err
+}
+
+// SaveAnalyticsHistory stores an analytics report payload created at t,
+// so that it can be replayed later. Only the most recent reports are kept.
+func (s *Storage) SaveAnalyticsHistory(t time.Time, report []byte) error {
+	err := s.saveJSON(analyticsHistoryKey(t), AnalyticsHistoryEntry{
+		Timestamp: t,
+		Report:    report,
 	})
+	if err != nil {
+		return err
+	}
+	return s.pruneAnalyticsHistory(analyticsHistoryMaxEntries)
 }
 
-func (s *Storage) LoadAnalytics(a interface{}) error {
-	err := s.main.View(func(txn *badger.Txn) error {
-		v, err := txn.Get([]byte(analyticsKey))
-		if err != nil {
+// DeleteAnalyticsHistory removes the history entry created at t,
+// once the report is replayed.
+func (s *Storage) DeleteAnalyticsHistory(t time.Time) error {
+	return s.main.Backend.Delete([]byte(analyticsHistoryKey(t)))
+}
+
+// pruneAnalyticsHistory removes the oldest history entries,
+// if there are more than n.
+func (s *Storage) pruneAnalyticsHistory(n int) error {
+	var keys [][]byte
+	it := s.main.NewIterator(backend.IteratorOptions{Prefix: []byte(analyticsHistoryPrefix)})
+	for it.Rewind(); it.Valid(); it.Next() {
+		keys = append(keys, it.Item().KeyCopy(nil))
+	}
+	it.Close()
+	if len(keys) <= n {
+		return nil
+	}
+	for _, k := range keys[:len(keys)-n] {
+		if err := s.main.Backend.Delete(k); err != nil {
 			return err
 		}
-		return v.Value(func(val []byte) error {
-			return json.Unmarshal(val, a)
-		})
+	}
+	return nil
+}
+
+// ReadAnalyticsHistory returns history entries created at or after since,
+// ordered by creation time.
+func (s *Storage) ReadAnalyticsHistory(since time.Time) ([]AnalyticsHistoryEntry, error) {
+	var entries []AnalyticsHistoryEntry
+	it := s.main.NewIterator(backend.IteratorOptions{
+		Prefix:         []byte(analyticsHistoryPrefix),
+		PrefetchValues: true,
 	})
-	return err
+	defer it.Close()
+	for it.Seek([]byte(analyticsHistoryKey(since))); it.Valid(); it.Next() {
+		v, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		var e AnalyticsHistoryEntry
+		if err = json.Unmarshal(v, &e); err != nil {
+			s.logger.WithError(err).Warn("skipping malformed analytics history entry")
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// analyticsHistoryKey returns a key that sorts in chronological order.
+func analyticsHistoryKey(t time.Time) string {
+	return fmt.Sprintf("%s%020d", analyticsHistoryPrefix, t.UnixNano())
+}
+
+func (s *Storage) saveJSON(k string, x interface{}) error {
+	v, err := json.Marshal(x)
+	if err != nil {
+		return err
+	}
+	return s.main.Set([]byte(k), v)
+}
+
+func (s *Storage) loadJSON(k string, x interface{}) error {
+	v, err := s.loadValue(k)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(v, x)
+}
+
+func (s *Storage) loadValue(k string) ([]byte, error) {
+	return s.main.Get([]byte(k))
 }
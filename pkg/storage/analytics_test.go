@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("analytics", func() {
+	var s *Storage
+
+	type analytics struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+
+	testing.WithConfig(func(cfg **config.Config) {
+		JustBeforeEach(func() {
+			var err error
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		JustAfterEach(func() {
+			Expect(s.Close()).To(Succeed())
+		})
+
+		It("loads saved analytics data", func() {
+			Expect(s.SaveAnalytics(&analytics{Name: "foo", Count: 42})).To(Succeed())
+			var a analytics
+			Expect(s.LoadAnalytics(&a)).To(Succeed())
+			Expect(a).To(Equal(analytics{Name: "foo", Count: 42}))
+		})
+
+		It("returns error if analytics data does not exist", func() {
+			var a analytics
+			Expect(s.LoadAnalytics(&a)).To(MatchError(backend.ErrNotFound))
+		})
+
+		It("discards malformed analytics data", func() {
+			Expect(s.main.Set([]byte(analyticsKey), []byte(`{"name":"foo","count":4`))).To(Succeed())
+			a := analytics{Name: "bar", Count: 1}
+			Expect(s.LoadAnalytics(&a)).To(Succeed())
+			Expect(a).To(Equal(analytics{}))
+
+			Expect(s.SaveAnalytics(&analytics{Count: 2})).To(Succeed())
+			Expect(s.LoadAnalytics(&a)).To(Succeed())
+			Expect(a).To(Equal(analytics{Count: 2}))
+		})
+
+		It("reads analytics history in chronological order", func() {
+			t := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+			for _, d := range []time.Duration{2, 0, 1, 3} {
+				Expect(s.SaveAnalyticsHistory(t.Add(d*time.Hour), []byte(`{"count":1}`))).To(Succeed())
+			}
+			Expect(s.DeleteAnalyticsHistory(t.Add(3 * time.Hour))).To(Succeed())
+
+			entries, err := s.ReadAnalyticsHistory(t.Add(time.Hour))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(HaveLen(2))
+			Expect(entries[0].Timestamp.Equal(t.Add(time.Hour))).To(BeTrue())
+			Expect(string(entries[0].Report)).To(Equal(`{"count":1}`))
+			Expect(entries[1].Timestamp.Equal(t.Add(2 * time.Hour))).To(BeTrue())
+		})
+
+		It("keeps only the most recent analytics history entries", func() {
+			t := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+			for i := 0; i < analyticsHistoryMaxEntries+5; i++ {
+				Expect(s.SaveAnalyticsHistory(t.Add(time.Duration(i)*time.Hour), []byte(`{}`))).To(Succeed())
+			}
+			entries, err := s.ReadAnalyticsHistory(t)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(HaveLen(analyticsHistoryMaxEntries))
+			Expect(entries[0].Timestamp.Equal(t.Add(5 * time.Hour))).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,255 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+)
+
+// API keys are persisted in the main database under the key name; only
+// the SHA-256 hash of the key token is stored. The keys are loaded into
+// memory, so that authentication of requests doesn't touch the database.
+
+const (
+	apiKeysPrefix   = "apikey:"
+	apiKeyTokenSize = 32
+	// apiKeyTokenPrefix helps to identify leaked tokens.
+	apiKeyTokenPrefix = "psk_"
+)
+
+var (
+	ErrAPIKeyExists       = errors.New("api key already exists")
+	ErrAPIKeyNotFound     = errors.New("api key not found")
+	ErrAPIKeyInvalidName  = errors.New("api key name must consist of letters, digits, '.', '_' and '-'")
+	ErrAPIKeyInvalidToken = errors.New("invalid api key")
+	ErrAPIKeyInvalidApps  = errors.New("invalid api key application pattern")
+
+	apiKeyNameRe = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,64}$`)
+)
+
+type APIKeyRole string
+
+const (
+	// APIKeyRoleIngest allows ingestion only.
+	APIKeyRoleIngest APIKeyRole = "ingest"
+	// APIKeyRoleReadOnly allows queries only.
+	APIKeyRoleReadOnly APIKeyRole = "read-only"
+	// APIKeyRoleAdmin allows everything, including management of keys.
+	APIKeyRoleAdmin APIKeyRole = "admin"
+)
+
+func ParseAPIKeyRole(s string) (APIKeyRole, error) {
+	switch r := APIKeyRole(s); r {
+	case APIKeyRoleIngest, APIKeyRoleReadOnly, APIKeyRoleAdmin:
+		return r, nil
+	default:
+		return "", fmt.Errorf("unknown api key role %q: must be one of %s, %s, %s",
+			s, APIKeyRoleIngest, APIKeyRoleReadOnly, APIKeyRoleAdmin)
+	}
+}
+
+// Permission describes an operation a role is allowed to perform.
+type Permission int
+
+const (
+	PermissionIngest Permission = iota
+	PermissionRead
+	PermissionAdmin
+)
+
+// Allows reports whether the role grants the permission.
+func (r APIKeyRole) Allows(p Permission) bool {
+	switch r {
+	case APIKeyRoleAdmin:
+		return true
+	case APIKeyRoleIngest:
+		return p == PermissionIngest
+	case APIKeyRoleReadOnly:
+		return p == PermissionRead
+	default:
+		return false
+	}
+}
+
+type APIKey struct {
+	Name      string     `json:"name"`
+	Role      APIKeyRole `json:"role"`
+	CreatedAt time.Time  `json:"createdAt"`
+	// Apps are patterns of names of applications the key is allowed to
+	// access, e.g. payments.*; all the applications if empty.
+	Apps []string `json:"apps,omitempty"`
+	// Tenant the key is bound to in multi-tenancy mode, if any.
+	Tenant string `json:"tenant,omitempty"`
+	// Hash is the hex-encoded SHA-256 hash of the key token.
+	Hash string `json:"hash"`
+}
+
+// AllowsApp reports whether the key is allowed to access the application.
+// Similarly to app retention, patterns are case-insensitive.
+func (k *APIKey) AllowsApp(appName string) bool {
+	if len(k.Apps) == 0 {
+		return true
+	}
+	name := strings.ToLower(appName)
+	for _, pattern := range k.Apps {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+type CreateAPIKeyInput struct {
+	Name   string
+	Role   APIKeyRole
+	Apps   []string
+	Tenant string
+}
+
+type apiKeys struct {
+	sync.RWMutex
+	// byHash maps token hashes to keys.
+	byHash map[string]*APIKey
+}
+
+// CreateAPIKey creates a new API key and returns it along with the key
+// token. The token can not be retrieved later.
+func (s *Storage) CreateAPIKey(in CreateAPIKeyInput) (*APIKey, string, error) {
+	if err := s.writable(); err != nil {
+		return nil, "", err
+	}
+	if !apiKeyNameRe.MatchString(in.Name) {
+		return nil, "", ErrAPIKeyInvalidName
+	}
+	if _, err := ParseAPIKeyRole(string(in.Role)); err != nil {
+		return nil, "", err
+	}
+	var apps []string
+	for _, pattern := range in.Apps {
+		pattern = strings.ToLower(pattern)
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return nil, "", fmt.Errorf("%w: %q", ErrAPIKeyInvalidApps, pattern)
+		}
+		apps = append(apps, pattern)
+	}
+	token, err := newAPIKeyToken()
+	if err != nil {
+		return nil, "", err
+	}
+	k := APIKey{
+		Name:      in.Name,
+		Role:      in.Role,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Apps:      apps,
+		Tenant:    in.Tenant,
+		Hash:      hashAPIKeyToken(token),
+	}
+	s.apiKeys.Lock()
+	defer s.apiKeys.Unlock()
+	for _, x := range s.apiKeys.byHash {
+		if x.Name == k.Name {
+			return nil, "", ErrAPIKeyExists
+		}
+	}
+	if err = s.saveJSON(apiKeysPrefix+k.Name, k); err != nil {
+		return nil, "", err
+	}
+	s.apiKeys.byHash[k.Hash] = &k
+	return &k, token, nil
+}
+
+// DeleteAPIKey deletes the API key with the given name.
+func (s *Storage) DeleteAPIKey(name string) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	s.apiKeys.Lock()
+	defer s.apiKeys.Unlock()
+	for h, x := range s.apiKeys.byHash {
+		if x.Name == name {
+			if err := s.main.Backend.Delete([]byte(apiKeysPrefix + name)); err != nil {
+				return err
+			}
+			delete(s.apiKeys.byHash, h)
+			return nil
+		}
+	}
+	return ErrAPIKeyNotFound
+}
+
+// APIKeys lists the API keys ordered by name.
+func (s *Storage) APIKeys() []APIKey {
+	s.apiKeys.RLock()
+	keys := make([]APIKey, 0, len(s.apiKeys.byHash))
+	for _, x := range s.apiKeys.byHash {
+		keys = append(keys, *x)
+	}
+	s.apiKeys.RUnlock()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Name < keys[j].Name
+	})
+	return keys
+}
+
+// AuthenticateAPIKey returns the API key the token belongs to.
+func (s *Storage) AuthenticateAPIKey(token string) (*APIKey, error) {
+	h := hashAPIKeyToken(token)
+	s.apiKeys.RLock()
+	defer s.apiKeys.RUnlock()
+	k, ok := s.apiKeys.byHash[h]
+	if !ok {
+		return nil, ErrAPIKeyInvalidToken
+	}
+	x := *k
+	return &x, nil
+}
+
+func (s *Storage) loadAPIKeys() error {
+	it := s.main.NewIterator(backend.IteratorOptions{
+		Prefix:         []byte(apiKeysPrefix),
+		PrefetchValues: true,
+	})
+	defer it.Close()
+	byHash := make(map[string]*APIKey)
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		var k APIKey
+		if err = json.Unmarshal(v, &k); err != nil {
+			s.logger.WithError(err).WithField("key", string(item.Key())).Warn("skipping malformed api key")
+			continue
+		}
+		byHash[k.Hash] = &k
+	}
+	s.apiKeys.Lock()
+	s.apiKeys.byHash = byHash
+	s.apiKeys.Unlock()
+	return nil
+}
+
+func newAPIKeyToken() (string, error) {
+	b := make([]byte, apiKeyTokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return apiKeyTokenPrefix + hex.EncodeToString(b), nil
+}
+
+func hashAPIKeyToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("API keys", func() {
+	var s *Storage
+
+	testing.WithConfig(func(cfg **config.Config) {
+		JustBeforeEach(func() {
+			var err error
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		JustAfterEach(func() {
+			Expect(s.Close()).To(Succeed())
+		})
+
+		It("creates, authenticates and deletes keys", func() {
+			k, token, err := s.CreateAPIKey(CreateAPIKeyInput{Name: "agent", Role: APIKeyRoleIngest})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(k.Hash).ToNot(ContainSubstring(token))
+			_, _, err = s.CreateAPIKey(CreateAPIKeyInput{Name: "agent", Role: APIKeyRoleAdmin})
+			Expect(err).To(MatchError(ErrAPIKeyExists))
+			_, _, err = s.CreateAPIKey(CreateAPIKeyInput{Name: "a b", Role: APIKeyRoleAdmin})
+			Expect(err).To(MatchError(ErrAPIKeyInvalidName))
+
+			// Keys are persisted.
+			Expect(s.loadAPIKeys()).To(Succeed())
+			a, err := s.AuthenticateAPIKey(token)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(a.Name).To(Equal("agent"))
+			Expect(a.Role.Allows(PermissionIngest)).To(BeTrue())
+			Expect(a.Role.Allows(PermissionRead)).To(BeFalse())
+			_, err = s.AuthenticateAPIKey(token + "x")
+			Expect(err).To(MatchError(ErrAPIKeyInvalidToken))
+
+			Expect(s.DeleteAPIKey("agent")).To(Succeed())
+			Expect(s.DeleteAPIKey("agent")).To(MatchError(ErrAPIKeyNotFound))
+			Expect(s.loadAPIKeys()).To(Succeed())
+			Expect(s.APIKeys()).To(BeEmpty())
+			_, err = s.AuthenticateAPIKey(token)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
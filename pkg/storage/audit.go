@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+)
+
+// Audit events are stored in the main database, keyed by the event time.
+// The log is append-only: events are never modified or removed by the
+// storage, including by retention.
+
+const (
+	auditPrefix = "audit:"
+	// auditRetentionKey holds retention settings recorded at the last
+	// start, which are compared with the current ones.
+	auditRetentionKey = "audit-retention"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditActionQuery           = "query"
+	AuditActionDeleteApp       = "app.delete"
+	AuditActionDeleteData      = "data.delete"
+	AuditActionCreateAPIKey    = "api-key.create"
+	AuditActionDeleteAPIKey    = "api-key.delete"
+	AuditActionChangeRetention = "retention.change"
+)
+
+type AuditEvent struct {
+	Time time.Time `json:"time"`
+	// Actor is the user name, the API key, or the component that
+	// performed the action.
+	Actor      string `json:"actor"`
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	// Tenant of the request in multi-tenancy mode.
+	Tenant string `json:"tenant,omitempty"`
+	Action string `json:"action"`
+	// App is the application name, or the query the action targets.
+	App string `json:"app,omitempty"`
+	// From and Until are the time range of the action, in unix seconds.
+	From    int64             `json:"from,omitempty"`
+	Until   int64             `json:"until,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+type auditLog struct {
+	sync.Mutex
+	// last is the time of the last event key, which
+	// is incremented to keep the keys unique.
+	last int64
+}
+
+// AppendAuditEvent records the event in the audit log. If the event
+// time is not set, the current time is used.
+func (s *Storage) AppendAuditEvent(e *AuditEvent) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	s.audit.Lock()
+	defer s.audit.Unlock()
+	t := e.Time.UnixNano()
+	if t <= s.audit.last {
+		t = s.audit.last + 1
+	}
+	if err := s.saveJSON(auditEventKey(t), e); err != nil {
+		return err
+	}
+	s.audit.last = t
+	return nil
+}
+
+// AuditEvents calls fn for the events recorded within the time range,
+// in chronological order. Zero time does not limit the range.
+func (s *Storage) AuditEvents(from, until time.Time, fn func(*AuditEvent) error) error {
+	it := s.main.NewIterator(backend.IteratorOptions{
+		Prefix:         []byte(auditPrefix),
+		PrefetchValues: true,
+	})
+	defer it.Close()
+	if from.IsZero() {
+		it.Rewind()
+	} else {
+		it.Seek([]byte(auditEventKey(from.UnixNano())))
+	}
+	var end []byte
+	if !until.IsZero() {
+		end = []byte(auditEventKey(until.UnixNano()))
+	}
+	for ; it.Valid(); it.Next() {
+		item := it.Item()
+		if end != nil && bytes.Compare(item.Key(), end) > 0 {
+			break
+		}
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		var e AuditEvent
+		if err = json.Unmarshal(v, &e); err != nil {
+			s.logger.WithError(err).WithField("key", string(item.Key())).Warn("skipping malformed audit event")
+			continue
+		}
+		if err = fn(&e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func auditEventKey(t int64) string {
+	return fmt.Sprintf("%s%020d", auditPrefix, t)
+}
+
+type retentionSettings struct {
+	Retention       string            `json:"retention"`
+	RetentionLevels []string          `json:"retentionLevels"`
+	AppRetention    map[string]string `json:"appRetention,omitempty"`
+}
+
+// auditRetention records a retention change event, if retention settings
+// differ from the ones the storage was started with previously.
+func (s *Storage) auditRetention() error {
+	s.retentionMutex.RLock()
+	c := retentionSettings{
+		Retention: s.config.retention.String(),
+		RetentionLevels: []string{
+			s.config.retentionLevels.Zero.String(),
+			s.config.retentionLevels.One.String(),
+			s.config.retentionLevels.Two.String(),
+		},
+		AppRetention: s.config.appRetention,
+	}
+	s.retentionMutex.RUnlock()
+	current, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	previous, err := s.main.Get([]byte(auditRetentionKey))
+	switch {
+	case err == nil:
+		if bytes.Equal(previous, current) {
+			return nil
+		}
+	case errors.Is(err, backend.ErrNotFound):
+	default:
+		return err
+	}
+	e := AuditEvent{
+		Actor:   "config",
+		Action:  AuditActionChangeRetention,
+		Details: map[string]string{"current": string(current)},
+	}
+	if previous != nil {
+		e.Details["previous"] = string(previous)
+	}
+	if err = s.AppendAuditEvent(&e); err != nil {
+		return err
+	}
+	return s.main.Set([]byte(auditRetentionKey), current)
+}
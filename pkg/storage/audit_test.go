@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("audit log", func() {
+	var s *Storage
+
+	testing.WithConfig(func(cfg **config.Config) {
+		open := func() {
+			var err error
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		events := func(from, until time.Time) []AuditEvent {
+			var list []AuditEvent
+			Expect(s.AuditEvents(from, until, func(e *AuditEvent) error {
+				list = append(list, *e)
+				return nil
+			})).To(Succeed())
+			return list
+		}
+
+		It("returns events within the time range in order", func() {
+			open()
+			defer s.Close()
+			t := time.Now().Truncate(time.Second)
+			for i := 0; i < 3; i++ {
+				Expect(s.AppendAuditEvent(&AuditEvent{
+					Time:   t.Add(time.Duration(i) * time.Minute),
+					Actor:  "alice",
+					Action: AuditActionQuery,
+				})).To(Succeed())
+			}
+			// Events recorded at the same time are not overwritten.
+			Expect(s.AppendAuditEvent(&AuditEvent{Time: t.Add(2 * time.Minute), Actor: "bob", Action: AuditActionQuery})).To(Succeed())
+
+			Expect(events(time.Time{}, time.Time{})).To(HaveLen(4))
+			list := events(t.Add(30*time.Second), time.Time{})
+			Expect(list).To(HaveLen(3))
+			Expect(list[0].Time).To(BeTemporally("==", t.Add(time.Minute)))
+			Expect(list[2].Actor).To(Equal("bob"))
+			Expect(events(time.Time{}, t.Add(time.Second))).To(HaveLen(1))
+		})
+
+		It("records retention changes", func() {
+			(*cfg).Server.AuditLog = true
+			(*cfg).Server.Retention = time.Hour
+			open()
+			list := events(time.Time{}, time.Time{})
+			Expect(list).To(HaveLen(1))
+			Expect(list[0].Action).To(Equal(AuditActionChangeRetention))
+			Expect(list[0].Details).ToNot(HaveKey("previous"))
+			Expect(s.Close()).To(Succeed())
+
+			open()
+			Expect(events(time.Time{}, time.Time{})).To(HaveLen(1))
+			Expect(s.Close()).To(Succeed())
+
+			(*cfg).Server.Retention = 2 * time.Hour
+			open()
+			defer s.Close()
+			list = events(time.Time{}, time.Time{})
+			Expect(list).To(HaveLen(2))
+			Expect(list[1].Details).To(HaveKey("previous"))
+			Expect(list[1].Details["current"]).To(ContainSubstring("2h0m0s"))
+		})
+	})
+})
@@ -0,0 +1,163 @@
+// Package backend defines the key-value store interface storage
+// databases are built on, and provides its implementations.
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNotFound is returned when the requested key does not exist.
+var ErrNotFound = errors.New("key not found")
+
+// ErrNotSupported is returned when the backend does not
+// implement the operation.
+var ErrNotSupported = errors.New("operation is not supported by the backend")
+
+// Backend is an ordered key-value store. Implementations must be safe
+// for concurrent use.
+type Backend interface {
+	// Get returns a copy of the value stored under the key.
+	// ErrNotFound is returned if the key does not exist.
+	Get(key []byte) ([]byte, error)
+	Set(key, value []byte) error
+	// Delete removes the key. If the key does not exist,
+	// no error is returned.
+	Delete(key []byte) error
+	// DropPrefix removes all the keys with the given prefix.
+	DropPrefix(prefix []byte) error
+
+	// NewIterator returns an iterator over a consistent snapshot of the
+	// store. The iterator must be closed after use.
+	NewIterator(IteratorOptions) Iterator
+	NewWriteBatch() WriteBatch
+	// MaxBatchCount returns the number of operations a write batch
+	// should be flushed after.
+	MaxBatchCount() int64
+
+	// Size returns the approximate size of the index and values
+	// in bytes.
+	Size() (index, values int64)
+	// GC reclaims space occupied by deleted and overwritten values,
+	// if possible. A call rewrites at most one value log file and
+	// reports whether any space was reclaimed: it is to be repeated
+	// until no more space can be reclaimed.
+	GC(discardRatio float64) (bool, error)
+	// Flatten merges the levels of the LSM tree, if applicable.
+	Flatten() error
+
+	// Dump writes the entries modified since the given version,
+	// deletions included, and returns the version the next dump
+	// is to start from.
+	Dump(w io.Writer, since uint64) (uint64, error)
+	// Load applies the entries written with Dump.
+	Load(r io.Reader) error
+	Close() error
+}
+
+type IteratorOptions struct {
+	// Prefix limits the iteration to keys with the prefix.
+	Prefix []byte
+	// PrefetchValues is a hint that values are to be read.
+	PrefetchValues bool
+	// AllVersions requests all versions of the keys to be visited,
+	// including deleted ones. Backends that do not keep versions
+	// ignore the option.
+	AllVersions bool
+}
+
+// Iterator iterates over keys in the lexicographical order.
+type Iterator interface {
+	// Rewind positions the iterator at the first key.
+	Rewind()
+	// Seek positions the iterator at the first key that is
+	// greater than or equal to the given one.
+	Seek(key []byte)
+	Valid() bool
+	Next()
+	Item() Item
+	Close()
+}
+
+// Item is an iterator item. The item is only valid until Next is called.
+type Item interface {
+	// Key returns the item key. The slice must not be modified or
+	// retained: use KeyCopy to take a copy.
+	Key() []byte
+	KeyCopy(dst []byte) []byte
+	ValueCopy(dst []byte) ([]byte, error)
+	// EstimatedSize returns the approximate size of the item
+	// in the store.
+	EstimatedSize() int64
+}
+
+// WriteBatch accumulates writes and applies them on Flush.
+// Cancel must be called if the batch is not flushed.
+type WriteBatch interface {
+	Set(key, value []byte) error
+	Delete(key []byte) error
+	Flush() error
+	Cancel()
+}
+
+// Options are passed to the backend factory.
+type Options struct {
+	// Name of the database, e.g. "trees".
+	Name string
+	// Path to the directory the database is to be stored at.
+	Path string
+	// InMemory specifies that the data is not to be persisted.
+	InMemory bool
+	// NoTruncate prevents truncation of corrupted data on open.
+	NoTruncate bool
+	Logger     logrus.FieldLogger
+	// Badger options are only used by the badger backend.
+	Badger BadgerOptions
+}
+
+// Factory opens a backend with the given options.
+type Factory func(Options) (Backend, error)
+
+var (
+	factoriesMutex sync.RWMutex
+	factories      = make(map[string]Factory)
+)
+
+// Register makes the backend available by its name. Register panics
+// if a backend with the same name is already registered.
+func Register(name string, f Factory) {
+	factoriesMutex.Lock()
+	defer factoriesMutex.Unlock()
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("storage backend %q is already registered", name))
+	}
+	factories[name] = f
+}
+
+// Open opens a backend registered with the name.
+func Open(name string, o Options) (Backend, error) {
+	factoriesMutex.RLock()
+	f, ok := factories[name]
+	factoriesMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown storage backend %q, supported backends: %v", name, Names())
+	}
+	return f(o)
+}
+
+// Names returns the sorted list of registered backends.
+func Names() []string {
+	factoriesMutex.RLock()
+	defer factoriesMutex.RUnlock()
+	names := make([]string, 0, len(factories))
+	for n := range factories {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,169 @@
+package backend_test
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("backend", func() {
+	for _, name := range backend.Names() {
+		name := name
+		Context(name, func() {
+			var (
+				b    backend.Backend
+				tdir *testing.TmpDirectory
+			)
+
+			BeforeEach(func() {
+				tdir = testing.TmpDirSync()
+				var err error
+				b, err = backend.Open(name, backend.Options{Name: "test", Path: tdir.Path})
+				Expect(err).ToNot(HaveOccurred())
+			})
+
+			AfterEach(func() {
+				Expect(b.Close()).To(Succeed())
+				tdir.Close()
+			})
+
+			keys := func(o backend.IteratorOptions, seek []byte) []string {
+				it := b.NewIterator(o)
+				defer it.Close()
+				var r []string
+				if seek != nil {
+					it.Seek(seek)
+				} else {
+					it.Rewind()
+				}
+				for ; it.Valid(); it.Next() {
+					r = append(r, string(it.Item().KeyCopy(nil)))
+				}
+				return r
+			}
+
+			It("reads and writes values", func() {
+				_, err := b.Get([]byte("foo"))
+				Expect(err).To(MatchError(backend.ErrNotFound))
+				Expect(b.Set([]byte("foo"), []byte("bar"))).To(Succeed())
+				v, err := b.Get([]byte("foo"))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(v)).To(Equal("bar"))
+				Expect(b.Delete([]byte("foo"))).To(Succeed())
+				Expect(b.Delete([]byte("foo"))).To(Succeed())
+				_, err = b.Get([]byte("foo"))
+				Expect(err).To(MatchError(backend.ErrNotFound))
+			})
+
+			It("iterates over keys in order", func() {
+				for _, k := range []string{"b:2", "a:1", "b:1", "c:1", "b:3"} {
+					Expect(b.Set([]byte(k), []byte(k))).To(Succeed())
+				}
+				Expect(keys(backend.IteratorOptions{}, nil)).To(Equal([]string{"a:1", "b:1", "b:2", "b:3", "c:1"}))
+				Expect(keys(backend.IteratorOptions{Prefix: []byte("b:")}, nil)).To(Equal([]string{"b:1", "b:2", "b:3"}))
+				Expect(keys(backend.IteratorOptions{Prefix: []byte("b:")}, []byte("b:2"))).To(Equal([]string{"b:2", "b:3"}))
+
+				it := b.NewIterator(backend.IteratorOptions{Prefix: []byte("c:"), PrefetchValues: true})
+				defer it.Close()
+				it.Rewind()
+				Expect(it.Valid()).To(BeTrue())
+				v, err := it.Item().ValueCopy(nil)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(v)).To(Equal("c:1"))
+			})
+
+			It("drops keys by prefix", func() {
+				for _, k := range []string{"a:1", "b:1", "b:2"} {
+					Expect(b.Set([]byte(k), nil)).To(Succeed())
+				}
+				Expect(b.DropPrefix([]byte("b:"))).To(Succeed())
+				Expect(keys(backend.IteratorOptions{}, nil)).To(Equal([]string{"a:1"}))
+			})
+
+			It("applies write batches on flush", func() {
+				Expect(b.Set([]byte("a"), []byte("1"))).To(Succeed())
+				batch := b.NewWriteBatch()
+				Expect(batch.Set([]byte("b"), []byte("2"))).To(Succeed())
+				Expect(batch.Delete([]byte("a"))).To(Succeed())
+				Expect(batch.Flush()).To(Succeed())
+				batch.Cancel()
+				Expect(keys(backend.IteratorOptions{}, nil)).To(Equal([]string{"b"}))
+
+				batch = b.NewWriteBatch()
+				Expect(batch.Set([]byte("c"), []byte("3"))).To(Succeed())
+				batch.Cancel()
+				Expect(keys(backend.IteratorOptions{}, nil)).To(Equal([]string{"b"}))
+			})
+		})
+	}
+
+	It("fails to open unknown backend", func() {
+		_, err := backend.Open("foo", backend.Options{})
+		Expect(err).To(MatchError(`unknown storage backend "foo", supported backends: [badger memory]`))
+	})
+
+	It("applies badger options", func() {
+		tdir := testing.TmpDirSync()
+		defer tdir.Close()
+		o := backend.Options{Name: "test", Path: tdir.Path, Badger: backend.BadgerOptions{
+			BlockCacheSize:   1 << 20,
+			IndexCacheSize:   1 << 20,
+			ValueLogFileSize: 1 << 20,
+			Compression:      "snappy",
+			NumCompactors:    3,
+		}}
+		b, err := backend.Open(backend.Badger, o)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(b.Set([]byte("foo"), []byte("bar"))).To(Succeed())
+		Expect(b.Close()).To(Succeed())
+
+		o.Badger.Compression = "lz4"
+		_, err = backend.Open(backend.Badger, o)
+		Expect(err).To(MatchError(`unknown compression "lz4": should be one of none, snappy, zstd`))
+		o.Badger.Compression = ""
+		o.Badger.NumCompactors = 1
+		_, err = backend.Open(backend.Badger, o)
+		Expect(err).To(HaveOccurred())
+	})
+	It("loads incremental badger dumps", func() {
+		src, dst := testing.TmpDirSync(), testing.TmpDirSync()
+		defer src.Close()
+		defer dst.Close()
+		a, err := backend.Open(backend.Badger, backend.Options{Name: "a", Path: src.Path})
+		Expect(err).ToNot(HaveOccurred())
+		defer a.Close()
+		b, err := backend.Open(backend.Badger, backend.Options{Name: "b", Path: dst.Path})
+		Expect(err).ToNot(HaveOccurred())
+		defer b.Close()
+
+		ship := func(since uint64) uint64 {
+			var buf bytes.Buffer
+			v, err := a.Dump(&buf, since)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b.Load(&buf)).To(Succeed())
+			return v
+		}
+
+		Expect(a.Set([]byte("foo"), []byte("1"))).To(Succeed())
+		Expect(a.Set([]byte("bar"), []byte("1"))).To(Succeed())
+		v := ship(0)
+		Expect(ship(v)).To(Equal(v))
+
+		Expect(a.Set([]byte("foo"), []byte("2"))).To(Succeed())
+		Expect(a.Delete([]byte("bar"))).To(Succeed())
+		var buf bytes.Buffer
+		_, err = a.Dump(&buf, v)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(b.Load(&buf)).To(Succeed())
+
+		r, err := b.Get([]byte("foo"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(r)).To(Equal("2"))
+		_, err = b.Get([]byte("bar"))
+		Expect(err).To(MatchError(backend.ErrNotFound))
+	})
+})
@@ -0,0 +1,216 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/options"
+)
+
+// Badger is the name of the default backend built on BadgerDB.
+const Badger = "badger"
+
+func init() { Register(Badger, OpenBadger) }
+
+type badgerBackend struct{ db *badger.DB }
+
+// BadgerOptions tune BadgerDB. Zero value of ValueLogFileSize,
+// Compression, and NumCompactors mean the defaults.
+type BadgerOptions struct {
+	// BlockCacheSize is the size of the cache of decompressed blocks.
+	// 0 disables the cache.
+	BlockCacheSize int64
+	// IndexCacheSize is the size of the cache of table indices. 0 means
+	// all the indices are kept in memory.
+	IndexCacheSize   int64
+	ValueLogFileSize int64
+	// Compression is one of none, snappy, zstd.
+	Compression   string
+	NumCompactors int
+	// EncryptionKey is the AES key data is encrypted with; empty means
+	// no encryption. Data is encrypted with data keys rotated at the
+	// EncryptionKeyRotationDuration, which are encrypted with the key.
+	EncryptionKey                 []byte
+	EncryptionKeyRotationDuration time.Duration
+}
+
+// ErrEncryptionKeyMismatch is returned if the database is not
+// encrypted with the key.
+var ErrEncryptionKeyMismatch = errors.New("encryption key mismatch")
+
+// ParseCompression returns the BadgerDB compression type by its name.
+func ParseCompression(name string) (options.CompressionType, error) {
+	switch name {
+	case "", "zstd":
+		return options.ZSTD, nil
+	case "snappy":
+		return options.Snappy, nil
+	case "none":
+		return options.None, nil
+	default:
+		return 0, fmt.Errorf("unknown compression %q: should be one of none, snappy, zstd", name)
+	}
+}
+
+// OpenBadger opens BadgerDB database with the given options.
+func OpenBadger(o Options) (Backend, error) {
+	var opts badger.Options
+	if o.InMemory {
+		opts = badger.DefaultOptions("").WithInMemory(true)
+	} else {
+		compression, err := ParseCompression(o.Badger.Compression)
+		if err != nil {
+			return nil, err
+		}
+		opts = badger.DefaultOptions(o.Path).
+			WithTruncate(!o.NoTruncate).
+			WithSyncWrites(false).
+			WithCompactL0OnClose(false).
+			WithCompression(compression)
+		if o.Badger.ValueLogFileSize > 0 {
+			opts = opts.WithValueLogFileSize(o.Badger.ValueLogFileSize)
+		}
+		if len(o.Badger.EncryptionKey) > 0 {
+			opts = opts.WithEncryptionKey(o.Badger.EncryptionKey)
+			if o.Badger.EncryptionKeyRotationDuration > 0 {
+				opts = opts.WithEncryptionKeyRotationDuration(o.Badger.EncryptionKeyRotationDuration)
+			}
+		}
+	}
+	opts = opts.
+		WithBlockCacheSize(o.Badger.BlockCacheSize).
+		WithIndexCacheSize(o.Badger.IndexCacheSize)
+	if o.Badger.NumCompactors > 0 {
+		opts = opts.WithNumCompactors(o.Badger.NumCompactors)
+	}
+	if o.Logger != nil {
+		opts = opts.WithLogger(o.Logger)
+	}
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, badgerError(err)
+	}
+	return &badgerBackend{db: db}, nil
+}
+
+// RotateBadgerEncryptionKey re-encrypts data keys of the database at
+// the path with the new key. Either key may be empty, which means no
+// encryption. The database must not be open.
+func RotateBadgerEncryptionKey(path string, oldKey, newKey []byte) error {
+	opts := badger.KeyRegistryOptions{
+		Dir:           path,
+		ReadOnly:      true,
+		EncryptionKey: oldKey,
+	}
+	kr, err := badger.OpenKeyRegistry(opts)
+	if err != nil {
+		return badgerError(err)
+	}
+	defer kr.Close()
+	opts.EncryptionKey = newKey
+	return badgerError(badger.WriteKeyRegistry(kr, opts))
+}
+
+func badgerError(err error) error {
+	if errors.Is(err, badger.ErrEncryptionKeyMismatch) {
+		return ErrEncryptionKeyMismatch
+	}
+	return err
+}
+
+func (b *badgerBackend) Get(key []byte) ([]byte, error) {
+	var v []byte
+	err := b.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+		v, err = item.ValueCopy(nil)
+		return err
+	})
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, ErrNotFound
+	}
+	return v, err
+}
+
+func (b *badgerBackend) Set(key, value []byte) error {
+	return b.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(key, value)
+	})
+}
+
+func (b *badgerBackend) Delete(key []byte) error {
+	return b.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(key)
+	})
+}
+
+func (b *badgerBackend) DropPrefix(prefix []byte) error { return b.db.DropPrefix(prefix) }
+
+func (b *badgerBackend) NewIterator(o IteratorOptions) Iterator {
+	txn := b.db.NewTransaction(false)
+	return &badgerIterator{
+		txn: txn,
+		it: txn.NewIterator(badger.IteratorOptions{
+			Prefix:         o.Prefix,
+			PrefetchValues: o.PrefetchValues,
+			PrefetchSize:   badger.DefaultIteratorOptions.PrefetchSize,
+			AllVersions:    o.AllVersions,
+		}),
+	}
+}
+
+func (b *badgerBackend) NewWriteBatch() WriteBatch { return b.db.NewWriteBatch() }
+
+func (b *badgerBackend) MaxBatchCount() int64 { return b.db.MaxBatchCount() }
+
+func (b *badgerBackend) Size() (index, values int64) { return b.db.Size() }
+
+func (b *badgerBackend) GC(discardRatio float64) (reclaimed bool, err error) {
+	switch err = b.db.RunValueLogGC(discardRatio); err {
+	case nil:
+		return true, nil
+	case badger.ErrNoRewrite:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func (b *badgerBackend) Flatten() error { return b.db.Flatten(1) }
+
+func (b *badgerBackend) Dump(w io.Writer, since uint64) (uint64, error) {
+	v, err := b.db.Backup(w, since)
+	if err != nil || v < since {
+		// Nothing has been written.
+		return since, err
+	}
+	return v + 1, nil
+}
+
+// maxPendingWrites limits the memory used by Load.
+const maxPendingWrites = 256
+
+func (b *badgerBackend) Load(r io.Reader) error { return b.db.Load(r, maxPendingWrites) }
+
+func (b *badgerBackend) Close() error { return b.db.Close() }
+
+type badgerIterator struct {
+	txn *badger.Txn
+	it  *badger.Iterator
+}
+
+func (i *badgerIterator) Rewind()         { i.it.Rewind() }
+func (i *badgerIterator) Seek(key []byte) { i.it.Seek(key) }
+func (i *badgerIterator) Valid() bool     { return i.it.Valid() }
+func (i *badgerIterator) Next()           { i.it.Next() }
+func (i *badgerIterator) Item() Item      { return i.it.Item() }
+
+func (i *badgerIterator) Close() {
+	i.it.Close()
+	i.txn.Discard()
+}
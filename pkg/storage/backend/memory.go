@@ -0,0 +1,169 @@
+package backend
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Memory is the name of the backend keeping data in a map. The backend
+// is not persistent and is mostly useful for testing.
+const Memory = "memory"
+
+func init() { Register(Memory, OpenMemory) }
+
+type memoryBackend struct {
+	mutex sync.RWMutex
+	data  map[string][]byte
+}
+
+// OpenMemory returns a new in-memory backend. Options are ignored.
+func OpenMemory(Options) (Backend, error) {
+	return &memoryBackend{data: make(map[string][]byte)}, nil
+}
+
+func (m *memoryBackend) Get(key []byte) ([]byte, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	v, ok := m.data[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, v...), nil
+}
+
+func (m *memoryBackend) Set(key, value []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.data[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (m *memoryBackend) Delete(key []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.data, string(key))
+	return nil
+}
+
+func (m *memoryBackend) DropPrefix(prefix []byte) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	p := string(prefix)
+	for k := range m.data {
+		if strings.HasPrefix(k, p) {
+			delete(m.data, k)
+		}
+	}
+	return nil
+}
+
+func (m *memoryBackend) NewIterator(o IteratorOptions) Iterator {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	p := string(o.Prefix)
+	it := new(memoryIterator)
+	for k, v := range m.data {
+		if strings.HasPrefix(k, p) {
+			// Values are never modified in place,
+			// therefore the snapshot is consistent.
+			it.items = append(it.items, memoryItem{key: []byte(k), value: v})
+		}
+	}
+	sort.Slice(it.items, func(i, j int) bool {
+		return bytes.Compare(it.items[i].key, it.items[j].key) < 0
+	})
+	return it
+}
+
+func (m *memoryBackend) NewWriteBatch() WriteBatch { return &memoryBatch{m: m} }
+
+func (*memoryBackend) MaxBatchCount() int64 { return 10000 }
+
+func (m *memoryBackend) Size() (index, values int64) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	for k, v := range m.data {
+		index += int64(len(k))
+		values += int64(len(v))
+	}
+	return index, values
+}
+
+func (*memoryBackend) GC(float64) (bool, error) { return false, nil }
+
+func (*memoryBackend) Flatten() error { return nil }
+
+func (*memoryBackend) Dump(io.Writer, uint64) (uint64, error) { return 0, ErrNotSupported }
+
+func (*memoryBackend) Load(io.Reader) error { return ErrNotSupported }
+
+func (*memoryBackend) Close() error { return nil }
+
+type memoryIterator struct {
+	items []memoryItem
+	pos   int
+}
+
+func (i *memoryIterator) Rewind() { i.pos = 0 }
+
+func (i *memoryIterator) Seek(key []byte) {
+	i.pos = sort.Search(len(i.items), func(n int) bool {
+		return bytes.Compare(i.items[n].key, key) >= 0
+	})
+}
+
+func (i *memoryIterator) Valid() bool { return i.pos < len(i.items) }
+func (i *memoryIterator) Next()       { i.pos++ }
+func (i *memoryIterator) Item() Item  { return &i.items[i.pos] }
+func (i *memoryIterator) Close()      {}
+
+type memoryItem struct {
+	key   []byte
+	value []byte
+}
+
+func (i *memoryItem) Key() []byte               { return i.key }
+func (i *memoryItem) KeyCopy(dst []byte) []byte { return append(dst[:0], i.key...) }
+func (i *memoryItem) EstimatedSize() int64      { return int64(len(i.key) + len(i.value)) }
+func (i *memoryItem) ValueCopy(dst []byte) ([]byte, error) {
+	return append(dst[:0], i.value...), nil
+}
+
+type memoryBatch struct {
+	m   *memoryBackend
+	ops []memoryBatchOp
+}
+
+type memoryBatchOp struct {
+	key, value []byte
+	delete     bool
+}
+
+func (b *memoryBatch) Set(key, value []byte) error {
+	b.ops = append(b.ops, memoryBatchOp{key: append([]byte{}, key...), value: append([]byte{}, value...)})
+	return nil
+}
+
+func (b *memoryBatch) Delete(key []byte) error {
+	b.ops = append(b.ops, memoryBatchOp{key: append([]byte{}, key...), delete: true})
+	return nil
+}
+
+func (b *memoryBatch) Flush() error {
+	b.m.mutex.Lock()
+	defer b.m.mutex.Unlock()
+	for _, op := range b.ops {
+		if op.delete {
+			delete(b.m.data, string(op.key))
+		} else {
+			b.m.data[string(op.key)] = op.value
+		}
+	}
+	b.ops = nil
+	return nil
+}
+
+func (b *memoryBatch) Cancel() { b.ops = nil }
@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+)
+
+// A backup is a tar stream of key-value records of all the databases.
+// Records of a database are split into entries named <db>/<n>; a record
+// consists of the uvarint key length, the key, the uvarint value length,
+// and the value.
+//
+// Trees offloaded to object storage are backed up as references to the
+// blocks: the restored storage must use the same object storage.
+
+const backupEntrySize = 32 << 20
+
+// ErrStorageNotEmpty is returned when restoring into a storage
+// that already contains data.
+var ErrStorageNotEmpty = errors.New("storage is not empty")
+
+// Backup writes a consistent snapshot of the storage to w.
+func (s *Storage) Backup(w io.Writer) error {
+	dbs := s.databases()
+	iterators := make([]backend.Iterator, len(dbs))
+	// Ingestion and maintenance tasks are suspended until the iterators
+	// are created, so that the snapshot is consistent across databases.
+	s.tasksMutex.Lock()
+	s.putMutex.Lock()
+	s.writeBack()
+	for i, d := range dbs {
+		iterators[i] = d.NewIterator(backend.IteratorOptions{PrefetchValues: true})
+	}
+	s.putMutex.Unlock()
+	s.tasksMutex.Unlock()
+	defer func() {
+		for _, it := range iterators {
+			it.Close()
+		}
+	}()
+
+	tw := tar.NewWriter(w)
+	for i, d := range dbs {
+		if err := backupDB(tw, d.name, iterators[i]); err != nil {
+			return fmt.Errorf("backup %s: %w", d.name, err)
+		}
+	}
+	return tw.Close()
+}
+
+func backupDB(tw *tar.Writer, name string, it backend.Iterator) error {
+	var (
+		buf bytes.Buffer
+		n   int
+		l   [binary.MaxVarintLen64]byte
+	)
+	flush := func() error {
+		err := tw.WriteHeader(&tar.Header{
+			Name:    fmt.Sprintf("%s/%d", name, n),
+			Mode:    0o644,
+			Size:    int64(buf.Len()),
+			ModTime: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		if _, err = buf.WriteTo(tw); err != nil {
+			return err
+		}
+		n++
+		return nil
+	}
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		k := item.Key()
+		buf.Write(l[:binary.PutUvarint(l[:], uint64(len(k)))])
+		buf.Write(k)
+		buf.Write(l[:binary.PutUvarint(l[:], uint64(len(v)))])
+		buf.Write(v)
+		if buf.Len() >= backupEntrySize {
+			if err = flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if buf.Len() > 0 || n == 0 {
+		return flush()
+	}
+	return nil
+}
+
+// Restore loads the backup written with Backup. The storage must be empty.
+func (s *Storage) Restore(r io.Reader) error {
+	if len(s.GetAppNames()) > 0 {
+		return ErrStorageNotEmpty
+	}
+	dbs := make(map[string]*db)
+	for _, d := range s.databases() {
+		dbs[d.name] = d
+	}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		switch {
+		case errors.Is(err, io.EOF):
+			return nil
+		case err != nil:
+			return err
+		}
+		name := path.Dir(h.Name)
+		d, ok := dbs[name]
+		if !ok {
+			return fmt.Errorf("restore: unknown database %q", name)
+		}
+		if err = restoreDB(d, bufio.NewReader(tr), h.Size); err != nil {
+			return fmt.Errorf("restore %s: %w", name, err)
+		}
+	}
+}
+
+func restoreDB(d *db, r *bufio.Reader, size int64) error {
+	batchSize := d.MaxBatchCount()
+	batch := d.NewWriteBatch()
+	defer func() {
+		batch.Cancel()
+	}()
+	for n := int64(1); ; n++ {
+		k, err := readRecordField(r, size)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		v, err := readRecordField(r, size)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		if err = batch.Set(k, v); err != nil {
+			return err
+		}
+		if n%batchSize == 0 {
+			if err = batch.Flush(); err != nil {
+				return err
+			}
+			batch = d.NewWriteBatch()
+		}
+	}
+	return batch.Flush()
+}
+
+func readRecordField(r *bufio.Reader, size int64) ([]byte, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(size) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, n)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"bytes"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("backup", func() {
+	var s *Storage
+
+	testing.WithConfig(func(cfg **config.Config) {
+		JustBeforeEach(func() {
+			var err error
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		JustAfterEach(func() {
+			Expect(s.Close()).To(Succeed())
+		})
+
+		It("restores the backup", func() {
+			k, err := segment.ParseKey("app.cpu{foo=bar}")
+			Expect(err).ToNot(HaveOccurred())
+			t := tree.New()
+			t.Insert([]byte("a;b"), uint64(1))
+			t.Insert([]byte("a;c"), uint64(2))
+			st := time.Now().Add(-time.Hour).Truncate(10 * time.Second)
+			Expect(s.Put(&PutInput{
+				StartTime:  st,
+				EndTime:    st.Add(10 * time.Second),
+				Key:        k,
+				Val:        t,
+				SpyName:    "testspy",
+				SampleRate: 100,
+			})).To(Succeed())
+
+			var buf bytes.Buffer
+			Expect(s.Backup(&buf)).To(Succeed())
+
+			c := NewConfig(&config.Server{MaxNodesSerialization: 2048}).WithInMemory().WithBackend(backend.Memory)
+			r, err := New(c, logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+			defer r.Close()
+			Expect(r.Restore(bytes.NewReader(buf.Bytes()))).To(Succeed())
+
+			Expect(r.GetAppNames()).To(Equal([]string{"app.cpu"}))
+			o, err := r.Get(&GetInput{StartTime: st, EndTime: st.Add(10 * time.Second), Key: k})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(o).ToNot(BeNil())
+			Expect(o.Tree.String()).To(Equal(t.String()))
+			Expect(o.SpyName).To(Equal("testspy"))
+
+			Expect(r.Restore(bytes.NewReader(buf.Bytes()))).To(MatchError(ErrStorageNotEmpty))
+		})
+	})
+})
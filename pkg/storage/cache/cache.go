@@ -5,41 +5,62 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/dgraph-io/badger/v2"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/valyala/bytebufferpool"
 
-	"github.com/pyroscope-io/pyroscope/pkg/storage/cache/lfu"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/cache/lru"
 )
 
 type Cache struct {
-	db      *badger.DB
-	lfu     *lfu.Cache
+	db      backend.Backend
+	lru     *lru.Cache
 	metrics *Metrics
 	codec   Codec
 
 	prefix string
 	ttl    time.Duration
 
+	// Evicted items that are not saved yet. Cache misses must
+	// be served from here, otherwise the items would be lost.
+	pendingMutex sync.Mutex
+	pending      map[string]*pendingEviction
+
+	// Held for reading while an item is loaded from or saved to
+	// the database, see Exclusively.
+	ioMutex sync.RWMutex
+
 	evictionsDone chan struct{}
 	writeBackDone chan struct{}
 	flushOnce     sync.Once
 }
 
+type pendingEviction struct {
+	value interface{}
+	n     int
+}
+
 type Config struct {
-	*badger.DB
+	backend.Backend
 	*Metrics
 	Codec
 
-	// Prefix for badger DB keys.
+	// Prefix for database keys.
 	Prefix string
 	// TTL specifies number of seconds an item can reside in cache after
 	// the last access. An obsolete item is evicted. Setting TTL to less
 	// than a second disables time-based eviction.
 	TTL time.Duration
+	// MaxSize specifies the maximum total size of the items in bytes:
+	// once exceeded, least recently used items are evicted. The most
+	// recently used item is kept even if it exceeds the limit alone,
+	// therefore the size may exceed MaxSize by up to the largest item.
+	// The codec must implement Sizer. Zero means no limit.
+	MaxSize int64
 }
 
 // Codec is a shorthand of coder-decoder. A Codec implementation
@@ -53,6 +74,12 @@ type Codec interface {
 	New(key string) interface{}
 }
 
+// Sizer is implemented by codecs of caches with MaxSize set.
+type Sizer interface {
+	// Size returns an estimate of the memory occupied by the value.
+	Size(key string, value interface{}) int64
+}
+
 type Metrics struct {
 	MissesCounter     prometheus.Counter
 	ReadsCounter      prometheus.Counter
@@ -60,27 +87,40 @@ type Metrics struct {
 	DBReads           prometheus.Observer
 	WriteBackDuration prometheus.Observer
 	EvictionsDuration prometheus.Observer
+	// SizeEvictionsCounter counts items evicted because
+	// the cache exceeds MaxSize.
+	SizeEvictionsCounter prometheus.Counter
 }
 
 func New(c Config) *Cache {
 	cache := &Cache{
-		lfu:           lfu.New(),
-		db:            c.DB,
+		lru:           lru.New(),
+		db:            c.Backend,
 		codec:         c.Codec,
 		metrics:       c.Metrics,
 		prefix:        c.Prefix,
 		ttl:           c.TTL,
+		pending:       make(map[string]*pendingEviction),
 		evictionsDone: make(chan struct{}),
 		writeBackDone: make(chan struct{}),
 	}
 
-	evictionChannel := make(chan lfu.Eviction)
-	writeBackChannel := make(chan lfu.Eviction)
+	evictionChannel := make(chan lru.Eviction)
+	writeBackChannel := make(chan lru.Eviction)
 
 	// eviction channel for saving cache items to disk
-	cache.lfu.EvictionChannel = evictionChannel
-	cache.lfu.WriteBackChannel = writeBackChannel
-	cache.lfu.TTL = int64(c.TTL.Seconds())
+	cache.lru.EvictionChannel = evictionChannel
+	cache.lru.WriteBackChannel = writeBackChannel
+	cache.lru.OnEviction = cache.evicting
+	cache.lru.TTL = int64(c.TTL.Seconds())
+
+	if sizer, ok := c.Codec.(Sizer); ok && c.MaxSize > 0 {
+		cache.lru.MaxSize = c.MaxSize
+		cache.lru.SizeOf = sizer.Size
+		if c.Metrics.SizeEvictionsCounter != nil {
+			cache.lru.OnSizeEviction = c.Metrics.SizeEvictionsCounter.Inc
+		}
+	}
 
 	// start a goroutine for saving the evicted cache items to disk
 	go func() {
@@ -92,6 +132,7 @@ func New(c Config) *Cache {
 			//  Also, WriteBack and Evict could be combined. We also could
 			//  consider moving caching to storage/db.
 			cache.saveToDisk(e.Key, e.Value)
+			cache.evicted(e.Key)
 		}
 		close(cache.evictionsDone)
 	}()
@@ -99,6 +140,10 @@ func New(c Config) *Cache {
 	// start a goroutine for saving the evicted cache items to disk
 	go func() {
 		for e := range writeBackChannel {
+			if b, ok := e.Value.(writeBackBarrier); ok {
+				close(b)
+				continue
+			}
 			cache.saveToDisk(e.Key, e.Value)
 		}
 		close(cache.writeBackDone)
@@ -108,29 +153,69 @@ func New(c Config) *Cache {
 }
 
 func (cache *Cache) Put(key string, val interface{}) {
-	cache.lfu.Set(key, val)
+	cache.lru.Set(key, val)
+}
+
+// evicting is called by lru before an item is sent to the eviction
+// channel, and evicted is called once the item is saved.
+func (cache *Cache) evicting(e lru.Eviction) {
+	cache.pendingMutex.Lock()
+	defer cache.pendingMutex.Unlock()
+	p, ok := cache.pending[e.Key]
+	if !ok {
+		p = new(pendingEviction)
+		cache.pending[e.Key] = p
+	}
+	// Evictions are saved in order: the last one is the most recent.
+	p.value = e.Value
+	p.n++
+}
+
+func (cache *Cache) evicted(key string) {
+	cache.pendingMutex.Lock()
+	defer cache.pendingMutex.Unlock()
+	if p, ok := cache.pending[key]; ok {
+		if p.n--; p.n == 0 {
+			delete(cache.pending, key)
+		}
+	}
+}
+
+func (cache *Cache) lookupPending(key string) (interface{}, bool) {
+	cache.pendingMutex.Lock()
+	defer cache.pendingMutex.Unlock()
+	if p, ok := cache.pending[key]; ok {
+		return p.value, true
+	}
+	return nil, false
+}
+
+func (cache *Cache) discardPending(key string) {
+	cache.pendingMutex.Lock()
+	delete(cache.pending, key)
+	cache.pendingMutex.Unlock()
 }
 
 func (cache *Cache) saveToDisk(key string, val interface{}) error {
+	cache.ioMutex.RLock()
+	defer cache.ioMutex.RUnlock()
 	b := bytebufferpool.Get()
 	defer bytebufferpool.Put(b)
 	if err := cache.codec.Serialize(b, key, val); err != nil {
 		return fmt.Errorf("serialization: %w", err)
 	}
 	cache.metrics.DBWrites.Observe(float64(b.Len()))
-	return cache.db.Update(func(txn *badger.Txn) error {
-		return txn.Set([]byte(cache.prefix+key), b.Bytes())
-	})
+	return cache.db.Set([]byte(cache.prefix+key), b.Bytes())
 }
 
 func (cache *Cache) Flush() {
 	cache.flushOnce.Do(func() {
 		// Make sure there is no pending items.
-		close(cache.lfu.WriteBackChannel)
+		close(cache.lru.WriteBackChannel)
 		<-cache.writeBackDone
 		// evict all the items in cache
-		cache.lfu.Evict(cache.lfu.Len())
-		close(cache.lfu.EvictionChannel)
+		cache.lru.Evict(cache.lru.Len())
+		close(cache.lru.EvictionChannel)
 		// wait until all evictions are done
 		<-cache.evictionsDone
 	})
@@ -141,35 +226,59 @@ func (cache *Cache) Flush() {
 // See https://github.com/pyroscope-io/pyroscope/issues/210 for more context
 func (cache *Cache) Evict(percent float64) {
 	timer := prometheus.NewTimer(prometheus.ObserverFunc(cache.metrics.EvictionsDuration.Observe))
-	cache.lfu.Evict(int(float64(cache.lfu.Len()) * percent))
+	cache.lru.Evict(int(float64(cache.lru.Len()) * percent))
 	timer.ObserveDuration()
 }
 
+// writeBackBarrier is sent to the write-back channel after the items:
+// they are saved sequentially, therefore all of them are on disk once
+// the barrier is received.
+type writeBackBarrier chan struct{}
+
+// WriteBack persists modified items and returns once they are saved.
 func (cache *Cache) WriteBack() {
 	timer := prometheus.NewTimer(prometheus.ObserverFunc(cache.metrics.WriteBackDuration.Observe))
-	cache.lfu.WriteBack()
+	cache.lru.WriteBack()
+	done := make(writeBackBarrier)
+	cache.lru.WriteBackChannel <- lru.Eviction{Value: done}
+	<-done
 	timer.ObserveDuration()
 }
 
 func (cache *Cache) Delete(key string) error {
-	cache.lfu.Delete(key)
-	return cache.db.Update(func(txn *badger.Txn) error {
-		return txn.Delete([]byte(cache.prefix + key))
-	})
+	cache.lru.Delete(key)
+	cache.discardPending(key)
+	return cache.db.Delete([]byte(cache.prefix + key))
 }
 
 func (cache *Cache) Discard(key string) {
-	cache.lfu.Delete(key)
+	cache.lru.Delete(key)
+	cache.discardPending(key)
 }
 
 // DiscardPrefix deletes all data that matches a certain prefix
 // In both cache and database
 func (cache *Cache) DiscardPrefix(prefix string) error {
-	cache.lfu.DeletePrefix(prefix)
+	cache.lru.DeletePrefix(prefix)
+	cache.pendingMutex.Lock()
+	for k := range cache.pending {
+		if strings.HasPrefix(k, prefix) {
+			delete(cache.pending, k)
+		}
+	}
+	cache.pendingMutex.Unlock()
 
 	return cache.db.DropPrefix([]byte(cache.prefix + prefix))
 }
 
+// Purge removes all the items from cache without writing them back.
+func (cache *Cache) Purge() {
+	cache.lru.DeletePrefix("")
+	cache.pendingMutex.Lock()
+	cache.pending = make(map[string]*pendingEviction)
+	cache.pendingMutex.Unlock()
+}
+
 func (cache *Cache) GetOrCreate(key string) (interface{}, error) {
 	v, err := cache.get(key) // find the key from cache first
 	if err != nil {
@@ -179,7 +288,7 @@ func (cache *Cache) GetOrCreate(key string) (interface{}, error) {
 		return v, nil
 	}
 	v = cache.codec.New(key)
-	cache.lfu.Set(key, v)
+	cache.lru.Set(key, v)
 	return v, nil
 }
 
@@ -193,21 +302,17 @@ func (cache *Cache) Lookup(key string) (interface{}, bool) {
 
 func (cache *Cache) get(key string) (interface{}, error) {
 	cache.metrics.ReadsCounter.Inc()
-	return cache.lfu.GetOrSet(key, func() (interface{}, error) {
+	return cache.lru.GetOrSet(key, func() (interface{}, error) {
 		cache.metrics.MissesCounter.Inc()
-		var buf []byte
-		err := cache.db.View(func(txn *badger.Txn) error {
-			item, err := txn.Get([]byte(cache.prefix + key))
-			if err != nil {
-				return err
-			}
-			buf, err = item.ValueCopy(buf)
-			return err
-		})
-
+		if v, ok := cache.lookupPending(key); ok {
+			return v, nil
+		}
+		cache.ioMutex.RLock()
+		defer cache.ioMutex.RUnlock()
+		buf, err := cache.db.Get([]byte(cache.prefix + key))
 		switch {
 		case err == nil:
-		case errors.Is(err, badger.ErrKeyNotFound):
+		case errors.Is(err, backend.ErrNotFound):
 			return nil, nil
 		default:
 			return nil, err
@@ -218,6 +323,26 @@ func (cache *Cache) get(key string) (interface{}, error) {
 	})
 }
 
+// Exclusively calls f while no items are being loaded from or saved to
+// the database, which allows to modify the stored items consistently with
+// the codec state. f must not access the cache, otherwise it may deadlock.
+func (cache *Cache) Exclusively(f func() error) error {
+	cache.ioMutex.Lock()
+	defer cache.ioMutex.Unlock()
+	return f()
+}
+
 func (cache *Cache) Size() uint64 {
-	return uint64(cache.lfu.Len())
+	return uint64(cache.lru.Len())
+}
+
+// Bounded reports whether the cache size is limited with MaxSize.
+func (cache *Cache) Bounded() bool {
+	return cache.lru.MaxSize > 0
+}
+
+// SizeBytes returns the estimated total size of the items. The value is
+// only maintained if the cache is bounded with MaxSize.
+func (cache *Cache) SizeBytes() uint64 {
+	return uint64(cache.lru.Size())
 }
@@ -9,13 +9,13 @@ import (
 	"os"
 	"path/filepath"
 
-	"github.com/dgraph-io/badger/v2"
-	"github.com/dgraph-io/badger/v2/options"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
 	"github.com/pyroscope-io/pyroscope/pkg/testing"
 )
 
@@ -27,6 +27,47 @@ func (fakeCodec) Serialize(_ io.Writer, _ string, _ interface{}) error { return
 
 func (fakeCodec) Deserialize(_ io.Reader, _ string) (interface{}, error) { return nil, nil }
 
+// stringCodec stores values as is and reports their length as size.
+type stringCodec struct{}
+
+func (stringCodec) New(k string) interface{} { return k }
+
+func (stringCodec) Serialize(w io.Writer, _ string, v interface{}) error {
+	_, err := io.WriteString(w, v.(string))
+	return err
+}
+
+func (stringCodec) Deserialize(r io.Reader, _ string) (interface{}, error) {
+	b, err := io.ReadAll(r)
+	return string(b), err
+}
+
+func (stringCodec) Size(_ string, v interface{}) int64 { return int64(len(v.(string))) }
+
+func newTestMetrics() *Metrics {
+	reg := prometheus.NewRegistry()
+	return &Metrics{
+		MissesCounter: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "cache_test_miss",
+		}),
+		ReadsCounter: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "storage_test_read",
+		}),
+		DBWrites: promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+			Name: "storage_test_write",
+		}),
+		DBReads: promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+			Name: "storage_test_reads",
+		}),
+		WriteBackDuration: promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
+			Name: "storage_test_write_back_duration",
+		}),
+		SizeEvictionsCounter: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "storage_test_size_evictions",
+		}),
+	}
+}
+
 var _ = Describe("cache", func() {
 	It("works properly", func(done Done) {
 		tdir := testing.TmpDirSync()
@@ -34,33 +75,14 @@ var _ = Describe("cache", func() {
 		err := os.MkdirAll(badgerPath, 0o755)
 		Expect(err).ToNot(HaveOccurred())
 
-		badgerOptions := badger.DefaultOptions(badgerPath)
-		badgerOptions = badgerOptions.WithTruncate(false)
-		badgerOptions = badgerOptions.WithSyncWrites(false)
-		badgerOptions = badgerOptions.WithCompression(options.ZSTD)
-
-		db, err := badger.Open(badgerOptions)
+		db, err := backend.OpenBadger(backend.Options{Path: badgerPath, NoTruncate: true})
 		Expect(err).ToNot(HaveOccurred())
 
-		reg := prometheus.NewRegistry()
 		cache := New(Config{
-			DB:     db,
-			Codec:  fakeCodec{},
-			Prefix: "p:",
-			Metrics: &Metrics{
-				MissesCounter: promauto.With(reg).NewCounter(prometheus.CounterOpts{
-					Name: "cache_test_miss",
-				}),
-				ReadsCounter: promauto.With(reg).NewCounter(prometheus.CounterOpts{
-					Name: "storage_test_read",
-				}),
-				DBWrites: promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
-					Name: "storage_test_write",
-				}),
-				DBReads: promauto.With(reg).NewHistogram(prometheus.HistogramOpts{
-					Name: "storage_test_reads",
-				}),
-			},
+			Backend: db,
+			Codec:   fakeCodec{},
+			Prefix:  "p:",
+			Metrics: newTestMetrics(),
 		})
 
 		for i := 0; i < 200; i++ {
@@ -80,6 +102,37 @@ var _ = Describe("cache", func() {
 		Expect(v).To(Equal("foo-1234"))
 		cache.Flush()
 
+		close(done)
+	}, 3)
+	It("evicts items exceeding max size to the backend", func(done Done) {
+		db, err := backend.OpenMemory(backend.Options{})
+		Expect(err).ToNot(HaveOccurred())
+
+		m := newTestMetrics()
+		cache := New(Config{
+			Backend: db,
+			Codec:   stringCodec{},
+			Prefix:  "p:",
+			Metrics: m,
+			MaxSize: 30,
+		})
+
+		for i := 0; i < 10; i++ {
+			cache.Put(fmt.Sprintf("foo-%d", i), fmt.Sprintf("bar-%d", i))
+		}
+		Expect(cache.Size()).To(Equal(uint64(6)))
+		Expect(cache.SizeBytes()).To(Equal(uint64(30)))
+		Expect(testutil.ToFloat64(m.SizeEvictionsCounter)).To(Equal(float64(4)))
+
+		// Evicted items are read back from the backend.
+		cache.WriteBack()
+		v, err := cache.GetOrCreate("foo-0")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(v).To(Equal("bar-0"))
+		Expect(testutil.ToFloat64(m.MissesCounter)).To(Equal(float64(1)))
+		Expect(cache.SizeBytes()).To(Equal(uint64(30)))
+		cache.Flush()
+
 		close(done)
 	}, 3)
 })
@@ -0,0 +1,240 @@
+// Package lru implements a least recently used cache, optionally bounded
+// by the total size of the items.
+package lru
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Cache struct {
+	TTL              int64
+	EvictionChannel  chan<- Eviction
+	WriteBackChannel chan<- Eviction
+
+	// MaxSize is the maximum total size of the items. When exceeded,
+	// least recently used items are evicted. Zero means no limit.
+	MaxSize int64
+	// SizeOf returns the size of the item. It is only called if MaxSize
+	// is set: the size is measured when the item is added or updated.
+	SizeOf func(key string, value interface{}) int64
+	// OnSizeEviction is called for every item evicted because the total
+	// size of the items exceeds MaxSize.
+	OnSizeEviction func()
+	// OnEviction is called for every item sent to EvictionChannel,
+	// before it is sent.
+	OnEviction func(Eviction)
+
+	lock   sync.Mutex
+	values map[string]*list.Element
+	// Most recently used items are at the front.
+	ll   *list.List
+	size int64
+}
+
+type Eviction struct {
+	Key   string
+	Value interface{}
+}
+
+type cacheEntry struct {
+	key            string
+	value          interface{}
+	size           int64
+	persisted      bool
+	lastAccessTime int64
+}
+
+func New() *Cache {
+	return &Cache{
+		values: make(map[string]*list.Element),
+		ll:     list.New(),
+	}
+}
+
+func (c *Cache) Get(key string) interface{} {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if e, ok := c.values[key]; ok {
+		c.touch(e)
+		return e.Value.(*cacheEntry).value
+	}
+	return nil
+}
+
+func (c *Cache) GetOrSet(key string, value func() (interface{}, error)) (interface{}, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if e, ok := c.values[key]; ok {
+		c.touch(e)
+		return e.Value.(*cacheEntry).value, nil
+	}
+	// value doesn't exist.
+	v, err := value()
+	if err != nil || v == nil {
+		return nil, err
+	}
+	c.insert(key, v)
+	return v, nil
+}
+
+func (c *Cache) Set(key string, value interface{}) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if e, ok := c.values[key]; ok {
+		// value already exists for key.  overwrite
+		entry := e.Value.(*cacheEntry)
+		entry.value = value
+		entry.persisted = false
+		c.touch(e)
+		c.resize(entry)
+		c.evictOversize()
+		return
+	}
+	c.insert(key, value)
+}
+
+func (c *Cache) insert(key string, value interface{}) {
+	entry := &cacheEntry{key: key, value: value}
+	e := c.ll.PushFront(entry)
+	c.values[key] = e
+	c.touch(e)
+	c.resize(entry)
+	c.evictOversize()
+}
+
+func (c *Cache) Delete(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if e, ok := c.values[key]; ok {
+		c.delete(e)
+	}
+}
+
+func (c *Cache) DeletePrefix(prefix string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for k, e := range c.values {
+		if strings.HasPrefix(k, prefix) {
+			c.delete(e)
+		}
+	}
+}
+
+//revive:disable-next-line:confusing-naming methods are different
+func (c *Cache) delete(e *list.Element) {
+	entry := e.Value.(*cacheEntry)
+	delete(c.values, entry.key)
+	c.ll.Remove(e)
+	c.size -= entry.size
+}
+
+func (c *Cache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.ll.Len()
+}
+
+// Size returns the total size of the items. The value is only
+// maintained if MaxSize is set.
+func (c *Cache) Size() int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.size
+}
+
+// Evict removes count least recently used items.
+func (c *Cache) Evict(count int) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.evict(count)
+}
+
+// WriteBack persists modified items and evicts obsolete ones.
+func (c *Cache) WriteBack() (persisted, evicted int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.writeBack()
+}
+
+//revive:disable-next-line:confusing-naming methods are different
+func (c *Cache) evict(count int) int {
+	// No lock here so it can be called
+	// from within the lock (during Set)
+	var evicted int
+	for ; evicted < count; evicted++ {
+		e := c.ll.Back()
+		if e == nil {
+			break
+		}
+		c.evictEntry(e)
+	}
+	return evicted
+}
+
+// evictOversize evicts least recently used items until the total size
+// fits MaxSize. The most recently used item is never evicted, even if it
+// exceeds the limit alone.
+func (c *Cache) evictOversize() {
+	if c.MaxSize <= 0 {
+		return
+	}
+	for c.size > c.MaxSize && c.ll.Len() > 1 {
+		c.evictEntry(c.ll.Back())
+		if c.OnSizeEviction != nil {
+			c.OnSizeEviction()
+		}
+	}
+}
+
+func (c *Cache) evictEntry(e *list.Element) {
+	entry := e.Value.(*cacheEntry)
+	if c.EvictionChannel != nil && !entry.persisted {
+		ev := Eviction{
+			Key:   entry.key,
+			Value: entry.value,
+		}
+		if c.OnEviction != nil {
+			c.OnEviction(ev)
+		}
+		c.EvictionChannel <- ev
+	}
+	c.delete(e)
+}
+
+//revive:disable-next-line:confusing-naming methods are different
+func (c *Cache) writeBack() (persisted, evicted int) {
+	now := time.Now().Unix()
+	for k, e := range c.values {
+		entry := e.Value.(*cacheEntry)
+		if c.WriteBackChannel != nil && !entry.persisted {
+			c.WriteBackChannel <- Eviction{
+				Key:   k,
+				Value: entry.value,
+			}
+			entry.persisted = true
+			persisted++
+		}
+		if c.TTL > 0 && now-entry.lastAccessTime > c.TTL {
+			c.delete(e)
+			evicted++
+		}
+	}
+	return persisted, evicted
+}
+
+func (c *Cache) touch(e *list.Element) {
+	e.Value.(*cacheEntry).lastAccessTime = time.Now().Unix()
+	c.ll.MoveToFront(e)
+}
+
+func (c *Cache) resize(entry *cacheEntry) {
+	if c.MaxSize <= 0 || c.SizeOf == nil {
+		return
+	}
+	size := c.SizeOf(entry.key, entry.value)
+	c.size += size - entry.size
+	entry.size = size
+}
@@ -0,0 +1,118 @@
+package lru
+
+import (
+	"testing"
+)
+
+func TestLRU(t *testing.T) {
+	c := New()
+	c.Set("a", "a")
+	if v := c.Get("a"); v != "a" {
+		t.Errorf("Value was not saved: %v != 'a'", v)
+	}
+	if l := c.Len(); l != 1 {
+		t.Errorf("Length was not updated: %v != 1", l)
+	}
+
+	c.Set("b", "b")
+	if v := c.Get("b"); v != "b" {
+		t.Errorf("Value was not saved: %v != 'b'", v)
+	}
+	if l := c.Len(); l != 2 {
+		t.Errorf("Length was not updated: %v != 2", l)
+	}
+
+	c.Get("a")
+	evicted := c.Evict(1)
+	if v := c.Get("a"); v != "a" {
+		t.Errorf("Value was improperly evicted: %v != 'a'", v)
+	}
+	if v := c.Get("b"); v != nil {
+		t.Errorf("Value was not evicted: %v", v)
+	}
+	if l := c.Len(); l != 1 {
+		t.Errorf("Length was not updated: %v != 1", l)
+	}
+	if evicted != 1 {
+		t.Errorf("Number of evicted items is wrong: %v != 1", evicted)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	ch := make(chan Eviction, 1)
+
+	c := New()
+	c.EvictionChannel = ch
+	c.Set("a", "b")
+	c.Evict(1)
+
+	ev := <-ch
+
+	if ev.Key != "a" || ev.Value.(string) != "b" {
+		t.Error("Incorrect item")
+	}
+}
+
+func TestEvictionOrder(t *testing.T) {
+	c := New()
+	c.Set("a1", 1)
+	c.Set("a2", 2)
+	c.Set("a3", 3)
+	c.Get("a1")
+	c.Evict(2)
+
+	if e := c.Get("a1"); e == nil {
+		t.Error("Incorrect eviction order")
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	ch := make(chan Eviction, 3)
+
+	var sizeEvictions int
+	c := New()
+	c.EvictionChannel = ch
+	c.MaxSize = 10
+	c.SizeOf = func(_ string, v interface{}) int64 { return int64(len(v.(string))) }
+	c.OnSizeEviction = func() { sizeEvictions++ }
+
+	c.Set("a", "aaaa")
+	c.Set("b", "bbbb")
+	c.Get("a")
+	c.Set("c", "cccc")
+	if v := c.Get("b"); v != nil {
+		t.Errorf("Least recently used value was not evicted: %v", v)
+	}
+	if ev := <-ch; ev.Key != "b" {
+		t.Errorf("Incorrect item: %v", ev.Key)
+	}
+	if s := c.Size(); s != 8 {
+		t.Errorf("Size was not updated: %v != 8", s)
+	}
+
+	// Updates are accounted.
+	c.Set("c", "cccccccc")
+	if v := c.Get("a"); v != nil {
+		t.Errorf("Value was not evicted: %v", v)
+	}
+	if s := c.Size(); s != 8 {
+		t.Errorf("Size was not updated: %v != 8", s)
+	}
+
+	// The most recently used item is kept even if it does not fit.
+	c.Set("d", "dddddddddddd")
+	if v := c.Get("d"); v == nil {
+		t.Error("Most recently used value was evicted")
+	}
+	if l := c.Len(); l != 1 {
+		t.Errorf("Length was not updated: %v != 1", l)
+	}
+	if sizeEvictions != 3 {
+		t.Errorf("Number of size evictions is wrong: %v != 3", sizeEvictions)
+	}
+
+	c.Delete("d")
+	if s := c.Size(); s != 0 {
+		t.Errorf("Size was not updated: %v != 0", s)
+	}
+}
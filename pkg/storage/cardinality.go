@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+)
+
+// Cardinality limits protect the dimensions index from tags with an
+// unbounded number of values (e.g. request IDs): the number of distinct
+// values of a tag, and the number of series of every application are
+// limited. A profile exceeding a limit is either rejected, or values of
+// the offending tags are replaced with OverflowTagValue. If the number of
+// series is exceeded, all the tags of the profile are replaced, and the
+// overflow series is stored regardless of the limit.
+//
+// Tag values and series of an application are loaded from the dimensions
+// index on the first write (or AppSeries call) after the start, and are
+// loaded again after the application data is removed.
+
+// OverflowTagValue replaces values of the tags exceeding the limits.
+const OverflowTagValue = "__overflow__"
+
+const (
+	cardinalityLimitReject   = "reject"
+	cardinalityLimitOverflow = "overflow"
+)
+
+var ErrCardinalityLimit = errors.New("cardinality limit exceeded")
+
+type cardinalityLimits struct {
+	sync.Mutex
+	apps map[string]*appCardinality
+}
+
+type appCardinality struct {
+	values map[string]map[string]struct{}
+	series map[string]struct{}
+}
+
+func validateCardinalityLimitAction(action string) error {
+	switch action {
+	case "", cardinalityLimitReject, cardinalityLimitOverflow:
+		return nil
+	}
+	return fmt.Errorf("invalid cardinality limit action %q: must be %s or %s",
+		action, cardinalityLimitReject, cardinalityLimitOverflow)
+}
+
+// applyCardinalityLimits returns the key the profile is to be stored with:
+// if tags are collapsed, a modified copy of the key is returned.
+func (s *Storage) applyCardinalityLimits(k *segment.Key) (*segment.Key, error) {
+	maxValues, maxSeries := s.config.maxTagValues, s.config.maxSeries
+	if k.HasProfileID() {
+		return k, nil
+	}
+	if maxValues <= 0 && maxSeries <= 0 {
+		s.trackSeries(k)
+		return k, nil
+	}
+	overflow := s.config.cardinalityLimitAction == cardinalityLimitOverflow
+	s.cardinality.Lock()
+	defer s.cardinality.Unlock()
+	a := s.appCardinality(k.AppName())
+
+	var collapse []string
+	if maxValues > 0 {
+		for name, v := range k.Labels() {
+			if name == "__name__" || v == OverflowTagValue {
+				continue
+			}
+			values := a.values[name]
+			if _, ok := values[v]; ok || len(values) < maxValues {
+				continue
+			}
+			if !overflow {
+				s.cardinalityLimitExceeded.WithLabelValues("tag-values", "rejected").Inc()
+				return nil, fmt.Errorf("%w: %s: tag %q has more than %d values", ErrCardinalityLimit, k.AppName(), name, maxValues)
+			}
+			s.cardinalityLimitExceeded.WithLabelValues("tag-values", "overflow").Inc()
+			collapse = append(collapse, name)
+		}
+	}
+	if len(collapse) > 0 {
+		k = k.Clone()
+		for _, name := range collapse {
+			k.Add(name, OverflowTagValue)
+		}
+	}
+
+	sk := k.SegmentKey()
+	if _, ok := a.series[sk]; !ok && maxSeries > 0 && len(a.series) >= maxSeries {
+		if !overflow {
+			s.cardinalityLimitExceeded.WithLabelValues("series", "rejected").Inc()
+			return nil, fmt.Errorf("%w: %s: more than %d series", ErrCardinalityLimit, k.AppName(), maxSeries)
+		}
+		s.cardinalityLimitExceeded.WithLabelValues("series", "overflow").Inc()
+		k = k.Clone()
+		for name := range k.Labels() {
+			if name != "__name__" {
+				k.Add(name, OverflowTagValue)
+			}
+		}
+		sk = k.SegmentKey()
+	}
+	a.add(k, sk)
+	return k, nil
+}
+
+// trackSeries adds the series of the key to the application, if its
+// series are loaded.
+func (s *Storage) trackSeries(k *segment.Key) {
+	s.cardinality.Lock()
+	defer s.cardinality.Unlock()
+	if a, ok := s.cardinality.apps[k.AppName()]; ok {
+		a.add(k, k.SegmentKey())
+	}
+}
+
+// AppSeries returns the number of series of the application.
+func (s *Storage) AppSeries(appName string) int {
+	s.cardinality.Lock()
+	defer s.cardinality.Unlock()
+	return len(s.appCardinality(appName).series)
+}
+
+// HasSeries reports whether the series of the key exists.
+func (s *Storage) HasSeries(k *segment.Key) bool {
+	s.cardinality.Lock()
+	defer s.cardinality.Unlock()
+	_, ok := s.appCardinality(k.AppName()).series[k.SegmentKey()]
+	return ok
+}
+
+// appCardinality returns the tag values and series of the application.
+// The caller must hold the cardinality mutex.
+func (s *Storage) appCardinality(appName string) *appCardinality {
+	if s.cardinality.apps == nil {
+		s.cardinality.apps = make(map[string]*appCardinality)
+	}
+	if a, ok := s.cardinality.apps[appName]; ok {
+		return a
+	}
+	a := &appCardinality{
+		values: make(map[string]map[string]struct{}),
+		series: make(map[string]struct{}),
+	}
+	if d, ok := s.lookupAppDimension(appName); ok {
+		for _, x := range d.Keys {
+			k, err := segment.ParseKey(string(x))
+			if err != nil {
+				continue
+			}
+			a.add(k, string(x))
+		}
+	}
+	s.cardinality.apps[appName] = a
+	return a
+}
+
+// resetCardinality makes the tag values and series of the application
+// to be loaded again, once its data is removed.
+func (s *Storage) resetCardinality(appName string) {
+	s.cardinality.Lock()
+	delete(s.cardinality.apps, appName)
+	s.cardinality.Unlock()
+}
+
+func (a *appCardinality) add(k *segment.Key, sk string) {
+	a.series[sk] = struct{}{}
+	for name, v := range k.Labels() {
+		if name == "__name__" {
+			continue
+		}
+		values, ok := a.values[name]
+		if !ok {
+			values = make(map[string]struct{})
+			a.values[name] = values
+		}
+		values[v] = struct{}{}
+	}
+}
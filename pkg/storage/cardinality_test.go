@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("cardinality limits", func() {
+	var s *Storage
+
+	testing.WithConfig(func(cfg **config.Config) {
+		JustBeforeEach(func() {
+			var err error
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		JustAfterEach(func() {
+			Expect(s.Close()).To(Succeed())
+		})
+
+		st := time.Now().Truncate(10 * time.Second)
+		put := func(key string) error {
+			k, err := segment.ParseKey(key)
+			Expect(err).ToNot(HaveOccurred())
+			t := tree.New()
+			t.Insert([]byte("a;b"), uint64(1))
+			return s.Put(&PutInput{
+				StartTime: st,
+				EndTime:   st.Add(10 * time.Second),
+				Key:       k,
+				Val:       t,
+			})
+		}
+
+		segmentKeys := func(app string) []string {
+			d, ok := s.lookupAppDimension(app)
+			Expect(ok).To(BeTrue())
+			keys := make([]string, len(d.Keys))
+			for i, k := range d.Keys {
+				keys[i] = string(k)
+			}
+			return keys
+		}
+
+		It("counts series of applications without limits", func() {
+			Expect(s.AppSeries("app.cpu")).To(BeZero())
+			Expect(put("app.cpu{pod=a}")).To(Succeed())
+			Expect(put("app.cpu{pod=b}")).To(Succeed())
+			Expect(s.AppSeries("app.cpu")).To(Equal(2))
+			k, _ := segment.ParseKey("app.cpu{pod=b}")
+			Expect(s.HasSeries(k)).To(BeTrue())
+			k, _ = segment.ParseKey("app.cpu{pod=c}")
+			Expect(s.HasSeries(k)).To(BeFalse())
+
+			Expect(s.DeleteApp("app.cpu")).To(Succeed())
+			Expect(s.AppSeries("app.cpu")).To(BeZero())
+		})
+
+		Context("reject", func() {
+			BeforeEach(func() {
+				(*cfg).Server.StorageMaxTagValues = 2
+				(*cfg).Server.StorageMaxSeries = 3
+				(*cfg).Server.StorageCardinalityLimitAction = "reject"
+			})
+
+			It("rejects profiles with new values of tags at the limit", func() {
+				Expect(put("app.cpu{id=1}")).To(Succeed())
+				Expect(put("app.cpu{id=2}")).To(Succeed())
+				Expect(put("app.cpu{id=3}")).To(MatchError(ErrCardinalityLimit))
+				Expect(put("app.cpu{id=1}")).To(Succeed())
+				Expect(put("other.cpu{id=3}")).To(Succeed())
+
+				By("rejecting new series once the limit is reached")
+				Expect(put("app.cpu{id=1,foo=bar}")).To(Succeed())
+				Expect(put("app.cpu{id=2,foo=bar}")).To(MatchError(ErrCardinalityLimit))
+				Expect(testutil.ToFloat64(s.cardinalityLimitExceeded.WithLabelValues("tag-values", "rejected"))).To(Equal(float64(1)))
+				Expect(testutil.ToFloat64(s.cardinalityLimitExceeded.WithLabelValues("series", "rejected"))).To(Equal(float64(1)))
+			})
+
+			It("counts series stored before the start", func() {
+				Expect(put("app.cpu{id=1}")).To(Succeed())
+				Expect(put("app.cpu{id=2}")).To(Succeed())
+				s.cardinality.apps = nil
+				Expect(put("app.cpu{id=3}")).To(MatchError(ErrCardinalityLimit))
+
+				By("resetting the limits once the app is deleted")
+				Expect(s.DeleteApp("app.cpu")).To(Succeed())
+				Expect(put("app.cpu{id=3}")).To(Succeed())
+			})
+		})
+
+		Context("overflow", func() {
+			BeforeEach(func() {
+				(*cfg).Server.StorageMaxTagValues = 1
+				(*cfg).Server.StorageMaxSeries = 2
+				(*cfg).Server.StorageCardinalityLimitAction = "overflow"
+			})
+
+			It("collapses values of the offending tags", func() {
+				Expect(put("app.cpu{id=1,foo=bar}")).To(Succeed())
+				Expect(put("app.cpu{id=2,foo=bar}")).To(Succeed())
+				Expect(put("app.cpu{id=3,foo=bar}")).To(Succeed())
+				Expect(segmentKeys("app.cpu")).To(ConsistOf(
+					"app.cpu{foo=bar,id=1}",
+					"app.cpu{foo=bar,id=__overflow__}",
+				))
+
+				By("collapsing all tags once the series limit is reached")
+				Expect(put("app.cpu{id=1,foo=baz}")).To(Succeed())
+				Expect(segmentKeys("app.cpu")).To(ConsistOf(
+					"app.cpu{foo=bar,id=1}",
+					"app.cpu{foo=bar,id=__overflow__}",
+					"app.cpu{foo=__overflow__,id=__overflow__}",
+				))
+				Expect(testutil.ToFloat64(s.cardinalityLimitExceeded.WithLabelValues("tag-values", "overflow"))).To(Equal(float64(3)))
+				Expect(testutil.ToFloat64(s.cardinalityLimitExceeded.WithLabelValues("series", "overflow"))).To(Equal(float64(1)))
+			})
+		})
+
+		Context("invalid action", func() {
+			It("fails to create storage", func() {
+				c := (*cfg).Server
+				c.StorageCardinalityLimitAction = "drop"
+				_, err := New(NewConfig(&c), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+})
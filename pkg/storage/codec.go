@@ -20,14 +20,21 @@ func (c treeCodec) Serialize(w io.Writer, k string, v interface{}) error {
 	if err != nil {
 		return err
 	}
-	err = v.(*tree.Tree).SerializeTruncate(d.(*dict.Dict), c.config.maxNodesSerialization, w)
-	if err != nil {
+	if err = c.serializeTree(v.(*tree.Tree), d.(*dict.Dict), w); err != nil {
 		return err
 	}
 	c.dicts.Put(key, d)
 	return nil
 }
 
+// serializeTree writes the tree in the configured format.
+func (s *Storage) serializeTree(t *tree.Tree, d *dict.Dict, w io.Writer) error {
+	if s.config.treeFormat == tree.FormatColumns {
+		return t.SerializeColumns(d, s.config.maxNodesSerialization, w)
+	}
+	return t.SerializeTruncate(d, s.config.maxNodesSerialization, w)
+}
+
 func (c treeCodec) Deserialize(r io.Reader, k string) (interface{}, error) {
 	key := segment.FromTreeToDictKey(k)
 	d, err := c.dicts.GetOrCreate(key)
@@ -37,6 +44,10 @@ func (c treeCodec) Deserialize(r io.Reader, k string) (interface{}, error) {
 	return tree.Deserialize(d.(*dict.Dict), r)
 }
 
+func (treeCodec) Size(_ string, v interface{}) int64 {
+	return v.(*tree.Tree).EstimatedSize()
+}
+
 type dictionaryCodec struct{}
 
 func (dictionaryCodec) New(_ string) interface{} { return dict.New() }
@@ -72,3 +83,7 @@ func (dimensionCodec) Serialize(w io.Writer, _ string, v interface{}) error {
 func (dimensionCodec) Deserialize(r io.Reader, _ string) (interface{}, error) {
 	return dimension.Deserialize(r)
 }
+
+func (dimensionCodec) Size(_ string, v interface{}) int64 {
+	return v.(*dimension.Dimension).EstimatedSize()
+}
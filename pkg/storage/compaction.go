@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/pyroscope-io/pyroscope/pkg/util/bytesize"
+)
+
+// Compaction of a database consists of the value log garbage collection,
+// which is triggered if the database size has increased by more than
+// gcSizeDiff since the last run, and, optionally, merging of the LSM tree
+// levels. Compaction runs within the configured time of day window only,
+// and the value log is rewritten at the configured rate: the remaining
+// work is picked up on the next run.
+//
+// Note that disk usage enforcement collects the value log regardless.
+
+// defaultValueLogFileSize is the BadgerDB default. A value log GC run
+// rewrites at most one file.
+const defaultValueLogFileSize = bytesize.GB
+
+// compactionWindow is a time of day range, offsets are relative
+// to the local midnight. The range may span midnight.
+type compactionWindow struct {
+	start, end time.Duration
+}
+
+// parseCompactionWindow parses a range in the form of "22:00-06:00".
+// Empty string means there are no restrictions (nil window).
+func parseCompactionWindow(s string) (*compactionWindow, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid compaction window %q: should be in the form of hh:mm-hh:mm", s)
+	}
+	var (
+		w   compactionWindow
+		err error
+	)
+	if w.start, err = parseTimeOfDay(parts[0]); err != nil {
+		return nil, fmt.Errorf("invalid compaction window %q: %w", s, err)
+	}
+	if w.end, err = parseTimeOfDay(parts[1]); err != nil {
+		return nil, fmt.Errorf("invalid compaction window %q: %w", s, err)
+	}
+	if w.start == w.end {
+		return nil, fmt.Errorf("invalid compaction window %q: empty range", s)
+	}
+	return &w, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (w *compactionWindow) contains(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+	y, m, d := t.Date()
+	offset := t.Sub(time.Date(y, m, d, 0, 0, 0, 0, t.Location()))
+	if w.start < w.end {
+		return offset >= w.start && offset < w.end
+	}
+	return offset >= w.start || offset < w.end
+}
+
+func (s *Storage) newCompactionLimiter() *rate.Limiter {
+	if s.config.compactionRateLimit <= 0 {
+		return nil
+	}
+	burst := s.config.badgerOptions.ValueLogFileSize
+	if burst <= 0 {
+		burst = int64(defaultValueLogFileSize)
+	}
+	return rate.NewLimiter(rate.Limit(s.config.compactionRateLimit), int(burst))
+}
+
+func (s *Storage) compactionTask() {
+	if !s.compactionWindow.contains(time.Now()) {
+		return
+	}
+	for _, d := range s.databases() {
+		if !s.compactDB(d) {
+			return
+		}
+	}
+}
+
+// compactDB reports whether compaction can proceed with other databases.
+func (s *Storage) compactDB(d *db) bool {
+	diff := calculateDBSize(d.path) - d.lastGC
+	if d.lastGC != 0 && s.gcSizeDiff != 0 && diff <= s.gcSizeDiff {
+		return true
+	}
+	timer := time.Now()
+	for {
+		if !s.waitCompaction() {
+			return false
+		}
+		// Steps run exclusively with other maintenance tasks, while
+		// the throttling does not block them.
+		s.tasksMutex.Lock()
+		reclaimed := d.runGC(0.7)
+		s.tasksMutex.Unlock()
+		if !reclaimed {
+			break
+		}
+	}
+	d.gcCount.Inc()
+	if s.config.compactionFlatten && s.compactionWindow.contains(time.Now()) {
+		s.tasksMutex.Lock()
+		if err := d.Flatten(); err != nil {
+			d.logger.WithError(err).Warn("failed to merge LSM tree levels")
+		}
+		s.tasksMutex.Unlock()
+	}
+	d.lastGC = calculateDBSize(d.path)
+	s.metrics.compactionDuration.WithLabelValues(d.name).Observe(time.Since(timer).Seconds())
+	return true
+}
+
+// waitCompaction blocks until the next value log GC run is allowed
+// by the rate limit. It returns false if the run is not to happen:
+// the storage is closing or the window is over.
+func (s *Storage) waitCompaction() bool {
+	if s.compactionLimiter != nil {
+		r := s.compactionLimiter.ReserveN(time.Now(), s.compactionLimiter.Burst())
+		t := time.NewTimer(r.Delay())
+		select {
+		case <-s.stop:
+			t.Stop()
+			r.Cancel()
+			return false
+		case <-t.C:
+		}
+	}
+	select {
+	case <-s.stop:
+		return false
+	default:
+	}
+	return s.compactionWindow.contains(time.Now())
+}
@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("compaction", func() {
+	Context("window", func() {
+		at := func(hh, mm int) time.Time {
+			return time.Date(2021, 10, 1, hh, mm, 0, 0, time.Local)
+		}
+
+		It("is not restricted if not specified", func() {
+			w, err := parseCompactionWindow("")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(w.contains(at(12, 0))).To(BeTrue())
+		})
+
+		It("contains time of day within the range", func() {
+			w, err := parseCompactionWindow("01:30-06:00")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(w.contains(at(1, 29))).To(BeFalse())
+			Expect(w.contains(at(1, 30))).To(BeTrue())
+			Expect(w.contains(at(5, 59))).To(BeTrue())
+			Expect(w.contains(at(6, 0))).To(BeFalse())
+		})
+
+		It("may span midnight", func() {
+			w, err := parseCompactionWindow("22:00-06:00")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(w.contains(at(23, 0))).To(BeTrue())
+			Expect(w.contains(at(0, 0))).To(BeTrue())
+			Expect(w.contains(at(12, 0))).To(BeFalse())
+		})
+
+		It("rejects invalid ranges", func() {
+			for _, v := range []string{"22:00", "22:00-25:00", "06:00-06:00", "a-b"} {
+				_, err := parseCompactionWindow(v)
+				Expect(err).To(HaveOccurred(), v)
+			}
+		})
+	})
+
+	It("is throttled until the storage is closed", func() {
+		s := &Storage{
+			stop:              make(chan struct{}),
+			compactionLimiter: rate.NewLimiter(1, 10),
+		}
+		Expect(s.waitCompaction()).To(BeTrue())
+		done := make(chan bool)
+		go func() { done <- s.waitCompaction() }()
+		Consistently(done).ShouldNot(Receive())
+		close(s.stop)
+		Eventually(done).Should(Receive(BeFalse()))
+	})
+
+	testing.WithConfig(func(cfg **config.Config) {
+		var s *Storage
+
+		JustBeforeEach(func() {
+			var err error
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		JustAfterEach(func() {
+			Expect(s.Close()).To(Succeed())
+		})
+
+		Context("outside of the window", func() {
+			BeforeEach(func() {
+				now := time.Now()
+				(*cfg).Server.CompactionWindow = now.Add(time.Hour).Format("15:04") + "-" + now.Add(2*time.Hour).Format("15:04")
+			})
+
+			It("does not compact databases", func() {
+				s.compactionTask()
+				for _, d := range s.databases() {
+					Expect(testutil.ToFloat64(d.gcCount)).To(BeZero())
+				}
+			})
+		})
+
+		Context("within the window", func() {
+			It("compacts databases", func() {
+				// Every database is compacted on start.
+				Eventually(func() float64 {
+					return testutil.ToFloat64(s.trees.gcCount)
+				}).Should(BeNumerically(">", 0))
+			})
+		})
+	})
+})
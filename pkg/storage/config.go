@@ -4,20 +4,72 @@ import (
 	"time"
 
 	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/objstore"
 	"github.com/sirupsen/logrus"
 )
 
 type Config struct {
-	badgerLogLevel        logrus.Level
-	badgerNoTruncate      bool
-	badgerBasePath        string
-	cacheEvictThreshold   float64
-	cacheEvictVolume      float64
-	maxNodesSerialization int
-	retention             time.Duration
-	hideApplications      []string
-	retentionLevels       config.RetentionLevels
-	inMemory              bool
+	badgerLogLevel         logrus.Level
+	badgerNoTruncate       bool
+	badgerBasePath         string
+	badgerOptions          backend.BadgerOptions
+	cacheEvictThreshold    float64
+	cacheEvictVolume       float64
+	cacheTreesMaxSize      int64
+	cacheDimensionsMaxSize int64
+	maxNodesSerialization  int
+	treeFormat             int
+	treeShardDuration      time.Duration
+	retention              time.Duration
+	hideApplications       []string
+	retentionLevels        config.RetentionLevels
+	appRetention           map[string]string
+
+	downsamplingAge        time.Duration
+	downsamplingResolution time.Duration
+	inMemory               bool
+	backend                string
+
+	maxDiskUsage          string
+	diskUsageLowWatermark float64
+	quotas                map[string]string
+
+	maxTagValues           int
+	maxSeries              int
+	cardinalityLimitAction string
+
+	compactionInterval  time.Duration
+	compactionWindow    string
+	compactionRateLimit int64
+	compactionFlatten   bool
+
+	dictionaryGCInterval time.Duration
+	preloadApps          int
+
+	wal              bool
+	walFsync         string
+	walFsyncInterval time.Duration
+
+	objectStorageURL     string
+	objectStorageOptions objstore.Options
+	objectStorageMinAge  time.Duration
+	installIDFile        string
+
+	snapshotShippingURL      string
+	snapshotShippingInterval time.Duration
+	standbyURL               string
+	standbyPollInterval      time.Duration
+
+	readOnly bool
+
+	encryptionKeyFile             string
+	encryptionKeyRotationInterval time.Duration
+
+	queryCacheTTL        time.Duration
+	queryCacheMaxEntries int
+
+	auditLog bool
 }
 
 // NewConfig returns a new storage config from a server config
@@ -26,17 +78,80 @@ func NewConfig(server *config.Server) *Config {
 	if l, err := logrus.ParseLevel(server.BadgerLogLevel); err == nil {
 		level = l
 	}
+	name := server.StorageBackend
+	if name == "" {
+		name = backend.Badger
+	}
 	return &Config{
-		badgerLogLevel:        level,
-		badgerBasePath:        server.StoragePath,
-		badgerNoTruncate:      server.BadgerNoTruncate,
-		cacheEvictThreshold:   server.CacheEvictThreshold,
-		cacheEvictVolume:      server.CacheEvictVolume,
-		maxNodesSerialization: server.MaxNodesSerialization,
-		retention:             server.Retention,
-		retentionLevels:       server.RetentionLevels,
-		hideApplications:      server.HideApplications,
-		inMemory:              false,
+		badgerLogLevel:   level,
+		badgerBasePath:   server.StoragePath,
+		badgerNoTruncate: server.BadgerNoTruncate,
+		badgerOptions: backend.BadgerOptions{
+			BlockCacheSize:   int64(server.BadgerBlockCacheSize),
+			IndexCacheSize:   int64(server.BadgerIndexCacheSize),
+			ValueLogFileSize: int64(server.BadgerValueLogFileSize),
+			Compression:      server.BadgerCompression,
+			NumCompactors:    server.BadgerNumCompactors,
+		},
+		cacheEvictThreshold:    server.CacheEvictThreshold,
+		cacheEvictVolume:       server.CacheEvictVolume,
+		cacheTreesMaxSize:      int64(server.CacheTreesMaxSize),
+		cacheDimensionsMaxSize: int64(server.CacheDimensionsMaxSize),
+		maxNodesSerialization:  server.MaxNodesSerialization,
+		treeFormat:             server.StorageTreeFormat,
+		treeShardDuration:      server.StorageTreeShardDuration,
+		retention:              server.Retention,
+		retentionLevels:        server.RetentionLevels,
+		appRetention:           server.AppRetention,
+
+		downsamplingAge:        server.DownsamplingAge,
+		downsamplingResolution: server.DownsamplingResolution,
+		hideApplications:       server.HideApplications,
+		inMemory:               false,
+		backend:                name,
+
+		maxDiskUsage:          server.StorageMaxDiskUsage,
+		diskUsageLowWatermark: server.StorageDiskUsageLowWatermark,
+		quotas:                server.StorageQuota,
+
+		maxTagValues:           server.StorageMaxTagValues,
+		maxSeries:              server.StorageMaxSeries,
+		cardinalityLimitAction: server.StorageCardinalityLimitAction,
+
+		compactionInterval:  server.CompactionInterval,
+		compactionWindow:    server.CompactionWindow,
+		compactionRateLimit: int64(server.CompactionRateLimit),
+		compactionFlatten:   server.CompactionFlatten,
+
+		dictionaryGCInterval: server.DictionaryGCInterval,
+		preloadApps:          server.StoragePreloadApps,
+
+		wal:              server.StorageWAL,
+		walFsync:         server.StorageWALFsync,
+		walFsyncInterval: server.StorageWALFsyncInterval,
+
+		objectStorageURL: server.ObjectStorageURL,
+		objectStorageOptions: objstore.Options{
+			Endpoint: server.ObjectStorageEndpoint,
+			Region:   server.ObjectStorageRegion,
+		},
+		objectStorageMinAge: server.ObjectStorageMinAge,
+		installIDFile:       server.InstallIDFile,
+
+		snapshotShippingURL:      server.SnapshotShippingURL,
+		snapshotShippingInterval: server.SnapshotShippingInterval,
+		standbyURL:               server.StandbyURL,
+		standbyPollInterval:      server.StandbyPollInterval,
+
+		readOnly: server.ReadOnly,
+
+		encryptionKeyFile:             server.StorageEncryptionKeyFile,
+		encryptionKeyRotationInterval: server.StorageEncryptionKeyRotationInterval,
+
+		queryCacheTTL:        server.StorageQueryCacheTTL,
+		queryCacheMaxEntries: server.StorageQueryCacheMaxEntries,
+
+		auditLog: server.AuditLog,
 	}
 }
 
@@ -51,3 +166,15 @@ func (c *Config) WithInMemory() *Config {
 	c.inMemory = true
 	return c
 }
+
+// WithReadOnly makes the storage reject modifications.
+func (c *Config) WithReadOnly() *Config {
+	c.readOnly = true
+	return c
+}
+
+// WithBackend sets the name of the storage backend.
+func (c *Config) WithBackend(name string) *Config {
+	c.backend = name
+	return c
+}
@@ -1,25 +1,26 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"runtime/debug"
 
-	"github.com/dgraph-io/badger/v2"
-	"github.com/dgraph-io/badger/v2/options"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/cache"
 	"github.com/pyroscope-io/pyroscope/pkg/util/bytesize"
 )
 
 type db struct {
 	name   string
+	path   string
 	logger logrus.FieldLogger
 
-	*badger.DB
+	backend.Backend
 	*cache.Cache
 
 	lastGC  bytesize.ByteSize
@@ -46,121 +47,126 @@ func (p prefix) trim(k []byte) ([]byte, bool) {
 	return nil, false
 }
 
-func (s *Storage) newBadger(name string, p prefix, codec cache.Codec) (d *db, err error) {
+func (s *Storage) newDB(name string, p prefix, codec cache.Codec) (d *db, err error) {
 	logger := logrus.New()
 	logger.SetLevel(s.config.badgerLogLevel)
+	opts := backend.Options{
+		Name:       name,
+		InMemory:   s.config.inMemory,
+		NoTruncate: s.config.badgerNoTruncate,
+		Logger:     logger.WithField("badger", name),
+		Badger:     s.config.badgerOptions,
+	}
 
-	if s.config.inMemory {
-		badgerDB, err := badger.Open(badger.DefaultOptions("").
-			WithInMemory(true).
-			WithLogger(logger.WithField("badger", name)))
-		if err != nil {
+	if !s.config.inMemory {
+		opts.Path = filepath.Join(s.config.badgerBasePath, name)
+		if err = os.MkdirAll(opts.Path, 0o755); err != nil {
 			return nil, err
 		}
-
-		d = &db{
-			name:   name,
-			DB:     badgerDB,
-			logger: s.logger.WithField("db", name),
-		}
-
-		if codec != nil {
-			d.Cache = cache.New(cache.Config{
-				DB:      badgerDB,
-				Metrics: s.metrics.createCacheMetrics(name),
-				TTL:     s.cacheTTL,
-				Prefix:  p.String(),
-				Codec:   codec,
-			})
-		}
-		return d, nil
+		defer func() {
+			if r := recover(); r != nil {
+				// BadgerDB may panic because of file system access permissions. In particular,
+				// if is running in kubernetes with incorrect/unset fsGroup security context:
+				// https://github.com/pyroscope-io/pyroscope/issues/350.
+				err = fmt.Errorf("failed to open database\n\n"+
+					"Please make sure Pyroscope Server has write access permissions to %s directory.\n\n"+
+					"Recovered from panic: %v\n%v", opts.Path, r, string(debug.Stack()))
+			}
+		}()
 	}
 
-	badgerPath := filepath.Join(s.config.badgerBasePath, name)
-	if err = os.MkdirAll(badgerPath, 0o755); err != nil {
-		return nil, err
+	b, err := backend.Open(s.config.backend, opts)
+	if errors.Is(err, backend.ErrEncryptionKeyMismatch) {
+		return nil, fmt.Errorf("failed to open %s database: %w\n\n"+
+			"Please make sure storage-encryption-key-file is set to the file of the key the storage is encrypted with. "+
+			"The key can be changed with 'pyroscope admin storage rotate-key' command", name, err)
 	}
-
-	defer func() {
-		if r := recover(); r != nil {
-			// BadgerDB may panic because of file system access permissions. In particular,
-			// if is running in kubernetes with incorrect/unset fsGroup security context:
-			// https://github.com/pyroscope-io/pyroscope/issues/350.
-			err = fmt.Errorf("failed to open database\n\n"+
-				"Please make sure Pyroscope Server has write access permissions to %s directory.\n\n"+
-				"Recovered from panic: %v\n%v", badgerPath, r, string(debug.Stack()))
-		}
-	}()
-
-	badgerDB, err := badger.Open(badger.DefaultOptions(badgerPath).
-		WithTruncate(!s.config.badgerNoTruncate).
-		WithSyncWrites(false).
-		WithCompactL0OnClose(false).
-		WithCompression(options.ZSTD).
-		WithLogger(logger.WithField("badger", name)))
-
 	if err != nil {
 		return nil, err
 	}
+	if p == treePrefix {
+		if err = s.checkTreeSharding(b); err != nil {
+			_ = b.Close()
+			return nil, err
+		}
+		if s.config.treeShardDuration > 0 {
+			if s.treeShards, err = s.openTreeShards(b, opts); err != nil {
+				_ = b.Close()
+				return nil, err
+			}
+			b = s.treeShards
+		}
+	}
+	if p == treePrefix && s.objects != nil {
+		b = &offloadedBackend{Backend: b, objects: s.objects}
+	}
 
 	d = &db{
 		name:    name,
-		DB:      badgerDB,
+		path:    opts.Path,
+		Backend: b,
 		logger:  s.logger.WithField("db", name),
 		gcCount: s.metrics.gcCount.WithLabelValues(name),
 	}
 
 	if codec != nil {
 		d.Cache = cache.New(cache.Config{
-			DB:      badgerDB,
+			Backend: b,
 			Metrics: s.metrics.createCacheMetrics(name),
 			TTL:     s.cacheTTL,
 			Prefix:  p.String(),
 			Codec:   codec,
+			MaxSize: s.cacheMaxSize(p),
 		})
 	}
 
-	s.maintenanceTask(s.badgerGCTaskInterval, func() {
-		diff := calculateDBSize(badgerPath) - d.lastGC
-		if d.lastGC == 0 || s.gcSizeDiff == 0 || diff > s.gcSizeDiff {
-			d.runGC(0.7)
-			d.gcCount.Inc()
-			d.lastGC = calculateDBSize(badgerPath)
-		}
-	})
-
 	return d, nil
 }
 
+// cacheMaxSize returns the limit of the cache size of the database:
+// only trees and dimensions caches are bounded.
+func (s *Storage) cacheMaxSize(p prefix) int64 {
+	switch p {
+	case treePrefix:
+		return s.config.cacheTreesMaxSize
+	case dimensionPrefix:
+		return s.config.cacheDimensionsMaxSize
+	default:
+		return 0
+	}
+}
+
 func (d *db) close() {
 	if d.Cache != nil {
 		d.Cache.Flush()
 	}
-	if err := d.DB.Close(); err != nil {
+	if err := d.Backend.Close(); err != nil {
 		d.logger.WithError(err).Error("closing database")
 	}
 }
 
 func (d *db) size() bytesize.ByteSize {
+	u := d.usage()
+	return u.LSM + u.VLog
+}
+
+func (d *db) usage() DiskUsage {
 	// The value is updated once per minute.
-	lsm, vlog := d.DB.Size()
-	return bytesize.ByteSize(lsm + vlog)
+	lsm, vlog := d.Backend.Size()
+	return DiskUsage{
+		LSM:  bytesize.ByteSize(lsm),
+		VLog: bytesize.ByteSize(vlog),
+	}
 }
 
 func (d *db) runGC(discardRatio float64) (reclaimed bool) {
-	d.logger.Debug("starting badger garbage collection")
-	for {
-		switch err := d.RunValueLogGC(discardRatio); err {
-		default:
-			d.logger.WithError(err).Warn("failed to run GC")
-			return false
-		case badger.ErrNoRewrite:
-			return reclaimed
-		case nil:
-			reclaimed = true
-			continue
-		}
+	d.logger.Debug("starting garbage collection")
+	reclaimed, err := d.GC(discardRatio)
+	if err != nil {
+		d.logger.WithError(err).Warn("failed to run GC")
+		return false
 	}
+	return reclaimed
 }
 
 // TODO(kolesnikovae): filepath.Walk is notoriously slow.
@@ -7,8 +7,9 @@ import (
 	"io"
 	"net/http"
 
-	"github.com/dgraph-io/badger/v2"
 	"github.com/gorilla/mux"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
 )
 
 func (s *Storage) DebugExport(w http.ResponseWriter, r *http.Request) {
@@ -43,21 +44,12 @@ func (s *Storage) DebugExport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/octet-stream")
-	err := d.DB.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(k[0]))
-		if err != nil {
-			return err
-		}
-		return item.Value(func(v []byte) error {
-			_, err = io.Copy(w, bytes.NewBuffer(v))
-			return err
-		})
-	})
-
+	v, err := d.Backend.Get([]byte(k[0]))
 	switch {
 	case err == nil:
-	case errors.Is(err, badger.ErrKeyNotFound):
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = io.Copy(w, bytes.NewBuffer(v))
+	case errors.Is(err, backend.ErrNotFound):
 		http.Error(w, fmt.Sprintf("key %q not found in %s", k[0], n), http.StatusNotFound)
 	default:
 		http.Error(w, fmt.Sprintf("failed to export value for key %q: %v", k[0], err), http.StatusInternalServerError)
@@ -76,3 +76,14 @@ func (t *Dict) Put(val Value) Key {
 	t.root.findNodeAt(val, &buf)
 	return buf.Bytes()
 }
+
+// Replace replaces the dictionary contents with the contents of d,
+// which must not be modified afterwards.
+func (t *Dict) Replace(d *Dict) {
+	d.m.RLock()
+	root := d.root
+	d.m.RUnlock()
+	t.m.Lock()
+	t.root = root
+	t.m.Unlock()
+}
@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/dict"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+)
+
+// Dictionaries are tries of node names, trees store the paths to the
+// names (label links). Entries can not be removed from a dictionary in
+// place, because links depend on the trie layout. Instead, the dictionary
+// of an application is rebuilt from the names referenced by the stored
+// trees of the application (mark), and the trees are rewritten with the
+// links to the new dictionary (sweep). The sweep phase runs exclusively
+// with loading and saving trees, and only if it reclaims at least
+// dictGCMinReclaimed of the dictionary size.
+//
+// Trees and dictionaries are stored in different databases, therefore
+// the sweep is not atomic: if the process crashes in the middle, trees
+// of the application may end up referencing the old dictionary.
+
+const dictGCMinReclaimed = 0.2
+
+// dictGCTask collects dictionaries, if it's time to.
+// It runs as a part of the retention task.
+func (s *Storage) dictGCTask() {
+	if s.config.dictionaryGCInterval <= 0 || time.Since(s.lastDictGC) < s.config.dictionaryGCInterval {
+		return
+	}
+	s.lastDictGC = time.Now()
+	for _, app := range s.GetAppNames() {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+		if err := s.collectDictionary(app); err != nil {
+			s.logger.WithError(err).WithField("app", app).Error("failed to collect dictionary")
+		}
+	}
+}
+
+func (s *Storage) collectDictionary(app string) error {
+	v, ok := s.dicts.Lookup(app)
+	if !ok {
+		return nil
+	}
+	d := v.(*dict.Dict)
+	// Mark phase: the dictionary may be updated in the meantime,
+	// but only the new dictionary size estimate is needed.
+	n := dict.New()
+	if err := s.rewriteTrees(app, d, n, false); err != nil {
+		return err
+	}
+	before, after := dictSize(d), dictSize(n)
+	if before == 0 || float64(before-after)/float64(before) < dictGCMinReclaimed {
+		return nil
+	}
+
+	// Sweep phase.
+	n = dict.New()
+	err := s.trees.Cache.Exclusively(func() error {
+		if err := s.rewriteTrees(app, d, n, true); err != nil {
+			return err
+		}
+		// Trees that are cached or being saved use the
+		// same dictionary instance.
+		d.Replace(n)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.dicts.Put(app, d)
+	s.dicts.WriteBack()
+	after = dictSize(d)
+	s.metrics.dictGCReclaimedBytes.Add(float64(before - after))
+	s.logger.WithField("app", app).
+		WithField("before", before).
+		WithField("after", after).
+		Debug("dictionary collected")
+	return nil
+}
+
+// rewriteTrees decodes all the stored trees of the application with the
+// dictionary d and encodes them with the dictionary n. If write is true,
+// the stored trees are replaced.
+func (s *Storage) rewriteTrees(app string, d, n *dict.Dict, write bool) error {
+	it := s.trees.NewIterator(backend.IteratorOptions{
+		Prefix: treePrefix.key(app + "{"),
+	})
+	defer it.Close()
+	batch := s.trees.NewWriteBatch()
+	defer func() {
+		batch.Cancel()
+	}()
+	var (
+		buf     bytes.Buffer
+		written int64
+	)
+	for it.Rewind(); it.Valid(); it.Next() {
+		k := it.Item().KeyCopy(nil)
+		// Offloaded trees are resolved and stored locally: they
+		// are offloaded again with the next block.
+		b, err := s.trees.Backend.Get(k)
+		switch {
+		case err == nil:
+		case errors.Is(err, backend.ErrNotFound):
+			continue
+		default:
+			return err
+		}
+		t, err := tree.FromBytes(d, b)
+		if err != nil {
+			return fmt.Errorf("tree %q: %w", k, err)
+		}
+		buf.Reset()
+		if err = s.serializeTree(t, n, &buf); err != nil {
+			return err
+		}
+		if !write {
+			continue
+		}
+		if err = batch.Set(k, append([]byte(nil), buf.Bytes()...)); err != nil {
+			return err
+		}
+		// The sweep is not interrupted when the storage is closing.
+		if written++; written%s.trees.MaxBatchCount() == 0 {
+			if err = batch.Flush(); err != nil {
+				return err
+			}
+			batch = s.trees.NewWriteBatch()
+		}
+	}
+	if !write {
+		return nil
+	}
+	return batch.Flush()
+}
+
+func dictSize(d *dict.Dict) int {
+	var buf bytes.Buffer
+	if err := d.Serialize(&buf); err != nil {
+		return 0
+	}
+	return buf.Len()
+}
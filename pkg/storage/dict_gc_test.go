@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/dict"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("dictionary GC", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		JustBeforeEach(func() {
+			var err error
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		JustAfterEach(func() {
+			Expect(s.Close()).To(Succeed())
+		})
+
+		st := time.Now().Add(-time.Hour).Truncate(10 * time.Second)
+		put := func(name string, t *tree.Tree) {
+			k, err := segment.ParseKey(name)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.Put(&PutInput{
+				StartTime:  st,
+				EndTime:    st.Add(10 * time.Second),
+				Key:        k,
+				Val:        t,
+				SpyName:    "testspy",
+				SampleRate: 100,
+			})).To(Succeed())
+		}
+
+		get := func(name string) string {
+			k, err := segment.ParseKey(name)
+			Expect(err).ToNot(HaveOccurred())
+			o, err := s.Get(&GetInput{StartTime: st, EndTime: st.Add(10 * time.Second), Key: k})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(o).ToNot(BeNil())
+			return o.Tree.String()
+		}
+
+		It("removes names that are no longer referenced", func() {
+			t := tree.New()
+			for i := 0; i < 100; i++ {
+				t.Insert([]byte("main;function_"+strconv.Itoa(i)), 1)
+			}
+			put("app.cpu{foo=expired}", t)
+			t = tree.New()
+			t.Insert([]byte("main;a"), 1)
+			t.Insert([]byte("main;b"), 2)
+			put("app.cpu{foo=live}", t)
+			s.writeBack()
+
+			k, _ := segment.ParseKey("app.cpu{foo=expired}")
+			Expect(s.deleteSegmentAndRelatedData(k)).To(Succeed())
+			v, ok := s.dicts.Lookup("app.cpu")
+			Expect(ok).To(BeTrue())
+			before := dictSize(v.(*dict.Dict))
+
+			Expect(s.collectDictionary("app.cpu")).To(Succeed())
+			Expect(dictSize(v.(*dict.Dict))).To(BeNumerically("<", before))
+			Expect(testutil.ToFloat64(s.metrics.dictGCReclaimedBytes)).To(BeNumerically(">", 0))
+
+			// Cached trees are kept.
+			Expect(get("app.cpu{foo=live}")).To(Equal("main;a 1\nmain;b 2\n"))
+			put("app.cpu{foo=live}", t)
+			// Stored trees are rewritten.
+			s.writeBack()
+			s.trees.Cache.Purge()
+			s.dicts.Cache.Purge()
+			Expect(get("app.cpu{foo=live}")).To(Equal("main;a 2\nmain;b 4\n"))
+		})
+
+		It("keeps dictionaries without garbage", func() {
+			t := tree.New()
+			t.Insert([]byte("main;a"), 1)
+			put("app.cpu{foo=live}", t)
+			s.writeBack()
+			Expect(s.collectDictionary("app.cpu")).To(Succeed())
+			Expect(testutil.ToFloat64(s.metrics.dictGCReclaimedBytes)).To(BeZero())
+			s.trees.Cache.Purge()
+			Expect(get("app.cpu{foo=live}")).To(Equal("main;a 1\n"))
+		})
+	})
+})
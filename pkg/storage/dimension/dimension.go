@@ -53,6 +53,18 @@ func (d *Dimension) Delete(key Key) {
 	}
 }
 
+// EstimatedSize returns an estimate of the memory occupied by the dimension.
+func (d *Dimension) EstimatedSize() int64 {
+	d.m.RLock()
+	defer d.m.RUnlock()
+	// Slice headers of the keys.
+	size := int64(cap(d.Keys)) * 24
+	for _, k := range d.Keys {
+		size += int64(cap(k))
+	}
+	return size
+}
+
 type advanceResult int
 
 const (
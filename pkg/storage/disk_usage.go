@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/util/bytesize"
+	"github.com/pyroscope-io/pyroscope/pkg/util/disk"
+)
+
+// parseDiskUsageLimit parses the limit specified either in bytes,
+// or as a percentage of the size of the disk the path belongs to.
+func parseDiskUsageLimit(v, path string) (bytesize.ByteSize, error) {
+	if p := strings.TrimSuffix(v, "%"); p != v {
+		pct, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || pct <= 0 || pct > 100 {
+			return 0, fmt.Errorf("invalid disk usage limit %q: percentage must be within (0, 100]", v)
+		}
+		total, err := disk.TotalSpace(path)
+		if err != nil {
+			return 0, fmt.Errorf("disk usage limit: %w", err)
+		}
+		return bytesize.ByteSize(float64(total) * pct / 100), nil
+	}
+	size, err := bytesize.Parse(v)
+	if err != nil || size <= 0 {
+		return 0, fmt.Errorf("invalid disk usage limit %q", v)
+	}
+	return size, nil
+}
+
+// diskUsageTask removes the oldest data once the disk usage exceeds the
+// limit (high watermark), until the usage is reduced to the low watermark.
+func (s *Storage) diskUsageTask() {
+	usage := calculateDBSize(s.config.badgerBasePath)
+	if usage <= s.maxDiskUsage {
+		return
+	}
+	target := bytesize.ByteSize(float64(s.maxDiskUsage) * s.config.diskUsageLowWatermark)
+	s.logger.WithField("usage", usage).
+		WithField("limit", s.maxDiskUsage).
+		Warn("disk usage limit exceeded, removing the oldest data")
+	s.metrics.diskUsageEvictions.Inc()
+	err := s.reclaimSpace(int64(usage - target))
+	switch {
+	case err == nil:
+	case errors.Is(err, errClosed):
+		return
+	default:
+		s.logger.WithError(err).Error("failed to reclaim disk space")
+	}
+	// Otherwise, the space occupied by the removed trees is reclaimed
+	// eventually, and the data would be evicted again on the next run.
+	for s.trees.runGC(0.5) {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+	}
+}
+
+// reclaimSpace removes the oldest trees of every segment, so that the
+// estimated size of the removed data is roughly the given size. Each
+// segment loses the amount of data proportional to its size.
+func (s *Storage) reclaimSpace(size int64) error {
+	var segments []segmentSize
+	err := s.iterateOverAllSegments(func(k *segment.Key) error {
+		segments = append(segments, segmentSize{key: k, size: s.segmentTreesSize(k)})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return s.reclaimSegmentsSpace(segments, size)
+}
+
+type segmentSize struct {
+	key  *segment.Key
+	size int64
+}
+
+// reclaimSegmentsSpace removes the oldest trees of the segments,
+// proportionally to their sizes, see reclaimSpace.
+func (s *Storage) reclaimSegmentsSpace(segments []segmentSize, size int64) error {
+	var total int64
+	for _, x := range segments {
+		total += x.size
+	}
+	if total == 0 {
+		return nil
+	}
+	for _, x := range segments {
+		share := size * x.size / total
+		if share == 0 {
+			continue
+		}
+		if err := s.reclaimSegmentSpace(x.key, share); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// segmentTreesSize returns the estimated size of the segment trees,
+// counted the same way as in reclaimSegmentSpace.
+func (s *Storage) segmentTreesSize(k *segment.Key) int64 {
+	it := s.trees.NewIterator(backend.IteratorOptions{
+		AllVersions: true,
+		Prefix:      treePrefix.key(k.SegmentKey()),
+	})
+	defer it.Close()
+	var size int64
+	for it.Rewind(); it.Valid(); it.Next() {
+		size += it.Item().EstimatedSize()
+	}
+	return size
+}
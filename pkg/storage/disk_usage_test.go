@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+	"github.com/pyroscope-io/pyroscope/pkg/util/bytesize"
+)
+
+var _ = Describe("disk usage limit", func() {
+	var s *Storage
+
+	testing.WithConfig(func(cfg **config.Config) {
+		JustBeforeEach(func() {
+			var err error
+			(*cfg).Server.StorageMaxDiskUsage = "1TB"
+			(*cfg).Server.StorageDiskUsageLowWatermark = 0.9
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		JustAfterEach(func() {
+			Expect(s.Close()).To(Succeed())
+		})
+
+		It("removes the oldest trees first", func() {
+			Expect(s.maxDiskUsage).To(Equal(bytesize.TB))
+			k, err := segment.ParseKey("app.cpu")
+			Expect(err).ToNot(HaveOccurred())
+			// Keys of trees with negative timestamps (testing.SimpleTime)
+			// are not ordered by time.
+			base := time.Unix(1600000000, 0)
+			var treeKeys []string
+			for i := 0; i < 10; i++ {
+				t := tree.New()
+				t.Insert([]byte("a;b"), uint64(i+1))
+				st := base.Add(time.Duration(i) * 10 * time.Second)
+				Expect(s.Put(&PutInput{
+					StartTime: st,
+					EndTime:   st.Add(10 * time.Second),
+					Key:       k,
+					Val:       t,
+				})).To(Succeed())
+				treeKeys = append(treeKeys, k.TreeKey(0, st))
+			}
+			s.writeBackTask()
+			for _, treeKey := range treeKeys {
+				Eventually(func() error {
+					_, err := s.trees.Backend.Get(treePrefix.key(treeKey))
+					return err
+				}).Should(Succeed())
+			}
+			last := treeKeys[len(treeKeys)-1]
+
+			Expect(s.reclaimSpace(s.segmentTreesSize(k) / 4)).To(Succeed())
+			_, err = s.trees.Backend.Get(treePrefix.key(treeKeys[0]))
+			Expect(err).To(MatchError(backend.ErrNotFound))
+			_, err = s.trees.Backend.Get(treePrefix.key(last))
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	It("parses the limit", func() {
+		Expect(parseDiskUsageLimit("100GB", "")).To(Equal(100 * bytesize.GB))
+		Expect(parseDiskUsageLimit("50%", ".")).To(BeNumerically(">", 0))
+		for _, v := range []string{"", "0", "foo", "0%", "101%", "-5%"} {
+			_, err := parseDiskUsageLimit(v, ".")
+			Expect(err).To(HaveOccurred(), v)
+		}
+	})
+})
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+)
+
+// Databases may be encrypted at rest with AES. Badger encrypts data with
+// data keys which are rotated periodically and stored encrypted with the
+// storage encryption key; rotation of the storage key (RotateEncryptionKey)
+// therefore only re-encrypts the data keys.
+//
+// A storage created without encryption can be encrypted by rotating the
+// key from an empty one: data written before is only encrypted once
+// compacted.
+
+var errInvalidEncryptionKey = errors.New("invalid storage encryption key: " +
+	"must be 16, 24, or 32 bytes (AES-128, AES-192, or AES-256), raw or hex-encoded")
+
+// ReadEncryptionKey reads the storage encryption key from the file.
+// No key is returned if the file name is empty.
+func ReadEncryptionKey(file string) ([]byte, error) {
+	if file == "" {
+		return nil, nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading storage encryption key: %w", err)
+	}
+	t := bytes.TrimSpace(b)
+	k := make([]byte, hex.DecodedLen(len(t)))
+	if _, err = hex.Decode(k, t); err == nil && validEncryptionKeyLen(len(k)) {
+		return k, nil
+	}
+	if validEncryptionKeyLen(len(b)) {
+		return b, nil
+	}
+	return nil, errInvalidEncryptionKey
+}
+
+func validEncryptionKeyLen(n int) bool { return n == 16 || n == 24 || n == 32 }
+
+// loadEncryptionKey sets the encryption key of the databases.
+func (s *Storage) loadEncryptionKey() error {
+	c := s.config
+	if c.encryptionKeyFile == "" || c.inMemory {
+		return nil
+	}
+	if c.backend != backend.Badger {
+		return fmt.Errorf("storage encryption is not supported by %q backend", c.backend)
+	}
+	key, err := ReadEncryptionKey(c.encryptionKeyFile)
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Stat(c.encryptionKeyFile); err == nil && fi.Mode().Perm()&0o077 != 0 {
+		s.logger.WithField("file", c.encryptionKeyFile).
+			Warn("storage encryption key file is accessible by other users")
+	}
+	c.badgerOptions.EncryptionKey = key
+	c.badgerOptions.EncryptionKeyRotationDuration = c.encryptionKeyRotationInterval
+	return nil
+}
+
+// RotateEncryptionKey re-encrypts the storage at the path with the new
+// key. Either key may be empty, which means no encryption. The storage
+// must not be open. Databases that are already encrypted with the new
+// key are skipped, so that an interrupted rotation can be resumed.
+func RotateEncryptionKey(path string, oldKey, newKey []byte) error {
+	dirs := []string{"main", "dicts", "dimensions", "segments", "trees"}
+	shards, err := filepath.Glob(filepath.Join(path, treeShardsDir, "*"))
+	if err != nil {
+		return err
+	}
+	for _, shard := range shards {
+		rel, _ := filepath.Rel(path, shard)
+		dirs = append(dirs, rel)
+	}
+	for _, dir := range dirs {
+		p := filepath.Join(path, dir)
+		if _, err = os.Stat(p); os.IsNotExist(err) {
+			continue
+		}
+		err = backend.RotateBadgerEncryptionKey(p, oldKey, newKey)
+		if errors.Is(err, backend.ErrEncryptionKeyMismatch) {
+			// Already rotated?
+			if backend.RotateBadgerEncryptionKey(p, newKey, newKey) == nil {
+				continue
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", dir, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("storage encryption", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		writeKey := func(name string, key []byte) string {
+			p := filepath.Join((*cfg).Server.StoragePath, name)
+			Expect(os.WriteFile(p, key, 0o600)).To(Succeed())
+			return p
+		}
+
+		It("reads raw and hex-encoded keys", func() {
+			raw := []byte("0123456789abcdef")
+			k, err := ReadEncryptionKey(writeKey("raw.key", raw))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(k).To(Equal(raw))
+
+			k, err = ReadEncryptionKey(writeKey("hex.key", []byte(hex.EncodeToString(raw)+"\n")))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(k).To(Equal(raw))
+
+			_, err = ReadEncryptionKey(writeKey("short.key", []byte("short")))
+			Expect(err).To(MatchError(errInvalidEncryptionKey))
+		})
+
+		It("refuses to open the storage with a wrong key, and rotates the key", func() {
+			oldKey := []byte("old-key-old-key-old-key-old-key!")
+			newKey := []byte("new-key-new-key!")
+			(*cfg).Server.StorageEncryptionKeyFile = writeKey("old.key", oldKey)
+
+			s, err := New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+			st := time.Now().Truncate(10 * time.Second)
+			k, _ := segment.ParseKey("app.cpu{foo=bar}")
+			t := tree.New()
+			t.Insert([]byte("a;b"), 1)
+			Expect(s.Put(&PutInput{
+				StartTime: st,
+				EndTime:   st.Add(10 * time.Second),
+				Key:       k,
+				Val:       t,
+			})).To(Succeed())
+			Expect(s.Close()).To(Succeed())
+
+			(*cfg).Server.StorageEncryptionKeyFile = writeKey("new.key", newKey)
+			_, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).To(MatchError(backend.ErrEncryptionKeyMismatch))
+
+			Expect(RotateEncryptionKey((*cfg).Server.StoragePath, oldKey, newKey)).To(Succeed())
+			// Rotation can be resumed.
+			Expect(RotateEncryptionKey((*cfg).Server.StoragePath, oldKey, newKey)).To(Succeed())
+
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+			defer func() { Expect(s.Close()).To(Succeed()) }()
+			o, err := s.Get(&GetInput{StartTime: st, EndTime: st.Add(10 * time.Second), Key: k})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(o.Tree.String()).To(Equal(t.String()))
+		})
+	})
+})
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+)
+
+const exemplarsPrefix = "exemplar:"
+
+// Exemplar describes a profile ingested with a profile_id label, e.g. a
+// profile of a single request. The profile itself can be retrieved with
+// a query like app.name{profile_id="<id>"}.
+type Exemplar struct {
+	AppName   string    `json:"appName"`
+	ProfileID string    `json:"profileID"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+	// Labels of the profile other than the application name and
+	// profile ID, e.g. trace_id or span_id.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type GetExemplarsInput struct {
+	AppName   string
+	StartTime time.Time
+	EndTime   time.Time
+	// Labels, if specified, must match exemplar labels exactly.
+	Labels map[string]string
+	// Limit is the maximum number of exemplars returned; 0 means no limit.
+	Limit int
+}
+
+// saveExemplar records an exemplar for the profile. Exemplars with the same
+// profile ID are merged: the time range is extended to cover both.
+// Must be called with putMutex held.
+func (s *Storage) saveExemplar(pi *PutInput) error {
+	k := exemplarKey(pi.Key.AppName(), pi.Key.Labels()[segment.ProfileIDLabelName])
+	e := Exemplar{
+		AppName:   pi.Key.AppName(),
+		ProfileID: pi.Key.Labels()[segment.ProfileIDLabelName],
+		StartTime: pi.StartTime,
+		EndTime:   pi.EndTime,
+	}
+	for n, v := range pi.Key.Labels() {
+		if n == "__name__" || n == segment.ProfileIDLabelName {
+			continue
+		}
+		if e.Labels == nil {
+			e.Labels = make(map[string]string)
+		}
+		e.Labels[n] = v
+	}
+	var prev Exemplar
+	switch err := s.loadJSON(k, &prev); err {
+	case nil:
+		if prev.StartTime.Before(e.StartTime) {
+			e.StartTime = prev.StartTime
+		}
+		if prev.EndTime.After(e.EndTime) {
+			e.EndTime = prev.EndTime
+		}
+	case backend.ErrNotFound:
+	default:
+		return err
+	}
+	return s.saveJSON(k, e)
+}
+
+// GetExemplars returns exemplars of the application overlapping
+// the given time range.
+func (s *Storage) GetExemplars(gi *GetExemplarsInput) ([]Exemplar, error) {
+	exemplars := make([]Exemplar, 0)
+	it := s.main.NewIterator(backend.IteratorOptions{
+		Prefix:         []byte(exemplarKey(gi.AppName, "")),
+		PrefetchValues: true,
+	})
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		v, err := it.Item().ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		var e Exemplar
+		if err = json.Unmarshal(v, &e); err != nil {
+			s.logger.WithError(err).Warn("skipping malformed exemplar")
+			continue
+		}
+		if !e.matches(gi) {
+			continue
+		}
+		exemplars = append(exemplars, e)
+		if gi.Limit > 0 && len(exemplars) >= gi.Limit {
+			break
+		}
+	}
+	return exemplars, nil
+}
+
+func (e Exemplar) matches(gi *GetExemplarsInput) bool {
+	if !gi.StartTime.IsZero() && e.EndTime.Before(gi.StartTime) {
+		return false
+	}
+	if !gi.EndTime.IsZero() && e.StartTime.After(gi.EndTime) {
+		return false
+	}
+	for n, v := range gi.Labels {
+		if e.Labels[n] != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Storage) deleteExemplars(appName string) error {
+	return s.main.DropPrefix([]byte(exemplarKey(appName, "")))
+}
+
+func exemplarKey(appName, profileID string) string {
+	return exemplarsPrefix + appName + ":" + profileID
+}
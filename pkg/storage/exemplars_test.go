@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("exemplars", func() {
+	var s *Storage
+
+	testing.WithConfig(func(cfg **config.Config) {
+		JustBeforeEach(func() {
+			var err error
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		JustAfterEach(func() {
+			Expect(s.Close()).To(Succeed())
+		})
+
+		put := func(name string, st, et time.Time) {
+			k, err := segment.ParseKey(name)
+			Expect(err).ToNot(HaveOccurred())
+			t := tree.New()
+			t.Insert([]byte("a;b"), uint64(1))
+			Expect(s.Put(&PutInput{
+				StartTime:  st,
+				EndTime:    et,
+				Key:        k,
+				Val:        t,
+				SpyName:    "testspy",
+				SampleRate: 100,
+			})).To(Succeed())
+		}
+
+		t0 := testing.SimpleTime(0)
+		t1 := testing.SimpleTime(10)
+		t2 := testing.SimpleTime(20)
+		t3 := testing.SimpleTime(30)
+
+		It("stores exemplars of profiles with profile_id", func() {
+			put("app.cpu{profile_id=a,trace_id=x}", t0, t1)
+			put("app.cpu{profile_id=b,trace_id=y}", t2, t3)
+			put("app.cpu{foo=bar}", t0, t3)
+
+			exemplars, err := s.GetExemplars(&GetExemplarsInput{AppName: "app.cpu"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(exemplars).To(ConsistOf(
+				Exemplar{AppName: "app.cpu", ProfileID: "a", StartTime: t0, EndTime: t1, Labels: map[string]string{"trace_id": "x"}},
+				Exemplar{AppName: "app.cpu", ProfileID: "b", StartTime: t2, EndTime: t3, Labels: map[string]string{"trace_id": "y"}},
+			))
+		})
+
+		It("merges time ranges of exemplars with the same profile ID", func() {
+			put("app.cpu{profile_id=a}", t1, t2)
+			put("app.cpu{profile_id=a}", t0, t1)
+			exemplars, err := s.GetExemplars(&GetExemplarsInput{AppName: "app.cpu"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(exemplars).To(HaveLen(1))
+			Expect(exemplars[0].StartTime).To(Equal(t0))
+			Expect(exemplars[0].EndTime).To(Equal(t2))
+		})
+
+		It("filters exemplars", func() {
+			put("app.cpu{profile_id=a,trace_id=x}", t0, t1)
+			put("app.cpu{profile_id=b,trace_id=y}", t2, t3)
+			put("app.cpu.other{profile_id=c}", t0, t3)
+
+			exemplars, err := s.GetExemplars(&GetExemplarsInput{AppName: "app.cpu", StartTime: testing.SimpleTime(15), EndTime: t3})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(exemplars).To(HaveLen(1))
+			Expect(exemplars[0].ProfileID).To(Equal("b"))
+
+			exemplars, err = s.GetExemplars(&GetExemplarsInput{AppName: "app.cpu", Labels: map[string]string{"trace_id": "x"}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(exemplars).To(HaveLen(1))
+			Expect(exemplars[0].ProfileID).To(Equal("a"))
+
+			exemplars, err = s.GetExemplars(&GetExemplarsInput{AppName: "app.cpu", Limit: 1})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(exemplars).To(HaveLen(1))
+		})
+
+		It("deletes exemplars with the app", func() {
+			put("app.cpu{profile_id=a}", t0, t1)
+			Expect(s.DeleteApp("app.cpu")).To(Succeed())
+			exemplars, err := s.GetExemplars(&GetExemplarsInput{AppName: "app.cpu"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(exemplars).To(BeEmpty())
+		})
+	})
+})
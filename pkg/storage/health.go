@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+)
+
+// healthCheckKey is written to and removed from every database
+// by CheckDatabases. It does not match any of the database prefixes.
+var healthCheckKey = []byte("health-check")
+
+// healthCheckWriteInterval is the minimum interval between the writes
+// of CheckDatabases: the health endpoints don't require authentication.
+const healthCheckWriteInterval = 10 * time.Second
+
+type healthCheck struct {
+	sync.Mutex
+	// written is the time of the last successful write check.
+	written time.Time
+}
+
+// CheckDatabases verifies that the databases are open and writable.
+// The databases are read from on every call, but written to at most
+// once per healthCheckWriteInterval. If the storage can not be modified
+// (read-only mode or standby), the databases are only read from.
+func (s *Storage) CheckDatabases() error {
+	s.healthCheck.Lock()
+	defer s.healthCheck.Unlock()
+	now := time.Now()
+	write := s.writable() == nil && now.Sub(s.healthCheck.written) >= healthCheckWriteInterval
+	for _, d := range s.databases() {
+		if err := d.check(write); err != nil {
+			return fmt.Errorf("%s database: %w", d.name, err)
+		}
+	}
+	if write {
+		s.healthCheck.written = now
+	}
+	return nil
+}
+
+func (d *db) check(write bool) error {
+	if !write {
+		if _, err := d.Backend.Get(healthCheckKey); err != nil && !errors.Is(err, backend.ErrNotFound) {
+			return err
+		}
+		return nil
+	}
+	if err := d.Backend.Set(healthCheckKey, []byte{1}); err != nil {
+		return err
+	}
+	if _, err := d.Backend.Get(healthCheckKey); err != nil {
+		return err
+	}
+	return d.Backend.Delete(healthCheckKey)
+}
+
+// QueueUsage returns the number of profiles in the ingestion queue
+// (see Enqueue) and its capacity; profiles are dropped once it's full.
+func (s *Storage) QueueUsage() (n, capacity int) {
+	return len(s.queue), cap(s.queue)
+}
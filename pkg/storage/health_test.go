@@ -0,0 +1,58 @@
+package storage
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("storage health", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		open := func(c *Config) *Storage {
+			s, err := New(c, logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+			return s
+		}
+
+		It("checks the databases are open and writable", func() {
+			s := open(NewConfig(&(*cfg).Server))
+			Expect(s.CheckDatabases()).To(Succeed())
+			_, err := s.main.Backend.Get(healthCheckKey)
+			Expect(err).To(MatchError(backend.ErrNotFound))
+			n, capacity := s.QueueUsage()
+			Expect(n).To(BeZero())
+			Expect(capacity).To(Equal(s.queueLen))
+
+			Expect(s.Close()).To(Succeed())
+			Expect(s.CheckDatabases()).To(HaveOccurred())
+		})
+
+		It("throttles database writes", func() {
+			s := open(NewConfig(&(*cfg).Server))
+			defer s.Close()
+			Expect(s.CheckDatabases()).To(Succeed())
+			written := s.healthCheck.written
+			Expect(written).ToNot(BeZero())
+			Expect(s.CheckDatabases()).To(Succeed())
+			Expect(s.healthCheck.written).To(Equal(written))
+
+			s.healthCheck.written = written.Add(-healthCheckWriteInterval)
+			Expect(s.CheckDatabases()).To(Succeed())
+			Expect(s.healthCheck.written).To(BeTemporally(">", written))
+		})
+
+		It("does not write to read-only storage", func() {
+			s := open(NewConfig(&(*cfg).Server))
+			Expect(s.Close()).To(Succeed())
+			s = open(NewConfig(&(*cfg).Server).WithReadOnly())
+			defer s.Close()
+			Expect(s.CheckDatabases()).To(Succeed())
+		})
+	})
+})
@@ -1,47 +1,89 @@
 package storage
 
 import (
-	"github.com/dgraph-io/badger/v2"
+	"errors"
+	"os"
+	"strings"
+
 	"github.com/google/uuid"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
 )
 
-const installID = "installID"
+const (
+	installID       = "installID"
+	installIDEnvVar = "PYROSCOPE_INSTALL_ID"
+)
 
+// InstallID returns the install ID resolved from the following sources,
+// in order of precedence:
+//   - PYROSCOPE_INSTALL_ID environment variable;
+//   - the file specified with install-id-file option;
+//   - the value persisted in the main database.
+//
+// If none of them provides an ID, a new one is generated and persisted.
+// The resolved value is cached for the storage lifetime.
 func (s *Storage) InstallID() string {
-	var id []byte
-	err := s.main.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(installID))
-		if err != nil {
-			if err == badger.ErrKeyNotFound {
-				return nil
-			}
-			return err
-		}
+	s.installIDMutex.Lock()
+	defer s.installIDMutex.Unlock()
+	if s.cachedInstallID != "" {
+		return s.cachedInstallID
+	}
+	if id := strings.TrimSpace(os.Getenv(installIDEnvVar)); id != "" {
+		s.cachedInstallID = id
+		return id
+	}
+	if id := s.installIDFromFile(); id != "" {
+		s.cachedInstallID = id
+		return id
+	}
+	id, ok := s.persistedInstallID()
+	if ok {
+		s.cachedInstallID = id
+	}
+	return id
+}
 
-		err = item.Value(func(val []byte) error {
-			id = append([]byte{}, val...)
-			return nil
-		})
-		if err != nil {
-			return err
-		}
-		return nil
-	})
+// Ready reports whether the storage is able to provide a valid install ID.
+// Until then, InstallID may return a placeholder value.
+func (s *Storage) Ready() bool {
+	s.InstallID()
+	s.installIDMutex.Lock()
+	defer s.installIDMutex.Unlock()
+	return s.cachedInstallID != ""
+}
+
+func (s *Storage) installIDFromFile() string {
+	if s.config.installIDFile == "" {
+		return ""
+	}
+	b, err := os.ReadFile(s.config.installIDFile)
 	if err != nil {
-		return "id-read-error"
+		if !os.IsNotExist(err) {
+			s.logger.WithError(err).Warn("failed to read install ID file")
+		}
+		return ""
 	}
+	return strings.TrimSpace(string(b))
+}
 
-	if id == nil {
+// persistedInstallID returns the install ID stored in the main database,
+// generating a new one if it does not exist. If the ID can not be read or
+// written, a placeholder value is returned and ok is false.
+func (s *Storage) persistedInstallID() (string, bool) {
+	id, err := s.main.Get([]byte(installID))
+	switch {
+	case err == nil:
+	case errors.Is(err, backend.ErrNotFound):
 		id = []byte(newID())
-		err = s.main.Update(func(txn *badger.Txn) error {
-			return txn.SetEntry(badger.NewEntry([]byte(installID), id))
-		})
-		if err != nil {
-			return "id-write-error"
+		if err = s.main.Set([]byte(installID), id); err != nil {
+			return "id-write-error", false
 		}
+	default:
+		return "id-read-error", false
 	}
 
-	return string(id)
+	return string(id), true
 }
 
 func newID() string {
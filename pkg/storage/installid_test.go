@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("InstallID", func() {
+	var s *Storage
+
+	testing.WithConfig(func(cfg **config.Config) {
+		JustBeforeEach(func() {
+			var err error
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		JustAfterEach(func() {
+			Expect(s.Close()).To(Succeed())
+		})
+
+		writeFile := func(id string) {
+			path := filepath.Join((*cfg).Server.StoragePath, "install-id")
+			Expect(os.WriteFile(path, []byte(id+"\n"), 0o600)).To(Succeed())
+			(*cfg).Server.InstallIDFile = path
+		}
+
+		Context("when environment variable is set", func() {
+			BeforeEach(func() {
+				Expect(os.Setenv(installIDEnvVar, "env-id")).To(Succeed())
+				writeFile("file-id")
+			})
+
+			AfterEach(func() {
+				Expect(os.Unsetenv(installIDEnvVar)).To(Succeed())
+			})
+
+			It("takes precedence over other sources", func() {
+				Expect(s.InstallID()).To(Equal("env-id"))
+			})
+		})
+
+		Context("when install ID file is configured", func() {
+			BeforeEach(func() {
+				writeFile("file-id")
+			})
+
+			It("takes precedence over the persisted value", func() {
+				Expect(s.main.Set([]byte(installID), []byte("persisted-id"))).To(Succeed())
+				Expect(s.InstallID()).To(Equal("file-id"))
+			})
+		})
+
+		Context("when install ID file does not exist", func() {
+			BeforeEach(func() {
+				(*cfg).Server.InstallIDFile = filepath.Join((*cfg).Server.StoragePath, "missing")
+			})
+
+			It("falls through to the persisted value", func() {
+				Expect(s.main.Set([]byte(installID), []byte("persisted-id"))).To(Succeed())
+				Expect(s.InstallID()).To(Equal("persisted-id"))
+			})
+		})
+
+		Context("when no source provides an ID", func() {
+			It("generates, persists, and caches a new one", func() {
+				id := s.InstallID()
+				Expect(id).ToNot(BeEmpty())
+				persisted, ok := s.persistedInstallID()
+				Expect(ok).To(BeTrue())
+				Expect(persisted).To(Equal(id))
+
+				Expect(os.Setenv(installIDEnvVar, "env-id")).To(Succeed())
+				defer os.Unsetenv(installIDEnvVar)
+				Expect(s.InstallID()).To(Equal(id))
+			})
+
+			It("reports storage is ready once the ID is persisted", func() {
+				Expect(s.Ready()).To(BeTrue())
+				Expect(s.cachedInstallID).ToNot(BeEmpty())
+			})
+		})
+	})
+})
@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/wal"
+	"github.com/pyroscope-io/pyroscope/pkg/util/varint"
+)
+
+// Every ingested profile is journaled to the write-ahead log before it is
+// applied, and the log is truncated once the caches are written back to
+// disk. On startup, profiles journaled since the last checkpoint are
+// replayed.
+//
+// Note that trees evicted from cache under memory pressure are written to
+// disk before the next checkpoint: if the process crashes in between,
+// the corresponding profiles are applied twice.
+
+const journalRecordVersion = 1
+
+var errJournalRecordVersion = errors.New("unsupported write-ahead log record version")
+
+func (s *Storage) openJournal() error {
+	policy, err := wal.ParseSyncPolicy(s.config.walFsync)
+	if err != nil {
+		return err
+	}
+	s.journal, err = wal.Open(wal.Options{
+		Dir:          filepath.Join(s.config.badgerBasePath, "wal"),
+		Sync:         policy,
+		SyncInterval: s.config.walFsyncInterval,
+		Logger:       s.logger,
+	})
+	return err
+}
+
+// replayJournal applies profiles journaled before the storage was
+// closed abnormally, and removes them from the log once persisted.
+func (s *Storage) replayJournal() error {
+	var n int
+	last, err := s.journal.Replay(func(b []byte) error {
+		pi, err := decodePutInput(b)
+		if err != nil {
+			s.logger.WithError(err).Warn("skipping invalid write-ahead log record")
+			return nil
+		}
+		if err = s.put(pi); err != nil {
+			s.logger.WithError(err).WithField("key", pi.Key.Normalized()).
+				Warn("failed to replay write-ahead log record")
+		}
+		n++
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("replay write-ahead log: %w", err)
+	}
+	if last == 0 {
+		return nil
+	}
+	s.logger.WithField("profiles", n).Info("replayed write-ahead log")
+	s.writeBack()
+	return s.journal.Truncate(last)
+}
+
+// checkpoint writes back the caches and removes the journaled profiles
+// that are persisted as a result.
+func (s *Storage) checkpoint() error {
+	s.putMutex.Lock()
+	defer s.putMutex.Unlock()
+	return s.truncateJournal()
+}
+
+// Must be called with putMutex held.
+func (s *Storage) truncateJournal() error {
+	prev, err := s.journal.Rotate()
+	if err != nil {
+		return err
+	}
+	s.writeBack()
+	return s.journal.Truncate(prev)
+}
+
+func (s *Storage) closeJournal() error {
+	// All the data is persisted at this point.
+	prev, err := s.journal.Rotate()
+	if err == nil {
+		err = s.journal.Truncate(prev)
+	}
+	if cerr := s.journal.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// A record is a version byte, followed by the profile metadata and the
+// tree stacks, each with its self value. Trees are journaled entirely:
+// they are only truncated when persisted.
+func encodePutInput(pi *PutInput) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(journalRecordVersion)
+	varint.Write(&buf, uint64(pi.StartTime.UnixNano()))
+	varint.Write(&buf, uint64(pi.EndTime.UnixNano()))
+	writeJournalString(&buf, pi.Key.Normalized())
+	writeJournalString(&buf, pi.SpyName)
+	varint.Write(&buf, uint64(pi.SampleRate))
+	writeJournalString(&buf, pi.Units)
+	writeJournalString(&buf, pi.AggregationType)
+	pi.Val.RLock()
+	pi.Val.IterateStacks(func(_ string, self uint64, stack []string) {
+		for i, j := 0, len(stack)-1; i < j; i, j = i+1, j-1 {
+			stack[i], stack[j] = stack[j], stack[i]
+		}
+		writeJournalString(&buf, strings.Join(stack, ";"))
+		varint.Write(&buf, self)
+	})
+	pi.Val.RUnlock()
+	return buf.Bytes()
+}
+
+func decodePutInput(b []byte) (*PutInput, error) {
+	r := bytes.NewReader(b)
+	v, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if v != journalRecordVersion {
+		return nil, fmt.Errorf("%w: %d", errJournalRecordVersion, v)
+	}
+	var (
+		pi         PutInput
+		st, et, sr uint64
+		key        string
+	)
+	if st, err = varint.Read(r); err != nil {
+		return nil, err
+	}
+	if et, err = varint.Read(r); err != nil {
+		return nil, err
+	}
+	if key, err = readJournalString(r); err != nil {
+		return nil, err
+	}
+	if pi.SpyName, err = readJournalString(r); err != nil {
+		return nil, err
+	}
+	if sr, err = varint.Read(r); err != nil {
+		return nil, err
+	}
+	if pi.Units, err = readJournalString(r); err != nil {
+		return nil, err
+	}
+	if pi.AggregationType, err = readJournalString(r); err != nil {
+		return nil, err
+	}
+	if pi.Key, err = segment.ParseKey(key); err != nil {
+		return nil, err
+	}
+	pi.Val = tree.New()
+	for r.Len() > 0 {
+		stack, err := readJournalString(r)
+		if err != nil {
+			return nil, err
+		}
+		self, err := varint.Read(r)
+		if err != nil {
+			return nil, err
+		}
+		pi.Val.Insert([]byte(stack), self)
+	}
+	pi.StartTime = time.Unix(0, int64(st))
+	pi.EndTime = time.Unix(0, int64(et))
+	pi.SampleRate = uint32(sr)
+	return &pi, nil
+}
+
+func writeJournalString(buf *bytes.Buffer, v string) {
+	varint.Write(buf, uint64(len(v)))
+	buf.WriteString(v)
+}
+
+func readJournalString(r *bytes.Reader) (string, error) {
+	n, err := varint.Read(r)
+	if err != nil {
+		return "", err
+	}
+	if n > uint64(r.Len()) {
+		return "", io.ErrUnexpectedEOF
+	}
+	b := make([]byte, n)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
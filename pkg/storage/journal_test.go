@@ -0,0 +1,119 @@
+package storage
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("write-ahead log", func() {
+	var s *Storage
+
+	testing.WithConfig(func(cfg **config.Config) {
+		open := func() *Storage {
+			// Data of the memory backend does not survive the storage:
+			// only journaled profiles do.
+			(*cfg).Server.StorageBackend = backend.Memory
+			(*cfg).Server.StorageWAL = true
+			(*cfg).Server.StorageWALFsync = "always"
+			st, err := New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+			return st
+		}
+
+		crash := func(st *Storage) {
+			close(st.stop)
+			st.queueWorkersWG.Wait()
+			st.tasksWG.Wait()
+		}
+
+		JustBeforeEach(func() {
+			s = open()
+		})
+
+		JustAfterEach(func() {
+			Expect(s.Close()).To(Succeed())
+		})
+
+		k, _ := segment.ParseKey("app.cpu{foo=bar}")
+		st := time.Now().Add(-time.Hour).Truncate(10 * time.Second)
+		put := func() {
+			t := tree.New()
+			t.Insert([]byte("a;b"), uint64(1))
+			t.Insert([]byte("a;c"), uint64(2))
+			Expect(s.Put(&PutInput{
+				StartTime:  st,
+				EndTime:    st.Add(10 * time.Second),
+				Key:        k,
+				Val:        t,
+				SpyName:    "testspy",
+				SampleRate: 100,
+			})).To(Succeed())
+		}
+
+		get := func() *GetOutput {
+			o, err := s.Get(&GetInput{StartTime: st, EndTime: st.Add(10 * time.Second), Key: k})
+			Expect(err).ToNot(HaveOccurred())
+			return o
+		}
+
+		It("replays profiles ingested before a crash", func() {
+			put()
+			put()
+			crash(s)
+
+			s = open()
+			o := get()
+			Expect(o).ToNot(BeNil())
+			Expect(o.Tree.String()).To(Equal("a;b 2\na;c 4\n"))
+			Expect(o.SpyName).To(Equal("testspy"))
+			Expect(o.SampleRate).To(Equal(uint32(100)))
+		})
+
+		It("does not replay checkpointed profiles", func() {
+			put()
+			Expect(s.checkpoint()).To(Succeed())
+			crash(s)
+
+			s = open()
+			Expect(get()).To(BeNil())
+		})
+	})
+
+	It("encodes profiles", func() {
+		k, err := segment.ParseKey("app.cpu{foo=bar}")
+		Expect(err).ToNot(HaveOccurred())
+		t := tree.New()
+		t.Insert([]byte("a;b"), uint64(1))
+		pi := &PutInput{
+			StartTime:       time.Unix(1600000000, 0),
+			EndTime:         time.Unix(1600000010, 0),
+			Key:             k,
+			Val:             t,
+			SpyName:         "gospy",
+			SampleRate:      100,
+			Units:           "samples",
+			AggregationType: "sum",
+		}
+		b := encodePutInput(pi)
+		r, err := decodePutInput(b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.Val.String()).To(Equal(t.String()))
+		r.Val, pi.Val = nil, nil
+		Expect(r).To(Equal(pi))
+
+		b[0] = 0
+		_, err = decodePutInput(b)
+		Expect(err).To(MatchError(errJournalRecordVersion))
+	})
+})
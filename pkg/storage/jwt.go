@@ -2,9 +2,10 @@ package storage
 
 import (
 	"crypto/rand"
+	"errors"
 	"math/big"
 
-	"github.com/dgraph-io/badger/v2"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
 )
 
 const (
@@ -13,41 +14,20 @@ const (
 )
 
 func (s *Storage) JWT() (string, error) {
-	var secret []byte
-	err := s.main.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(jwtSecret))
-		if err != nil {
-			if err == badger.ErrKeyNotFound {
-				return nil
-			}
-			return err
-		}
-
-		err = item.Value(func(val []byte) error {
-			secret = append([]byte{}, val...)
-			return nil
-		})
-		if err != nil {
-			return err
-		}
-		return nil
-	})
-	if err != nil {
-		return "", err
-	}
-
-	if secret == nil {
+	secret, err := s.main.Get([]byte(jwtSecret))
+	switch {
+	case err == nil:
+	case errors.Is(err, backend.ErrNotFound):
 		generatedJWT, err := newJWTSecret()
 		if err != nil {
 			return "", err
 		}
 		secret = []byte(generatedJWT)
-		err = s.main.Update(func(txn *badger.Txn) error {
-			return txn.SetEntry(badger.NewEntry([]byte(jwtSecret), secret))
-		})
-		if err != nil {
+		if err = s.main.Set([]byte(jwtSecret), secret); err != nil {
 			return "", err
 		}
+	default:
+		return "", err
 	}
 
 	return string(secret), nil
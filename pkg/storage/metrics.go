@@ -10,21 +10,50 @@ import (
 type metrics struct {
 	putTotal              prometheus.Counter
 	getTotal              prometheus.Counter
+	queryCacheHits        prometheus.Counter
+	queryCacheMisses      prometheus.Counter
+	getDuration           prometheus.Histogram
+	treeMergeDuration     prometheus.Histogram
 	retentionTaskDuration prometheus.Summary
 	evictionTaskDuration  prometheus.Summary
 	writeBackTaskDuration prometheus.Summary
+	offloadTaskDuration   prometheus.Summary
 
-	dbSize    *prometheus.GaugeVec
-	cacheSize *prometheus.GaugeVec
-	gcCount   *prometheus.CounterVec
+	tombstonesTaskDuration prometheus.Summary
+
+	offloadedTrees prometheus.Counter
+	offloadedBytes prometheus.Counter
+
+	diskUsageEvictions prometheus.Counter
+	evictedTrees       prometheus.Counter
+	evictedBytes       prometheus.Counter
+	quotaEvictions     prometheus.Counter
+
+	cardinalityLimitExceeded *prometheus.CounterVec
+
+	dictGCReclaimedBytes prometheus.Counter
+	treeShardsRemoved    prometheus.Counter
+
+	dbSize         *prometheus.GaugeVec
+	cacheSize      *prometheus.GaugeVec
+	cacheSizeBytes *prometheus.GaugeVec
+	gcCount        *prometheus.CounterVec
 
 	cacheMisses   *prometheus.CounterVec
 	cacheReads    *prometheus.CounterVec
 	cacheDBWrites *prometheus.HistogramVec
 	cacheDBReads  *prometheus.HistogramVec
 
-	evictionsDuration *prometheus.SummaryVec
-	writeBackDuration *prometheus.SummaryVec
+	cacheSizeEvictions *prometheus.CounterVec
+
+	snapshotsShipped         prometheus.Counter
+	snapshotsApplied         prometheus.Counter
+	snapshotShippingDuration prometheus.Summary
+	snapshotApplyingDuration prometheus.Summary
+
+	evictionsDuration  *prometheus.SummaryVec
+	compactionDuration *prometheus.SummaryVec
+	writeBackDuration  *prometheus.SummaryVec
 }
 
 func newMetrics(r prometheus.Registerer) *metrics {
@@ -38,6 +67,24 @@ func newMetrics(r prometheus.Registerer) *metrics {
 			Name: "pyroscope_storage_reads_total",
 			Help: "number of calls to storage.Get",
 		}),
+		queryCacheHits: promauto.With(r).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_storage_query_cache_hits_total",
+			Help: "number of queries served from the query cache",
+		}),
+		queryCacheMisses: promauto.With(r).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_storage_query_cache_misses_total",
+			Help: "number of queries not found in the query cache",
+		}),
+		getDuration: promauto.With(r).NewHistogram(prometheus.HistogramOpts{
+			Name:    "pyroscope_storage_read_duration_seconds",
+			Help:    "duration of calls to storage.Get",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+		}),
+		treeMergeDuration: promauto.With(r).NewHistogram(prometheus.HistogramOpts{
+			Name:    "pyroscope_storage_tree_merge_duration_seconds",
+			Help:    "time spent merging trees of a single storage.Get call",
+			Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+		}),
 
 		retentionTaskDuration: promauto.With(r).NewSummary(prometheus.SummaryOpts{
 			Name:       "pyroscope_storage_retention_task_duration_seconds",
@@ -54,6 +101,74 @@ func newMetrics(r prometheus.Registerer) *metrics {
 			Help:       "duration of write-back writes (triggered periodically)",
 			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
 		}),
+		offloadTaskDuration: promauto.With(r).NewSummary(prometheus.SummaryOpts{
+			Name:       "pyroscope_storage_offload_task_duration_seconds",
+			Help:       "duration of offloading old trees to object storage",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		}),
+		tombstonesTaskDuration: promauto.With(r).NewSummary(prometheus.SummaryOpts{
+			Name:       "pyroscope_storage_tombstones_task_duration_seconds",
+			Help:       "duration of deletion of data matching tombstones",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		}),
+
+		snapshotsShipped: promauto.With(r).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_storage_snapshots_shipped_total",
+			Help: "number of storage snapshots shipped to a warm standby",
+		}),
+		snapshotsApplied: promauto.With(r).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_storage_snapshots_applied_total",
+			Help: "number of storage snapshots applied by a warm standby",
+		}),
+		snapshotShippingDuration: promauto.With(r).NewSummary(prometheus.SummaryOpts{
+			Name:       "pyroscope_storage_snapshot_shipping_duration_seconds",
+			Help:       "duration of dumping and uploading storage snapshots",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		}),
+		snapshotApplyingDuration: promauto.With(r).NewSummary(prometheus.SummaryOpts{
+			Name:       "pyroscope_storage_snapshot_applying_duration_seconds",
+			Help:       "duration of downloading and applying storage snapshots",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		}),
+		offloadedTrees: promauto.With(r).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_storage_offloaded_trees_total",
+			Help: "number of trees offloaded to object storage",
+		}),
+		offloadedBytes: promauto.With(r).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_storage_offloaded_bytes_total",
+			Help: "size of blocks uploaded to object storage",
+		}),
+
+		diskUsageEvictions: promauto.With(r).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_storage_disk_usage_evictions_total",
+			Help: "number of times the oldest data was removed because disk usage limit was exceeded",
+		}),
+		evictedTrees: promauto.With(r).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_storage_disk_usage_evicted_trees_total",
+			Help: "number of trees removed because disk usage limit was exceeded",
+		}),
+		evictedBytes: promauto.With(r).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_storage_disk_usage_evicted_bytes_total",
+			Help: "estimated size of trees removed because disk usage limit was exceeded",
+		}),
+		quotaEvictions: promauto.With(r).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_storage_quota_evictions_total",
+			Help: "number of times the oldest data of an application was removed because its storage quota was exceeded",
+		}),
+
+		cardinalityLimitExceeded: promauto.With(r).NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_storage_cardinality_limit_exceeded_total",
+			Help: "number of profiles exceeding the tag values or series limit, by the action taken",
+		}, []string{"limit", "action"}),
+
+		dictGCReclaimedBytes: promauto.With(r).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_storage_dictionary_gc_reclaimed_bytes_total",
+			Help: "size of dictionary entries removed because they are no longer referenced",
+		}),
+		treeShardsRemoved: promauto.With(r).NewCounter(prometheus.CounterOpts{
+			Name: "pyroscope_storage_tree_shards_removed_total",
+			Help: "number of tree shards removed because they are out of retention",
+		}),
 
 		dbSize: promauto.With(r).NewGaugeVec(prometheus.GaugeOpts{
 			Name: "pyroscope_storage_db_size_bytes",
@@ -67,6 +182,14 @@ func newMetrics(r prometheus.Registerer) *metrics {
 			Name: "pyroscope_storage_db_cache_size",
 			Help: "number of items in cache",
 		}, name),
+		cacheSizeBytes: promauto.With(r).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "pyroscope_storage_db_cache_size_bytes",
+			Help: "estimated size of items in cache, only reported for caches with a size limit",
+		}, name),
+		cacheSizeEvictions: promauto.With(r).NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_storage_db_cache_size_evictions_total",
+			Help: "total number of items evicted because the cache exceeds its size limit",
+		}, name),
 
 		cacheDBWrites: promauto.With(r).NewHistogramVec(prometheus.HistogramOpts{
 			Name:    "pyroscope_storage_db_cache_write_bytes",
@@ -93,6 +216,11 @@ func newMetrics(r prometheus.Registerer) *metrics {
 			Help:       "duration of evictions (triggered when there's memory pressure)",
 			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
 		}, name),
+		compactionDuration: promauto.With(r).NewSummaryVec(prometheus.SummaryOpts{
+			Name:       "pyroscope_storage_db_compaction_duration_seconds",
+			Help:       "duration of database compaction, including the time spent throttled",
+			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
+		}, name),
 		writeBackDuration: promauto.With(r).NewSummaryVec(prometheus.SummaryOpts{
 			Name:       "pyroscope_storage_cache_writeback_duration_seconds",
 			Help:       "duration of write-back writes (triggered periodically)",
@@ -103,11 +231,12 @@ func newMetrics(r prometheus.Registerer) *metrics {
 
 func (m *metrics) createCacheMetrics(name string) *cache.Metrics {
 	return &cache.Metrics{
-		MissesCounter:     m.cacheMisses.WithLabelValues(name),
-		ReadsCounter:      m.cacheReads.WithLabelValues(name),
-		DBWrites:          m.cacheDBWrites.WithLabelValues(name),
-		DBReads:           m.cacheDBReads.WithLabelValues(name),
-		EvictionsDuration: m.evictionsDuration.WithLabelValues(name),
-		WriteBackDuration: m.writeBackDuration.WithLabelValues(name),
+		MissesCounter:        m.cacheMisses.WithLabelValues(name),
+		ReadsCounter:         m.cacheReads.WithLabelValues(name),
+		DBWrites:             m.cacheDBWrites.WithLabelValues(name),
+		DBReads:              m.cacheDBReads.WithLabelValues(name),
+		EvictionsDuration:    m.evictionsDuration.WithLabelValues(name),
+		WriteBackDuration:    m.writeBackDuration.WithLabelValues(name),
+		SizeEvictionsCounter: m.cacheSizeEvictions.WithLabelValues(name),
 	}
 }
@@ -6,8 +6,7 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/dgraph-io/badger/v2"
-
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/dict"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
 )
@@ -30,6 +29,8 @@ func (s *Storage) migrate() error {
 		return nil
 	case ver > len(migrations):
 		return fmt.Errorf("db version %d: future versions are not supported", ver)
+	case s.config.readOnly:
+		return fmt.Errorf("db version %d: migrations can not be applied in read-only mode", ver)
 	}
 	for v, m := range migrations[ver:] {
 		if err = m(s); err != nil {
@@ -41,30 +42,19 @@ func (s *Storage) migrate() error {
 
 // dbVersion returns the number of migrations applied to the storage.
 func (s *Storage) dbVersion() (int, error) {
-	var version int
-	err := s.main.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(dbVersionKey))
-		if err != nil {
-			return err
-		}
-		return item.Value(func(val []byte) error {
-			version, err = strconv.Atoi(string(val))
-			return err
-		})
-	})
-	if errors.Is(err, badger.ErrKeyNotFound) {
+	v, err := s.main.Get([]byte(dbVersionKey))
+	switch {
+	case err == nil:
+		return strconv.Atoi(string(v))
+	case errors.Is(err, backend.ErrNotFound):
 		return 0, nil
+	default:
+		return 0, err
 	}
-	return version, err
 }
 
 func (s *Storage) setDbVersion(v int) error {
-	return s.main.Update(func(txn *badger.Txn) error {
-		return txn.SetEntry(&badger.Entry{
-			Key:   []byte(dbVersionKey),
-			Value: []byte(strconv.Itoa(v)),
-		})
-	})
+	return s.main.Set([]byte(dbVersionKey), []byte(strconv.Itoa(v)))
 }
 
 // In 0.0.34 we changed dictionary key format from normalized segment key
@@ -85,58 +75,57 @@ func (s *Storage) setDbVersion(v int) error {
 func migrateDictionaryKeys(s *Storage) error {
 	appNameKeys := map[string]struct{}{}
 	segmentNameKeys := map[string][]byte{}
-	return s.dicts.Update(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.Prefix = dictionaryPrefix.bytes()
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		// Find all dicts with keys:
-		//  - in normalized segment key format.
-		//  - in application name format.
-		for it.Rewind(); it.Valid(); it.Next() {
-			item := it.Item()
-			k := item.Key()
-			item.ExpiresAt()
-			k, ok := dictionaryPrefix.trim(k)
-			if !ok {
-				continue
-			}
-			// Make sure the dictionary is valid.
-			b, err := item.ValueCopy(nil)
-			if err != nil {
-				return err
-			}
-			d, err := dict.FromBytes(b)
-			if err != nil {
-				return err
-			}
-			if d == nil {
-				continue
-			}
-			if !strings.Contains(string(k), "{") {
-				appNameKeys[string(k)] = struct{}{}
-			} else {
-				segmentNameKeys[string(k)] = b
-			}
+	it := s.dicts.NewIterator(backend.IteratorOptions{
+		Prefix:         dictionaryPrefix.bytes(),
+		PrefetchValues: true,
+	})
+	defer it.Close()
+	// Find all dicts with keys:
+	//  - in normalized segment key format.
+	//  - in application name format.
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		k, ok := dictionaryPrefix.trim(item.Key())
+		if !ok {
+			continue
+		}
+		// Make sure the dictionary is valid.
+		b, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
 		}
+		d, err := dict.FromBytes(b)
+		if err != nil {
+			return err
+		}
+		if d == nil {
+			continue
+		}
+		if !strings.Contains(string(k), "{") {
+			appNameKeys[string(k)] = struct{}{}
+		} else {
+			segmentNameKeys[string(k)] = b
+		}
+	}
 
-		for k, v := range segmentNameKeys {
-			dictKey := segment.FromTreeToDictKey(k)
-			if _, ok := appNameKeys[dictKey]; ok {
-				// The dictionary is most likely incomplete and causes
-				// the problem described in the function comment.
-				continue
-			}
-			// Migration from version before 0.0.34.
-			if err := txn.Set(dictionaryPrefix.key(dictKey), v); err != nil {
-				return err
-			}
-			// Remove dict stored with old keys.
-			if err := txn.Delete(dictionaryPrefix.key(k)); err != nil {
-				return err
-			}
+	batch := s.dicts.NewWriteBatch()
+	defer batch.Cancel()
+	for k, v := range segmentNameKeys {
+		dictKey := segment.FromTreeToDictKey(k)
+		if _, ok := appNameKeys[dictKey]; ok {
+			// The dictionary is most likely incomplete and causes
+			// the problem described in the function comment.
+			continue
+		}
+		// Migration from version before 0.0.34.
+		if err := batch.Set(dictionaryPrefix.key(dictKey), v); err != nil {
+			return err
+		}
+		// Remove dict stored with old keys.
+		if err := batch.Delete(dictionaryPrefix.key(k)); err != nil {
+			return err
 		}
+	}
 
-		return nil
-	})
+	return batch.Flush()
 }
@@ -0,0 +1,74 @@
+package objstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+type fsStore struct{ dir string }
+
+func newFS(dir string) (*fsStore, error) {
+	if dir == "" {
+		return nil, errors.New("object storage directory is not specified")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &fsStore{dir: dir}, nil
+}
+
+func (s *fsStore) path(name string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(name))
+}
+
+// Put writes the object to a temporary file first, so that
+// partially written objects are never visible.
+func (s *fsStore) Put(name string, data []byte) error {
+	p := s.path(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+func (s *fsStore) Get(name string) ([]byte, error) {
+	b, err := os.ReadFile(s.path(name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return b, err
+}
+
+func (s *fsStore) ReadRange(name string, offset, length int64) ([]byte, error) {
+	f, err := os.Open(s.path(name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	defer f.Close()
+	b := make([]byte, length)
+	if _, err = f.ReadAt(b, offset); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("object %q: range %d-%d is out of bounds", name, offset, offset+length)
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+func (s *fsStore) Delete(name string) error {
+	err := os.Remove(s.path(name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
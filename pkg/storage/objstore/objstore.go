@@ -0,0 +1,61 @@
+// Package objstore provides access to object storage services
+// immutable data blocks are kept in.
+package objstore
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ErrNotFound is returned when the requested object does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// Store is an object storage bucket. Implementations must be safe
+// for concurrent use.
+type Store interface {
+	// Put uploads the object, replacing existing one, if any.
+	Put(name string, data []byte) error
+	// Get downloads the whole object.
+	Get(name string) ([]byte, error)
+	// ReadRange reads length bytes of the object starting at offset.
+	ReadRange(name string, offset, length int64) ([]byte, error)
+	Delete(name string) error
+}
+
+type Options struct {
+	// Endpoint overrides the default service endpoint,
+	// e.g. to use an S3-compatible storage.
+	Endpoint string
+	Region   string
+}
+
+// Open returns the store for the given URL. Supported schemes:
+//   - s3://bucket/prefix: Amazon S3 or S3-compatible storage.
+//   - gs://bucket/prefix: Google Cloud Storage via its S3-compatible
+//     XML API; HMAC keys are to be used as credentials.
+//   - file:///path: local directory, e.g. a mounted bucket.
+func Open(rawURL string, o Options) (Store, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("object storage url: %w", err)
+	}
+	prefix := strings.Trim(u.Path, "/")
+	switch u.Scheme {
+	case "s3":
+		return newS3(u.Host, prefix, o)
+	case "gs":
+		if o.Endpoint == "" {
+			o.Endpoint = gcsEndpoint
+		}
+		if o.Region == "" {
+			o.Region = "auto"
+		}
+		return newS3(u.Host, prefix, o)
+	case "file":
+		return newFS(u.Path)
+	default:
+		return nil, fmt.Errorf("object storage url %q: unsupported scheme %q", rawURL, u.Scheme)
+	}
+}
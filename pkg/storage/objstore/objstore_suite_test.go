@@ -0,0 +1,13 @@
+package objstore_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestObjstore(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Objstore Suite")
+}
@@ -0,0 +1,50 @@
+package objstore_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/objstore"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("objstore", func() {
+	It("reads and writes objects in a directory", func() {
+		tdir := testing.TmpDirSync()
+		defer tdir.Close()
+
+		s, err := objstore.Open("file://"+tdir.Path+"/blocks", objstore.Options{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(s.Put("foo/bar.block", []byte("0123456789"))).To(Succeed())
+
+		b, err := s.ReadRange("foo/bar.block", 2, 3)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(b)).To(Equal("234"))
+		_, err = s.ReadRange("foo/bar.block", 8, 3)
+		Expect(err).To(HaveOccurred())
+		b, err = s.Get("foo/bar.block")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(b)).To(Equal("0123456789"))
+
+		Expect(s.Delete("foo/bar.block")).To(Succeed())
+		Expect(s.Delete("foo/bar.block")).To(Succeed())
+		_, err = s.ReadRange("foo/bar.block", 0, 1)
+		Expect(err).To(MatchError(objstore.ErrNotFound))
+		_, err = s.Get("foo/bar.block")
+		Expect(err).To(MatchError(objstore.ErrNotFound))
+	})
+
+	It("supports s3 and gs URLs", func() {
+		_, err := objstore.Open("s3://bucket/prefix", objstore.Options{Region: "us-east-1"})
+		Expect(err).ToNot(HaveOccurred())
+		_, err = objstore.Open("gs://bucket", objstore.Options{})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("rejects unsupported URLs", func() {
+		_, err := objstore.Open("ftp://bucket", objstore.Options{})
+		Expect(err).To(MatchError(`object storage url "ftp://bucket": unsupported scheme "ftp"`))
+		_, err = objstore.Open("s3:///prefix", objstore.Options{})
+		Expect(err).To(HaveOccurred())
+	})
+})
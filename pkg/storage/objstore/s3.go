@@ -0,0 +1,109 @@
+package objstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const gcsEndpoint = "https://storage.googleapis.com"
+
+type s3Store struct {
+	client *s3.S3
+	bucket string
+	prefix string
+}
+
+// newS3 creates a client using the default credential chain:
+// environment variables, shared credentials file, or instance role.
+func newS3(bucket, prefix string, o Options) (*s3Store, error) {
+	if bucket == "" {
+		return nil, errors.New("object storage bucket is not specified")
+	}
+	c := aws.NewConfig()
+	if o.Region != "" {
+		c = c.WithRegion(o.Region)
+	}
+	if o.Endpoint != "" {
+		c = c.WithEndpoint(o.Endpoint).WithS3ForcePathStyle(true)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *c,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("object storage session: %w", err)
+	}
+	return &s3Store{
+		client: s3.New(sess),
+		bucket: bucket,
+		prefix: prefix,
+	}, nil
+}
+
+func (s *s3Store) key(name string) *string { return aws.String(path.Join(s.prefix, name)) }
+
+func (s *s3Store) Put(name string, data []byte) error {
+	_, err := s.client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    s.key(name),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+func (s *s3Store) Get(name string) ([]byte, error) {
+	out, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    s.key(name),
+	})
+	if err != nil {
+		var e awserr.Error
+		if errors.As(err, &e) && e.Code() == s3.ErrCodeNoSuchKey {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	defer out.Body.Close()
+	b, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("object %q: %w", name, err)
+	}
+	return b, nil
+}
+
+func (s *s3Store) ReadRange(name string, offset, length int64) ([]byte, error) {
+	out, err := s.client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    s.key(name),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		var e awserr.Error
+		if errors.As(err, &e) && e.Code() == s3.ErrCodeNoSuchKey {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	defer out.Body.Close()
+	b := make([]byte, length)
+	if _, err = io.ReadFull(out.Body, b); err != nil {
+		return nil, fmt.Errorf("object %q: %w", name, err)
+	}
+	return b, nil
+}
+
+func (s *s3Store) Delete(name string) error {
+	_, err := s.client.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    s.key(name),
+	})
+	return err
+}
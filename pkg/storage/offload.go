@@ -0,0 +1,222 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/objstore"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+)
+
+// Trees that are old enough are offloaded to object storage: tree values
+// of a segment are uploaded as a single immutable block, and local values
+// are replaced with references to the block. References are resolved
+// transparently when trees are read.
+//
+// A block consists of the tree values, followed by the JSON-encoded index
+// of the block (tree keys, offsets and lengths of the values), the index
+// length as a big-endian uint64, and the block magic.
+//
+// Blocks are never modified or removed: if an offloaded tree is updated,
+// it is stored locally again and offloaded with the next block. Blocks
+// should be removed by the object storage lifecycle policy.
+
+const maxBlockSize = 64 << 20
+
+var (
+	blockMagic    = []byte("PYROBLK1")
+	blockRefMagic = []byte{0xff, 'b', 'l', 'k'}
+)
+
+type blockRef struct {
+	name   string
+	offset int64
+	length int64
+}
+
+func (r blockRef) bytes() []byte {
+	b := make([]byte, len(blockRefMagic)+2*binary.MaxVarintLen64+len(r.name))
+	n := copy(b, blockRefMagic)
+	n += binary.PutUvarint(b[n:], uint64(r.offset))
+	n += binary.PutUvarint(b[n:], uint64(r.length))
+	n += copy(b[n:], r.name)
+	return b[:n]
+}
+
+// parseBlockRef reports whether v is a reference to a value stored in
+// a block. A serialized tree can't be mistaken for it: trees start with
+// the format version.
+func parseBlockRef(v []byte) (blockRef, bool) {
+	var r blockRef
+	if !bytes.HasPrefix(v, blockRefMagic) {
+		return r, false
+	}
+	v = v[len(blockRefMagic):]
+	offset, n := binary.Uvarint(v)
+	if n <= 0 {
+		return r, false
+	}
+	v = v[n:]
+	length, n := binary.Uvarint(v)
+	if n <= 0 {
+		return r, false
+	}
+	r.name = string(v[n:])
+	r.offset = int64(offset)
+	r.length = int64(length)
+	return r, true
+}
+
+// offloadedBackend resolves references to values offloaded to object storage.
+type offloadedBackend struct {
+	backend.Backend
+	objects objstore.Store
+}
+
+func (b *offloadedBackend) Get(key []byte) ([]byte, error) {
+	v, err := b.Backend.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if r, ok := parseBlockRef(v); ok {
+		return b.objects.ReadRange(r.name, r.offset, r.length)
+	}
+	return v, nil
+}
+
+type blockIndexEntry struct {
+	Key    string `json:"key"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+}
+
+type blockWriter struct {
+	buf   bytes.Buffer
+	index []blockIndexEntry
+}
+
+func (w *blockWriter) add(k, v []byte) {
+	w.index = append(w.index, blockIndexEntry{
+		Key:    string(k),
+		Offset: int64(w.buf.Len()),
+		Length: int64(len(v)),
+	})
+	w.buf.Write(v)
+}
+
+func (w *blockWriter) bytes() ([]byte, error) {
+	index, err := json.Marshal(w.index)
+	if err != nil {
+		return nil, err
+	}
+	w.buf.Write(index)
+	var l [8]byte
+	binary.BigEndian.PutUint64(l[:], uint64(len(index)))
+	w.buf.Write(l[:])
+	w.buf.Write(blockMagic)
+	return w.buf.Bytes(), nil
+}
+
+func (s *Storage) offloadTask() {
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(s.metrics.offloadTaskDuration.Observe))
+	defer timer.ObserveDuration()
+	err := s.offloadTrees(time.Now().Add(-s.config.objectStorageMinAge))
+	if err != nil && !errors.Is(err, errClosed) {
+		s.logger.WithError(err).Error("failed to offload trees to object storage")
+	}
+}
+
+// offloadTrees offloads trees that only contain data collected
+// before the given time.
+func (s *Storage) offloadTrees(before time.Time) error {
+	return s.iterateOverAllSegments(func(k *segment.Key) error {
+		return s.offloadSegmentTrees(k, before)
+	})
+}
+
+func (s *Storage) offloadSegmentTrees(k *segment.Key, before time.Time) error {
+	it := s.trees.NewIterator(backend.IteratorOptions{
+		Prefix:         treePrefix.key(k.SegmentKey() + ":"),
+		PrefetchValues: true,
+	})
+	defer it.Close()
+	w := new(blockWriter)
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		tk, ok := treePrefix.trim(item.Key())
+		if !ok {
+			continue
+		}
+		t, depth, err := segment.ParseTreeKey(string(tk))
+		if err != nil || t.Add(segment.DurationForDepth(depth)).After(before) {
+			continue
+		}
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if _, ok = parseBlockRef(v); ok {
+			continue
+		}
+		w.add(item.KeyCopy(nil), v)
+		if w.buf.Len() >= maxBlockSize {
+			if err = s.uploadBlock(k, w); err != nil {
+				return err
+			}
+			w = new(blockWriter)
+		}
+	}
+	return s.uploadBlock(k, w)
+}
+
+// uploadBlock uploads the block and replaces the values with references.
+func (s *Storage) uploadBlock(k *segment.Key, w *blockWriter) error {
+	if len(w.index) == 0 {
+		return nil
+	}
+	select {
+	case <-s.stop:
+		return errClosed
+	default:
+	}
+	b, err := w.bytes()
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s/%d.block", url.PathEscape(k.SegmentKey()), time.Now().UnixNano())
+	if err = s.objects.Put(name, b); err != nil {
+		return fmt.Errorf("uploading block %q: %w", name, err)
+	}
+	s.metrics.offloadedBytes.Add(float64(len(b)))
+
+	batchSize := s.trees.MaxBatchCount()
+	batch := s.trees.NewWriteBatch()
+	defer func() {
+		batch.Cancel()
+	}()
+	for i, e := range w.index {
+		r := blockRef{name: name, offset: e.Offset, length: e.Length}
+		if err = batch.Set([]byte(e.Key), r.bytes()); err != nil {
+			return err
+		}
+		if int64(i+1)%batchSize == 0 {
+			if err = batch.Flush(); err != nil {
+				return err
+			}
+			batch = s.trees.NewWriteBatch()
+		}
+	}
+	if err = batch.Flush(); err != nil {
+		return err
+	}
+	s.metrics.offloadedTrees.Add(float64(len(w.index)))
+	return nil
+}
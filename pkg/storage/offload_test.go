@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("offloading to object storage", func() {
+	var s *Storage
+
+	testing.WithConfig(func(cfg **config.Config) {
+		JustBeforeEach(func() {
+			var err error
+			(*cfg).Server.ObjectStorageURL = "file://" + filepath.Join((*cfg).Server.StoragePath, "objects")
+			(*cfg).Server.ObjectStorageMinAge = 24 * time.Hour
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		JustAfterEach(func() {
+			Expect(s.Close()).To(Succeed())
+		})
+
+		// Cache items are written to disk asynchronously.
+		persist := func(b backend.Backend, treeKey string) {
+			s.writeBackTask()
+			Eventually(func() error {
+				_, err := b.Get(treePrefix.key(treeKey))
+				return err
+			}).Should(Succeed())
+		}
+
+		It("offloads old trees and reads them back", func() {
+			k, err := segment.ParseKey("app.cpu{foo=bar}")
+			Expect(err).ToNot(HaveOccurred())
+			t := tree.New()
+			t.Insert([]byte("a;b"), uint64(1))
+			t.Insert([]byte("a;c"), uint64(2))
+			st := testing.SimpleTime(10)
+			et := testing.SimpleTime(19)
+			Expect(s.Put(&PutInput{
+				StartTime:  st,
+				EndTime:    et,
+				Key:        k,
+				Val:        t,
+				SpyName:    "testspy",
+				SampleRate: 100,
+			})).To(Succeed())
+			treeKey := k.TreeKey(0, st)
+			local := s.trees.Backend.(*offloadedBackend).Backend
+			persist(local, treeKey)
+
+			Expect(s.offloadTrees(time.Now())).To(Succeed())
+			v, err := local.Get(treePrefix.key(treeKey))
+			Expect(err).ToNot(HaveOccurred())
+			r, ok := parseBlockRef(v)
+			Expect(ok).To(BeTrue())
+			_, err = os.Stat(filepath.Join((*cfg).Server.StoragePath, "objects", filepath.FromSlash(r.name)))
+			Expect(err).ToNot(HaveOccurred())
+
+			// Already offloaded trees are skipped.
+			Expect(s.offloadTrees(time.Now())).To(Succeed())
+			v2, err := local.Get(treePrefix.key(treeKey))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(v2).To(Equal(v))
+
+			s.trees.Discard(treeKey)
+			o, err := s.Get(&GetInput{StartTime: st, EndTime: et, Key: k})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(o.Tree.String()).To(Equal(t.String()))
+		})
+
+		It("keeps recent trees locally", func() {
+			k, err := segment.ParseKey("app.cpu")
+			Expect(err).ToNot(HaveOccurred())
+			t := tree.New()
+			t.Insert([]byte("a;b"), uint64(1))
+			st := time.Now().Add(-time.Hour).Truncate(10 * time.Second)
+			Expect(s.Put(&PutInput{
+				StartTime: st,
+				EndTime:   st.Add(10 * time.Second),
+				Key:       k,
+				Val:       t,
+			})).To(Succeed())
+			local := s.trees.Backend.(*offloadedBackend).Backend
+			persist(local, k.TreeKey(0, st))
+
+			Expect(s.offloadTrees(time.Now().Add(-24 * time.Hour))).To(Succeed())
+			v, err := local.Get(treePrefix.key(k.TreeKey(0, st)))
+			Expect(err).ToNot(HaveOccurred())
+			_, ok := parseBlockRef(v)
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	It("encodes block references", func() {
+		r := blockRef{name: "app.cpu%7B%7D/1.block", offset: 1 << 40, length: 123}
+		p, ok := parseBlockRef(r.bytes())
+		Expect(ok).To(BeTrue())
+		Expect(p).To(Equal(r))
+		_, ok = parseBlockRef([]byte{1, 2, 3})
+		Expect(ok).To(BeFalse())
+	})
+
+	It("fails to start with unsupported object storage", func() {
+		c := NewConfig(&config.Server{ObjectStorageURL: "ftp://foo"}).WithInMemory().WithBackend(backend.Memory)
+		_, err := New(c, logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+		Expect(err).To(HaveOccurred())
+	})
+})
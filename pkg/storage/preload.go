@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+)
+
+// Dimensions and segments are loaded to cache lazily, therefore the first
+// queries after restart are slow. Optionally, indexes of the most recently
+// written applications are preloaded in parallel on startup. To pick the
+// applications, the time of the latest profile of every application is
+// stored in the main database.
+
+const (
+	appWritesPrefix = "app-written:"
+
+	preloadProgressInterval = 10 * time.Second
+)
+
+// Preloaded reports whether the storage indexes are loaded. It's always
+// true if preloading is disabled.
+func (s *Storage) Preloaded() bool { return atomic.LoadUint32(&s.preloading) == 0 }
+
+// recordAppWrite remembers the time of the latest profile of the app,
+// the time is persisted on write back.
+func (s *Storage) recordAppWrite(app string, t time.Time) {
+	s.appWritesMutex.Lock()
+	defer s.appWritesMutex.Unlock()
+	if s.appWrites == nil {
+		s.appWrites = make(map[string]int64)
+	}
+	if v := t.Unix(); v > s.appWrites[app] {
+		s.appWrites[app] = v
+	}
+}
+
+func (s *Storage) writeBackAppWrites() {
+	s.appWritesMutex.Lock()
+	w := s.appWrites
+	s.appWrites = nil
+	s.appWritesMutex.Unlock()
+	for app, t := range w {
+		if err := s.main.Set([]byte(appWritesPrefix+app), []byte(strconv.FormatInt(t, 10))); err != nil {
+			s.logger.WithError(err).WithField("app", app).Error("failed to save application write time")
+		}
+	}
+}
+
+func (s *Storage) deleteAppWrite(app string) error {
+	s.appWritesMutex.Lock()
+	delete(s.appWrites, app)
+	s.appWritesMutex.Unlock()
+	return s.main.Backend.Delete([]byte(appWritesPrefix + app))
+}
+
+// recentApps returns up to n applications, most recently written first.
+func (s *Storage) recentApps(n int) ([]string, error) {
+	type appWrite struct {
+		app string
+		t   int64
+	}
+	var apps []appWrite
+	it := s.main.NewIterator(backend.IteratorOptions{
+		Prefix:         []byte(appWritesPrefix),
+		PrefetchValues: true,
+	})
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, err
+		}
+		t, err := strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			continue
+		}
+		apps = append(apps, appWrite{app: string(item.Key()[len(appWritesPrefix):]), t: t})
+	}
+	sort.Slice(apps, func(i, j int) bool { return apps[i].t > apps[j].t })
+	if len(apps) > n {
+		apps = apps[:n]
+	}
+	r := make([]string, len(apps))
+	for i, x := range apps {
+		r[i] = x.app
+	}
+	return r, nil
+}
+
+// startPreload loads indexes of the most recently written applications
+// in background. The storage is not Preloaded until it's done.
+func (s *Storage) startPreload() {
+	atomic.StoreUint32(&s.preloading, 1)
+	s.tasksWG.Add(1)
+	go func() {
+		defer s.tasksWG.Done()
+		defer atomic.StoreUint32(&s.preloading, 0)
+		apps, err := s.recentApps(s.config.preloadApps)
+		if err != nil {
+			s.logger.WithError(err).Error("failed to preload storage indexes")
+			return
+		}
+		s.preload(apps)
+	}()
+}
+
+func (s *Storage) preload(apps []string) {
+	start := time.Now()
+	s.logger.WithField("apps", len(apps)).Info("preloading storage indexes")
+	var (
+		loadedApps     int64
+		loadedSegments int64
+		wg             sync.WaitGroup
+	)
+	queue := make(chan string)
+	for i := 0; i < s.preloadWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for app := range queue {
+				atomic.AddInt64(&loadedSegments, int64(s.preloadApp(app)))
+				atomic.AddInt64(&loadedApps, 1)
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(preloadProgressInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				s.logger.
+					WithField("apps", atomic.LoadInt64(&loadedApps)).
+					WithField("total", len(apps)).
+					WithField("segments", atomic.LoadInt64(&loadedSegments)).
+					Info("preloading storage indexes")
+			}
+		}
+	}()
+
+enqueue:
+	for _, app := range apps {
+		select {
+		case <-s.stop:
+			break enqueue
+		case queue <- app:
+		}
+	}
+	close(queue)
+	wg.Wait()
+	close(done)
+
+	s.logger.
+		WithField("apps", loadedApps).
+		WithField("segments", loadedSegments).
+		WithField("duration", time.Since(start)).
+		Info("storage indexes preloaded")
+}
+
+// preloadApp loads the application dictionary, segments, and dimensions
+// to cache, and returns the number of segments loaded.
+func (s *Storage) preloadApp(app string) int {
+	d, ok := s.lookupAppDimension(app)
+	if !ok {
+		return 0
+	}
+	s.dicts.Lookup(app)
+	var n int
+	for _, k := range d.Keys {
+		select {
+		case <-s.stop:
+			return n
+		default:
+		}
+		sk := string(k)
+		if _, ok = s.segments.Lookup(sk); !ok {
+			continue
+		}
+		n++
+		key, err := segment.ParseKey(sk)
+		if err != nil {
+			continue
+		}
+		for name, value := range key.Labels() {
+			s.lookupDimensionKV(name, value)
+		}
+	}
+	return n
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("index preloading", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		It("preloads indexes of the most recently written apps on startup", func() {
+			s, err := New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.Preloaded()).To(BeTrue())
+
+			st := time.Now().Add(-time.Hour).Truncate(10 * time.Second)
+			for i, name := range []string{"old.cpu{foo=bar}", "mid.cpu{foo=baz}", "new.cpu{foo=qux}"} {
+				k, err := segment.ParseKey(name)
+				Expect(err).ToNot(HaveOccurred())
+				t := tree.New()
+				t.Insert([]byte("a;b"), 1)
+				pst := st.Add(time.Duration(i) * time.Minute)
+				Expect(s.Put(&PutInput{
+					StartTime: pst,
+					EndTime:   pst.Add(10 * time.Second),
+					Key:       k,
+					Val:       t,
+				})).To(Succeed())
+			}
+			Expect(s.Close()).To(Succeed())
+
+			(*cfg).Server.StoragePreloadApps = 2
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+			defer func() { Expect(s.Close()).To(Succeed()) }()
+
+			Expect(s.recentApps(10)).To(Equal([]string{"new.cpu", "mid.cpu", "old.cpu"}))
+			Eventually(s.Preloaded).Should(BeTrue())
+			Expect(s.segments.Cache.Size()).To(Equal(uint64(2)))
+			// Application and label dimensions.
+			Expect(s.dimensions.Cache.Size()).To(BeNumerically(">=", 4))
+		})
+	})
+})
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+)
+
+// queryCacheResolution is the resolution the time range of cached
+// queries is aligned to: it matches the finest resolution of segments,
+// so that queries of a dashboard refreshed periodically hit the cache.
+const queryCacheResolution = 10 * time.Second
+
+// queryCache keeps results of recent queries. An entry is invalidated
+// once its TTL expires, or on a write to (or deletion from) the
+// application within the time range of the query.
+type queryCache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	m       sync.Mutex
+	entries map[queryCacheKey]*queryCacheEntry
+	// generation is incremented when the cache is purged, and
+	// appGenerations on every invalidation of the application, so
+	// that results of queries running concurrently are not cached.
+	generation     uint64
+	appGenerations map[string]uint64
+}
+
+// queryCacheGeneration identifies the state of the application data
+// a query result is obtained from.
+type queryCacheGeneration struct {
+	global uint64
+	app    uint64
+}
+
+type queryCacheKey struct {
+	query       string
+	startTime   int64
+	endTime     int64
+	aggregation Aggregation
+}
+
+type queryCacheEntry struct {
+	appName   string
+	startTime time.Time
+	endTime   time.Time
+	expiresAt time.Time
+	output    *GetOutput
+}
+
+func newQueryCache(ttl time.Duration, maxEntries int) *queryCache {
+	return &queryCache{
+		ttl:            ttl,
+		maxEntries:     maxEntries,
+		entries:        make(map[queryCacheKey]*queryCacheEntry),
+		appGenerations: make(map[string]uint64),
+	}
+}
+
+// alignQueryTimeRange returns the time range extended to the query
+// cache resolution.
+func alignQueryTimeRange(st, et time.Time) (time.Time, time.Time) {
+	st = st.Truncate(queryCacheResolution)
+	if t := et.Truncate(queryCacheResolution); t.Before(et) {
+		et = t.Add(queryCacheResolution)
+	}
+	return st, et
+}
+
+func newQueryCacheKey(gi *GetInput) (queryCacheKey, string) {
+	k := queryCacheKey{
+		startTime:   gi.StartTime.UnixNano(),
+		endTime:     gi.EndTime.UnixNano(),
+		aggregation: gi.Aggregation,
+	}
+	if gi.Key != nil {
+		k.query = "key:" + gi.Key.Normalized()
+		return k, gi.Key.AppName()
+	}
+	k.query = "query:" + gi.Query.String()
+	return k, gi.Query.AppName
+}
+
+// get returns a copy of the cached output, callers may modify it.
+func (c *queryCache) get(gi *GetInput) (*GetOutput, bool) {
+	k, _ := newQueryCacheKey(gi)
+	c.m.Lock()
+	defer c.m.Unlock()
+	e, ok := c.entries[k]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(e.expiresAt) {
+		delete(c.entries, k)
+		return nil, false
+	}
+	return copyGetOutput(e.output), true
+}
+
+// currentGeneration returns the generation of the application the query
+// refers to: writes to other applications do not affect the query.
+func (c *queryCache) currentGeneration(gi *GetInput) queryCacheGeneration {
+	_, appName := newQueryCacheKey(gi)
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.generationOf(appName)
+}
+
+func (c *queryCache) generationOf(appName string) queryCacheGeneration {
+	return queryCacheGeneration{global: c.generation, app: c.appGenerations[appName]}
+}
+
+// put caches the output of the query, unless the application entries
+// were invalidated since the given generation.
+func (c *queryCache) put(gi *GetInput, o *GetOutput, generation queryCacheGeneration) {
+	k, appName := newQueryCacheKey(gi)
+	now := time.Now()
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.generationOf(appName) != generation {
+		return
+	}
+	if _, ok := c.entries[k]; !ok && len(c.entries) >= c.maxEntries {
+		c.evict(now)
+	}
+	c.entries[k] = &queryCacheEntry{
+		appName:   appName,
+		startTime: gi.StartTime,
+		endTime:   gi.EndTime,
+		expiresAt: now.Add(c.ttl),
+		output:    copyGetOutput(o),
+	}
+}
+
+// evict removes expired entries, or the one expiring first, if there
+// are none.
+func (c *queryCache) evict(now time.Time) {
+	var (
+		oldest    queryCacheKey
+		oldestExp time.Time
+		expired   bool
+	)
+	for k, e := range c.entries {
+		if now.After(e.expiresAt) {
+			delete(c.entries, k)
+			expired = true
+			continue
+		}
+		if oldestExp.IsZero() || e.expiresAt.Before(oldestExp) {
+			oldest, oldestExp = k, e.expiresAt
+		}
+	}
+	if !expired && !oldestExp.IsZero() {
+		delete(c.entries, oldest)
+	}
+}
+
+// invalidate removes entries of the application overlapping the time
+// range. Zero time range invalidates all the entries of the application.
+func (c *queryCache) invalidate(appName string, st, et time.Time) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.appGenerations[appName]++
+	for k, e := range c.entries {
+		if e.appName != appName {
+			continue
+		}
+		if st.IsZero() && et.IsZero() || e.startTime.Before(et) && st.Before(e.endTime) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+func (c *queryCache) purge() {
+	c.m.Lock()
+	c.generation++
+	c.entries = make(map[queryCacheKey]*queryCacheEntry)
+	c.appGenerations = make(map[string]uint64)
+	c.m.Unlock()
+}
+
+func copyGetOutput(o *GetOutput) *GetOutput {
+	if o == nil {
+		return nil
+	}
+	c := *o
+	if o.Tree != nil {
+		c.Tree = o.Tree.Clone(big.NewRat(1, 1))
+	}
+	if o.Timeline != nil {
+		t := *o.Timeline
+		t.Samples = append([]uint64(nil), o.Timeline.Samples...)
+		c.Timeline = &t
+	}
+	return &c
+}
+
+// The methods below are no-op if the query cache is disabled.
+
+func (s *Storage) invalidateQueryCache(appName string, st, et time.Time) {
+	if s.queryCache != nil {
+		s.queryCache.invalidate(appName, st, et)
+	}
+}
+
+func (s *Storage) invalidateQueryCacheKey(k *segment.Key) {
+	s.invalidateQueryCache(k.AppName(), time.Time{}, time.Time{})
+}
+
+func (s *Storage) purgeQueryCache() {
+	if s.queryCache != nil {
+		s.queryCache.purge()
+	}
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/flameql"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("query cache", func() {
+	var s *Storage
+
+	testing.WithConfig(func(cfg **config.Config) {
+		JustBeforeEach(func() {
+			var err error
+			(*cfg).Server.StorageQueryCacheTTL = time.Minute
+			(*cfg).Server.StorageQueryCacheMaxEntries = 2
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		JustAfterEach(func() {
+			Expect(s.Close()).To(Succeed())
+		})
+
+		st := time.Now().Add(-time.Hour).Truncate(100 * time.Second)
+		put := func(name string, i int) {
+			k, err := segment.ParseKey(name)
+			Expect(err).ToNot(HaveOccurred())
+			t := tree.New()
+			t.Insert([]byte("a;b"), uint64(1))
+			Expect(s.Put(&PutInput{
+				StartTime: st.Add(time.Duration(i) * 10 * time.Second),
+				EndTime:   st.Add(time.Duration(i+1) * 10 * time.Second),
+				Key:       k,
+				Val:       t,
+			})).To(Succeed())
+		}
+
+		samples := func(query string, d time.Duration) uint64 {
+			q, err := flameql.ParseQuery(query)
+			Expect(err).ToNot(HaveOccurred())
+			// Unaligned time range.
+			o, err := s.Get(&GetInput{StartTime: st.Add(time.Second), EndTime: st.Add(d), Query: q})
+			Expect(err).ToNot(HaveOccurred())
+			if o == nil {
+				return 0
+			}
+			// Modifications must not affect the cached result.
+			o.Tree.Insert([]byte("c"), 100)
+			return o.Tree.Samples() - 100
+		}
+
+		It("caches query results", func() {
+			put("app.cpu{foo=bar}", 0)
+			put("app.cpu{foo=bar}", 1)
+			Expect(samples("app.cpu{}", 20*time.Second)).To(Equal(uint64(2)))
+			Expect(samples("app.cpu{}", 20*time.Second)).To(Equal(uint64(2)))
+			Expect(testutil.ToFloat64(s.queryCacheHits)).To(Equal(float64(1)))
+			Expect(testutil.ToFloat64(s.queryCacheMisses)).To(Equal(float64(1)))
+		})
+
+		It("invalidates results on writes within the time range", func() {
+			put("app.cpu{foo=bar}", 0)
+			put("other.cpu{}", 0)
+			Expect(samples("app.cpu{}", 20*time.Second)).To(Equal(uint64(1)))
+			Expect(samples("other.cpu{}", 20*time.Second)).To(Equal(uint64(1)))
+
+			put("app.cpu{foo=baz}", 1)
+			put("other.cpu{}", 5)
+			Expect(samples("app.cpu{}", 20*time.Second)).To(Equal(uint64(2)))
+			Expect(samples("other.cpu{}", 20*time.Second)).To(Equal(uint64(1)))
+			Expect(testutil.ToFloat64(s.queryCacheHits)).To(Equal(float64(1)))
+		})
+
+		It("caches results of queries running concurrently with writes to other applications", func() {
+			put("app.cpu{}", 0)
+			q, err := flameql.ParseQuery("app.cpu{}")
+			Expect(err).ToNot(HaveOccurred())
+			gi := &GetInput{StartTime: st, EndTime: st.Add(20 * time.Second), Query: q}
+			g := s.queryCache.currentGeneration(gi)
+			o, err := s.Get(gi)
+			Expect(err).ToNot(HaveOccurred())
+
+			put("other.cpu{}", 0)
+			s.queryCache.put(gi, o, g)
+			_, ok := s.queryCache.get(gi)
+			Expect(ok).To(BeTrue())
+
+			g = s.queryCache.currentGeneration(gi)
+			put("app.cpu{}", 1)
+			s.queryCache.put(gi, o, g)
+			_, ok = s.queryCache.get(gi)
+			Expect(ok).To(BeFalse())
+		})
+
+		It("invalidates results on deletion", func() {
+			put("app.cpu{foo=bar}", 0)
+			Expect(samples("app.cpu{}", 20*time.Second)).To(Equal(uint64(1)))
+			Expect(s.DeleteApp("app.cpu")).To(Succeed())
+			Expect(samples("app.cpu{}", 20*time.Second)).To(BeZero())
+		})
+
+		It("limits the number of entries", func() {
+			put("app.cpu{}", 0)
+			for i := 1; i <= 3; i++ {
+				samples("app.cpu{}", time.Duration(i)*10*time.Second)
+			}
+			Expect(s.queryCache.entries).To(HaveLen(2))
+		})
+	})
+})
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/util/bytesize"
+)
+
+// appQuota is the maximum size of the stored data of every application
+// with the name matching the pattern. Application name ends with the
+// profile type (e.g., "myapp.alloc_space"), so quotas can be specified
+// per profile type with patterns like "*.alloc_space".
+type appQuota struct {
+	pattern string
+	size    bytesize.ByteSize
+}
+
+// parseAppQuotas parses pattern=size pairs. Similarly to app retention,
+// the longest pattern takes precedence and patterns are case-insensitive.
+func parseAppQuotas(m map[string]string) ([]appQuota, error) {
+	r := make([]appQuota, 0, len(m))
+	for pattern, v := range m {
+		pattern = strings.ToLower(pattern)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid storage quota pattern %q: %w", pattern, err)
+		}
+		size, err := bytesize.Parse(v)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid storage quota for %q: %q", pattern, v)
+		}
+		r = append(r, appQuota{pattern: pattern, size: size})
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if len(r[i].pattern) != len(r[j].pattern) {
+			return len(r[i].pattern) > len(r[j].pattern)
+		}
+		return r[i].pattern < r[j].pattern
+	})
+	return r, nil
+}
+
+// appQuota returns the storage quota of the application, if any.
+func (s *Storage) appQuota(appName string) (bytesize.ByteSize, bool) {
+	name := strings.ToLower(appName)
+	for _, q := range s.appQuotas {
+		if ok, _ := path.Match(q.pattern, name); ok {
+			return q.size, true
+		}
+	}
+	return 0, false
+}
+
+// quotaTask removes the oldest data of applications exceeding their quotas,
+// until the size is reduced to the low watermark, like diskUsageTask does.
+// The space is reclaimed by the database eventually.
+func (s *Storage) quotaTask() {
+	for _, app := range s.GetAppNames() {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+		quota, ok := s.appQuota(app)
+		if !ok {
+			continue
+		}
+		err := s.enforceAppQuota(app, quota)
+		switch {
+		case err == nil:
+		case errors.Is(err, errClosed):
+			return
+		default:
+			s.logger.WithError(err).WithField("app", app).Error("failed to enforce storage quota")
+		}
+	}
+}
+
+func (s *Storage) enforceAppQuota(app string, quota bytesize.ByteSize) error {
+	d, ok := s.lookupAppDimension(app)
+	if !ok {
+		return nil
+	}
+	var (
+		segments []segmentSize
+		total    int64
+	)
+	for _, k := range d.Keys {
+		key, err := segment.ParseKey(string(k))
+		if err != nil {
+			continue
+		}
+		n := s.segmentTreesSize(key)
+		segments = append(segments, segmentSize{key: key, size: n})
+		total += n
+	}
+	if total <= int64(quota) {
+		return nil
+	}
+	target := int64(float64(quota) * s.config.diskUsageLowWatermark)
+	s.logger.WithField("app", app).
+		WithField("size", bytesize.ByteSize(total)).
+		WithField("quota", quota).
+		Warn("storage quota exceeded, removing the oldest data")
+	s.metrics.quotaEvictions.Inc()
+	return s.reclaimSegmentsSpace(segments, total-target)
+}
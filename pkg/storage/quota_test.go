@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+	"github.com/pyroscope-io/pyroscope/pkg/util/bytesize"
+)
+
+var _ = Describe("storage quota", func() {
+	It("parses quotas", func() {
+		q, err := parseAppQuotas(map[string]string{
+			"*.alloc_space":    "10GB",
+			"app.alloc_space":  "1GB",
+			"*.cpu":            "100MB",
+			"other.inuse_*":    "5MB",
+			"unused.*.pattern": "1KB",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		s := &Storage{appQuotas: q}
+		expect := func(app string, size bytesize.ByteSize, found bool) {
+			v, ok := s.appQuota(app)
+			Expect(ok).To(Equal(found), app)
+			Expect(v).To(Equal(size), app)
+		}
+		expect("app.alloc_space", bytesize.GB, true)
+		expect("foo.alloc_space", 10*bytesize.GB, true)
+		expect("Foo.CPU", 100*bytesize.MB, true)
+		expect("other.inuse_space", 5*bytesize.MB, true)
+		expect("other.alloc_objects", 0, false)
+
+		for _, v := range []map[string]string{{"[": "1GB"}, {"*": "foo"}, {"*": "0"}} {
+			_, err = parseAppQuotas(v)
+			Expect(err).To(HaveOccurred(), v)
+		}
+	})
+
+	testing.WithConfig(func(cfg **config.Config) {
+		var s *Storage
+
+		JustBeforeEach(func() {
+			var err error
+			(*cfg).Server.StorageQuota = map[string]string{"*.alloc_space": "1KB"}
+			(*cfg).Server.StorageDiskUsageLowWatermark = 0.5
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		JustAfterEach(func() {
+			Expect(s.Close()).To(Succeed())
+		})
+
+		It("removes the oldest data of the profile type exceeding the quota", func() {
+			base := time.Unix(1600000000, 0)
+			put := func(name string) [][]byte {
+				k, err := segment.ParseKey(name)
+				Expect(err).ToNot(HaveOccurred())
+				var treeKeys [][]byte
+				for i := 0; i < 50; i++ {
+					t := tree.New()
+					t.Insert([]byte("a;b"), uint64(i+1))
+					st := base.Add(time.Duration(i) * 10 * time.Second)
+					Expect(s.Put(&PutInput{
+						StartTime: st,
+						EndTime:   st.Add(10 * time.Second),
+						Key:       k,
+						Val:       t,
+					})).To(Succeed())
+					treeKeys = append(treeKeys, treePrefix.key(k.TreeKey(0, st)))
+				}
+				return treeKeys
+			}
+			cpu := put("app.cpu")
+			alloc := put("app.alloc_space")
+			s.writeBackTask()
+
+			k, _ := segment.ParseKey("app.alloc_space")
+			Expect(s.segmentTreesSize(k)).To(BeNumerically(">", bytesize.KB))
+			s.quotaTask()
+			Expect(testutil.ToFloat64(s.metrics.quotaEvictions)).To(Equal(float64(1)))
+
+			_, err := s.trees.Backend.Get(alloc[0])
+			Expect(err).To(MatchError(backend.ErrNotFound))
+			_, err = s.trees.Backend.Get(alloc[len(alloc)-1])
+			Expect(err).ToNot(HaveOccurred())
+			for _, treeKey := range cpu {
+				_, err = s.trees.Backend.Get(treeKey)
+				Expect(err).ToNot(HaveOccurred())
+			}
+		})
+	})
+})
@@ -2,22 +2,107 @@ package storage
 
 import (
 	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
 	"time"
 
-	"github.com/dgraph-io/badger/v2"
-
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/dimension"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/util/duration"
 )
 
+// appRetention is the retention period of applications
+// with names matching the pattern.
+type appRetention struct {
+	pattern string
+	period  time.Duration
+}
+
+// parseAppRetention parses pattern=period pairs. Patterns are matched
+// case-insensitively, as the configuration keys are lower-cased anyway.
+func parseAppRetention(m map[string]string) ([]appRetention, error) {
+	r := make([]appRetention, 0, len(m))
+	for pattern, v := range m {
+		pattern = strings.ToLower(pattern)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid app retention pattern %q: %w", pattern, err)
+		}
+		period, err := duration.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retention period for %q: %w", pattern, err)
+		}
+		r = append(r, appRetention{pattern: pattern, period: period})
+	}
+	// The longest pattern takes precedence.
+	sort.Slice(r, func(i, j int) bool {
+		if len(r[i].pattern) != len(r[j].pattern) {
+			return len(r[i].pattern) > len(r[j].pattern)
+		}
+		return r[i].pattern < r[j].pattern
+	})
+	return r, nil
+}
+
+// appRetentionPolicy returns the retention policy for the application:
+// the period configured for the application name pattern overrides the
+// global retention period. Retention levels apply to all applications.
+func (s *Storage) appRetentionPolicy(appName string) *segment.RetentionPolicy {
+	s.retentionMutex.RLock()
+	defer s.retentionMutex.RUnlock()
+	rp := s.retentionPolicy()
+	name := strings.ToLower(appName)
+	for _, r := range s.appRetention {
+		if ok, _ := path.Match(r.pattern, name); ok {
+			return rp.SetAbsolutePeriod(r.period)
+		}
+	}
+	return rp
+}
+
+// ApplyRetention replaces the retention settings of the storage with the
+// ones of the configuration, e.g. on config reload. The data is removed
+// according to the new settings by the next retention task.
+func (s *Storage) ApplyRetention(c *Config) error {
+	r, err := parseAppRetention(c.appRetention)
+	if err != nil {
+		return err
+	}
+	s.retentionMutex.Lock()
+	s.config.retention = c.retention
+	s.config.retentionLevels = c.retentionLevels
+	s.config.appRetention = c.appRetention
+	s.config.downsamplingAge = c.downsamplingAge
+	s.config.downsamplingResolution = c.downsamplingResolution
+	s.appRetention = r
+	s.retentionMutex.Unlock()
+	if s.config.auditLog && s.standby == nil {
+		return s.auditRetention()
+	}
+	return nil
+}
+
 func (s *Storage) EnforceRetentionPolicy(rp *segment.RetentionPolicy) error {
 	if rp.LowerTimeBoundary().IsZero() {
 		return nil
 	}
+	return s.enforceRetention(func(*segment.Key) *segment.RetentionPolicy {
+		return rp
+	})
+}
 
+// enforceRetention removes segment data according to the policy
+// returned for the segment.
+func (s *Storage) enforceRetention(policy func(*segment.Key) *segment.RetentionPolicy) error {
 	// It may make sense running it concurrently with some throttling.
 	s.logger.Debug("enforcing retention policy")
 	err := s.iterateOverAllSegments(func(k *segment.Key) error {
+		rp := policy(k)
+		if rp.LowerTimeBoundary().IsZero() {
+			return nil
+		}
 		return s.deleteSegmentData(k, rp)
 	})
 
@@ -31,6 +116,7 @@ func (s *Storage) EnforceRetentionPolicy(rp *segment.RetentionPolicy) error {
 
 func (s *Storage) deleteSegmentData(k *segment.Key, rp *segment.RetentionPolicy) error {
 	sk := k.SegmentKey()
+	s.invalidateQueryCacheKey(k)
 	cached, ok := s.segments.Lookup(sk)
 	if !ok {
 		return nil
@@ -53,11 +139,6 @@ func (s *Storage) deleteSegmentData(k *segment.Key, rp *segment.RetentionPolicy)
 	//  removed for a very long period. For example, when retention-period
 	//  is enabled for the first time on a server with historical data.
 
-	type segmentNode struct {
-		depth int
-		time  int64
-	}
-
 	nodes := make([]segmentNode, 0)
 	seg := cached.(*segment.Segment)
 	deleted, err := seg.WalkNodesToDelete(rp, func(d int, t time.Time) error {
@@ -70,8 +151,25 @@ func (s *Storage) deleteSegmentData(k *segment.Key, rp *segment.RetentionPolicy)
 	if deleted {
 		return s.deleteSegmentAndRelatedData(k)
 	}
+	if err = s.deleteTrees(sk, nodes); err != nil {
+		return err
+	}
+
+	_, err = seg.DeleteNodesBefore(rp)
+	return err
+}
 
-	var removed int64
+type segmentNode struct {
+	depth int
+	time  int64
+}
+
+// deleteTrees removes trees of the segment nodes in batches.
+func (s *Storage) deleteTrees(sk string, nodes []segmentNode) error {
+	var (
+		removed int64
+		err     error
+	)
 	batchSize := s.trees.MaxBatchCount()
 	batch := s.trees.NewWriteBatch()
 	defer func() {
@@ -81,11 +179,7 @@ func (s *Storage) deleteSegmentData(k *segment.Key, rp *segment.RetentionPolicy)
 	for _, n := range nodes {
 		treeKey := segment.TreeKey(sk, n.depth, n.time)
 		s.trees.Discard(treeKey)
-		switch err = batch.Delete(treePrefix.key(treeKey)); {
-		case err == nil:
-		case errors.Is(err, badger.ErrKeyNotFound):
-			continue
-		default:
+		if err = batch.Delete(treePrefix.key(treeKey)); err != nil {
 			return err
 		}
 		// It is not possible to make size estimation without reading
@@ -99,15 +193,11 @@ func (s *Storage) deleteSegmentData(k *segment.Key, rp *segment.RetentionPolicy)
 
 	// Flush remaining items, if any: it's important to make sure
 	// all trees were removed before deleting segment nodes - see
-	// note on a potential inconsistency above.
+	// note on a potential inconsistency in deleteSegmentData.
 	if removed%batchSize != 0 {
-		if err = batch.Flush(); err != nil {
-			return err
-		}
+		return batch.Flush()
 	}
-
-	_, err = seg.DeleteNodesBefore(rp)
-	return err
+	return nil
 }
 
 // reclaimSegmentSpace is aimed to reclaim specified size by removing
@@ -118,6 +208,7 @@ func (s *Storage) deleteSegmentData(k *segment.Key, rp *segment.RetentionPolicy)
 // eventually, there is no way to juxtapose the actual occupied disk size
 // and the number of items to remove based on their estimated size.
 func (s *Storage) reclaimSegmentSpace(k *segment.Key, size int64) error {
+	s.invalidateQueryCacheKey(k)
 	batchSize := s.trees.MaxBatchCount()
 	batch := s.trees.NewWriteBatch()
 	defer func() {
@@ -132,11 +223,11 @@ func (s *Storage) reclaimSegmentSpace(k *segment.Key, size int64) error {
 
 	// Keep track of the most recent removed tree time per every segment level.
 	rp := &segment.RetentionPolicy{Levels: make(map[int]time.Time)}
-	err = s.trees.View(func(txn *badger.Txn) error {
+	err = func() error {
 		// Lower-level trees come first because of the lexicographical order:
 		// from the very first tree to the most recent one, from the lowest
 		// level (with highest resolution) to the highest.
-		it := txn.NewIterator(badger.IteratorOptions{
+		it := s.trees.NewIterator(backend.IteratorOptions{
 			// We count all version so that our estimation is more precise
 			// but slightly higher than the actual size in practice,
 			// meaning that we delete less data (and reclaim less space);
@@ -166,15 +257,13 @@ func (s *Storage) reclaimSegmentSpace(k *segment.Key, size int64) error {
 
 			// A key copy must be taken. The slice is reused
 			// by iterator but is also used in the batch.
-			switch err = batch.Delete(item.KeyCopy(nil)); {
-			case err == nil:
-			case errors.Is(err, badger.ErrKeyNotFound):
-				continue
-			default:
+			if err = batch.Delete(item.KeyCopy(nil)); err != nil {
 				return err
 			}
 
 			reclaimed += item.EstimatedSize()
+			s.metrics.evictedTrees.Inc()
+			s.metrics.evictedBytes.Add(float64(item.EstimatedSize()))
 			if removed++; removed%batchSize == 0 {
 				if batch, err = s.flushTreeBatch(batch); err != nil {
 					return err
@@ -182,14 +271,14 @@ func (s *Storage) reclaimSegmentSpace(k *segment.Key, size int64) error {
 			}
 		}
 		return nil
-	})
+	}()
 	if err != nil {
 		return err
 	}
 
 	// Flush remaining items, if any: it's important to make sure
 	// all trees were removed before deleting segment nodes - see
-	// note on a potential inconsistency above.
+	// note on a potential inconsistency in deleteSegmentData.
 	if removed%batchSize != 0 {
 		if err = batch.Flush(); err != nil {
 			return err
@@ -211,7 +300,7 @@ func (s *Storage) reclaimSegmentSpace(k *segment.Key, size int64) error {
 // the batch unchanged in case of an error so that it can be safely cancelled.
 //
 // If the storage was requested to close, errClosed will be returned.
-func (s *Storage) flushTreeBatch(batch *badger.WriteBatch) (*badger.WriteBatch, error) {
+func (s *Storage) flushTreeBatch(batch backend.WriteBatch) (backend.WriteBatch, error) {
 	if err := batch.Flush(); err != nil {
 		return batch, err
 	}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("per-application retention", func() {
+	var s *Storage
+
+	testing.WithConfig(func(cfg **config.Config) {
+		JustBeforeEach(func() {
+			var err error
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		JustAfterEach(func() {
+			Expect(s.Close()).To(Succeed())
+		})
+
+		put := func(app string, st time.Time) error {
+			k, err := segment.ParseKey(app)
+			Expect(err).ToNot(HaveOccurred())
+			t := tree.New()
+			t.Insert([]byte("a;b"), uint64(1))
+			return s.Put(&PutInput{
+				StartTime: st,
+				EndTime:   st.Add(10 * time.Second),
+				Key:       k,
+				Val:       t,
+			})
+		}
+
+		get := func(app string, st time.Time) *GetOutput {
+			k, err := segment.ParseKey(app)
+			Expect(err).ToNot(HaveOccurred())
+			o, err := s.Get(&GetInput{StartTime: st, EndTime: st.Add(10 * time.Second), Key: k})
+			Expect(err).ToNot(HaveOccurred())
+			return o
+		}
+
+		It("removes data of matching applications only", func() {
+			old := time.Now().Add(-3 * time.Hour).Truncate(10 * time.Second)
+			Expect(put("app.staging.cpu{foo=bar}", old)).To(Succeed())
+			Expect(put("app.prod.cpu", old)).To(Succeed())
+
+			var err error
+			s.appRetention, err = parseAppRetention(map[string]string{"*.STAGING.*": "2h"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(put("app.staging.cpu", old)).To(MatchError(errRetention))
+
+			s.retentionTask()
+			Expect(get("app.staging.cpu{foo=bar}", old)).To(BeNil())
+			Expect(get("app.prod.cpu", old)).ToNot(BeNil())
+		})
+
+		It("applies retention settings on reload", func() {
+			old := time.Now().Add(-3 * time.Hour).Truncate(10 * time.Second)
+			Expect(put("app.cpu", old)).To(Succeed())
+
+			c := (*cfg).Server
+			c.Retention = time.Hour
+			Expect(s.ApplyRetention(NewConfig(&c))).To(Succeed())
+			Expect(put("app.cpu", old)).To(MatchError(errRetention))
+			s.retentionTask()
+			Expect(get("app.cpu", old)).To(BeNil())
+
+			c.AppRetention = map[string]string{"[": "1d"}
+			Expect(s.ApplyRetention(NewConfig(&c))).ToNot(Succeed())
+			Expect(s.maxRetentionPeriod()).To(Equal(time.Hour))
+		})
+	})
+
+	It("chooses the longest matching pattern", func() {
+		r, err := parseAppRetention(map[string]string{
+			"*":           "1d",
+			"payments.*":  "90d",
+			"*.staging.*": "3d",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r).To(Equal([]appRetention{
+			{pattern: "*.staging.*", period: 3 * 24 * time.Hour},
+			{pattern: "payments.*", period: 90 * 24 * time.Hour},
+			{pattern: "*", period: 24 * time.Hour},
+		}))
+
+		_, err = parseAppRetention(map[string]string{"[": "1d"})
+		Expect(err).To(HaveOccurred())
+		_, err = parseAppRetention(map[string]string{"*": "foo"})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("downsampling", func() {
+	var s *Storage
+
+	testing.WithConfig(func(cfg **config.Config) {
+		JustBeforeEach(func() {
+			var err error
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		JustAfterEach(func() {
+			Expect(s.Close()).To(Succeed())
+		})
+
+		It("keeps old data at coarse resolution only", func() {
+			k, err := segment.ParseKey("app.cpu")
+			Expect(err).ToNot(HaveOccurred())
+			st := time.Now().Add(-3 * time.Hour).Truncate(100 * time.Second)
+			for i := 0; i < 10; i++ {
+				t := tree.New()
+				t.Insert([]byte("a;b"), uint64(1))
+				Expect(s.Put(&PutInput{
+					StartTime: st.Add(time.Duration(i) * 10 * time.Second),
+					EndTime:   st.Add(time.Duration(i+1) * 10 * time.Second),
+					Key:       k,
+					Val:       t,
+				})).To(Succeed())
+			}
+
+			s.config.downsamplingAge = time.Hour
+			s.config.downsamplingResolution = 100 * time.Second
+			Expect(s.retentionPolicy().Levels).To(HaveLen(1))
+			s.retentionTask()
+
+			_, ok := s.trees.Lookup(k.TreeKey(0, st))
+			Expect(ok).To(BeFalse())
+			o, err := s.Get(&GetInput{StartTime: st, EndTime: st.Add(100 * time.Second), Key: k})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(o).ToNot(BeNil())
+			Expect(o.Tree.Samples()).To(Equal(uint64(10)))
+		})
+	})
+
+	It("rounds the resolution up to the segment level", func() {
+		Expect(segment.DepthForDuration(10 * time.Second)).To(Equal(0))
+		Expect(segment.DepthForDuration(10 * time.Minute)).To(Equal(2))
+		Expect(segment.DepthForDuration(time.Hour)).To(Equal(3))
+	})
+})
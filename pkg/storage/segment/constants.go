@@ -22,3 +22,22 @@ func init() {
 		d = newD
 	}
 }
+
+// DurationForDepth returns the time span of a segment node at the depth.
+func DurationForDepth(depth int) time.Duration {
+	if depth < 0 || depth >= len(durations) {
+		return durations[len(durations)-1]
+	}
+	return durations[depth]
+}
+
+// DepthForDuration returns the depth of segment nodes with the shortest
+// time span not less than d.
+func DepthForDuration(d time.Duration) int {
+	for i, x := range durations {
+		if x >= d {
+			return i
+		}
+	}
+	return len(durations) - 1
+}
@@ -233,6 +233,74 @@ func (sn *streeNode) walkNodesToDelete(t *RetentionPolicy, cb func(depth int, t
 	return isBefore, nil
 }
 
+// deleteRange returns true if the node should be deleted. Nodes that
+// overlap the time range partially are replaced with their children:
+// cb is called for every node which tree is to be removed.
+// The node is only modified if apply is true.
+func (sn *streeNode) deleteRange(st, et time.Time, apply bool, cb func(depth int, t time.Time) error) (bool, error) {
+	switch sn.relationship(st, et) {
+	case outside:
+		return false, nil
+	case match, contain:
+		return true, sn.walk(cb)
+	}
+	// Inside or overlap. A trie of a node without children can't be
+	// split, therefore the whole node is removed.
+	var samples, writes uint64
+	var remaining int
+	for i, v := range sn.children {
+		if v == nil {
+			continue
+		}
+		ok, err := v.deleteRange(st, et, apply, cb)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			if apply {
+				sn.children[i] = nil
+			}
+			continue
+		}
+		remaining++
+		samples += v.samples
+		writes += v.writes
+	}
+	if remaining == 0 {
+		if sn.present {
+			return true, cb(sn.depth, sn.time)
+		}
+		return true, nil
+	}
+	if sn.present {
+		if err := cb(sn.depth, sn.time); err != nil {
+			return false, err
+		}
+	}
+	if apply {
+		sn.present = false
+		sn.samples = samples
+		sn.writes = writes
+	}
+	return false, nil
+}
+
+func (sn *streeNode) walk(cb func(depth int, t time.Time) error) error {
+	if sn.present {
+		if err := cb(sn.depth, sn.time); err != nil {
+			return err
+		}
+	}
+	for _, v := range sn.children {
+		if v != nil {
+			if err := v.walk(cb); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 type Segment struct {
 	m    sync.RWMutex
 	root *streeNode
@@ -417,6 +485,45 @@ func (s *Segment) WalkNodesToDelete(t *RetentionPolicy, cb func(depth int, t tim
 	return s.root.walkNodesToDelete(t.normalize(), cb)
 }
 
+// WalkNodes calls cb for every node that has data (a tree).
+func (s *Segment) WalkNodes(cb func(depth int, t time.Time) error) error {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	if s.root == nil {
+		return nil
+	}
+	return s.root.walk(cb)
+}
+
+// WalkNodesInRange calls cb for every node which tree is to be removed
+// by DeleteNodesInRange. It returns true if the segment would be empty.
+func (s *Segment) WalkNodesInRange(st, et time.Time, cb func(depth int, t time.Time) error) (bool, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	if s.root == nil {
+		return true, nil
+	}
+	st, et = normalize(st, et)
+	return s.root.deleteRange(st, et, false, cb)
+}
+
+// DeleteNodesInRange removes nodes within the given time range. Nodes
+// overlapping the range partially are replaced with their children,
+// which are queried instead. It returns true if the segment is empty.
+func (s *Segment) DeleteNodesInRange(st, et time.Time) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.root == nil {
+		return true
+	}
+	st, et = normalize(st, et)
+	ok, _ := s.root.deleteRange(st, et, true, func(int, time.Time) error { return nil })
+	if ok {
+		s.root = nil
+	}
+	return ok
+}
+
 // TODO: this should be refactored
 func (s *Segment) SetMetadata(spyName string, sampleRate uint32, units, aggregationType string) {
 	s.spyName = spyName
@@ -218,6 +218,53 @@ var _ = Describe("stree", func() {
 		})
 	})
 
+	Context("DeleteNodesInRange", func() {
+		It("replaces partially overlapping nodes with children", func() {
+			s := New()
+			s.Put(testing.SimpleUTime(10), testing.SimpleUTime(19), 1, func(de int, t time.Time, r *big.Rat, a []Addon) {})
+			s.Put(testing.SimpleUTime(20), testing.SimpleUTime(29), 1, func(de int, t time.Time, r *big.Rat, a []Addon) {})
+			s.Put(testing.SimpleUTime(30), testing.SimpleUTime(39), 1, func(de int, t time.Time, r *big.Rat, a []Addon) {})
+
+			keys := []string{}
+			r, _ := s.WalkNodesInRange(testing.SimpleUTime(20), testing.SimpleUTime(30), func(depth int, t time.Time) error {
+				keys = append(keys, strconv.Itoa(depth)+":"+strconv.Itoa(int(t.Unix())))
+				return nil
+			})
+			Expect(r).To(BeFalse())
+			Expect(keys).To(ConsistOf([]string{
+				"1:0",
+				"0:20",
+			}))
+
+			Expect(s.DeleteNodesInRange(testing.SimpleUTime(20), testing.SimpleUTime(30))).To(BeFalse())
+			Expect(doGet(s, testing.SimpleUTime(0), testing.SimpleUTime(100))).To(Equal([]time.Time{
+				testing.SimpleUTime(10),
+				testing.SimpleUTime(30),
+			}))
+			expectChildrenSamplesAddUpToParentSamples(s.root)
+		})
+
+		It("deletes all the nodes within the range", func() {
+			s := New()
+			s.Put(testing.SimpleUTime(10), testing.SimpleUTime(19), 1, func(de int, t time.Time, r *big.Rat, a []Addon) {})
+			s.Put(testing.SimpleUTime(20), testing.SimpleUTime(29), 1, func(de int, t time.Time, r *big.Rat, a []Addon) {})
+
+			keys := []string{}
+			r, _ := s.WalkNodesInRange(testing.SimpleUTime(0), testing.SimpleUTime(100), func(depth int, t time.Time) error {
+				keys = append(keys, strconv.Itoa(depth)+":"+strconv.Itoa(int(t.Unix())))
+				return nil
+			})
+			Expect(r).To(BeTrue())
+			Expect(keys).To(ConsistOf([]string{
+				"1:0",
+				"0:10",
+				"0:20",
+			}))
+			Expect(s.DeleteNodesInRange(testing.SimpleUTime(0), testing.SimpleUTime(100))).To(BeTrue())
+			Expect(s.root).To(BeNil())
+		})
+	})
+
 	Context("Put", func() {
 		Context("When inserts are far apart", func() {
 			Context("When second insert is far in the future", func() {
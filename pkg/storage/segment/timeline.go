@@ -63,6 +63,17 @@ func (tl *Timeline) PopulateTimeline(s *Segment) {
 	}
 }
 
+// Divide divides the number of samples of the timeline by n.
+func (tl *Timeline) Divide(n uint64) {
+	for i, v := range tl.Samples {
+		// Values are offset by one, so that 0 indicates
+		// the absence of data (see populateTimeline).
+		if v > 1 {
+			tl.Samples[i] = (v-1)/n + 1
+		}
+	}
+}
+
 func (sn streeNode) populateTimeline(tl *Timeline, s *Segment) {
 	if sn.relationship(tl.st, tl.et) == outside {
 		return
@@ -0,0 +1,479 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+)
+
+// Trees may be stored in time shards: a separate database per time range.
+// A tree belongs to the shard its time range ends within, therefore once
+// the shard time range is out of retention, so are all the trees of the
+// shard, and the shard is removed as a whole instead of key by key.
+// Segments still reference the removed trees until retention is enforced
+// for them; it's cheap, as deletion of trees of a missing shard is no-op.
+//
+// Trees which keys have no time (e.g. trees of individual profiles) are
+// stored in the base trees database, which also holds the trees of the
+// storage created without sharding: because of that, sharding can't be
+// enabled for an existing storage.
+
+const (
+	treeShardsDir      = "trees.shards"
+	treeShardsDuration = "trees-shard-duration"
+	treeShardFormat    = "20060102T150405Z"
+)
+
+var (
+	errTreeShardingEnabled  = errors.New("trees are sharded: storage-tree-shard-duration can't be changed")
+	errTreeShardingExisting = errors.New("tree sharding can't be enabled for a storage with existing data")
+)
+
+type treeShard struct {
+	backend.Backend
+	start time.Time
+	path  string
+	// refs tracks operations in progress: the shard
+	// is closed and removed once all of them are done.
+	refs sync.WaitGroup
+}
+
+type shardedBackend struct {
+	// The base database stores trees without time.
+	backend.Backend
+	duration time.Duration
+	open     func(name string) (backend.Backend, string, error)
+
+	m      sync.RWMutex
+	shards map[int64]*treeShard
+}
+
+// openTreeShards wraps the base trees database, opening existing shards.
+func (s *Storage) openTreeShards(base backend.Backend, opts backend.Options) (*shardedBackend, error) {
+	b := &shardedBackend{
+		Backend:  base,
+		duration: s.config.treeShardDuration,
+		shards:   make(map[int64]*treeShard),
+	}
+	dir := filepath.Join(s.config.badgerBasePath, treeShardsDir)
+	b.open = func(name string) (backend.Backend, string, error) {
+		o := opts
+		o.Name = "trees/" + name
+		if !opts.InMemory {
+			o.Path = filepath.Join(dir, name)
+			if err := os.MkdirAll(o.Path, 0o755); err != nil {
+				return nil, "", err
+			}
+		}
+		x, err := backend.Open(s.config.backend, o)
+		return x, o.Path, err
+	}
+	if opts.InMemory {
+		return b, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, e := range entries {
+		t, err := time.Parse(treeShardFormat, e.Name())
+		if err != nil {
+			continue
+		}
+		x, err := b.shard(t, true)
+		if err != nil {
+			for _, x = range b.shards {
+				_ = x.Close()
+			}
+			return nil, fmt.Errorf("opening tree shard %s: %w", e.Name(), err)
+		}
+		x.refs.Done()
+	}
+	return b, nil
+}
+
+// checkTreeSharding makes sure the shard duration has not been changed:
+// otherwise trees can't be found.
+func (s *Storage) checkTreeSharding(base backend.Backend) error {
+	v, err := s.main.Get([]byte(treeShardsDuration))
+	switch {
+	case err == nil:
+		if s.config.treeShardDuration.String() != string(v) {
+			return fmt.Errorf("%w: expected %s", errTreeShardingEnabled, v)
+		}
+		return nil
+	case !errors.Is(err, backend.ErrNotFound):
+		return err
+	case s.config.treeShardDuration <= 0:
+		return nil
+	}
+	it := base.NewIterator(backend.IteratorOptions{Prefix: treePrefix.bytes()})
+	it.Rewind()
+	empty := !it.Valid()
+	it.Close()
+	if !empty {
+		return errTreeShardingExisting
+	}
+	return s.main.Set([]byte(treeShardsDuration), []byte(s.config.treeShardDuration.String()))
+}
+
+// dropTreeShards removes the shards that are out of retention
+// of all the applications.
+func (s *Storage) dropTreeShards() {
+	if s.treeShards == nil {
+		return
+	}
+	period := s.maxRetentionPeriod()
+	if period <= 0 {
+		return
+	}
+	n, err := s.treeShards.dropBefore(time.Now().Add(-period))
+	if err != nil {
+		s.logger.WithError(err).Error("failed to remove tree shards")
+	}
+	if n > 0 {
+		s.metrics.treeShardsRemoved.Add(float64(n))
+		s.logger.WithField("shards", n).Info("removed tree shards out of retention")
+	}
+}
+
+// maxRetentionPeriod returns the longest retention period
+// of all the applications, or 0 if some data is kept forever.
+func (s *Storage) maxRetentionPeriod() time.Duration {
+	s.retentionMutex.RLock()
+	defer s.retentionMutex.RUnlock()
+	p := s.config.retention
+	if p <= 0 {
+		return 0
+	}
+	for _, r := range s.appRetention {
+		if r.period <= 0 {
+			return 0
+		}
+		if r.period > p {
+			p = r.period
+		}
+	}
+	return p
+}
+
+// shardStart returns the start of the shard the tree belongs to,
+// or false if the tree is stored in the base database.
+func (b *shardedBackend) shardStart(key []byte) (time.Time, bool) {
+	k, ok := treePrefix.trim(key)
+	if !ok {
+		return time.Time{}, false
+	}
+	t, depth, err := segment.ParseTreeKey(string(k))
+	if err != nil {
+		return time.Time{}, false
+	}
+	end := t.Add(segment.DurationForDepth(depth))
+	return end.Add(-time.Nanosecond).Truncate(b.duration).UTC(), true
+}
+
+// shard returns the shard starting at t, a reference is to be released
+// with refs.Done. If create is false and the shard does not exist, nil
+// is returned.
+func (b *shardedBackend) shard(t time.Time, create bool) (*treeShard, error) {
+	k := t.Unix()
+	b.m.RLock()
+	x, ok := b.shards[k]
+	if ok {
+		x.refs.Add(1)
+	}
+	b.m.RUnlock()
+	if ok || !create {
+		return x, nil
+	}
+	b.m.Lock()
+	defer b.m.Unlock()
+	if x, ok = b.shards[k]; !ok {
+		d, path, err := b.open(t.UTC().Format(treeShardFormat))
+		if err != nil {
+			return nil, err
+		}
+		x = &treeShard{Backend: d, start: t, path: path}
+		b.shards[k] = x
+	}
+	x.refs.Add(1)
+	return x, nil
+}
+
+// acquire returns the backend the key belongs to, and the function
+// to release it with. Nil backend is returned if the shard does not
+// exist and create is false.
+func (b *shardedBackend) acquire(key []byte, create bool) (backend.Backend, func(), error) {
+	t, ok := b.shardStart(key)
+	if !ok {
+		return b.Backend, func() {}, nil
+	}
+	x, err := b.shard(t, create)
+	if err != nil || x == nil {
+		return nil, nil, err
+	}
+	return x.Backend, x.refs.Done, nil
+}
+
+// acquireAll returns all the shards sorted by time.
+func (b *shardedBackend) acquireAll() []*treeShard {
+	b.m.RLock()
+	shards := make([]*treeShard, 0, len(b.shards))
+	for _, x := range b.shards {
+		x.refs.Add(1)
+		shards = append(shards, x)
+	}
+	b.m.RUnlock()
+	sort.Slice(shards, func(i, j int) bool { return shards[i].start.Before(shards[j].start) })
+	return shards
+}
+
+func (b *shardedBackend) forEach(fn func(backend.Backend) error) error {
+	shards := b.acquireAll()
+	defer func() {
+		for _, x := range shards {
+			x.refs.Done()
+		}
+	}()
+	firstErr := fn(b.Backend)
+	for _, x := range shards {
+		if err := fn(x.Backend); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (b *shardedBackend) Get(key []byte) ([]byte, error) {
+	d, release, err := b.acquire(key, false)
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		return nil, backend.ErrNotFound
+	}
+	defer release()
+	return d.Get(key)
+}
+
+func (b *shardedBackend) Set(key, value []byte) error {
+	d, release, err := b.acquire(key, true)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return d.Set(key, value)
+}
+
+func (b *shardedBackend) Delete(key []byte) error {
+	d, release, err := b.acquire(key, false)
+	if err != nil || d == nil {
+		return err
+	}
+	defer release()
+	return d.Delete(key)
+}
+
+func (b *shardedBackend) DropPrefix(p []byte) error {
+	return b.forEach(func(d backend.Backend) error { return d.DropPrefix(p) })
+}
+
+func (b *shardedBackend) NewIterator(o backend.IteratorOptions) backend.Iterator {
+	shards := b.acquireAll()
+	m := &mergeIterator{its: []backend.Iterator{b.Backend.NewIterator(o)}}
+	for _, x := range shards {
+		m.its = append(m.its, x.Backend.NewIterator(o))
+	}
+	m.release = func() {
+		for _, x := range shards {
+			x.refs.Done()
+		}
+	}
+	return m
+}
+
+func (b *shardedBackend) NewWriteBatch() backend.WriteBatch {
+	return &shardedBatch{b: b, batches: make(map[backend.Backend]backend.WriteBatch)}
+}
+
+func (b *shardedBackend) Size() (index, values int64) {
+	_ = b.forEach(func(d backend.Backend) error {
+		i, v := d.Size()
+		index += i
+		values += v
+		return nil
+	})
+	return index, values
+}
+
+// GC runs garbage collection of every shard once.
+func (b *shardedBackend) GC(discardRatio float64) (bool, error) {
+	var reclaimed bool
+	err := b.forEach(func(d backend.Backend) error {
+		ok, err := d.GC(discardRatio)
+		reclaimed = reclaimed || ok
+		return err
+	})
+	return reclaimed, err
+}
+
+func (b *shardedBackend) Flatten() error {
+	return b.forEach(func(d backend.Backend) error { return d.Flatten() })
+}
+
+// Dump is not supported: versions are not comparable across shards.
+func (*shardedBackend) Dump(io.Writer, uint64) (uint64, error) {
+	return 0, backend.ErrNotSupported
+}
+
+func (*shardedBackend) Load(io.Reader) error { return backend.ErrNotSupported }
+
+func (b *shardedBackend) Close() error {
+	return b.forEach(func(d backend.Backend) error { return d.Close() })
+}
+
+// dropBefore closes and removes shards which time range ends before t,
+// and returns the number of shards removed.
+func (b *shardedBackend) dropBefore(t time.Time) (int, error) {
+	var dropped []*treeShard
+	b.m.Lock()
+	for k, x := range b.shards {
+		if !x.start.Add(b.duration).After(t) {
+			delete(b.shards, k)
+			dropped = append(dropped, x)
+		}
+	}
+	b.m.Unlock()
+	for _, x := range dropped {
+		x.refs.Wait()
+		if err := x.Close(); err != nil {
+			return 0, err
+		}
+		if x.path != "" {
+			if err := os.RemoveAll(x.path); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(dropped), nil
+}
+
+// mergeIterator iterates over keys of all the shards in order. A key
+// can only belong to one shard, so no deduplication is needed.
+type mergeIterator struct {
+	its     []backend.Iterator
+	cur     backend.Iterator
+	release func()
+}
+
+func (m *mergeIterator) Rewind() {
+	for _, it := range m.its {
+		it.Rewind()
+	}
+	m.pick()
+}
+
+func (m *mergeIterator) Seek(key []byte) {
+	for _, it := range m.its {
+		it.Seek(key)
+	}
+	m.pick()
+}
+
+func (m *mergeIterator) Valid() bool { return m.cur != nil }
+
+func (m *mergeIterator) Next() {
+	m.cur.Next()
+	m.pick()
+}
+
+func (m *mergeIterator) pick() {
+	m.cur = nil
+	for _, it := range m.its {
+		if it.Valid() && (m.cur == nil || bytes.Compare(it.Item().Key(), m.cur.Item().Key()) < 0) {
+			m.cur = it
+		}
+	}
+}
+
+func (m *mergeIterator) Item() backend.Item { return m.cur.Item() }
+
+func (m *mergeIterator) Close() {
+	for _, it := range m.its {
+		it.Close()
+	}
+	m.release()
+}
+
+type shardedBatch struct {
+	b        *shardedBackend
+	batches  map[backend.Backend]backend.WriteBatch
+	releases []func()
+}
+
+func (w *shardedBatch) batch(key []byte, create bool) (backend.WriteBatch, error) {
+	d, release, err := w.b.acquire(key, create)
+	if err != nil || d == nil {
+		return nil, err
+	}
+	x, ok := w.batches[d]
+	if ok {
+		release()
+		return x, nil
+	}
+	x = d.NewWriteBatch()
+	w.batches[d] = x
+	w.releases = append(w.releases, release)
+	return x, nil
+}
+
+func (w *shardedBatch) Set(key, value []byte) error {
+	x, err := w.batch(key, true)
+	if err != nil {
+		return err
+	}
+	return x.Set(key, value)
+}
+
+func (w *shardedBatch) Delete(key []byte) error {
+	x, err := w.batch(key, false)
+	if err != nil || x == nil {
+		return err
+	}
+	return x.Delete(key)
+}
+
+func (w *shardedBatch) Flush() error {
+	var firstErr error
+	for _, x := range w.batches {
+		if err := x.Flush(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	w.done()
+	return firstErr
+}
+
+func (w *shardedBatch) Cancel() {
+	for _, x := range w.batches {
+		x.Cancel()
+	}
+	w.done()
+}
+
+func (w *shardedBatch) done() {
+	for _, release := range w.releases {
+		release()
+	}
+	w.batches = make(map[backend.Backend]backend.WriteBatch)
+	w.releases = nil
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("tree shards", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		open := func() (*Storage, error) {
+			return New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+		}
+
+		now := time.Now().Truncate(10 * time.Second)
+		oldTime := now.Add(-40 * 24 * time.Hour)
+		newTime := now.Add(-24 * time.Hour)
+		k, _ := segment.ParseKey("app.cpu{foo=bar}")
+
+		put := func(s *Storage, t time.Time) {
+			x := tree.New()
+			x.Insert([]byte("a;b"), 1)
+			Expect(s.Put(&PutInput{
+				StartTime: t,
+				EndTime:   t.Add(10 * time.Second),
+				Key:       k,
+				Val:       x,
+			})).To(Succeed())
+		}
+
+		get := func(s *Storage, t time.Time) *GetOutput {
+			s.trees.Cache.Purge()
+			o, err := s.Get(&GetInput{StartTime: t, EndTime: t.Add(10 * time.Second), Key: k})
+			Expect(err).ToNot(HaveOccurred())
+			return o
+		}
+
+		shards := func() int {
+			entries, err := os.ReadDir(filepath.Join((*cfg).Server.StoragePath, treeShardsDir))
+			Expect(err).ToNot(HaveOccurred())
+			return len(entries)
+		}
+
+		It("stores trees in time shards and removes shards out of retention", func() {
+			(*cfg).Server.StorageTreeShardDuration = 24 * time.Hour
+			s, err := open()
+			Expect(err).ToNot(HaveOccurred())
+			put(s, oldTime)
+			put(s, newTime)
+			Expect(s.Close()).To(Succeed())
+			n := shards()
+			Expect(n).To(BeNumerically(">=", 2))
+
+			(*cfg).Server.Retention = 30 * 24 * time.Hour
+			s, err = open()
+			Expect(err).ToNot(HaveOccurred())
+			defer func() { Expect(s.Close()).To(Succeed()) }()
+			Expect(get(s, oldTime)).ToNot(BeNil())
+			Expect(get(s, newTime).Tree.String()).To(Equal("a;b 1\n"))
+
+			s.retentionTask()
+			Expect(testutil.ToFloat64(s.metrics.treeShardsRemoved)).To(BeNumerically(">", 0))
+			Expect(shards()).To(BeNumerically("<", n))
+			Expect(get(s, oldTime)).To(BeNil())
+			Expect(get(s, newTime).Tree.String()).To(Equal("a;b 1\n"))
+
+			// Trees of all the shards are iterated.
+			r, err := s.Verify(false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Trees).To(BeNumerically(">", 0))
+			for _, x := range r.Issues {
+				Expect(x.Kind).ToNot(BeElementOf(IssueOrphanTree, IssueCorruptTree))
+			}
+		})
+
+		It("does not allow to change the shard duration", func() {
+			(*cfg).Server.StorageTreeShardDuration = 24 * time.Hour
+			s, err := open()
+			Expect(err).ToNot(HaveOccurred())
+			put(s, newTime)
+			Expect(s.Close()).To(Succeed())
+
+			(*cfg).Server.StorageTreeShardDuration = 48 * time.Hour
+			_, err = open()
+			Expect(err).To(MatchError(ContainSubstring(errTreeShardingEnabled.Error())))
+			(*cfg).Server.StorageTreeShardDuration = 0
+			_, err = open()
+			Expect(err).To(MatchError(ContainSubstring(errTreeShardingEnabled.Error())))
+		})
+
+		It("can't be enabled for existing storage", func() {
+			s, err := open()
+			Expect(err).ToNot(HaveOccurred())
+			put(s, newTime)
+			Expect(s.Close()).To(Succeed())
+
+			(*cfg).Server.StorageTreeShardDuration = 24 * time.Hour
+			_, err = open()
+			Expect(err).To(MatchError(errTreeShardingExisting))
+		})
+	})
+})
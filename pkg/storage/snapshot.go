@@ -0,0 +1,318 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/objstore"
+)
+
+// A snapshot is an incremental dump of all the databases, shipped to
+// object storage as "snapshots/<seq>.tar": every tar entry is named after
+// the database it is a dump of, and "deleted-apps.json" lists the apps
+// deleted since the previous snapshot (dropped keys are not dumped). The
+// sequence number of the last snapshot is stored in "snapshots/latest".
+//
+// A warm standby tails the snapshots and applies them in order. Databases
+// are dumped one after another once the caches are written back, therefore
+// the standby may be slightly inconsistent until the next snapshot is
+// applied. Trees offloaded to object storage are shipped as references.
+//
+// Snapshot shipping state is kept in a file next to the databases, so
+// that it is not shipped itself: once the standby is promoted, it starts
+// shipping its own snapshots with the next sequence number from scratch.
+
+const (
+	snapshotsLatest     = "snapshots/latest"
+	snapshotDeletedApps = "deleted-apps.json"
+	snapshotStateFile   = "snapshots.json"
+)
+
+var errStandby = errors.New("storage is a read-only standby")
+
+type snapshotState struct {
+	// Seq is the sequence number of the last shipped
+	// or applied snapshot.
+	Seq uint64 `json:"seq"`
+	// Versions the next dumps of the databases start from.
+	Versions map[string]uint64 `json:"versions,omitempty"`
+	// DeletedApps are the apps deleted since the last snapshot.
+	DeletedApps []string `json:"deletedApps,omitempty"`
+}
+
+func snapshotName(seq uint64) string { return fmt.Sprintf("snapshots/%020d.tar", seq) }
+
+func (s *Storage) snapshotStatePath() string {
+	return filepath.Join(s.config.badgerBasePath, snapshotStateFile)
+}
+
+func (s *Storage) loadSnapshotState() error {
+	b, err := os.ReadFile(s.snapshotStatePath())
+	switch {
+	case err == nil:
+		if err = json.Unmarshal(b, &s.snapshotState); err != nil {
+			return fmt.Errorf("snapshot state: %w", err)
+		}
+	case os.IsNotExist(err):
+	default:
+		return err
+	}
+	if s.snapshotState.Versions == nil {
+		s.snapshotState.Versions = make(map[string]uint64)
+	}
+	return nil
+}
+
+// Must be called with snapshotMutex held.
+func (s *Storage) saveSnapshotState() error {
+	b, err := json.Marshal(s.snapshotState)
+	if err != nil {
+		return err
+	}
+	p := s.snapshotStatePath()
+	if err = os.WriteFile(p+".tmp", b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(p+".tmp", p)
+}
+
+// openSnapshots prepares snapshot shipping: the sequence continues after
+// the last shipped snapshot, even if the local state is lost.
+func (s *Storage) openSnapshots() (err error) {
+	if s.snapshots, err = objstore.Open(s.config.snapshotShippingURL, s.config.objectStorageOptions); err != nil {
+		return err
+	}
+	if err = s.loadSnapshotState(); err != nil {
+		return err
+	}
+	b, err := s.snapshots.Get(snapshotsLatest)
+	switch {
+	case err == nil:
+	case errors.Is(err, objstore.ErrNotFound):
+		return nil
+	default:
+		return fmt.Errorf("latest snapshot: %w", err)
+	}
+	latest, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return fmt.Errorf("latest snapshot: %w", err)
+	}
+	if latest > s.snapshotState.Seq {
+		s.snapshotState.Seq = latest
+	}
+	return nil
+}
+
+func (s *Storage) openStandby() (err error) {
+	if s.standby, err = objstore.Open(s.config.standbyURL, s.config.objectStorageOptions); err != nil {
+		return err
+	}
+	return s.loadSnapshotState()
+}
+
+// recordDeletedApp makes sure the app is deleted on the standby.
+// Must be called with putMutex held.
+func (s *Storage) recordDeletedApp(appname string) error {
+	s.snapshotMutex.Lock()
+	defer s.snapshotMutex.Unlock()
+	s.snapshotState.DeletedApps = append(s.snapshotState.DeletedApps, appname)
+	return s.saveSnapshotState()
+}
+
+func (s *Storage) shipSnapshotTask() {
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(s.metrics.snapshotShippingDuration.Observe))
+	defer timer.ObserveDuration()
+	if err := s.shipSnapshot(); err != nil {
+		s.logger.WithError(err).Error("failed to ship storage snapshot")
+	}
+}
+
+func (s *Storage) shipSnapshot() error {
+	s.putMutex.Lock()
+	var err error
+	if s.journal != nil {
+		err = s.truncateJournal()
+	} else {
+		s.writeBack()
+	}
+	s.snapshotMutex.Lock()
+	deleted := append([]string(nil), s.snapshotState.DeletedApps...)
+	since := make(map[string]uint64, len(s.snapshotState.Versions))
+	for k, v := range s.snapshotState.Versions {
+		since[k] = v
+	}
+	s.snapshotMutex.Unlock()
+	s.putMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	versions := make(map[string]uint64, len(since))
+	changed := len(deleted) > 0
+	if changed {
+		b, err := json.Marshal(deleted)
+		if err != nil {
+			return err
+		}
+		if err = writeSnapshotEntry(tw, snapshotDeletedApps, b); err != nil {
+			return err
+		}
+	}
+	for _, d := range s.databases() {
+		var b bytes.Buffer
+		if versions[d.name], err = d.Dump(&b, since[d.name]); err != nil {
+			return fmt.Errorf("dump %s: %w", d.name, err)
+		}
+		if b.Len() == 0 {
+			continue
+		}
+		changed = true
+		if err = writeSnapshotEntry(tw, d.name, b.Bytes()); err != nil {
+			return err
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+
+	seq := s.snapshotState.Seq + 1
+	if err = s.snapshots.Put(snapshotName(seq), buf.Bytes()); err != nil {
+		return fmt.Errorf("uploading snapshot %d: %w", seq, err)
+	}
+	if err = s.snapshots.Put(snapshotsLatest, []byte(strconv.FormatUint(seq, 10))); err != nil {
+		return fmt.Errorf("uploading snapshot %d: %w", seq, err)
+	}
+	s.metrics.snapshotsShipped.Inc()
+
+	s.snapshotMutex.Lock()
+	defer s.snapshotMutex.Unlock()
+	s.snapshotState.Seq = seq
+	s.snapshotState.Versions = versions
+	// Apps might have been deleted in the meantime.
+	s.snapshotState.DeletedApps = s.snapshotState.DeletedApps[len(deleted):]
+	return s.saveSnapshotState()
+}
+
+func writeSnapshotEntry(tw *tar.Writer, name string, b []byte) error {
+	err := tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    int64(len(b)),
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(b)
+	return err
+}
+
+func (s *Storage) tailSnapshotsTask() {
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(s.metrics.snapshotApplyingDuration.Observe))
+	defer timer.ObserveDuration()
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+		seq := s.snapshotState.Seq + 1
+		b, err := s.standby.Get(snapshotName(seq))
+		switch {
+		case err == nil:
+		case errors.Is(err, objstore.ErrNotFound):
+			return
+		default:
+			s.logger.WithError(err).WithField("seq", seq).Error("failed to download storage snapshot")
+			return
+		}
+		if err = s.applySnapshot(b); err != nil {
+			// The snapshot is retried on the next run.
+			s.logger.WithError(err).WithField("seq", seq).Error("failed to apply storage snapshot")
+			return
+		}
+		s.metrics.snapshotsApplied.Inc()
+		s.snapshotMutex.Lock()
+		s.snapshotState.Seq = seq
+		err = s.saveSnapshotState()
+		s.snapshotMutex.Unlock()
+		if err != nil {
+			s.logger.WithError(err).Error("failed to save snapshot state")
+			return
+		}
+	}
+}
+
+// applySnapshot loads the snapshot into the databases. Entries loaded
+// twice (e.g., if the process crashes halfway) have no effect.
+func (s *Storage) applySnapshot(b []byte) error {
+	dbs := make(map[string]*db)
+	for _, d := range s.databases() {
+		dbs[d.name] = d
+	}
+	tr := tar.NewReader(bytes.NewReader(b))
+	for {
+		h, err := tr.Next()
+		switch {
+		case errors.Is(err, io.EOF):
+			return s.reloadStandby()
+		case err != nil:
+			return err
+		}
+		if h.Name == snapshotDeletedApps {
+			var apps []string
+			if err = json.NewDecoder(tr).Decode(&apps); err != nil {
+				return fmt.Errorf("%s: %w", snapshotDeletedApps, err)
+			}
+			s.putMutex.Lock()
+			for _, app := range apps {
+				if err = s.deleteApp(app); err != nil {
+					break
+				}
+			}
+			s.putMutex.Unlock()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		d, ok := dbs[h.Name]
+		if !ok {
+			return fmt.Errorf("unknown database %q", h.Name)
+		}
+		if err = d.Load(tr); err != nil {
+			return fmt.Errorf("load %s: %w", h.Name, err)
+		}
+	}
+}
+
+// reloadStandby discards the state derived from the databases
+// that is outdated once a snapshot is applied.
+func (s *Storage) reloadStandby() error {
+	s.purgeQueryCache()
+	for _, d := range s.databases() {
+		if d.Cache != nil {
+			d.Cache.Purge()
+		}
+	}
+	if err := s.loadTombstones(); err != nil {
+		return err
+	}
+	return s.loadAPIKeys()
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("snapshot shipping", func() {
+	var (
+		primary, standby *Storage
+		snapshots        *testing.TmpDirectory
+		standbyDir       *testing.TmpDirectory
+	)
+
+	testing.WithConfig(func(cfg **config.Config) {
+		JustBeforeEach(func() {
+			snapshots = testing.TmpDirSync()
+			standbyDir = testing.TmpDirSync()
+			c := (*cfg).Server
+			c.SnapshotShippingURL = "file://" + snapshots.Path
+			c.SnapshotShippingInterval = time.Hour
+			var err error
+			primary, err = New(NewConfig(&c), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+
+			c = (*cfg).Server
+			c.StoragePath = standbyDir.Path
+			c.StandbyURL = "file://" + snapshots.Path
+			c.StandbyPollInterval = time.Hour
+			standby, err = New(NewConfig(&c), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		JustAfterEach(func() {
+			Expect(primary.Close()).To(Succeed())
+			Expect(standby.Close()).To(Succeed())
+			snapshots.Close()
+			standbyDir.Close()
+		})
+
+		exclusively := func(s *Storage, f func()) {
+			s.tasksMutex.Lock()
+			defer s.tasksMutex.Unlock()
+			f()
+		}
+
+		ship := func() {
+			exclusively(primary, primary.shipSnapshotTask)
+			exclusively(standby, standby.tailSnapshotsTask)
+		}
+
+		st := time.Now().Add(-time.Hour).Truncate(10 * time.Second)
+		put := func(name string) {
+			k, err := segment.ParseKey(name)
+			Expect(err).ToNot(HaveOccurred())
+			t := tree.New()
+			t.Insert([]byte("a;b"), uint64(1))
+			Expect(primary.Put(&PutInput{
+				StartTime:  st,
+				EndTime:    st.Add(10 * time.Second),
+				Key:        k,
+				Val:        t,
+				SpyName:    "testspy",
+				SampleRate: 100,
+			})).To(Succeed())
+		}
+
+		get := func(s *Storage, name string) *GetOutput {
+			k, err := segment.ParseKey(name)
+			Expect(err).ToNot(HaveOccurred())
+			o, err := s.Get(&GetInput{StartTime: st, EndTime: st.Add(10 * time.Second), Key: k})
+			Expect(err).ToNot(HaveOccurred())
+			return o
+		}
+
+		It("applies shipped snapshots on the standby", func() {
+			put("app.cpu{foo=bar}")
+			ship()
+			o := get(standby, "app.cpu{foo=bar}")
+			Expect(o).ToNot(BeNil())
+			Expect(o.Tree.String()).To(Equal("a;b 1\n"))
+
+			// Only changes are shipped.
+			put("app.cpu{foo=bar}")
+			seq := primary.snapshotState.Seq
+			ship()
+			Expect(primary.snapshotState.Seq).To(Equal(seq + 1))
+			Expect(standby.snapshotState.Seq).To(Equal(seq + 1))
+			Expect(get(standby, "app.cpu{foo=bar}").Tree.String()).To(Equal("a;b 2\n"))
+			ship()
+			Expect(primary.snapshotState.Seq).To(Equal(seq + 1))
+		})
+
+		It("ships deleted apps", func() {
+			put("app.cpu{foo=bar}")
+			put("other.cpu{foo=bar}")
+			ship()
+			Expect(standby.GetAppNames()).To(ConsistOf("app.cpu", "other.cpu"))
+
+			Expect(primary.DeleteApp("app.cpu")).To(Succeed())
+			ship()
+			Expect(standby.GetAppNames()).To(ConsistOf("other.cpu"))
+			Expect(get(standby, "app.cpu{foo=bar}")).To(BeNil())
+		})
+
+		It("rejects writes on the standby", func() {
+			k, _ := segment.ParseKey("app.cpu")
+			Expect(standby.Put(&PutInput{Key: k, Val: tree.New()})).To(MatchError(errStandby))
+			Expect(standby.DeleteApp("app.cpu")).To(MatchError(errStandby))
+		})
+	})
+})
@@ -4,16 +4,21 @@ package storage
 
 import (
 	"errors"
+	"fmt"
 	"runtime"
 	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
 
 	"github.com/pyroscope-io/pyroscope/pkg/health"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/labels"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/objstore"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/wal"
 	"github.com/pyroscope-io/pyroscope/pkg/util/bytesize"
 )
 
@@ -21,6 +26,7 @@ var (
 	errRetention  = errors.New("could not write because of retention settings")
 	errOutOfSpace = errors.New("running out of space")
 	errClosed     = errors.New("storage closed")
+	errReadOnly   = errors.New("storage is read-only")
 )
 
 type Storage struct {
@@ -37,6 +43,35 @@ type Storage struct {
 	main       *db
 	labels     *labels.Labels
 
+	// objects is the object storage old trees are offloaded to, if configured.
+	objects objstore.Store
+	// retentionMutex guards retention settings, which can be replaced
+	// on config reload.
+	retentionMutex sync.RWMutex
+	// appRetention is ordered by precedence.
+	appRetention []appRetention
+	// appQuotas is ordered by precedence.
+	appQuotas []appQuota
+	// maxDiskUsage is the disk usage limit; 0 if there is no limit.
+	maxDiskUsage bytesize.ByteSize
+	// journal is the write-ahead log of ingested profiles, if enabled.
+	journal *wal.Log
+	// treeShards is the trees database, if it's sharded by time.
+	treeShards *shardedBackend
+	// tombstones are pending deletions of data within a time range.
+	tombstones tombstones
+	// apiKeys are the keys used for authentication of API requests.
+	apiKeys apiKeys
+	// audit keeps the audit log event keys unique.
+	audit auditLog
+	// queryCache keeps results of recent queries, if enabled.
+	queryCache *queryCache
+	// cardinality tracks tag values and series of applications,
+	// if cardinality limits are set.
+	cardinality cardinalityLimits
+	// healthCheck throttles the database writes of CheckDatabases.
+	healthCheck healthCheck
+
 	hc *health.Controller
 
 	// Maintenance tasks are executed exclusively to avoid competition:
@@ -51,6 +86,25 @@ type Storage struct {
 	queue          chan *PutInput
 
 	putMutex sync.Mutex
+
+	// Snapshots are shipped to, or applied from (standby).
+	snapshots     objstore.Store
+	standby       objstore.Store
+	snapshotMutex sync.Mutex
+	snapshotState snapshotState
+
+	compactionWindow  *compactionWindow
+	compactionLimiter *rate.Limiter
+
+	lastDictGC time.Time
+
+	// Latest profile time of applications, pending write back.
+	appWritesMutex sync.Mutex
+	appWrites      map[string]int64
+	preloading     uint32
+
+	installIDMutex  sync.Mutex
+	cachedInstallID string
 }
 
 type storageOptions struct {
@@ -59,10 +113,14 @@ type storageOptions struct {
 	writeBackTaskInterval     time.Duration
 	evictionTaskInterval      time.Duration
 	retentionTaskInterval     time.Duration
+	offloadTaskInterval       time.Duration
+	diskUsageTaskInterval     time.Duration
+	tombstonesTaskInterval    time.Duration
 	cacheTTL                  time.Duration
 	gcSizeDiff                bytesize.ByteSize
 	queueLen                  int
 	queueWorkers              int
+	preloadWorkers            int
 }
 
 // MetricsExporter exports values of particular stack traces sample from profiling
@@ -88,13 +146,17 @@ func New(c *Config, logger *logrus.Logger, reg prometheus.Registerer, hc *health
 		config: c,
 		storageOptions: &storageOptions{
 			// Interval at which GC triggered if the db size has increased more
-			// than by gcSizeDiff since the last probe.
+			// than by gcSizeDiff since the last probe. Overridden by the
+			// compaction interval, if set.
 			badgerGCTaskInterval: 5 * time.Minute,
 			// DB size and cache size metrics are updated periodically.
 			metricsUpdateTaskInterval: 10 * time.Second,
 			writeBackTaskInterval:     time.Minute,
 			evictionTaskInterval:      20 * time.Second,
 			retentionTaskInterval:     10 * time.Minute,
+			offloadTaskInterval:       10 * time.Minute,
+			diskUsageTaskInterval:     time.Minute,
+			tombstonesTaskInterval:    time.Minute,
 			cacheTTL:                  2 * time.Minute,
 			// gcSizeDiff specifies the minimal storage size difference that
 			// causes garbage collection to trigger.
@@ -102,6 +164,8 @@ func New(c *Config, logger *logrus.Logger, reg prometheus.Registerer, hc *health
 			// in-memory queue params.
 			queueLen:     100,
 			queueWorkers: runtime.NumCPU(),
+			// Number of applications indexes are preloaded for in parallel.
+			preloadWorkers: runtime.NumCPU(),
 		},
 
 		hc:      hc,
@@ -113,27 +177,123 @@ func New(c *Config, logger *logrus.Logger, reg prometheus.Registerer, hc *health
 	s.queue = make(chan *PutInput, s.queueLen)
 
 	var err error
-	if s.main, err = s.newBadger("main", "", nil); err != nil {
+	if s.appRetention, err = parseAppRetention(c.appRetention); err != nil {
+		return nil, err
+	}
+	if s.appQuotas, err = parseAppQuotas(c.quotas); err != nil {
+		return nil, err
+	}
+	if err = validateCardinalityLimitAction(c.cardinalityLimitAction); err != nil {
+		return nil, err
+	}
+	if !c.inMemory && c.maxDiskUsage != "" {
+		if s.maxDiskUsage, err = parseDiskUsageLimit(c.maxDiskUsage, c.badgerBasePath); err != nil {
+			return nil, err
+		}
+	}
+	if s.maxDiskUsage > 0 || len(s.appQuotas) > 0 {
+		if c.diskUsageLowWatermark <= 0 || c.diskUsageLowWatermark > 1 {
+			return nil, fmt.Errorf("invalid disk usage low watermark %v: must be within (0, 1]", c.diskUsageLowWatermark)
+		}
+	}
+	switch c.treeFormat {
+	case 0:
+		c.treeFormat = tree.FormatRows
+	case tree.FormatRows, tree.FormatColumns:
+	default:
+		return nil, fmt.Errorf("invalid tree format %d: must be %d or %d", c.treeFormat, tree.FormatRows, tree.FormatColumns)
+	}
+	if c.treeShardDuration < 0 || (c.treeShardDuration > 0 && c.treeShardDuration < time.Hour) {
+		return nil, fmt.Errorf("invalid tree shard duration %v: must be at least 1h", c.treeShardDuration)
+	}
+	if c.treeShardDuration > 0 && (c.snapshotShippingURL != "" || c.standbyURL != "") {
+		return nil, errors.New("snapshot shipping and standby modes are not supported with tree sharding")
+	}
+	if c.readOnly && (c.wal || c.snapshotShippingURL != "" || c.auditLog) {
+		return nil, errors.New("write-ahead log, snapshot shipping and audit log are not supported in read-only mode")
+	}
+	if s.compactionWindow, err = parseCompactionWindow(c.compactionWindow); err != nil {
+		return nil, err
+	}
+	if c.compactionInterval > 0 {
+		s.badgerGCTaskInterval = c.compactionInterval
+	}
+	s.compactionLimiter = s.newCompactionLimiter()
+	if c.queryCacheTTL > 0 {
+		if c.queryCacheMaxEntries <= 0 {
+			return nil, fmt.Errorf("invalid query cache size %d: must be positive", c.queryCacheMaxEntries)
+		}
+		s.queryCache = newQueryCache(c.queryCacheTTL, c.queryCacheMaxEntries)
+	}
+	if err = s.loadEncryptionKey(); err != nil {
 		return nil, err
 	}
-	if s.dicts, err = s.newBadger("dicts", dictionaryPrefix, dictionaryCodec{}); err != nil {
+	if c.objectStorageURL != "" {
+		if s.objects, err = objstore.Open(c.objectStorageURL, c.objectStorageOptions); err != nil {
+			return nil, err
+		}
+	}
+	if s.main, err = s.newDB("main", "", nil); err != nil {
+		return nil, err
+	}
+	if s.dicts, err = s.newDB("dicts", dictionaryPrefix, dictionaryCodec{}); err != nil {
 		return nil, err
 	}
-	if s.dimensions, err = s.newBadger("dimensions", dimensionPrefix, dimensionCodec{}); err != nil {
+	if s.dimensions, err = s.newDB("dimensions", dimensionPrefix, dimensionCodec{}); err != nil {
 		return nil, err
 	}
-	if s.segments, err = s.newBadger("segments", segmentPrefix, segmentCodec{}); err != nil {
+	if s.segments, err = s.newDB("segments", segmentPrefix, segmentCodec{}); err != nil {
 		return nil, err
 	}
-	if s.trees, err = s.newBadger("trees", treePrefix, treeCodec{s}); err != nil {
+	if s.trees, err = s.newDB("trees", treePrefix, treeCodec{s}); err != nil {
+		// Tree sharding settings may be invalid: the databases
+		// are closed so that the storage can be opened again.
+		for _, d := range []*db{s.main, s.dicts, s.dimensions, s.segments} {
+			d.close()
+		}
 		return nil, err
 	}
 
-	s.labels = labels.New(s.main.DB)
+	s.labels = labels.New(s.main.Backend)
 
 	if err = s.migrate(); err != nil {
 		return nil, err
 	}
+	if err = s.loadTombstones(); err != nil {
+		return nil, err
+	}
+	if err = s.loadAPIKeys(); err != nil {
+		return nil, err
+	}
+
+	if !c.inMemory && c.snapshotShippingURL != "" && c.standbyURL != "" {
+		return nil, errors.New("snapshot shipping and standby modes are mutually exclusive")
+	}
+	if !c.inMemory && c.snapshotShippingURL != "" {
+		if err = s.openSnapshots(); err != nil {
+			return nil, err
+		}
+	}
+	if !c.inMemory && c.standbyURL != "" {
+		if err = s.openStandby(); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.auditLog && s.standby == nil {
+		if err = s.auditRetention(); err != nil {
+			return nil, err
+		}
+	}
+
+	if !c.inMemory && c.wal {
+		if err = s.openJournal(); err != nil {
+			return nil, err
+		}
+		if err = s.replayJournal(); err != nil {
+			return nil, err
+		}
+	}
 
 	s.maintenanceTask(s.writeBackTaskInterval, s.writeBackTask)
 	s.startQueueWorkers()
@@ -146,10 +306,36 @@ func New(c *Config, logger *logrus.Logger, reg prometheus.Registerer, hc *health
 		}
 
 		s.maintenanceTask(s.evictionTaskInterval, s.evictionTask(memTotal))
-		s.maintenanceTask(s.retentionTaskInterval, s.retentionTask)
+		s.periodicTask(s.badgerGCTaskInterval, s.compactionTask)
+		switch {
+		case s.standby != nil:
+			// Data is only modified by applied snapshots.
+			s.maintenanceTask(c.standbyPollInterval, s.tailSnapshotsTask)
+		case c.readOnly:
+			// Retention, deletion, and offloading modify the data.
+		default:
+			s.maintenanceTask(s.retentionTaskInterval, s.retentionTask)
+			s.maintenanceTask(s.tombstonesTaskInterval, s.tombstonesTask)
+			if s.maxDiskUsage > 0 {
+				s.maintenanceTask(s.diskUsageTaskInterval, s.diskUsageTask)
+			}
+			if len(s.appQuotas) > 0 {
+				s.maintenanceTask(s.diskUsageTaskInterval, s.quotaTask)
+			}
+			if s.objects != nil {
+				s.maintenanceTask(s.offloadTaskInterval, s.offloadTask)
+			}
+		}
+		if s.snapshots != nil {
+			s.maintenanceTask(c.snapshotShippingInterval, s.shipSnapshotTask)
+		}
 		s.periodicTask(s.metricsUpdateTaskInterval, s.updateMetricsTask)
 	}
 
+	if c.preloadApps > 0 {
+		s.startPreload()
+	}
+
 	return s, nil
 }
 
@@ -160,6 +346,7 @@ func (s *Storage) Close() error {
 	s.logger.Debug("waiting for storage tasks to finish")
 	s.tasksWG.Wait()
 	s.logger.Debug("storage tasks finished")
+	s.writeBackAppWrites()
 	// Dictionaries DB has to close last because trees depend on it.
 	s.goDB(func(d *db) {
 		if d != s.dicts {
@@ -167,6 +354,20 @@ func (s *Storage) Close() error {
 		}
 	})
 	s.dicts.close()
+	if s.journal != nil {
+		return s.closeJournal()
+	}
+	return nil
+}
+
+// writable returns an error if the storage data can not be modified.
+func (s *Storage) writable() error {
+	switch {
+	case s.standby != nil:
+		return errStandby
+	case s.config.readOnly:
+		return errReadOnly
+	}
 	return nil
 }
 
@@ -178,6 +379,24 @@ func (s *Storage) DiskUsage() map[string]bytesize.ByteSize {
 	return m
 }
 
+// DiskUsage describes disk space used by a database.
+type DiskUsage struct {
+	// LSM is the size of the LSM tree files (.sst).
+	LSM bytesize.ByteSize `json:"lsm"`
+	// VLog is the size of the value log files (.vlog).
+	VLog bytesize.ByteSize `json:"vlog"`
+}
+
+// DiskUsageDetailed returns disk usage of every database, broken down
+// by LSM tree and value log.
+func (s *Storage) DiskUsageDetailed() map[string]DiskUsage {
+	m := make(map[string]DiskUsage)
+	for _, d := range s.databases() {
+		m[d.name] = d.usage()
+	}
+	return m
+}
+
 func (s *Storage) CacheStats() map[string]uint64 {
 	m := make(map[string]uint64)
 	for _, d := range s.databases() {
@@ -271,11 +490,26 @@ func (s *Storage) evictionTask(memTotal uint64) func() {
 func (s *Storage) writeBackTask() {
 	timer := prometheus.NewTimer(prometheus.ObserverFunc(s.metrics.writeBackTaskDuration.Observe))
 	defer timer.ObserveDuration()
-	for _, d := range s.databases() {
-		if d.Cache != nil {
-			d.WriteBack()
+	if s.journal == nil {
+		s.writeBack()
+		return
+	}
+	if err := s.checkpoint(); err != nil {
+		s.logger.WithError(err).Error("failed to truncate write-ahead log")
+	}
+}
+
+// writeBack writes cached items of all the databases to disk.
+// Databases are written back in the reverse order: serialization
+// of trees updates dictionaries.
+func (s *Storage) writeBack() {
+	dbs := s.databases()
+	for i := len(dbs) - 1; i >= 0; i-- {
+		if dbs[i].Cache != nil {
+			dbs[i].WriteBack()
 		}
 	}
+	s.writeBackAppWrites()
 }
 
 func (s *Storage) updateMetricsTask() {
@@ -283,6 +517,9 @@ func (s *Storage) updateMetricsTask() {
 		s.metrics.dbSize.WithLabelValues(d.name).Set(float64(d.size()))
 		if d.Cache != nil {
 			s.metrics.cacheSize.WithLabelValues(d.name).Set(float64(d.Cache.Size()))
+			if d.Cache.Bounded() {
+				s.metrics.cacheSizeBytes.WithLabelValues(d.name).Set(float64(d.Cache.SizeBytes()))
+			}
 		}
 	}
 }
@@ -290,11 +527,18 @@ func (s *Storage) updateMetricsTask() {
 func (s *Storage) retentionTask() {
 	timer := prometheus.NewTimer(prometheus.ObserverFunc(s.metrics.retentionTaskDuration.Observe))
 	defer timer.ObserveDuration()
-	if err := s.EnforceRetentionPolicy(s.retentionPolicy()); err != nil {
+	s.dropTreeShards()
+	err := s.enforceRetention(func(k *segment.Key) *segment.RetentionPolicy {
+		return s.appRetentionPolicy(k.AppName())
+	})
+	if err != nil {
 		s.logger.WithError(err).Error("failed to enforce retention policy")
 	}
+	s.dictGCTask()
 }
 
+// retentionPolicy returns the global retention policy.
+// retentionMutex must be held.
 func (s *Storage) retentionPolicy() *segment.RetentionPolicy {
 	rp := segment.NewRetentionPolicy().SetAbsolutePeriod(s.config.retention)
 	levels := []time.Duration{
@@ -302,6 +546,18 @@ func (s *Storage) retentionPolicy() *segment.RetentionPolicy {
 		s.config.retentionLevels.One,
 		s.config.retentionLevels.Two,
 	}
+	// Data older than the downsampling age is only kept in trees of the
+	// configured resolution and coarser: they cover the same time ranges.
+	if age := s.config.downsamplingAge; age > 0 {
+		for i := 0; i < segment.DepthForDuration(s.config.downsamplingResolution); i++ {
+			if i == len(levels) {
+				levels = append(levels, 0)
+			}
+			if levels[i] == 0 || levels[i] > age {
+				levels[i] = age
+			}
+		}
+	}
 	for i, p := range levels {
 		if p != 0 {
 			rp.SetLevelPeriod(i, p)
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("storage backend", func() {
+	var s *Storage
+
+	testing.WithConfig(func(cfg **config.Config) {
+		JustBeforeEach(func() {
+			var err error
+			c := NewConfig(&(*cfg).Server).WithBackend(backend.Memory)
+			s, err = New(c, logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		JustAfterEach(func() {
+			Expect(s.Close()).To(Succeed())
+		})
+
+		It("stores profiles using the configured backend", func() {
+			k, err := segment.ParseKey("app.cpu{foo=bar}")
+			Expect(err).ToNot(HaveOccurred())
+			t := tree.New()
+			t.Insert([]byte("a;b"), uint64(1))
+			st := testing.SimpleTime(10)
+			et := testing.SimpleTime(19)
+			Expect(s.Put(&PutInput{
+				StartTime:  st,
+				EndTime:    et,
+				Key:        k,
+				Val:        t,
+				SpyName:    "testspy",
+				SampleRate: 100,
+			})).To(Succeed())
+
+			o, err := s.Get(&GetInput{StartTime: st, EndTime: et, Key: k})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(o.Tree.String()).To(Equal(t.String()))
+			Expect(s.main.Get([]byte("l:foo"))).ToNot(BeNil())
+		})
+	})
+
+	It("fails if the backend is unknown", func() {
+		_, err := New(NewConfig(new(config.Server)).WithInMemory().WithBackend("foo"),
+			logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+		Expect(err).To(HaveOccurred())
+	})
+})
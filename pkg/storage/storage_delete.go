@@ -18,6 +18,8 @@ func (s *Storage) Delete(di *DeleteInput) error {
 
 func (s *Storage) deleteSegmentAndRelatedData(k *segment.Key) error {
 	sk := k.SegmentKey()
+	s.invalidateQueryCacheKey(k)
+	s.resetCardinality(k.AppName())
 
 	// Drop trees from disk.
 	if err := s.trees.DropPrefix(treePrefix.key(sk)); err != nil {
@@ -41,12 +43,12 @@ func (s *Storage) deleteSegmentAndRelatedData(k *segment.Key) error {
 			return err
 		}
 		if key == "__name__" {
-			if err := s.dicts.Delete(k.DictKey()); err != nil {
+			if err := s.dicts.Cache.Delete(k.DictKey()); err != nil {
 				return err
 			}
 		}
 	}
-	return s.segments.Delete(sk)
+	return s.segments.Cache.Delete(sk)
 }
 
 // DeleteApp fully deletes an app
@@ -54,6 +56,29 @@ func (s *Storage) deleteSegmentAndRelatedData(k *segment.Key) error {
 // It's an idempotent call, ie. if the app already does not exist, no error is triggered.
 // TODO cancelation?
 func (s *Storage) DeleteApp(appname string) error {
+	// Ingestion is suspended so that the app is not recreated halfway.
+	s.putMutex.Lock()
+	defer s.putMutex.Unlock()
+	if err := s.writable(); err != nil {
+		return err
+	}
+	if err := s.deleteApp(appname); err != nil {
+		return err
+	}
+	if s.snapshots != nil {
+		if err := s.recordDeletedApp(appname); err != nil {
+			return err
+		}
+	}
+	if s.journal != nil {
+		// Otherwise the app profiles journaled before
+		// deletion would be replayed after a crash.
+		return s.truncateJournal()
+	}
+	return nil
+}
+
+func (s *Storage) deleteApp(appname string) error {
 	/***********************************/
 	/*      V a l i d a t i o n s      */
 	/***********************************/
@@ -127,6 +152,14 @@ func (s *Storage) DeleteApp(appname string) error {
 	// include 'my_another_application':
 	//   foo=bar
 	//     my_another_application{foo=bar}
+	s.invalidateQueryCacheKey(key)
+	if err = s.deleteExemplars(key.AppName()); err != nil {
+		return err
+	}
+	if err = s.deleteAppWrite(key.AppName()); err != nil {
+		return err
+	}
+
 	s.logger.Debugf("looking for app dimension '%s'\n", appname)
 	d, ok := s.lookupAppDimension(appname)
 	if !ok {
@@ -172,7 +205,7 @@ func (s *Storage) DeleteApp(appname string) error {
 			}
 
 			s.logger.Debugf("deleting dimension %s=%s \n", labelKey, labelValue)
-			if err := s.dimensions.Delete(labelKey + ":" + labelValue); err != nil {
+			if err := s.dimensions.Cache.Delete(labelKey + ":" + labelValue); err != nil {
 				return err
 			}
 		}
@@ -191,7 +224,7 @@ func (s *Storage) DeleteApp(appname string) error {
 	}
 
 	s.logger.Debugf("deleting dicts %s\n", key.DictKey())
-	if err := s.dicts.Delete(key.DictKey()); err != nil {
+	if err := s.dicts.Cache.Delete(key.DictKey()); err != nil {
 		return err
 	}
 
@@ -201,5 +234,6 @@ func (s *Storage) DeleteApp(appname string) error {
 	}
 
 	s.logger.Debugf("deleting dimensions for __name__=%s\n", appname)
-	return s.dimensions.Delete("__name__:" + appname)
+	s.resetCardinality(appname)
+	return s.dimensions.Cache.Delete("__name__:" + appname)
 }
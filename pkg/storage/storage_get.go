@@ -3,12 +3,15 @@ package storage
 import (
 	"context"
 	"fmt"
+	"math"
 	"math/big"
 	"runtime/trace"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 
 	"github.com/pyroscope-io/pyroscope/pkg/flameql"
@@ -23,6 +26,53 @@ type GetInput struct {
 	EndTime   time.Time
 	Key       *segment.Key
 	Query     *flameql.Query
+	// Aggregation of profiles of the series matching the key or query,
+	// e.g. instances of an application. Sum if not specified.
+	Aggregation Aggregation
+}
+
+type Aggregation string
+
+const (
+	AggregationSum Aggregation = "sum"
+	// AggregationAvg divides the sum by the number of series
+	// that have data in the time range.
+	AggregationAvg Aggregation = "avg"
+	// AggregationMedian and other percentile aggregations (pNN, e.g.
+	// p90) calculate the percentile of every node of the trees of the
+	// series within the time range, scaled to a 10s interval, so that
+	// the result is the typical profile of a series over 10 seconds.
+	AggregationMedian Aggregation = "median"
+)
+
+// ParseAggregation returns the aggregation by its name.
+func ParseAggregation(name string) (Aggregation, error) {
+	switch a := Aggregation(name); a {
+	case "", AggregationSum:
+		return AggregationSum, nil
+	case AggregationAvg, AggregationMedian:
+		return a, nil
+	default:
+		if _, ok := a.percentile(); ok {
+			return a, nil
+		}
+		return "", fmt.Errorf("unknown aggregation %q: must be %q, %q, %q, or a percentile from p1 to p99", name, AggregationSum, AggregationAvg, AggregationMedian)
+	}
+}
+
+// percentile returns the percentile the aggregation calculates, if any.
+func (a Aggregation) percentile() (float64, bool) {
+	if a == AggregationMedian {
+		return 0.5, true
+	}
+	if len(a) < 2 || a[0] != 'p' {
+		return 0, false
+	}
+	n, err := strconv.Atoi(string(a[1:]))
+	if err != nil || n < 1 || n > 99 || strconv.Itoa(n) != string(a[1:]) {
+		return 0, false
+	}
+	return float64(n) / 100, true
 }
 
 type GetOutput struct {
@@ -68,6 +118,8 @@ func (s *Storage) GetContext(ctx context.Context, gi *GetInput) (*GetOutput, err
 	}
 
 	s.getTotal.Inc()
+	timer := prometheus.NewTimer(s.getDuration)
+	defer timer.ObserveDuration()
 	logger.Debug("storage.Get")
 	trace.Logf(ctx, traceCatGetKey, "%+v", gi)
 
@@ -95,16 +147,88 @@ func (s *Storage) GetContext(ctx context.Context, gi *GetInput) (*GetOutput, err
 		}
 	}
 
+	if s.queryCache == nil {
+		return s.getByDimensionKeys(ctx, gi, dimensionKeys())
+	}
+	return s.getCached(ctx, gi, dimensionKeys)
+}
+
+// getCached serves the query from the query cache; the time range is
+// aligned to the cache resolution.
+func (s *Storage) getCached(ctx context.Context, gi *GetInput, dimensionKeys func() []dimension.Key) (*GetOutput, error) {
+	aligned := *gi
+	aligned.StartTime, aligned.EndTime = alignQueryTimeRange(gi.StartTime, gi.EndTime)
+	if o, ok := s.queryCache.get(&aligned); ok {
+		s.queryCacheHits.Inc()
+		return o, nil
+	}
+	s.queryCacheMisses.Inc()
+	generation := s.queryCache.currentGeneration(&aligned)
+	o, err := s.getByDimensionKeys(ctx, &aligned, dimensionKeys())
+	if err != nil {
+		return nil, err
+	}
+	s.queryCache.put(&aligned, o, generation)
+	return o, nil
+}
+
+// GetGroupedContext returns profiles of the series matching the key or
+// query, merged by values of the label. Series without the label are
+// merged into the group with the empty value.
+func (s *Storage) GetGroupedContext(ctx context.Context, gi *GetInput, label string) (map[string]*GetOutput, error) {
+	var dimensionKeys func() []dimension.Key
+	switch {
+	case gi.Key != nil:
+		dimensionKeys = s.dimensionKeysByKey(gi.Key)
+	case gi.Query != nil:
+		dimensionKeys = s.dimensionKeysByQuery(gi.Query)
+	default:
+		return nil, fmt.Errorf("key or query must be specified")
+	}
+
+	groups := make(map[string][]dimension.Key)
+	for _, k := range dimensionKeys() {
+		parsedKey, err := segment.ParseKey(string(k))
+		if err != nil {
+			s.logger.Errorf("parse key: %v: %v", string(k), err)
+			continue
+		}
+		v := parsedKey.Labels()[label]
+		groups[v] = append(groups[v], k)
+	}
+
+	res := make(map[string]*GetOutput, len(groups))
+	for v, keys := range groups {
+		o, err := s.getByDimensionKeys(ctx, gi, keys)
+		if err != nil {
+			return nil, err
+		}
+		if o != nil {
+			res[v] = o
+		}
+	}
+	return res, nil
+}
+
+func (s *Storage) getByDimensionKeys(ctx context.Context, gi *GetInput, dimensionKeys []dimension.Key) (*GetOutput, error) {
 	var (
 		resultTrie  *tree.Tree
 		lastSegment *segment.Segment
 		writesTotal uint64
+		seriesTotal int64
 
 		aggregationType = "sum"
 		timeline        = segment.GenerateTimeline(gi.StartTime, gi.EndTime)
+
+		// Trees and their scale factors for percentile aggregations.
+		percentileTrees  []*tree.Tree
+		percentileScales []float64
+
+		mergeDuration time.Duration
 	)
+	percentile, isPercentile := gi.Aggregation.percentile()
 
-	for _, k := range dimensionKeys() {
+	for _, k := range dimensionKeys {
 		// TODO: refactor, store `Key`s in dimensions
 		parsedKey, err := segment.ParseKey(string(k))
 		if err != nil {
@@ -124,31 +248,68 @@ func (s *Storage) GetContext(ctx context.Context, gi *GetInput) (*GetOutput, err
 
 		timeline.PopulateTimeline(st)
 		lastSegment = st
+		tombstones := s.matchingTombstones(parsedKey)
 
 		trace.Logf(ctx, traceCatGetCallback, "segment_key=%s", key)
+		var found bool
 		st.GetContext(ctx, gi.StartTime, gi.EndTime, func(depth int, samples, writes uint64, t time.Time, r *big.Rat) {
+			if tombstones != nil && overlapsTombstones(tombstones, depth, t) {
+				return
+			}
 			tk := parsedKey.TreeKey(depth, t)
 			res, ok = s.trees.Lookup(tk)
 			trace.Logf(ctx, traceCatGetCallback, "tree_found=%v time=%d r=%v", ok, t.Unix(), r)
+			if ok && isPercentile {
+				found = true
+				writesTotal += writes
+				// Trees of nodes at depth d are aggregated over
+				// 10^d 10s intervals, or writes, if averaged.
+				scale := 1 / math.Pow10(depth)
+				if st.AggregationType() == averageAggregationType && writes > 0 {
+					scale = 1 / float64(writes)
+				}
+				percentileTrees = append(percentileTrees, res.(*tree.Tree))
+				percentileScales = append(percentileScales, scale)
+				return
+			}
 			if ok {
+				found = true
+				mergeStart := time.Now()
 				x := res.(*tree.Tree).Clone(r)
 				writesTotal += writes
 				if resultTrie == nil {
 					resultTrie = x
-					return
+				} else {
+					resultTrie.Merge(x)
 				}
-				resultTrie.Merge(x)
+				mergeDuration += time.Since(mergeStart)
 			}
 		})
+		if found {
+			seriesTotal++
+		}
 	}
 
+	if isPercentile && len(percentileTrees) > 0 {
+		mergeStart := time.Now()
+		resultTrie = tree.Percentile(percentileTrees, percentileScales, percentile)
+		mergeDuration += time.Since(mergeStart)
+	}
+	if mergeDuration > 0 {
+		s.treeMergeDuration.Observe(mergeDuration.Seconds())
+	}
 	if resultTrie == nil || lastSegment == nil {
 		return nil, nil
 	}
 
-	if writesTotal > 0 && aggregationType == averageAggregationType {
+	// Percentile trees are scaled already.
+	if !isPercentile && writesTotal > 0 && aggregationType == averageAggregationType {
 		resultTrie = resultTrie.Clone(big.NewRat(1, int64(writesTotal)))
 	}
+	if seriesTotal > 1 && gi.Aggregation == AggregationAvg {
+		resultTrie = resultTrie.Clone(big.NewRat(1, seriesTotal))
+		timeline.Divide(uint64(seriesTotal))
+	}
 
 	return &GetOutput{
 		Tree:       resultTrie,
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/flameql"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+)
+
+type LabelsInput struct {
+	// Query selects the series, all the applications if not specified.
+	Query *flameql.Query
+	// If specified, only series having data within the time range are
+	// taken into account.
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// GetLabelKeys returns sorted names of labels of the series matching the
+// input, including __name__.
+func (s *Storage) GetLabelKeys(ctx context.Context, li *LabelsInput) ([]string, error) {
+	set := make(map[string]struct{})
+	err := s.iterateSeries(ctx, li, func(k *segment.Key) {
+		for l := range k.Labels() {
+			set[l] = struct{}{}
+		}
+	})
+	return sortedSet(set), err
+}
+
+// GetLabelValues returns sorted values of the label of the series matching
+// the input.
+func (s *Storage) GetLabelValues(ctx context.Context, li *LabelsInput, label string) ([]string, error) {
+	set := make(map[string]struct{})
+	err := s.iterateSeries(ctx, li, func(k *segment.Key) {
+		if v, ok := k.Labels()[label]; ok {
+			set[v] = struct{}{}
+		}
+	})
+	return sortedSet(set), err
+}
+
+func (s *Storage) iterateSeries(ctx context.Context, li *LabelsInput, cb func(*segment.Key)) error {
+	queries := []*flameql.Query{li.Query}
+	if li.Query == nil {
+		appNames := s.GetAppNames()
+		queries = make([]*flameql.Query, len(appNames))
+		for i, appName := range appNames {
+			queries[i] = &flameql.Query{AppName: appName}
+		}
+	}
+	checkTime := !li.StartTime.IsZero() || !li.EndTime.IsZero()
+	for _, q := range queries {
+		for _, dk := range s.execQuery(ctx, q) {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			k, err := segment.ParseKey(string(dk))
+			if err != nil {
+				s.logger.Errorf("parse key: %v: %v", string(dk), err)
+				continue
+			}
+			if checkTime && !s.hasData(k, li.StartTime, li.EndTime) {
+				continue
+			}
+			cb(k)
+		}
+	}
+	return nil
+}
+
+// hasData reports whether the series has data within the time range.
+func (s *Storage) hasData(k *segment.Key, st, et time.Time) bool {
+	res, ok := s.segments.Lookup(k.SegmentKey())
+	if !ok {
+		return false
+	}
+	var found bool
+	res.(*segment.Segment).Get(st, et, func(int, uint64, uint64, time.Time, *big.Rat) {
+		found = true
+	})
+	return found
+}
+
+func sortedSet(set map[string]struct{}) []string {
+	r := make([]string, 0, len(set))
+	for v := range set {
+		r = append(r, v)
+	}
+	sort.Strings(r)
+	return r
+}
@@ -27,13 +27,28 @@ func (s *Storage) Put(pi *PutInput) error {
 	// TODO: This is a pretty broad lock. We should find a way to make these locks more selective.
 	s.putMutex.Lock()
 	defer s.putMutex.Unlock()
+	if err := s.writable(); err != nil {
+		return err
+	}
 	if s.hc.IsOutOfDiskSpace() {
 		return errOutOfSpace
 	}
-	if pi.StartTime.Before(s.retentionPolicy().LowerTimeBoundary()) {
+	if pi.StartTime.Before(s.appRetentionPolicy(pi.Key.AppName()).LowerTimeBoundary()) {
 		return errRetention
 	}
+	var err error
+	if pi.Key, err = s.applyCardinalityLimits(pi.Key); err != nil {
+		return err
+	}
+	if s.journal != nil {
+		if err := s.journal.Append(encodePutInput(pi)); err != nil {
+			return fmt.Errorf("write-ahead log: %w", err)
+		}
+	}
+	return s.put(pi)
+}
 
+func (s *Storage) put(pi *PutInput) error {
 	s.putTotal.Inc()
 	s.logger.WithFields(logrus.Fields{
 		"startTime":       pi.StartTime.String(),
@@ -51,12 +66,14 @@ func (s *Storage) Put(pi *PutInput) error {
 			pi.Val.Merge(v.(*tree.Tree))
 		}
 		s.trees.Put(k, pi.Val)
-		return nil
+		return s.saveExemplar(pi)
 	}
 
 	for k, v := range pi.Key.Labels() {
 		s.labels.Put(k, v)
 	}
+	s.recordAppWrite(pi.Key.AppName(), pi.EndTime)
+	s.invalidateQueryCache(pi.Key.AppName(), pi.StartTime, pi.EndTime)
 
 	sk := pi.Key.SegmentKey()
 	for k, v := range pi.Key.Labels() {
@@ -294,6 +294,32 @@ var _ = Describe("storage package", func() {
 				})
 			})
 		})
+
+		Context("disk usage", func() {
+			It("reports LSM and value log sizes of every database", func() {
+				tree := tree.New()
+				tree.Insert([]byte("a;b"), uint64(1))
+				key, _ := segment.ParseKey("foo")
+				Expect(s.Put(&PutInput{
+					StartTime:  testing.SimpleTime(10),
+					EndTime:    testing.SimpleTime(19),
+					Key:        key,
+					Val:        tree,
+					SpyName:    "testspy",
+					SampleRate: 100,
+				})).To(Succeed())
+
+				du := s.DiskUsageDetailed()
+				Expect(du).To(HaveLen(len(s.databases())))
+				total := s.DiskUsage()
+				for name, u := range du {
+					Expect(u.LSM).To(BeNumerically(">=", 0))
+					Expect(u.VLog).To(BeNumerically(">=", 0))
+					Expect(u.LSM + u.VLog).To(Equal(total[name]))
+				}
+				Expect(s.Close()).ToNot(HaveOccurred())
+			})
+		})
 	}
 
 	logrus.SetLevel(logrus.InfoLevel)
@@ -375,6 +401,51 @@ var _ = Describe("persistence", func() {
 				Expect(s2.Close()).ToNot(HaveOccurred())
 			})
 		})
+
+		Context("read-only mode", func() {
+			It("serves queries and rejects modifications", func() {
+				key, _ := segment.ParseKey("foo{tag=value}")
+				t := tree.New()
+				t.Insert([]byte("a;b"), uint64(1))
+				Expect(s.Put(&PutInput{
+					StartTime: testing.SimpleTime(10),
+					EndTime:   testing.SimpleTime(19),
+					Key:       key,
+					Val:       t,
+				})).To(Succeed())
+				Expect(s.Close()).To(Succeed())
+
+				s2, err := New(NewConfig(&(*cfg).Server).WithReadOnly(), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+				Expect(err).ToNot(HaveOccurred())
+				defer s2.Close()
+
+				o, err := s2.Get(&GetInput{
+					StartTime: testing.SimpleTime(0),
+					EndTime:   testing.SimpleTime(30),
+					Key:       key,
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(o.Tree.String()).To(Equal(t.String()))
+
+				Expect(s2.Put(&PutInput{
+					StartTime: testing.SimpleTime(20),
+					EndTime:   testing.SimpleTime(29),
+					Key:       key,
+					Val:       t,
+				})).To(MatchError(errReadOnly))
+				Expect(s2.DeleteApp("foo")).To(MatchError(errReadOnly))
+				_, _, err = s2.CreateAPIKey(CreateAPIKeyInput{Name: "foo", Role: APIKeyRoleReadOnly})
+				Expect(err).To(MatchError(errReadOnly))
+			})
+
+			It("does not apply migrations", func() {
+				Expect(s.Close()).To(Succeed())
+				tmpDir := testing.TmpDirSync()
+				defer tmpDir.Close()
+				_, err := New(NewConfig(&(*cfg).Server).WithPath(tmpDir.Path).WithReadOnly(), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+				Expect(err).To(HaveOccurred())
+			})
+		})
 	})
 })
 
@@ -672,3 +743,109 @@ var _ = Describe("Getters", func() {
 		})
 	})
 })
+
+var _ = Describe("bounded cache", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		JustBeforeEach(func() {
+			var err error
+			(*cfg).Server.CacheTreesMaxSize = 4 << 10
+			(*cfg).Server.CacheDimensionsMaxSize = 1 << 10
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		JustAfterEach(func() {
+			Expect(s.Close()).To(Succeed())
+		})
+
+		It("evicts trees exceeding the limit and reads them back", func() {
+			st := time.Now().Add(-time.Hour).Truncate(10 * time.Second)
+			put := func(i int) {
+				t := tree.New()
+				t.Insert([]byte("foo;bar;"+strconv.Itoa(i)), uint64(i+1))
+				key, _ := segment.ParseKey("app.cpu{i=" + strconv.Itoa(i) + "}")
+				Expect(s.Put(&PutInput{
+					StartTime:  st,
+					EndTime:    st.Add(10 * time.Second),
+					Key:        key,
+					Val:        t,
+					SpyName:    "testspy",
+					SampleRate: 100,
+				})).To(Succeed())
+			}
+			const n = 100
+			for i := 0; i < n; i++ {
+				put(i)
+			}
+
+			Expect(s.trees.Cache.Size()).To(BeNumerically("<", n))
+			Expect(s.trees.SizeBytes()).To(BeNumerically("<=", 4<<10))
+			// The most recently used entry is never evicted: the dimension
+			// of the application name alone exceeds the limit.
+			d, ok := s.lookupAppDimension("app.cpu")
+			Expect(ok).To(BeTrue())
+			Expect(s.dimensions.SizeBytes()).To(BeNumerically("<=", 1<<10+dimensionCodec{}.Size("", d)))
+
+			for i := 0; i < n; i++ {
+				key, _ := segment.ParseKey("app.cpu{i=" + strconv.Itoa(i) + "}")
+				o, err := s.Get(&GetInput{StartTime: st, EndTime: st.Add(10 * time.Second), Key: key})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(o).ToNot(BeNil())
+				Expect(o.Tree.String()).To(Equal("foo;bar;" + strconv.Itoa(i) + " " + strconv.Itoa(i+1) + "\n"))
+			}
+		})
+	})
+})
+
+var _ = Describe("tree format", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		It("reads trees written in another format", func() {
+			st := time.Now().Add(-time.Hour).Truncate(10 * time.Second)
+			key, _ := segment.ParseKey("app.cpu{foo=bar}")
+			put := func() {
+				t := tree.New()
+				t.Insert([]byte("a;b"), uint64(1))
+				t.Insert([]byte("a;c"), uint64(2))
+				Expect(s.Put(&PutInput{
+					StartTime:  st,
+					EndTime:    st.Add(10 * time.Second),
+					Key:        key,
+					Val:        t,
+					SpyName:    "testspy",
+					SampleRate: 100,
+				})).To(Succeed())
+			}
+			get := func() string {
+				o, err := s.Get(&GetInput{StartTime: st, EndTime: st.Add(10 * time.Second), Key: key})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(o).ToNot(BeNil())
+				return o.Tree.String()
+			}
+			open := func(format int) {
+				var err error
+				(*cfg).Server.StorageTreeFormat = format
+				s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			open(tree.FormatRows)
+			put()
+			Expect(s.Close()).To(Succeed())
+
+			open(tree.FormatColumns)
+			Expect(get()).To(Equal("a;b 1\na;c 2\n"))
+			put()
+			Expect(s.Close()).To(Succeed())
+
+			open(tree.FormatRows)
+			Expect(get()).To(Equal("a;b 2\na;c 4\n"))
+			Expect(s.Close()).To(Succeed())
+		})
+
+		It("rejects unknown formats", func() {
+			(*cfg).Server.StorageTreeFormat = 3
+			_, err := New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/flameql"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+)
+
+// Deletion of data matching a query within a time range is recorded as
+// a tombstone, which is applied to the segments by a maintenance task.
+// Until then, the data overlapping the tombstone time range is hidden:
+// queries skip trees of matching segments that overlap the range.
+//
+// Trees of segment nodes overlapping the range partially are removed
+// as well: the nodes are replaced with their children, unless there are
+// none (e.g. because of downsampling), in which case the whole node is
+// removed.
+
+const tombstonesPrefix = "tombstone:"
+
+var errInvalidTimeRange = errors.New("invalid time range")
+
+type DeleteRangeInput struct {
+	// Query selects the series to delete data of, e.g. app.cpu{user_id="1"}.
+	Query     *flameql.Query
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+type tombstone struct {
+	Query     string    `json:"query"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
+
+	key   string
+	query *flameql.Query
+}
+
+type tombstones struct {
+	sync.RWMutex
+	list []*tombstone
+}
+
+// DeleteRange deletes the data of the series matching the query within
+// the time range. The call does not wait for the data to be removed.
+func (s *Storage) DeleteRange(di *DeleteRangeInput) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	if di.Query == nil {
+		return fmt.Errorf("query must be specified")
+	}
+	if !di.StartTime.Before(di.EndTime) {
+		return errInvalidTimeRange
+	}
+	t := &tombstone{
+		Query:     di.Query.String(),
+		StartTime: di.StartTime,
+		EndTime:   di.EndTime,
+		key:       fmt.Sprintf("%s%020d", tombstonesPrefix, time.Now().UnixNano()),
+		query:     di.Query,
+	}
+	if err := s.saveJSON(t.key, t); err != nil {
+		return err
+	}
+	s.tombstones.Lock()
+	s.tombstones.list = append(s.tombstones.list, t)
+	s.tombstones.Unlock()
+	s.invalidateQueryCache(di.Query.AppName, di.StartTime, di.EndTime)
+	return nil
+}
+
+func (s *Storage) loadTombstones() error {
+	it := s.main.NewIterator(backend.IteratorOptions{
+		Prefix:         []byte(tombstonesPrefix),
+		PrefetchValues: true,
+	})
+	defer it.Close()
+	var list []*tombstone
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		t := tombstone{key: string(item.Key())}
+		if err = json.Unmarshal(v, &t); err != nil {
+			s.logger.WithError(err).Warn("skipping malformed tombstone")
+			continue
+		}
+		if t.query, err = flameql.ParseQuery(t.Query); err != nil {
+			s.logger.WithError(err).WithField("query", t.Query).Warn("skipping malformed tombstone")
+			continue
+		}
+		list = append(list, &t)
+	}
+	s.tombstones.Lock()
+	s.tombstones.list = list
+	s.tombstones.Unlock()
+	return nil
+}
+
+// matchingTombstones returns pending tombstones matching the key.
+func (s *Storage) matchingTombstones(k *segment.Key) []*tombstone {
+	s.tombstones.RLock()
+	defer s.tombstones.RUnlock()
+	var matched []*tombstone
+	for _, t := range s.tombstones.list {
+		if k.Match(t.query) {
+			matched = append(matched, t)
+		}
+	}
+	return matched
+}
+
+func overlapsTombstones(tombstones []*tombstone, depth int, t time.Time) bool {
+	et := t.Add(segment.DurationForDepth(depth))
+	for _, x := range tombstones {
+		if t.Before(x.EndTime) && et.After(x.StartTime) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Storage) tombstonesTask() {
+	timer := prometheus.NewTimer(prometheus.ObserverFunc(s.metrics.tombstonesTaskDuration.Observe))
+	defer timer.ObserveDuration()
+	s.tombstones.RLock()
+	list := make([]*tombstone, len(s.tombstones.list))
+	copy(list, s.tombstones.list)
+	s.tombstones.RUnlock()
+	for _, t := range list {
+		err := s.applyTombstone(t)
+		switch {
+		case err == nil:
+		case errors.Is(err, errClosed):
+			return
+		default:
+			s.logger.WithError(err).WithField("query", t.Query).Error("failed to apply tombstone")
+			continue
+		}
+		s.tombstones.Lock()
+		for i, x := range s.tombstones.list {
+			if x == t {
+				s.tombstones.list = append(s.tombstones.list[:i], s.tombstones.list[i+1:]...)
+				break
+			}
+		}
+		s.tombstones.Unlock()
+	}
+}
+
+func (s *Storage) applyTombstone(t *tombstone) error {
+	for _, dk := range s.execQuery(context.TODO(), t.query) {
+		k, err := segment.ParseKey(string(dk))
+		if err != nil {
+			s.logger.WithError(err).WithField("key", string(dk)).Error("failed to parse segment key")
+			continue
+		}
+		if err = s.deleteSegmentRange(k, t.StartTime, t.EndTime); err != nil {
+			return err
+		}
+	}
+	return s.main.Backend.Delete([]byte(t.key))
+}
+
+func (s *Storage) deleteSegmentRange(k *segment.Key, st, et time.Time) error {
+	// Ingestion into the segment would recreate the trees being removed.
+	s.putMutex.Lock()
+	defer s.putMutex.Unlock()
+	s.invalidateQueryCache(k.AppName(), st, et)
+	sk := k.SegmentKey()
+	cached, ok := s.segments.Lookup(sk)
+	if !ok {
+		return nil
+	}
+	nodes := make([]segmentNode, 0)
+	seg := cached.(*segment.Segment)
+	deleted, err := seg.WalkNodesInRange(st, et, func(d int, t time.Time) error {
+		nodes = append(nodes, segmentNode{d, t.Unix()})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if deleted {
+		return s.deleteSegmentAndRelatedData(k)
+	}
+	if err = s.deleteTrees(sk, nodes); err != nil {
+		return err
+	}
+	seg.DeleteNodesInRange(st, et)
+	s.segments.Put(sk, seg)
+	return nil
+}
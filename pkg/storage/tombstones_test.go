@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/flameql"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("tombstones", func() {
+	var s *Storage
+
+	testing.WithConfig(func(cfg **config.Config) {
+		JustBeforeEach(func() {
+			var err error
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		JustAfterEach(func() {
+			Expect(s.Close()).To(Succeed())
+		})
+
+		st := time.Now().Add(-time.Hour).Truncate(100 * time.Second)
+		put := func(name string) {
+			k, err := segment.ParseKey(name)
+			Expect(err).ToNot(HaveOccurred())
+			for i := 0; i < 3; i++ {
+				t := tree.New()
+				t.Insert([]byte("a;b"), uint64(1))
+				Expect(s.Put(&PutInput{
+					StartTime: st.Add(time.Duration(i) * 10 * time.Second),
+					EndTime:   st.Add(time.Duration(i+1) * 10 * time.Second),
+					Key:       k,
+					Val:       t,
+				})).To(Succeed())
+			}
+		}
+
+		samples := func(name string, d time.Duration) uint64 {
+			k, err := segment.ParseKey(name)
+			Expect(err).ToNot(HaveOccurred())
+			o, err := s.Get(&GetInput{StartTime: st, EndTime: st.Add(d), Key: k})
+			Expect(err).ToNot(HaveOccurred())
+			if o == nil {
+				return 0
+			}
+			return o.Tree.Samples()
+		}
+
+		It("deletes data matching the query within the time range", func() {
+			put("app.cpu{user_id=1}")
+			put("app.cpu{user_id=2}")
+
+			q, err := flameql.ParseQuery(`app.cpu{user_id="1"}`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.DeleteRange(&DeleteRangeInput{
+				Query:     q,
+				StartTime: st.Add(10 * time.Second),
+				EndTime:   st.Add(20 * time.Second),
+			})).To(Succeed())
+
+			// Pending tombstones are persisted.
+			s.tombstones.list = nil
+			Expect(s.loadTombstones()).To(Succeed())
+			Expect(s.tombstones.list).To(HaveLen(1))
+
+			// Before the tombstone is applied, data overlapping the
+			// time range is hidden.
+			Expect(samples("app.cpu{user_id=1}", 30*time.Second)).To(Equal(uint64(2)))
+			Expect(samples("app.cpu{user_id=1}", 100*time.Second)).To(BeZero())
+			Expect(samples("app.cpu{user_id=2}", 100*time.Second)).To(Equal(uint64(3)))
+
+			s.tombstonesTask()
+			Expect(s.tombstones.list).To(BeEmpty())
+			k, _ := segment.ParseKey("app.cpu{user_id=1}")
+			_, ok := s.trees.Lookup(k.TreeKey(0, st.Add(10*time.Second)))
+			Expect(ok).To(BeFalse())
+			Expect(samples("app.cpu{user_id=1}", 30*time.Second)).To(Equal(uint64(2)))
+			Expect(samples("app.cpu{user_id=1}", 100*time.Second)).To(Equal(uint64(2)))
+			Expect(samples("app.cpu{user_id=2}", 100*time.Second)).To(Equal(uint64(3)))
+
+			Expect(s.loadTombstones()).To(Succeed())
+			Expect(s.tombstones.list).To(BeEmpty())
+		})
+
+		It("removes series without remaining data", func() {
+			put("app.cpu{user_id=1}")
+			put("app.cpu{user_id=2}")
+
+			q, err := flameql.ParseQuery(`app.cpu{user_id="1"}`)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(s.DeleteRange(&DeleteRangeInput{
+				Query:     q,
+				StartTime: st.Add(-time.Hour),
+				EndTime:   st.Add(time.Hour),
+			})).To(Succeed())
+			s.tombstonesTask()
+
+			values := make([]string, 0)
+			s.GetValues("user_id", func(v string) bool {
+				values = append(values, v)
+				return true
+			})
+			Expect(values).To(Equal([]string{"2"}))
+		})
+	})
+})
@@ -0,0 +1,172 @@
+package tree
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// CallGraph is a directed graph of function calls: nodes are functions,
+// and edges connect callers to callees.
+type CallGraph struct {
+	// Total is the number of samples of the tree.
+	Total uint64          `json:"total"`
+	Nodes []FunctionStats `json:"nodes"`
+	Edges []CallGraphEdge `json:"edges"`
+}
+
+type CallGraphEdge struct {
+	// From and To are indices of the caller and the callee in the nodes
+	// array.
+	From int `json:"from"`
+	To   int `json:"to"`
+	// Weight is the time spent in the callee called by the caller.
+	Weight uint64 `json:"weight"`
+}
+
+type callGraphEdgeKey struct{ from, to string }
+
+// CallGraph returns the call graph of the tree, only maxNodes nodes with
+// the largest total are included, as well as edges connecting them.
+// Like in Functions, recursive calls are accounted only once, for both
+// nodes and edges.
+func (t *Tree) CallGraph(maxNodes int) *CallGraph {
+	functions := t.Functions()
+
+	t.RLock()
+	g := CallGraph{
+		Total: t.root.Total,
+		Nodes: make([]FunctionStats, 0, len(functions)),
+		Edges: []CallGraphEdge{},
+	}
+	type frame struct {
+		node   *treeNode
+		parent *treeNode
+		exit   bool
+	}
+	weights := make(map[callGraphEdgeKey]uint64)
+	onStack := make(map[callGraphEdgeKey]int)
+	var frames []frame
+	for _, c := range t.root.ChildrenNodes {
+		frames = append(frames, frame{node: c})
+	}
+	for len(frames) > 0 {
+		f := frames[len(frames)-1]
+		frames = frames[:len(frames)-1]
+		if f.parent != nil {
+			k := callGraphEdgeKey{from: string(f.parent.Name), to: string(f.node.Name)}
+			if f.exit {
+				onStack[k]--
+				continue
+			}
+			if onStack[k] == 0 {
+				weights[k] += f.node.Total
+			}
+			onStack[k]++
+			frames = append(frames, frame{node: f.node, parent: f.parent, exit: true})
+		}
+		for _, c := range f.node.ChildrenNodes {
+			frames = append(frames, frame{node: c, parent: f.node})
+		}
+	}
+	t.RUnlock()
+
+	for _, f := range functions {
+		g.Nodes = append(g.Nodes, *f)
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool {
+		if g.Nodes[i].Total != g.Nodes[j].Total {
+			return g.Nodes[i].Total > g.Nodes[j].Total
+		}
+		return g.Nodes[i].Name < g.Nodes[j].Name
+	})
+	if maxNodes > 0 && len(g.Nodes) > maxNodes {
+		g.Nodes = g.Nodes[:maxNodes]
+	}
+	index := make(map[string]int, len(g.Nodes))
+	for i, n := range g.Nodes {
+		index[n.Name] = i
+	}
+	for k, w := range weights {
+		from, ok := index[k.from]
+		if !ok {
+			continue
+		}
+		to, ok := index[k.to]
+		if !ok {
+			continue
+		}
+		g.Edges = append(g.Edges, CallGraphEdge{From: from, To: to, Weight: w})
+	}
+	sort.Slice(g.Edges, func(i, j int) bool {
+		a, b := g.Edges[i], g.Edges[j]
+		if a.Weight != b.Weight {
+			return a.Weight > b.Weight
+		}
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+	return &g
+}
+
+// WriteDot writes the call graph in the DOT format, similar to the one
+// of "go tool pprof -dot": font size of a node reflects its self time,
+// width of an edge reflects its weight.
+func (g *CallGraph) WriteDot(w io.Writer, title string) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "digraph %s {\n", dotQuote(title))
+	fmt.Fprintf(bw, "node [style=filled fillcolor=\"#f8f8f8\"]\n")
+	fmt.Fprintf(bw, "subgraph cluster_L { \"%s\" [shape=box fontsize=16 label=%s] }\n",
+		dotEscape(title), dotQuote(fmt.Sprintf("%s\nTotal: %d\nShowing %d nodes", title, g.Total, len(g.Nodes))))
+	for i, n := range g.Nodes {
+		label := fmt.Sprintf("%s\n%d (%s)", n.Name, n.Self, g.percent(n.Self))
+		if n.Total != n.Self {
+			label += fmt.Sprintf("\nof %d (%s)", n.Total, g.percent(n.Total))
+		}
+		fmt.Fprintf(bw, "N%d [label=%s fontsize=%d shape=box tooltip=%s]\n",
+			i+1, dotQuote(label), g.fontSize(n.Self), dotQuote(fmt.Sprintf("%s (%d)", n.Name, n.Total)))
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(bw, "N%d -> N%d [label=\" %d\" weight=%d penwidth=%s]\n",
+			e.From+1, e.To+1, e.Weight, g.edgeWeight(e.Weight), g.penWidth(e.Weight))
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+func (g *CallGraph) ratio(v uint64) float64 {
+	if g.Total == 0 {
+		return 0
+	}
+	return float64(v) / float64(g.Total)
+}
+
+func (g *CallGraph) percent(v uint64) string {
+	return fmt.Sprintf("%.2f%%", g.ratio(v)*100)
+}
+
+func (g *CallGraph) fontSize(self uint64) int {
+	return 8 + int(42*g.ratio(self))
+}
+
+func (g *CallGraph) edgeWeight(v uint64) int {
+	return 1 + int(99*g.ratio(v))
+}
+
+func (g *CallGraph) penWidth(v uint64) string {
+	return fmt.Sprintf("%.2f", 1+4*g.ratio(v))
+}
+
+func dotEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
+
+func dotQuote(s string) string {
+	return `"` + dotEscape(s) + `"`
+}
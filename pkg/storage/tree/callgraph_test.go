@@ -0,0 +1,56 @@
+package tree
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CallGraph", func() {
+	var tree *Tree
+
+	BeforeEach(func() {
+		tree = New()
+		tree.Insert([]byte("a;b;c"), uint64(1))
+		tree.Insert([]byte("a;c"), uint64(2))
+		tree.Insert([]byte("a;b;a;b"), uint64(4))
+		tree.Insert([]byte("a"), uint64(8))
+	})
+
+	It("builds the call graph of the tree", func() {
+		g := tree.CallGraph(0)
+		Expect(g.Total).To(Equal(uint64(15)))
+		Expect(g.Nodes).To(Equal([]FunctionStats{
+			{Name: "a", Self: 8, Total: 15},
+			{Name: "b", Self: 4, Total: 5},
+			{Name: "c", Self: 3, Total: 3},
+		}))
+		Expect(g.Edges).To(Equal([]CallGraphEdge{
+			{From: 0, To: 1, Weight: 5},
+			{From: 1, To: 0, Weight: 4},
+			{From: 0, To: 2, Weight: 2},
+			{From: 1, To: 2, Weight: 1},
+		}))
+	})
+
+	It("limits the number of nodes", func() {
+		g := tree.CallGraph(2)
+		Expect(g.Nodes).To(HaveLen(2))
+		Expect(g.Edges).To(Equal([]CallGraphEdge{
+			{From: 0, To: 1, Weight: 5},
+			{From: 1, To: 0, Weight: 4},
+		}))
+	})
+
+	It("writes the call graph in the DOT format", func() {
+		var buf bytes.Buffer
+		Expect(tree.CallGraph(0).WriteDot(&buf, `app "cpu"`)).To(Succeed())
+		s := buf.String()
+		Expect(s).To(HavePrefix(`digraph "app \"cpu\"" {`))
+		Expect(s).To(ContainSubstring(`N1 [label="a\n8 (53.33%)\nof 15 (100.00%)"`))
+		Expect(s).To(ContainSubstring(`N3 [label="c\n3 (20.00%)" `))
+		Expect(s).To(ContainSubstring(`N1 -> N2 [label=" 5"`))
+		Expect(s).To(HaveSuffix("}\n"))
+	})
+})
@@ -0,0 +1,54 @@
+package tree
+
+import "regexp"
+
+// Filter returns a new tree built from the stacks of the tree: if focus is
+// not nil, only stacks containing a frame matching it are kept; if hide is
+// not nil, matching frames are removed from stacks, and their time is
+// attributed to the callers. Either of the expressions can be nil.
+func (t *Tree) Filter(focus, hide *regexp.Regexp) *Tree {
+	t.RLock()
+	defer t.RUnlock()
+
+	res := New()
+	t.IterateStacks(func(_ string, self uint64, stack []string) {
+		if focus != nil && !matchesAny(focus, stack) {
+			return
+		}
+		// Stacks are iterated leaf first.
+		s := make([]string, 0, len(stack))
+		for i := len(stack) - 1; i >= 0; i-- {
+			if hide == nil || !hide.MatchString(stack[i]) {
+				s = append(s, stack[i])
+			}
+		}
+		res.InsertStackString(s, self)
+	})
+	return res
+}
+
+func matchesAny(r *regexp.Regexp, s []string) bool {
+	for _, x := range s {
+		if r.MatchString(x) {
+			return true
+		}
+	}
+	return false
+}
+
+// MapNames returns a new tree with frame names replaced with the ones
+// returned by the function, nodes of the same name are merged.
+func (t *Tree) MapNames(f func(string) string) *Tree {
+	t.RLock()
+	defer t.RUnlock()
+
+	res := New()
+	t.IterateStacks(func(_ string, self uint64, stack []string) {
+		s := make([]string, len(stack))
+		for i, name := range stack {
+			s[len(stack)-1-i] = f(name)
+		}
+		res.InsertStackString(s, self)
+	})
+	return res
+}
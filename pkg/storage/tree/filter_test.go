@@ -0,0 +1,57 @@
+package tree
+
+import (
+	"regexp"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Filter", func() {
+	var tree *Tree
+
+	BeforeEach(func() {
+		tree = New()
+		tree.Insert([]byte("main;runtime.gc;mark"), uint64(1))
+		tree.Insert([]byte("main;handler;json.Marshal"), uint64(2))
+		tree.Insert([]byte("main;handler"), uint64(4))
+		tree.Insert([]byte("main;worker"), uint64(8))
+	})
+
+	It("keeps stacks containing focused frames", func() {
+		t := tree.Filter(regexp.MustCompile(`^(handler|mark)$`), nil)
+		Expect(t.String()).To(Equal(`main;handler 4
+main;handler;json.Marshal 2
+main;runtime.gc;mark 1
+`))
+		Expect(t.Samples()).To(Equal(uint64(7)))
+	})
+
+	It("removes hidden frames", func() {
+		t := tree.Filter(nil, regexp.MustCompile(`^runtime\.|^handler$`))
+		Expect(t.String()).To(Equal(`main 4
+main;json.Marshal 2
+main;mark 1
+main;worker 8
+`))
+		Expect(t.Samples()).To(Equal(uint64(15)))
+	})
+
+	It("does not modify the tree", func() {
+		tree.Filter(regexp.MustCompile(`worker`), regexp.MustCompile(`main`))
+		Expect(tree.Samples()).To(Equal(uint64(15)))
+	})
+
+	It("maps frame names", func() {
+		t := tree.MapNames(func(name string) string {
+			if name == "worker" {
+				return "handler"
+			}
+			return name
+		})
+		Expect(t.String()).To(Equal(`main;handler 12
+main;handler;json.Marshal 2
+main;runtime.gc;mark 1
+`))
+	})
+})
@@ -0,0 +1,52 @@
+package tree
+
+// FunctionStats describes the time spent in a function.
+type FunctionStats struct {
+	Name string `json:"name"`
+	// Self is the time spent in the function itself.
+	Self uint64 `json:"self"`
+	// Total is the time spent in the function and its callees.
+	Total uint64 `json:"total"`
+}
+
+// Functions returns stats of every function of the tree. The total time
+// of recursive functions only accounts for the outermost calls.
+func (t *Tree) Functions() map[string]*FunctionStats {
+	t.RLock()
+	defer t.RUnlock()
+
+	type frame struct {
+		node *treeNode
+		exit bool
+	}
+	stats := make(map[string]*FunctionStats)
+	onStack := make(map[string]int)
+	var frames []frame
+	for i := len(t.root.ChildrenNodes) - 1; i >= 0; i-- {
+		frames = append(frames, frame{node: t.root.ChildrenNodes[i]})
+	}
+	for len(frames) > 0 {
+		f := frames[len(frames)-1]
+		frames = frames[:len(frames)-1]
+		name := string(f.node.Name)
+		if f.exit {
+			onStack[name]--
+			continue
+		}
+		s, ok := stats[name]
+		if !ok {
+			s = &FunctionStats{Name: name}
+			stats[name] = s
+		}
+		s.Self += f.node.Self
+		if onStack[name] == 0 {
+			s.Total += f.node.Total
+		}
+		onStack[name]++
+		frames = append(frames, frame{node: f.node, exit: true})
+		for _, c := range f.node.ChildrenNodes {
+			frames = append(frames, frame{node: c})
+		}
+	}
+	return stats
+}
@@ -0,0 +1,22 @@
+package tree
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Functions", func() {
+	It("calculates self and total time of functions", func() {
+		tree := New()
+		tree.Insert([]byte("a;b;c"), uint64(1))
+		tree.Insert([]byte("a;c"), uint64(2))
+		tree.Insert([]byte("a;b;a;b"), uint64(4))
+		tree.Insert([]byte("a"), uint64(8))
+
+		Expect(tree.Functions()).To(Equal(map[string]*FunctionStats{
+			"a": {Name: "a", Self: 8, Total: 15},
+			"b": {Name: "b", Self: 4, Total: 5},
+			"c": {Name: "c", Self: 3, Total: 3},
+		}))
+	})
+})
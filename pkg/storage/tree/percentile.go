@@ -0,0 +1,86 @@
+package tree
+
+import (
+	"math"
+	"sort"
+)
+
+// Percentile returns a tree which self value of every node is the p-th
+// percentile (0 < p <= 1, nearest-rank method) of self values of the node
+// in the trees, multiplied by the corresponding scale factor; nodes missing
+// in a tree are accounted as zeros. Totals are sums of self values of the
+// nodes and their descendants.
+func Percentile(trees []*Tree, scales []float64, p float64) *Tree {
+	res := New()
+	if len(trees) == 0 {
+		return res
+	}
+	roots := make([]*treeNode, len(trees))
+	for i, t := range trees {
+		t.RLock()
+		defer t.RUnlock()
+		roots[i] = t.root
+	}
+	// Index of the percentile value in the sorted values.
+	k := int(math.Ceil(p*float64(len(trees)))) - 1
+	if k < 0 {
+		k = 0
+	}
+	pc := percentileCalc{scales: scales, k: k, values: make([]float64, len(trees))}
+	res.root = pc.node(nil, roots)
+	return res
+}
+
+type percentileCalc struct {
+	scales []float64
+	k      int
+	values []float64
+}
+
+func (pc *percentileCalc) node(name []byte, nodes []*treeNode) *treeNode {
+	n := &treeNode{Name: name}
+	for i, x := range nodes {
+		pc.values[i] = 0
+		if x != nil {
+			pc.values[i] = float64(x.Self) * pc.scales[i]
+		}
+	}
+	sort.Float64s(pc.values)
+	n.Self = uint64(math.Round(pc.values[pc.k]))
+	n.Total = n.Self
+
+	children := make(map[string][]*treeNode)
+	counts := make(map[string]int)
+	for i, x := range nodes {
+		if x == nil {
+			continue
+		}
+		for _, c := range x.ChildrenNodes {
+			s, ok := children[string(c.Name)]
+			if !ok {
+				s = make([]*treeNode, len(nodes))
+				children[string(c.Name)] = s
+			}
+			s[i] = c
+			counts[string(c.Name)]++
+		}
+	}
+	names := make([]string, 0, len(children))
+	for name, c := range counts {
+		// The percentile of the node and its descendants is zero,
+		// if it is missing in more than k trees.
+		if len(nodes)-c <= pc.k {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := pc.node([]byte(name), children[name])
+		if c.Total == 0 {
+			continue
+		}
+		n.ChildrenNodes = append(n.ChildrenNodes, c)
+		n.Total += c.Total
+	}
+	return n
+}
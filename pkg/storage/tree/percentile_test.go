@@ -0,0 +1,39 @@
+package tree
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Percentile", func() {
+	It("calculates percentiles of node values", func() {
+		var trees []*Tree
+		for _, v := range []uint64{1, 2, 3, 4} {
+			t := New()
+			t.Insert([]byte("a;b"), v)
+			t.Insert([]byte("a;c"), 10*v)
+			trees = append(trees, t)
+		}
+		// A runaway instance.
+		t := New()
+		t.Insert([]byte("a;b"), 1000)
+		t.Insert([]byte("a;d"), 1000)
+		trees = append(trees, t)
+		scales := []float64{1, 1, 1, 1, 1}
+
+		median := Percentile(trees, scales, 0.5)
+		Expect(median.String()).To(Equal("a;b 3\na;c 20\n"))
+		Expect(median.Samples()).To(Equal(uint64(23)))
+
+		p90 := Percentile(trees, scales, 0.9)
+		Expect(p90.String()).To(Equal("a;b 1000\na;c 40\na;d 1000\n"))
+	})
+
+	It("scales values", func() {
+		a, b := New(), New()
+		a.Insert([]byte("a"), 100)
+		b.Insert([]byte("a"), 10)
+		Expect(Percentile([]*Tree{a, b}, []float64{0.1, 1}, 1).String()).To(Equal("a 10\n"))
+		Expect(Percentile(nil, nil, 0.5).Samples()).To(BeZero())
+	})
+})
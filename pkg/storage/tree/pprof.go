@@ -56,6 +56,10 @@ type PprofMetadata struct {
 	Unit      string
 	StartTime time.Time
 	Duration  time.Duration
+	// SampleRate of CPU profiles (in samples per second) sets
+	// the sampling period of the profile, so that pprof can
+	// display samples as time.
+	SampleRate uint32
 }
 
 func (t *Tree) Pprof(metadata *PprofMetadata) *Profile {
@@ -74,6 +78,10 @@ func (t *Tree) Pprof(metadata *PprofMetadata) *Profile {
 	p.profile.SampleType = []*ValueType{{Type: p.newString(metadata.Type), Unit: p.newString(metadata.Unit)}}
 	p.profile.TimeNanos = metadata.StartTime.UnixNano()
 	p.profile.DurationNanos = metadata.Duration.Nanoseconds()
+	if metadata.Unit == "samples" && metadata.SampleRate > 0 {
+		p.profile.PeriodType = &ValueType{Type: p.newString("cpu"), Unit: p.newString("nanoseconds")}
+		p.profile.Period = time.Second.Nanoseconds() / int64(metadata.SampleRate)
+	}
 	t.IterateStacks(func(name string, self uint64, stack []string) {
 		value := []int64{int64(self)}
 		loc := []uint64{}
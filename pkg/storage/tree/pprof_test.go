@@ -80,6 +80,13 @@ var _ = Describe("tree", func() {
 				Expect(_type).To(Equal("cpu"))
 				Expect(unit).To(Equal("samples"))
 			})
+			It("Should set the sampling period of CPU profiles", func() {
+				Expect(profile.PeriodType).To(BeNil())
+				profile = tree.Pprof(&PprofMetadata{Type: "cpu", Unit: "samples", SampleRate: 100})
+				Expect(profile.StringTable[profile.PeriodType.Type]).To(Equal("cpu"))
+				Expect(profile.StringTable[profile.PeriodType.Unit]).To(Equal("nanoseconds"))
+				Expect(profile.Period).To(Equal(int64(10000000)))
+			})
 		})
 		Describe("Function", func() {
 			It("Should build correctly", func() {
@@ -0,0 +1,56 @@
+package tree
+
+// Prune returns a copy of the tree without nodes deeper than maxDepth:
+// the time spent in them is still accounted in totals of their ancestors,
+// so the nodes which total exceeds the sum of self and children totals
+// can be explored further with Subtree.
+func (t *Tree) Prune(maxDepth int) *Tree {
+	t.RLock()
+	defer t.RUnlock()
+	return &Tree{root: pruneNode(t.root, maxDepth)}
+}
+
+func pruneNode(n *treeNode, depth int) *treeNode {
+	c := &treeNode{
+		Name:  n.Name,
+		Total: n.Total,
+		Self:  n.Self,
+	}
+	if depth > 0 {
+		c.ChildrenNodes = make([]*treeNode, len(n.ChildrenNodes))
+		for i, child := range n.ChildrenNodes {
+			c.ChildrenNodes[i] = pruneNode(child, depth-1)
+		}
+	}
+	return c
+}
+
+// Subtree returns a new tree consisting of the node at the given call
+// stack and its descendants: the node is the only child of the root.
+// The tree is empty if there is no such node.
+func (t *Tree) Subtree(stack []string, maxDepth int) *Tree {
+	t.RLock()
+	defer t.RUnlock()
+
+	res := New()
+	n := t.root
+	for _, name := range stack {
+		var next *treeNode
+		for _, c := range n.ChildrenNodes {
+			if string(c.Name) == name {
+				next = c
+				break
+			}
+		}
+		if next == nil {
+			return res
+		}
+		n = next
+	}
+	if n == t.root {
+		return &Tree{root: pruneNode(n, maxDepth)}
+	}
+	res.root.Total = n.Total
+	res.root.ChildrenNodes = []*treeNode{pruneNode(n, maxDepth)}
+	return res
+}
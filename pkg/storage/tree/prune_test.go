@@ -0,0 +1,35 @@
+package tree
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Prune", func() {
+	var tree *Tree
+
+	BeforeEach(func() {
+		tree = New()
+		tree.Insert([]byte("a;b;c;d"), uint64(1))
+		tree.Insert([]byte("a;b;e"), uint64(2))
+		tree.Insert([]byte("a"), uint64(4))
+	})
+
+	It("removes nodes deeper than the limit", func() {
+		t := tree.Prune(2)
+		Expect(t.Samples()).To(Equal(uint64(7)))
+		Expect(t.String()).To(Equal("a 4\n"))
+		Expect(t.root.ChildrenNodes[0].ChildrenNodes[0].Total).To(Equal(uint64(3)))
+		Expect(tree.String()).To(Equal("a 4\na;b;e 2\na;b;c;d 1\n"))
+	})
+
+	It("returns the subtree of the node", func() {
+		t := tree.Subtree([]string{"a", "b"}, 1)
+		Expect(t.Samples()).To(Equal(uint64(3)))
+		Expect(t.String()).To(Equal("b;e 2\n"))
+		Expect(t.root.ChildrenNodes[0].ChildrenNodes[0].Total).To(Equal(uint64(1)))
+
+		Expect(tree.Subtree([]string{"a", "x"}, 1).Samples()).To(BeZero())
+		Expect(tree.Subtree(nil, 1).Samples()).To(Equal(uint64(7)))
+	})
+})
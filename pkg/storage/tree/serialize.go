@@ -10,8 +10,9 @@ import (
 	"github.com/pyroscope-io/pyroscope/pkg/util/varint"
 )
 
-// serialization format version. it's not very useful right now, but it will be in the future
-const currentVersion = 1
+// serialization format version of Serialize and SerializeTruncate,
+// see SerializeColumns for the column-oriented format
+const currentVersion = FormatRows
 
 func (t *Tree) Serialize(d *dict.Dict, maxNodes int, w io.Writer) error {
 	t.RLock()
@@ -153,10 +154,13 @@ func Deserialize(d *dict.Dict, r io.Reader) (*Tree, error) {
 	br := bufio.NewReader(r) // TODO if it's already a bytereader skip
 
 	// reads serialization format version, see comment at the top
-	_, err := varint.Read(br)
+	version, err := varint.Read(br)
 	if err != nil {
 		return nil, err
 	}
+	if version == FormatColumns {
+		return deserializeColumns(d, br)
+	}
 
 	parents := []*parentNode{{t.root, nil}}
 	j := 0
@@ -0,0 +1,198 @@
+package tree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/dict"
+	"github.com/pyroscope-io/pyroscope/pkg/util/varint"
+)
+
+// Serialization format versions.
+const (
+	// FormatRows is the original format: nodes are written one after
+	// another in depth-first order, each as label link, self value and
+	// number of children.
+	FormatRows = 1
+	// FormatColumns stores the same nodes in the same order, but as
+	// three parallel columns: label links, self values and numbers of
+	// children. Columns are prefixed with their sizes, which allows
+	// to decode them in a single pass over flat arrays.
+	FormatColumns = 2
+)
+
+var errInvalidFormat = errors.New("invalid tree serialization format")
+
+// SerializeColumns is like SerializeTruncate, but writes
+// the tree in the column-oriented format (FormatColumns).
+func (t *Tree) SerializeColumns(d *dict.Dict, maxNodes int, w io.Writer) error {
+	t.Lock()
+	defer t.Unlock()
+
+	var names, values, children bytes.Buffer
+	vw := varint.NewWriter()
+	minVal := t.minValue(maxNodes)
+	nodes := make([]*treeNode, 1, 128)
+	nodes[0] = t.root
+	var n uint64
+	for len(nodes) > 0 {
+		tn := nodes[0]
+		nodes = nodes[1:]
+		n++
+
+		labelKey := d.Put([]byte(tn.Name))
+		vw.Write(&names, uint64(len(labelKey)))
+		names.Write(labelKey)
+		vw.Write(&values, tn.Self)
+
+		cNodes := tn.ChildrenNodes
+		tn.ChildrenNodes = tn.ChildrenNodes[:0]
+		for _, cn := range cNodes {
+			if cn.Total >= minVal {
+				tn.ChildrenNodes = append(tn.ChildrenNodes, cn)
+			}
+		}
+		if len(tn.ChildrenNodes) > 0 {
+			nodes = append(tn.ChildrenNodes, nodes...)
+		} else {
+			tn.ChildrenNodes = nil
+		}
+		vw.Write(&children, uint64(len(tn.ChildrenNodes)))
+	}
+
+	for _, v := range []uint64{FormatColumns, n, uint64(names.Len()), uint64(values.Len()), uint64(children.Len())} {
+		if _, err := vw.Write(w, v); err != nil {
+			return err
+		}
+	}
+	for _, c := range []*bytes.Buffer{&names, &values, &children} {
+		if _, err := c.WriteTo(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deserializeColumns reads a tree in FormatColumns,
+// the version is expected to be already read.
+func deserializeColumns(d *dict.Dict, br *bufio.Reader) (*Tree, error) {
+	var hdr [4]uint64
+	for i := range hdr {
+		v, err := varint.Read(br)
+		if err != nil {
+			return nil, err
+		}
+		hdr[i] = v
+	}
+	n := int(hdr[0])
+	if n == 0 {
+		return nil, errInvalidFormat
+	}
+	buf := make([]byte, hdr[1]+hdr[2]+hdr[3])
+	if _, err := io.ReadFull(br, buf); err != nil {
+		return nil, err
+	}
+	names := bytes.NewReader(buf[:hdr[1]])
+	values := bytes.NewReader(buf[hdr[1] : hdr[1]+hdr[2]])
+	children := bytes.NewReader(buf[hdr[1]+hdr[2]:])
+
+	// Nodes are in depth-first order, therefore a parent always precedes
+	// its children: the stack holds the nodes waiting for their children.
+	slab := make([]treeNode, n)
+	nodes := make([]*treeNode, n)
+	remaining := make([]uint64, n)
+	stack := make([]int, 0, 32)
+	var nameBuf bytes.Buffer
+	for i := 0; i < n; i++ {
+		labelLen, err := varint.Read(names)
+		if err != nil {
+			return nil, err
+		}
+		if labelLen > uint64(names.Len()) {
+			return nil, errInvalidFormat
+		}
+		label := make([]byte, labelLen)
+		_, _ = names.Read(label)
+		nameBuf.Reset()
+		if !d.GetValue(label, &nameBuf) {
+			nameBuf.Reset()
+			nameBuf.WriteString("label not found " + base64.URLEncoding.EncodeToString(label))
+		}
+		self, err := varint.Read(values)
+		if err != nil {
+			return nil, err
+		}
+		if remaining[i], err = varint.Read(children); err != nil {
+			return nil, err
+		}
+
+		tn := &slab[i]
+		tn.Name = append([]byte(nil), nameBuf.Bytes()...)
+		if i > 0 {
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("%w: node %d has no parent", errInvalidFormat, i)
+			}
+			p := stack[len(stack)-1]
+			if remaining[p]--; remaining[p] == 0 {
+				stack = stack[:len(stack)-1]
+			}
+			tn = appendChild(nodes[p], tn)
+		}
+		tn.Self += self
+		nodes[i] = tn
+		if remaining[i] > 0 {
+			stack = append(stack, i)
+		}
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("%w: %d nodes are missing children", errInvalidFormat, len(stack))
+	}
+
+	t := New()
+	t.root = nodes[0]
+	t.root.updateTotals()
+	return t, nil
+}
+
+// appendChild adds the node to the parent children keeping them sorted
+// by name, and returns the child. Children are serialized in order, so
+// normally the node is just appended; otherwise (e.g., names of missing
+// labels may collide), it is inserted or merged with the existing one.
+func appendChild(parent, tn *treeNode) *treeNode {
+	c := parent.ChildrenNodes
+	if len(c) == 0 || bytes.Compare(c[len(c)-1].Name, tn.Name) < 0 {
+		parent.ChildrenNodes = append(c, tn)
+		return tn
+	}
+	return parent.insert(tn.Name)
+}
+
+// updateTotals sets the total value of each node
+// of the subtree, based on the self values.
+func (tn *treeNode) updateTotals() {
+	type frame struct {
+		node *treeNode
+		next int
+	}
+	stack := []frame{{node: tn}}
+	for len(stack) > 0 {
+		f := &stack[len(stack)-1]
+		if f.next == 0 {
+			f.node.Total = f.node.Self
+		}
+		if f.next < len(f.node.ChildrenNodes) {
+			f.next++
+			stack = append(stack, frame{node: f.node.ChildrenNodes[f.next-1]})
+			continue
+		}
+		stack = stack[:len(stack)-1]
+		if len(stack) > 0 {
+			p := stack[len(stack)-1].node
+			p.Total += f.node.Total
+		}
+	}
+}
@@ -58,4 +58,48 @@ var _ = Describe("tree", func() {
 			Expect(string(t.root.ChildrenNodes[0].ChildrenNodes[1].Name)).To(Equal("label not found AgE="))
 		})
 	})
+
+	Describe("SerializeColumns", func() {
+		newTree := func() *Tree {
+			t := New()
+			t.Insert([]byte("a;b"), uint64(1))
+			t.Insert([]byte("a;c"), uint64(2))
+			t.Insert([]byte("a;c;d"), uint64(3))
+			t.Insert([]byte("e"), uint64(4))
+			t.Insert([]byte("a"), uint64(5))
+			return t
+		}
+
+		It("round-trips a tree", func() {
+			d := dict.New()
+			var buf bytes.Buffer
+			Expect(newTree().SerializeColumns(d, 1024, &buf)).To(Succeed())
+			t, err := Deserialize(d, &buf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(t.String()).To(Equal(newTree().String()))
+			Expect(t.Samples()).To(Equal(uint64(15)))
+			Expect(t.root.ChildrenNodes[0].Total).To(Equal(uint64(11)))
+		})
+
+		It("is read the same way as the row-oriented format", func() {
+			d := dict.New()
+			var rows, cols bytes.Buffer
+			Expect(newTree().SerializeTruncate(d, 2, &rows)).To(Succeed())
+			Expect(newTree().SerializeColumns(d, 2, &cols)).To(Succeed())
+			a, err := Deserialize(d, &rows)
+			Expect(err).ToNot(HaveOccurred())
+			b, err := Deserialize(d, &cols)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b.String()).To(Equal(a.String()))
+			Expect(b.Samples()).To(Equal(a.Samples()))
+		})
+
+		It("rejects truncated data", func() {
+			d := dict.New()
+			var buf bytes.Buffer
+			Expect(newTree().SerializeColumns(d, 1024, &buf)).To(Succeed())
+			_, err := Deserialize(d, bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+			Expect(err).To(HaveOccurred())
+		})
+	})
 })
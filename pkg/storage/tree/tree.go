@@ -310,6 +310,25 @@ func (t *Tree) iterateWithTotal(cb func(total uint64) bool) {
 	}
 }
 
+// treeNodeSize is the size of treeNode struct: three slice headers
+// and two counters.
+const treeNodeSize = 3*24 + 2*8
+
+// EstimatedSize returns an estimate of the memory occupied by the tree.
+func (t *Tree) EstimatedSize() int64 {
+	t.RLock()
+	defer t.RUnlock()
+	var size int64
+	nodes := []*treeNode{t.root}
+	for len(nodes) > 0 {
+		node := nodes[len(nodes)-1]
+		nodes = nodes[:len(nodes)-1]
+		size += treeNodeSize + int64(cap(node.Name)) + int64(cap(node.ChildrenNodes))*8
+		nodes = append(nodes, node.ChildrenNodes...)
+	}
+	return size
+}
+
 func (t *Tree) Samples() uint64 {
 	return t.root.Total
 }
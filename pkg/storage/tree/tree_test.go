@@ -30,6 +30,21 @@ var _ = Describe("tree package", func() {
 		})
 	})
 
+	Context("EstimatedSize", func() {
+		It("accounts all the nodes", func() {
+			tree := New()
+			empty := tree.EstimatedSize()
+			Expect(empty).To(BeNumerically(">", 0))
+			tree.Insert([]byte("a;b"), uint64(1))
+			size := tree.EstimatedSize()
+			Expect(size).To(BeNumerically(">=", empty+2*treeNodeSize+2))
+			tree.Insert([]byte("a;b"), uint64(1))
+			Expect(tree.EstimatedSize()).To(Equal(size))
+			tree.Insert([]byte("a;c"), uint64(1))
+			Expect(tree.EstimatedSize()).To(BeNumerically(">", size))
+		})
+	})
+
 	Context("Diff", func() {
 		a := New()
 		a.Insert([]byte("a;b;c"), uint64(100))
@@ -0,0 +1,154 @@
+package tree
+
+import (
+	"sort"
+
+	"github.com/pyroscope-io/pyroscope/pkg/structs/cappedarr"
+)
+
+// MultiDiff is a tree combining several trees, e.g. profiles of the same
+// application in different time ranges: every node holds values of the
+// function call in each of the trees.
+type MultiDiff struct {
+	Names []string `json:"names"`
+	// Nodes are listed in depth-first order, the root node is the first.
+	Nodes []MultiDiffNode `json:"nodes"`
+	// Ticks is the number of samples of every tree.
+	Ticks []uint64 `json:"ticks"`
+}
+
+type MultiDiffNode struct {
+	// Name is the index in the names array.
+	Name int `json:"name"`
+	// Parent is the index of the parent node, -1 for the root.
+	Parent int `json:"parent"`
+	// Total and Self values of the node in every tree.
+	Total []uint64 `json:"total"`
+	Self  []uint64 `json:"self"`
+}
+
+type multiDiffNode struct {
+	name     string
+	total    []uint64
+	self     []uint64
+	children []*multiDiffNode
+	max      uint64
+}
+
+// CombineTrees combines the trees into a MultiDiff. Only nodes which
+// value in any of the trees is among the maxNodes largest are included,
+// others are aggregated into "other" nodes.
+func CombineTrees(trees []*Tree, maxNodes int) *MultiDiff {
+	roots := make([]*treeNode, len(trees))
+	res := MultiDiff{
+		Names: []string{},
+		Nodes: []MultiDiffNode{},
+		Ticks: make([]uint64, len(trees)),
+	}
+	for i, t := range trees {
+		t.RLock()
+		defer t.RUnlock()
+		roots[i] = t.root
+		res.Ticks[i] = t.root.Total
+	}
+
+	root := combineMultiDiffNodes("total", roots)
+	c := cappedarr.New(maxNodes)
+	nodes := []*multiDiffNode{root}
+	for len(nodes) > 0 {
+		n := nodes[0]
+		nodes = nodes[1:]
+		if c.Push(n.max) {
+			nodes = append(n.children, nodes...)
+		}
+	}
+	minVal := c.MinValue()
+
+	names := make(map[string]int)
+	type entry struct {
+		node   *multiDiffNode
+		parent int
+	}
+	entries := []entry{{node: root, parent: -1}}
+	for len(entries) > 0 {
+		e := entries[len(entries)-1]
+		entries = entries[:len(entries)-1]
+		i, ok := names[e.node.name]
+		if !ok {
+			i = len(res.Names)
+			names[e.node.name] = i
+			res.Names = append(res.Names, e.node.name)
+		}
+		parent := len(res.Nodes)
+		res.Nodes = append(res.Nodes, MultiDiffNode{
+			Name:   i,
+			Parent: e.parent,
+			Total:  e.node.total,
+			Self:   e.node.self,
+		})
+		var other *multiDiffNode
+		for _, child := range e.node.children {
+			if child.max >= minVal {
+				continue
+			}
+			if other == nil {
+				other = &multiDiffNode{
+					name:  "other",
+					total: make([]uint64, len(trees)),
+					self:  make([]uint64, len(trees)),
+				}
+			}
+			for k, v := range child.total {
+				other.total[k] += v
+				other.self[k] += v
+			}
+		}
+		// Nodes are pushed in reverse order, so that children are
+		// listed in the order of names, and "other" is the last.
+		if other != nil {
+			entries = append(entries, entry{node: other, parent: parent})
+		}
+		for j := len(e.node.children) - 1; j >= 0; j-- {
+			if child := e.node.children[j]; child.max >= minVal {
+				entries = append(entries, entry{node: child, parent: parent})
+			}
+		}
+	}
+	return &res
+}
+
+// combineMultiDiffNodes combines the nodes of the same call stack in the
+// trees, nodes missing in a tree are nil.
+func combineMultiDiffNodes(name string, nodes []*treeNode) *multiDiffNode {
+	n := multiDiffNode{
+		name:  name,
+		total: make([]uint64, len(nodes)),
+		self:  make([]uint64, len(nodes)),
+	}
+	children := make(map[string][]*treeNode)
+	for i, x := range nodes {
+		if x == nil {
+			continue
+		}
+		n.total[i], n.self[i] = x.Total, x.Self
+		n.max = maxUint64(n.max, x.Total)
+		for _, c := range x.ChildrenNodes {
+			s, ok := children[string(c.Name)]
+			if !ok {
+				s = make([]*treeNode, len(nodes))
+				children[string(c.Name)] = s
+			}
+			s[i] = c
+		}
+	}
+	childNames := make([]string, 0, len(children))
+	for k := range children {
+		childNames = append(childNames, k)
+	}
+	sort.Strings(childNames)
+	n.children = make([]*multiDiffNode, len(childNames))
+	for i, k := range childNames {
+		n.children[i] = combineMultiDiffNodes(k, children[k])
+	}
+	return &n
+}
@@ -0,0 +1,43 @@
+package tree
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CombineTrees", func() {
+	It("combines values of nodes of every tree", func() {
+		t1 := New()
+		t1.Insert([]byte("a;b"), uint64(1))
+		t1.Insert([]byte("a;c"), uint64(2))
+		t2 := New()
+		t2.Insert([]byte("a;c"), uint64(4))
+		t3 := New()
+		t3.Insert([]byte("a;b"), uint64(8))
+		t3.Insert([]byte("d"), uint64(16))
+
+		d := CombineTrees([]*Tree{t1, t2, t3}, 1024)
+		Expect(d.Ticks).To(Equal([]uint64{3, 4, 24}))
+		Expect(d.Names).To(Equal([]string{"total", "a", "b", "c", "d"}))
+		Expect(d.Nodes).To(Equal([]MultiDiffNode{
+			{Name: 0, Parent: -1, Total: []uint64{3, 4, 24}, Self: []uint64{0, 0, 0}},
+			{Name: 1, Parent: 0, Total: []uint64{3, 4, 8}, Self: []uint64{0, 0, 0}},
+			{Name: 2, Parent: 1, Total: []uint64{1, 0, 8}, Self: []uint64{1, 0, 8}},
+			{Name: 3, Parent: 1, Total: []uint64{2, 4, 0}, Self: []uint64{2, 4, 0}},
+			{Name: 4, Parent: 0, Total: []uint64{0, 0, 16}, Self: []uint64{0, 0, 16}},
+		}))
+	})
+
+	It("aggregates small nodes", func() {
+		t1 := New()
+		t1.Insert([]byte("a;b"), uint64(1))
+		t1.Insert([]byte("a;c"), uint64(2))
+		t1.Insert([]byte("a;d"), uint64(20))
+		t2 := New()
+		t2.Insert([]byte("a;b"), uint64(1))
+
+		d := CombineTrees([]*Tree{t1, t2}, 3)
+		Expect(d.Names).To(Equal([]string{"total", "a", "d", "other"}))
+		Expect(d.Nodes[3]).To(Equal(MultiDiffNode{Name: 3, Parent: 1, Total: []uint64{3, 1}, Self: []uint64{3, 1}}))
+	})
+})
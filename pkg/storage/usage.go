@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+)
+
+// AppUsage describes the data stored for an application.
+type AppUsage struct {
+	// Series is the number of series (distinct tag sets).
+	Series int
+	// Bytes is the estimated size of the profiling data written to disk,
+	// counted the same way as for storage quotas.
+	Bytes int64
+}
+
+// AppUsage returns the usage of the storage by the application,
+// if it exists. The call iterates over all the application trees.
+func (s *Storage) AppUsage(appName string) (AppUsage, bool) {
+	d, ok := s.lookupAppDimension(appName)
+	if !ok {
+		return AppUsage{}, false
+	}
+	u := AppUsage{Series: len(d.Keys)}
+	for _, k := range d.Keys {
+		key, err := segment.ParseKey(string(k))
+		if err != nil {
+			continue
+		}
+		u.Bytes += s.segmentTreesSize(key)
+	}
+	return u, true
+}
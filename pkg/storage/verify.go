@@ -0,0 +1,305 @@
+package storage
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/backend"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/dict"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/dimension"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+)
+
+// Kinds of integrity issues.
+const (
+	IssueCorruptDimension  = "corrupt-dimension"
+	IssueCorruptSegment    = "corrupt-segment"
+	IssueCorruptTree       = "corrupt-tree"
+	IssueDanglingDimension = "dangling-dimension-key"
+	IssueOrphanSegment     = "orphan-segment"
+	IssueOrphanTree        = "orphan-tree"
+	IssueMissingTree       = "missing-tree"
+	IssueMissingDictionary = "missing-dictionary"
+)
+
+// maxReportedIssues limits the number of issues included in the report.
+const maxReportedIssues = 1000
+
+// VerifyReport describes the storage integrity issues found by Verify.
+type VerifyReport struct {
+	Dimensions int `json:"dimensions"`
+	Segments   int `json:"segments"`
+	Trees      int `json:"trees"`
+	// Issues lists at most maxReportedIssues issues,
+	// IssuesTotal is the number of issues found.
+	Issues      []VerifyIssue `json:"issues,omitempty"`
+	IssuesTotal int           `json:"issuesTotal"`
+	// Repaired is the number of issues fixed.
+	Repaired int `json:"repaired"`
+}
+
+type VerifyIssue struct {
+	Kind string `json:"kind"`
+	// Key of the item, without the database prefix. For dangling
+	// dimension keys, it's the dimension key, followed by "->" and
+	// the missing segment key.
+	Key   string `json:"key"`
+	Error string `json:"error,omitempty"`
+}
+
+type verifier struct {
+	s      *Storage
+	report VerifyReport
+	issues []VerifyIssue
+
+	// Segment keys referenced by dimensions: all of them,
+	// and the ones referenced by application dimensions.
+	dimensionKeys map[string][]string
+	appSegments   map[string]struct{}
+	// Segments found, and trees referenced by them.
+	segments map[string]struct{}
+	trees    map[string]struct{}
+	dicts    map[string]*dict.Dict
+}
+
+// Verify walks the databases and checks that all the referenced segments,
+// trees, and dictionaries exist and can be decoded, and that there are no
+// unreachable items. If repair is true, corrupt and unreachable items are
+// removed, as well as dangling references to segments; missing trees and
+// dictionaries can't be restored. Note that a removed corrupt tree is
+// reported as missing on the next run, if a segment references it.
+//
+// Ingestion and maintenance tasks are suspended until the check is done.
+func (s *Storage) Verify(repair bool) (*VerifyReport, error) {
+	if repair {
+		if err := s.writable(); err != nil {
+			return nil, err
+		}
+	}
+	s.tasksMutex.Lock()
+	defer s.tasksMutex.Unlock()
+	s.putMutex.Lock()
+	defer s.putMutex.Unlock()
+	s.writeBack()
+
+	v := verifier{
+		s:             s,
+		dimensionKeys: make(map[string][]string),
+		appSegments:   make(map[string]struct{}),
+		segments:      make(map[string]struct{}),
+		trees:         make(map[string]struct{}),
+		dicts:         make(map[string]*dict.Dict),
+	}
+	for _, step := range []func() error{
+		v.verifyDimensions,
+		v.verifySegments,
+		v.verifyTrees,
+	} {
+		if err := step(); err != nil {
+			return nil, err
+		}
+	}
+	v.findDangling()
+	if repair {
+		if err := v.repair(); err != nil {
+			return &v.report, err
+		}
+	}
+	for i, x := range v.issues {
+		if i == maxReportedIssues {
+			break
+		}
+		v.report.Issues = append(v.report.Issues, x)
+	}
+	v.report.IssuesTotal = len(v.issues)
+	return &v.report, nil
+}
+
+func (v *verifier) add(kind, key string, err error) {
+	x := VerifyIssue{Kind: kind, Key: key}
+	if err != nil {
+		x.Error = err.Error()
+	}
+	v.issues = append(v.issues, x)
+}
+
+func (v *verifier) verifyDimensions() error {
+	return iterateDB(v.s.dimensions, dimensionPrefix, func(k string, b []byte) {
+		v.report.Dimensions++
+		d, err := dimension.FromBytes(b)
+		if err != nil {
+			v.add(IssueCorruptDimension, k, err)
+			return
+		}
+		keys := make([]string, len(d.Keys))
+		for i, sk := range d.Keys {
+			keys[i] = string(sk)
+		}
+		v.dimensionKeys[k] = keys
+		if strings.HasPrefix(k, "__name__:") {
+			for _, sk := range keys {
+				v.appSegments[sk] = struct{}{}
+			}
+		}
+	})
+}
+
+func (v *verifier) verifySegments() error {
+	return iterateDB(v.s.segments, segmentPrefix, func(k string, b []byte) {
+		v.report.Segments++
+		seg, err := segment.Deserialize(bytes.NewReader(b))
+		if err != nil {
+			v.add(IssueCorruptSegment, k, err)
+			return
+		}
+		v.segments[k] = struct{}{}
+		if _, ok := v.appSegments[k]; !ok {
+			v.add(IssueOrphanSegment, k, nil)
+		}
+		_ = seg.WalkNodes(func(depth int, t time.Time) error {
+			v.trees[segment.TreeKey(k, depth, t.Unix())] = struct{}{}
+			return nil
+		})
+	})
+}
+
+func (v *verifier) verifyTrees() error {
+	err := iterateDB(v.s.trees, treePrefix, func(k string, b []byte) {
+		v.report.Trees++
+		if _, _, err := segment.ParseTreeKey(k); err == nil {
+			if _, ok := v.trees[k]; !ok {
+				v.add(IssueOrphanTree, k, nil)
+			}
+			delete(v.trees, k)
+		}
+		// Offloaded trees are not downloaded.
+		if _, ok := parseBlockRef(b); ok {
+			return
+		}
+		if _, err := tree.FromBytes(v.dict(k), b); err != nil {
+			v.add(IssueCorruptTree, k, err)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for k := range v.trees {
+		v.add(IssueMissingTree, k, nil)
+	}
+	return nil
+}
+
+// dict returns the dictionary of the tree, reporting it if missing.
+func (v *verifier) dict(treeKey string) *dict.Dict {
+	app := segment.FromTreeToDictKey(treeKey)
+	d, ok := v.dicts[app]
+	if ok {
+		return d
+	}
+	if x, found := v.s.dicts.Lookup(app); found {
+		d = x.(*dict.Dict)
+	} else {
+		v.add(IssueMissingDictionary, app, nil)
+		d = dict.New()
+	}
+	v.dicts[app] = d
+	return d
+}
+
+func (v *verifier) findDangling() {
+	for dk, keys := range v.dimensionKeys {
+		for _, sk := range keys {
+			if _, ok := v.segments[sk]; !ok {
+				v.add(IssueDanglingDimension, dk+"->"+sk, nil)
+			}
+		}
+	}
+}
+
+func (v *verifier) repair() error {
+	s := v.s
+	for _, x := range v.issues {
+		var err error
+		switch x.Kind {
+		case IssueCorruptDimension:
+			err = v.deleteDimension(x.Key)
+		case IssueDanglingDimension:
+			i := strings.Index(x.Key, "->")
+			err = v.deleteDimensionKey(x.Key[:i], x.Key[i+2:])
+		case IssueCorruptSegment, IssueOrphanSegment:
+			err = v.deleteSegment(x.Key)
+		case IssueCorruptTree, IssueOrphanTree:
+			err = s.trees.Cache.Delete(x.Key)
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		v.report.Repaired++
+	}
+	return nil
+}
+
+// deleteSegment removes the segment, its trees, and references to it.
+func (v *verifier) deleteSegment(sk string) error {
+	if k, err := segment.ParseKey(sk); err == nil {
+		for name, value := range k.Labels() {
+			if err = v.deleteDimensionKey(name+":"+value, sk); err != nil {
+				return err
+			}
+		}
+	}
+	if err := v.s.trees.DiscardPrefix(sk + ":"); err != nil {
+		return err
+	}
+	return v.s.segments.Cache.Delete(sk)
+}
+
+func (v *verifier) deleteDimension(dk string) error {
+	if i := strings.Index(dk, ":"); i > 0 {
+		if err := v.s.labels.Delete(dk[:i], dk[i+1:]); err != nil {
+			return err
+		}
+	}
+	return v.s.dimensions.Cache.Delete(dk)
+}
+
+func (v *verifier) deleteDimensionKey(dk, sk string) error {
+	x, ok := v.s.dimensions.Lookup(dk)
+	if !ok {
+		return nil
+	}
+	d := x.(*dimension.Dimension)
+	d.Delete(dimension.Key(sk))
+	if len(d.Keys) == 0 {
+		return v.deleteDimension(dk)
+	}
+	v.s.dimensions.Cache.Put(dk, d)
+	return nil
+}
+
+// iterateDB calls fn for every item of the database,
+// the key is passed without the prefix.
+func iterateDB(d *db, p prefix, fn func(k string, v []byte)) error {
+	it := d.NewIterator(backend.IteratorOptions{
+		Prefix:         p.bytes(),
+		PrefetchValues: true,
+	})
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		k, ok := p.trim(item.Key())
+		if !ok {
+			continue
+		}
+		b, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		fn(string(k), b)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package storage
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/dimension"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("storage integrity", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		JustBeforeEach(func() {
+			var err error
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		JustAfterEach(func() {
+			Expect(s.Close()).To(Succeed())
+		})
+
+		st := time.Now().Add(-time.Hour).Truncate(10 * time.Second)
+		put := func(name string) {
+			k, err := segment.ParseKey(name)
+			Expect(err).ToNot(HaveOccurred())
+			t := tree.New()
+			t.Insert([]byte("a;b"), 1)
+			Expect(s.Put(&PutInput{
+				StartTime:  st,
+				EndTime:    st.Add(10 * time.Second),
+				Key:        k,
+				Val:        t,
+				SpyName:    "testspy",
+				SampleRate: 100,
+			})).To(Succeed())
+		}
+
+		kinds := func(r *VerifyReport) []string {
+			var k []string
+			for _, x := range r.Issues {
+				k = append(k, x.Kind+" "+x.Key)
+			}
+			return k
+		}
+
+		It("reports no issues for consistent storage", func() {
+			put("app.cpu{foo=bar}")
+			put("app.cpu{foo=baz}")
+			r, err := s.Verify(false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.IssuesTotal).To(BeZero())
+			Expect(r.Segments).To(Equal(2))
+			Expect(r.Trees).To(Equal(2))
+		})
+
+		It("reports and repairs corrupt and unreachable data", func() {
+			put("app.cpu{foo=bar}")
+			put("app.cpu{foo=baz}")
+			s.writeBack()
+
+			// Tree not referenced by any segment.
+			b, err := s.trees.Backend.Get(treePrefix.key(segment.TreeKey("app.cpu{foo=bar}", 0, st.Unix())))
+			Expect(err).ToNot(HaveOccurred())
+			orphan := segment.TreeKey("app.cpu{foo=bar}", 0, st.Add(-time.Hour).Unix())
+			Expect(s.trees.Backend.Set(treePrefix.key(orphan), b)).To(Succeed())
+			// Corrupt tree.
+			corrupt := segment.TreeKey("app.cpu{foo=baz}", 0, st.Unix())
+			Expect(s.trees.Backend.Set(treePrefix.key(corrupt), []byte{0xff, 0xff})).To(Succeed())
+			// Dimension referencing a segment that does not exist.
+			d := dimension.New()
+			d.Insert(dimension.Key("app.cpu{foo=qux}"))
+			s.dimensions.Cache.Put("foo:qux", d)
+			s.dimensions.WriteBack()
+
+			r, err := s.Verify(false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(kinds(r)).To(ConsistOf(
+				IssueOrphanTree+" "+orphan,
+				IssueCorruptTree+" "+corrupt,
+				IssueDanglingDimension+" foo:qux->app.cpu{foo=qux}",
+			))
+			Expect(r.Repaired).To(BeZero())
+
+			r, err = s.Verify(true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.IssuesTotal).To(Equal(3))
+			Expect(r.Repaired).To(Equal(3))
+
+			// The segment still references the removed corrupt tree.
+			r, err = s.Verify(false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(kinds(r)).To(ConsistOf(IssueMissingTree + " " + corrupt))
+			_, ok := s.dimensions.Lookup("foo:qux")
+			Expect(ok).To(BeFalse())
+
+			k, _ := segment.ParseKey("app.cpu{foo=bar}")
+			o, err := s.Get(&GetInput{StartTime: st, EndTime: st.Add(10 * time.Second), Key: k})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(o.Tree.String()).To(Equal("a;b 1\n"))
+		})
+	})
+})
@@ -0,0 +1,299 @@
+// Package wal implements a write-ahead log split into segment files.
+//
+// Records are appended to the current segment; Rotate closes it and
+// starts a new one, so that segments whose records are already applied
+// can be removed with Truncate. Segments found on Open are replayed
+// with Replay.
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SyncPolicy specifies when the log is synced to disk.
+type SyncPolicy int
+
+const (
+	// SyncAlways syncs the log on every append.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval syncs the log periodically: records written in the
+	// last interval may be lost if the OS crashes, but not the process.
+	SyncInterval
+	// SyncNever leaves syncing to the OS.
+	SyncNever
+)
+
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch s {
+	case "always":
+		return SyncAlways, nil
+	case "interval":
+		return SyncInterval, nil
+	case "never":
+		return SyncNever, nil
+	default:
+		return 0, fmt.Errorf("unknown sync policy %q: should be one of always, interval, never", s)
+	}
+}
+
+const (
+	segmentExt  = ".wal"
+	headerSize  = 8
+	maxRecordSz = 1 << 30
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type Options struct {
+	Dir          string
+	Sync         SyncPolicy
+	SyncInterval time.Duration
+	Logger       logrus.FieldLogger
+}
+
+type Log struct {
+	opts Options
+
+	mutex sync.Mutex
+	f     *os.File
+	seq   uint64
+	dirty bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Open creates a new segment after the existing ones, if any.
+func Open(o Options) (*Log, error) {
+	if o.Logger == nil {
+		o.Logger = logrus.StandardLogger()
+	}
+	if err := os.MkdirAll(o.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	segments, err := listSegments(o.Dir)
+	if err != nil {
+		return nil, err
+	}
+	l := &Log{opts: o, stop: make(chan struct{}), done: make(chan struct{})}
+	if len(segments) > 0 {
+		l.seq = segments[len(segments)-1]
+	}
+	if err = l.openSegment(l.seq + 1); err != nil {
+		return nil, err
+	}
+	if o.Sync == SyncInterval && o.SyncInterval > 0 {
+		go l.syncLoop()
+	} else {
+		close(l.done)
+	}
+	return l, nil
+}
+
+func (l *Log) segmentPath(seq uint64) string {
+	return filepath.Join(l.opts.Dir, fmt.Sprintf("%016d%s", seq, segmentExt))
+}
+
+func (l *Log) openSegment(seq uint64) error {
+	f, err := os.OpenFile(l.segmentPath(seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	l.f = f
+	l.seq = seq
+	return nil
+}
+
+// Append writes the record to the current segment.
+func (l *Log) Append(record []byte) error {
+	b := make([]byte, headerSize+len(record))
+	binary.BigEndian.PutUint32(b, uint32(len(record)))
+	binary.BigEndian.PutUint32(b[4:], crc32.Checksum(record, crcTable))
+	copy(b[headerSize:], record)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if _, err := l.f.Write(b); err != nil {
+		return err
+	}
+	if l.opts.Sync == SyncAlways {
+		return l.f.Sync()
+	}
+	l.dirty = true
+	return nil
+}
+
+// Rotate starts a new segment and returns the sequence number of the
+// previous one.
+func (l *Log) Rotate() (uint64, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	prev := l.seq
+	if err := l.closeSegment(); err != nil {
+		return 0, err
+	}
+	return prev, l.openSegment(prev + 1)
+}
+
+func (l *Log) closeSegment() error {
+	if l.opts.Sync != SyncNever {
+		if err := l.f.Sync(); err != nil {
+			_ = l.f.Close()
+			return err
+		}
+	}
+	l.dirty = false
+	return l.f.Close()
+}
+
+// Truncate removes segments up to and including seq.
+// The current segment is never removed.
+func (l *Log) Truncate(seq uint64) error {
+	segments, err := listSegments(l.opts.Dir)
+	if err != nil {
+		return err
+	}
+	l.mutex.Lock()
+	current := l.seq
+	l.mutex.Unlock()
+	for _, s := range segments {
+		if s > seq || s == current {
+			break
+		}
+		if err = os.Remove(l.segmentPath(s)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Replay calls fn for every record of the segments preceding the current
+// one. A torn record at the end of a segment, e.g. because of a crash
+// during a write, is skipped along with the rest of the segment. It
+// returns the sequence number of the last replayed segment, or 0.
+func (l *Log) Replay(fn func([]byte) error) (uint64, error) {
+	segments, err := listSegments(l.opts.Dir)
+	if err != nil {
+		return 0, err
+	}
+	l.mutex.Lock()
+	current := l.seq
+	l.mutex.Unlock()
+	var last uint64
+	for _, s := range segments {
+		if s >= current {
+			break
+		}
+		if err = l.replaySegment(s, fn); err != nil {
+			return last, err
+		}
+		last = s
+	}
+	return last, nil
+}
+
+func (l *Log) replaySegment(seq uint64, fn func([]byte) error) error {
+	f, err := os.Open(l.segmentPath(seq))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var h [headerSize]byte
+	for n := 0; ; n++ {
+		if _, err = io.ReadFull(r, h[:]); err != nil {
+			if !errors.Is(err, io.EOF) {
+				l.torn(seq, n, err)
+			}
+			return nil
+		}
+		size := binary.BigEndian.Uint32(h[:])
+		if size > maxRecordSz {
+			l.torn(seq, n, fmt.Errorf("invalid record size %d", size))
+			return nil
+		}
+		record := make([]byte, size)
+		if _, err = io.ReadFull(r, record); err != nil {
+			l.torn(seq, n, err)
+			return nil
+		}
+		if crc32.Checksum(record, crcTable) != binary.BigEndian.Uint32(h[4:]) {
+			l.torn(seq, n, errors.New("checksum mismatch"))
+			return nil
+		}
+		if err = fn(record); err != nil {
+			return err
+		}
+	}
+}
+
+func (l *Log) torn(seq uint64, n int, err error) {
+	l.opts.Logger.WithError(err).
+		WithField("segment", seq).
+		WithField("record", n).
+		Warn("skipping torn write-ahead log record")
+}
+
+func (l *Log) syncLoop() {
+	defer close(l.done)
+	t := time.NewTicker(l.opts.SyncInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-t.C:
+			l.mutex.Lock()
+			if l.dirty {
+				if err := l.f.Sync(); err != nil {
+					l.opts.Logger.WithError(err).Error("failed to sync write-ahead log")
+				}
+				l.dirty = false
+			}
+			l.mutex.Unlock()
+		}
+	}
+}
+
+func (l *Log) Close() error {
+	close(l.stop)
+	<-l.done
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.closeSegment()
+}
+
+func listSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, seq)
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+	return segments, nil
+}
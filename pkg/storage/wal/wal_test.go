@@ -0,0 +1,86 @@
+package wal_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/wal"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("wal", func() {
+	var tdir *testing.TmpDirectory
+
+	BeforeEach(func() {
+		tdir = testing.TmpDirSync()
+	})
+
+	AfterEach(func() {
+		tdir.Close()
+	})
+
+	replay := func(l *wal.Log) ([]string, uint64) {
+		var records []string
+		last, err := l.Replay(func(b []byte) error {
+			records = append(records, string(b))
+			return nil
+		})
+		Expect(err).ToNot(HaveOccurred())
+		return records, last
+	}
+
+	It("replays records of the previous segments", func() {
+		l, err := wal.Open(wal.Options{Dir: tdir.Path})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l.Append([]byte("foo"))).To(Succeed())
+		seq, err := l.Rotate()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l.Append([]byte("bar"))).To(Succeed())
+		Expect(l.Truncate(seq)).To(Succeed())
+		Expect(l.Append([]byte("baz"))).To(Succeed())
+		Expect(l.Close()).To(Succeed())
+
+		l, err = wal.Open(wal.Options{Dir: tdir.Path, Sync: wal.SyncNever})
+		Expect(err).ToNot(HaveOccurred())
+		records, last := replay(l)
+		Expect(records).To(Equal([]string{"bar", "baz"}))
+		Expect(l.Truncate(last)).To(Succeed())
+		Expect(l.Close()).To(Succeed())
+
+		l, err = wal.Open(wal.Options{Dir: tdir.Path})
+		Expect(err).ToNot(HaveOccurred())
+		records, _ = replay(l)
+		Expect(records).To(BeEmpty())
+		Expect(l.Close()).To(Succeed())
+	})
+
+	It("skips torn records", func() {
+		l, err := wal.Open(wal.Options{Dir: tdir.Path})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l.Append([]byte("foo"))).To(Succeed())
+		Expect(l.Append([]byte("bar"))).To(Succeed())
+		Expect(l.Close()).To(Succeed())
+
+		p := filepath.Join(tdir.Path, "0000000000000001.wal")
+		fi, err := os.Stat(p)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.Truncate(p, fi.Size()-1)).To(Succeed())
+
+		l, err = wal.Open(wal.Options{Dir: tdir.Path})
+		Expect(err).ToNot(HaveOccurred())
+		records, _ := replay(l)
+		Expect(records).To(Equal([]string{"foo"}))
+		Expect(l.Close()).To(Succeed())
+	})
+
+	It("parses sync policy", func() {
+		Expect(wal.ParseSyncPolicy("always")).To(Equal(wal.SyncAlways))
+		Expect(wal.ParseSyncPolicy("interval")).To(Equal(wal.SyncInterval))
+		Expect(wal.ParseSyncPolicy("never")).To(Equal(wal.SyncNever))
+		_, err := wal.ParseSyncPolicy("sometimes")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,171 @@
+package flamebearer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+)
+
+const (
+	// DefaultImageWidth is the width of rendered images in pixels,
+	// unless specified otherwise.
+	DefaultImageWidth = 1200
+	// MaxImageWidth limits the width of rendered images.
+	MaxImageWidth = 16384
+	// MaxImagePixels limits the size of rendered images: the height
+	// depends on the depth of the tree. A PNG image of this size takes
+	// 64MB of memory while it's rendered.
+	MaxImagePixels = 1 << 24
+
+	imagePadding     = 10
+	imageFrameHeight = 18
+	// Labels are drawn with basicfont.Face7x13 in PNG images; the same
+	// glyph width is assumed for the monospace font of SVG images.
+	imageCharWidth = 7
+)
+
+var (
+	// ErrDiffImageFormat is returned for diff profiles: images don't
+	// show the difference.
+	ErrDiffImageFormat = errors.New("diff can't be rendered as an image")
+	// ErrImageTooLarge is returned if the image would exceed MaxImagePixels.
+	ErrImageTooLarge = fmt.Errorf("image can't exceed %d pixels: reduce the width or max-nodes", MaxImagePixels)
+)
+
+type imageFrame struct {
+	x, y, w int
+	label   string
+	title   string
+	color   color.RGBA
+}
+
+// FlamebearerToSVG renders a flamebearer as a standalone SVG image of
+// the given width. Frames narrower than a pixel are not drawn.
+func FlamebearerToSVG(fb *FlamebearerProfile, width int, w io.Writer) error {
+	frames, height, err := imageFrames(fb, width)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<?xml version="1.0" standalone="no"?>`+"\n"+
+		`<svg version="1.1" width="%d" height="%d" viewBox="0 0 %d %d" xmlns="http://www.w3.org/2000/svg">`+"\n"+
+		`<rect x="0" y="0" width="100%%" height="100%%" fill="#ffffff"/>`+"\n"+
+		`<g font-family="monospace" font-size="12">`+"\n",
+		width, height, width, height)
+	for _, f := range frames {
+		fmt.Fprintf(bw, `<g><title>%s</title><rect x="%d" y="%d" width="%d" height="%d" fill="rgb(%d,%d,%d)"/>`,
+			html.EscapeString(f.title), f.x, f.y, f.w, imageFrameHeight-1, f.color.R, f.color.G, f.color.B)
+		if f.label != "" {
+			fmt.Fprintf(bw, `<text x="%d" y="%d">%s</text>`, f.x+3, f.y+13, html.EscapeString(f.label))
+		}
+		bw.WriteString("</g>\n")
+	}
+	bw.WriteString("</g>\n</svg>\n")
+	return bw.Flush()
+}
+
+// FlamebearerToPNG renders a flamebearer as a PNG image of the given
+// width. Frames narrower than a pixel are not drawn.
+func FlamebearerToPNG(fb *FlamebearerProfile, width int, w io.Writer) error {
+	frames, height, err := imageFrames(fb, width)
+	if err != nil {
+		return err
+	}
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	d := font.Drawer{Dst: img, Src: image.Black, Face: basicfont.Face7x13}
+	for _, f := range frames {
+		r := image.Rect(f.x, f.y, f.x+f.w, f.y+imageFrameHeight-1)
+		draw.Draw(img, r, image.NewUniform(f.color), image.Point{}, draw.Src)
+		if f.label != "" {
+			d.Dot = fixed.P(f.x+3, f.y+13)
+			d.DrawString(f.label)
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// imageFrames lays out the flamebearer frames, root at the top, and
+// returns them along with the image height.
+func imageFrames(fb *FlamebearerProfile, width int) ([]imageFrame, int, error) {
+	if fb.Metadata.Format == string(tree.FormatDouble) {
+		return nil, 0, ErrDiffImageFormat
+	}
+	if width <= 2*imagePadding || width > MaxImageWidth {
+		return nil, 0, fmt.Errorf("image width must be greater than %d and not exceed %d", 2*imagePadding, MaxImageWidth)
+	}
+	f := fb.Flamebearer
+	height := 2*imagePadding + len(f.Levels)*imageFrameHeight
+	if width*height > MaxImagePixels {
+		return nil, 0, ErrImageTooLarge
+	}
+	if f.NumTicks == 0 {
+		return nil, height, nil
+	}
+	scale := float64(width-2*imagePadding) / float64(f.NumTicks)
+	var frames []imageFrame
+	for level, l := range f.Levels {
+		// Offsets are delta encoded: see tree.FlamebearerStruct.
+		var x int
+		for i := 0; i+3 < len(l); i += 4 {
+			x += l[i]
+			total, name := l[i+1], f.Names[l[i+3]]
+			start := x
+			x += total
+			w := int(float64(total) * scale)
+			if w < 1 {
+				continue
+			}
+			frames = append(frames, imageFrame{
+				x:     imagePadding + int(float64(start)*scale),
+				y:     imagePadding + level*imageFrameHeight,
+				w:     w,
+				label: fitLabel(name, w),
+				title: fmt.Sprintf("%s (%d %s, %.2f%%)", name, total, fb.Metadata.Units, 100*float64(total)/float64(f.NumTicks)),
+				color: frameColor(name),
+			})
+		}
+	}
+	return frames, height, nil
+}
+
+// fitLabel truncates the frame name to fit the frame width.
+func fitLabel(name string, width int) string {
+	n := (width - 6) / imageCharWidth
+	r := []rune(name)
+	switch {
+	case n < 3:
+		return ""
+	case len(r) <= n:
+		return name
+	default:
+		return string(r[:n-2]) + ".."
+	}
+}
+
+// frameColor picks a warm color by the frame name, so that a function
+// has the same color across images.
+func frameColor(name string) color.RGBA {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(name))
+	v := h.Sum32()
+	return color.RGBA{
+		R: uint8(205 + v%50),
+		G: uint8(80 + (v>>8)%150),
+		B: uint8((v >> 16) % 60),
+		A: 0xff,
+	}
+}
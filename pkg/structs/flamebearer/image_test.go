@@ -0,0 +1,68 @@
+package flamebearer
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+)
+
+var _ = Describe("image rendering", func() {
+	var fb FlamebearerProfile
+
+	BeforeEach(func() {
+		t := tree.New()
+		t.Insert([]byte("a;b"), uint64(1))
+		t.Insert([]byte("a;c"), uint64(3))
+		fb = NewProfile(&storage.GetOutput{Tree: t, Units: "samples"}, maxNodes)
+	})
+
+	It("lays out frames proportionally to their totals", func() {
+		frames, height, err := imageFrames(&fb, 2*imagePadding+400)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(height).To(Equal(2*imagePadding + 3*imageFrameHeight))
+
+		widths := map[string]int{}
+		offsets := map[string]int{}
+		for _, f := range frames {
+			widths[f.label] = f.w
+			offsets[f.label] = f.x
+		}
+		Expect(widths).To(Equal(map[string]int{"total": 400, "a": 400, "b": 100, "c": 300}))
+		Expect(offsets["c"]).To(Equal(imagePadding + 100))
+	})
+
+	It("renders SVG", func() {
+		var buf bytes.Buffer
+		Expect(FlamebearerToSVG(&fb, DefaultImageWidth, &buf)).To(Succeed())
+		Expect(buf.String()).To(ContainSubstring("<title>c (3 samples, 75.00%)</title>"))
+	})
+
+	It("renders PNG", func() {
+		var buf bytes.Buffer
+		Expect(FlamebearerToPNG(&fb, 300, &buf)).To(Succeed())
+		img, err := png.Decode(&buf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(img.Bounds().Dx()).To(Equal(300))
+	})
+
+	It("limits the image size", func() {
+		t := tree.New()
+		t.Insert([]byte(strings.TrimSuffix(strings.Repeat("a;", 1000), ";")), uint64(1))
+		fb = NewProfile(&storage.GetOutput{Tree: t, Units: "samples"}, maxNodes)
+		_, _, err := imageFrames(&fb, MaxImageWidth)
+		Expect(err).To(MatchError(ErrImageTooLarge))
+		_, _, err = imageFrames(&fb, 300)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("truncates labels to fit frames", func() {
+		Expect(fitLabel("github.com/foo/bar.Baz", 6+7*10)).To(Equal("github.c.."))
+		Expect(fitLabel("main", 10)).To(BeEmpty())
+	})
+})
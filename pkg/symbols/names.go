@@ -0,0 +1,34 @@
+package symbols
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type names map[string]string
+
+func parseNames(r io.Reader) (names, error) {
+	m := make(names)
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimRight(s.Text(), "\r")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		i := strings.IndexByte(line, '\t')
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: tab separator is missing", n)
+		}
+		m[line[:i]] = line[i+1:]
+	}
+	return m, s.Err()
+}
+
+func (m names) MapName(name string) string {
+	if v, ok := m[name]; ok {
+		return v
+	}
+	return name
+}
@@ -0,0 +1,94 @@
+package symbols
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type proGuardClass struct {
+	name string
+	// methods maps obfuscated method names to the original ones.
+	methods map[string]string
+}
+
+// proGuard maps frame names of JVM applications, e.g. "a/b.c" or "a.b.c",
+// where "a.b" is the obfuscated class name and "c" is the method name.
+// If an obfuscated name corresponds to several methods (overloads or
+// inlined methods), the first one is used.
+type proGuard struct {
+	classes map[string]*proGuardClass
+}
+
+func parseProGuard(r io.Reader) (*proGuard, error) {
+	p := proGuard{classes: make(map[string]*proGuardClass)}
+	var c *proGuardClass
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64<<10), 1<<20)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimRight(s.Text(), "\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed[0] == '#' {
+			continue
+		}
+		i := strings.Index(trimmed, " -> ")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: invalid mapping", n)
+		}
+		original, obfuscated := trimmed[:i], trimmed[i+4:]
+		if line[0] != ' ' && line[0] != '\t' {
+			// Class mapping: "com.example.Foo -> a.b:".
+			c = &proGuardClass{name: original, methods: make(map[string]string)}
+			p.classes[strings.TrimSuffix(obfuscated, ":")] = c
+			continue
+		}
+		if c == nil {
+			return nil, fmt.Errorf("line %d: member mapping outside of class", n)
+		}
+		// Method mapping: "[1:5:]void foo(int)[:10[:15]] -> a",
+		// fields are ignored.
+		j := strings.IndexByte(original, '(')
+		if j < 0 {
+			continue
+		}
+		sig := original[:j]
+		name := sig[strings.LastIndexByte(sig, ' ')+1:]
+		if _, ok := c.methods[obfuscated]; !ok {
+			c.methods[obfuscated] = name
+		}
+	}
+	return &p, s.Err()
+}
+
+func (p *proGuard) MapName(name string) string {
+	sep := "."
+	if strings.Contains(name, "/") {
+		sep = "/"
+	}
+	// Signature, if present, is kept as is.
+	qualified, suffix := name, ""
+	if i := strings.IndexByte(name, '('); i >= 0 {
+		qualified, suffix = name[:i], name[i:]
+	}
+	i := strings.LastIndexByte(qualified, '.')
+	if i < 0 {
+		return name
+	}
+	class, method := qualified[:i], qualified[i+1:]
+	if sep == "/" {
+		class = strings.ReplaceAll(class, "/", ".")
+	}
+	c, ok := p.classes[class]
+	if !ok {
+		return name
+	}
+	if m, ok := c.methods[method]; ok {
+		method = m
+	}
+	class = c.name
+	if sep == "/" {
+		class = strings.ReplaceAll(class, ".", "/")
+	}
+	return class + "." + method + suffix
+}
@@ -0,0 +1,94 @@
+// Package symbols implements mapping of obfuscated (or minified) frame
+// names to the original ones, e.g. with ProGuard mapping files of JVM
+// applications.
+package symbols
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Mapper maps frame names to the original ones: names that are unknown to
+// the mapper are returned as is.
+type Mapper interface {
+	MapName(name string) string
+}
+
+const (
+	// TypeProGuard is the type of ProGuard (and R8) mapping files.
+	TypeProGuard = "proguard"
+	// TypeNames is the type of files mapping names as is: every line
+	// consists of the obfuscated and the original name separated with a
+	// tab character. Empty lines and lines starting with # are ignored.
+	TypeNames = "names"
+)
+
+// Open loads the mapping file of the given type.
+func Open(typ, filePath string) (Mapper, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	switch typ {
+	case TypeProGuard:
+		return parseProGuard(f)
+	case TypeNames:
+		return parseNames(f)
+	default:
+		return nil, fmt.Errorf("unknown symbol mapping type %q: must be %s or %s", typ, TypeProGuard, TypeNames)
+	}
+}
+
+type appMapper struct {
+	pattern string
+	mapper  Mapper
+}
+
+// AppMappers holds mappers of applications.
+type AppMappers struct {
+	mappers []appMapper
+}
+
+// NewAppMappers loads mapping files specified in pattern=type:path form,
+// where pattern is an application name glob. Similarly to app retention,
+// the longest pattern takes precedence and patterns are case-insensitive.
+func NewAppMappers(m map[string]string) (*AppMappers, error) {
+	a := AppMappers{mappers: make([]appMapper, 0, len(m))}
+	for pattern, v := range m {
+		pattern = strings.ToLower(pattern)
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid symbol mapping pattern %q: %w", pattern, err)
+		}
+		i := strings.IndexByte(v, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid symbol mapping for %q: %q: must be in type:path form", pattern, v)
+		}
+		mapper, err := Open(v[:i], v[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("symbol mapping for %q: %w", pattern, err)
+		}
+		a.mappers = append(a.mappers, appMapper{pattern: pattern, mapper: mapper})
+	}
+	sort.Slice(a.mappers, func(i, j int) bool {
+		if len(a.mappers[i].pattern) != len(a.mappers[j].pattern) {
+			return len(a.mappers[i].pattern) > len(a.mappers[j].pattern)
+		}
+		return a.mappers[i].pattern < a.mappers[j].pattern
+	})
+	return &a, nil
+}
+
+// Mapper returns the mapper of the application, if any.
+func (a *AppMappers) Mapper(appName string) (Mapper, bool) {
+	name := strings.ToLower(appName)
+	for _, m := range a.mappers {
+		if ok, _ := path.Match(m.pattern, name); ok {
+			return m.mapper, true
+		}
+	}
+	return nil, false
+}
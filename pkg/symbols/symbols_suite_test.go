@@ -0,0 +1,13 @@
+package symbols
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestSymbols(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Symbols Suite")
+}
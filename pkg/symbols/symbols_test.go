@@ -0,0 +1,78 @@
+package symbols
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const proGuardMapping = `# compiler: R8
+com.example.Foo -> a.b:
+    int count -> a
+    1:5:void handle(int):10:14 -> c
+    6:8:void handle(java.lang.String) -> c
+    java.lang.String toString() -> toString
+com.example.Bar -> a.c:
+    void <init>() -> <init>
+`
+
+var _ = Describe("symbol mapping", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = os.MkdirTemp("", "symbols")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(os.WriteFile(filepath.Join(dir, "mapping.txt"), []byte(proGuardMapping), 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "names.txt"), []byte("# minified\nt\trender\ne\tfetchData\n"), 0644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	It("maps ProGuard obfuscated names", func() {
+		m, err := Open(TypeProGuard, filepath.Join(dir, "mapping.txt"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(m.MapName("a.b.c")).To(Equal("com.example.Foo.handle"))
+		Expect(m.MapName("a/b.c")).To(Equal("com/example/Foo.handle"))
+		Expect(m.MapName("a/b.toString()")).To(Equal("com/example/Foo.toString()"))
+		Expect(m.MapName("a.c.x")).To(Equal("com.example.Bar.x"))
+		Expect(m.MapName("java.lang.Thread.run")).To(Equal("java.lang.Thread.run"))
+		Expect(m.MapName("main")).To(Equal("main"))
+	})
+
+	It("maps names as is", func() {
+		m, err := Open(TypeNames, filepath.Join(dir, "names.txt"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(m.MapName("t")).To(Equal("render"))
+		Expect(m.MapName("x")).To(Equal("x"))
+	})
+
+	It("selects mappers by application name", func() {
+		a, err := NewAppMappers(map[string]string{
+			"android.*":       "proguard:" + filepath.Join(dir, "mapping.txt"),
+			"android.web.cpu": "names:" + filepath.Join(dir, "names.txt"),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		m, ok := a.Mapper("android.web.cpu")
+		Expect(ok).To(BeTrue())
+		Expect(m.MapName("t")).To(Equal("render"))
+		m, ok = a.Mapper("Android.app.cpu")
+		Expect(ok).To(BeTrue())
+		Expect(m.MapName("a.b.c")).To(Equal("com.example.Foo.handle"))
+		_, ok = a.Mapper("ios.app.cpu")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("validates mappings", func() {
+		_, err := NewAppMappers(map[string]string{"app.*": filepath.Join(dir, "names.txt")})
+		Expect(err).To(HaveOccurred())
+		_, err = NewAppMappers(map[string]string{"app.*": "sourcemap:" + filepath.Join(dir, "names.txt")})
+		Expect(err).To(HaveOccurred())
+		_, err = NewAppMappers(map[string]string{"app.*": "names:" + filepath.Join(dir, "mapping.txt")})
+		Expect(err).To(HaveOccurred())
+	})
+})
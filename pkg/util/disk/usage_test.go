@@ -20,5 +20,15 @@ var _ = Describe("disk package", func() {
 				Expect(FreeSpace((*cfg).Server.StoragePath)).To(BeNumerically(">", 0))
 			})
 		})
+
+		Describe("TotalSpace", func() {
+			It("returns value not less than free space", func() {
+				free, err := FreeSpace((*cfg).Server.StoragePath)
+				Expect(err).ToNot(HaveOccurred())
+				total, err := TotalSpace((*cfg).Server.StoragePath)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(total).To(BeNumerically(">=", free))
+			})
+		})
 	})
 })
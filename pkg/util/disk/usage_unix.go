@@ -17,3 +17,14 @@ func FreeSpace(storagePath string) (bytesize.ByteSize, error) {
 
 	return bytesize.ByteSize(fs.Bavail) * bytesize.ByteSize(fs.Bsize), nil
 }
+
+// TotalSpace returns the size of the file system the path belongs to.
+func TotalSpace(storagePath string) (bytesize.ByteSize, error) {
+	fs := syscall.Statfs_t{}
+	err := syscall.Statfs(storagePath, &fs)
+	if err != nil {
+		return 0, err
+	}
+
+	return bytesize.ByteSize(fs.Blocks) * bytesize.ByteSize(fs.Bsize), nil
+}
@@ -17,9 +17,20 @@ var (
 )
 
 func FreeSpace(path string) (bytesize.ByteSize, error) {
+	free, _, err := diskSpace(path)
+	return free, err
+}
+
+// TotalSpace returns the size of the disk the path belongs to.
+func TotalSpace(path string) (bytesize.ByteSize, error) {
+	_, total, err := diskSpace(path)
+	return total, err
+}
+
+func diskSpace(path string) (free, total bytesize.ByteSize, err error) {
 	dirPath, err := syscall.UTF16PtrFromString(path)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
 
 	var (
@@ -34,8 +45,8 @@ func FreeSpace(path string) (bytesize.ByteSize, error) {
 		uintptr(unsafe.Pointer(&totalNumberOfBytes)),
 		uintptr(unsafe.Pointer(&totalNumberOfFreeBytes)))
 	if ret == 0 {
-		return 0, os.NewSyscallError("GetDiskFreeSpaceEx", err)
+		return 0, 0, os.NewSyscallError("GetDiskFreeSpaceEx", err)
 	}
 
-	return bytesize.ByteSize(freeBytesAvailableToCaller), nil
+	return bytesize.ByteSize(freeBytesAvailableToCaller), bytesize.ByteSize(totalNumberOfBytes), nil
 }
@@ -0,0 +1,99 @@
+// Package signature implements HMAC signing of ingestion requests with
+// secrets shared between agents and the server.
+//
+// The signature is provided in the X-Pyroscope-Signature header in form
+// of key=<key id>,t=<unix timestamp>,sig=<hex-encoded HMAC-SHA256>. The
+// signed message is the timestamp, the raw URL query and the request body
+// as transmitted (i.e. compressed, if Content-Encoding is specified),
+// separated by new lines.
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const Header = "X-Pyroscope-Signature"
+
+var (
+	ErrMissing   = errors.New("request signature is missing")
+	ErrMalformed = errors.New("request signature is malformed")
+	ErrInvalid   = errors.New("request signature is invalid")
+	ErrExpired   = errors.New("request signature is expired")
+)
+
+type Signature struct {
+	KeyID     string
+	Timestamp time.Time
+	MAC       []byte
+}
+
+// Sign returns the header value signing the request query and body.
+func Sign(keyID string, secret []byte, t time.Time, query string, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return fmt.Sprintf("key=%s,t=%s,sig=%s", keyID, ts, hex.EncodeToString(mac(secret, ts, query, body)))
+}
+
+// Parse parses the header value.
+func Parse(h string) (*Signature, error) {
+	if h == "" {
+		return nil, ErrMissing
+	}
+	var (
+		s   Signature
+		err error
+	)
+	for _, p := range strings.Split(h, ",") {
+		kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+		if len(kv) != 2 {
+			return nil, ErrMalformed
+		}
+		switch kv[0] {
+		case "key":
+			s.KeyID = kv[1]
+		case "t":
+			ts, err := strconv.ParseInt(kv[1], 10, 64)
+			if err != nil {
+				return nil, ErrMalformed
+			}
+			s.Timestamp = time.Unix(ts, 0)
+		case "sig":
+			if s.MAC, err = hex.DecodeString(kv[1]); err != nil {
+				return nil, ErrMalformed
+			}
+		}
+	}
+	if s.KeyID == "" || s.Timestamp.IsZero() || len(s.MAC) == 0 {
+		return nil, ErrMalformed
+	}
+	return &s, nil
+}
+
+// Verify checks whether the signature matches the request query and body,
+// and the signature timestamp is within maxAge of now, unless maxAge is 0.
+func (s *Signature) Verify(secret []byte, query string, body []byte, now time.Time, maxAge time.Duration) error {
+	if d := now.Sub(s.Timestamp); maxAge > 0 && (d > maxAge || d < -maxAge) {
+		return ErrExpired
+	}
+	ts := strconv.FormatInt(s.Timestamp.Unix(), 10)
+	if !hmac.Equal(s.MAC, mac(secret, ts, query, body)) {
+		return ErrInvalid
+	}
+	return nil
+}
+
+func mac(secret []byte, ts, query string, body []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(ts))
+	h.Write([]byte{'\n'})
+	h.Write([]byte(query))
+	h.Write([]byte{'\n'})
+	h.Write(body)
+	return h.Sum(nil)
+}
@@ -0,0 +1,58 @@
+package signature_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/util/signature"
+)
+
+var _ = Describe("signature", func() {
+	var (
+		secret = []byte("secret")
+		now    = time.Unix(1609459200, 0)
+		query  = "name=app.cpu&from=1609459200"
+		body   = []byte("foo;bar 1")
+	)
+
+	It("verifies signed requests", func() {
+		s, err := signature.Parse(signature.Sign("agent", secret, now, query, body))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(s.KeyID).To(Equal("agent"))
+		Expect(s.Timestamp).To(Equal(now))
+		Expect(s.Verify(secret, query, body, now.Add(time.Minute), 5*time.Minute)).To(Succeed())
+	})
+
+	It("rejects tampered requests", func() {
+		s, err := signature.Parse(signature.Sign("agent", secret, now, query, body))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(s.Verify([]byte("other"), query, body, now, 0)).To(MatchError(signature.ErrInvalid))
+		Expect(s.Verify(secret, "name=other.cpu", body, now, 0)).To(MatchError(signature.ErrInvalid))
+		Expect(s.Verify(secret, query, []byte("foo;baz 1"), now, 0)).To(MatchError(signature.ErrInvalid))
+	})
+
+	It("rejects expired signatures", func() {
+		s, err := signature.Parse(signature.Sign("agent", secret, now, query, body))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(s.Verify(secret, query, body, now.Add(time.Hour), 5*time.Minute)).To(MatchError(signature.ErrExpired))
+		Expect(s.Verify(secret, query, body, now.Add(-time.Hour), 5*time.Minute)).To(MatchError(signature.ErrExpired))
+		Expect(s.Verify(secret, query, body, now.Add(time.Hour), 0)).To(Succeed())
+	})
+
+	It("rejects malformed signatures", func() {
+		_, err := signature.Parse("")
+		Expect(err).To(MatchError(signature.ErrMissing))
+		for _, h := range []string{
+			"key=agent",
+			"key=agent,t=foo,sig=00",
+			"key=agent,t=1609459200,sig=zz",
+			"t=1609459200,sig=00",
+			"garbage",
+		} {
+			_, err = signature.Parse(h)
+			Expect(err).To(MatchError(signature.ErrMalformed), h)
+		}
+	})
+})